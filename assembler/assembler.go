@@ -0,0 +1,580 @@
+// Package assembler assembles the `repository` TrustRoot Custom Resource of a
+// TUF repository mirror, for programs embedding the TrustRoot Assembler
+// instead of running its command.
+//
+// Example usage:
+//
+//	a, err := assembler.New(
+//	    assembler.WithMirror("https://tuf-repo-cdn.sigstore.dev"),
+//	    assembler.WithHTTPClient(&http.Client{Timeout: 30 * time.Second}),
+//	    assembler.WithCompression(gzip.BestCompression),
+//	)
+//	if err != nil {
+//	    log.Fatalf("Error: %v", err)
+//	}
+//	trustRootYAML, err := a.TrustRoot("sigstore")
+package assembler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/pgzip"
+	"github.com/theupdateframework/go-tuf/client"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultMirror is the mirror used when WithMirror is not given, the Sigstore
+// public good instance.
+const DefaultMirror = "https://tuf-repo-cdn.sigstore.dev"
+
+// Assembler assembles the repository of one mirror. It keeps no state between
+// calls and can be used concurrently.
+type Assembler struct {
+	mirror      string
+	httpClient  *http.Client
+	compression int
+	concurrency int
+	modTime     *time.Time
+	layout      ArchiveLayout
+	symlinks    SymlinkPolicy
+	remote      client.RemoteStore
+	rootJSON    []byte
+}
+
+// archiveBlockSize is the size of the blocks of the archive compressed in
+// parallel. Smaller than the 1 MiB default of pgzip, so that repositories of
+// a few MiB are spread over the cores of CI runners.
+const archiveBlockSize = 256 << 10
+
+// Option configures an Assembler.
+type Option func(*Assembler)
+
+// WithMirror sets the URL of the TUF repository mirror, DefaultMirror by default.
+func WithMirror(mirror string) Option {
+	return func(a *Assembler) {
+		a.mirror = strings.TrimSuffix(mirror, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client fetching the metadata and targets,
+// http.DefaultClient by default.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(a *Assembler) {
+		a.httpClient = httpClient
+	}
+}
+
+// WithRemoteStore sets the store the metadata and targets are read from
+// instead of the mirror over HTTP, e.g. a client.FileRemoteStore of a
+// directory laid out like a mirror. The mirror then only names the repository
+// in errors and need not be an HTTP(S) URL.
+func WithRemoteStore(remote client.RemoteStore) Option {
+	return func(a *Assembler) {
+		a.remote = remote
+	}
+}
+
+// WithRoot sets the trusted root.json the TUF client is initialized with, the
+// latest N.root.json of the mirror by default. Pinning a root makes the client
+// verify the whole chain of root rotations since.
+func WithRoot(rootJSON []byte) Option {
+	return func(a *Assembler) {
+		a.rootJSON = rootJSON
+	}
+}
+
+// WithCompression sets the gzip level of the repository archive, from
+// gzip.HuffmanOnly to gzip.BestCompression; gzip.DefaultCompression by default.
+func WithCompression(level int) Option {
+	return func(a *Assembler) {
+		a.compression = level
+	}
+}
+
+// WithCompressionConcurrency sets the number of blocks of the archive
+// compressed in parallel, runtime.GOMAXPROCS(0) by default.
+func WithCompressionConcurrency(blocks int) Option {
+	return func(a *Assembler) {
+		a.concurrency = blocks
+	}
+}
+
+// WithReproducibleArchive makes the repository archive byte for byte
+// reproducible: every entry has modTime, no owner and 0644 or 0755 modes.
+func WithReproducibleArchive(modTime time.Time) Option {
+	return func(a *Assembler) {
+		a.modTime = &modTime
+	}
+}
+
+// WithArchiveLayout sets where the metadata and targets sit inside the
+// repository archive, DefaultArchiveLayout by default.
+func WithArchiveLayout(layout ArchiveLayout) Option {
+	return func(a *Assembler) {
+		a.layout = layout
+	}
+}
+
+// SymlinkPolicy is how symbolic links of an archived directory are written to
+// the archive.
+type SymlinkPolicy int
+
+const (
+	// SymlinksResolve archives the file a symbolic link points to under the
+	// name of the link, the default. Consumers such as the policy-controller
+	// drop link entries when unpacking. Links to directories and broken links
+	// fail the archive.
+	SymlinksResolve SymlinkPolicy = iota
+	// SymlinksForbid fails the archive on any symbolic link.
+	SymlinksForbid
+)
+
+// WithSymlinkPolicy sets how symbolic links in the repository directory are
+// archived, SymlinksResolve by default.
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(a *Assembler) {
+		a.symlinks = policy
+	}
+}
+
+// New returns an Assembler configured by opts.
+//
+// Parameters:
+//   - opts: The options, applied in order.
+//
+// Returns:
+//   - The Assembler.
+//   - An error if the mirror is not an HTTP(S) URL, without WithRemoteStore, or the compression level is invalid.
+func New(opts ...Option) (*Assembler, error) {
+	a := &Assembler{
+		mirror:      DefaultMirror,
+		httpClient:  http.DefaultClient,
+		compression: gzip.DefaultCompression,
+		concurrency: runtime.GOMAXPROCS(0),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.remote == nil && !strings.HasPrefix(a.mirror, "http://") && !strings.HasPrefix(a.mirror, "https://") {
+		return nil, fmt.Errorf("mirror %q is not an HTTP(S) URL", a.mirror)
+	}
+	if a.httpClient == nil {
+		return nil, fmt.Errorf("HTTP client is nil")
+	}
+	if a.compression < gzip.HuffmanOnly || a.compression > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", a.compression)
+	}
+	if a.concurrency < 1 {
+		return nil, fmt.Errorf("invalid compression concurrency %d", a.concurrency)
+	}
+	if err := a.layout.Validate(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Open downloads and verifies the latest metadata of the mirror with a go-tuf
+// client, initialized with the WithRoot root.json or else the latest
+// N.root.json of the mirror, without downloading any target.
+//
+// Returns:
+//   - The verified repository.
+//   - An error wrapping ErrMirrorUnreachable, ErrMetadataExpired or ErrVerification if the metadata could not be downloaded or verified.
+func (a *Assembler) Open() (*Repository, error) {
+	remote := a.remote
+	if remote == nil {
+		var err error
+		if remote, err = client.HTTPRemoteStore(a.mirror, &client.HTTPRemoteOptions{TargetsPath: "targets"}, a.httpClient); err != nil {
+			return nil, err
+		}
+	}
+	rootJSON := a.rootJSON
+	if rootJSON == nil {
+		var err error
+		if rootJSON, err = LatestRoot(remote); err != nil {
+			return nil, fmt.Errorf("could not get the latest root.json from %s: %w: %v", a.mirror, ErrMirrorUnreachable, err)
+		}
+	}
+	local := client.MemoryLocalStore()
+	c := client.NewClient(local, remote)
+	if err := c.Init(rootJSON); err != nil {
+		return nil, fmt.Errorf("could not initialize TUF client for %s: %w", a.mirror, ClassifyTUFError(err))
+	}
+	targets, err := c.Update()
+	if err != nil {
+		return nil, fmt.Errorf("could not update TUF metadata from %s: %w", a.mirror, ClassifyTUFError(err))
+	}
+	return &Repository{Mirror: a.mirror, Client: c, Local: local, Targets: targets}, nil
+}
+
+// Assemble downloads and verifies the latest metadata and every target of the
+// mirror, and writes the serialized repository into dir, see
+// Repository.Assemble.
+//
+// Targets delegated through succinct hash bins are not resolved; use the
+// command for such repositories.
+//
+// Parameters:
+//   - dir: The directory to assemble the repository in.
+//
+// Returns:
+//   - The verified root.json, to embed as the TrustRoot root.
+//   - An error wrapping ErrMirrorUnreachable, ErrMetadataExpired or ErrVerification if the repository could not be downloaded or verified.
+func (a *Assembler) Assemble(dir string) ([]byte, error) {
+	repository, err := a.Open()
+	if err != nil {
+		return nil, err
+	}
+	rootPath, _, err := repository.Assemble(dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(rootPath)
+}
+
+// Archive writes the repository in dir to w as a tar.gz archive, compressed
+// at the level of WithCompression and laid out by WithArchiveLayout.
+//
+// Parameters:
+//   - dir: The directory of the repository.
+//   - w: The writer of the archive.
+//
+// Returns:
+//   - An error if the directory could not be read or the archive written.
+func (a *Assembler) Archive(dir string, w io.Writer) error {
+	return compressFS(os.DirFS(dir), w, a.compression, a.concurrency, a.modTime, a.layout, a.symlinks)
+}
+
+// ArchiveLayout places the files of a repository inside its archive, since
+// consumers of serialized repositories expect slightly different layouts. The
+// zero value is DefaultArchiveLayout.
+type ArchiveLayout struct {
+	// Prefix is the directory of the repository inside the archive, e.g.
+	// "repository"; empty for the root of the archive.
+	Prefix string
+	// TargetsDir is the directory of the targets inside the repository,
+	// "targets" if empty.
+	TargetsDir string
+}
+
+// DefaultArchiveLayout puts the metadata at the root of the archive and the
+// targets in targets/.
+var DefaultArchiveLayout = ArchiveLayout{TargetsDir: "targets"}
+
+// Validate checks that the directories of the layout are relative slash
+// separated paths without "." or ".." elements.
+//
+// Returns:
+//   - error: nil if the layout is valid, otherwise an error naming the invalid directory.
+func (l ArchiveLayout) Validate() error {
+	if l.Prefix != "" && (!fs.ValidPath(l.Prefix) || l.Prefix == ".") {
+		return fmt.Errorf("invalid archive prefix %q", l.Prefix)
+	}
+	if l.TargetsDir != "" && (!fs.ValidPath(l.TargetsDir) || l.TargetsDir == ".") {
+		return fmt.Errorf("invalid archive targets directory %q", l.TargetsDir)
+	}
+	return nil
+}
+
+// Targets returns the targets directory of the layout, the `targets` field of
+// a TrustRoot repository.
+func (l ArchiveLayout) Targets() string {
+	if l.TargetsDir == "" {
+		return "targets"
+	}
+	return l.TargetsDir
+}
+
+// archiveName returns the path in the archive of the file at name in the
+// repository, whose targets are in targets/.
+func (l ArchiveLayout) archiveName(name string) string {
+	if name == "targets" {
+		name = l.Targets()
+	} else if rest, ok := strings.CutPrefix(name, "targets/"); ok {
+		name = path.Join(l.Targets(), rest)
+	}
+	if l.Prefix != "" {
+		name = path.Join(l.Prefix, name)
+	}
+	return name
+}
+
+// CompressFS writes the files and directories of fsys to w as a tar.gz
+// archive, with paths relative to the root of fsys, compressing blocks of the
+// archive on all cores. Entries are owned by uid and gid 0 without user and
+// group names, with 0644 or 0755 modes and without PAX records or extended
+// attributes, so the archive unpacks the same whichever runner built it,
+// does not leak its users and only uses PAX headers for long names.
+// In-memory filesystems such as testing/fstest.MapFS and embed.FS can be
+// archived without touching disk.
+//
+// Parameters:
+//   - fsys: The filesystem to archive.
+//   - w: The writer of the archive.
+//
+// Returns:
+//   - An error if fsys could not be walked or the archive written.
+func CompressFS(fsys fs.FS, w io.Writer) error {
+	return compressFS(fsys, w, gzip.DefaultCompression, runtime.GOMAXPROCS(0), nil, DefaultArchiveLayout, SymlinksResolve)
+}
+
+// CompressFSReproducible is CompressFS writing the same bytes for the same
+// file paths and contents, whatever their times: every entry has modTime.
+//
+// Parameters:
+//   - fsys: The filesystem to archive.
+//   - w: The writer of the archive.
+//   - modTime: The modification time of every entry, e.g. SOURCE_DATE_EPOCH.
+//
+// Returns:
+//   - An error if fsys could not be walked or the archive written.
+func CompressFSReproducible(fsys fs.FS, w io.Writer, modTime time.Time) error {
+	return compressFS(fsys, w, gzip.DefaultCompression, runtime.GOMAXPROCS(0), &modTime, DefaultArchiveLayout, SymlinksResolve)
+}
+
+// CompressFSLayout is CompressFS placing the files of fsys, a repository with
+// its targets in targets/, in the archive by layout. Like
+// CompressFSReproducible, the headers are normalized to modTime if it is not
+// nil.
+//
+// Parameters:
+//   - fsys: The filesystem of the repository.
+//   - w: The writer of the archive.
+//   - layout: Where the metadata and targets sit in the archive.
+//   - modTime: The modification time of every entry, nil to keep the times of fsys.
+//
+// Returns:
+//   - An error if the layout is invalid, fsys could not be walked or the archive written.
+func CompressFSLayout(fsys fs.FS, w io.Writer, layout ArchiveLayout, modTime *time.Time) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	return compressFS(fsys, w, gzip.DefaultCompression, runtime.GOMAXPROCS(0), modTime, layout, SymlinksResolve)
+}
+
+// compressFS is CompressFS at the given gzip level, compressing up to
+// concurrency blocks in parallel, normalizing the headers to modTime if it
+// is not nil, placing the files by layout and archiving symbolic links by
+// symlinks.
+func compressFS(fsys fs.FS, w io.Writer, level, concurrency int, modTime *time.Time, layout ArchiveLayout, symlinks SymlinkPolicy) error {
+	gw, err := pgzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	if err := gw.SetConcurrency(archiveBlockSize, concurrency); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
+	// Directories of the layout missing from fsys, e.g. the prefix, are
+	// added with the times of the root of fsys
+	written := map[string]bool{}
+	var writeParents func(name string, root fs.FileInfo) error
+	writeParents = func(name string, root fs.FileInfo) error {
+		parent := path.Dir(name)
+		if parent == "." || written[parent] {
+			return nil
+		}
+		if err := writeParents(parent, root); err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(root, "")
+		if err != nil {
+			return err
+		}
+		header.Name = parent
+		normalizeHeader(header, modTime)
+		written[parent] = true
+		return tw.WriteHeader(header)
+	}
+	root, err := fs.Stat(fsys, ".")
+	if err != nil {
+		return err
+	}
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if info, err = resolveSymlink(fsys, name, symlinks); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = layout.archiveName(name)
+		if err := writeParents(header.Name, root); err != nil {
+			return err
+		}
+		normalizeHeader(header, modTime)
+		written[header.Name] = true
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// resolveSymlink returns the file info of the file the symbolic link name of
+// fsys points to, archived in its place, see SymlinkPolicy.
+func resolveSymlink(fsys fs.FS, name string, symlinks SymlinkPolicy) (fs.FileInfo, error) {
+	if symlinks == SymlinksForbid {
+		return nil, fmt.Errorf("%s is a symbolic link, which the archive forbids", name)
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("%s is a broken symbolic link: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is a symbolic link to a directory or special file, only links to files are resolved", name)
+	}
+	return info, nil
+}
+
+// normalizeHeader strips the owner, permissions, PAX records and extended
+// attributes of header, see CompressFS, and its time if modTime is not nil,
+// see CompressFSReproducible.
+func normalizeHeader(header *tar.Header, modTime *time.Time) {
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	if header.Typeflag == tar.TypeDir {
+		header.Mode = 0o755
+	} else {
+		header.Mode = 0o644
+	}
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.PAXRecords = nil
+	// Deprecated, but still copied from tar.Header file infos
+	header.Xattrs = nil
+	if modTime != nil {
+		header.ModTime = modTime.UTC().Truncate(time.Second)
+	}
+}
+
+// TrustRoot assembles the repository of the mirror in a temporary directory
+// and renders it as the `repository` TrustRoot Custom Resource YAML.
+//
+// Parameters:
+//   - name: The metadata.name of the TrustRoot.
+//
+// Returns:
+//   - The TrustRoot YAML.
+//   - An error if the repository could not be assembled or archived.
+func (a *Assembler) TrustRoot(name string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "trustroot-assembler-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	rootJSON, err := a.Assemble(dir)
+	if err != nil {
+		return nil, err
+	}
+	var archive strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &archive)
+	if err := a.Archive(dir, encoder); err != nil {
+		return nil, fmt.Errorf("could not compress repository directory: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return trustRootYAML(name, rootJSON, a.layout, archive.String())
+}
+
+// TrustedRoot assembles the repository of the mirror in a temporary directory
+// and returns its verified trusted_root.json target. Package
+// cmd/sigstorego loads it into a sigstore-go root.TrustedMaterial, so this
+// package does not depend on sigstore-go.
+//
+// Returns:
+//   - The content of the trusted_root.json target.
+//   - An error wrapping ErrVerification if the repository has no trusted_root.json target, or the error of Assemble.
+func (a *Assembler) TrustedRoot() ([]byte, error) {
+	dir, err := os.MkdirTemp("", "trustroot-assembler-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := a.Assemble(dir); err != nil {
+		return nil, err
+	}
+	trustedRoot, err := os.ReadFile(filepath.Join(dir, "targets", "trusted_root.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: the repository of %s has no trusted_root.json target", ErrVerification, a.mirror)
+	}
+	return trustedRoot, err
+}
+
+// trustRootYAML renders the `repository` TrustRoot name embedding rootJSON
+// and the base64 archive laid out by layout, with the `targets` field only
+// for targets directories other than the default of the policy-controller.
+func trustRootYAML(name string, rootJSON []byte, layout ArchiveLayout, archive string) ([]byte, error) {
+	scalar := func(value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	}
+	literal := func(value string) *yaml.Node {
+		node := scalar(value)
+		node.Style = yaml.LiteralStyle
+		return node
+	}
+	mapping := func(content ...*yaml.Node) *yaml.Node {
+		return &yaml.Node{Kind: yaml.MappingNode, Content: content}
+	}
+	repository := mapping(scalar("root"), literal(base64.StdEncoding.EncodeToString(rootJSON)))
+	if layout.Targets() != "targets" {
+		repository.Content = append(repository.Content, scalar("targets"), scalar(layout.Targets()))
+	}
+	repository.Content = append(repository.Content, scalar("mirrorFS"), literal(archive))
+	trustRoot := mapping(
+		scalar("apiVersion"), scalar("policy.sigstore.dev/v1alpha1"),
+		scalar("kind"), scalar("TrustRoot"),
+		scalar("metadata"), mapping(scalar("name"), scalar(name)),
+		scalar("spec"), mapping(scalar("repository"), repository),
+	)
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(trustRoot); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
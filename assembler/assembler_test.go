@@ -0,0 +1,369 @@
+package assembler
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/client"
+	"gopkg.in/yaml.v3"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{"defaults", nil, false},
+		{"all options", []Option{WithMirror("https://tuf.example.com/"), WithHTTPClient(&http.Client{}), WithCompression(gzip.BestSpeed)}, false},
+		{"non HTTP mirror", []Option{WithMirror("/srv/tuf")}, true},
+		{"remote store", []Option{WithMirror("/srv/tuf"), WithRemoteStore(remoteStore{}), WithRoot([]byte("{}"))}, false},
+		{"nil HTTP client", []Option{WithHTTPClient(nil)}, true},
+		{"invalid compression", []Option{WithCompression(10)}, true},
+		{"invalid concurrency", []Option{WithCompressionConcurrency(0)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTrustRoot(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	a, err := New(WithMirror(server.URL), WithHTTPClient(server.Client()), WithCompression(gzip.BestCompression))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	got, err := a.TrustRoot("test")
+	if err != nil {
+		t.Fatalf("TrustRoot() error = %v", err)
+	}
+	var trustRoot struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+	if err := yaml.Unmarshal(got, &trustRoot); err != nil || trustRoot.Metadata.Name != "test" {
+		t.Fatalf("TrustRoot() = %q, not a YAML TrustRoot named test: %v", got, err)
+	}
+	match := regexp.MustCompile(`(?s)name: test\n.*root: \|-\n      (\S+)\n    mirrorFS: \|-\n      (\S+)\n`).FindSubmatch(got)
+	if match == nil {
+		t.Fatalf("TrustRoot() = %q, not a repository TrustRoot", got)
+	}
+	archive, err := base64.StdEncoding.DecodeString(string(match[2]))
+	if err != nil {
+		t.Fatalf("mirrorFS is not base64: %v", err)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("mirrorFS is not gzip: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("mirrorFS is not a tar archive: %v", err)
+		}
+		content, _ := io.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+	for _, name := range []string{"1.root.json", "1.snapshot.json", "1.targets.json", "timestamp.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("mirrorFS has no %s", name)
+		}
+	}
+	for _, target := range mockmirror.Targets {
+		if files["targets/"+target] == "" {
+			t.Errorf("mirrorFS has no targets/%s", target)
+		}
+	}
+}
+
+func TestOpenRemoteStore(t *testing.T) {
+	remote, err := client.NewFileRemoteStore(mockmirror.FS(), "targets")
+	if err != nil {
+		t.Fatalf("NewFileRemoteStore() error = %v", err)
+	}
+	a, err := New(WithMirror("mockmirror"), WithRemoteStore(remote), WithRoot(mockmirror.RootJSON()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	repository, err := a.Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if len(repository.Targets) != len(mockmirror.Targets) {
+		t.Errorf("Open() = %d targets, want %d", len(repository.Targets), len(mockmirror.Targets))
+	}
+	dir := t.TempDir()
+	rootPath, skipped, err := repository.Assemble(dir, nil)
+	if err != nil {
+		t.Fatalf("Assemble() error = %v", err)
+	}
+	if rootPath != filepath.Join(dir, "1.root.json") || len(skipped) != 0 {
+		t.Errorf("Assemble() = %s, %v skipped, want %s", rootPath, skipped, filepath.Join(dir, "1.root.json"))
+	}
+}
+
+func TestTrustedRoot(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	a, err := New(WithMirror(server.URL), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	got, err := a.TrustedRoot()
+	if err != nil {
+		t.Fatalf("TrustedRoot() error = %v", err)
+	}
+	var trustedRoot struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(got, &trustedRoot); err != nil || !strings.HasPrefix(trustedRoot.MediaType, "application/vnd.dev.sigstore.trustedroot") {
+		t.Errorf("TrustedRoot() = %.80q, want a trusted_root.json document", got)
+	}
+}
+
+func TestCompressFS(t *testing.T) {
+	builder := &tar.Header{Uid: 1001, Gid: 1001, Uname: "runner", Gname: "runner", AccessTime: time.Now(),
+		PAXRecords: map[string]string{"SCHILY.xattr.user.builder": "runner-42"}}
+	fsys := fstest.MapFS{
+		"1.root.json":       {Data: []byte(`{"signed":{}}`), Mode: 0o600, Sys: builder},
+		"targets":           {Mode: fs.ModeDir | 0o700, Sys: builder},
+		"targets/rekor.pub": {Data: []byte("rekor public key"), Mode: 0o755},
+	}
+	var buf bytes.Buffer
+	if err := CompressFS(fsys, &buf); err != nil {
+		t.Fatalf("CompressFS() error = %v", err)
+	}
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("CompressFS() output is not gzip: %v", err)
+	}
+	var got []string
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("CompressFS() output is not a tar archive: %v", err)
+		}
+		got = append(got, header.Name)
+		wantMode := int64(0o644)
+		if header.Typeflag == tar.TypeDir {
+			wantMode = 0o755
+		}
+		if header.Uid != 0 || header.Gid != 0 || header.Uname != "" || header.Gname != "" || header.Mode != wantMode {
+			t.Errorf("%s: owner %d:%d (%q:%q), mode %o, want 0:0 without names, mode %o", header.Name, header.Uid, header.Gid, header.Uname, header.Gname, header.Mode, wantMode)
+		}
+		if header.Format != tar.FormatUSTAR || len(header.PAXRecords) > 0 {
+			t.Errorf("%s: format %v with PAX records %v, want plain USTAR", header.Name, header.Format, header.PAXRecords)
+		}
+	}
+	want := []string{"1.root.json", "targets", "targets/rekor.pub"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("CompressFS() archived %v, want %v", got, want)
+	}
+}
+
+func TestCompressFSLayout(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1.root.json":       {Data: []byte(`{"signed":{}}`)},
+		"targets/rekor.pub": {Data: []byte("rekor public key")},
+	}
+	tests := []struct {
+		name    string
+		layout  ArchiveLayout
+		want    []string
+		wantErr bool
+	}{
+		{"default", DefaultArchiveLayout, []string{"1.root.json", "targets", "targets/rekor.pub"}, false},
+		{"zero value", ArchiveLayout{}, []string{"1.root.json", "targets", "targets/rekor.pub"}, false},
+		{"prefix", ArchiveLayout{Prefix: "repository"}, []string{"repository", "repository/1.root.json", "repository/targets", "repository/targets/rekor.pub"}, false},
+		{"nested prefix and targets", ArchiveLayout{Prefix: "a/b", TargetsDir: "files/targets"}, []string{"a", "a/b", "a/b/1.root.json", "a/b/files", "a/b/files/targets", "a/b/files/targets/rekor.pub"}, false},
+		{"escaping prefix", ArchiveLayout{Prefix: "../repository"}, nil, true},
+		{"absolute targets", ArchiveLayout{TargetsDir: "/targets"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := CompressFSLayout(fsys, &buf, tt.layout, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompressFSLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			gr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("CompressFSLayout() output is not gzip: %v", err)
+			}
+			var got []string
+			tr := tar.NewReader(gr)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("CompressFSLayout() output is not a tar archive: %v", err)
+				}
+				got = append(got, header.Name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("CompressFSLayout() archived %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressFSReproducible(t *testing.T) {
+	modTime := time.Unix(1700000000, 0)
+	archive := func(fileTime time.Time, mode fs.FileMode) []byte {
+		fsys := fstest.MapFS{
+			"1.root.json":       {Data: []byte(`{"signed":{}}`), ModTime: fileTime, Mode: mode},
+			"targets/rekor.pub": {Data: []byte("rekor public key"), ModTime: fileTime, Mode: 0o600},
+		}
+		var buf bytes.Buffer
+		if err := CompressFSReproducible(fsys, &buf, modTime); err != nil {
+			t.Fatalf("CompressFSReproducible() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+	first := archive(time.Now(), 0o644)
+	second := archive(time.Now().Add(time.Hour), 0o600)
+	if !bytes.Equal(first, second) {
+		t.Errorf("CompressFSReproducible() archives differ for the same contents")
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(first))
+	if err != nil {
+		t.Fatalf("CompressFSReproducible() output is not gzip: %v", err)
+	}
+	header, err := tar.NewReader(gr).Next()
+	if err != nil {
+		t.Fatalf("CompressFSReproducible() output is not a tar archive: %v", err)
+	}
+	if !header.ModTime.Equal(modTime) || header.Mode != 0o644 {
+		t.Errorf("CompressFSReproducible() header = %v %o, want %v 644", header.ModTime, header.Mode, modTime)
+	}
+}
+
+func TestArchiveSymlinks(t *testing.T) {
+	repository := func(t *testing.T, link string) string {
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "targets"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "shared-rekor.pub"), []byte("rekor public key"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(link, filepath.Join(dir, "targets", "rekor.pub")); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	tests := []struct {
+		name    string
+		link    string
+		policy  SymlinkPolicy
+		wantErr string
+	}{
+		{name: "resolved", link: "../shared-rekor.pub", policy: SymlinksResolve},
+		{name: "forbidden", link: "../shared-rekor.pub", policy: SymlinksForbid, wantErr: "forbids"},
+		{name: "broken", link: "../missing.pub", policy: SymlinksResolve, wantErr: "broken symbolic link"},
+		{name: "directory", link: "..", policy: SymlinksResolve, wantErr: "only links to files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(WithSymlinkPolicy(tt.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			err = a.Archive(repository(t, tt.link), &buf)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Archive() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Archive() error = %v", err)
+			}
+			gr, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(gr)
+			for {
+				header, err := tr.Next()
+				if err != nil {
+					t.Fatalf("archive has no targets/rekor.pub: %v", err)
+				}
+				if header.Name != "targets/rekor.pub" {
+					continue
+				}
+				content, _ := io.ReadAll(tr)
+				if header.Typeflag != tar.TypeReg || string(content) != "rekor public key" {
+					t.Errorf("targets/rekor.pub = type %c %q, want the regular file it links to", header.Typeflag, content)
+				}
+				return
+			}
+		})
+	}
+}
+
+func BenchmarkCompressFS(b *testing.B) {
+	// Hundreds of targets, as in large private repositories
+	fsys := fstest.MapFS{}
+	for i := 0; i < 500; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("target %d ", i)), 2000)
+		fsys[fmt.Sprintf("targets/%d.pem", i)] = &fstest.MapFile{Data: content}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := CompressFS(fsys, io.Discard); err != nil {
+			b.Fatalf("CompressFS() error = %v", err)
+		}
+	}
+}
+
+// remoteStore is a client.RemoteStore without any file.
+type remoteStore struct{}
+
+func (remoteStore) GetMeta(name string) (io.ReadCloser, int64, error) {
+	return nil, 0, client.ErrNotFound{File: name}
+}
+
+func (remoteStore) GetTarget(name string) (io.ReadCloser, int64, error) {
+	return nil, 0, client.ErrNotFound{File: name}
+}
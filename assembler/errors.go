@@ -0,0 +1,62 @@
+package assembler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/verify"
+)
+
+// Failure classes of the assembly. Errors returned by this package and the
+// command wrap one of them, test with errors.Is.
+var (
+	// ErrMirrorUnreachable is a mirror that could not be reached or did not
+	// serve a metadata file or target.
+	ErrMirrorUnreachable = errors.New("mirror unreachable")
+	// ErrMetadataExpired is metadata that expired or expires too soon.
+	ErrMetadataExpired = errors.New("metadata expired")
+	// ErrVerification is metadata or a target that does not verify against
+	// the trusted root.
+	ErrVerification = errors.New("verification failed")
+	// ErrOversizedOutput is an output exceeding the size limit of its
+	// destination, e.g. a Kubernetes object.
+	ErrOversizedOutput = errors.New("output too large")
+)
+
+// ClassifyTUFError wraps an error of the go-tuf client in its failure class:
+// ErrMetadataExpired for expired metadata, ErrMirrorUnreachable for failed
+// downloads and ErrVerification otherwise.
+//
+// Parameters:
+//   - err: The error returned by the go-tuf client, may be nil.
+//
+// Returns:
+//   - nil if err is nil, otherwise err wrapped in its failure class.
+func ClassifyTUFError(err error) error {
+	if err == nil {
+		return nil
+	}
+	// go-tuf does not unwrap its decoding errors
+	cause := err
+	var decodeFailed client.ErrDecodeFailed
+	if errors.As(err, &decodeFailed) {
+		cause = decodeFailed.Err
+	}
+	var (
+		expired        verify.ErrExpired
+		downloadFailed client.ErrDownloadFailed
+		notFound       client.ErrNotFound
+		missing        client.ErrMissingRemoteMetadata
+		netErr         net.Error
+	)
+	class := ErrVerification
+	switch {
+	case errors.As(cause, &expired):
+		class = ErrMetadataExpired
+	case errors.As(err, &downloadFailed), errors.As(err, &notFound), errors.As(err, &missing), errors.As(err, &netErr):
+		class = ErrMirrorUnreachable
+	}
+	return fmt.Errorf("%w: %w", class, err)
+}
@@ -0,0 +1,48 @@
+package assembler
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/verify"
+)
+
+func TestClassifyTUFError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"expired", client.ErrDecodeFailed{File: "timestamp.json", Err: verify.ErrExpired{Expired: time.Unix(0, 0)}}, ErrMetadataExpired},
+		{"not found", client.ErrNotFound{File: "1.root.json"}, ErrMirrorUnreachable},
+		{"download failed", client.ErrDownloadFailed{File: "rekor.pub", Err: errors.New("connection reset")}, ErrMirrorUnreachable},
+		{"network", &url.Error{Op: "Get", URL: "https://tuf.example.com", Err: &timeoutError{}}, ErrMirrorUnreachable},
+		{"bad signature", client.ErrDecodeFailed{File: "root.json", Err: verify.ErrInvalid}, ErrVerification},
+		{"wrong size", client.ErrWrongSize{File: "rekor.pub", Actual: 1, Expected: 2}, ErrVerification},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyTUFError(tt.err)
+			if !errors.Is(got, tt.want) {
+				t.Errorf("ClassifyTUFError() = %v, want %v", got, tt.want)
+			}
+			if !errors.Is(got, tt.err) {
+				t.Errorf("ClassifyTUFError() = %v does not wrap %v", got, tt.err)
+			}
+		})
+	}
+	if ClassifyTUFError(nil) != nil {
+		t.Errorf("ClassifyTUFError(nil) != nil")
+	}
+}
+
+// timeoutError is a net.Error.
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
@@ -0,0 +1,313 @@
+package assembler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// Repository is a TUF repository whose metadata has been updated and verified
+// by a go-tuf client, see Assembler.Open.
+type Repository struct {
+	// Mirror names the repository in errors.
+	Mirror string
+	// Client is the go-tuf client holding the verified metadata, downloading
+	// and verifying targets.
+	Client *client.Client
+	// Local stores the verified metadata.
+	Local client.LocalStore
+	// Targets are the verified targets of the top-level targets.json.
+	Targets data.TargetFiles
+}
+
+// Assemble downloads and verifies every target of r into dir/targets, along
+// with their <hash>.<name> copies for repositories with consistent snapshots,
+// and writes the verified top-level metadata into dir, see WriteMetadata.
+//
+// Parameters:
+//   - dir: The directory to assemble the repository in.
+//   - skip: Reports whether a target whose download failed with err is left out of the repository instead of failing it, nil to skip none.
+//
+// Returns:
+//   - The path of the N.root.json written into dir.
+//   - The names of the skipped targets.
+//   - An error if a target could not be downloaded or verified, wrapping ErrVerification for unsafe target names.
+func (r *Repository) Assemble(dir string, skip func(name string, err error) bool) (string, map[string]bool, error) {
+	meta, err := r.Local.GetMeta()
+	if err != nil {
+		return "", nil, err
+	}
+	consistent, err := ConsistentSnapshot(meta["root.json"])
+	if err != nil {
+		return "", nil, fmt.Errorf("could not read root.json of %s: %v", r.Mirror, err)
+	}
+	targetsDir := filepath.Join(dir, "targets")
+	skipped := map[string]bool{}
+	for name, targetMeta := range r.Targets {
+		if err := r.DownloadTarget(name, targetsDir); err != nil {
+			if skip == nil || !skip(name, err) {
+				return "", nil, err
+			}
+			skipped[name] = true
+			continue
+		}
+		// Clients of consistent snapshot repositories, like the
+		// policy-controller, fetch targets as <hash>.<name>
+		if consistent {
+			if err := HashedTargetCopies(filepath.Join(targetsDir, filepath.FromSlash(name)), targetsDir, name, targetMeta); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	rootPath, err := r.WriteMetadata(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	return rootPath, skipped, nil
+}
+
+// DownloadTarget downloads and verifies the target name into dir, once
+// CheckTargetName accepted its name.
+//
+// Parameters:
+//   - name: The name of the target.
+//   - dir: The directory the target is written below, at its slash separated name.
+//
+// Returns:
+//   - An error if the name is unsafe or the target could not be downloaded or verified.
+func (r *Repository) DownloadTarget(name, dir string) error {
+	if err := CheckTargetName(name); err != nil {
+		return err
+	}
+	dst := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := r.Client.Download(name, fileDestination{file}); err != nil {
+		return fmt.Errorf("could not download target %s from %s: %w", name, r.Mirror, ClassifyTUFError(err))
+	}
+	return file.Close()
+}
+
+// WriteMetadata writes the verified top-level metadata of r into dir using
+// the file names clients of the repository fetch: N.root.json,
+// N.snapshot.json, N.targets.json and timestamp.json for repositories with
+// consistent snapshots, and N.root.json, snapshot.json, targets.json and
+// timestamp.json otherwise.
+//
+// Parameters:
+//   - dir: The directory to write the metadata in.
+//
+// Returns:
+//   - The path of the N.root.json written into dir.
+//   - An error if the metadata could not be read or written.
+func (r *Repository) WriteMetadata(dir string) (string, error) {
+	meta, err := r.Local.GetMeta()
+	if err != nil {
+		return "", err
+	}
+	consistent, err := ConsistentSnapshot(meta["root.json"])
+	if err != nil {
+		return "", fmt.Errorf("could not read root.json of %s: %v", r.Mirror, err)
+	}
+	rootPath := ""
+	for _, role := range []string{"root.json", "snapshot.json", "targets.json", "timestamp.json"} {
+		content, ok := meta[role]
+		if !ok {
+			return "", fmt.Errorf("verified metadata of %s has no %s", r.Mirror, role)
+		}
+		name := role
+		if role == "root.json" || (consistent && role != "timestamp.json") {
+			version, err := MetadataVersion(content)
+			if err != nil {
+				return "", fmt.Errorf("could not read version of %s: %v", role, err)
+			}
+			name = fmt.Sprintf("%d.%s", version, role)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return "", err
+		}
+		if role == "root.json" {
+			rootPath = path
+		}
+	}
+	return rootPath, nil
+}
+
+// CheckTargetName rejects target names signed into metadata that are not
+// relative slash separated paths below the targets directory, like
+// "../root.json", "/etc/passwd" or "..\\root.json", before they are joined
+// into a local path.
+//
+// Parameters:
+//   - name: The name of the target.
+//
+// Returns:
+//   - error: nil if the name is safe, otherwise an error wrapping ErrVerification.
+func CheckTargetName(name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, "\\") {
+		return fmt.Errorf("%w: invalid target name %q", ErrVerification, name)
+	}
+	return nil
+}
+
+// HashedTargetCopies copies the verified target name at src into targetsDir
+// once per hash of meta, as <dir>/<hash>.<base>: the names clients of
+// consistent snapshot repositories fetch targets by.
+//
+// Parameters:
+//   - src: The path of the target.
+//   - targetsDir: The targets directory of the repository.
+//   - name: The name of the target.
+//   - meta: The verified metadata of the target.
+//
+// Returns:
+//   - An error if a copy could not be written.
+func HashedTargetCopies(src, targetsDir, name string, meta data.TargetFileMeta) error {
+	dir, base := path.Split(name)
+	for _, hash := range meta.Hashes {
+		dst := filepath.Join(targetsDir, filepath.FromSlash(dir), hash.String()+"."+base)
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("could not copy target %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, creating the parent directories of dst.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// MaxRootRotations is the highest root version LatestRoot probes, the
+// default number of root rotations go-tuf clients follow.
+const MaxRootRotations = 256
+
+// LatestRoot returns the latest N.root.json of remote, probing versions from 1
+// without relying on directory listings. Root versions are contiguous, so
+// versions are probed by doubling until one is not found and then bisected:
+// about 2*log2(N) requests instead of N. Repositories without consistent
+// snapshots may only publish a plain root.json, which is returned when there
+// is no 1.root.json. Repositories with more than MaxRootRotations root
+// versions are rejected rather than probed without end.
+//
+// Parameters:
+//   - remote: The store of the repository.
+//
+// Returns:
+//   - The latest root.json.
+//   - An error if no root.json could be fetched, a client.ErrNotFound if there is none, or wrapping ErrVerification if version MaxRootRotations+1 exists.
+func LatestRoot(remote client.RemoteStore) ([]byte, error) {
+	var latest []byte
+	found, missing := 0, 0
+	probe := func(version int) error {
+		content, err := readMeta(remote, fmt.Sprintf("%d.root.json", version))
+		var notFound client.ErrNotFound
+		if errors.As(err, &notFound) {
+			missing = version
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found, latest = version, content
+		return nil
+	}
+	// A mirror answering every request would be probed forever
+	for version := 1; missing == 0; version = min(2*version, MaxRootRotations+1) {
+		if err := probe(version); err != nil {
+			return nil, err
+		}
+		if found > MaxRootRotations {
+			return nil, fmt.Errorf("%w: more than %d root versions, the mirror serves every N.root.json", ErrVerification, MaxRootRotations)
+		}
+	}
+	if found == 0 {
+		return readMeta(remote, "root.json")
+	}
+	for missing-found > 1 {
+		if err := probe(found + (missing-found)/2); err != nil {
+			return nil, err
+		}
+	}
+	return latest, nil
+}
+
+// readMeta reads the metadata file name from remote.
+func readMeta(remote client.RemoteStore, name string) ([]byte, error) {
+	rc, _, err := remote.GetMeta(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// fileDestination is a client.Destination writing to a file.
+type fileDestination struct {
+	*os.File
+}
+
+// Delete removes the partially written file after a failed download.
+func (f fileDestination) Delete() error {
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// ConsistentSnapshot reports whether a root.json enables consistent
+// snapshots, in which case snapshot.json, targets.json and delegated targets
+// metadata are published as N.<role>.json and targets as <hash>.<name>.
+func ConsistentSnapshot(rootJSON []byte) (bool, error) {
+	var root struct {
+		Signed struct {
+			ConsistentSnapshot bool `json:"consistent_snapshot"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(rootJSON, &root); err != nil {
+		return false, err
+	}
+	return root.Signed.ConsistentSnapshot, nil
+}
+
+// MetadataVersion returns the signed version of a TUF metadata document.
+func MetadataVersion(metadata []byte) (int64, error) {
+	var signed struct {
+		Signed struct {
+			Version int64 `json:"version"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(metadata, &signed); err != nil {
+		return 0, err
+	}
+	return signed.Signed.Version, nil
+}
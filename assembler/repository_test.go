@@ -0,0 +1,49 @@
+package assembler
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckTargetName(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{name: "plain name", target: "rekor.pub"},
+		{name: "nested name", target: "tsa/tsa_root.crt.pem"},
+		{name: "parent directory", target: "../root.json", wantErr: true},
+		{name: "nested parent directory", target: "keys/../../root.json", wantErr: true},
+		{name: "absolute path", target: "/etc/passwd", wantErr: true},
+		{name: "backslash separator", target: `..\root.json`, wantErr: true},
+		{name: "current directory", target: ".", wantErr: true},
+		{name: "empty name", target: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckTargetName(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckTargetName(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrVerification) {
+				t.Errorf("CheckTargetName(%q) error = %v, want %v", tt.target, err, ErrVerification)
+			}
+		})
+	}
+}
+
+func TestDownloadTargetEscapingName(t *testing.T) {
+	dir := t.TempDir()
+	targetsDir := filepath.Join(dir, "targets")
+	// The name is rejected before the client is used
+	err := (&Repository{Mirror: "https://tuf.example.com"}).DownloadTarget("../root.json", targetsDir)
+	if !errors.Is(err, ErrVerification) {
+		t.Fatalf("DownloadTarget() error = %v, want %v", err, ErrVerification)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("DownloadTarget() wrote %v, want nothing", entries)
+	}
+}
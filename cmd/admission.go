@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+// admissionReviewVersion is the API version of the AdmissionReview objects
+// exchanged with the Kubernetes API server.
+const admissionReviewVersion = "admission.k8s.io/v1"
+
+// maxAdmissionReviewSize bounds AdmissionReview bodies: TrustRoots are
+// limited to 1.5 MiB, and an update carries the object and the old object.
+const maxAdmissionReviewSize = 4 * maxObjectSize
+
+// admissionReview is an AdmissionReview, with the request sent by the API
+// server or the response of the webhook.
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID       string          `json:"uid"`
+	Operation string          `json:"operation"`
+	Object    json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Result  *admissionStatus `json:"status,omitempty"`
+}
+
+type admissionStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// trustRootObject is the part of a policy.sigstore.dev TrustRoot checked by
+// ValidateTrustRoot and read by convert. Byte fields are base64 encoded in
+// the object.
+type trustRootObject struct {
+	Metadata struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Repository *struct {
+			Root     []byte `json:"root"`
+			MirrorFS []byte `json:"mirrorFS"`
+			Targets  string `json:"targets"`
+		} `json:"repository"`
+		Remote *struct {
+			Mirror string `json:"mirror"`
+			Root   []byte `json:"root"`
+		} `json:"remote"`
+		SigstoreKeys *struct {
+			CertificateAuthorities []trustRootAuthority `json:"certificateAuthorities"`
+			TLogs                  []trustRootLog       `json:"tLogs"`
+			CTLogs                 []trustRootLog       `json:"ctLogs"`
+			TimestampAuthorities   []trustRootAuthority `json:"timestampAuthorities"`
+		} `json:"sigstoreKeys"`
+	} `json:"spec"`
+}
+
+type trustRootAuthority struct {
+	Subject struct {
+		Organization string `json:"organization"`
+		CommonName   string `json:"commonName"`
+	} `json:"subject"`
+	URI       string `json:"uri"`
+	CertChain []byte `json:"certChain"`
+}
+
+type trustRootLog struct {
+	BaseURL       string `json:"baseURL"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	PublicKey     []byte `json:"publicKey"`
+	LogID         string `json:"logID"`
+}
+
+// ValidateTrustRoot decodes and verifies a TrustRoot the way the
+// policy-controller would load it, whatever generated it:
+//
+//   - repository: the mirrorFS archive is loaded with CheckPolicyControllerArchive from the root, so unverifiable or expired metadata and targets are rejected,
+//   - remote: the root must be signed by its own root keys and not expired,
+//   - sigstoreKeys: every certificate chain must be valid at now and every log key a supported public key.
+//
+// Parameters:
+//   - object: The JSON TrustRoot object.
+//   - archivePrefix: The directory of the repository inside mirrorFS archives, empty for their root.
+//   - now: The time the trust material must be valid at.
+//
+// Returns:
+//   - error: nil if the TrustRoot loads, otherwise an error describing why it would not, wrapping assembler.ErrVerification or assembler.ErrMetadataExpired.
+func ValidateTrustRoot(object []byte, archivePrefix string, now time.Time) error {
+	var trustRoot trustRootObject
+	if err := json.Unmarshal(object, &trustRoot); err != nil {
+		return fmt.Errorf("%w: malformed TrustRoot: %v", assembler.ErrVerification, err)
+	}
+	spec := trustRoot.Spec
+	switch {
+	case spec.Repository != nil:
+		if len(spec.Repository.Root) == 0 || len(spec.Repository.MirrorFS) == 0 {
+			return fmt.Errorf("%w: repository requires root and mirrorFS", assembler.ErrVerification)
+		}
+		layout := assembler.ArchiveLayout{Prefix: archivePrefix, TargetsDir: spec.Repository.Targets}
+		if err := layout.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", assembler.ErrVerification, err)
+		}
+		if err := CheckPolicyControllerArchive(bytes.NewReader(spec.Repository.MirrorFS), spec.Repository.Root, layout); err != nil {
+			// Files missing from the archive are not a mirror to retry
+			if !errors.Is(err, assembler.ErrMetadataExpired) && !errors.Is(err, assembler.ErrVerification) {
+				err = fmt.Errorf("%w: %w", assembler.ErrVerification, err)
+			}
+			return fmt.Errorf("repository: %w", err)
+		}
+	case spec.Remote != nil:
+		if len(spec.Remote.Root) == 0 {
+			return fmt.Errorf("%w: remote requires root", assembler.ErrVerification)
+		}
+		// Init checks the signatures of the root, not its expiry
+		if err := client.NewClient(client.MemoryLocalStore(), nil).Init(spec.Remote.Root); err != nil {
+			return fmt.Errorf("remote: root does not verify: %w", assembler.ClassifyTUFError(err))
+		}
+		if err := CheckExpiry(map[string][]byte{"root.json": spec.Remote.Root}, 0, now); err != nil {
+			return fmt.Errorf("remote: %w", err)
+		}
+	case spec.SigstoreKeys != nil:
+		keys := spec.SigstoreKeys
+		authorities := append(append([]trustRootAuthority(nil), keys.CertificateAuthorities...), keys.TimestampAuthorities...)
+		for _, authority := range authorities {
+			if _, err := NormalizeCertificateChain(authority.CertChain, now); err != nil {
+				return fmt.Errorf("%w: sigstoreKeys: authority %s: %v", assembler.ErrVerification, authority.URI, err)
+			}
+		}
+		for _, tlog := range append(append([]trustRootLog(nil), keys.TLogs...), keys.CTLogs...) {
+			if _, err := NormalizePublicKey(tlog.PublicKey); err != nil {
+				return fmt.Errorf("%w: sigstoreKeys: log %s: %v", assembler.ErrVerification, tlog.BaseURL, err)
+			}
+		}
+	default:
+		return fmt.Errorf("%w: TrustRoot has no repository, remote or sigstoreKeys", assembler.ErrVerification)
+	}
+	return nil
+}
+
+// AdmissionHandler returns the handler of the validating admission webhook:
+//
+//   - POST /validate: answers an AdmissionReview of a TrustRoot, denying it if ValidateTrustRoot fails.
+//   - GET /healthz: responds 200 while the server is up.
+//
+// Parameters:
+//   - archivePrefix: The directory of the repository inside mirrorFS archives, see ValidateTrustRoot.
+//
+// Returns:
+//   - The http.Handler of the webhook.
+func AdmissionHandler(archivePrefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
+		handleAdmissionReview(w, r, archivePrefix)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// handleAdmissionReview implements `POST /validate`.
+func handleAdmissionReview(w http.ResponseWriter, r *http.Request, archivePrefix string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	review := admissionReview{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAdmissionReviewSize)).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, fmt.Sprintf("invalid AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+	// Deletions carry no object, and other kinds are not ours to judge
+	var object struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	}
+	json.Unmarshal(review.Request.Object, &object)
+	if review.Request.Operation != "DELETE" && object.Kind == "TrustRoot" {
+		if err := ValidateTrustRoot(review.Request.Object, archivePrefix, now()); err != nil {
+			log.Printf("denied TrustRoot %s: %v\n", object.Metadata.Name, err)
+			response.Allowed = false
+			response.Result = &admissionStatus{Code: http.StatusForbidden, Message: err.Error()}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(admissionReview{APIVersion: admissionReviewVersion, Kind: "AdmissionReview", Response: response})
+}
+
+// ServeAdmissionWebhook serves AdmissionHandler over TLS on addr, as the API
+// server only calls webhooks over HTTPS.
+//
+// Parameters:
+//   - addr: The TCP address to listen on, e.g. ":8443".
+//   - certFile: The PEM certificate of the webhook Service, with its chain.
+//   - keyFile: The PEM private key of the certificate.
+//   - archivePrefix: The directory of the repository inside mirrorFS archives, see ValidateTrustRoot.
+//
+// Returns:
+//   - error: The error that stopped the server.
+func ServeAdmissionWebhook(addr, certFile, keyFile, archivePrefix string) error {
+	log.Printf("serving the TrustRoot admission webhook on %s\n", addr)
+	return http.ListenAndServeTLS(addr, certFile, keyFile, AdmissionHandler(archivePrefix))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+// testTrustRootObject returns the JSON TrustRoot object of spec.
+func testTrustRootObject(t *testing.T, spec map[string]any) []byte {
+	t.Helper()
+	object, err := json.Marshal(map[string]any{
+		"apiVersion": "policy.sigstore.dev/v1alpha1",
+		"kind":       "TrustRoot",
+		"metadata":   map[string]any{"name": "sigstore"},
+		"spec":       spec,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+// testRepositorySpec returns the repository spec of the mockmirror.
+func testRepositorySpec(t *testing.T) map[string]any {
+	t.Helper()
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rootJSONFile.Close()
+	rootJSON, err := io.ReadAll(rootJSONFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := compressRepository(workDir, &archive); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]any{"repository": map[string]any{"root": rootJSON, "mirrorFS": archive.Bytes(), "targets": "targets"}}
+}
+
+func TestValidateTrustRoot(t *testing.T) {
+	repository := testRepositorySpec(t)
+	tampered := testRepositorySpec(t)
+	archive := tampered["repository"].(map[string]any)["mirrorFS"].([]byte)
+	tampered["repository"].(map[string]any)["mirrorFS"] = archive[:len(archive)/2]
+	owner, _ := keys.GenerateEd25519Key()
+	other, _ := keys.GenerateEd25519Key()
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	logKeyPEM, _ := testPublicKeyPEM(t, &logKey.PublicKey)
+	chain := testCertificateChainPEM(t, "sigstore.dev", "sigstore-intermediate", "sigstore")
+	sigstoreKeys := func(chain, publicKey []byte) map[string]any {
+		return map[string]any{"sigstoreKeys": map[string]any{
+			"certificateAuthorities": []any{map[string]any{"uri": "https://fulcio.example.com", "certChain": chain}},
+			"tLogs":                  []any{map[string]any{"baseURL": "https://rekor.example.com", "hashAlgorithm": "sha-256", "publicKey": publicKey}},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		spec    map[string]any
+		now     time.Time
+		wantErr error
+	}{
+		{name: "repository", spec: repository, now: time.Now()},
+		{name: "truncated mirrorFS", spec: tampered, now: time.Now(), wantErr: assembler.ErrVerification},
+		{name: "repository without mirrorFS", spec: map[string]any{"repository": map[string]any{"root": []byte("{}")}}, now: time.Now(), wantErr: assembler.ErrVerification},
+		{name: "remote", spec: map[string]any{"remote": map[string]any{"mirror": "https://tuf.example.com", "root": testRoot(t, 1, owner, owner)}}, now: time.Now()},
+		{name: "remote root signed by another key", spec: map[string]any{"remote": map[string]any{"root": testRoot(t, 1, owner, other)}}, now: time.Now(), wantErr: assembler.ErrVerification},
+		{name: "expired remote root", spec: map[string]any{"remote": map[string]any{"root": testRoot(t, 1, owner, owner)}}, now: time.Now().Add(2 * time.Hour), wantErr: assembler.ErrMetadataExpired},
+		{name: "sigstoreKeys", spec: sigstoreKeys(chain, logKeyPEM), now: time.Now()},
+		{name: "expired certificate chain", spec: sigstoreKeys(chain, logKeyPEM), now: time.Now().Add(2 * time.Hour), wantErr: assembler.ErrVerification},
+		{name: "malformed log key", spec: sigstoreKeys(chain, []byte("not a key")), now: time.Now(), wantErr: assembler.ErrVerification},
+		{name: "empty spec", spec: map[string]any{}, now: time.Now(), wantErr: assembler.ErrVerification},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTrustRoot(testTrustRootObject(t, tt.spec), "", tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateTrustRoot() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandler(t *testing.T) {
+	server := httptest.NewServer(AdmissionHandler(""))
+	defer server.Close()
+	valid := testTrustRootObject(t, testRepositorySpec(t))
+	invalid := testTrustRootObject(t, map[string]any{})
+
+	tests := []struct {
+		name        string
+		operation   string
+		object      []byte
+		wantAllowed bool
+	}{
+		{name: "valid", operation: "CREATE", object: valid, wantAllowed: true},
+		{name: "invalid", operation: "UPDATE", object: invalid, wantAllowed: false},
+		{name: "deleted", operation: "DELETE", object: []byte("null"), wantAllowed: true},
+		{name: "other kind", operation: "CREATE", object: []byte(`{"kind":"ClusterImagePolicy"}`), wantAllowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(admissionReview{APIVersion: admissionReviewVersion, Kind: "AdmissionReview", Request: &admissionRequest{UID: "e911857d-c318-11e8-bbad-025000000001", Operation: tt.operation, Object: tt.object}})
+			resp, err := http.Post(server.URL+"/validate", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			review := admissionReview{}
+			if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+				t.Fatal(err)
+			}
+			if review.Response == nil || review.Response.UID != "e911857d-c318-11e8-bbad-025000000001" {
+				t.Fatalf("response = %+v, want the UID of the request", review.Response)
+			}
+			if review.Response.Allowed != tt.wantAllowed {
+				t.Errorf("allowed = %v, want %v", review.Response.Allowed, tt.wantAllowed)
+			}
+			if !tt.wantAllowed && (review.Response.Result == nil || !strings.Contains(review.Response.Result.Message, "no repository")) {
+				t.Errorf("status = %+v, want the reason of the denial", review.Response.Result)
+			}
+		})
+	}
+
+	resp, err := http.Post(server.URL+"/validate", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("review without request: status = %d, want 400", resp.StatusCode)
+	}
+}
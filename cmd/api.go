@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"cmd/assembler"
+)
+
+// mirrorPresets are the well-known Sigstore TUF repositories an assemble
+// request can name instead of a mirror URL.
+var mirrorPresets = map[string]string{
+	"public-good": "https://tuf-repo-cdn.sigstore.dev",
+	"staging":     "https://tuf-repo-cdn.sigstage.dev",
+}
+
+// AssembleRequest is the JSON body of `POST /assemble`.
+type AssembleRequest struct {
+	// Mirror is the URL of the TUF repository mirror to assemble, one of the
+	// mirrors the server allows.
+	Mirror string `json:"mirror,omitempty"`
+	// Preset names one of mirrorPresets, instead of Mirror.
+	Preset string `json:"preset,omitempty"`
+	// Name of the TrustRoot, defaults to the naming of the main command.
+	Name string `json:"name,omitempty"`
+	// Options of the output, as the flags of the main command.
+	Options AssembleOptions `json:"options,omitempty"`
+}
+
+// AssembleOptions are the output options of an AssembleRequest.
+type AssembleOptions struct {
+	// Output is the format of the response, as --output, by default trustroot.
+	Output string `json:"output,omitempty"`
+	// NameStrategy names the TrustRoot when Name is not set, as
+	// --name-strategy, by default timestamp.
+	NameStrategy string `json:"nameStrategy,omitempty"`
+}
+
+// validate checks the name and options of the request and sets the default
+// options.
+func (r *AssembleRequest) validate() error {
+	if r.Name != "" && !validObjectName(r.Name) {
+		return fmt.Errorf("name %q is not a valid Kubernetes object name", r.Name)
+	}
+	if r.Options.Output == "" {
+		r.Options.Output = outputTrustRoot
+	}
+	if _, err := LookupRenderer(r.Options.Output); err != nil {
+		return err
+	}
+	if r.Options.NameStrategy == "" {
+		r.Options.NameStrategy = nameStrategyTimestamp
+	}
+	if r.Options.NameStrategy != nameStrategyTimestamp && r.Options.NameStrategy != nameStrategyDigest {
+		return fmt.Errorf("unknown name strategy %q, expected %s or %s", r.Options.NameStrategy, nameStrategyTimestamp, nameStrategyDigest)
+	}
+	return nil
+}
+
+// resolveMirror returns the mirror URL the request asks for. Only presets and
+// the allowed mirrors are assembled, so the server cannot be made to fetch
+// arbitrary URLs of the network it runs in.
+func (r AssembleRequest) resolveMirror(allowedMirrors []string) (string, error) {
+	switch {
+	case r.Mirror != "" && r.Preset != "":
+		return "", fmt.Errorf("mirror and preset are mutually exclusive")
+	case r.Preset != "":
+		mirror, ok := mirrorPresets[r.Preset]
+		if !ok {
+			return "", fmt.Errorf("unknown preset %q", r.Preset)
+		}
+		return mirror, nil
+	case r.Mirror != "":
+		// Local sources would expose the files of the server
+		if !isHTTPSource(r.Mirror) && !strings.HasPrefix(r.Mirror, "s3://") && !strings.HasPrefix(r.Mirror, "oci://") {
+			return "", fmt.Errorf("mirror %q is not an http(s)://, s3:// or oci:// URL", r.Mirror)
+		}
+		mirror := strings.TrimSuffix(r.Mirror, "/")
+		if !slices.Contains(allowedMirrors, mirror) {
+			return "", fmt.Errorf("mirror %q is not allowed by the server", r.Mirror)
+		}
+		return mirror, nil
+	default:
+		return mirrorPresets["public-good"], nil
+	}
+}
+
+// ServeAPI serves the assembler HTTP API on addr.
+//
+// Parameters:
+//   - addr: The TCP address to listen on, e.g. ":8080".
+//   - token: The bearer token of assemble requests.
+//   - allowedMirrors: The mirrors requests may assemble besides the presets.
+//
+// Returns:
+//   - error: The error that stopped the server.
+func ServeAPI(addr, token string, allowedMirrors []string) error {
+	log.Printf("serving the assembler API on %s\n", addr)
+	return http.ListenAndServe(addr, APIHandler(token, allowedMirrors))
+}
+
+// APIHandler returns the handler of the assembler HTTP API:
+//
+//   - POST /assemble: assembles the repository described by an AssembleRequest
+//     and responds with its output, by default the `repository` TrustRoot
+//     YAML. Requests must carry token as `Authorization: Bearer` header.
+//   - GET /healthz: responds 200 while the server is up.
+//
+// Parameters:
+//   - token: The bearer token of assemble requests.
+//   - allowedMirrors: The mirrors requests may assemble besides the presets,
+//     without trailing slash.
+//
+// Returns:
+//   - The http.Handler of the API.
+func APIHandler(token string, allowedMirrors []string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/assemble", func(w http.ResponseWriter, r *http.Request) {
+		handleAssemble(w, r, token, allowedMirrors)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// authorized reports whether r carries token as bearer token.
+func authorized(r *http.Request, token string) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+// handleAssemble implements `POST /assemble`.
+func handleAssemble(w http.ResponseWriter, r *http.Request, token string, allowedMirrors []string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorized(r, token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	request := AssembleRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := request.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mirror, err := request.resolveMirror(allowedMirrors)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	workDir, err := os.MkdirTemp(tempDir(), "tuf-repository-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(workDir)
+	rootJSONFile, err := AssembleRepository(mirror, workDir)
+	if err != nil {
+		log.Printf("could not assemble %s: %v\n", mirror, err)
+		http.Error(w, fmt.Sprintf("could not assemble %s: %v", mirror, err), http.StatusBadGateway)
+		return
+	}
+	defer rootJSONFile.Close()
+	// Presets are trusted for chaining to their pinned roots, like in the
+	// main command
+	if _, err := verifyKnownRoot(mirror, rootJSONFile.Name()); err != nil {
+		log.Printf("could not assemble %s: %v\n", mirror, err)
+		http.Error(w, fmt.Sprintf("could not assemble %s: %v", mirror, err), http.StatusBadGateway)
+		return
+	}
+	// Catch layout regressions before they reach an admission webhook
+	if request.Options.Output != outputTrustedRoot && request.Options.Output != outputDigest {
+		if err := verifyArchive(workDir, rootJSONFile.Name()); err != nil {
+			http.Error(w, fmt.Sprintf("could not assemble %s: %v", mirror, err), http.StatusBadGateway)
+			return
+		}
+	}
+	name := request.Name
+	if name == "" {
+		snapshotVersion, err := latestMetadataVersion(workDir, "snapshot.json")
+		if err != nil {
+			http.Error(w, fmt.Sprintf("could not read version of snapshot.json: %v", err), http.StatusBadGateway)
+			return
+		}
+		name, err = TrustRootName(request.Options.NameStrategy, sourceName(mirror), workDir, snapshotVersion)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	output, err := renderRepository(request.Options.Output, name, workDir, rootJSONFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", outputContentType(request.Options.Output))
+	w.Write(output)
+}
+
+// latestMetadataVersion returns the version of the latest role metadata of
+// the repository in dir.
+func latestMetadataVersion(dir, role string) (int64, error) {
+	path, err := latestMetadataPath(dir, role)
+	if err != nil {
+		return 0, err
+	}
+	metadata, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return assembler.MetadataVersion(metadata)
+}
+
+// outputContentType returns the media type of the output format.
+func outputContentType(format string) string {
+	switch format {
+	case outputTrustedRoot:
+		return "application/json"
+	case outputDigest:
+		return "text/plain; charset=utf-8"
+	default:
+		return "application/yaml"
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIHandler(t *testing.T) {
+	mirror := httptest.NewServer(RepositoryHandler(newTestRepository(t)))
+	defer mirror.Close()
+	api := httptest.NewServer(APIHandler("secret", []string{mirror.URL, mirror.URL + "/missing"}))
+	defer api.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		body     string
+		status   int
+		contains string
+	}{
+		{"assemble mirror", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `/", "name": "test"}`, http.StatusOK, "name: test\n"},
+		{"default name", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `"}`, http.StatusOK, "name: " + sourceName(mirror.URL) + "-"},
+		{"digest name strategy", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `", "options": {"nameStrategy": "digest"}}`, http.StatusOK, "name: " + sourceName(mirror.URL) + "-"},
+		{"configmap output", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `", "name": "test", "options": {"output": "configmap"}}`, http.StatusOK, "kind: ConfigMap"},
+		{"digest output", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `", "options": {"output": "digest"}}`, http.StatusOK, "  repository"},
+		{"missing token", http.MethodPost, "", `{"mirror": "` + mirror.URL + `"}`, http.StatusUnauthorized, "unauthorized"},
+		{"wrong token", http.MethodPost, "guess", `{"mirror": "` + mirror.URL + `"}`, http.StatusUnauthorized, "unauthorized"},
+		{"mirror not allowed", http.MethodPost, "secret", `{"mirror": "http://169.254.169.254/latest"}`, http.StatusBadRequest, "is not allowed"},
+		{"mirror and preset", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `", "preset": "staging"}`, http.StatusBadRequest, "mutually exclusive"},
+		{"unknown preset", http.MethodPost, "secret", `{"preset": "production"}`, http.StatusBadRequest, "unknown preset"},
+		{"unknown field", http.MethodPost, "secret", `{"mirrors": []}`, http.StatusBadRequest, "invalid request body"},
+		{"unknown option", http.MethodPost, "secret", `{"options": {"strict": true}}`, http.StatusBadRequest, "invalid request body"},
+		{"unknown output", http.MethodPost, "secret", `{"options": {"output": "helm"}}`, http.StatusBadRequest, "helm"},
+		{"unknown name strategy", http.MethodPost, "secret", `{"options": {"nameStrategy": "random"}}`, http.StatusBadRequest, "unknown name strategy"},
+		{"uppercase name", http.MethodPost, "secret", `{"name": "Test"}`, http.StatusBadRequest, "not a valid Kubernetes object name"},
+		{"name with slash", http.MethodPost, "secret", `{"name": "../test"}`, http.StatusBadRequest, "not a valid Kubernetes object name"},
+		{"name ending with dash", http.MethodPost, "secret", `{"name": "test-"}`, http.StatusBadRequest, "not a valid Kubernetes object name"},
+		{"name too long", http.MethodPost, "secret", `{"name": "` + strings.Repeat("a", 254) + `"}`, http.StatusBadRequest, "not a valid Kubernetes object name"},
+		{"not a URL", http.MethodPost, "secret", `{"mirror": "file:///etc"}`, http.StatusBadRequest, "is not an http(s)://, s3:// or oci:// URL"},
+		{"unreachable mirror", http.MethodPost, "secret", `{"mirror": "` + mirror.URL + `/missing"}`, http.StatusBadGateway, "could not assemble"},
+		{"get", http.MethodGet, "secret", "", http.StatusMethodNotAllowed, "method not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, api.URL+"/assemble", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if !strings.Contains(string(body), tt.contains) {
+				t.Errorf("response does not contain %q: %s", tt.contains, body)
+			}
+		})
+	}
+}
+
+func TestAPIHandlerKnownRoot(t *testing.T) {
+	repository := newTestRepository(t)
+	mirror := httptest.NewServer(RepositoryHandler(repository))
+	defer mirror.Close()
+	rootJSON, err := os.ReadFile(filepath.Join(repository, "1.root.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	roles, err := RootKeys(rootJSON)
+	if err != nil {
+		t.Fatalf("RootKeys() error = %v", err)
+	}
+	known := KnownRoot{Version: 1, Threshold: roles[0].Threshold}
+	for _, key := range roles[0].Keys {
+		known.Fingerprints = append(known.Fingerprints, key.Fingerprint)
+	}
+	mirrorPresets["test"] = mirror.URL
+	defer delete(mirrorPresets, "test")
+	defer delete(knownRoots, mirror.URL)
+	api := httptest.NewServer(APIHandler("secret", nil))
+	defer api.Close()
+
+	tests := []struct {
+		name   string
+		known  KnownRoot
+		status int
+	}{
+		{"chains to the known root", known, http.StatusOK},
+		{"other root keys", KnownRoot{Version: 1, Threshold: 1, Fingerprints: []string{"other"}}, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			knownRoots[mirror.URL] = tt.known
+			req, err := http.NewRequest(http.MethodPost, api.URL+"/assemble", strings.NewReader(`{"preset": "test"}`))
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d: %s", resp.StatusCode, tt.status, body)
+			}
+			if tt.status != http.StatusOK && !strings.Contains(string(body), "known root keys") {
+				t.Errorf("response does not name the known root: %s", body)
+			}
+		})
+	}
+}
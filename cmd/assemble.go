@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"cmd/assembler"
+)
+
+// AssembleRepository assembles the serialized repository of mirror into
+// workDir with a go-tuf client: the verified top-level metadata, every target
+// and the targets of succinct hash bin delegations. The client is initialized
+// with the latest root.json of mirror, or of the --root-source.
+//
+// Every file written to workDir is downloaded by the verifying client, never
+// by a raw request, and the assembled directory is verified again before it is
+// returned. It keeps no state outside workDir and can run any number of
+// times, concurrently, in the same process.
+//
+// Parameters:
+//   - mirror: The URL of the TUF repository mirror.
+//   - workDir: The directory to assemble the repository in.
+//
+// Returns:
+//   - The root.json of the repository, to embed as the TrustRoot root.
+//   - An error if the repository could not be downloaded or verified.
+func AssembleRepository(mirror, workDir string) (*os.File, error) {
+	rootJSON, err := initialRoot(mirror)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := openVerifiedRepository(mirror, rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	return repository.assemble(workDir)
+}
+
+// AssembleMetadata verifies the metadata chain of mirror with a go-tuf client,
+// from the latest root.json, of mirror or the --root-source, to
+// timestamp.json, snapshot.json and targets.json, and writes the verified
+// metadata into workDir without downloading any target, for fast freshness checks and remote TrustRoots
+// whose targets the policy-controller fetches from the mirror itself.
+//
+// Parameters:
+//   - mirror: The URL of the TUF repository mirror.
+//   - workDir: The directory to write the metadata in.
+//
+// Returns:
+//   - The root.json of the repository, to embed as the TrustRoot root.
+//   - An error if the metadata could not be downloaded or verified.
+func AssembleMetadata(mirror, workDir string) (*os.File, error) {
+	rootJSON, err := initialRoot(mirror)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := openVerifiedRepository(mirror, rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	rootPath, err := repository.writeMetadata(workDir)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("verified the metadata of %s, %d targets not downloaded\n", mirror, len(repository.Targets))
+	return os.Open(rootPath)
+}
+
+// assemble writes the serialized repository of r into workDir, see
+// AssembleRepository.
+func (r *verifiedRepository) assemble(workDir string) (*os.File, error) {
+	meta, err := r.Local.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+	consistent, err := assembler.ConsistentSnapshot(meta["root.json"])
+	if err != nil {
+		return nil, err
+	}
+	rootPath, skipped, err := r.Assemble(workDir, func(name string, err error) bool {
+		if !r.expired[name] || !fetchPolicies[fileClassExpiredTarget].Skip {
+			return false
+		}
+		// Best effort for material no longer trusted for new signatures
+		warnf(nil, "skipping expired target %s: %v", name, err)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.logVersions()
+
+	if _, err := ResolveSuccinctDelegations(r.Mirror, workDir, meta["targets.json"], meta["snapshot.json"], consistent, delegatedTargets); err != nil {
+		return nil, fmt.Errorf("could not resolve succinct hash bin delegations: %w", err)
+	}
+	// What is archived is exactly what was verified
+	if _, err := verifyDirectory(workDir, dirFetcher{dir: workDir, plainTargets: true}, skipped); err != nil {
+		return nil, fmt.Errorf("assembled repository of %s does not verify: %w", r.Mirror, err)
+	}
+	if len(skipped) > 0 {
+		log.Printf("assembled %s, %d targets, %d expired targets skipped\n", r.Mirror, len(r.Targets)-len(skipped), len(skipped))
+	} else {
+		log.Printf("assembled %s, %d targets\n", r.Mirror, len(r.Targets))
+	}
+	return os.Open(rootPath)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+)
+
+func TestAssembleRepository(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	// Like a bucket in website mode or nginx without autoindex
+	withoutListings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.Error(w, "listing forbidden", http.StatusForbidden)
+			return
+		}
+		mockmirror.Handler().ServeHTTP(w, r)
+	}))
+	defer withoutListings.Close()
+
+	tests := []struct {
+		name    string
+		mirror  string
+		wantErr error
+	}{
+		{"mockmirror", server.URL, nil},
+		{"without listings", withoutListings.URL, nil},
+		{"missing", server.URL + "/missing", assembler.ErrMirrorUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			rootJSONFile, err := AssembleRepository(tt.mirror, workDir)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AssembleRepository() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer rootJSONFile.Close()
+			for _, target := range mockmirror.Targets {
+				if _, err := os.Stat(filepath.Join(workDir, "targets", target)); err != nil {
+					t.Errorf("target %s is missing: %v", target, err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(workDir, "timestamp.json")); err != nil {
+				t.Errorf("timestamp.json is missing: %v", err)
+			}
+		})
+	}
+}
+
+func TestAssembleMetadata(t *testing.T) {
+	var targetRequests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/targets/") {
+			targetRequests = append(targetRequests, r.URL.Path)
+		}
+		mockmirror.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleMetadata(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleMetadata() error = %v", err)
+	}
+	defer rootJSONFile.Close()
+	if len(targetRequests) > 0 {
+		t.Errorf("targets requested: %v, want none", targetRequests)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "timestamp.json")); err != nil {
+		t.Errorf("timestamp.json is missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "targets")); !os.IsNotExist(err) {
+		t.Errorf("targets directory exists: %v", err)
+	}
+
+	rootJSON, _ := os.ReadFile(rootJSONFile.Name())
+	trustRoot, err := ReadTrustRoot([]byte(RenderRemoteTrustRoot("sigstore", server.URL, rootJSON)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trustRoot.Spec.Remote == nil || trustRoot.Spec.Remote.Mirror != server.URL || string(trustRoot.Spec.Remote.Root) != string(rootJSON) {
+		t.Errorf("remote TrustRoot spec = %+v, want the mirror and its root", trustRoot.Spec.Remote)
+	}
+	object := testTrustRootObject(t, map[string]any{"remote": map[string]any{"mirror": trustRoot.Spec.Remote.Mirror, "root": trustRoot.Spec.Remote.Root}})
+	if err := ValidateTrustRoot(object, "", time.Now()); err != nil {
+		t.Errorf("ValidateTrustRoot() of the remote TrustRoot error = %v", err)
+	}
+}
+
+func TestVerifyAssembledDirectory(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		tamper  func(workDir string) error
+		wantErr error
+	}{
+		{"assembled", func(string) error { return nil }, nil},
+		{"tampered target", func(workDir string) error {
+			return os.WriteFile(filepath.Join(workDir, "targets", "rekor.pub"), []byte("tampered"), 0o644)
+		}, assembler.ErrVerification},
+		{"missing target", func(workDir string) error {
+			return os.Remove(filepath.Join(workDir, "targets", "ctfe.pub"))
+		}, assembler.ErrMirrorUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			rootJSONFile, err := AssembleRepository(server.URL, workDir)
+			if err != nil {
+				t.Fatalf("AssembleRepository() error = %v", err)
+			}
+			rootJSONFile.Close()
+			if err := tt.tamper(workDir); err != nil {
+				t.Fatalf("Failed to tamper with the repository: %v", err)
+			}
+			if err := verifyAssembledDirectory(workDir); !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("verifyAssembledDirectory() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssembleRepositoryWithoutConsistentSnapshots(t *testing.T) {
+	tests := []struct {
+		name   string
+		remove string
+	}{
+		{"versioned root", ""},
+		{"plain root only", "1.root.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := newNonConsistentTestRepository(t)
+			if tt.remove != "" {
+				if err := os.Remove(filepath.Join(repository, tt.remove)); err != nil {
+					t.Fatalf("Failed to remove %s: %v", tt.remove, err)
+				}
+			}
+			server := httptest.NewServer(http.FileServer(http.Dir(repository)))
+			defer server.Close()
+
+			workDir := t.TempDir()
+			rootJSONFile, err := AssembleRepository(server.URL, workDir)
+			if err != nil {
+				t.Fatalf("AssembleRepository() error = %v", err)
+			}
+			rootJSONFile.Close()
+			for _, name := range []string{"1.root.json", "snapshot.json", "targets.json", "timestamp.json"} {
+				if _, err := os.Stat(filepath.Join(workDir, name)); err != nil {
+					t.Errorf("%s is missing: %v", name, err)
+				}
+			}
+			for target := range testTargets {
+				if _, err := os.Stat(filepath.Join(workDir, "targets", target)); err != nil {
+					t.Errorf("target %s is missing: %v", target, err)
+				}
+			}
+		})
+	}
+}
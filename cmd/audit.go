@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// auditLogUsage is the usage of the --audit-log flag.
+const auditLogUsage = "Append-only JSON Lines audit log receiving a hash-chained entry for every emitted TrustRoot with its inputs, metadata versions and output digests: a file, or an s3://bucket/key or gs://bucket/key object"
+
+// AuditEntry is a line of an audit log, describing a generated trust root.
+// Every entry holds the hash of the previous one, so rewriting or removing
+// an entry breaks the chain of all the entries after it.
+type AuditEntry struct {
+	// Sequence numbers the entries of a log from 1.
+	Sequence  int       `json:"sequence"`
+	Time      time.Time `json:"time"`
+	TrustRoot string    `json:"trustRoot"`
+	Output    string    `json:"output"`
+	// Source is the mirror or --map file of the repository, RootSource the
+	// --root-source it is bootstrapped from and RootChecksum the pinned
+	// --root-checksum, if set.
+	Source       string `json:"source"`
+	RootSource   string `json:"rootSource,omitempty"`
+	RootChecksum string `json:"rootChecksum,omitempty"`
+	// RootDigest and RepositoryDigest are those of GenerationEvent.
+	RootDigest       string           `json:"rootDigest"`
+	RepositoryDigest string           `json:"repositoryDigest,omitempty"`
+	Versions         map[string]int64 `json:"versions"`
+	// Targets are the "sha256:<hex>" digests of the embedded targets, by
+	// path.
+	Targets map[string]string `json:"targets,omitempty"`
+	// OutputSize and OutputDigest describe the emitted output.
+	OutputSize   int64  `json:"outputSize"`
+	OutputDigest string `json:"outputDigest"`
+	// PreviousHash is the Hash of the previous entry, empty for the first.
+	PreviousHash string `json:"previousHash,omitempty"`
+	// Hash is the hex SHA-256 of the JSON encoding of the entry without
+	// Hash.
+	Hash string `json:"hash,omitempty"`
+}
+
+// hash returns the hex SHA-256 of the JSON encoding of e without its Hash.
+func (e AuditEntry) hash() string {
+	e.Hash = ""
+	content, _ := json.Marshal(e)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditStorage reads and appends to an audit log.
+type auditStorage interface {
+	// read returns the content of the log, empty if it does not exist yet.
+	read() ([]byte, error)
+	// append adds line to the log whose current content is content.
+	append(content, line []byte) error
+}
+
+// newAuditStorage returns the storage of the audit log at destination, a
+// file or an s3://bucket/key or gs://bucket/key object, with the
+// credentials of PublishDirectory.
+func newAuditStorage(destination string) (auditStorage, error) {
+	if !strings.Contains(destination, "://") {
+		return auditFile(destination), nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit log %q: %v", destination, err)
+	}
+	key := strings.Trim(u.Path, "/")
+	if key == "" {
+		return nil, fmt.Errorf("audit log %q names no object", destination)
+	}
+	switch u.Scheme {
+	case "s3":
+		storage, err := newS3Publisher(u.Host, "")
+		if err != nil {
+			return nil, err
+		}
+		return auditObject{key: key, put: storage.put, get: storage.get}, nil
+	case "gs":
+		storage, err := newGCSPublisher(u.Host, "")
+		if err != nil {
+			return nil, err
+		}
+		return auditObject{key: key, put: storage.put, get: storage.get}, nil
+	}
+	return nil, fmt.Errorf("unsupported audit log scheme %q", u.Scheme)
+}
+
+// auditFile is an audit log file, appended to in place.
+type auditFile string
+
+func (f auditFile) read() ([]byte, error) {
+	content, err := os.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+func (f auditFile) append(_, line []byte) error {
+	file, err := os.OpenFile(string(f), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// auditObject is an audit log object of a bucket, which is replaced by the
+// log with the new line appended, as objects cannot be appended to.
+type auditObject struct {
+	key string
+	put func(key string, body []byte, contentType, cacheControl string) error
+	get func(key string) (*http.Response, error)
+}
+
+func (o auditObject) read() ([]byte, error) {
+	resp, err := o.get(o.key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Only a missing object starts a new chain, not an unreadable one
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("GET %s: %w", o.key, newStatusError(resp))
+}
+
+func (o auditObject) append(content, line []byte) error {
+	return o.put(o.key, append(append([]byte{}, content...), line...), "application/jsonl", "no-store")
+}
+
+// AppendAuditEntry chains entry to the last entry of the audit log at
+// destination and appends it. The log is verified first, so entries are
+// never chained to a log that was tampered with.
+//
+// Parameters:
+//   - destination: The audit log, a file or an s3://bucket/key or gs://bucket/key object.
+//   - entry: The entry, whose Sequence, PreviousHash and Hash are set.
+//
+// Returns:
+//   - The appended entry.
+//   - An error if the log could not be read or written, wrapping assembler.ErrVerification if its chain is broken.
+func AppendAuditEntry(destination string, entry AuditEntry) (AuditEntry, error) {
+	storage, err := newAuditStorage(destination)
+	if err != nil {
+		return entry, err
+	}
+	content, err := storage.read()
+	if err != nil {
+		return entry, fmt.Errorf("could not read audit log %s: %v", destination, err)
+	}
+	entries, err := VerifyAuditLog(content)
+	if err != nil {
+		return entry, fmt.Errorf("audit log %s: %w", destination, err)
+	}
+	entry.Sequence, entry.PreviousHash = 1, ""
+	if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		entry.Sequence, entry.PreviousHash = last.Sequence+1, last.Hash
+	}
+	entry.Hash = entry.hash()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return entry, err
+	}
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		line = append([]byte("\n"), line...)
+	}
+	if err := storage.append(content, append(line, '\n')); err != nil {
+		return entry, fmt.Errorf("could not append to audit log %s: %v", destination, err)
+	}
+	return entry, nil
+}
+
+// VerifyAuditLog checks the hash chain of the content of an audit log.
+//
+// Parameters:
+//   - content: The JSON Lines of the log.
+//
+// Returns:
+//   - The entries of the log, oldest first.
+//   - An error wrapping assembler.ErrVerification naming the first entry that does not chain to the previous one.
+func VerifyAuditLog(content []byte) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	previous := AuditEntry{}
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		entry := AuditEntry{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return entries, fmt.Errorf("%w: %w: line %d is not an audit entry: %v", assembler.ErrVerification, errAuditTampered, i+1, err)
+		}
+		canonical, err := json.Marshal(entry)
+		if err != nil {
+			return entries, err
+		}
+		switch {
+		case !bytes.Equal(canonical, bytes.TrimSpace(line)):
+			return entries, fmt.Errorf("%w: %w: line %d is not an entry as written, fields were added or reordered", assembler.ErrVerification, errAuditTampered, i+1)
+		case entry.Hash != entry.hash():
+			return entries, fmt.Errorf("%w: %w: entry %d on line %d does not match its hash", assembler.ErrVerification, errAuditTampered, entry.Sequence, i+1)
+		case entry.Sequence != previous.Sequence+1 || entry.PreviousHash != previous.Hash:
+			return entries, fmt.Errorf("%w: %w: entry %d on line %d does not follow entry %d", assembler.ErrVerification, errAuditTampered, entry.Sequence, i+1, previous.Sequence)
+		}
+		entries = append(entries, entry)
+		previous = entry
+	}
+	return entries, nil
+}
+
+// errAuditTampered is the failure class of an audit log modified after its
+// entries were written.
+var errAuditTampered = errors.New("audit log tampered with")
+
+// errAuditEntryMissing is returned by CheckAuditLog for an entry hash that
+// is not in the log.
+var errAuditEntryMissing = errors.New("entry not in the audit log")
+
+// CheckAuditLog verifies the audit log at destination, and that it still
+// holds the entries of hashes, e.g. logged by earlier runs or kept by
+// auditors, which detects a log rewritten or truncated as a whole.
+//
+// Parameters:
+//   - destination: The audit log, a file or an s3://bucket/key or gs://bucket/key object.
+//   - hashes: The hashes of entries the log must hold.
+//
+// Returns:
+//   - The entries of the log, oldest first.
+//   - An error if the log is missing or empty, wrapping assembler.ErrVerification if the chain is broken or an entry is missing.
+func CheckAuditLog(destination string, hashes []string) ([]AuditEntry, error) {
+	storage, err := newAuditStorage(destination)
+	if err != nil {
+		return nil, err
+	}
+	content, err := storage.read()
+	if err != nil {
+		return nil, fmt.Errorf("could not read audit log %s: %v", destination, err)
+	}
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("audit log %s does not exist or is empty", destination)
+	}
+	entries, err := VerifyAuditLog(content)
+	if err != nil {
+		return entries, err
+	}
+	logged := map[string]bool{}
+	for _, entry := range entries {
+		logged[entry.Hash] = true
+	}
+	for _, hash := range hashes {
+		if !logged[strings.ToLower(hash)] {
+			return entries, fmt.Errorf("%w: %w: %w: %s", assembler.ErrVerification, errAuditTampered, errAuditEntryMissing, hash)
+		}
+	}
+	return entries, nil
+}
+
+// recordAudit appends the AuditEntry of the trust root name, rendered in the
+// format output from the repository assembled in workDir out of source, to
+// the --audit-log destination, if set, exiting on errors.
+func recordAudit(destination, source, format, name, workDir string, rootJSONFile *os.File, output io.WriterTo) {
+	if destination == "" {
+		return
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	entry, err := newAuditEntry(source, format, name, workDir, rootJSON, output)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	entry, err = AppendAuditEntry(destination, entry)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	log.Printf("appended entry %d, hash %s, to audit log %s\n", entry.Sequence, entry.Hash, destination)
+}
+
+// newAuditEntry describes the trust root name rendered in the format output
+// from the repository assembled in workDir out of source.
+func newAuditEntry(source, format, name, workDir string, rootJSON []byte, output io.WriterTo) (AuditEntry, error) {
+	event, err := newGenerationEvent(name, format, workDir, rootJSON)
+	if err != nil {
+		return AuditEntry{}, err
+	}
+	targets, err := TargetDigests(workDir)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("could not digest targets: %v", err)
+	}
+	described, err := describeOutput(output)
+	if err != nil {
+		return AuditEntry{}, fmt.Errorf("could not digest output: %v", err)
+	}
+	entry := AuditEntry{
+		Time:             now().UTC(),
+		TrustRoot:        name,
+		Output:           format,
+		Source:           source,
+		RootDigest:       event.RootDigest,
+		RepositoryDigest: event.RepositoryDigest,
+		Versions:         event.Versions,
+		Targets:          targets,
+		OutputSize:       described.Size,
+		OutputDigest:     "sha256:" + described.SHA256,
+	}
+	if rootSource != "" {
+		entry.RootSource = rootSourceName(source)
+	}
+	if rootChecksum != "" {
+		entry.RootChecksum = strings.ToLower(strings.TrimPrefix(rootChecksum, "sha256:"))
+	}
+	if len(entry.Targets) == 0 {
+		entry.Targets = nil
+	}
+	return entry, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+)
+
+// testAuditLog returns an audit log file of n entries.
+func testAuditLog(t *testing.T, n int) (string, []AuditEntry) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	var entries []AuditEntry
+	for i := 0; i < n; i++ {
+		entry, err := AppendAuditEntry(path, AuditEntry{TrustRoot: "sigstore", Source: "https://tuf.example.com", OutputDigest: "sha256:" + strings.Repeat("0", 64)})
+		if err != nil {
+			t.Fatalf("AppendAuditEntry() error = %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	return path, entries
+}
+
+func TestAppendAuditEntry(t *testing.T) {
+	path, entries := testAuditLog(t, 3)
+	for i, entry := range entries {
+		if entry.Sequence != i+1 {
+			t.Errorf("entry %d Sequence = %d", i, entry.Sequence)
+		}
+		if i > 0 && entry.PreviousHash != entries[i-1].Hash {
+			t.Errorf("entry %d PreviousHash = %q, want %q", i, entry.PreviousHash, entries[i-1].Hash)
+		}
+	}
+	if entries[0].PreviousHash != "" {
+		t.Errorf("first entry PreviousHash = %q, want none", entries[0].PreviousHash)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 3 {
+		t.Errorf("audit log has %d lines, want 3", lines)
+	}
+
+	// Entries are never chained to a tampered log
+	if err := os.WriteFile(path, bytes.Replace(content, []byte("sigstore"), []byte("evil"), 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AppendAuditEntry(path, AuditEntry{TrustRoot: "sigstore"}); !errors.Is(err, assembler.ErrVerification) {
+		t.Errorf("AppendAuditEntry() to a tampered log error = %v, want %v", err, assembler.ErrVerification)
+	}
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	path, _ := testAuditLog(t, 3)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(content), "\n")
+
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "intact", content: string(content), want: 3},
+		{name: "without trailing newline", content: strings.TrimSuffix(string(content), "\n"), want: 3},
+		{name: "modified entry", content: strings.Replace(string(content), "tuf.example.com", "tuf.example.org", 1), wantErr: true},
+		{name: "removed entry", content: lines[0] + lines[2], want: 1, wantErr: true},
+		{name: "reordered entries", content: lines[1] + lines[0] + lines[2], wantErr: true},
+		{name: "added field", content: strings.Replace(lines[0], `{"sequence"`, `{"note":"x","sequence"`, 1), wantErr: true},
+		{name: "malformed line", content: lines[0] + "{\n", want: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := VerifyAuditLog([]byte(tt.content))
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, assembler.ErrVerification)) {
+				t.Fatalf("VerifyAuditLog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(entries) != tt.want {
+				t.Errorf("VerifyAuditLog() = %d entries, want %d", len(entries), tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAuditLog(t *testing.T) {
+	path, entries := testAuditLog(t, 2)
+	tests := []struct {
+		name     string
+		log      string
+		hashes   []string
+		wantErr  error
+		wantNone bool
+	}{
+		{name: "no hashes", log: path},
+		{name: "logged hashes", log: path, hashes: []string{entries[0].Hash, strings.ToUpper(entries[1].Hash)}},
+		{name: "missing hash", log: path, hashes: []string{strings.Repeat("0", 64)}, wantErr: assembler.ErrVerification},
+		{name: "missing log", log: filepath.Join(t.TempDir(), "audit.jsonl"), wantNone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckAuditLog(tt.log, tt.hashes)
+			switch {
+			case tt.wantNone:
+				if err == nil {
+					t.Fatal("CheckAuditLog() of a missing log succeeded")
+				}
+			case !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil):
+				t.Fatalf("CheckAuditLog() error = %v, want %v", err, tt.wantErr)
+			case err == nil && len(got) != len(entries):
+				t.Errorf("CheckAuditLog() = %d entries, want %d", len(got), len(entries))
+			}
+		})
+	}
+}
+
+func TestAppendAuditEntryBucket(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case status != http.StatusOK:
+			w.WriteHeader(status)
+		case objects[r.URL.Path] == nil:
+			http.NotFound(w, r)
+		default:
+			w.Write(objects[r.URL.Path])
+		}
+	}))
+	defer server.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	for i := 0; i < 2; i++ {
+		if _, err := AppendAuditEntry("s3://audit/trustroots/audit.jsonl", AuditEntry{TrustRoot: "sigstore"}); err != nil {
+			t.Fatalf("AppendAuditEntry() error = %v", err)
+		}
+	}
+	entries, err := VerifyAuditLog(objects["/audit/trustroots/audit.jsonl"])
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("VerifyAuditLog() of the object = %d entries, %v, want 2", len(entries), err)
+	}
+
+	// An unreadable log does not start a new chain
+	status = http.StatusForbidden
+	if _, err := AppendAuditEntry("s3://audit/trustroots/audit.jsonl", AuditEntry{TrustRoot: "sigstore"}); err == nil {
+		t.Error("AppendAuditEntry() to an unreadable object succeeded")
+	}
+	if _, err := AppendAuditEntry("s3://audit", AuditEntry{}); err == nil {
+		t.Error("AppendAuditEntry() to a bucket without key succeeded")
+	}
+}
+
+func TestNewAuditEntry(t *testing.T) {
+	defer func(source, checksum string) { rootSource, rootChecksum = source, checksum }(rootSource, rootChecksum)
+	rootSource, rootChecksum = "", ""
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	defer rootJSONFile.Close()
+	rootChecksum = "sha256:" + strings.Repeat("AB", 32)
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := newAuditEntry(server.URL, outputTrustRoot, "sigstore", workDir, rootJSON, strings.NewReader("trustroot"))
+	if err != nil {
+		t.Fatalf("newAuditEntry() error = %v", err)
+	}
+	for _, name := range mockmirror.Targets {
+		if !strings.HasPrefix(entry.Targets[name], "sha256:") {
+			t.Errorf("entry has no digest of target %s: %v", name, entry.Targets)
+		}
+	}
+	if entry.Versions["root.json"] != 1 || entry.OutputSize != int64(len("trustroot")) || !strings.HasPrefix(entry.OutputDigest, "sha256:") {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+	if entry.RootSource != "" || entry.RootChecksum != strings.Repeat("ab", 32) {
+		t.Errorf("entry inputs = %q, %q", entry.RootSource, entry.RootChecksum)
+	}
+}
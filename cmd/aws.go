@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials sign requests to AWS APIs.
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// awsService describes an AWS API speaking the JSON protocol.
+type awsService struct {
+	// signingName is the service name of the signing scope, also the
+	// endpoint prefix.
+	signingName string
+	// endpointEnv is the suffix of the AWS_ENDPOINT_URL_* variable
+	// overriding the endpoint.
+	endpointEnv  string
+	targetPrefix string
+}
+
+var (
+	awsSecretsManager = awsService{signingName: "secretsmanager", endpointEnv: "SECRETS_MANAGER", targetPrefix: "secretsmanager"}
+	awsSSM            = awsService{signingName: "ssm", endpointEnv: "SSM", targetPrefix: "AmazonSSM"}
+)
+
+// awsError is an error answered by an AWS JSON API.
+type awsError struct {
+	Type    string
+	Message string
+}
+
+func (e *awsError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// awsRegion returns AWS_REGION, us-east-1 if unset.
+func awsRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// awsEndpoint returns the endpoint of the service with signing name prefix,
+// overridden by AWS_ENDPOINT_URL_<envName> or AWS_ENDPOINT_URL.
+func awsEndpoint(prefix, envName, region string) string {
+	for _, name := range []string{"AWS_ENDPOINT_URL_" + envName, "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			return strings.TrimSuffix(endpoint, "/")
+		}
+	}
+	return fmt.Sprintf("https://%s.%s.amazonaws.com", prefix, region)
+}
+
+// loadAWSCredentials returns the credentials of AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN or else, as set up by IAM
+// roles for service accounts on EKS, of the AWS_ROLE_ARN assumed with the
+// web identity token in AWS_WEB_IDENTITY_TOKEN_FILE.
+func loadAWSCredentials(region string) (awsCredentials, error) {
+	if accessKey := os.Getenv("AWS_ACCESS_KEY_ID"); accessKey != "" {
+		return awsCredentials{accessKey: accessKey, secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	roleARN, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return awsCredentials{}, fmt.Errorf("AWS_ACCESS_KEY_ID, or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE, must be set")
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("could not read web identity token: %v", err)
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = fieldManager
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {sessionName},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	resp, err := httpClient.PostForm(awsEndpoint("sts", "STS", region)+"/", form)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return awsCredentials{}, fmt.Errorf("AssumeRoleWithWebIdentity of %s: %s: %s", roleARN, resp.Status, bytes.TrimSpace(body))
+	}
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string `xml:"AccessKeyId"`
+			SecretAccessKey string `xml:"SecretAccessKey"`
+			SessionToken    string `xml:"SessionToken"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return awsCredentials{}, fmt.Errorf("could not decode AssumeRoleWithWebIdentity response: %v", err)
+	}
+	return awsCredentials{accessKey: result.Credentials.AccessKeyID, secretKey: result.Credentials.SecretAccessKey, sessionToken: result.Credentials.SessionToken}, nil
+}
+
+// awsJSONClient calls the actions of an AWS JSON protocol API.
+type awsJSONClient struct {
+	service     awsService
+	endpoint    string
+	region      string
+	credentials awsCredentials
+	now         func() time.Time
+}
+
+// newAWSJSONClient returns an awsJSONClient for service configured from the
+// environment.
+func newAWSJSONClient(service awsService) (*awsJSONClient, error) {
+	region := awsRegion()
+	credentials, err := loadAWSCredentials(region)
+	if err != nil {
+		return nil, err
+	}
+	return &awsJSONClient{
+		service:     service,
+		endpoint:    awsEndpoint(service.signingName, service.endpointEnv, region),
+		region:      region,
+		credentials: credentials,
+		now:         time.Now,
+	}, nil
+}
+
+// call sends input to action, decoding the answer into output. Errors
+// answered by the API are *awsError.
+func (c *awsJSONClient) call(action string, input, output any) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", c.service.targetPrefix+"."+action)
+	signAWSRequest(req, body, c.service.signingName, c.region, c.credentials, c.now())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var answer struct {
+			Type string `json:"__type"`
+			// Services answer message or Message, both decoded
+			Message string `json:"message"`
+		}
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(content, &answer) != nil || answer.Type == "" {
+			return fmt.Errorf("%s: %s", action, resp.Status)
+		}
+		// Types may be qualified, e.g. com.amazonaws.secretsmanager#ResourceNotFoundException
+		errorType := answer.Type[strings.LastIndex(answer.Type, "#")+1:]
+		return fmt.Errorf("%s: %w", action, &awsError{Type: errorType, Message: answer.Message})
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
+
+// PutAWSSecret stores value as the new AWSCURRENT version of the Secrets
+// Manager secret id, creating the secret if it does not exist. Secrets
+// Manager keeps the replaced version as AWSPREVIOUS.
+//
+// Parameters:
+//   - id: The name or ARN of the secret.
+//   - value: The SecretString of the new version.
+//
+// Returns:
+//   - The VersionId of the new version.
+//   - An error if the secret could not be written.
+func PutAWSSecret(id, value string) (string, error) {
+	client, err := newAWSJSONClient(awsSecretsManager)
+	if err != nil {
+		return "", err
+	}
+	var output struct {
+		VersionID string `json:"VersionId"`
+	}
+	err = client.call("PutSecretValue", map[string]string{"SecretId": id, "SecretString": value}, &output)
+	var apiErr *awsError
+	if errors.As(err, &apiErr) && apiErr.Type == "ResourceNotFoundException" {
+		err = client.call("CreateSecret", map[string]string{"Name": id, "SecretString": value}, &output)
+	}
+	return output.VersionID, err
+}
+
+// PutSSMParameters stores every parameter, name to value, as a String
+// parameter of SSM Parameter Store, overwriting existing ones. The
+// Intelligent-Tiering tier makes values over 4 KB advanced parameters.
+//
+// Parameters:
+//   - parameters: The parameter names and values.
+//
+// Returns:
+//   - An error if a parameter could not be written.
+func PutSSMParameters(parameters map[string]string) error {
+	client, err := newAWSJSONClient(awsSSM)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		input := map[string]any{"Name": name, "Value": parameters[name], "Type": "String", "Overwrite": true, "Tier": "Intelligent-Tiering"}
+		var output struct {
+			Version int64 `json:"Version"`
+		}
+		if err := client.call("PutParameter", input, &output); err != nil {
+			return fmt.Errorf("could not put %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ssmParameters returns the SSM parameters --aws-ssm-path stores under path
+// for the trust root name: its root.json and the digests pinning it, which
+// fit the parameter size limits unlike the archive.
+func ssmParameters(path string, event GenerationEvent, rootJSON []byte) map[string]string {
+	path = strings.TrimSuffix(path, "/")
+	return map[string]string{
+		path + "/name":              event.TrustRoot,
+		path + "/root.json":         string(rootJSON),
+		path + "/root-digest":       event.RootDigest,
+		path + "/repository-digest": event.RepositoryDigest,
+	}
+}
+
+// storeAWS writes the trust material of the repository assembled in workDir
+// to the --aws-secret-id secret and the --aws-ssm-path parameters, exiting
+// on errors.
+func storeAWS(secretID, ssmPath, output, name, manifest, workDir string, rootJSONFile *os.File) {
+	if secretID == "" && ssmPath == "" {
+		return
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	if secretID != "" {
+		data, err := trustMaterialData(manifest, workDir, rootJSON)
+		if err != nil {
+			fatalf(err, "Error: %v", err)
+		}
+		value, err := json.Marshal(data)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		version, err := PutAWSSecret(secretID, string(value))
+		if err != nil {
+			fatalf(err, "Error: could not write Secrets Manager secret %s: %v", secretID, err)
+		}
+		log.Printf("wrote trust material to Secrets Manager secret %s version %s\n", secretID, version)
+	}
+	if ssmPath != "" {
+		event, err := newGenerationEvent(name, output, workDir, rootJSON)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if err := PutSSMParameters(ssmParameters(ssmPath, event, rootJSON)); err != nil {
+			fatalf(err, "Error: could not write SSM parameters %s: %v", ssmPath, err)
+		}
+		log.Printf("wrote SSM parameters under %s\n", ssmPath)
+	}
+}
+
+// signAWSRequest adds the AWS Signature Version 4 headers of service in
+// region to req, signed with credentials at now.
+// See https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signAWSRequest(req *http.Request, body []byte, service, region string, credentials awsCredentials, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalRequestHash[:])
+	key := hmacSHA256([]byte("AWS4"+credentials.secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", credentials.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
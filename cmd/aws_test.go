@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// awsTestServer answers the AWS JSON actions of handler, by X-Amz-Target,
+// recording the inputs of every call in order.
+func awsTestServer(t *testing.T, handler func(target string, input map[string]any) (int, string)) (*httptest.Server, *[]string) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("unsigned request: %q", r.Header.Get("Authorization"))
+		}
+		var input map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			t.Errorf("Failed to decode input: %v", err)
+		}
+		target := r.Header.Get("X-Amz-Target")
+		calls = append(calls, target)
+		status, body := handler(target, input)
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	return server, &calls
+}
+
+func TestPutAWSSecret(t *testing.T) {
+	tests := []struct {
+		name      string
+		exists    bool
+		wantCalls []string
+	}{
+		{"new version", true, []string{"secretsmanager.PutSecretValue"}},
+		{"created", false, []string{"secretsmanager.PutSecretValue", "secretsmanager.CreateSecret"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := awsTestServer(t, func(target string, input map[string]any) (int, string) {
+				if input["SecretString"] != `{"manifest":"kind: TrustRoot"}` {
+					t.Errorf("SecretString = %v", input["SecretString"])
+				}
+				if target == "secretsmanager.PutSecretValue" && !tt.exists {
+					return http.StatusBadRequest, `{"__type":"ResourceNotFoundException","Message":"Secrets Manager can't find the specified secret."}`
+				}
+				return http.StatusOK, `{"VersionId":"v2"}`
+			})
+			defer server.Close()
+
+			version, err := PutAWSSecret("sigstore/trustroot", `{"manifest":"kind: TrustRoot"}`)
+			if err != nil {
+				t.Fatalf("PutAWSSecret() error = %v", err)
+			}
+			if version != "v2" || !reflect.DeepEqual(*calls, tt.wantCalls) {
+				t.Errorf("PutAWSSecret() = %s with calls %v, want v2 with %v", version, *calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPutAWSSecretError(t *testing.T) {
+	server, _ := awsTestServer(t, func(string, map[string]any) (int, string) {
+		return http.StatusBadRequest, `{"__type":"com.amazonaws.secretsmanager#AccessDeniedException","message":"not authorized"}`
+	})
+	defer server.Close()
+
+	_, err := PutAWSSecret("sigstore/trustroot", "{}")
+	if err == nil || !strings.Contains(err.Error(), "AccessDeniedException: not authorized") {
+		t.Errorf("PutAWSSecret() error = %v, want AccessDeniedException", err)
+	}
+}
+
+func TestPutSSMParameters(t *testing.T) {
+	var names []string
+	server, _ := awsTestServer(t, func(target string, input map[string]any) (int, string) {
+		if target != "AmazonSSM.PutParameter" || input["Overwrite"] != true || input["Type"] != "String" {
+			t.Errorf("unexpected call %s %v", target, input)
+		}
+		names = append(names, input["Name"].(string))
+		return http.StatusOK, `{"Version":4,"Tier":"Standard"}`
+	})
+	defer server.Close()
+
+	event := GenerationEvent{TrustRoot: "sigstore-1", RootDigest: "aa", RepositoryDigest: "bb"}
+	if err := PutSSMParameters(ssmParameters("/sigstore/trustroot/", event, []byte("{}"))); err != nil {
+		t.Fatalf("PutSSMParameters() error = %v", err)
+	}
+	want := []string{"/sigstore/trustroot/name", "/sigstore/trustroot/repository-digest", "/sigstore/trustroot/root-digest", "/sigstore/trustroot/root.json"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("parameters = %v, want %v", names, want)
+	}
+}
+
+func TestLoadAWSCredentialsWebIdentity(t *testing.T) {
+	var form map[string][]string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		io.WriteString(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <SessionToken>session</SessionToken>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <Expiration>2025-01-01T00:00:00Z</Expiration>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer sts.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("eyJhbGciOi\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/trustroot")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+
+	credentials, err := loadAWSCredentials("eu-west-1")
+	if err != nil {
+		t.Fatalf("loadAWSCredentials() error = %v", err)
+	}
+	want := awsCredentials{accessKey: "ASIAEXAMPLE", secretKey: "secret", sessionToken: "session"}
+	if credentials != want {
+		t.Errorf("loadAWSCredentials() = %+v, want %+v", credentials, want)
+	}
+	if form["WebIdentityToken"][0] != "eyJhbGciOi" || form["RoleArn"][0] != "arn:aws:iam::123456789012:role/trustroot" || form["RoleSessionName"][0] != fieldManager {
+		t.Errorf("AssumeRoleWithWebIdentity form = %v", form)
+	}
+
+	t.Setenv("AWS_ROLE_ARN", "")
+	if _, err := loadAWSCredentials("eu-west-1"); err == nil {
+		t.Error("loadAWSCredentials() without credentials error = nil, want an error")
+	}
+}
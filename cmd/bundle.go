@@ -0,0 +1,420 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// Entries of the air-gap bundles written by WriteBundle.
+const (
+	// bundleRepositoryDir holds the verified repository, laid out as
+	// assembled.
+	bundleRepositoryDir = "repository"
+	// bundleTrustRootPath is the TrustRoot manifest of the repository.
+	bundleTrustRootPath = "manifests/trustroot.yaml"
+	// bundleManifestPath is the BundleManifest.
+	bundleManifestPath = "versions.json"
+	// bundleChecksumsPath lists the SHA-256 of every other file of the
+	// bundle, in the format of sha256sum.
+	bundleChecksumsPath = "SHA256SUMS"
+	// bundleSignaturePath is the base64 signature of bundleChecksumsPath.
+	bundleSignaturePath = "SHA256SUMS.sig"
+)
+
+// BundleManifest describes the repository of an air-gap bundle.
+type BundleManifest struct {
+	TrustRoot string    `json:"trustRoot"`
+	Source    string    `json:"source"`
+	Created   time.Time `json:"created"`
+	// RootDigest and RepositoryDigest are those of GenerationEvent.
+	RootDigest       string               `json:"rootDigest"`
+	RepositoryDigest string               `json:"repositoryDigest"`
+	Versions         map[string]int64     `json:"versions"`
+	Expires          map[string]time.Time `json:"expires,omitempty"`
+}
+
+// LoadSigningKey reads the unencrypted PEM private key at path, PKCS #8 or
+// SEC 1 EC, signing air-gap bundles.
+//
+// Parameters:
+//   - path: The path of the key file.
+//
+// Returns:
+//   - The signer of the key.
+//   - An error if the file is not an unencrypted ECDSA, Ed25519 or RSA private key.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s, expected an unencrypted PRIVATE KEY", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// signBundle returns the base64 signature of content, over its SHA-256 for
+// ECDSA and RSA (PKCS #1 v1.5) keys and over content itself for Ed25519
+// keys, as verified by `cosign verify-blob --key`.
+func signBundle(signer crypto.Signer, content []byte) ([]byte, error) {
+	var signature []byte
+	var err error
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		signature, err = signer.Sign(rand.Reader, content, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(content)
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(signature)), nil
+}
+
+// bundleWriter writes the entries of a bundle, recording their checksums.
+type bundleWriter struct {
+	tw        *tar.Writer
+	modTime   time.Time
+	checksums strings.Builder
+}
+
+// writeDir writes the directory entry name.
+func (b *bundleWriter) writeDir(name string) error {
+	return b.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: b.modTime})
+}
+
+// writeFile writes the file entry name of size bytes from r, adding it to
+// the checksums unless unlisted.
+func (b *bundleWriter) writeFile(name string, size int64, r io.Reader, unlisted bool) error {
+	if err := b.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: size, ModTime: b.modTime}); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(b.tw, hash), r); err != nil {
+		return err
+	}
+	if !unlisted {
+		fmt.Fprintf(&b.checksums, "%s  %s\n", hex.EncodeToString(hash.Sum(nil)), name)
+	}
+	return nil
+}
+
+// writeContent writes the file entry name with content.
+func (b *bundleWriter) writeContent(name string, content []byte, unlisted bool) error {
+	return b.writeFile(name, int64(len(content)), bytes.NewReader(content), unlisted)
+}
+
+// WriteBundle writes the air-gap bundle of the repository assembled in
+// workDir to w: a tar.gz archive of the repository in repository/, its
+// TrustRoot manifest, a BundleManifest of its metadata versions, the
+// SHA-256 of these files in SHA256SUMS and the signature of SHA256SUMS, so
+// the bundle can be checked once carried into a disconnected network.
+//
+// Parameters:
+//   - w: The writer of the bundle.
+//   - name: The name of the TrustRoot.
+//   - source: The mirror the repository was assembled from.
+//   - workDir: The directory of the assembled repository.
+//   - rootJSON: The verified root.json of the repository.
+//   - signer: The key signing SHA256SUMS.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func WriteBundle(w io.Writer, name, source, workDir string, rootJSON []byte, signer crypto.Signer) error {
+	trustRootYAML, err := trustRootRenderer.Render(TrustMaterial{Name: name, Dir: workDir, RootJSON: rootJSON})
+	if err != nil {
+		return err
+	}
+	event, err := newGenerationEvent(name, outputTrustRoot, workDir, rootJSON)
+	if err != nil {
+		return err
+	}
+	expiries, err := metadataExpiries(workDir)
+	if err != nil {
+		return err
+	}
+	created := now().UTC().Truncate(time.Second)
+	manifest, err := json.MarshalIndent(BundleManifest{
+		TrustRoot:        name,
+		Source:           source,
+		Created:          created,
+		RootDigest:       event.RootDigest,
+		RepositoryDigest: event.RepositoryDigest,
+		Versions:         event.Versions,
+		Expires:          expiries,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	b := &bundleWriter{tw: tar.NewWriter(gw), modTime: created}
+	if err := b.writeDir(bundleRepositoryDir); err != nil {
+		return err
+	}
+	err = filepath.WalkDir(workDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || filePath == workDir {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, filePath)
+		if err != nil {
+			return err
+		}
+		entryName := path.Join(bundleRepositoryDir, filepath.ToSlash(rel))
+		if entry.IsDir() {
+			return b.writeDir(entryName)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return b.writeFile(entryName, info.Size(), file, false)
+	})
+	if err != nil {
+		return fmt.Errorf("could not archive repository: %v", err)
+	}
+	if err := b.writeDir(path.Dir(bundleTrustRootPath)); err != nil {
+		return err
+	}
+	if err := b.writeContent(bundleTrustRootPath, trustRootYAML, false); err != nil {
+		return err
+	}
+	if err := b.writeContent(bundleManifestPath, append(manifest, '\n'), false); err != nil {
+		return err
+	}
+	checksums := []byte(b.checksums.String())
+	signature, err := signBundle(signer, checksums)
+	if err != nil {
+		return fmt.Errorf("could not sign %s: %v", bundleChecksumsPath, err)
+	}
+	if err := b.writeContent(bundleChecksumsPath, checksums, true); err != nil {
+		return err
+	}
+	if err := b.writeContent(bundleSignaturePath, signature, true); err != nil {
+		return err
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// verifyBundleSignature checks signature, the base64 signature of content
+// written by signBundle, against the PEM encoded publicKeyPEM.
+func verifyBundleSignature(publicKeyPEM, content, signature []byte) error {
+	der, err := publicKeyDER(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("%s is not base64: %v", bundleSignaturePath, err)
+	}
+	digest := sha256.Sum256(content)
+	var valid bool
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], decoded)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], decoded) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, content, decoded)
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	if !valid {
+		return fmt.Errorf("invalid signature of %s", bundleChecksumsPath)
+	}
+	return nil
+}
+
+// ReadBundle extracts the air-gap bundle written by WriteBundle from r into
+// dir and checks it: SHA256SUMS must be signed by publicKeyPEM and list
+// exactly the other files of the bundle with their SHA-256.
+//
+// Parameters:
+//   - r: The reader of the bundle.
+//   - dir: The directory the bundle is extracted in.
+//   - publicKeyPEM: The PEM encoded public key of the bundle signing key.
+//
+// Returns:
+//   - The BundleManifest of the bundle.
+//   - An error wrapping assembler.ErrVerification if the bundle does not verify.
+func ReadBundle(r io.Reader, dir string, publicKeyPEM []byte) (BundleManifest, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("bundle is not a tar.gz archive: %v", err)
+	}
+	defer gr.Close()
+	sums := map[string]string{}
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return BundleManifest{}, fmt.Errorf("could not read bundle: %v", err)
+		}
+		name := strings.TrimSuffix(header.Name, "/")
+		if !fs.ValidPath(name) || name == "." {
+			return BundleManifest{}, fmt.Errorf("%w: invalid bundle entry %q", assembler.ErrVerification, header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return BundleManifest{}, err
+			}
+		case tar.TypeReg:
+			if _, ok := sums[name]; ok {
+				return BundleManifest{}, fmt.Errorf("%w: duplicate bundle entry %s", assembler.ErrVerification, name)
+			}
+			sum, err := extractBundleFile(tr, target)
+			if err != nil {
+				return BundleManifest{}, err
+			}
+			sums[name] = sum
+		default:
+			return BundleManifest{}, fmt.Errorf("%w: bundle entry %s is not a file or directory", assembler.ErrVerification, name)
+		}
+	}
+
+	checksums, err := os.ReadFile(filepath.Join(dir, bundleChecksumsPath))
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("%w: bundle has no %s", assembler.ErrVerification, bundleChecksumsPath)
+	}
+	signature, err := os.ReadFile(filepath.Join(dir, bundleSignaturePath))
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("%w: bundle has no %s", assembler.ErrVerification, bundleSignaturePath)
+	}
+	if err := verifyBundleSignature(publicKeyPEM, checksums, signature); err != nil {
+		return BundleManifest{}, fmt.Errorf("%w: %v", assembler.ErrVerification, err)
+	}
+	delete(sums, bundleChecksumsPath)
+	delete(sums, bundleSignaturePath)
+	listed := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(checksums)), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if !ok {
+			return BundleManifest{}, fmt.Errorf("%w: invalid %s line %q", assembler.ErrVerification, bundleChecksumsPath, line)
+		}
+		if got, ok := sums[name]; !ok || got != sum {
+			return BundleManifest{}, fmt.Errorf("%w: checksum of %s does not match %s", assembler.ErrVerification, name, bundleChecksumsPath)
+		}
+		listed++
+	}
+	if listed != len(sums) {
+		return BundleManifest{}, fmt.Errorf("%w: bundle has files not listed in %s", assembler.ErrVerification, bundleChecksumsPath)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, bundleManifestPath))
+	if err != nil {
+		return BundleManifest{}, fmt.Errorf("%w: bundle has no %s", assembler.ErrVerification, bundleManifestPath)
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return BundleManifest{}, fmt.Errorf("could not read %s: %v", bundleManifestPath, err)
+	}
+	return manifest, nil
+}
+
+// extractBundleFile writes the current entry of tr to path and returns its
+// hex SHA-256.
+func extractBundleFile(tr *tar.Reader, path string) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), tr); err != nil {
+		file.Close()
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), file.Close()
+}
+
+// BundleTrustRoot returns the TrustRoot manifest of a bundle extracted in
+// dir by ReadBundle: the bundled manifest, or with regenerate the manifest
+// rendered again from the bundled repository once verified offline.
+//
+// Parameters:
+//   - dir: The directory of the extracted bundle.
+//   - name: The name of the regenerated TrustRoot.
+//   - regenerate: Whether to render the manifest from the repository.
+//
+// Returns:
+//   - The TrustRoot manifest.
+//   - An error if the manifest could not be read or the repository does not verify.
+func BundleTrustRoot(dir, name string, regenerate bool) ([]byte, error) {
+	if !regenerate {
+		return os.ReadFile(filepath.Join(dir, bundleTrustRootPath))
+	}
+	repositoryDir := filepath.Join(dir, bundleRepositoryDir)
+	if err := verifyAssembledDirectory(repositoryDir); err != nil {
+		return nil, fmt.Errorf("bundled repository does not verify: %w", err)
+	}
+	rootPath, err := latestMetadataPath(repositoryDir, "root.json")
+	if err != nil {
+		return nil, err
+	}
+	rootJSON, err := os.ReadFile(rootPath)
+	if err != nil {
+		return nil, err
+	}
+	return trustRootRenderer.Render(TrustMaterial{Name: name, Dir: repositoryDir, RootJSON: rootJSON})
+}
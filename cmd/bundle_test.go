@@ -0,0 +1,277 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+// writePEM writes a PEM block of type blockType with der to a file.
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return path
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecPKCS8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	edPKCS8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	ecSEC1, _ := x509.MarshalECPrivateKey(ecKey)
+	public, _ := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	notPEM := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(notPEM, []byte("key"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"pkcs8 ecdsa", writePEM(t, "PRIVATE KEY", ecPKCS8), false},
+		{"pkcs8 ed25519", writePEM(t, "PRIVATE KEY", edPKCS8), false},
+		{"sec1 ecdsa", writePEM(t, "EC PRIVATE KEY", ecSEC1), false},
+		{"public key", writePEM(t, "PUBLIC KEY", public), true},
+		{"encrypted key", writePEM(t, "ENCRYPTED PRIVATE KEY", ecPKCS8), true},
+		{"not pem", notPEM, true},
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSigningKey(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadSigningKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testBundle returns a bundle of the mockmirror repository signed by key,
+// with the root.json of the repository.
+func testBundle(t *testing.T, key *ecdsa.PrivateKey) ([]byte, []byte) {
+	t.Helper()
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read root.json: %v", err)
+	}
+	var bundle bytes.Buffer
+	if err := WriteBundle(&bundle, "bundled", server.URL, workDir, rootJSON, key); err != nil {
+		t.Fatalf("WriteBundle() error = %v", err)
+	}
+	return bundle.Bytes(), rootJSON
+}
+
+// bundleEntry is a regular file of a bundle.
+type bundleEntry struct {
+	name    string
+	content []byte
+}
+
+// readBundleEntries returns the regular files of bundle in archive order.
+func readBundleEntries(t *testing.T, bundle []byte) []bundleEntry {
+	t.Helper()
+	gr, err := gzip.NewReader(bytes.NewReader(bundle))
+	if err != nil {
+		t.Fatalf("bundle is not gzip: %v", err)
+	}
+	var entries []bundleEntry
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("bundle is not a tar archive: %v", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			content, _ := io.ReadAll(tr)
+			entries = append(entries, bundleEntry{header.Name, content})
+		}
+	}
+}
+
+// writeBundleEntries returns a bundle of entries.
+func writeBundleEntries(t *testing.T, entries []bundleEntry) []byte {
+	t.Helper()
+	var bundle bytes.Buffer
+	gw := gzip.NewWriter(&bundle)
+	tw := tar.NewWriter(gw)
+	for _, entry := range entries {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: entry.name, Mode: 0o644, Size: int64(len(entry.content))}); err != nil {
+			t.Fatalf("Failed to write bundle: %v", err)
+		}
+		tw.Write(entry.content)
+	}
+	tw.Close()
+	gw.Close()
+	return bundle.Bytes()
+}
+
+func TestWriteBundle(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	bundle, rootJSON := testBundle(t, key)
+	files := map[string][]byte{}
+	for _, entry := range readBundleEntries(t, bundle) {
+		files[entry.name] = entry.content
+	}
+
+	checksums := files[bundleChecksumsPath]
+	signature, err := base64.StdEncoding.DecodeString(string(files[bundleSignaturePath]))
+	if err != nil {
+		t.Fatalf("signature is not base64: %v", err)
+	}
+	digest := sha256.Sum256(checksums)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], signature) {
+		t.Error("signature of SHA256SUMS does not verify")
+	}
+	lines := strings.Split(strings.TrimSpace(string(checksums)), "\n")
+	if len(lines) != len(files)-2 {
+		t.Errorf("SHA256SUMS lists %d files, want the %d other files", len(lines), len(files)-2)
+	}
+	for _, line := range lines {
+		sum, name, _ := strings.Cut(line, "  ")
+		content, ok := files[name]
+		got := sha256.Sum256(content)
+		if !ok || hex.EncodeToString(got[:]) != sum {
+			t.Errorf("checksum of %s does not match", name)
+		}
+	}
+
+	if !bytes.Equal(files["repository/1.root.json"], rootJSON) {
+		t.Error("bundle has no repository root.json")
+	}
+	if !strings.Contains(string(files[bundleTrustRootPath]), "name: bundled") {
+		t.Errorf("TrustRoot manifest = %.200s", files[bundleTrustRootPath])
+	}
+	var manifest BundleManifest
+	if err := json.Unmarshal(files[bundleManifestPath], &manifest); err != nil {
+		t.Fatalf("versions.json is not JSON: %v", err)
+	}
+	if manifest.TrustRoot != "bundled" || !strings.HasPrefix(manifest.Source, "http://") || manifest.Versions["root.json"] == 0 || len(manifest.RepositoryDigest) != 64 {
+		t.Errorf("versions.json = %s", files[bundleManifestPath])
+	}
+}
+
+func TestReadBundle(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	publicKeyPEM := func(key *ecdsa.PrivateKey) []byte {
+		der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	bundle, _ := testBundle(t, key)
+	entries := readBundleEntries(t, bundle)
+	modified := func(modify func([]bundleEntry) []bundleEntry) []byte {
+		return writeBundleEntries(t, modify(append([]bundleEntry(nil), entries...)))
+	}
+
+	tests := []struct {
+		name      string
+		bundle    []byte
+		publicKey []byte
+		wantErr   bool
+	}{
+		{"valid", bundle, publicKeyPEM(key), false},
+		{"other signing key", bundle, publicKeyPEM(otherKey), true},
+		{"tampered file", modified(func(entries []bundleEntry) []bundleEntry {
+			for i := range entries {
+				if entries[i].name == bundleTrustRootPath {
+					entries[i] = bundleEntry{bundleTrustRootPath, []byte("kind: TrustRoot")}
+				}
+			}
+			return entries
+		}), publicKeyPEM(key), true},
+		{"unlisted file", modified(func(entries []bundleEntry) []bundleEntry {
+			return append(entries, bundleEntry{"repository/2.root.json", []byte("{}")})
+		}), publicKeyPEM(key), true},
+		{"missing file", modified(func(entries []bundleEntry) []bundleEntry {
+			return entries[1:]
+		}), publicKeyPEM(key), true},
+		{"path outside the bundle", modified(func(entries []bundleEntry) []bundleEntry {
+			return append(entries, bundleEntry{"../escaped", []byte("{}")})
+		}), publicKeyPEM(key), true},
+		{"missing signature", modified(func(entries []bundleEntry) []bundleEntry {
+			return entries[:len(entries)-1]
+		}), publicKeyPEM(key), true},
+		{"not a bundle", []byte("bundle"), publicKeyPEM(key), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := ReadBundle(bytes.NewReader(tt.bundle), t.TempDir(), tt.publicKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && manifest.TrustRoot != "bundled" {
+				t.Errorf("ReadBundle() = %+v", manifest)
+			}
+		})
+	}
+}
+
+func TestBundleTrustRoot(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	bundle, rootJSON := testBundle(t, key)
+	dir := t.TempDir()
+	if _, err := ReadBundle(bytes.NewReader(bundle), dir, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+		t.Fatalf("ReadBundle() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		trustRoot  string
+		regenerate bool
+		want       string
+	}{
+		{"bundled manifest", "ignored", false, "name: bundled"},
+		{"regenerated manifest", "renamed", true, "name: renamed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, err := BundleTrustRoot(dir, tt.trustRoot, tt.regenerate)
+			if err != nil {
+				t.Fatalf("BundleTrustRoot() error = %v", err)
+			}
+			trustRoot, err := ReadTrustRoot(manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(manifest), tt.want) || trustRoot.Spec.Repository == nil || !bytes.Equal(trustRoot.Spec.Repository.Root, rootJSON) {
+				t.Errorf("BundleTrustRoot() = %.300s", manifest)
+			}
+		})
+	}
+}
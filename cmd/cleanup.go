@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// skipCleanupUsage is the usage of the --skip-cleanup flag.
+const skipCleanupUsage = "Keep the temporary working directory and spilled outputs of a failed run and print their paths, to inspect what was downloaded"
+
+// skipCleanup keeps the temporary files of a failed run, set by
+// --skip-cleanup.
+var skipCleanup bool
+
+var (
+	keptPathsMu sync.Mutex
+	keptPaths   []string
+)
+
+// keepOnFailure records path as a temporary file or directory to keep if the
+// run fails, when --skip-cleanup is set. The path is logged right away, since
+// most failures exit without reaching fatalf.
+func keepOnFailure(path string) {
+	if !skipCleanup {
+		return
+	}
+	keptPathsMu.Lock()
+	defer keptPathsMu.Unlock()
+	keptPaths = append(keptPaths, path)
+	log.Printf("--skip-cleanup: %s is kept if the run fails\n", path)
+}
+
+// removeOnFailure returns the cleanup of path to run when the run fails, e.g.
+// on the --run-timeout deadline: it removes path, unless --skip-cleanup keeps
+// it.
+func removeOnFailure(path string) func() {
+	keepOnFailure(path)
+	return func() {
+		if !skipCleanup {
+			os.RemoveAll(path)
+		}
+	}
+}
+
+// logKeptPaths logs the temporary files kept by --skip-cleanup, if any.
+func logKeptPaths() {
+	keptPathsMu.Lock()
+	defer keptPathsMu.Unlock()
+	for _, path := range keptPaths {
+		log.Printf("kept %s for inspection\n", path)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveOnFailure(t *testing.T) {
+	defer func(skip bool) { skipCleanup = skip }(skipCleanup)
+	defer func(paths []string) { keptPaths = paths }(keptPaths)
+
+	tests := []struct {
+		name        string
+		skipCleanup bool
+		wantKept    bool
+	}{
+		{name: "removed", skipCleanup: false, wantKept: false},
+		{name: "kept with --skip-cleanup", skipCleanup: true, wantKept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skipCleanup = tt.skipCleanup
+			keptPaths = nil
+			dir := filepath.Join(t.TempDir(), "tuf-repository")
+			if err := os.Mkdir(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+
+			removeOnFailure(dir)()
+			if _, err := os.Stat(dir); (err == nil) != tt.wantKept {
+				t.Errorf("stat %s: %v, want kept %v", dir, err, tt.wantKept)
+			}
+			if got := len(keptPaths) == 1 && keptPaths[0] == dir; got != tt.wantKept {
+				t.Errorf("keptPaths = %v, want kept %v", keptPaths, tt.wantKept)
+			}
+		})
+	}
+}
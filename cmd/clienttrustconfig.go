@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// clientTrustConfigMediaType is the media type of a Sigstore ClientTrustConfig.
+const clientTrustConfigMediaType = "application/vnd.dev.sigstore.clienttrustconfig.v0.1+json"
+
+// clientTrustConfig combines a trusted root with a signing config, so signing
+// clients can be configured from a single document.
+type clientTrustConfig struct {
+	MediaType     string          `json:"mediaType"`
+	TrustedRoot   json.RawMessage `json:"trustedRoot"`
+	SigningConfig json.RawMessage `json:"signingConfig"`
+}
+
+// BuildClientTrustConfig assembles a ClientTrustConfig JSON document from the
+// trusted_root.json and signing_config targets of a TUF repository.
+//
+// When several signing config versions are present (e.g. signing_config.json and
+// signing_config.v0.2.json) the lexically last one is used.
+//
+// Parameters:
+//   - targetsDir: The directory holding the verified targets of the repository.
+//
+// Returns:
+//   - The indented ClientTrustConfig JSON document.
+//   - An error if either target is missing or is not valid JSON.
+func BuildClientTrustConfig(targetsDir string) ([]byte, error) {
+	trustedRoot, err := os.ReadFile(filepath.Join(targetsDir, "trusted_root.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read trusted root: %v", err)
+	}
+	signingConfigs, err := filepath.Glob(filepath.Join(targetsDir, "signing_config*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(signingConfigs) == 0 {
+		return nil, fmt.Errorf("no signing_config target found in %s", targetsDir)
+	}
+	sort.Strings(signingConfigs)
+	signingConfig, err := os.ReadFile(signingConfigs[len(signingConfigs)-1])
+	if err != nil {
+		return nil, fmt.Errorf("could not read signing config: %v", err)
+	}
+	if !json.Valid(trustedRoot) || !json.Valid(signingConfig) {
+		return nil, fmt.Errorf("trusted root or signing config is not valid JSON")
+	}
+	return json.MarshalIndent(clientTrustConfig{
+		MediaType:     clientTrustConfigMediaType,
+		TrustedRoot:   trustedRoot,
+		SigningConfig: signingConfig,
+	}, "", "  ")
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildClientTrustConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		files             map[string]string
+		wantSigningConfig string
+		wantErr           bool
+	}{
+		{
+			name: "latest signing config",
+			files: map[string]string{
+				"trusted_root.json":        `{"mediaType": "root"}`,
+				"signing_config.json":      `{"mediaType": "v0.1"}`,
+				"signing_config.v0.2.json": `{"mediaType": "v0.2"}`,
+			},
+			wantSigningConfig: "v0.2",
+		},
+		{
+			name:    "missing signing config",
+			files:   map[string]string{"trusted_root.json": `{}`},
+			wantErr: true,
+		},
+		{
+			name:    "missing trusted root",
+			files:   map[string]string{"signing_config.json": `{}`},
+			wantErr: true,
+		},
+		{
+			name: "invalid JSON",
+			files: map[string]string{
+				"trusted_root.json":   `{`,
+				"signing_config.json": `{}`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatalf("Failed to write %s: %v", name, err)
+				}
+			}
+			got, err := BuildClientTrustConfig(dir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BuildClientTrustConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			var config struct {
+				MediaType     string `json:"mediaType"`
+				SigningConfig struct {
+					MediaType string `json:"mediaType"`
+				} `json:"signingConfig"`
+			}
+			if err := json.Unmarshal(got, &config); err != nil {
+				t.Fatalf("Failed to decode client trust config: %v", err)
+			}
+			if config.MediaType != clientTrustConfigMediaType {
+				t.Errorf("BuildClientTrustConfig() mediaType = %v, want %v", config.MediaType, clientTrustConfigMediaType)
+			}
+			if config.SigningConfig.MediaType != tt.wantSigningConfig {
+				t.Errorf("BuildClientTrustConfig() signing config = %v, want %v", config.SigningConfig.MediaType, tt.wantSigningConfig)
+			}
+		})
+	}
+}
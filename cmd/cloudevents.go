@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// cloudEventGenerated is the CloudEvent type sent for every generated trust
+// root.
+const cloudEventGenerated = "dev.sigstore.trustroot-assembler.generated"
+
+// GenerationEvent is the data of the CloudEvent sent for a generated trust
+// root.
+type GenerationEvent struct {
+	TrustRoot string `json:"trustRoot"`
+	Output    string `json:"output"`
+	// RootDigest is the hex SHA-256 of root.json, RepositoryDigest the
+	// digest of the repository used by --name-strategy digest.
+	RootDigest       string           `json:"rootDigest"`
+	RepositoryDigest string           `json:"repositoryDigest"`
+	Versions         map[string]int64 `json:"versions"`
+}
+
+// CloudEventSink is an HTTP endpoint, such as a Knative broker, receiving
+// CloudEvents in binary content mode.
+type CloudEventSink struct {
+	URL string
+}
+
+// Send POSTs a CloudEvent of type cloudEventGenerated with event as data.
+//
+// Parameters:
+//   - source: The source of the event, the mirror or --map file of the trust root.
+//   - event: The data of the event.
+//
+// Returns:
+//   - error: nil if the sink answered with a 2xx status.
+func (s CloudEventSink) Send(source string, event GenerationEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", hex.EncodeToString(id))
+	req.Header.Set("ce-type", cloudEventGenerated)
+	req.Header.Set("ce-source", source)
+	req.Header.Set("ce-subject", event.TrustRoot)
+	req.Header.Set("ce-time", time.Now().UTC().Format(time.RFC3339))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("CloudEvent sink %s answered %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// newGenerationEvent describes the trust root name rendered in the format
+// output from the repository assembled in workDir.
+func newGenerationEvent(name, output, workDir string, rootJSON []byte) (GenerationEvent, error) {
+	versions, err := metadataVersions(workDir)
+	if err != nil {
+		return GenerationEvent{}, err
+	}
+	repositoryDigest, err := RepositoryDigest(workDir)
+	if err != nil {
+		return GenerationEvent{}, fmt.Errorf("could not digest repository: %v", err)
+	}
+	rootDigest := sha256.Sum256(rootJSON)
+	return GenerationEvent{
+		TrustRoot:        name,
+		Output:           output,
+		RootDigest:       hex.EncodeToString(rootDigest[:]),
+		RepositoryDigest: repositoryDigest,
+		Versions:         versions,
+	}, nil
+}
+
+// sendGenerationEvents sends the GenerationEvent of the repository assembled
+// in workDir to every --cloudevents-sink, exiting on errors.
+func sendGenerationEvents(sinks []string, source, output, name, workDir string, rootJSONFile *os.File) {
+	if len(sinks) == 0 {
+		return
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	event, err := newGenerationEvent(name, output, workDir, rootJSON)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	for _, sink := range sinks {
+		if err := (CloudEventSink{URL: sink}).Send(source, event); err != nil {
+			log.Fatalf("Error: could not send CloudEvent: %v", err)
+		}
+		log.Printf("sent CloudEvent %s to %s\n", cloudEventGenerated, sink)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestCloudEventSinkSend(t *testing.T) {
+	event := GenerationEvent{TrustRoot: "sigstore-1", Output: outputTrustRoot, RootDigest: "aa", RepositoryDigest: "bb", Versions: map[string]int64{"snapshot.json": 3}}
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusAccepted, false},
+		{"rejected", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			var got GenerationEvent
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("Failed to decode event data: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer sink.Close()
+
+			err := CloudEventSink{URL: sink.URL}.Send("https://tuf-repo-cdn.sigstore.dev", event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for name, want := range map[string]string{
+				"Ce-Specversion": "1.0",
+				"Ce-Type":        cloudEventGenerated,
+				"Ce-Source":      "https://tuf-repo-cdn.sigstore.dev",
+				"Ce-Subject":     "sigstore-1",
+				"Content-Type":   "application/json",
+			} {
+				if header.Get(name) != want {
+					t.Errorf("header %s = %q, want %q", name, header.Get(name), want)
+				}
+			}
+			if header.Get("Ce-Id") == "" || header.Get("Ce-Time") == "" {
+				t.Errorf("ce-id and ce-time must be set, got %q and %q", header.Get("Ce-Id"), header.Get("Ce-Time"))
+			}
+			if got.TrustRoot != event.TrustRoot || got.Versions["snapshot.json"] != 3 {
+				t.Errorf("event data = %+v, want %+v", got, event)
+			}
+		})
+	}
+}
+
+func TestNewGenerationEvent(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+
+	event, err := newGenerationEvent("sigstore", outputConfigMap, workDir, mockmirror.RootJSON())
+	if err != nil {
+		t.Fatalf("newGenerationEvent() error = %v", err)
+	}
+	repositoryDigest, err := RepositoryDigest(workDir)
+	if err != nil {
+		t.Fatalf("RepositoryDigest() error = %v", err)
+	}
+	if event.RepositoryDigest != repositoryDigest || len(event.RootDigest) != 64 || event.Output != outputConfigMap {
+		t.Errorf("newGenerationEvent() = %+v", event)
+	}
+	for _, role := range inspectRoles {
+		if event.Versions[role] != 1 {
+			t.Errorf("version of %s = %d, want 1", role, event.Versions[role])
+		}
+	}
+}
+
+func TestWatcherCloudEvents(t *testing.T) {
+	mirror := httptest.NewServer(RepositoryHandler(newTestRepository(t)))
+	defer mirror.Close()
+	var types []string
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		types = append(types, r.Header.Get("ce-type"))
+	}))
+	defer sink.Close()
+	watcher := &Watcher{Mirror: mirror.URL, Name: "sigstore", CloudEventSinks: []CloudEventSink{{URL: sink.URL}}}
+
+	for i := 0; i < 2; i++ {
+		if err := watcher.Refresh(); err != nil {
+			t.Fatalf("Refresh() error = %v", err)
+		}
+	}
+	// The second refresh finds the repository unchanged
+	if len(types) != 1 || types[0] != cloudEventGenerated {
+		t.Errorf("CloudEvents = %v, want one %s", types, cloudEventGenerated)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// noColorUsage is the usage of the --no-color flag of every command.
+const noColorUsage = "Do not colorize messages, which are only colorized on terminals and without NO_COLOR"
+
+// ANSI escape sequences of the colorized messages.
+const (
+	colorReset   = "\x1b[0m"
+	colorError   = "\x1b[1;31m"
+	colorWarning = "\x1b[33m"
+	colorSummary = "\x1b[32m"
+)
+
+// warningMarkers and summaryPrefixes classify log messages, errors are the
+// messages starting with "Error:".
+var (
+	warningMarkers  = []string{"could not", "failed", "skipping", "spilled", "warning:"}
+	summaryPrefixes = []string{"assembled ", "mirrored ", "published ", "recorded ", "resolved ", "verified ", "ClientTrustConfig written", "root.json written"}
+)
+
+// colorEnabled reports whether messages written to f are colorized: f is a
+// terminal, noColor is unset and so are NO_COLOR and TERM=dumb.
+// See https://no-color.org
+func colorEnabled(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// setupLogOutput sends log messages to stderr, colorized if colorEnabled.
+func setupLogOutput(noColor bool) {
+	if colorEnabled(os.Stderr, noColor) {
+		log.SetOutput(colorWriter{w: os.Stderr})
+	} else {
+		log.SetOutput(os.Stderr)
+	}
+}
+
+// colorWriter colorizes the log messages written to w by class: errors,
+// warnings and summaries. Other messages are written unchanged.
+type colorWriter struct {
+	w io.Writer
+}
+
+// Write colorizes p, a message of the log package.
+func (c colorWriter) Write(p []byte) (int, error) {
+	color := messageColor(string(p))
+	if color == "" {
+		return c.w.Write(p)
+	}
+	message := bytes.TrimRight(p, "\n")
+	if _, err := io.WriteString(c.w, color+string(message)+colorReset+string(p[len(message):])); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// messageColor returns the color of a log message, "" for none.
+func messageColor(message string) string {
+	if strings.HasPrefix(message, "Error:") {
+		return colorError
+	}
+	for _, prefix := range summaryPrefixes {
+		if strings.HasPrefix(message, prefix) {
+			return colorSummary
+		}
+	}
+	for _, marker := range warningMarkers {
+		if strings.Contains(message, marker) {
+			return colorWarning
+		}
+	}
+	return ""
+}
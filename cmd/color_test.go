@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColorWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{"error", "Error: could not get timestamp.json\n", colorError + "Error: could not get timestamp.json" + colorReset + "\n"},
+		{"warning", "refresh of https://tuf.example.com failed: timeout\n", colorWarning + "refresh of https://tuf.example.com failed: timeout" + colorReset + "\n"},
+		{"warning message", "warning: skipping expired target ctfe.pub\n", colorWarning + "warning: skipping expired target ctfe.pub" + colorReset + "\n"},
+		{"summary", "assembled https://tuf.example.com, 4 targets\n", colorSummary + "assembled https://tuf.example.com, 4 targets" + colorReset + "\n"},
+		{"plain", "mirror https://tuf.example.com, root 12.root.json\n", "mirror https://tuf.example.com, root 12.root.json\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got strings.Builder
+			n, err := colorWriter{w: &got}.Write([]byte(tt.message))
+			if err != nil || n != len(tt.message) {
+				t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(tt.message))
+			}
+			if got.String() != tt.want {
+				t.Errorf("Write() wrote %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("TERM", "xterm")
+	if colorEnabled(file, false) {
+		t.Errorf("colorEnabled() of a regular file = true, want false")
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("no terminal: %v", err)
+	}
+	defer tty.Close()
+	if !colorEnabled(tty, false) {
+		t.Errorf("colorEnabled() of a terminal = false, want true")
+	}
+	if colorEnabled(tty, true) {
+		t.Errorf("colorEnabled() with --no-color = true, want false")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if colorEnabled(tty, false) {
+		t.Errorf("colorEnabled() with NO_COLOR = true, want false")
+	}
+}
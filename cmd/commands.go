@@ -0,0 +1,835 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+)
+
+// runSubcommand runs the subcommand named by args[0], if any, and reports
+// whether it did.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "mirror-sync":
+		mirrorSyncCommand(args[1:])
+	case "serve":
+		serveCommand(args[1:])
+	case "api":
+		apiCommand(args[1:])
+	case "admission-webhook":
+		admissionWebhookCommand(args[1:])
+	case "watch":
+		watchCommand(args[1:])
+	case "rollback":
+		rollbackCommand(args[1:])
+	case "mockmirror":
+		mockMirrorCommand(args[1:])
+	case "compare":
+		compareCommand(args[1:])
+	case "inspect":
+		inspectCommand(args[1:])
+	case "export":
+		exportCommand(args[1:])
+	case "tenants":
+		tenantsCommand(args[1:])
+	case "bundle":
+		bundleCommand(args[1:])
+	case "report":
+		reportCommand(args[1:])
+	case "convert":
+		convertCommand(args[1:])
+	case "rewire":
+		rewireCommand(args[1:])
+	case "e2e-verify":
+		e2eVerifyCommand(args[1:])
+	case "preflight":
+		preflightCommand(args[1:])
+	case "audit-verify":
+		auditVerifyCommand(args[1:])
+	default:
+		return false
+	}
+	return true
+}
+
+// newSubcommandFlagSet returns a flag set for a subcommand with the usage
+// format of the main command.
+func newSubcommandFlagSet(name, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [options]\n\n%s\n\n", os.Args[0], name, description)
+		fs.PrintDefaults()
+	}
+	fs.Duration("http-timeout", defaultHTTPTimeout, httpTimeoutUsage)
+	fs.Bool("debug", false, debugUsage)
+	fs.String("log-http", "", logHTTPUsage)
+	fs.String("work-dir", "", workDirUsage)
+	fs.Bool("no-color", false, noColorUsage)
+	return fs
+}
+
+// stringsFlag is a flag.Value collecting the values of a repeated flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// mirrorSyncCommand implements `mirror-sync`.
+func mirrorSyncCommand(args []string) {
+	fs := newSubcommandFlagSet("mirror-sync", "Download and verify a full copy of a TUF repository, laid out for re-serving.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	dir := fs.String("dir", "", "Destination directory of the mirrored repository")
+	publish := fs.String("publish", "", "Also upload the mirrored repository to s3://, gs://, webdav(s):// or sftp:// storage")
+	fs.StringVar(&rootSource, "root-source", "", rootSourceUsage)
+	fs.StringVar(&rootChecksum, "root-checksum", "", rootChecksumUsage)
+	parseSubcommandFlags(fs, args)
+	if *dir == "" {
+		log.Fatalf("Error: --dir is required")
+	}
+	if rootChecksum != "" && !validRootChecksum(rootChecksum) {
+		log.Fatalf("Error: --root-checksum must be a hex encoded SHA-256, got %q", rootChecksum)
+	}
+	if err := MirrorSync(*mirror, *dir); err != nil {
+		fatalf(err, "Error: could not mirror %s: %v", *mirror, err)
+	}
+	if *publish != "" {
+		if err := PublishDirectory(*dir, *publish); err != nil {
+			log.Fatalf("Error: could not publish %s: %v", *dir, err)
+		}
+	}
+}
+
+// serveCommand implements `serve`.
+func serveCommand(args []string) {
+	fs := newSubcommandFlagSet("serve", "Verify a mirrored repository and serve it over HTTP as a TUF mirror.")
+	dir := fs.String("dir", "", "Directory of the repository to serve")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	parseSubcommandFlags(fs, args)
+	if *dir == "" {
+		log.Fatalf("Error: --dir is required")
+	}
+	if err := ServeRepository(*dir, *addr); err != nil {
+		log.Fatalf("Error: could not serve %s: %v", *dir, err)
+	}
+}
+
+// apiCommand implements `api`.
+func apiCommand(args []string) {
+	fs := newSubcommandFlagSet("api", "Serve an HTTP API generating TrustRoots on demand with POST /assemble.")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	tokenFile := fs.String("token-file", "", "File holding the bearer token assemble requests must carry")
+	var allowedMirrors stringsFlag
+	fs.Var(&allowedMirrors, "allow-mirror", "Mirror URL requests may assemble besides the presets, repeatable")
+	parseSubcommandFlags(fs, args)
+	if *tokenFile == "" {
+		log.Fatalf("Error: --token-file is required, assemble requests are authenticated with a bearer token")
+	}
+	token, err := os.ReadFile(*tokenFile)
+	if err != nil {
+		log.Fatalf("Error: could not read --token-file: %v", err)
+	}
+	if len(bytes.TrimSpace(token)) == 0 {
+		log.Fatalf("Error: --token-file %s is empty", *tokenFile)
+	}
+	mirrors := make([]string, 0, len(allowedMirrors))
+	for _, mirror := range allowedMirrors {
+		if !isHTTPSource(mirror) && !strings.HasPrefix(mirror, "s3://") && !strings.HasPrefix(mirror, "oci://") {
+			log.Fatalf("Error: --allow-mirror %q is not an http(s)://, s3:// or oci:// URL", mirror)
+		}
+		mirrors = append(mirrors, strings.TrimSuffix(mirror, "/"))
+	}
+	if err := ServeAPI(*addr, string(bytes.TrimSpace(token)), mirrors); err != nil {
+		log.Fatalf("Error: could not serve the API: %v", err)
+	}
+}
+
+// admissionWebhookCommand implements `admission-webhook`.
+func admissionWebhookCommand(args []string) {
+	fs := newSubcommandFlagSet("admission-webhook", "Serve a validating admission webhook rejecting TrustRoots that do not decode and verify.")
+	addr := fs.String("addr", ":8443", "Address to listen on")
+	tlsCert := fs.String("tls-cert", "", "PEM certificate of the webhook Service")
+	tlsKey := fs.String("tls-key", "", "PEM private key of --tls-cert")
+	archivePrefix := fs.String("archive-prefix", "", "Directory of the repository inside the mirrorFS archives, as given to --archive-prefix when assembling them")
+	parseSubcommandFlags(fs, args)
+	if *tlsCert == "" || *tlsKey == "" {
+		log.Fatalf("Error: --tls-cert and --tls-key are required, the API server only calls webhooks over HTTPS")
+	}
+	if err := (assembler.ArchiveLayout{Prefix: *archivePrefix}).Validate(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := ServeAdmissionWebhook(*addr, *tlsCert, *tlsKey, *archivePrefix); err != nil {
+		log.Fatalf("Error: could not serve the admission webhook: %v", err)
+	}
+}
+
+// mockMirrorCommand implements `mockmirror`.
+func mockMirrorCommand(args []string) {
+	fs := newSubcommandFlagSet("mockmirror", "Serve a small signed test TUF repository, so tests do not depend on the Sigstore CDN.")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	parseSubcommandFlags(fs, args)
+	log.Printf("serving the mockmirror repository on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, mockmirror.Handler()); err != nil {
+		log.Fatalf("Error: could not serve the mockmirror repository: %v", err)
+	}
+}
+
+// compareCommand implements `compare`.
+func compareCommand(args []string) {
+	fs := newSubcommandFlagSet("compare", "Compare a generated manifest with a golden one, ignoring volatile fields. Exits with 1 if they differ.")
+	golden := fs.String("golden", "", "Path of the golden manifest")
+	generated := fs.String("generated", "-", "Path of the generated manifest, - for stdin")
+	parseSubcommandFlags(fs, args)
+	if *golden == "" {
+		log.Fatalf("Error: --golden is required")
+	}
+	goldenManifest, err := os.ReadFile(*golden)
+	if err != nil {
+		log.Fatalf("Error: could not read golden manifest: %v", err)
+	}
+	var generatedManifest []byte
+	if *generated == "-" {
+		generatedManifest, err = io.ReadAll(os.Stdin)
+	} else {
+		generatedManifest, err = os.ReadFile(*generated)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read generated manifest: %v", err)
+	}
+	diff, err := CompareManifests(goldenManifest, generatedManifest)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if len(diff) > 0 {
+		fmt.Println(strings.Join(diff, "\n"))
+		os.Exit(1)
+	}
+	log.Printf("%s matches %s\n", *generated, *golden)
+}
+
+// convertCommand implements `convert`.
+func convertCommand(args []string) {
+	fs := newSubcommandFlagSet("convert", "Convert a repository based TrustRoot into a sigstoreKeys based one, or the reverse, printing it to stdout.")
+	in := fs.String("in", "-", "Path of the TrustRoot manifest to convert, - for stdin")
+	to := fs.String("to", convertToSigstoreKeys, "Spec of the converted TrustRoot: "+convertToSigstoreKeys+" or "+convertToRepository)
+	name := fs.String("name", "", "metadata.name of the converted TrustRoot, that of the input by default")
+	archivePrefix := fs.String("archive-prefix", "", "Directory of the repository inside the mirrorFS archive, as given to --archive-prefix when assembling it")
+	expires := fs.Duration("expires", 365*24*time.Hour, "repository: Validity of the metadata of the converted repository")
+	parseSubcommandFlags(fs, args)
+	var manifest []byte
+	var err error
+	if *in == "-" {
+		manifest, err = io.ReadAll(os.Stdin)
+	} else {
+		manifest, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read TrustRoot manifest: %v", err)
+	}
+	trustRoot, err := ReadTrustRoot(manifest)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *name == "" {
+		*name = trustRoot.Metadata.Name
+	}
+	spec := trustRoot.Spec
+	switch *to {
+	case convertToSigstoreKeys:
+		if spec.Repository == nil {
+			log.Fatalf("Error: --to %s converts TrustRoots with a repository spec", convertToSigstoreKeys)
+		}
+		layout := assembler.ArchiveLayout{Prefix: *archivePrefix, TargetsDir: spec.Repository.Targets}
+		if err := layout.Validate(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		keys, err := RepositorySigstoreKeys(bytes.NewReader(spec.Repository.MirrorFS), spec.Repository.Root, layout, now())
+		if err != nil {
+			fatalf(err, "Error: could not read the repository: %v", err)
+		}
+		if keys.empty() {
+			log.Fatalf("Error: the repository has no trust material in effect to convert")
+		}
+		fmt.Print(RenderSigstoreKeysTrustRoot(*name, keys))
+	case convertToRepository:
+		if spec.SigstoreKeys == nil {
+			log.Fatalf("Error: --to %s converts TrustRoots with a sigstoreKeys spec", convertToRepository)
+		}
+		if *expires <= 0 {
+			log.Fatalf("Error: --expires must be positive")
+		}
+		workDir, err := mkdirTemp("tuf-repository-*")
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		defer os.RemoveAll(workDir)
+		rootJSONFile, err := BuildSigstoreKeysRepository(trustRoot.sigstoreKeys(), workDir, now().Add(*expires))
+		if err != nil {
+			log.Fatalf("Error: could not convert the sigstoreKeys: %v", err)
+		}
+		defer rootJSONFile.Close()
+		output, err := RenderRepositoryTrustRoot(*name, workDir, rootJSONFile)
+		if err != nil {
+			log.Fatalf("Error: could not render the TrustRoot: %v", err)
+		}
+		fmt.Print(output)
+	default:
+		log.Fatalf("Error: unknown --to %q, expected %s or %s", *to, convertToSigstoreKeys, convertToRepository)
+	}
+}
+
+// inspectCommand implements `inspect`.
+func inspectCommand(args []string) {
+	fs := newSubcommandFlagSet("inspect", "Verify a TUF repository and report the expiry of its metadata, the Sigstore usage and status of its targets and the keys of its root.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	archive := fs.String("archive", "", "Path of a repository.tar.gz or of a TrustRoot manifest, - for stdin, whose archive entries are listed with their sizes and SHA-256 instead of inspecting --mirror")
+	parseSubcommandFlags(fs, args)
+	if *archive != "" {
+		inspectArchive(*archive)
+		return
+	}
+	rootJSON, err := fetchLatestRoot(*mirror)
+	if err != nil {
+		err = fmt.Errorf("could not get the latest root.json from %s: %w: %v", *mirror, assembler.ErrMirrorUnreachable, err)
+		fatalf(err, "Error: %v", err)
+	}
+	repository, err := openVerifiedRepository(*mirror, rootJSON)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	meta, err := repository.Local.GetMeta()
+	if err != nil {
+		log.Fatalf("Error: could not read the verified metadata: %v", err)
+	}
+	metadata := make(map[string][]byte, len(meta))
+	for name, content := range meta {
+		metadata[name] = content
+	}
+	if err := WriteInspectReport(os.Stdout, metadata, time.Now()); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	fmt.Println()
+	if err := WriteRootKeyReport(os.Stdout, metadata["root.json"]); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// rewireCommand implements `rewire`.
+func rewireCommand(args []string) {
+	fs := newSubcommandFlagSet("rewire", "Point the trustRootRef fields of ClusterImagePolicies, in YAML files or in the cluster, to a new TrustRoot, printing the kubectl patches.")
+	to := fs.String("to", "", "Name of the TrustRoot the policies must reference")
+	from := fs.String("from", "", "Pattern of the TrustRoot names to rewire, e.g. 'tuf-repo-cdn.sigstore.dev-*', every TrustRoot by default")
+	var files stringsFlag
+	fs.Var(&files, "file", "YAML file of ClusterImagePolicies to rewire instead of those of the cluster, repeatable")
+	write := fs.Bool("write", false, "Rewrite the --file files in place")
+	apply := fs.Bool("apply", false, "Patch the ClusterImagePolicies of the cluster of the current context or the tool runs in")
+	parseSubcommandFlags(fs, args)
+	if *to == "" {
+		log.Fatalf("Error: --to is required")
+	}
+	if len(files) > 0 && *apply || len(files) == 0 && *write {
+		log.Fatalf("Error: --write requires --file, and --apply cannot be used with --file")
+	}
+	var rewires []PolicyRewire
+	if len(files) == 0 {
+		kube, err := newKubeClient()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		rewires, err = RewireCluster(kube, *from, *to, *apply)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	for _, file := range files {
+		manifests, err := os.ReadFile(file)
+		if err != nil {
+			log.Fatalf("Error: could not read %s: %v", file, err)
+		}
+		fileRewires, rewired, err := RewireManifests(manifests, *from, *to)
+		if err != nil {
+			log.Fatalf("Error: could not rewire %s: %v", file, err)
+		}
+		if *write && len(fileRewires) > 0 {
+			if err := os.WriteFile(file, rewired, 0o644); err != nil {
+				log.Fatalf("Error: could not write %s: %v", file, err)
+			}
+		}
+		rewires = append(rewires, fileRewires...)
+	}
+	for _, rewire := range rewires {
+		fmt.Println(rewire.kubectlPatch())
+	}
+	if *write || *apply {
+		log.Printf("rewired %d ClusterImagePolicies to TrustRoot %s\n", len(rewires), *to)
+	} else {
+		log.Printf("%d ClusterImagePolicies to rewire to TrustRoot %s\n", len(rewires), *to)
+	}
+}
+
+// e2eVerifyCommand implements `e2e-verify`.
+func e2eVerifyCommand(args []string) {
+	fs := newSubcommandFlagSet("e2e-verify", "Apply a TrustRoot to a test cluster running the policy-controller and assert a signed image is admitted and an unsigned one denied.")
+	trustRootPath := fs.String("trustroot", "", "TrustRoot manifest to verify, - for stdin")
+	kubeconfig := fs.String("kubeconfig", "", "Kubeconfig of the test cluster, by default the cluster the tool runs in, $KUBECONFIG or ~/.kube/config")
+	kubeContext := fs.String("context", "", "Context of the kubeconfig, its current context by default")
+	namespace := fs.String("namespace", "trustroot-e2e", "Namespace of the sample pods, created if missing and labelled policy.sigstore.dev/include=true")
+	signedImage := fs.String("signed-image", "", "Fully qualified image signed keylessly with the Fulcio and Rekor of the TrustRoot, expected to be admitted")
+	unsignedImage := fs.String("unsigned-image", "", "Fully qualified unsigned image, expected to be denied")
+	issuer := fs.String("issuer-regexp", ".*", "Regular expression of the OIDC issuer of the signature of --signed-image")
+	subject := fs.String("subject-regexp", ".*", "Regular expression of the OIDC subject of the signature of --signed-image")
+	timeout := fs.Duration("timeout", 2*time.Minute, "How long to wait for the policy-controller to admit --signed-image once the TrustRoot and policy are applied")
+	keep := fs.Bool("keep", false, "Keep the TrustRoot, ClusterImagePolicy and namespace created by the check")
+	parseSubcommandFlags(fs, args)
+	if *trustRootPath == "" || *signedImage == "" || *unsignedImage == "" {
+		log.Fatalf("Error: --trustroot, --signed-image and --unsigned-image are required")
+	}
+	var manifest []byte
+	var err error
+	if *trustRootPath == "-" {
+		manifest, err = io.ReadAll(os.Stdin)
+	} else {
+		manifest, err = os.ReadFile(*trustRootPath)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read TrustRoot: %v", err)
+	}
+	var kube *kubeClient
+	switch {
+	case *kubeconfig != "":
+		kube, err = newKubeconfigContextKubeClient(*kubeconfig, *kubeContext)
+	case *kubeContext != "":
+		kube, err = newContextKubeClient(*kubeContext)
+	default:
+		kube, err = newKubeClient()
+	}
+	if err != nil {
+		log.Fatalf("Error: could not create Kubernetes client: %v", err)
+	}
+	options := E2EOptions{
+		Namespace:     *namespace,
+		SignedImage:   *signedImage,
+		UnsignedImage: *unsignedImage,
+		IssuerRegExp:  *issuer,
+		SubjectRegExp: *subject,
+		Timeout:       *timeout,
+		Keep:          *keep,
+	}
+	if err := E2EVerify(kube, manifest, options); err != nil {
+		fatalf(err, "Error: end-to-end verification on %s failed: %v", kube.host, err)
+	}
+	log.Printf("verified the TrustRoot end to end on %s\n", kube.host)
+}
+
+// preflightCommand implements `preflight`.
+func preflightCommand(args []string) {
+	fs := newSubcommandFlagSet("preflight", "Verify the keyless signatures and attestations of an image locally with only the trust material of a TrustRoot, before rolling it out.")
+	trustRootPath := fs.String("trustroot", "", "TrustRoot manifest whose trust material verifies the image, - for stdin")
+	archivePrefix := fs.String("archive-prefix", "", "Directory of the repository inside the mirrorFS archive, as given to --archive-prefix when assembling it")
+	image := fs.String("image", "", "Fully qualified image referenced by digest, e.g. ghcr.io/org/app@sha256:...")
+	issuer := fs.String("issuer-regexp", "", "Regular expression of the OIDC issuer of the signatures, e.g. ^https://token.actions.githubusercontent.com$")
+	subject := fs.String("subject-regexp", "", "Regular expression of the OIDC subject of the signatures, e.g. ^https://github.com/org/")
+	requireAttestation := fs.Bool("require-attestation", false, "Also require a verified attestation of the image")
+	parseSubcommandFlags(fs, args)
+	if *trustRootPath == "" || *image == "" || *issuer == "" || *subject == "" {
+		log.Fatalf("Error: --trustroot, --image, --issuer-regexp and --subject-regexp are required")
+	}
+	var manifest []byte
+	var err error
+	if *trustRootPath == "-" {
+		manifest, err = io.ReadAll(os.Stdin)
+	} else {
+		manifest, err = os.ReadFile(*trustRootPath)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read TrustRoot: %v", err)
+	}
+	trustRoot, err := ReadTrustRoot(manifest)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	var keys SigstoreKeys
+	keysAt, err := trustRootSigstoreKeys(trustRoot, *archivePrefix)
+	if err == nil {
+		keys, err = keysAt(now())
+	}
+	if err != nil {
+		fatalf(err, "Error: could not read the trust material of the TrustRoot: %v", err)
+	}
+	if keys.empty() {
+		log.Fatalf("Error: the TrustRoot has no trust material in effect to verify with")
+	}
+	registry, err := newOCIFetcher("https", *image)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	options := PreflightOptions{IssuerRegExp: *issuer, SubjectRegExp: *subject, RequireAttestation: *requireAttestation}
+	result, err := PreflightImage(registry, keysAt, options)
+	for _, rejected := range result.Rejected {
+		log.Printf("rejected %s\n", rejected)
+	}
+	if err != nil {
+		fatalf(err, "Error: preflight of %s failed: %v", *image, err)
+	}
+	for _, signature := range result.Signatures {
+		log.Printf("verified signature by %s from %s, logged at index %d on %s\n", signature.Subject, signature.Issuer, signature.LogIndex, signature.IntegratedTime.Format(time.RFC3339))
+	}
+	for _, attestation := range result.Attestations {
+		log.Printf("verified %s attestation by %s from %s, logged at index %d on %s\n", attestation.PredicateType, attestation.Subject, attestation.Issuer, attestation.LogIndex, attestation.IntegratedTime.Format(time.RFC3339))
+	}
+}
+
+// inspectArchive implements `inspect --archive`: the archive at path, or the
+// mirrorFS of the TrustRoot manifest at path, is listed to stderr.
+func inspectArchive(path string) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		log.Fatalf("Error: could not read archive: %v", err)
+	}
+	archive, err := MirrorFSArchive(content)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := WriteArchiveListing(os.Stderr, bytes.NewReader(archive)); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// reportCommand implements `report`.
+func reportCommand(args []string) {
+	fs := newSubcommandFlagSet("report", "Verify a TUF repository and report every certificate authority, log key and timestamp authority of its trusted root, with validity windows and usage.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	output := fs.String("output", reportOutputTable, "Report format: table or json")
+	parseSubcommandFlags(fs, args)
+	if *output != reportOutputTable && *output != reportOutputJSON {
+		log.Fatalf("Error: --output must be %s or %s", reportOutputTable, reportOutputJSON)
+	}
+	workDir, err := mkdirTemp("tuf-repository-*")
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	rootJSONFile, err := AssembleRepository(*mirror, workDir)
+	if err != nil {
+		fatalf(err, "Error: could not assemble %s: %v", *mirror, err)
+	}
+	rootJSONFile.Close()
+	report, err := readTrustReport(*mirror, workDir, time.Now())
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := WriteTrustReport(os.Stdout, report, *output); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// exportCommand implements `export`.
+func exportCommand(args []string) {
+	fs := newSubcommandFlagSet("export", "Download and verify a TUF repository into the local TUF cache of a Sigstore client.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	dir := fs.String("dir", defaultCacheDir(), "Cache directory, $TUF_ROOT or ~/.sigstore/root by default")
+	layout := fs.String("layout", cacheLayoutSigstoreGo, "Cache layout: sigstore-go (sigstore-go and recent cosign) or cosign (legacy cosign tuf.db)")
+	parseSubcommandFlags(fs, args)
+	if err := ExportCache(*mirror, *dir, *layout); err != nil {
+		fatalf(err, "Error: could not export %s: %v", *mirror, err)
+	}
+}
+
+// bundleCommand implements `bundle export` and `bundle import`.
+func bundleCommand(args []string) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	switch args[0] {
+	case "export":
+		bundleExportCommand(args[1:])
+	case "import":
+		bundleImportCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: %s bundle export|import [options]\n", os.Args[0])
+		os.Exit(2)
+	}
+}
+
+// bundleExportCommand implements `bundle export`.
+func bundleExportCommand(args []string) {
+	fs := newSubcommandFlagSet("bundle export", "Download and verify a TUF repository into a signed tarball for air-gapped networks, with its TrustRoot manifest, checksums and metadata versions.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	name := fs.String("name", "", "Name of the TrustRoot, <mirror>-<unix time> by default")
+	out := fs.String("out", "", "Path of the bundle tarball")
+	signingKey := fs.String("signing-key", "", "Unencrypted PEM private key (ECDSA, Ed25519 or RSA) signing the bundle checksums")
+	allowUnknownRoot := fs.Bool("allow-unknown-root", false, "Bundle the public-good repository even if its root.json does not chain to the known Sigstore root keys")
+	parseSubcommandFlags(fs, args)
+	if *out == "" || *signingKey == "" {
+		log.Fatalf("Error: --out and --signing-key are required")
+	}
+	signer, err := LoadSigningKey(*signingKey)
+	if err != nil {
+		log.Fatalf("Error: could not load signing key: %v", err)
+	}
+	if *name == "" {
+		*name = fmt.Sprintf("%s-%d", sourceName(*mirror), now().Unix())
+	}
+	workDir, err := mkdirTemp("tuf-repository-*")
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	rootJSONFile, err := AssembleRepository(*mirror, workDir)
+	if err != nil {
+		fatalf(err, "Error: could not assemble %s: %v", *mirror, err)
+	}
+	rootJSONFile.Close()
+	checkKnownRoot(*mirror, rootJSONFile.Name(), *allowUnknownRoot)
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := WriteBundle(file, *name, *mirror, workDir, rootJSON, signer); err != nil {
+		file.Close()
+		os.Remove(*out)
+		log.Fatalf("Error: could not write bundle: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatalf("Error: could not write bundle: %v", err)
+	}
+	log.Printf("bundled %s as TrustRoot %s in %s\n", *mirror, *name, *out)
+}
+
+// bundleImportCommand implements `bundle import`.
+func bundleImportCommand(args []string) {
+	fs := newSubcommandFlagSet("bundle import", "Verify an air-gap bundle written by bundle export and print or apply its TrustRoot.")
+	bundlePath := fs.String("bundle", "", "Path of the bundle tarball")
+	publicKey := fs.String("public-key", "", "PEM public key of the bundle signing key")
+	regenerate := fs.Bool("regenerate", false, "Verify the bundled repository offline and render its TrustRoot again instead of using the bundled manifest")
+	name := fs.String("name", "", "Name of the regenerated TrustRoot, the bundled name by default (requires --regenerate)")
+	apply := fs.Bool("apply", false, "Apply the TrustRoot to the cluster of the current context or the tool runs in instead of printing it")
+	parseSubcommandFlags(fs, args)
+	if *bundlePath == "" || *publicKey == "" {
+		log.Fatalf("Error: --bundle and --public-key are required")
+	}
+	if *name != "" && !*regenerate {
+		log.Fatalf("Error: --name requires --regenerate")
+	}
+	publicKeyPEM, err := os.ReadFile(*publicKey)
+	if err != nil {
+		log.Fatalf("Error: could not read public key: %v", err)
+	}
+	bundle, err := os.Open(*bundlePath)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer bundle.Close()
+	dir, err := mkdirTemp("trustroot-bundle-*")
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	manifest, err := ReadBundle(bundle, dir, publicKeyPEM)
+	if err != nil {
+		fatalf(err, "Error: could not verify bundle %s: %v", *bundlePath, err)
+	}
+	log.Printf("verified bundle of %s created %s, root.json v%d\n", manifest.Source, manifest.Created.Format(time.RFC3339), manifest.Versions["root.json"])
+	if *name == "" {
+		*name = manifest.TrustRoot
+	}
+	trustRootYAML, err := BundleTrustRoot(dir, *name, *regenerate)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	if !*apply {
+		fmt.Println(string(trustRootYAML))
+		return
+	}
+	kube, err := newKubeClient()
+	if err != nil {
+		log.Fatalf("Error: could not create Kubernetes client: %v", err)
+	}
+	if err := kube.applyTrustRoot(*name, trustRootYAML); err != nil {
+		log.Fatalf("Error: could not apply TrustRoot %s: %v", *name, err)
+	}
+	log.Printf("applied TrustRoot %s\n", *name)
+}
+
+// tenantsCommand implements `tenants`.
+func tenantsCommand(args []string) {
+	fs := newSubcommandFlagSet("tenants", "Generate, and apply to their clusters, the trust roots of every tenant of a tenants config.")
+	configPath := fs.String("config", "", "YAML tenants config")
+	apply := fs.Bool("apply", false, "Apply every tenant output to its destinations instead of printing them")
+	concurrency := fs.Int("concurrency", 4, "Number of tenants generated and applied at the same time")
+	parseSubcommandFlags(fs, args)
+	if *configPath == "" {
+		log.Fatalf("Error: --config is required")
+	}
+	if *concurrency < 1 {
+		log.Fatalf("Error: --concurrency must be at least 1")
+	}
+	config, err := LoadTenantsConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if failed := runTenants(config, *apply, *concurrency, os.Stdout); failed > 0 {
+		log.Fatalf("Error: %d of %d tenants failed", failed, len(config.Tenants))
+	}
+}
+
+// watchCommand implements `watch`.
+func watchCommand(args []string) {
+	fs := newSubcommandFlagSet("watch", "Regenerate a TrustRoot whenever the root or targets of a TUF repository change.")
+	mirror := fs.String("mirror", "https://tuf-repo-cdn.sigstore.dev", "Sigstore TUF Repository Mirror")
+	name := fs.String("name", "", "Name of the TrustRoot")
+	interval := fs.Duration("interval", time.Hour, "Interval between checks of the mirror")
+	jitter := fs.Float64("jitter", 0.1, "Fraction of the interval by which checks are randomly spread, so watchers started together do not poll the mirror in lockstep")
+	maxBackoff := fs.Duration("max-backoff", 6*time.Hour, "Cap of the delay after consecutive failed checks, which doubles from --interval after each failure and honors the Retry-After of the mirror")
+	out := fs.String("out", "", "File to write the TrustRoot YAML to")
+	apply := fs.Bool("apply", false, "Apply the TrustRoot to the cluster the tool runs in")
+	historyDir := fs.String("history-dir", defaultHistoryDir(), "State directory keeping the last generated TrustRoots for rollback, by default history under $XDG_STATE_HOME/trustroot-assembler if set")
+	historyKeep := fs.Int("history-keep", 10, "Number of generations kept in --history-dir")
+	auditLog := fs.String("audit-log", "", auditLogUsage)
+	fs.StringVar(&rootSource, "root-source", "", rootSourceUsage)
+	fs.StringVar(&rootChecksum, "root-checksum", "", rootChecksumUsage)
+	var webhooks, slackWebhooks stringsFlag
+	fs.Var(&webhooks, "webhook", "URL notified with a JSON event on changes, can be repeated")
+	fs.Var(&slackWebhooks, "slack-webhook", "Slack incoming webhook URL notified on changes, can be repeated")
+	natsURL := fs.String("nats-url", "", "NATS server publishing every event, nats://[user:password@|token@]host:port or tls://host:port")
+	natsSubject := fs.String("nats-subject", "trustroot.events", "NATS subject of the events")
+	kafkaProxyURL := fs.String("kafka-proxy-url", "", "Kafka REST proxy producing every event")
+	kafkaTopic := fs.String("kafka-topic", "trustroot-events", "Kafka topic of the events")
+	var cloudEventSinks stringsFlag
+	fs.Var(&cloudEventSinks, "cloudevents-sink", "URL receiving a CloudEvent for every generated TrustRoot, can be repeated")
+	parseSubcommandFlags(fs, args)
+	if *name == "" {
+		log.Fatalf("Error: --name is required")
+	}
+	if *out == "" && !*apply {
+		log.Fatalf("Error: --out or --apply is required")
+	}
+	if *jitter < 0 || *jitter >= 1 {
+		log.Fatalf("Error: --jitter must be at least 0 and less than 1")
+	}
+	if rootChecksum != "" && !validRootChecksum(rootChecksum) {
+		log.Fatalf("Error: --root-checksum must be a hex encoded SHA-256, got %q", rootChecksum)
+	}
+	watcher := &Watcher{Mirror: *mirror, Name: *name, Out: *out, AuditLog: *auditLog, Jitter: *jitter, MaxBackoff: *maxBackoff}
+	if *historyDir != "" {
+		watcher.History = &History{Dir: *historyDir, Keep: *historyKeep}
+	}
+	for _, url := range webhooks {
+		watcher.Notifiers = append(watcher.Notifiers, Webhook{URL: url})
+	}
+	for _, url := range slackWebhooks {
+		watcher.Notifiers = append(watcher.Notifiers, Webhook{URL: url, Slack: true})
+	}
+	if *natsURL != "" {
+		watcher.Notifiers = append(watcher.Notifiers, NATSNotifier{URL: *natsURL, Subject: *natsSubject})
+	}
+	if *kafkaProxyURL != "" {
+		watcher.Notifiers = append(watcher.Notifiers, KafkaNotifier{URL: *kafkaProxyURL, Topic: *kafkaTopic})
+	}
+	for _, url := range cloudEventSinks {
+		watcher.CloudEventSinks = append(watcher.CloudEventSinks, CloudEventSink{URL: url})
+	}
+	if *apply {
+		kube, err := newInClusterKubeClient()
+		if err != nil {
+			log.Fatalf("Error: could not create Kubernetes client: %v", err)
+		}
+		watcher.Kube = kube
+	}
+	watcher.Run(*interval, nil)
+}
+
+// rollbackCommand implements `rollback`.
+func rollbackCommand(args []string) {
+	fs := newSubcommandFlagSet("rollback", "Re-emit or re-apply a TrustRoot recorded in a history directory.")
+	historyDir := fs.String("history-dir", defaultHistoryDir(), "State directory of the recorded TrustRoots, by default history under $XDG_STATE_HOME/trustroot-assembler if set")
+	generation := fs.Int("generation", 0, "Generation to roll back to (defaults to the one before the latest)")
+	list := fs.Bool("list", false, "List the recorded generations instead of rolling back")
+	apply := fs.Bool("apply", false, "Apply the TrustRoot to the cluster the tool runs in instead of printing it")
+	parseSubcommandFlags(fs, args)
+	if *historyDir == "" {
+		log.Fatalf("Error: --history-dir is required")
+	}
+	history := History{Dir: *historyDir}
+	entries, err := history.List()
+	if err != nil {
+		log.Fatalf("Error: could not read history %s: %v", *historyDir, err)
+	}
+	if *list {
+		for _, entry := range entries {
+			fmt.Printf("%d\t%s\t%s\t%s\t%v\n", entry.Generation, entry.Created.Format(time.RFC3339), entry.Name, entry.Mirror, entry.Versions)
+		}
+		return
+	}
+
+	var target *HistoryEntry
+	for i := range entries {
+		if entries[i].Generation == *generation || (*generation == 0 && i == len(entries)-2) {
+			target = &entries[i]
+		}
+	}
+	if target == nil {
+		log.Fatalf("Error: generation to roll back to not found in %s, see --list", *historyDir)
+	}
+	manifest, err := history.Manifest(target.Generation)
+	if err != nil {
+		log.Fatalf("Error: could not read generation %d: %v", target.Generation, err)
+	}
+	log.Printf("rolling back to generation %d, TrustRoot %s created %s\n", target.Generation, target.Name, target.Created.Format(time.RFC3339))
+	if !*apply {
+		fmt.Println(string(manifest))
+		return
+	}
+	kube, err := newInClusterKubeClient()
+	if err != nil {
+		log.Fatalf("Error: could not create Kubernetes client: %v", err)
+	}
+	if err := kube.applyTrustRoot(target.Name, manifest); err != nil {
+		log.Fatalf("Error: could not apply TrustRoot %s: %v", target.Name, err)
+	}
+}
+
+// auditVerifyCommand implements `audit-verify`.
+func auditVerifyCommand(args []string) {
+	fs := newSubcommandFlagSet("audit-verify", "Verify the hash chain of an --audit-log and list the trust roots it records.")
+	auditLog := fs.String("audit-log", "", "Audit log to verify, a file or an s3://bucket/key or gs://bucket/key object")
+	var hashes stringsFlag
+	fs.Var(&hashes, "hash", "Hash of an entry the log must still hold, e.g. logged by the run that appended it, can be repeated")
+	parseSubcommandFlags(fs, args)
+	if *auditLog == "" {
+		log.Fatalf("Error: --audit-log is required")
+	}
+	entries, err := CheckAuditLog(*auditLog, hashes)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	for _, entry := range entries {
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", entry.Sequence, entry.Time.Format(time.RFC3339), entry.TrustRoot, entry.Source, entry.OutputDigest, entry.Hash)
+	}
+	log.Printf("verified the chain of %d entries of %s, the latest hash is %s\n", len(entries), *auditLog, entries[len(entries)-1].Hash)
+}
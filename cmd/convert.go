@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf"
+	"github.com/theupdateframework/go-tuf/data"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec styles of `convert --to`.
+const (
+	convertToSigstoreKeys = "sigstore-keys"
+	convertToRepository   = "repository"
+)
+
+// trustedRootMediaType is the media type of the trusted_root.json documents
+// written by TrustedRootJSON.
+const trustedRootMediaType = "application/vnd.dev.sigstore.trustedroot+json;version=0.1"
+
+// ReadTrustRoot decodes a TrustRoot manifest, YAML or JSON.
+//
+// Parameters:
+//   - manifest: The content of the manifest.
+//
+// Returns:
+//   - The decoded TrustRoot.
+//   - An error if the manifest is malformed.
+func ReadTrustRoot(manifest []byte) (trustRootObject, error) {
+	var trustRoot trustRootObject
+	var document any
+	if err := yaml.Unmarshal(manifest, &document); err != nil {
+		return trustRoot, fmt.Errorf("malformed TrustRoot manifest: %v", err)
+	}
+	// Byte fields are base64 strings, which encoding/json decodes
+	object, err := json.Marshal(document)
+	if err != nil {
+		return trustRoot, fmt.Errorf("malformed TrustRoot manifest: %v", err)
+	}
+	if err := json.Unmarshal(object, &trustRoot); err != nil {
+		return trustRoot, fmt.Errorf("malformed TrustRoot manifest: %v", err)
+	}
+	return trustRoot, nil
+}
+
+// sigstoreKeys returns the SigstoreKeys of the sigstoreKeys spec of t, empty
+// if it has none.
+func (t trustRootObject) sigstoreKeys() SigstoreKeys {
+	var keys SigstoreKeys
+	spec := t.Spec.SigstoreKeys
+	if spec == nil {
+		return keys
+	}
+	authority := func(ca trustRootAuthority) CertificateAuthority {
+		return CertificateAuthority{Organization: ca.Subject.Organization, CommonName: ca.Subject.CommonName, URI: ca.URI, CertChain: ca.CertChain}
+	}
+	transparencyLog := func(tlog trustRootLog) TransparencyLogInstance {
+		return TransparencyLogInstance{BaseURL: tlog.BaseURL, HashAlgorithm: tlog.HashAlgorithm, PublicKey: tlog.PublicKey, LogID: tlog.LogID}
+	}
+	for _, ca := range spec.CertificateAuthorities {
+		keys.CertificateAuthorities = append(keys.CertificateAuthorities, authority(ca))
+	}
+	for _, ca := range spec.TimestampAuthorities {
+		keys.TimestampAuthorities = append(keys.TimestampAuthorities, authority(ca))
+	}
+	for _, tlog := range spec.TLogs {
+		keys.TLogs = append(keys.TLogs, transparencyLog(tlog))
+	}
+	for _, tlog := range spec.CTLogs {
+		keys.CTLogs = append(keys.CTLogs, transparencyLog(tlog))
+	}
+	return keys
+}
+
+// RepositorySigstoreKeys extracts the trust material in effect from the
+// mirrorFS archive of a repository based TrustRoot: the entries of its
+// trusted_root.json target valid at now or, for repositories without one or
+// without entries, the targets Sigstore marked as active in their custom
+// metadata.
+//
+// Parameters:
+//   - archive: The mirrorFS tar.gz archive.
+//   - rootJSON: The trusted root.json of the repository.
+//   - layout: Where the repository is in the archive.
+//   - now: The time the extracted entries must be valid at.
+//
+// Returns:
+//   - The SigstoreKeys of the repository.
+//   - An error if the repository does not verify or its trust material cannot be read.
+func RepositorySigstoreKeys(archive io.Reader, rootJSON []byte, layout assembler.ArchiveLayout, now time.Time) (SigstoreKeys, error) {
+	c, targets, err := loadArchivedRepository(archive, rootJSON, layout)
+	if err != nil {
+		return SigstoreKeys{}, err
+	}
+	download := func(name string) ([]byte, error) {
+		var content bufferDestination
+		if err := c.Download(name, &content); err != nil {
+			return nil, fmt.Errorf("could not load target %s from the archive: %w", name, assembler.ClassifyTUFError(err))
+		}
+		return content.Bytes(), nil
+	}
+	if _, ok := targets["trusted_root.json"]; ok {
+		trustedRootJSON, err := download("trusted_root.json")
+		if err != nil {
+			return SigstoreKeys{}, err
+		}
+		keys, err := TrustedRootSigstoreKeys(trustedRootJSON, now)
+		if err != nil || !keys.empty() {
+			return keys, err
+		}
+	}
+
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys SigstoreKeys
+	customs := make(map[string]*sigstoreCustomMetadata, len(names))
+	for _, name := range names {
+		custom := targetSigstoreMetadata(targets[name])
+		customs[name] = custom
+		if custom == nil || !strings.EqualFold(custom.Status, "Active") {
+			continue
+		}
+		content, err := download(name)
+		if err != nil {
+			return SigstoreKeys{}, err
+		}
+		switch strings.ToLower(custom.Usage) {
+		case "fulcio":
+			ca, err := NewCertificateAuthority(custom.URI, content)
+			if err != nil {
+				return SigstoreKeys{}, fmt.Errorf("target %s: %v", name, err)
+			}
+			keys.CertificateAuthorities = append(keys.CertificateAuthorities, ca)
+		case "rekor", "ctfe":
+			tlog, err := newTransparencyLog(custom.URI, content)
+			if err != nil {
+				return SigstoreKeys{}, fmt.Errorf("target %s: %v", name, err)
+			}
+			if strings.EqualFold(custom.Usage, "rekor") {
+				keys.TLogs = append(keys.TLogs, tlog)
+			} else {
+				keys.CTLogs = append(keys.CTLogs, tlog)
+			}
+		}
+	}
+	// TSA targets of the tsa*.crt.pem convention have no custom metadata
+	if keys.TimestampAuthorities, err = timestampAuthorities(customs, download, now); err != nil {
+		return SigstoreKeys{}, err
+	}
+	return keys, nil
+}
+
+// empty reports whether keys hold no trust material.
+func (keys SigstoreKeys) empty() bool {
+	return len(keys.CertificateAuthorities)+len(keys.TLogs)+len(keys.CTLogs)+len(keys.TimestampAuthorities) == 0
+}
+
+// TrustedRootSigstoreKeys returns the entries of a Sigstore trusted_root.json
+// valid at now, leaving out expired and not yet valid ones.
+//
+// Parameters:
+//   - trustedRootJSON: The content of the trusted_root.json target.
+//   - now: The time the entries must be valid at.
+//
+// Returns:
+//   - The SigstoreKeys of the document.
+//   - An error if the document or one of its keys or chains is malformed.
+func TrustedRootSigstoreKeys(trustedRootJSON []byte, now time.Time) (SigstoreKeys, error) {
+	var root trustedRootDocument
+	if err := json.Unmarshal(trustedRootJSON, &root); err != nil {
+		return SigstoreKeys{}, fmt.Errorf("malformed trusted_root.json: %v", err)
+	}
+	logs := func(entries []trustedRootLog) ([]TransparencyLogInstance, error) {
+		var tlogs []TransparencyLogInstance
+		for _, entry := range entries {
+			if trustStatus(entry.PublicKey.ValidFor, now) != trustStatusActive {
+				continue
+			}
+			tlog, err := newTransparencyLog(entry.BaseURL, entry.PublicKey.RawBytes)
+			if err != nil {
+				return nil, fmt.Errorf("log %s: %v", entry.BaseURL, err)
+			}
+			// Rekor v2 logs are named by their checkpoint key ID, like NewRekorV2Log
+			switch {
+			case entry.CheckpointKeyID != nil && len(entry.CheckpointKeyID.KeyID) > 0:
+				tlog.LogID = hex.EncodeToString(entry.CheckpointKeyID.KeyID)
+			case len(entry.LogID.KeyID) > 0:
+				tlog.LogID = hex.EncodeToString(entry.LogID.KeyID)
+			}
+			tlogs = append(tlogs, tlog)
+		}
+		return tlogs, nil
+	}
+	var keys SigstoreKeys
+	var err error
+	if keys.CertificateAuthorities, err = trustedRootAuthorities(root.CertificateAuthorities, now); err != nil {
+		return SigstoreKeys{}, err
+	}
+	if keys.TimestampAuthorities, err = trustedRootAuthorities(root.TimestampAuthorities, now); err != nil {
+		return SigstoreKeys{}, err
+	}
+	if keys.TLogs, err = logs(root.TLogs); err != nil {
+		return SigstoreKeys{}, err
+	}
+	if keys.CTLogs, err = logs(root.CTLogs); err != nil {
+		return SigstoreKeys{}, err
+	}
+	return keys, nil
+}
+
+// trustedRootAuthorities returns the authorities of a trusted_root.json
+// valid at now, with their chains validated by NormalizeCertificateChain.
+func trustedRootAuthorities(entries []trustedRootAuthority, now time.Time) ([]CertificateAuthority, error) {
+	var cas []CertificateAuthority
+	for _, entry := range entries {
+		if trustStatus(entry.ValidFor, now) != trustStatusActive {
+			continue
+		}
+		var chain bytes.Buffer
+		for _, cert := range entry.CertChain.Certificates {
+			pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.RawBytes})
+		}
+		normalized, err := NormalizeCertificateChain(chain.Bytes(), now)
+		if err != nil {
+			return nil, fmt.Errorf("authority %s: %v", entry.URI, err)
+		}
+		cas = append(cas, CertificateAuthority{Organization: entry.Subject.Organization, CommonName: entry.Subject.CommonName, URI: entry.URI, CertChain: normalized})
+	}
+	return cas, nil
+}
+
+// newTransparencyLog returns the TransparencyLogInstance of the log at
+// baseURL with the PEM or DER encoded publicKey.
+func newTransparencyLog(baseURL string, publicKey []byte) (TransparencyLogInstance, error) {
+	normalized, err := NormalizePublicKey(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	logID, err := LogID(normalized)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	return TransparencyLogInstance{BaseURL: baseURL, HashAlgorithm: "sha-256", PublicKey: normalized, LogID: logID}, nil
+}
+
+// TrustedRootJSON returns the Sigstore trusted_root.json document of keys.
+// Authorities are valid from the NotBefore of their root certificate, and
+// logs, whose keys carry no validity, from the Unix epoch.
+//
+// Parameters:
+//   - keys: The trust material of the document.
+//
+// Returns:
+//   - The trusted_root.json document.
+//   - An error if a chain or key of keys is malformed.
+func TrustedRootJSON(keys SigstoreKeys) ([]byte, error) {
+	root := trustedRootDocument{
+		MediaType:              trustedRootMediaType,
+		TLogs:                  []trustedRootLog{},
+		CertificateAuthorities: []trustedRootAuthority{},
+		CTLogs:                 []trustedRootLog{},
+		TimestampAuthorities:   []trustedRootAuthority{},
+	}
+	authority := func(ca CertificateAuthority) (trustedRootAuthority, error) {
+		var entry trustedRootAuthority
+		certs, err := parseCertificates(ca.CertChain)
+		if err != nil {
+			return entry, fmt.Errorf("authority %s: %v", ca.URI, err)
+		}
+		entry.Subject.Organization, entry.Subject.CommonName, entry.URI = ca.Organization, ca.CommonName, ca.URI
+		for _, cert := range certs {
+			entry.CertChain.Certificates = append(entry.CertChain.Certificates, trustedRootCertificate{RawBytes: cert.Raw})
+		}
+		entry.ValidFor.Start = certs[len(certs)-1].NotBefore.UTC()
+		return entry, nil
+	}
+	transparencyLog := func(tlog TransparencyLogInstance) (trustedRootLog, error) {
+		var entry trustedRootLog
+		der, err := publicKeyDER(tlog.PublicKey)
+		if err != nil {
+			return entry, fmt.Errorf("log %s: %v", tlog.BaseURL, err)
+		}
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return entry, fmt.Errorf("log %s: %v", tlog.BaseURL, err)
+		}
+		logID := tlog.LogID
+		if logID == "" {
+			if logID, err = LogID(tlog.PublicKey); err != nil {
+				return entry, fmt.Errorf("log %s: %v", tlog.BaseURL, err)
+			}
+		}
+		if entry.LogID.KeyID, err = hex.DecodeString(logID); err != nil {
+			return entry, fmt.Errorf("log %s: malformed logID: %v", tlog.BaseURL, err)
+		}
+		// The logID of a Rekor v2 log is its checkpoint key ID, entries name the log by its key
+		if len(entry.LogID.KeyID) == checkpointKeyIDSize {
+			entry.CheckpointKeyID = &trustedRootLogID{KeyID: entry.LogID.KeyID}
+			if logID, err = LogID(tlog.PublicKey); err != nil {
+				return entry, fmt.Errorf("log %s: %v", tlog.BaseURL, err)
+			}
+			entry.LogID.KeyID, _ = hex.DecodeString(logID)
+		}
+		entry.BaseURL, entry.HashAlgorithm = tlog.BaseURL, "SHA2_256"
+		entry.PublicKey = trustedRootPublicKey{RawBytes: der, KeyDetails: keyDetails(pub), ValidFor: trustedRootValidity{Start: time.Unix(0, 0).UTC()}}
+		return entry, nil
+	}
+	for _, ca := range keys.CertificateAuthorities {
+		entry, err := authority(ca)
+		if err != nil {
+			return nil, err
+		}
+		root.CertificateAuthorities = append(root.CertificateAuthorities, entry)
+	}
+	for _, ca := range keys.TimestampAuthorities {
+		entry, err := authority(ca)
+		if err != nil {
+			return nil, err
+		}
+		root.TimestampAuthorities = append(root.TimestampAuthorities, entry)
+	}
+	for _, tlog := range keys.TLogs {
+		entry, err := transparencyLog(tlog)
+		if err != nil {
+			return nil, err
+		}
+		root.TLogs = append(root.TLogs, entry)
+	}
+	for _, tlog := range keys.CTLogs {
+		entry, err := transparencyLog(tlog)
+		if err != nil {
+			return nil, err
+		}
+		root.CTLogs = append(root.CTLogs, entry)
+	}
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// keyDetails returns the Sigstore PublicKeyDetails of pub.
+func keyDetails(pub any) string {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P384():
+			return "PKIX_ECDSA_P384_SHA_384"
+		case elliptic.P521():
+			return "PKIX_ECDSA_P521_SHA_512"
+		}
+		return "PKIX_ECDSA_P256_SHA_256"
+	case ed25519.PublicKey:
+		return "PKIX_ED25519"
+	case *rsa.PublicKey:
+		switch {
+		case key.N.BitLen() >= 4096:
+			return "PKIX_RSA_PKCS1V15_4096_SHA256"
+		case key.N.BitLen() >= 3072:
+			return "PKIX_RSA_PKCS1V15_3072_SHA256"
+		}
+		return "PKIX_RSA_PKCS1V15_2048_SHA256"
+	}
+	return "PUBLIC_KEY_DETAILS_UNSPECIFIED"
+}
+
+// BuildSigstoreKeysRepository writes to workDir a TUF repository serving
+// keys, for TrustRoots migrating from sigstoreKeys to repository: a
+// trusted_root.json target and one legacy target per key or chain with its
+// Sigstore custom metadata. Every role is signed by its own ephemeral key,
+// only the trusted root.json vouching for them.
+//
+// Parameters:
+//   - keys: The trust material of the repository.
+//   - workDir: The directory the repository is written to.
+//   - expires: When the metadata of the repository expires.
+//
+// Returns:
+//   - The root.json file of the repository, open for reading.
+//   - An error if keys are malformed or the repository does not verify.
+func BuildSigstoreKeysRepository(keys SigstoreKeys, workDir string, expires time.Time) (*os.File, error) {
+	trustedRoot, err := TrustedRootJSON(keys)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{"trusted_root.json": trustedRoot}
+	custom := map[string]json.RawMessage{}
+	addTarget := func(base, extension, usage, uri string, index int, content []byte) {
+		name := base + extension
+		if index > 0 {
+			name = fmt.Sprintf("%s_%d%s", base, index+1, extension)
+		}
+		files[name] = content
+		metadata, _ := json.Marshal(map[string]sigstoreCustomMetadata{"sigstore": {Usage: usage, Status: "Active", URI: uri}})
+		custom[name] = metadata
+	}
+	for i, ca := range keys.CertificateAuthorities {
+		addTarget("fulcio", ".crt.pem", "Fulcio", ca.URI, i, ca.CertChain)
+	}
+	for i, ca := range keys.TimestampAuthorities {
+		addTarget("tsa", ".crt.pem", "TSA", ca.URI, i, ca.CertChain)
+	}
+	for i, tlog := range keys.TLogs {
+		addTarget("rekor", ".pub", "Rekor", tlog.BaseURL, i, tlog.PublicKey)
+	}
+	for i, tlog := range keys.CTLogs {
+		addTarget("ctfe", ".pub", "CTFE", tlog.BaseURL, i, tlog.PublicKey)
+	}
+
+	store := tuf.MemoryStore(nil, files)
+	repo, err := tuf.NewRepo(store)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Init(false); err != nil {
+		return nil, err
+	}
+	for _, role := range []string{"root", "targets", "snapshot", "timestamp"} {
+		if _, err := repo.GenKeyWithExpires(role, expires); err != nil {
+			return nil, fmt.Errorf("could not generate the %s key: %v", role, err)
+		}
+	}
+	if err := repo.AddTargetWithExpires("trusted_root.json", nil, expires); err != nil {
+		return nil, fmt.Errorf("could not add target trusted_root.json: %v", err)
+	}
+	for name, metadata := range custom {
+		if err := repo.AddTargetWithExpires(name, metadata, expires); err != nil {
+			return nil, fmt.Errorf("could not add target %s: %v", name, err)
+		}
+	}
+	if err := repo.SnapshotWithExpires(expires); err != nil {
+		return nil, err
+	}
+	if err := repo.TimestampWithExpires(expires); err != nil {
+		return nil, err
+	}
+	if err := repo.Commit(); err != nil {
+		return nil, fmt.Errorf("could not sign the repository: %v", err)
+	}
+
+	meta, err := store.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+	// The layout of assembled repositories without consistent snapshots
+	for role, name := range map[string]string{"root.json": "1.root.json", "targets.json": "targets.json", "snapshot.json": "snapshot.json", "timestamp.json": "timestamp.json"} {
+		if err := os.WriteFile(filepath.Join(workDir, name), meta[role], 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(workDir, "targets"), 0o755); err != nil {
+		return nil, err
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(workDir, "targets", name), content, 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyAssembledDirectory(workDir); err != nil {
+		return nil, fmt.Errorf("converted repository does not verify: %w", err)
+	}
+	return os.Open(filepath.Join(workDir, "1.root.json"))
+}
+
+// bufferDestination is a client.Destination keeping a download in memory.
+type bufferDestination struct {
+	bytes.Buffer
+}
+
+func (b *bufferDestination) Delete() error {
+	b.Reset()
+	return nil
+}
+
+// targetSigstoreMetadata returns the Sigstore custom metadata of a verified
+// target, or nil if it has none.
+func targetSigstoreMetadata(meta data.TargetFileMeta) *sigstoreCustomMetadata {
+	if meta.Custom == nil {
+		return nil
+	}
+	var custom struct {
+		Sigstore *sigstoreCustomMetadata `json:"sigstore"`
+	}
+	if err := json.Unmarshal(*meta.Custom, &custom); err != nil {
+		return nil
+	}
+	return custom.Sigstore
+}
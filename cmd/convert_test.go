@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+)
+
+// testSigstoreKeys returns SigstoreKeys with a Fulcio chain valid for an hour
+// and a Rekor key.
+func testSigstoreKeys(t *testing.T) SigstoreKeys {
+	t.Helper()
+	ca, err := NewCertificateAuthority("https://fulcio.example.com", testCertificateChainPEM(t, "example.com", "fulcio-intermediate", "fulcio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	logKeyPEM, _ := testPublicKeyPEM(t, &logKey.PublicKey)
+	tlog, err := newTransparencyLog("https://rekor.example.com", logKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SigstoreKeys{CertificateAuthorities: []CertificateAuthority{ca}, TLogs: []TransparencyLogInstance{tlog}}
+}
+
+func TestReadTrustRoot(t *testing.T) {
+	keys := testSigstoreKeys(t)
+	trustRoot, err := ReadTrustRoot([]byte(RenderSigstoreKeysTrustRoot("sigstore", keys)))
+	if err != nil {
+		t.Fatalf("ReadTrustRoot() error = %v", err)
+	}
+	if trustRoot.Metadata.Name != "sigstore" {
+		t.Errorf("name = %q, want sigstore", trustRoot.Metadata.Name)
+	}
+	if got := trustRoot.sigstoreKeys(); !reflect.DeepEqual(got, keys) {
+		t.Errorf("sigstoreKeys() = %+v, want %+v", got, keys)
+	}
+	if _, err := ReadTrustRoot([]byte("spec: [")); err == nil {
+		t.Error("ReadTrustRoot() of a malformed manifest succeeded")
+	}
+}
+
+func TestTrustedRootSigstoreKeys(t *testing.T) {
+	keys := testSigstoreKeys(t)
+	rekorV2Key, _, _ := ed25519.GenerateKey(rand.Reader)
+	rekorV2PEM, rekorV2DER := testPublicKeyPEM(t, rekorV2Key)
+	rekorV2KeyFile := filepath.Join(t.TempDir(), "rekor-v2.pub")
+	if err := os.WriteFile(rekorV2KeyFile, rekorV2PEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rekorV2, err := NewRekorV2Log("https://log2025-1.rekor.example.com", rekorV2KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.TLogs = append(keys.TLogs, rekorV2)
+	trustedRoot, err := TrustedRootJSON(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries of a Rekor v2 log name it by its key, checkpoints by the checkpoint key ID
+	var document trustedRootDocument
+	if err := json.Unmarshal(trustedRoot, &document); err != nil {
+		t.Fatal(err)
+	}
+	rekorV2Entry := document.TLogs[1]
+	keySum := sha256.Sum256(rekorV2DER)
+	if !bytes.Equal(rekorV2Entry.LogID.KeyID, keySum[:]) {
+		t.Errorf("Rekor v2 logId = %x, want %x", rekorV2Entry.LogID.KeyID, keySum)
+	}
+	if rekorV2Entry.CheckpointKeyID == nil || hex.EncodeToString(rekorV2Entry.CheckpointKeyID.KeyID) != rekorV2.LogID {
+		t.Errorf("Rekor v2 checkpointKeyId = %+v, want %s", rekorV2Entry.CheckpointKeyID, rekorV2.LogID)
+	}
+	if document.TLogs[0].CheckpointKeyID != nil {
+		t.Errorf("Rekor v1 checkpointKeyId = %+v, want none", document.TLogs[0].CheckpointKeyID)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want SigstoreKeys
+	}{
+		{name: "active", now: time.Now(), want: keys},
+		{name: "chain not yet valid", now: time.Now().Add(-2 * time.Hour), want: SigstoreKeys{TLogs: keys.TLogs}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TrustedRootSigstoreKeys(trustedRoot, tt.now)
+			if err != nil {
+				t.Fatalf("TrustedRootSigstoreKeys() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TrustedRootSigstoreKeys() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositorySigstoreKeys(t *testing.T) {
+	spec := testRepositorySpec(t)["repository"].(map[string]any)
+	keys, err := RepositorySigstoreKeys(bytes.NewReader(spec["mirrorFS"].([]byte)), spec["root"].([]byte), assembler.ArchiveLayout{}, time.Now())
+	if err != nil {
+		t.Fatalf("RepositorySigstoreKeys() error = %v", err)
+	}
+	// The fixture targets are placeholders without Sigstore custom metadata
+	if !keys.empty() {
+		t.Errorf("RepositorySigstoreKeys() = %+v, want no trust material", keys)
+	}
+
+	archive := spec["mirrorFS"].([]byte)
+	if _, err := RepositorySigstoreKeys(bytes.NewReader(archive[:len(archive)/2]), spec["root"].([]byte), assembler.ArchiveLayout{}, time.Now()); err == nil {
+		t.Error("RepositorySigstoreKeys() of a truncated archive succeeded")
+	}
+}
+
+func TestBuildSigstoreKeysRepository(t *testing.T) {
+	keys := testSigstoreKeys(t)
+	workDir := t.TempDir()
+	rootJSONFile, err := BuildSigstoreKeysRepository(keys, workDir, time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("BuildSigstoreKeysRepository() error = %v", err)
+	}
+	output, err := RenderRepositoryTrustRoot("sigstore", workDir, rootJSONFile)
+	rootJSONFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustRoot, err := ReadTrustRoot([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, _ := json.Marshal(map[string]any{"kind": "TrustRoot", "spec": map[string]any{"repository": trustRoot.Spec.Repository}})
+	if err := ValidateTrustRoot(object, "", time.Now()); err != nil {
+		t.Errorf("ValidateTrustRoot() of the converted TrustRoot error = %v", err)
+	}
+	repository := trustRoot.Spec.Repository
+	layout := assembler.ArchiveLayout{TargetsDir: repository.Targets}
+	got, err := RepositorySigstoreKeys(bytes.NewReader(repository.MirrorFS), repository.Root, layout, time.Now())
+	if err != nil {
+		t.Fatalf("RepositorySigstoreKeys() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Errorf("round trip = %+v, want %+v", got, keys)
+	}
+
+	if _, err := BuildSigstoreKeysRepository(SigstoreKeys{TLogs: []TransparencyLogInstance{{BaseURL: "https://rekor.example.com", PublicKey: []byte("not a key")}}}, t.TempDir(), time.Now().Add(time.Hour)); err == nil {
+		t.Error("BuildSigstoreKeysRepository() of a malformed key succeeded")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+
+	"cmd/assembler"
+)
+
+// rootSigningRepository is where the sigstore/root-signing GitHub repository
+// publishes the public-good TUF repository the CDN serves, the --cross-check
+// source named root-signing.
+const rootSigningRepository = "https://raw.githubusercontent.com/sigstore/root-signing/main/repository/repository"
+
+// crossCheckSource returns the repository source of the --cross-check value,
+// root-signing or any NewFetcher source.
+func crossCheckSource(value string) string {
+	if value == "root-signing" {
+		return rootSigningRepository
+	}
+	return value
+}
+
+// CrossCheckRoot requires a second source of the repository to serve the
+// same root version as rootJSON byte for byte, so a mirror showing a split
+// view of the repository, a root valid for its keys but not the one
+// published to everyone else, is detected.
+//
+// Parameters:
+//   - fetcher: The second source of the repository.
+//   - rootJSON: The verified root.json, as downloaded from the mirror.
+//
+// Returns:
+//   - error: nil if both sources agree, otherwise an error wrapping assembler.ErrVerification, or assembler.ErrMirrorUnreachable if the second source has no such root.
+func CrossCheckRoot(fetcher Fetcher, rootJSON []byte) error {
+	version, err := assembler.MetadataVersion(rootJSON)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.root.json", version)
+	other, err := fetchMetadata(fetcher, name)
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w: %v", name, assembler.ErrMirrorUnreachable, err)
+	}
+	if !bytes.Equal(other, rootJSON) {
+		return fmt.Errorf("%w: %s differs between the mirror and the cross-check source", assembler.ErrVerification, name)
+	}
+	return nil
+}
+
+// crossCheckRoot runs CrossCheckRoot against the --cross-check value for the
+// root.json at rootPath, exiting on errors.
+func crossCheckRoot(value, rootPath string) {
+	rootJSON, err := os.ReadFile(rootPath)
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	source := crossCheckSource(value)
+	fetcher, err := NewFetcher(source)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if err := CrossCheckRoot(fetcher, rootJSON); err != nil {
+		fatalf(err, "Error: cross-check against %s failed: %v", source, err)
+	}
+	log.Printf("root.json matches %s\n", source)
+}
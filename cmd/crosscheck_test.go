@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cmd/assembler"
+
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+func TestCrossCheckSource(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"root-signing", rootSigningRepository},
+		{"https://mirror.example.com", "https://mirror.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := crossCheckSource(tt.value); got != tt.want {
+				t.Errorf("crossCheckSource() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCrossCheckRoot(t *testing.T) {
+	key, _ := keys.GenerateEd25519Key()
+	otherKey, _ := keys.GenerateEd25519Key()
+	root := testRoot(t, 2, key, key)
+	tests := []struct {
+		name    string
+		other   []byte
+		wantErr error
+	}{
+		{"same root", root, nil},
+		{"split view", testRoot(t, 2, otherKey, otherKey), assembler.ErrVerification},
+		{"reencoded root", append(append([]byte(nil), root...), '\n'), assembler.ErrVerification},
+		{"missing root", nil, assembler.ErrMirrorUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.other != nil {
+				if err := os.WriteFile(filepath.Join(dir, "2.root.json"), tt.other, 0o644); err != nil {
+					t.Fatalf("Failed to write root: %v", err)
+				}
+			}
+			err := CrossCheckRoot(dirFetcher{dir: dir}, root)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("CrossCheckRoot() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// ctSignedTreeHead is the response of the RFC 6962 get-sth endpoint.
+type ctSignedTreeHead struct {
+	TreeSize          uint64 `json:"tree_size"`
+	Timestamp         uint64 `json:"timestamp"`
+	SHA256RootHash    []byte `json:"sha256_root_hash"`
+	TreeHeadSignature []byte `json:"tree_head_signature"`
+}
+
+// TLS identifiers of the DigitallySigned struct of RFC 5246.
+const (
+	tlsHashSHA256     = 4
+	tlsSignatureRSA   = 1
+	tlsSignatureECDSA = 3
+)
+
+// FetchCTLog returns the TransparencyLogInstance of a running RFC 6962 CT log
+// such as a Sigstore CTFE. CT logs publish no public key endpoint, so the key
+// is read from publicKeyPath and checked against the signed tree head of
+// /ct/v1/get-sth: a key that does not sign the running log fails instead of
+// breaking keyless verification later.
+//
+// Parameters:
+//   - ctlogURL: The base URL of the log, including its prefix, e.g. http://ctlog.ctlog-system.svc/sigstorescaffolding.
+//   - publicKeyPath: The path of the PEM or DER encoded public key of the log.
+//
+// Returns:
+//   - The TransparencyLogInstance of the log, identified by its log ID.
+//   - An error if the key is invalid, the tree head could not be fetched or the key does not verify it.
+func FetchCTLog(ctlogURL, publicKeyPath string) (TransparencyLogInstance, error) {
+	ctlogURL = strings.TrimSuffix(ctlogURL, "/")
+	publicKey, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	publicKey, err = NormalizePublicKey(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("invalid public key %s: %v", publicKeyPath, err)
+	}
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	content, err := fetch(ctlogURL + "/ct/v1/get-sth")
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("could not get the signed tree head: %v", err)
+	}
+	var sth ctSignedTreeHead
+	if err := json.Unmarshal(content, &sth); err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("could not decode the signed tree head: %v", err)
+	}
+	if err := verifyCTTreeHead(sth, publicKey); err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("the public key does not verify the signed tree head: %v", err)
+	}
+	log.Printf("ctlog %s: tree size %d, log ID %s\n", ctlogURL, sth.TreeSize, logID)
+	return TransparencyLogInstance{BaseURL: ctlogURL, HashAlgorithm: "sha-256", PublicKey: publicKey, LogID: logID}, nil
+}
+
+// verifyCTTreeHead checks the tree_head_signature of sth, a TLS
+// DigitallySigned struct over the TreeHeadSignature of RFC 6962 section 3.5,
+// with the PEM encoded publicKeyPEM.
+func verifyCTTreeHead(sth ctSignedTreeHead, publicKeyPEM []byte) error {
+	if len(sth.SHA256RootHash) != sha256.Size {
+		return fmt.Errorf("invalid root hash of %d bytes", len(sth.SHA256RootHash))
+	}
+	signature := sth.TreeHeadSignature
+	if len(signature) < 4 || int(binary.BigEndian.Uint16(signature[2:4])) != len(signature)-4 {
+		return fmt.Errorf("malformed tree head signature")
+	}
+	if signature[0] != tlsHashSHA256 {
+		return fmt.Errorf("unsupported tree head signature hash algorithm %d", signature[0])
+	}
+	der, err := publicKeyDER(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+
+	// version v1, signature type tree_hash, timestamp, tree size, root hash
+	signed := make([]byte, 2, 2+8+8+sha256.Size)
+	signed[1] = 1
+	signed = binary.BigEndian.AppendUint64(signed, sth.Timestamp)
+	signed = binary.BigEndian.AppendUint64(signed, sth.TreeSize)
+	signed = append(signed, sth.SHA256RootHash...)
+	digest := sha256.Sum256(signed)
+
+	var valid bool
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = signature[1] == tlsSignatureECDSA && ecdsa.VerifyASN1(key, digest[:], signature[4:])
+	case *rsa.PublicKey:
+		valid = signature[1] == tlsSignatureRSA && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature[4:]) == nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	if !valid {
+		return fmt.Errorf("invalid tree head signature")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testCTTreeHead returns an RFC 6962 signed tree head signed by key.
+func testCTTreeHead(t *testing.T, key *ecdsa.PrivateKey) ctSignedTreeHead {
+	t.Helper()
+	sth := ctSignedTreeHead{TreeSize: 42, Timestamp: 1700000000000, SHA256RootHash: make([]byte, sha256.Size)}
+	signed := []byte{0, 1}
+	signed = binary.BigEndian.AppendUint64(signed, sth.Timestamp)
+	signed = binary.BigEndian.AppendUint64(signed, sth.TreeSize)
+	signed = append(signed, sth.SHA256RootHash...)
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign tree head: %v", err)
+	}
+	sth.TreeHeadSignature = binary.BigEndian.AppendUint16([]byte{tlsHashSHA256, tlsSignatureECDSA}, uint16(len(signature)))
+	sth.TreeHeadSignature = append(sth.TreeHeadSignature, signature...)
+	return sth
+}
+
+func TestFetchCTLog(t *testing.T) {
+	key, publicKey := testRekorKey(t)
+	otherKey, _ := testRekorKey(t)
+	logID, err := LogID(publicKey)
+	if err != nil {
+		t.Fatalf("LogID() error = %v", err)
+	}
+	truncated := testCTTreeHead(t, key)
+	truncated.TreeHeadSignature = truncated.TreeHeadSignature[:10]
+
+	tests := []struct {
+		name      string
+		publicKey []byte
+		sth       ctSignedTreeHead
+		wantErr   bool
+	}{
+		{"valid", publicKey, testCTTreeHead(t, key), false},
+		{"signed by another key", publicKey, testCTTreeHead(t, otherKey), true},
+		{"truncated signature", publicKey, truncated, true},
+		{"invalid public key", []byte("not a key"), testCTTreeHead(t, key), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/sigstorescaffolding/ct/v1/get-sth" {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(tt.sth)
+			}))
+			defer server.Close()
+			publicKeyPath := filepath.Join(t.TempDir(), "ctfe.pub")
+			if err := os.WriteFile(publicKeyPath, tt.publicKey, 0o644); err != nil {
+				t.Fatalf("Failed to write public key: %v", err)
+			}
+
+			tlog, err := FetchCTLog(server.URL+"/sigstorescaffolding/", publicKeyPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchCTLog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (tlog.BaseURL != server.URL+"/sigstorescaffolding" || tlog.LogID != logID) {
+				t.Errorf("FetchCTLog() = %+v, want the log at %s/sigstorescaffolding with log ID %s", tlog, server.URL, logID)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/verify"
+)
+
+// SuccinctRoles is a TAP 15 succinct hash bin delegation: targets are
+// distributed over 2^BitLength bins named NamePrefix-<hex bin number>, all
+// signed by the same keys.
+// See https://github.com/theupdateframework/taps/blob/master/tap15.md
+type SuccinctRoles struct {
+	KeyIDs     []string `json:"keyids"`
+	Threshold  int      `json:"threshold"`
+	BitLength  int      `json:"bit_length"`
+	NamePrefix string   `json:"name_prefix"`
+}
+
+// maxSuccinctBitLength is the largest bit_length of a succinct delegation
+// whose bins are all fetched: 2^16 bins. Larger delegations, up to the 32
+// bits of TAP 15, are only resolved for --delegated-target targets.
+const maxSuccinctBitLength = 16
+
+// delegatedTargets are the --delegated-target targets resolved from succinct
+// hash bin delegations, none to resolve every bin.
+var delegatedTargets stringsFlag
+
+// BinName returns the name of the bin responsible for target: the first
+// BitLength bits of the SHA-256 of the target path.
+func (s SuccinctRoles) BinName(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return s.binName(binary.BigEndian.Uint32(sum[:4]) >> (32 - s.BitLength))
+}
+
+// Bins returns the number of bins of the delegation, 2^BitLength, whose
+// names BinNameOf returns without listing them all.
+func (s SuccinctRoles) Bins() uint64 {
+	return 1 << s.BitLength
+}
+
+// BinNameOf returns the name of bin number bin, lower than Bins.
+func (s SuccinctRoles) BinNameOf(bin uint64) string {
+	return s.binName(uint32(bin))
+}
+
+func (s SuccinctRoles) binName(bin uint32) string {
+	return fmt.Sprintf("%s-%0*x", s.NamePrefix, (s.BitLength+3)/4, bin)
+}
+
+// ResolveSuccinctDelegations downloads the hash bins delegated by a targets.json
+// and the targets they list into workDir, so that delegated targets are part of
+// the serialized repository.
+//
+// Bins are fetched at the version pinned by snapshot.json and verified against
+// the keys and threshold of the delegation; targets are verified against the
+// length and SHA-256 of their bin. With requested targets, only the bins they
+// hash to are fetched and only those targets downloaded, as a TUF client
+// looking them up would; otherwise every bin and target is, which is only
+// supported up to a bit_length of maxSuccinctBitLength.
+//
+// Parameters:
+//   - mirror: The URL of the TUF repository mirror, or any source of NewFetcher.
+//   - workDir: The repository directory; bins are written next to targets.json, targets into targets/.
+//   - targetsJSON: The content of the top-level targets.json.
+//   - snapshotJSON: The content of snapshot.json.
+//   - consistent: Whether the root enables consistent snapshots: bins are then fetched as N.<bin>.json and targets as <hash>.<name>, and as <bin>.json and <name> otherwise.
+//   - requested: The delegated targets to resolve, nil for all of them.
+//
+// Returns:
+//   - The number of delegated targets downloaded, 0 if targets.json has no succinct delegation.
+//   - An error if a bin or target could not be downloaded or verified, or if all bins of a delegation of more than maxSuccinctBitLength bits are requested.
+func ResolveSuccinctDelegations(mirror, workDir string, targetsJSON, snapshotJSON []byte, consistent bool, requested []string) (int, error) {
+	var targets struct {
+		Signed struct {
+			Delegations *struct {
+				Keys          map[string]*data.PublicKey `json:"keys"`
+				SuccinctRoles *SuccinctRoles             `json:"succinct_roles"`
+			} `json:"delegations"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(targetsJSON, &targets); err != nil {
+		return 0, err
+	}
+	delegations := targets.Signed.Delegations
+	if delegations == nil || delegations.SuccinctRoles == nil {
+		return 0, nil
+	}
+	succinct := *delegations.SuccinctRoles
+	if succinct.BitLength < 1 || succinct.BitLength > 32 {
+		return 0, fmt.Errorf("invalid succinct delegation bit_length %d", succinct.BitLength)
+	}
+	if len(requested) == 0 && succinct.BitLength > maxSuccinctBitLength {
+		return 0, fmt.Errorf("succinct delegation of %d bins exceeds the %d bins fetched without --delegated-target, name the delegated targets to resolve", succinct.Bins(), 1<<maxSuccinctBitLength)
+	}
+	// The bins to fetch, with the targets to download from each, nil for all
+	var bins []string
+	wanted := map[string]map[string]bool{}
+	if len(requested) == 0 {
+		for bin := uint64(0); bin < succinct.Bins(); bin++ {
+			bins = append(bins, succinct.BinNameOf(bin))
+		}
+	} else {
+		for _, target := range requested {
+			bin := succinct.BinName(target)
+			if wanted[bin] == nil {
+				wanted[bin] = map[string]bool{}
+				bins = append(bins, bin)
+			}
+			wanted[bin][target] = true
+		}
+		sort.Strings(bins)
+	}
+
+	var snapshot struct {
+		Signed struct {
+			Meta map[string]struct {
+				Version int64 `json:"version"`
+			} `json:"meta"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(snapshotJSON, &snapshot); err != nil {
+		return 0, err
+	}
+
+	fetcher, err := NewFetcher(mirror)
+	if err != nil {
+		return 0, err
+	}
+	db := verify.NewDB()
+	for id, key := range delegations.Keys {
+		if err := db.AddKey(id, key); err != nil {
+			return 0, fmt.Errorf("invalid delegation key %s: %v", id, err)
+		}
+	}
+	count := 0
+	for _, bin := range bins {
+		if err := db.AddRole(bin, &data.Role{KeyIDs: succinct.KeyIDs, Threshold: succinct.Threshold}); err != nil {
+			return 0, err
+		}
+		meta, ok := snapshot.Signed.Meta[bin+".json"]
+		if !ok {
+			return 0, fmt.Errorf("bin %s is not listed in snapshot.json", bin)
+		}
+		binName := bin + ".json"
+		if consistent {
+			binName = fmt.Sprintf("%d.%s.json", meta.Version, bin)
+		}
+		binJSON, err := fetchMetadata(fetcher, binName)
+		if err != nil {
+			return 0, fmt.Errorf("could not get bin %s: %w: %v", bin, assembler.ErrMirrorUnreachable, err)
+		}
+		binTargets := &data.Targets{}
+		if err := db.Unmarshal(binJSON, binTargets, bin, meta.Version); err != nil {
+			return 0, fmt.Errorf("could not verify bin %s: %w: %v", bin, assembler.ErrVerification, err)
+		}
+		if binTargets.Version != meta.Version {
+			return 0, fmt.Errorf("%w: bin %s has version %d, snapshot.json pins %d", assembler.ErrVerification, bin, binTargets.Version, meta.Version)
+		}
+		if err := os.WriteFile(filepath.Join(workDir, binName), binJSON, 0o644); err != nil {
+			return 0, err
+		}
+		for name, targetMeta := range binTargets.Targets {
+			if succinct.BinName(name) != bin {
+				return 0, fmt.Errorf("%w: bin %s lists target %s which belongs to bin %s", assembler.ErrVerification, bin, name, succinct.BinName(name))
+			}
+			if wanted[bin] != nil && !wanted[bin][name] {
+				continue
+			}
+			if err := downloadDelegatedTarget(fetcher, filepath.Join(workDir, "targets"), name, targetMeta, consistent); err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+	log.Printf("resolved %d targets from %d of %d succinct hash bins\n", count, len(bins), succinct.Bins())
+	return count, nil
+}
+
+// downloadDelegatedTarget downloads target into targetsDir, from its
+// consistent snapshot name if consistent is set, and verifies it against meta.
+// Consistent snapshot targets are written under both names.
+func downloadDelegatedTarget(fetcher Fetcher, targetsDir, name string, meta data.TargetFileMeta, consistent bool) error {
+	sum, ok := meta.Hashes["sha256"]
+	if !ok {
+		return fmt.Errorf("target %s has no sha256 hash", name)
+	}
+	remoteName := name
+	if consistent {
+		dir, base := path.Split(name)
+		remoteName = fmt.Sprintf("%s%s.%s", dir, sum.String(), base)
+	}
+	content, err := fetchTargetFile(fetcher, remoteName)
+	if err != nil {
+		return fmt.Errorf("could not get target %s: %w: %v", name, assembler.ErrMirrorUnreachable, err)
+	}
+	got := sha256.Sum256(content)
+	if int64(len(content)) != meta.Length || hex.EncodeToString(got[:]) != sum.String() {
+		return fmt.Errorf("%w: target %s does not match its delegated metadata", assembler.ErrVerification, name)
+	}
+	if err := assembler.CheckTargetName(name); err != nil {
+		return err
+	}
+	dst := filepath.Join(targetsDir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if consistent {
+		if err := os.WriteFile(filepath.Join(targetsDir, filepath.FromSlash(remoteName)), content, 0o644); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(dst, content, 0o644)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+	"github.com/theupdateframework/go-tuf/sign"
+)
+
+func TestSuccinctRolesBinName(t *testing.T) {
+	tests := []struct {
+		name      string
+		bitLength int
+		target    string
+		wantBins  uint64
+	}{
+		{name: "one bit", bitLength: 1, target: "rekor.pub", wantBins: 2},
+		{name: "four bits", bitLength: 4, target: "rekor.pub", wantBins: 16},
+		{name: "nine bits", bitLength: 9, target: "fulcio.crt.pem", wantBins: 512},
+		{name: "thirty-two bits", bitLength: 32, target: "fulcio.crt.pem", wantBins: 1 << 32},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SuccinctRoles{BitLength: tt.bitLength, NamePrefix: "bin"}
+			if got := s.Bins(); got != tt.wantBins {
+				t.Fatalf("Bins() = %d, want %d", got, tt.wantBins)
+			}
+			sum := sha256.Sum256([]byte(tt.target))
+			bin := (uint64(sum[0])<<24 | uint64(sum[1])<<16 | uint64(sum[2])<<8 | uint64(sum[3])) >> (32 - tt.bitLength)
+			if got := s.BinName(tt.target); got != s.BinNameOf(bin) {
+				t.Errorf("BinName() = %v, want %v", got, s.BinNameOf(bin))
+			}
+			if got, want := s.BinNameOf(tt.wantBins-1), "bin-"+strings.Repeat("f", (tt.bitLength+3)/4); tt.bitLength%4 == 0 && got != want {
+				t.Errorf("BinNameOf(last) = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// succinctTestRepository is a repository delegating targets to succinct hash
+// bins: its targets.json and snapshot.json, and its bins and targets by path
+// with consistent snapshots, files, and without, plainFiles.
+type succinctTestRepository struct {
+	succinct                  SuccinctRoles
+	targetsJSON, snapshotJSON []byte
+	files, plainFiles         map[string][]byte
+}
+
+// newSuccinctTestRepository returns a repository delegating targets to the
+// bins of succinct, signed by a new key. Only the bins the targets hash to
+// are published if onlyTargetBins is set.
+func newSuccinctTestRepository(t *testing.T, succinct SuccinctRoles, targets map[string][]byte, onlyTargetBins bool) succinctTestRepository {
+	t.Helper()
+	signer, err := keys.GenerateEd25519Key()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyID := signer.PublicData().IDs()[0]
+	succinct.KeyIDs, succinct.Threshold = []string{keyID}, 1
+	repo := succinctTestRepository{succinct: succinct, files: map[string][]byte{}, plainFiles: map[string][]byte{}}
+	binTargets := map[string]*data.Targets{}
+	var bins []string
+	if !onlyTargetBins {
+		for bin := uint64(0); bin < succinct.Bins(); bin++ {
+			bins = append(bins, succinct.BinNameOf(bin))
+		}
+	}
+	for name := range targets {
+		bins = append(bins, succinct.BinName(name))
+	}
+	for _, bin := range bins {
+		binTargets[bin] = data.NewTargets()
+	}
+	for name, content := range targets {
+		sum := sha256.Sum256(content)
+		binTargets[succinct.BinName(name)].Targets[name] = data.TargetFileMeta{FileMeta: data.FileMeta{Length: int64(len(content)), Hashes: data.Hashes{"sha256": sum[:]}}}
+		dir, base := path.Split(name)
+		repo.files["/targets/"+dir+hex.EncodeToString(sum[:])+"."+base] = content
+		repo.plainFiles["/targets/"+name] = content
+	}
+	snapshotMeta := map[string]map[string]int64{}
+	for bin, meta := range binTargets {
+		meta.Version = 3
+		meta.Expires = time.Now().Add(time.Hour)
+		signed, err := sign.Marshal(meta, signer)
+		if err != nil {
+			t.Fatalf("Failed to sign bin: %v", err)
+		}
+		repo.files["/3."+bin+".json"], _ = json.Marshal(signed)
+		repo.plainFiles["/"+bin+".json"] = repo.files["/3."+bin+".json"]
+		snapshotMeta[bin+".json"] = map[string]int64{"version": 3}
+	}
+	delegations := map[string]any{
+		"keys":           map[string]*data.PublicKey{keyID: signer.PublicData()},
+		"succinct_roles": succinct,
+	}
+	repo.targetsJSON, _ = json.Marshal(map[string]any{"signed": map[string]any{"delegations": delegations}})
+	repo.snapshotJSON, _ = json.Marshal(map[string]any{"signed": map[string]any{"meta": snapshotMeta}})
+	return repo
+}
+
+// serveFiles serves files by path and records the paths of the requests.
+func serveFiles(t *testing.T, files map[string][]byte, requests *[]string) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		*requests = append(*requests, r.URL.Path)
+		mu.Unlock()
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestResolveSuccinctDelegations(t *testing.T) {
+	target := []byte("delegated content")
+	targets := map[string][]byte{"dir/delegated.pem": target, "other.pem": []byte("other content")}
+	repo := newSuccinctTestRepository(t, SuccinctRoles{BitLength: 1, NamePrefix: "bin"}, targets, false)
+	// A delegation too large to fetch every bin, and the largest of TAP 15
+	large := newSuccinctTestRepository(t, SuccinctRoles{BitLength: maxSuccinctBitLength + 1, NamePrefix: "bin"}, targets, true)
+	widest := newSuccinctTestRepository(t, SuccinctRoles{BitLength: 32, NamePrefix: "bin"}, targets, true)
+	var requests []string
+	server := serveFiles(t, repo.files, &requests)
+	// A repository without consistent snapshots publishes plain names only
+	plainServer := serveFiles(t, repo.plainFiles, &requests)
+	largeServer := serveFiles(t, large.files, &requests)
+	widestServer := serveFiles(t, widest.files, &requests)
+
+	tests := []struct {
+		name         string
+		repo         succinctTestRepository
+		targetsJSON  []byte
+		mirror       string
+		consistent   bool
+		requested    []string
+		want         int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "no delegation", repo: repo, targetsJSON: []byte(`{"signed": {}}`), mirror: server.URL, consistent: true, want: 0},
+		{name: "succinct delegation", repo: repo, mirror: server.URL, consistent: true, want: 2, wantRequests: 4},
+		{name: "without consistent snapshots", repo: repo, mirror: plainServer.URL, consistent: false, want: 2, wantRequests: 4},
+		{name: "unreachable bins", repo: repo, mirror: server.URL + "/missing", consistent: true, wantErr: true},
+		{name: "requested target", repo: repo, mirror: server.URL, consistent: true, requested: []string{"dir/delegated.pem"}, want: 1, wantRequests: 2},
+		{name: "requested target not delegated", repo: repo, mirror: server.URL, consistent: true, requested: []string{"missing.pem"}, want: 0, wantRequests: 1},
+		{name: "every bin of a large delegation", repo: large, mirror: largeServer.URL, consistent: true, wantErr: true},
+		{name: "requested target of a large delegation", repo: large, mirror: largeServer.URL, consistent: true, requested: []string{"dir/delegated.pem"}, want: 1, wantRequests: 2},
+		{name: "requested target of a 32 bit delegation", repo: widest, mirror: widestServer.URL, consistent: true, requested: []string{"dir/delegated.pem", "other.pem"}, want: 2, wantRequests: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			targetsJSON := tt.targetsJSON
+			if targetsJSON == nil {
+				targetsJSON = tt.repo.targetsJSON
+			}
+			requests = nil
+			got, err := ResolveSuccinctDelegations(tt.mirror, workDir, targetsJSON, tt.repo.snapshotJSON, tt.consistent, tt.requested)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveSuccinctDelegations() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ResolveSuccinctDelegations() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && len(requests) != tt.wantRequests {
+				t.Errorf("ResolveSuccinctDelegations() requested %v, want %d requests", requests, tt.wantRequests)
+			}
+			if tt.want > 0 {
+				content, err := os.ReadFile(filepath.Join(workDir, "targets", "dir", "delegated.pem"))
+				if err != nil || string(content) != string(target) {
+					t.Errorf("delegated target not written: %v", err)
+				}
+				binName := tt.repo.succinct.BinName("dir/delegated.pem") + ".json"
+				if tt.consistent {
+					binName = "3." + binName
+				}
+				if _, err := os.Stat(filepath.Join(workDir, binName)); err != nil {
+					t.Errorf("bin metadata not written: %v", err)
+				}
+			}
+		})
+	}
+}
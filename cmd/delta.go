@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"cmd/assembler"
+)
+
+// RepositoryDelta describes what changed in a repository since a previous
+// TrustRoot, so refresh PRs get meaningful descriptions.
+type RepositoryDelta struct {
+	// Previous is the path of the previous TrustRoot manifest.
+	Previous string          `json:"previous"`
+	Versions []VersionChange `json:"versions,omitempty"`
+	// Added, Removed and Modified are target names, Modified targets having
+	// a different length or SHA-256.
+	Added    []string      `json:"added,omitempty"`
+	Removed  []string      `json:"removed,omitempty"`
+	Modified []string      `json:"modified,omitempty"`
+	Keys     []KeyRotation `json:"keys,omitempty"`
+}
+
+// VersionChange is the version bump of a top-level metadata role.
+type VersionChange struct {
+	Role     string `json:"role"`
+	Previous int64  `json:"previous"`
+	Current  int64  `json:"current"`
+}
+
+// KeyRotation lists the key IDs added to and removed from a top-level role
+// of the root and, if it changed, the previous and current threshold of the
+// role.
+type KeyRotation struct {
+	Role              string   `json:"role"`
+	Added             []string `json:"added,omitempty"`
+	Removed           []string `json:"removed,omitempty"`
+	PreviousThreshold int      `json:"previousThreshold,omitempty"`
+	Threshold         int      `json:"threshold,omitempty"`
+}
+
+// CompareRepositories computes the delta between the top-level metadata of
+// two repositories.
+//
+// Parameters:
+//   - previous: The metadata of the previous repository, by role file name, e.g. root.json.
+//   - current: The metadata of the current repository, by role file name.
+//
+// Returns:
+//   - The delta, empty but for Previous if nothing changed.
+//   - An error if the metadata could not be decoded.
+func CompareRepositories(previous, current map[string][]byte) (RepositoryDelta, error) {
+	var delta RepositoryDelta
+	for _, role := range inspectRoles {
+		previousVersion, err := assembler.MetadataVersion(previous[role])
+		if err != nil {
+			return delta, fmt.Errorf("previous %s: %v", role, err)
+		}
+		currentVersion, err := assembler.MetadataVersion(current[role])
+		if err != nil {
+			return delta, fmt.Errorf("%s: %v", role, err)
+		}
+		if previousVersion != currentVersion {
+			delta.Versions = append(delta.Versions, VersionChange{Role: role, Previous: previousVersion, Current: currentVersion})
+		}
+	}
+
+	previousTargets, err := parseTargetsMetadata(previous["targets.json"])
+	if err != nil {
+		return delta, fmt.Errorf("previous targets.json: %v", err)
+	}
+	currentTargets, err := parseTargetsMetadata(current["targets.json"])
+	if err != nil {
+		return delta, fmt.Errorf("targets.json: %v", err)
+	}
+	names := map[string]bool{}
+	for name := range previousTargets.Signed.Targets {
+		names[name] = true
+	}
+	for name := range currentTargets.Signed.Targets {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		previousTarget, wasListed := previousTargets.Signed.Targets[name]
+		currentTarget, isListed := currentTargets.Signed.Targets[name]
+		switch {
+		case !wasListed:
+			delta.Added = append(delta.Added, name)
+		case !isListed:
+			delta.Removed = append(delta.Removed, name)
+		case previousTarget.Length != currentTarget.Length || previousTarget.Hashes["sha256"] != currentTarget.Hashes["sha256"]:
+			delta.Modified = append(delta.Modified, name)
+		}
+	}
+
+	delta.Keys, err = CompareRootKeys(previous["root.json"], current["root.json"])
+	return delta, err
+}
+
+// CompareRootKeys lists the changes to the keys and thresholds of the
+// top-level roles between two root.json versions, which humans should review
+// before the new root is trusted.
+//
+// Parameters:
+//   - previousRoot: The previous root.json.
+//   - currentRoot: The current root.json.
+//
+// Returns:
+//   - The rotations of the root, targets, snapshot and timestamp roles in this order, none if the keys and thresholds did not change.
+//   - An error if a root could not be decoded.
+func CompareRootKeys(previousRoot, currentRoot []byte) ([]KeyRotation, error) {
+	previousKeys, err := RootKeys(previousRoot)
+	if err != nil {
+		return nil, fmt.Errorf("previous root.json: %v", err)
+	}
+	currentKeys, err := RootKeys(currentRoot)
+	if err != nil {
+		return nil, fmt.Errorf("root.json: %v", err)
+	}
+	var rotations []KeyRotation
+	for i, role := range currentKeys {
+		rotation := KeyRotation{Role: role.Role}
+		rotation.Added = missingKeyIDs(role.Keys, previousKeys[i].Keys)
+		rotation.Removed = missingKeyIDs(previousKeys[i].Keys, role.Keys)
+		if role.Threshold != previousKeys[i].Threshold {
+			rotation.PreviousThreshold, rotation.Threshold = previousKeys[i].Threshold, role.Threshold
+		}
+		if len(rotation.Added) > 0 || len(rotation.Removed) > 0 || rotation.Threshold != 0 {
+			rotations = append(rotations, rotation)
+		}
+	}
+	return rotations, nil
+}
+
+// missingKeyIDs returns the IDs of keys not found in other, in keys order.
+func missingKeyIDs(keys, other []RootKey) []string {
+	var missing []string
+	for _, key := range keys {
+		found := false
+		for _, otherKey := range other {
+			found = found || otherKey.ID == key.ID
+		}
+		if !found {
+			missing = append(missing, key.ID)
+		}
+	}
+	return missing
+}
+
+// Changelog describes the delta one change per line, e.g. "snapshot.json
+// version 41 -> 42" or "target modified: trusted_root.json".
+func (d RepositoryDelta) Changelog() []string {
+	var lines []string
+	for _, version := range d.Versions {
+		lines = append(lines, fmt.Sprintf("%s version %d -> %d", version.Role, version.Previous, version.Current))
+	}
+	for _, name := range d.Added {
+		lines = append(lines, "target added: "+name)
+	}
+	for _, name := range d.Removed {
+		lines = append(lines, "target removed: "+name)
+	}
+	for _, name := range d.Modified {
+		lines = append(lines, "target modified: "+name)
+	}
+	for _, rotation := range d.Keys {
+		lines = append(lines, rotation.String())
+	}
+	return lines
+}
+
+// String describes the rotation, e.g. "root keys rotated: added 1a2b;
+// threshold 3 -> 4".
+func (r KeyRotation) String() string {
+	var changes []string
+	if len(r.Added) > 0 {
+		changes = append(changes, "added "+strings.Join(r.Added, ", "))
+	}
+	if len(r.Removed) > 0 {
+		changes = append(changes, "removed "+strings.Join(r.Removed, ", "))
+	}
+	if r.Threshold != 0 {
+		changes = append(changes, fmt.Sprintf("threshold %d -> %d", r.PreviousThreshold, r.Threshold))
+	}
+	return fmt.Sprintf("%s keys rotated: %s", r.Role, strings.Join(changes, "; "))
+}
+
+// PreviousMetadata returns the top-level metadata embedded in the mirrorFS
+// of a TrustRoot manifest. The metadata is not verified again: a previous
+// generation is expected to have expired timestamp and snapshot metadata.
+//
+// Parameters:
+//   - manifest: The content of the previous TrustRoot manifest, YAML or JSON.
+//   - prefix: The directory of the repository inside the archive, empty for its root.
+//
+// Returns:
+//   - The metadata of the repository by role file name, the root being that of the spec.
+//   - An error if the manifest is not a repository TrustRoot or its archive cannot be read.
+func PreviousMetadata(manifest []byte, prefix string) (map[string][]byte, error) {
+	trustRoot, err := ReadTrustRoot(manifest)
+	if err != nil {
+		return nil, err
+	}
+	repository := trustRoot.Spec.Repository
+	if repository == nil || len(repository.MirrorFS) == 0 {
+		return nil, fmt.Errorf("TrustRoot %s has no repository mirrorFS", trustRoot.Metadata.Name)
+	}
+	stripPrefix := ""
+	if prefix != "" {
+		stripPrefix = prefix + "/"
+	}
+	memFS, err := UncompressMemFS(bytes.NewReader(repository.MirrorFS), stripPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("could not unpack the mirrorFS of %s: %v", trustRoot.Metadata.Name, err)
+	}
+	metadata := map[string][]byte{"root.json": repository.Root}
+	for _, role := range inspectRoles[1:] {
+		name, err := latestArchivedMetadata(memFS, role)
+		if err != nil {
+			return nil, err
+		}
+		content, err := fs.ReadFile(memFS, name)
+		if err != nil {
+			return nil, fmt.Errorf("mirrorFS of %s has no %s: %v", trustRoot.Metadata.Name, role, err)
+		}
+		metadata[role] = content
+	}
+	return metadata, nil
+}
+
+// latestArchivedMetadata is latestMetadataPath for the repository
+// filesystem of an archive.
+func latestArchivedMetadata(fsys fs.FS, role string) (string, error) {
+	if role == "timestamp.json" {
+		return role, nil
+	}
+	names, err := fs.Glob(fsys, "*."+role)
+	if err != nil {
+		return "", err
+	}
+	latest, latestVersion := role, int64(-1)
+	for _, name := range names {
+		var version int64
+		if _, err := fmt.Sscanf(path.Base(name), "%d."+role, &version); err != nil {
+			continue
+		}
+		if version > latestVersion {
+			latest, latestVersion = name, version
+		}
+	}
+	return latest, nil
+}
+
+// repositoryMetadata returns the latest top-level metadata of the repository
+// directory dir, by role file name.
+func repositoryMetadata(dir string) (map[string][]byte, error) {
+	metadata := map[string][]byte{}
+	for _, role := range inspectRoles {
+		path, err := latestMetadataPath(dir, role)
+		if err != nil {
+			return nil, err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		metadata[role] = content
+	}
+	return metadata, nil
+}
+
+// reportDelta logs the changelog of the repository assembled in workDir
+// since the TrustRoot manifest at previous, exiting on errors.
+func reportDelta(previous, workDir string) *RepositoryDelta {
+	manifest, err := os.ReadFile(previous)
+	if err != nil {
+		log.Fatalf("Error: could not read --previous: %v", err)
+	}
+	previousMetadata, err := PreviousMetadata(manifest, archiveLayout.Prefix)
+	if err != nil {
+		log.Fatalf("Error: --previous %s: %v", previous, err)
+	}
+	currentMetadata, err := repositoryMetadata(workDir)
+	if err != nil {
+		log.Fatalf("Error: could not read the assembled metadata: %v", err)
+	}
+	delta, err := CompareRepositories(previousMetadata, currentMetadata)
+	if err != nil {
+		log.Fatalf("Error: could not compare with --previous %s: %v", previous, err)
+	}
+	delta.Previous = previous
+	changelog := delta.Changelog()
+	if len(changelog) == 0 {
+		log.Printf("no changes since %s\n", previous)
+	} else {
+		log.Printf("changes since %s:\n  %s\n", previous, strings.Join(changelog, "\n  "))
+	}
+	return &delta
+}
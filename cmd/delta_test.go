@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+// testDeltaMetadata returns top-level metadata with the versions and targets
+// given, by role file name, trusting root.
+func testDeltaMetadata(root []byte, snapshotVersion int64, targets string) map[string][]byte {
+	return map[string][]byte{
+		"root.json":      root,
+		"timestamp.json": []byte(fmt.Sprintf(`{"signed":{"version":%d}}`, snapshotVersion)),
+		"snapshot.json":  []byte(fmt.Sprintf(`{"signed":{"version":%d}}`, snapshotVersion)),
+		"targets.json":   []byte(`{"signed":{"version":3,"targets":{` + targets + `}}}`),
+	}
+}
+
+func TestCompareRepositories(t *testing.T) {
+	oldKey, _ := keys.GenerateEd25519Key()
+	newKey, _ := keys.GenerateEd25519Key()
+	oldRoot := testRoot(t, 1, oldKey, oldKey)
+	newRoot := testRoot(t, 2, newKey, oldKey, newKey)
+	previous := testDeltaMetadata(oldRoot, 41, `"rekor.pub":{"length":1,"hashes":{"sha256":"aa"}},"fulcio.crt.pem":{"length":1,"hashes":{"sha256":"bb"}},"ctfe.pub":{"length":1,"hashes":{"sha256":"cc"}}`)
+
+	tests := []struct {
+		name    string
+		current map[string][]byte
+		want    []string
+	}{
+		{name: "unchanged", current: previous},
+		{
+			name:    "refreshed",
+			current: testDeltaMetadata(oldRoot, 42, `"rekor.pub":{"length":1,"hashes":{"sha256":"aa"}},"fulcio.crt.pem":{"length":1,"hashes":{"sha256":"bb"}},"ctfe.pub":{"length":1,"hashes":{"sha256":"cc"}}`),
+			want:    []string{"timestamp.json version 41 -> 42", "snapshot.json version 41 -> 42"},
+		},
+		{
+			name:    "targets and keys",
+			current: testDeltaMetadata(newRoot, 41, `"rekor.pub":{"length":2,"hashes":{"sha256":"dd"}},"fulcio.crt.pem":{"length":1,"hashes":{"sha256":"bb"}},"trusted_root.json":{"length":1,"hashes":{"sha256":"ee"}}`),
+			want: []string{
+				"root.json version 1 -> 2",
+				"target added: trusted_root.json",
+				"target removed: ctfe.pub",
+				"target modified: rekor.pub",
+				fmt.Sprintf("root keys rotated: added %s; removed %s", newKey.PublicData().IDs()[0], oldKey.PublicData().IDs()[0]),
+				fmt.Sprintf("targets keys rotated: added %s; removed %s", newKey.PublicData().IDs()[0], oldKey.PublicData().IDs()[0]),
+				fmt.Sprintf("snapshot keys rotated: added %s; removed %s", newKey.PublicData().IDs()[0], oldKey.PublicData().IDs()[0]),
+				fmt.Sprintf("timestamp keys rotated: added %s; removed %s", newKey.PublicData().IDs()[0], oldKey.PublicData().IDs()[0]),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta, err := CompareRepositories(previous, tt.current)
+			if err != nil {
+				t.Fatalf("CompareRepositories() error = %v", err)
+			}
+			if got := delta.Changelog(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Changelog() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := CompareRepositories(previous, testDeltaMetadata([]byte("{"), 41, "")); err == nil {
+		t.Error("CompareRepositories() of a malformed root succeeded")
+	}
+}
+
+func TestPreviousMetadata(t *testing.T) {
+	spec := testRepositorySpec(t)
+	manifest := testTrustRootObject(t, spec)
+	metadata, err := PreviousMetadata(manifest, "")
+	if err != nil {
+		t.Fatalf("PreviousMetadata() error = %v", err)
+	}
+	for _, role := range inspectRoles {
+		if len(metadata[role]) == 0 {
+			t.Errorf("PreviousMetadata() has no %s", role)
+		}
+	}
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootJSONFile.Close()
+	current, err := repositoryMetadata(workDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := CompareRepositories(metadata, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changelog := delta.Changelog(); len(changelog) != 0 {
+		t.Errorf("Changelog() of the same repository = %q, want none", changelog)
+	}
+
+	if _, err := PreviousMetadata(testTrustRootObject(t, map[string]any{"remote": map[string]any{"mirror": "https://tuf.example.com"}}), ""); err == nil {
+		t.Error("PreviousMetadata() of a remote TrustRoot succeeded")
+	}
+}
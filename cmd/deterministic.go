@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now is the clock of TrustRoot names and history records, fixed by
+// --deterministic. Expiry checks always use the real time.
+var now = time.Now
+
+// deterministic reports whether --deterministic is set: a fixed clock, fixed
+// temporary directory names and reproducible archives.
+var deterministic = false
+
+// enableDeterministicMode fixes the clock to SOURCE_DATE_EPOCH, or the unix
+// epoch if it is not set, and makes temporary names and archives
+// reproducible.
+func enableDeterministicMode() error {
+	fixed := time.Unix(0, 0).UTC()
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", epoch, err)
+		}
+		fixed = time.Unix(seconds, 0).UTC()
+	}
+	now = func() time.Time { return fixed }
+	deterministic = true
+	return nil
+}
+
+// mkdirTemp is os.MkdirTemp in tempDir. With
+// --deterministic, the random part of pattern is replaced by "deterministic"
+// and a previous directory of that name is removed, so concurrent
+// deterministic runs must use different TMPDIRs or cache directories.
+func mkdirTemp(pattern string) (string, error) {
+	if !deterministic {
+		return os.MkdirTemp(tempDir(), pattern)
+	}
+	dir := filepath.Join(tempDir(), strings.Replace(pattern, "*", "deterministic", 1))
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	return dir, os.Mkdir(dir, 0o700)
+}
+
+// volatileLines match the manifest lines ignored by CompareManifests: names
+// ending with a unix time and the fields set by the API server.
+var volatileLines = []struct {
+	re          *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`^(\s*name: .*-)\d{9,}$`), "${1}<timestamp>"},
+	{regexp.MustCompile(`^(\s*(creationTimestamp|resourceVersion|uid|generation):).*$`), "${1} <volatile>"},
+}
+
+// mirrorFSLine matches the first line of a base64 encoded repository archive.
+var mirrorFSLine = regexp.MustCompile(`^(\s*)(mirrorFS|repository\.tar\.gz): *(\|-)?\s*(\S*)$`)
+
+// CompareManifests compares a generated manifest with a golden one, ignoring
+// volatile fields: timestamp name suffixes and fields set by the API server.
+// Repository archives are compared by the paths and SHA-256 of their files,
+// not by their bytes, which depend on file times and compression.
+//
+// Parameters:
+//   - golden: The expected manifest.
+//   - generated: The generated manifest.
+//
+// Returns:
+//   - The differing lines, prefixed with "-" for golden and "+" for generated, nil if the manifests match.
+//   - An error if an archive could not be decoded.
+func CompareManifests(golden, generated []byte) ([]string, error) {
+	goldenLines, err := normalizeManifest(golden)
+	if err != nil {
+		return nil, fmt.Errorf("golden manifest: %v", err)
+	}
+	generatedLines, err := normalizeManifest(generated)
+	if err != nil {
+		return nil, fmt.Errorf("generated manifest: %v", err)
+	}
+	return diffLines(goldenLines, generatedLines), nil
+}
+
+// normalizeManifest returns the lines of manifest with volatile fields
+// replaced and repository archives expanded to one line per file.
+func normalizeManifest(manifest []byte) ([]string, error) {
+	lines := strings.Split(strings.TrimRight(string(manifest), "\n"), "\n")
+	var normalized []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if match := mirrorFSLine.FindStringSubmatch(line); match != nil {
+			encoded := match[4]
+			// Block scalars hold the archive in the more indented lines
+			if encoded == "" {
+				for i+1 < len(lines) && len(lines[i+1])-len(strings.TrimLeft(lines[i+1], " ")) > len(match[1]) {
+					i++
+					encoded += strings.TrimSpace(lines[i])
+				}
+			}
+			files, err := ArchiveListing(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode %s: %v", match[2], err)
+			}
+			normalized = append(normalized, match[1]+match[2]+":")
+			for _, file := range files {
+				normalized = append(normalized, match[1]+"  "+file)
+			}
+			continue
+		}
+		for _, volatile := range volatileLines {
+			line = volatile.re.ReplaceAllString(line, volatile.replacement)
+		}
+		normalized = append(normalized, line)
+	}
+	return normalized, nil
+}
+
+// ArchiveListing returns "<path> <sha256>" for every file of a base64
+// encoded tar.gz archive, in archive order.
+//
+// Parameters:
+//   - encoded: The base64 encoded archive, e.g. the mirrorFS of a TrustRoot.
+//
+// Returns:
+//   - The files of the archive.
+//   - An error if encoded is not a base64 encoded tar.gz archive.
+func ArchiveListing(encoded string) ([]string, error) {
+	archive, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		sum := sha256.New()
+		if _, err := io.Copy(sum, tr); err != nil {
+			return nil, err
+		}
+		files = append(files, fmt.Sprintf("%s %x", header.Name, sum.Sum(nil)))
+	}
+}
+
+// diffLines returns the lines removed from a ("-") and added in b ("+")
+// along their longest common subsequence, nil if they are equal.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	return diff
+}
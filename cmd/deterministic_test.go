@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cmd/assembler"
+)
+
+// testTrustRoot renders a TrustRoot named name whose mirrorFS archives fsys.
+func testTrustRoot(t *testing.T, name string, fsys fstest.MapFS) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	if err := assembler.CompressFS(fsys, &archive); err != nil {
+		t.Fatalf("CompressFS() error = %v", err)
+	}
+	return []byte(fmt.Sprintf("apiVersion: policy.sigstore.dev/v1alpha1\nkind: TrustRoot\nmetadata:\n  name: %s\nspec:\n  repository:\n    root: |-\n      cm9vdA==\n    mirrorFS: |-\n      %s\n", name, base64.StdEncoding.EncodeToString(archive.Bytes())))
+}
+
+func TestCompareManifests(t *testing.T) {
+	repository := fstest.MapFS{"targets/rekor.pub": {Data: []byte("rekor public key"), ModTime: time.Unix(1, 0)}}
+	touched := fstest.MapFS{"targets/rekor.pub": {Data: []byte("rekor public key"), ModTime: time.Unix(2, 0)}}
+	rotated := fstest.MapFS{"targets/rekor.pub": {Data: []byte("rotated rekor public key")}}
+	golden := testTrustRoot(t, "tuf-repo-cdn.sigstore.dev-1700000000", repository)
+	var archive bytes.Buffer
+	if err := assembler.CompressFS(repository, &archive); err != nil {
+		t.Fatal(err)
+	}
+	defer func(width int) { base64LineWidth = width }(base64LineWidth)
+	base64LineWidth = 76
+	var rendered bytes.Buffer
+	writeYAML(&rendered, trustRootRenderer.manifest(yamlMapping(yamlField{"name", yamlString("tuf-repo-cdn.sigstore.dev-1700000000")}), []byte("root"), yamlBase64(archive.Bytes())))
+
+	tests := []struct {
+		name      string
+		generated []byte
+		wantDiff  []string
+	}{
+		{"identical", golden, nil},
+		{"volatile name and file times", testTrustRoot(t, "tuf-repo-cdn.sigstore.dev-1800000000", touched), nil},
+		{"renamed", testTrustRoot(t, "sigstore", repository), []string{"-  name: tuf-repo-cdn.sigstore.dev-<timestamp>", "+  name: sigstore"}},
+		{"changed target", testTrustRoot(t, "tuf-repo-cdn.sigstore.dev-1700000000", rotated), []string{"-      targets/rekor.pub", "+      targets/rekor.pub"}},
+		{"rendered with wrapped lines", rendered.Bytes(), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := CompareManifests(golden, tt.generated)
+			if err != nil {
+				t.Fatalf("CompareManifests() error = %v", err)
+			}
+			if len(diff) != len(tt.wantDiff) {
+				t.Fatalf("CompareManifests() = %q, want %q", diff, tt.wantDiff)
+			}
+			for i := range diff {
+				if !strings.HasPrefix(diff[i], tt.wantDiff[i]) {
+					t.Errorf("CompareManifests()[%d] = %q, want prefix %q", i, diff[i], tt.wantDiff[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDeterministicMode(t *testing.T) {
+	defer func(clock func() time.Time) { now, deterministic = clock, false }(now)
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	if err := enableDeterministicMode(); err != nil {
+		t.Fatalf("enableDeterministicMode() error = %v", err)
+	}
+	if got := now(); got.Unix() != 1700000000 {
+		t.Errorf("now() = %v, want SOURCE_DATE_EPOCH", got)
+	}
+
+	archive := func(fileTime time.Time) []byte {
+		dir, err := mkdirTemp("deterministic-test-*")
+		if err != nil {
+			t.Fatalf("mkdirTemp() error = %v", err)
+		}
+		defer os.RemoveAll(dir)
+		if filepath.Base(dir) != "deterministic-test-deterministic" {
+			t.Errorf("mkdirTemp() = %s, want a fixed name", dir)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "1.root.json"), []byte(`{"signed":{}}`), 0o600); err != nil {
+			t.Fatalf("Failed to write root.json: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, "1.root.json"), fileTime, fileTime); err != nil {
+			t.Fatalf("Failed to set file times: %v", err)
+		}
+		dst := filepath.Join(t.TempDir(), "repository.tar.gz")
+		if err := CompressDirectory(dir, dst); err != nil {
+			t.Fatalf("CompressDirectory() error = %v", err)
+		}
+		content, err := os.ReadFile(dst)
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		return content
+	}
+	if !bytes.Equal(archive(time.Unix(1, 0)), archive(time.Unix(2, 0))) {
+		t.Errorf("CompressDirectory() archives of the same repository differ in deterministic mode")
+	}
+}
+
+func FuzzCompareManifests(f *testing.F) {
+	var archive bytes.Buffer
+	if err := assembler.CompressFS(fstest.MapFS{"targets/rekor.pub": {Data: []byte("rekor public key")}}, &archive); err != nil {
+		f.Fatalf("CompressFS() error = %v", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(archive.Bytes())
+	f.Add([]byte("kind: TrustRoot\nmetadata:\n  name: sigstore-1700000000\nspec:\n  repository:\n    mirrorFS: |-\n      " + encoded + "\n"))
+	f.Add([]byte("kind: ConfigMap\nbinaryData:\n  repository.tar.gz: " + encoded + "\n"))
+	f.Add([]byte("mirrorFS: |-\n"))
+	f.Fuzz(func(t *testing.T, manifest []byte) {
+		diff, err := CompareManifests(manifest, manifest)
+		if err == nil && len(diff) > 0 {
+			t.Errorf("CompareManifests() of a manifest with itself = %q", diff)
+		}
+	})
+}
+
+func FuzzArchiveListing(f *testing.F) {
+	var archive bytes.Buffer
+	if err := assembler.CompressFS(fstest.MapFS{"1.root.json": {Data: []byte(`{"signed":{}}`)}}, &archive); err != nil {
+		f.Fatalf("CompressFS() error = %v", err)
+	}
+	f.Add(base64.StdEncoding.EncodeToString(archive.Bytes()))
+	f.Add("H4sIAAAAAAAA/w==")
+	f.Add("not base64")
+	f.Fuzz(func(t *testing.T, encoded string) {
+		ArchiveListing(encoded)
+	})
+}
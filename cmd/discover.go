@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// scaffoldComponent identifies a Sigstore service installed by the
+// sigstore/scaffolding Helm charts.
+type scaffoldComponent struct {
+	namespace string
+	service   string
+	// path is the URL prefix the component is served under.
+	path string
+}
+
+// Default namespaces and service names used by the scaffolding Helm charts.
+var (
+	scaffoldFulcio = scaffoldComponent{namespace: "fulcio-system", service: "fulcio-server"}
+	scaffoldRekor  = scaffoldComponent{namespace: "rekor-system", service: "rekor-server"}
+	scaffoldCTLog  = scaffoldComponent{namespace: "ctlog-system", service: "ctlog", path: "/sigstorescaffolding"}
+	scaffoldTSA    = scaffoldComponent{namespace: "tsa-system", service: "tsa-server"}
+)
+
+// scaffoldCTLogSecret holds the CT log public key, it is created by the
+// scaffolding createctconfig job.
+const (
+	scaffoldCTLogSecret    = "ctlog-public-key"
+	scaffoldCTLogSecretKey = "public"
+)
+
+// DiscoverInCluster finds the Fulcio, Rekor, CT log and TSA services installed
+// by the sigstore/scaffolding Helm charts and collects their trust material.
+//
+// Fulcio and Rekor are required; the CT log and the TSA are included when installed.
+//
+// Parameters:
+//   - kube: A client for the Kubernetes API of the cluster to search.
+//
+// Returns:
+//   - The SigstoreKeys describing the private Sigstore instance.
+//   - An error if a required component is missing or its trust material could not be fetched.
+func DiscoverInCluster(kube *kubeClient) (SigstoreKeys, error) {
+	keys := SigstoreKeys{}
+
+	fulcioURL, err := discoverServiceURL(kube, scaffoldFulcio)
+	if err != nil {
+		return keys, fmt.Errorf("could not discover fulcio: %v", err)
+	}
+	ca, err := fetchCertificateAuthority(fulcioURL, fulcioURL+"/api/v1/rootCert")
+	if err != nil {
+		return keys, fmt.Errorf("could not get fulcio certificate chain: %v", err)
+	}
+	keys.CertificateAuthorities = append(keys.CertificateAuthorities, ca)
+
+	rekorURL, err := discoverServiceURL(kube, scaffoldRekor)
+	if err != nil {
+		return keys, fmt.Errorf("could not discover rekor: %v", err)
+	}
+	rekorKey, err := fetch(rekorURL + "/api/v1/log/publicKey")
+	if err != nil {
+		return keys, fmt.Errorf("could not get rekor public key: %v", err)
+	}
+	rekorKey, err = NormalizePublicKey(rekorKey)
+	if err != nil {
+		return keys, fmt.Errorf("invalid rekor public key: %v", err)
+	}
+	rekorLogID, err := LogID(rekorKey)
+	if err != nil {
+		return keys, fmt.Errorf("could not compute rekor log ID: %v", err)
+	}
+	keys.TLogs = append(keys.TLogs, TransparencyLogInstance{BaseURL: rekorURL, HashAlgorithm: "sha-256", PublicKey: rekorKey, LogID: rekorLogID})
+
+	ctlogURL, err := discoverServiceURL(kube, scaffoldCTLog)
+	switch {
+	case errors.Is(err, errKubeNotFound):
+		log.Printf("ctlog not found in namespace %s, skipping\n", scaffoldCTLog.namespace)
+	case err != nil:
+		return keys, fmt.Errorf("could not discover ctlog: %v", err)
+	default:
+		secret, err := kube.getSecret(scaffoldCTLog.namespace, scaffoldCTLogSecret)
+		if err != nil {
+			return keys, fmt.Errorf("could not get ctlog public key secret: %v", err)
+		}
+		ctlogKey, ok := secret.Data[scaffoldCTLogSecretKey]
+		if !ok {
+			return keys, fmt.Errorf("secret %s/%s has no %q key", scaffoldCTLog.namespace, scaffoldCTLogSecret, scaffoldCTLogSecretKey)
+		}
+		ctlogKey, err = NormalizePublicKey(ctlogKey)
+		if err != nil {
+			return keys, fmt.Errorf("invalid ctlog public key: %v", err)
+		}
+		ctlogLogID, err := LogID(ctlogKey)
+		if err != nil {
+			return keys, fmt.Errorf("could not compute ctlog log ID: %v", err)
+		}
+		keys.CTLogs = append(keys.CTLogs, TransparencyLogInstance{BaseURL: ctlogURL, HashAlgorithm: "sha-256", PublicKey: ctlogKey, LogID: ctlogLogID})
+	}
+
+	tsaURL, err := discoverServiceURL(kube, scaffoldTSA)
+	switch {
+	case errors.Is(err, errKubeNotFound):
+		log.Printf("tsa not found in namespace %s, skipping\n", scaffoldTSA.namespace)
+	case err != nil:
+		return keys, fmt.Errorf("could not discover tsa: %v", err)
+	default:
+		tsa, err := FetchTimestampAuthority(tsaURL)
+		if err != nil {
+			return keys, fmt.Errorf("could not get tsa certificate chain: %v", err)
+		}
+		keys.TimestampAuthorities = append(keys.TimestampAuthorities, tsa)
+	}
+
+	return keys, nil
+}
+
+// discoverServiceURL looks up the Service of component and returns its in-cluster URL.
+func discoverServiceURL(kube *kubeClient, component scaffoldComponent) (string, error) {
+	svc, err := kube.getService(component.namespace, component.service)
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("http://%s.%s.svc", svc.Metadata.Name, svc.Metadata.Namespace)
+	if len(svc.Spec.Ports) > 0 && svc.Spec.Ports[0].Port != 80 {
+		url = fmt.Sprintf("%s:%d", url, svc.Spec.Ports[0].Port)
+	}
+	log.Printf("discovered %s at %s\n", component.service, url)
+	return url + component.path, nil
+}
+
+// fetchCertificateAuthority downloads the PEM certificate chain at chainURL and
+// returns a CertificateAuthority whose subject is taken from the chain's root.
+func fetchCertificateAuthority(uri, chainURL string) (CertificateAuthority, error) {
+	chain, err := fetch(chainURL)
+	if err != nil {
+		return CertificateAuthority{}, err
+	}
+	return NewCertificateAuthority(uri, chain)
+}
+
+// FetchTimestampAuthority downloads the certificate chain of a running
+// Timestamp Authority from its /api/v1/timestamp/certchain endpoint.
+//
+// Parameters:
+//   - tsaURL: The base URL of the Timestamp Authority, also used as its URI.
+//
+// Returns:
+//   - The CertificateAuthority of the Timestamp Authority.
+//   - An error if the chain could not be fetched or holds no valid certificate.
+func FetchTimestampAuthority(tsaURL string) (CertificateAuthority, error) {
+	tsaURL = strings.TrimSuffix(tsaURL, "/")
+	return fetchCertificateAuthority(tsaURL, tsaURL+"/api/v1/timestamp/certchain")
+}
+
+// NewCertificateAuthority returns a CertificateAuthority for the certificate
+// chain, normalized by NormalizeCertificateChain, taking its subject from the
+// root of the chain.
+//
+// Parameters:
+//   - uri: The URI of the Fulcio instance or Timestamp Authority.
+//   - chain: The PEM or DER encoded certificate chain.
+//
+// Returns:
+//   - The CertificateAuthority.
+//   - An error if the chain is not a valid chain of currently valid certificates.
+func NewCertificateAuthority(uri string, chain []byte) (CertificateAuthority, error) {
+	chain, err := NormalizeCertificateChain(chain, now())
+	if err != nil {
+		return CertificateAuthority{}, err
+	}
+	root, err := rootCertificate(chain)
+	if err != nil {
+		return CertificateAuthority{}, err
+	}
+	ca := CertificateAuthority{URI: uri, CommonName: root.Subject.CommonName, CertChain: chain}
+	if len(root.Subject.Organization) > 0 {
+		ca.Organization = root.Subject.Organization[0]
+	}
+	return ca, nil
+}
+
+// rootCertificate returns the last certificate of a PEM encoded chain.
+func rootCertificate(chain []byte) (*x509.Certificate, error) {
+	var root *x509.Certificate
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		root = cert
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no certificate found in chain")
+	}
+	return root, nil
+}
+
+// fetch returns the body of a successful GET request to url.
+func fetch(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(resp)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testCertificatePEM returns a self-signed PEM certificate with the given subject.
+func testCertificatePEM(t *testing.T, organization, commonName string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{organization}, CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testCertificateChainPEM returns a PEM chain, leaf first, of certificates
+// with the given common names, each issued by the next and the last self-signed.
+func testCertificateChainPEM(t *testing.T, organization string, commonNames ...string) []byte {
+	t.Helper()
+	keys := make([]*ecdsa.PrivateKey, len(commonNames))
+	templates := make([]*x509.Certificate, len(commonNames))
+	for i, commonName := range commonNames {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		keys[i] = key
+		templates[i] = &x509.Certificate{
+			SerialNumber:          big.NewInt(int64(i + 1)),
+			Subject:               pkix.Name{Organization: []string{organization}, CommonName: commonName},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+	}
+	var chain []byte
+	for i := range commonNames {
+		parent, parentKey := templates[i], keys[i]
+		if i+1 < len(commonNames) {
+			parent, parentKey = templates[i+1], keys[i+1]
+		}
+		der, err := x509.CreateCertificate(rand.Reader, templates[i], parent, &keys[i].PublicKey, parentKey)
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %v", err)
+		}
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return chain
+}
+
+func TestRootCertificate(t *testing.T) {
+	leaf := testCertificatePEM(t, "leaf-org", "leaf")
+	root := testCertificatePEM(t, "root-org", "root")
+	tests := []struct {
+		name    string
+		chain   []byte
+		wantCN  string
+		wantErr bool
+	}{
+		{
+			name:   "single certificate",
+			chain:  root,
+			wantCN: "root",
+		},
+		{
+			name:   "leaf first chain",
+			chain:  append(append([]byte{}, leaf...), root...),
+			wantCN: "root",
+		},
+		{
+			name:    "no certificate",
+			chain:   []byte("not a pem"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rootCertificate(tt.chain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rootCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Subject.CommonName != tt.wantCN {
+				t.Errorf("rootCertificate() common name = %v, want %v", got.Subject.CommonName, tt.wantCN)
+			}
+		})
+	}
+}
+
+func TestFetchTimestampAuthority(t *testing.T) {
+	chain := testCertificateChainPEM(t, "tsa-org", "tsa-leaf", "tsa-root")
+	tests := []struct {
+		name    string
+		body    []byte
+		wantCN  string
+		wantErr bool
+	}{
+		{"certificate chain", chain, "tsa-root", false},
+		{"no certificate", []byte("not a pem"), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/timestamp/certchain" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			tsa, err := FetchTimestampAuthority(server.URL + "/")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchTimestampAuthority() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (tsa.CommonName != tt.wantCN || tsa.URI != server.URL) {
+				t.Errorf("FetchTimestampAuthority() = %s at %s, want %s at %s", tsa.CommonName, tsa.URI, tt.wantCN, server.URL)
+			}
+		})
+	}
+}
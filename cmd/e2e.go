@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// policyIncludeLabel opts a namespace in to the admission of the
+// policy-controller.
+const policyIncludeLabel = "policy.sigstore.dev/include"
+
+// e2ePollInterval is the delay between two admissions of the signed image
+// while the policy-controller picks up the TrustRoot and policy.
+var e2ePollInterval = 5 * time.Second
+
+// E2EOptions configures E2EVerify.
+type E2EOptions struct {
+	// Namespace is the namespace of the sample pods, labelled with
+	// policyIncludeLabel.
+	Namespace string
+	// SignedImage and UnsignedImage are fully qualified image references,
+	// the first signed keylessly with the Fulcio and Rekor of the TrustRoot,
+	// the second not signed at all.
+	SignedImage   string
+	UnsignedImage string
+	// IssuerRegExp and SubjectRegExp match the OIDC identity of the
+	// signature of SignedImage.
+	IssuerRegExp  string
+	SubjectRegExp string
+	// Timeout bounds the wait for the policy-controller to admit SignedImage
+	// once the TrustRoot and the policy are applied.
+	Timeout time.Duration
+	// Keep leaves the objects created by the check in the cluster.
+	Keep bool
+}
+
+// E2EVerify smoke tests a TrustRoot in a cluster running the
+// policy-controller: it applies the TrustRoot and a ClusterImagePolicy
+// requiring keyless signatures verified with it, then creates pods of the
+// signed and the unsigned image in dry-run, so nothing is scheduled, and
+// asserts the first is admitted and the second denied. The namespace, the
+// policy and the TrustRoot are deleted afterwards unless they existed before
+// or Keep is set.
+//
+// Parameters:
+//   - kube: The client of the test cluster.
+//   - manifest: The TrustRoot manifest, YAML or JSON.
+//   - options: The sample images and namespace of the check.
+//
+// Returns:
+//   - error: nil if both admission results are the expected ones, otherwise an error wrapping assembler.ErrVerification, or the error of the Kubernetes API.
+func E2EVerify(kube *kubeClient, manifest []byte, options E2EOptions) (err error) {
+	trustRoot, err := ReadTrustRoot(manifest)
+	if err != nil {
+		return err
+	}
+	name := trustRoot.Metadata.Name
+	policyName := "trustroot-e2e-" + name
+	namespace, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]any{"name": options.Namespace, "labels": map[string]string{policyIncludeLabel: "true"}},
+	})
+	policy, _ := json.Marshal(e2ePolicy(policyName, name, options))
+	objects := []struct {
+		kind, path string
+		manifest   []byte
+	}{
+		{"TrustRoot", trustRootPath(name), manifest},
+		{"Namespace", "/api/v1/namespaces/" + options.Namespace, namespace},
+		{"ClusterImagePolicy", clusterImagePoliciesPath + "/" + policyName, policy},
+	}
+	var created []string
+	defer func() {
+		if options.Keep {
+			return
+		}
+		for i := len(created) - 1; i >= 0; i-- {
+			if deleteErr := kube.delete(created[i]); deleteErr != nil && err == nil {
+				err = fmt.Errorf("could not clean up: %v", deleteErr)
+			}
+		}
+	}()
+	for _, object := range objects {
+		var existing json.RawMessage
+		getErr := kube.get(object.path, &existing)
+		if getErr != nil && !errors.Is(getErr, errKubeNotFound) {
+			return fmt.Errorf("could not get %s: %v", object.kind, getErr)
+		}
+		if err := kube.apply(object.path, object.manifest, false); err != nil {
+			return fmt.Errorf("could not apply %s: %v", object.kind, err)
+		}
+		if errors.Is(getErr, errKubeNotFound) {
+			created = append(created, object.path)
+		}
+		log.Printf("applied %s %s\n", object.kind, object.path[strings.LastIndex(object.path, "/")+1:])
+	}
+
+	deadline := time.Now().Add(options.Timeout)
+	for {
+		admitErr := e2eAdmit(kube, options.Namespace, "e2e-signed", options.SignedImage)
+		if admitErr == nil {
+			break
+		}
+		if !admissionDenied(admitErr) {
+			return fmt.Errorf("could not create a pod of the signed image: %v", admitErr)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: the signed image %s was not admitted within %s: %v", assembler.ErrVerification, options.SignedImage, options.Timeout, admitErr)
+		}
+		time.Sleep(e2ePollInterval)
+	}
+	log.Printf("admitted the signed image %s\n", options.SignedImage)
+	admitErr := e2eAdmit(kube, options.Namespace, "e2e-unsigned", options.UnsignedImage)
+	if admitErr == nil {
+		return fmt.Errorf("%w: the unsigned image %s was admitted, the policy does not enforce signatures", assembler.ErrVerification, options.UnsignedImage)
+	}
+	if !admissionDenied(admitErr) {
+		return fmt.Errorf("could not create a pod of the unsigned image: %v", admitErr)
+	}
+	log.Printf("denied the unsigned image %s\n", options.UnsignedImage)
+	return nil
+}
+
+// e2ePolicy returns the ClusterImagePolicy name of the sample images of
+// options, requiring keyless signatures verified with the TrustRoot
+// trustRoot.
+func e2ePolicy(name, trustRoot string, options E2EOptions) map[string]any {
+	return map[string]any{
+		"apiVersion": "policy.sigstore.dev/v1beta1",
+		"kind":       "ClusterImagePolicy",
+		"metadata":   map[string]any{"name": name},
+		"spec": map[string]any{
+			"images": []map[string]string{{"glob": options.SignedImage}, {"glob": options.UnsignedImage}},
+			"authorities": []map[string]any{{
+				"name": "trustroot",
+				"keyless": map[string]any{
+					"trustRootRef": trustRoot,
+					"identities":   []map[string]string{{"issuerRegExp": options.IssuerRegExp, "subjectRegExp": options.SubjectRegExp}},
+				},
+				"ctlog": map[string]any{"trustRootRef": trustRoot},
+			}},
+		},
+	}
+}
+
+// e2eAdmit creates the pod name of image in namespace in dry-run, so it runs
+// through admission without being persisted.
+func e2eAdmit(kube *kubeClient, namespace, name, image string) error {
+	pod, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]any{"name": name, "namespace": namespace},
+		"spec":       map[string]any{"containers": []map[string]string{{"name": "image", "image": image}}},
+	})
+	return kube.apply(fmt.Sprintf("/api/v1/namespaces/%s/pods/%s", namespace, name), pod, true)
+}
+
+// admissionDenied reports whether err is an admission webhook denying a
+// request.
+func admissionDenied(err error) bool {
+	return strings.Contains(err.Error(), "denied the request")
+}
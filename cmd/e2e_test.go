@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+)
+
+// fakePolicyController is a Kubernetes API server admitting the dry-run pods
+// of the images in signed and denying the others, once deniedAttempts
+// admissions of signed images were denied.
+type fakePolicyController struct {
+	mu             sync.Mutex
+	existing       map[string]bool
+	signed         map[string]bool
+	deniedAttempts int
+	requests       []string
+	// persistedPods is set if a pod was created without dry-run.
+	persistedPods bool
+}
+
+func (f *fakePolicyController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != http.MethodGet {
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	}
+	switch {
+	case r.Method == http.MethodGet && f.existing[r.URL.Path]:
+		w.Write([]byte(`{}`))
+	case r.Method == http.MethodGet:
+		http.NotFound(w, r)
+	case r.Method == http.MethodPatch && strings.Contains(r.URL.Path, "/pods/"):
+		if r.URL.Query().Get("dryRun") != "All" {
+			f.persistedPods = true
+		}
+		body, _ := io.ReadAll(r.Body)
+		for image := range f.signed {
+			if strings.Contains(string(body), `"image":"`+image+`"`) {
+				if f.deniedAttempts == 0 {
+					return
+				}
+				f.deniedAttempts--
+			}
+		}
+		http.Error(w, `admission webhook "policy.sigstore.dev" denied the request: validation failed`, http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func TestE2EVerify(t *testing.T) {
+	defer func(interval time.Duration) { e2ePollInterval = interval }(e2ePollInterval)
+	e2ePollInterval = time.Millisecond
+	manifest := testTrustRootObject(t, map[string]any{"remote": map[string]any{"mirror": "https://tuf.example.com"}})
+	options := E2EOptions{Namespace: "e2e", SignedImage: "registry.example.com/signed:1", UnsignedImage: "registry.example.com/unsigned:1", IssuerRegExp: ".*", SubjectRegExp: ".*", Timeout: time.Second}
+	applied := []string{
+		"PATCH " + trustRootPath("sigstore"),
+		"PATCH /api/v1/namespaces/e2e",
+		"PATCH " + clusterImagePoliciesPath + "/trustroot-e2e-sigstore",
+	}
+
+	tests := []struct {
+		name           string
+		existing       map[string]bool
+		signed         []string
+		deniedAttempts int
+		timeout        time.Duration
+		wantErr        error
+		wantDeleted    []string
+	}{
+		{
+			name:           "signed admitted once the policy is picked up",
+			signed:         []string{options.SignedImage},
+			deniedAttempts: 2,
+			wantDeleted:    []string{"DELETE " + clusterImagePoliciesPath + "/trustroot-e2e-sigstore", "DELETE /api/v1/namespaces/e2e", "DELETE " + trustRootPath("sigstore")},
+		},
+		{
+			name:        "existing objects are kept",
+			existing:    map[string]bool{trustRootPath("sigstore"): true, "/api/v1/namespaces/e2e": true},
+			signed:      []string{options.SignedImage},
+			wantDeleted: []string{"DELETE " + clusterImagePoliciesPath + "/trustroot-e2e-sigstore"},
+		},
+		{
+			name:     "unsigned admitted",
+			existing: map[string]bool{trustRootPath("sigstore"): true, "/api/v1/namespaces/e2e": true, clusterImagePoliciesPath + "/trustroot-e2e-sigstore": true},
+			signed:   []string{options.SignedImage, options.UnsignedImage},
+			wantErr:  assembler.ErrVerification,
+		},
+		{
+			name:           "signed never admitted",
+			existing:       map[string]bool{trustRootPath("sigstore"): true, "/api/v1/namespaces/e2e": true, clusterImagePoliciesPath + "/trustroot-e2e-sigstore": true},
+			signed:         []string{options.SignedImage},
+			deniedAttempts: 1 << 30,
+			timeout:        10 * time.Millisecond,
+			wantErr:        assembler.ErrVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &fakePolicyController{existing: tt.existing, signed: map[string]bool{}, deniedAttempts: tt.deniedAttempts}
+			for _, image := range tt.signed {
+				controller.signed[image] = true
+			}
+			server := httptest.NewServer(controller)
+			defer server.Close()
+			kube := &kubeClient{host: server.URL, client: server.Client()}
+
+			options := options
+			if tt.timeout > 0 {
+				options.Timeout = tt.timeout
+			}
+			err := E2EVerify(kube, manifest, options)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("E2EVerify() error = %v, want %v", err, tt.wantErr)
+			}
+			if controller.persistedPods {
+				t.Error("E2EVerify() created pods without dry-run")
+			}
+			var got []string
+			for _, request := range controller.requests {
+				if !strings.Contains(request, "/pods/") {
+					got = append(got, request)
+				}
+			}
+			if want := append(append([]string{}, applied...), tt.wantDeleted...); !reflect.DeepEqual(got, want) {
+				t.Errorf("requests = %q, want %q", got, want)
+			}
+		})
+	}
+}
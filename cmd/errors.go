@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"cmd/assembler"
+)
+
+// Exit codes of the failure classes of assembler, next to exitCodeExpiring,
+// so scheduled jobs can alert on an unreachable mirror differently than on a
+// repository that does not verify.
+const (
+	exitCodeUnreachable  = 4
+	exitCodeVerification = 5
+	exitCodeOversized    = 6
+)
+
+// exitCode returns the exit code of the failure class err wraps, 1 for other
+// errors.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, assembler.ErrMetadataExpired):
+		return exitCodeExpiring
+	case errors.Is(err, assembler.ErrMirrorUnreachable):
+		return exitCodeUnreachable
+	case errors.Is(err, assembler.ErrVerification):
+		return exitCodeVerification
+	case errors.Is(err, assembler.ErrOversizedOutput):
+		return exitCodeOversized
+	case errors.Is(err, errRunTimeout):
+		return exitCodeRunTimeout
+	case errors.Is(err, errRootRotated):
+		return exitCodeRootRotated
+	}
+	return 1
+}
+
+// fatalf is log.Fatalf exiting with the exit code of err, followed by the
+// remediation hint of err if there is one and the temporary files kept by
+// --skip-cleanup, pushing the failure to the --pushgateway. The message is
+// formatted from format and v, err is usually the last of v.
+func fatalf(err error, format string, v ...any) {
+	log.Printf(format, v...)
+	if hint := remediationHint(err); hint != "" {
+		log.Printf("hint: %s\n", hint)
+	}
+	logKeptPaths()
+	pushFailureMetrics(exitCode(err))
+	os.Exit(exitCode(err))
+}
+
+// remediationHint explains the failure class of err and suggests next steps,
+// "" if there is nothing to suggest. The most specific cause is explained:
+// an HTTP status or network error before the failure class wrapping it.
+func remediationHint(err error) string {
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden:
+			return fmt.Sprintf("the mirror returned %s: if this is a private mirror, read it from its bucket (s3://, gs://) or registry (oci://) with credentials instead of over HTTP", status.Status)
+		case status.StatusCode == http.StatusNotFound:
+			return "the mirror returned 404 Not Found: --mirror must be the root of the TUF repository, serving N.root.json and timestamp.json"
+		case status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= 500:
+			return fmt.Sprintf("the mirror returned %s: it is overloaded or failing, retry later", status.Status)
+		}
+	}
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCertificate x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("%s does not resolve: check the --mirror URL and the DNS configuration of the job", dnsErr.Name)
+	case errors.As(err, &unknownAuthority), errors.As(err, &invalidCertificate), errors.As(err, &hostname):
+		return "the TLS certificate of the mirror is not trusted: add its certificate authority to SSL_CERT_FILE or SSL_CERT_DIR"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "nothing listens at the mirror address: check the --mirror URL, or the HTTPS_PROXY and NO_PROXY of the job"
+	case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err):
+		return "the mirror did not answer in time: raise --http-timeout, or check the HTTPS_PROXY and NO_PROXY of the job"
+	case errors.Is(err, errRunTimeout):
+		return "pass --debug to find the request that hangs, or raise --run-timeout if the repository is large"
+	case errors.Is(err, assembler.ErrMetadataExpired):
+		return "the mirror serves stale metadata: check that it is synced with its upstream, e.g. by mirror-sync, or lower --expiry-grace"
+	case errors.Is(err, errAuditTampered):
+		return "the audit log was modified after its entries were written: compare it with a copy kept elsewhere, e.g. a previous version of its bucket object, and investigate who had write access"
+	case errors.Is(err, assembler.ErrVerification):
+		return "the repository does not verify against its root: the mirror may be partially synced or tampered with, re-sync it and check the root.json it is bootstrapped from"
+	case errors.Is(err, errRootRotated):
+		return "the upstream root keys or thresholds changed: check the new keys against the announcements of the repository owners, e.g. with --show-root-keys, before shipping the TrustRoot"
+	case errors.Is(err, errArchiveLimit):
+		return "the repository archive is larger than downstream systems accept: raise --max-archive-size if they allow it, or trim the repository at its source"
+	case errors.Is(err, assembler.ErrOversizedOutput):
+		return "the repository is too large to be embedded in one Kubernetes object: use --output trusted-root, or serve the repository with the serve command"
+	case errors.Is(err, assembler.ErrMirrorUnreachable):
+		return "check the --mirror URL and that the mirror is reachable from the job; pass --debug to log the HTTP requests"
+	}
+	return ""
+}
+
+// sizeError returns an error wrapping assembler.ErrOversizedOutput if an output
+// of size bytes exceeds limit bytes.
+func sizeError(kind string, size, limit int) error {
+	if size > limit {
+		return fmt.Errorf("%w: %s of %d bytes exceeds the limit of %d bytes", assembler.ErrOversizedOutput, kind, size, limit)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+)
+
+func TestExitCode(t *testing.T) {
+	expired := CheckExpiry(map[string][]byte{"timestamp.json": []byte(`{"signed":{"expires":"2000-01-01T00:00:00Z"}}`)}, time.Hour, time.Now())
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"expired", expired, exitCodeExpiring},
+		{"unreachable", fmt.Errorf("could not get root.json: %w: connection refused", assembler.ErrMirrorUnreachable), exitCodeUnreachable},
+		{"verification", assembler.ClassifyTUFError(errors.New("tuf: signature verification failed")), exitCodeVerification},
+		{"oversized", sizeError("TrustRoot", 10, 5), exitCodeOversized},
+		{"run timeout", errRunTimeout, exitCodeRunTimeout},
+		{"root rotated", errRootRotated, exitCodeRootRotated},
+		{"other", errors.New("could not create temporary directory"), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+	if err := sizeError("TrustRoot", 5, 5); err != nil {
+		t.Errorf("sizeError() at the limit = %v, want nil", err)
+	}
+}
+
+func TestRemediationHint(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"forbidden", fmt.Errorf("%w: %w", assembler.ErrMirrorUnreachable, &StatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"}), "if this is a private mirror"},
+		{"not found", &StatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}, "root of the TUF repository"},
+		{"unavailable", &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}, "retry later"},
+		{"dns", fmt.Errorf("%w: %w", assembler.ErrMirrorUnreachable, &net.DNSError{Name: "tuf.example.com", Err: "no such host"}), "tuf.example.com does not resolve"},
+		{"untrusted certificate", x509.UnknownAuthorityError{}, "SSL_CERT_FILE"},
+		{"connection refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "nothing listens"},
+		{"timeout", fmt.Errorf("could not get timestamp.json: %w", context.DeadlineExceeded), "--http-timeout"},
+		{"expired", fmt.Errorf("%w: timestamp.json expires soon", assembler.ErrMetadataExpired), "--expiry-grace"},
+		{"verification", assembler.ClassifyTUFError(errors.New("tuf: signature verification failed")), "does not verify"},
+		{"audit log tampered", fmt.Errorf("%w: %w: entry 1 on line 1 does not match its hash", assembler.ErrVerification, errAuditTampered), "modified after its entries were written"},
+		{"root rotated", errRootRotated, "--show-root-keys"},
+		{"oversized", sizeError("TrustRoot", 10, 5), "--output trusted-root"},
+		{"archive limit", fmt.Errorf("%w: %w of 10 bytes", assembler.ErrOversizedOutput, errArchiveLimit), "raise --max-archive-size"},
+		{"unreachable", fmt.Errorf("%w: unexpected EOF", assembler.ErrMirrorUnreachable), "--debug"},
+		{"other", errors.New("could not create temporary directory"), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := remediationHint(tt.err)
+			if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+				t.Errorf("remediationHint(%v) = %q, want it to contain %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// exitCodeExpiring is the exit code of a run refused by --expiry-grace, so a
+// CronJob can tell stale upstream metadata apart from other failures.
+const exitCodeExpiring = 3
+
+// CheckExpiry checks that no metadata expires within grace of now.
+//
+// Parameters:
+//   - metadata: The metadata documents to check, by file name.
+//   - grace: The minimum remaining validity, typically the refresh interval of the TrustRoot.
+//   - now: The current time.
+//
+// Returns:
+//   - error: nil if all the metadata is valid for at least grace, otherwise an error wrapping assembler.ErrMetadataExpired naming every expiring document.
+func CheckExpiry(metadata map[string][]byte, grace time.Duration, now time.Time) error {
+	expiring, err := expiringMetadata(metadata, grace, now)
+	if err != nil {
+		return err
+	}
+	if len(expiring) > 0 {
+		return fmt.Errorf("%w: metadata expires within the grace period of %s: %s", assembler.ErrMetadataExpired, grace, strings.Join(expiring, ", "))
+	}
+	return nil
+}
+
+// expiringMetadata describes the metadata documents expiring within d of
+// now, e.g. "timestamp.json expires 2025-01-01T00:00:00Z", by file name.
+func expiringMetadata(metadata map[string][]byte, d time.Duration, now time.Time) ([]string, error) {
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var expiring []string
+	for _, name := range names {
+		var signed struct {
+			Signed struct {
+				Expires time.Time `json:"expires"`
+			} `json:"signed"`
+		}
+		if err := json.Unmarshal(metadata[name], &signed); err != nil {
+			return nil, fmt.Errorf("could not read expiry of %s: %v", name, err)
+		}
+		if expires := signed.Signed.Expires; expires.Before(now.Add(d)) {
+			expiring = append(expiring, fmt.Sprintf("%s expires %s", name, expires.UTC().Format(time.RFC3339)))
+		}
+	}
+	return expiring, nil
+}
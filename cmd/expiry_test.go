@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	metadata := map[string][]byte{
+		"timestamp.json":    []byte(`{"signed": {"expires": "2025-01-02T00:00:00Z"}}`),
+		"156.snapshot.json": []byte(`{"signed": {"expires": "2025-06-01T00:00:00Z"}}`),
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string][]byte
+		grace    time.Duration
+		wantErr  string
+	}{
+		{"valid beyond grace", metadata, 12 * time.Hour, ""},
+		{"timestamp within grace", metadata, 48 * time.Hour, "timestamp.json expires 2025-01-02T00:00:00Z"},
+		{"already expired", map[string][]byte{"timestamp.json": []byte(`{"signed": {"expires": "2024-12-31T00:00:00Z"}}`)}, 0, "timestamp.json expires"},
+		{"invalid metadata", map[string][]byte{"timestamp.json": []byte(`{`)}, time.Hour, "could not read expiry of timestamp.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckExpiry(tt.metadata, tt.grace, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckExpiry() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckExpiry() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
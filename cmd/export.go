@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	leveldbstore "github.com/theupdateframework/go-tuf/client/leveldbstore"
+)
+
+// TUF cache layouts written by ExportCache.
+const (
+	// cacheLayoutCosign is the cache of cosign built on sigstore/sigstore
+	// pkg/tuf: tuf.db, a LevelDB of the top-level metadata, remote.json naming
+	// the mirror and targets/.
+	cacheLayoutCosign = "cosign"
+	// cacheLayoutSigstoreGo is the cache of sigstore-go and recent cosign
+	// releases: a directory named after the mirror with root.json, ...,
+	// timestamp.json and targets/.
+	cacheLayoutSigstoreGo = "sigstore-go"
+)
+
+// defaultCacheDir returns the TUF cache directory of Sigstore clients,
+// $TUF_ROOT or else ~/.sigstore/root.
+func defaultCacheDir() string {
+	if dir := os.Getenv("TUF_ROOT"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".sigstore", "root")
+	}
+	return filepath.Join(home, ".sigstore", "root")
+}
+
+// ExportCache writes a verified copy of the TUF repository served by mirror
+// into dir as the local TUF cache of a Sigstore client, so that the client
+// starts from the verified metadata and targets without contacting the
+// mirror on its first run.
+//
+// Parameters:
+//   - mirror: The URL of the TUF repository mirror, or any source of NewFetcher; recorded as the mirror of the cache.
+//   - dir: The cache directory, e.g. ~/.sigstore/root, created if missing.
+//   - layout: cacheLayoutCosign or cacheLayoutSigstoreGo.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func ExportCache(mirror, dir, layout string) error {
+	if layout != cacheLayoutCosign && layout != cacheLayoutSigstoreGo {
+		return fmt.Errorf("unknown cache layout %q, expected %s or %s", layout, cacheLayoutCosign, cacheLayoutSigstoreGo)
+	}
+	mirror = strings.TrimSuffix(mirror, "/")
+	rootJSON, err := fetchLatestRoot(mirror)
+	if err != nil {
+		return fmt.Errorf("could not get the latest root.json: %v", err)
+	}
+	repository, err := openVerifiedRepository(mirror, rootJSON)
+	if err != nil {
+		return err
+	}
+	meta, err := repository.Local.GetMeta()
+	if err != nil {
+		return err
+	}
+
+	cacheDir := dir
+	if layout == cacheLayoutSigstoreGo {
+		cacheDir = filepath.Join(dir, sigstoreGoCacheName(mirror))
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	for name := range repository.Targets {
+		if err := repository.DownloadTarget(name, filepath.Join(cacheDir, "targets")); err != nil {
+			return err
+		}
+	}
+
+	switch layout {
+	case cacheLayoutCosign:
+		remote, err := json.Marshal(struct {
+			Mirror string `json:"mirror"`
+		}{mirror})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(cacheDir, "remote.json"), remote, 0o644); err != nil {
+			return err
+		}
+		db, err := leveldbstore.FileLocalStore(filepath.Join(cacheDir, "tuf.db"))
+		if err != nil {
+			return fmt.Errorf("could not open tuf.db: %v", err)
+		}
+		defer db.Close()
+		for name, content := range meta {
+			if err := db.SetMeta(name, content); err != nil {
+				return fmt.Errorf("could not write %s to tuf.db: %v", name, err)
+			}
+		}
+	case cacheLayoutSigstoreGo:
+		for name, content := range meta {
+			if err := os.WriteFile(filepath.Join(cacheDir, name), content, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	log.Printf("exported %s to %s in the %s layout, %d targets\n", mirror, cacheDir, layout, len(repository.Targets))
+	return nil
+}
+
+// sigstoreGoCacheName returns the name of the cache directory of mirror in
+// sigstore-go: the URL without scheme in lower case, with "/" and ":"
+// replaced by "-", e.g. tuf-repo-cdn.sigstore.dev.
+func sigstoreGoCacheName(mirror string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(mirror, "https://"), "http://")
+	name = strings.ReplaceAll(name, "/", "-")
+	name = strings.ReplaceAll(name, ":", "-")
+	return strings.ToLower(name)
+}
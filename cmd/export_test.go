@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/mockmirror"
+	leveldbstore "github.com/theupdateframework/go-tuf/client/leveldbstore"
+)
+
+func TestExportCache(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		layout string
+		check  func(t *testing.T, dir string) string
+	}{
+		{"cosign", cacheLayoutCosign, func(t *testing.T, dir string) string {
+			content, err := os.ReadFile(filepath.Join(dir, "remote.json"))
+			if err != nil {
+				t.Fatalf("remote.json is missing: %v", err)
+			}
+			var remote struct {
+				Mirror string `json:"mirror"`
+			}
+			if err := json.Unmarshal(content, &remote); err != nil || remote.Mirror != server.URL {
+				t.Errorf("remote.json = %s, want the mirror %s", content, server.URL)
+			}
+			db, err := leveldbstore.FileLocalStore(filepath.Join(dir, "tuf.db"))
+			if err != nil {
+				t.Fatalf("Failed to open tuf.db: %v", err)
+			}
+			defer db.Close()
+			meta, err := db.GetMeta()
+			if err != nil {
+				t.Fatalf("Failed to read tuf.db: %v", err)
+			}
+			for _, role := range inspectRoles {
+				if _, ok := meta[role]; !ok {
+					t.Errorf("tuf.db has no %s", role)
+				}
+			}
+			return dir
+		}},
+		{"sigstore-go", cacheLayoutSigstoreGo, func(t *testing.T, dir string) string {
+			cacheDir := filepath.Join(dir, sigstoreGoCacheName(server.URL))
+			for _, role := range inspectRoles {
+				if _, err := os.Stat(filepath.Join(cacheDir, role)); err != nil {
+					t.Errorf("%s is missing: %v", role, err)
+				}
+			}
+			return cacheDir
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := ExportCache(server.URL+"/", dir, tt.layout); err != nil {
+				t.Fatalf("ExportCache() error = %v", err)
+			}
+			cacheDir := tt.check(t, dir)
+			for _, target := range mockmirror.Targets {
+				if _, err := os.Stat(filepath.Join(cacheDir, "targets", target)); err != nil {
+					t.Errorf("target %s is missing: %v", target, err)
+				}
+			}
+		})
+	}
+
+	if err := ExportCache(server.URL, t.TempDir(), "tuf-client"); err == nil || !strings.Contains(err.Error(), "unknown cache layout") {
+		t.Errorf("ExportCache() with an unknown layout error = %v", err)
+	}
+}
+
+func TestSigstoreGoCacheName(t *testing.T) {
+	tests := []struct {
+		mirror string
+		want   string
+	}{
+		{"https://tuf-repo-cdn.sigstore.dev", "tuf-repo-cdn.sigstore.dev"},
+		{"http://127.0.0.1:8080", "127.0.0.1-8080"},
+		{"https://Mirror.example.com/sigstore/tuf", "mirror.example.com-sigstore-tuf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mirror, func(t *testing.T) {
+			if got := sigstoreGoCacheName(tt.mirror); got != tt.want {
+				t.Errorf("sigstoreGoCacheName(%q) = %q, want %q", tt.mirror, got, tt.want)
+			}
+		})
+	}
+}
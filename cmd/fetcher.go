@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+// Fetcher reads the files of a TUF repository from a source. Missing files are
+// reported with client.ErrNotFound.
+type Fetcher interface {
+	// GetMetadata opens the metadata file name, e.g. 10.root.json.
+	GetMetadata(name string) (io.ReadCloser, int64, error)
+	// GetTarget opens the target file at path, relative to targets/.
+	GetTarget(path string) (io.ReadCloser, int64, error)
+}
+
+// NewFetcher returns the Fetcher of source, selected by URL scheme:
+//
+//   - http(s)://host/path: a TUF mirror,
+//   - file:///path or a plain path: a directory laid out like a mirror, e.g. by mirror-sync,
+//   - s3://bucket/prefix: an S3 bucket, anonymously or with the AWS_* credentials of PublishDirectory,
+//   - oci://registry/repository:tag: an OCI artifact with one layer per file, titled with its path in the repository.
+//
+// Parameters:
+//   - source: The URL or path of the repository.
+//
+// Returns:
+//   - The Fetcher.
+//   - An error if the scheme is not supported.
+func NewFetcher(source string) (Fetcher, error) {
+	u, err := url.Parse(source)
+	if err != nil || u.Scheme == "" || len(u.Scheme) == 1 {
+		// Plain paths, including Windows drive letters
+		return dirFetcher{dir: source}, nil
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return httpFetcher{base: mirrorBase(u), client: httpClient}, nil
+	case "file":
+		return dirFetcher{dir: filepath.FromSlash(u.Path)}, nil
+	case "s3":
+		s, err := newS3Storage(u.Host, strings.Trim(u.Path, "/"))
+		if err != nil {
+			return nil, err
+		}
+		return s3Fetcher{storage: s}, nil
+	case "oci":
+		return newOCIFetcher("https", strings.TrimPrefix(source, "oci://"))
+	default:
+		return nil, fmt.Errorf("unsupported repository source %q", source)
+	}
+}
+
+// mirrorBase returns the URL of an HTTP mirror as the base of its files: the
+// scheme and host lowercased, the path ending with exactly one slash so that
+// files resolve under a path prefix like https://artifacts.corp/tuf/sigstore/,
+// the query, e.g. a signed token, kept for every file and the fragment
+// dropped.
+func mirrorBase(mirror *url.URL) *url.URL {
+	base := *mirror
+	base.Scheme, base.Host = strings.ToLower(base.Scheme), strings.ToLower(base.Host)
+	base.Fragment, base.RawFragment = "", ""
+	base.Path = strings.TrimRight(base.Path, "/") + "/"
+	base.RawPath = ""
+	if escaped := strings.TrimRight(mirror.EscapedPath(), "/") + "/"; escaped != base.EscapedPath() {
+		base.RawPath = escaped
+	}
+	return &base
+}
+
+// mirrorFileURL returns the URL of the repository file name, e.g.
+// targets/rekor.pub, under the base URL of mirrorBase, its path segments
+// escaped and the query of base kept.
+func mirrorFileURL(base *url.URL, name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return base.JoinPath(segments...).String()
+}
+
+// NormalizeMirrorURL returns the canonical form of the URL of an HTTP mirror,
+// without the trailing slash of mirrorBase, so equivalent spellings of a
+// mirror name the same repository. Other sources are returned unchanged.
+//
+// Parameters:
+//   - mirror: The URL of the mirror, e.g. HTTPS://Tuf-Repo-CDN.sigstore.dev/.
+//
+// Returns:
+//   - The normalized URL, e.g. https://tuf-repo-cdn.sigstore.dev.
+func NormalizeMirrorURL(mirror string) string {
+	u, err := url.Parse(mirror)
+	if err != nil || !isHTTPSource(strings.ToLower(mirror)) {
+		return mirror
+	}
+	base := mirrorBase(u)
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	base.RawPath = strings.TrimSuffix(base.RawPath, "/")
+	return base.String()
+}
+
+// isHTTPSource reports whether source is a mirror served over HTTP.
+func isHTTPSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// fetchMetadata reads the metadata file name from f.
+func fetchMetadata(f Fetcher, name string) ([]byte, error) {
+	body, _, err := f.GetMetadata(name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// fetchTargetFile reads the target file at path from f.
+func fetchTargetFile(f Fetcher, path string) ([]byte, error) {
+	body, _, err := f.GetTarget(path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// latestRoot returns the latest N.root.json of f, see assembler.LatestRoot.
+func latestRoot(f Fetcher) ([]byte, error) {
+	return assembler.LatestRoot(remoteStore{f})
+}
+
+// remoteStore adapts a Fetcher to the client.RemoteStore of go-tuf.
+type remoteStore struct {
+	Fetcher
+}
+
+func (r remoteStore) GetMeta(name string) (io.ReadCloser, int64, error) {
+	return r.GetMetadata(name)
+}
+
+// httpFetcher reads a repository from an HTTP mirror, following the
+// redirects of every file.
+type httpFetcher struct {
+	// base is the mirrorBase of the mirror.
+	base   *url.URL
+	client *http.Client
+}
+
+func (h httpFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return h.get(name)
+}
+
+func (h httpFetcher) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return h.get("targets/" + path)
+}
+
+func (h httpFetcher) get(name string) (io.ReadCloser, int64, error) {
+	resp, err := h.client.Get(mirrorFileURL(h.base, name))
+	if err != nil {
+		return nil, 0, err
+	}
+	return responseBody(resp, name)
+}
+
+// responseBody returns the body of a successful resp for the repository file
+// name, mapping 403 and 404 statuses to client.ErrNotFound as storage
+// services answer either for missing objects.
+func responseBody(resp *http.Response, name string) (io.ReadCloser, int64, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, resp.ContentLength, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		resp.Body.Close()
+		return nil, 0, client.ErrNotFound{File: name}
+	default:
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("failed to fetch %s: %w", name, newStatusError(resp))
+	}
+}
+
+// s3Fetcher reads a repository from an S3 bucket.
+type s3Fetcher struct {
+	storage *s3Publisher
+}
+
+func (s s3Fetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return s.get(name)
+}
+
+func (s s3Fetcher) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return s.get("targets/" + path)
+}
+
+func (s s3Fetcher) get(key string) (io.ReadCloser, int64, error) {
+	resp, err := s.storage.get(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return responseBody(resp, key)
+}
+
+// ociTitleAnnotation names the file a layer of an OCI artifact holds.
+const ociTitleAnnotation = "org.opencontainers.image.title"
+
+// ociFetcher reads a repository from an OCI artifact, as pushed by
+// `oras push registry/repository:tag <files>` from a mirrored directory.
+// Pulls are anonymous, with the bearer token flow of the distribution spec.
+type ociFetcher struct {
+	registry   string
+	repository string
+	reference  string
+	scheme     string
+	client     *http.Client
+
+	once   sync.Once
+	token  string
+	layers map[string]string
+	err    error
+}
+
+func newOCIFetcher(scheme, ref string) (*ociFetcher, error) {
+	registry, repository, ok := strings.Cut(ref, "/")
+	if !ok || repository == "" {
+		return nil, fmt.Errorf("invalid OCI reference %q, expected registry/repository:tag", ref)
+	}
+	reference := "latest"
+	if i := strings.LastIndex(repository, "@"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 {
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	return &ociFetcher{registry: registry, repository: repository, reference: reference, scheme: scheme, client: httpClient}, nil
+}
+
+func (o *ociFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return o.get(name)
+}
+
+func (o *ociFetcher) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return o.get("targets/" + path)
+}
+
+func (o *ociFetcher) get(name string) (io.ReadCloser, int64, error) {
+	o.once.Do(func() { o.err = o.loadManifest() })
+	if o.err != nil {
+		return nil, 0, o.err
+	}
+	digest, ok := o.layers[path.Clean(name)]
+	if !ok {
+		return nil, 0, client.ErrNotFound{File: name}
+	}
+	resp, err := o.do(fmt.Sprintf("/v2/%s/blobs/%s", o.repository, digest), "")
+	if err != nil {
+		return nil, 0, err
+	}
+	return responseBody(resp, name)
+}
+
+// loadManifest fetches the manifest of the artifact and indexes its layers.
+func (o *ociFetcher) loadManifest() error {
+	resp, err := o.do(fmt.Sprintf("/v2/%s/manifests/%s", o.repository, o.reference), "application/vnd.oci.image.manifest.v1+json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get manifest of %s/%s:%s: %s", o.registry, o.repository, o.reference, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	o.layers, err = ParseOCIManifest(body)
+	if err != nil {
+		return fmt.Errorf("could not parse manifest of %s/%s: %v", o.registry, o.repository, err)
+	}
+	return nil
+}
+
+// ParseOCIManifest returns the digests of the layers of an OCI image manifest
+// by the file name of their org.opencontainers.image.title annotation.
+// Layers without a title are ignored.
+//
+// Parameters:
+//   - manifest: The manifest JSON, untrusted remote content.
+//
+// Returns:
+//   - The layer digests by cleaned file name.
+//   - An error if the manifest is not valid JSON.
+func ParseOCIManifest(manifest []byte) (map[string]string, error) {
+	var parsed struct {
+		Layers []struct {
+			Digest      string            `json:"digest"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		return nil, err
+	}
+	layers := map[string]string{}
+	for _, layer := range parsed.Layers {
+		if title := layer.Annotations[ociTitleAnnotation]; title != "" {
+			layers[path.Clean(title)] = layer.Digest
+		}
+	}
+	return layers, nil
+}
+
+// ParseBearerChallenge returns the parameters of a
+// `Bearer realm="...",service="...",scope="..."` WWW-Authenticate challenge.
+//
+// Parameters:
+//   - challenge: The WWW-Authenticate header, untrusted remote content.
+//
+// Returns:
+//   - The challenge parameters by name, unquoted.
+//   - An error if the challenge is not a Bearer challenge.
+func ParseBearerChallenge(challenge string) (map[string]string, error) {
+	params, ok := strings.CutPrefix(challenge, "Bearer ")
+	if !ok {
+		return nil, fmt.Errorf("%q is not a Bearer challenge", challenge)
+	}
+	values := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			values[key] = strings.Trim(value, `"`)
+		}
+	}
+	return values, nil
+}
+
+// do GETs path from the registry, fetching an anonymous bearer token when the
+// registry asks for one.
+func (o *ociFetcher) do(apiPath, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, o.scheme+"://"+o.registry+apiPath, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if o.token != "" {
+			req.Header.Set("Authorization", "Bearer "+o.token)
+		}
+		resp, err := o.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if o.token, err = o.fetchToken(resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchToken requests an anonymous pull token from the realm of a
+// `Bearer realm="...",service="...",scope="..."` challenge.
+func (o *ociFetcher) fetchToken(challenge string) (string, error) {
+	values, err := ParseBearerChallenge(challenge)
+	if err != nil {
+		return "", fmt.Errorf("registry %s asks for unsupported authentication: %v", o.registry, err)
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return "", fmt.Errorf("registry %s sent an invalid token realm %q", o.registry, values["realm"])
+	}
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + o.repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+	resp, err := o.client.Get(realm.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get a pull token from %s: %s", realm.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
+	return token.Token, nil
+}
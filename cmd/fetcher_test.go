@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+func TestNewFetcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{"https", "https://tuf-repo-cdn.sigstore.dev", "main.httpFetcher", false},
+		{"file URL", "file:///var/lib/tuf", "main.dirFetcher", false},
+		{"path", "./repo", "main.dirFetcher", false},
+		{"windows path", `C:\repo`, "main.dirFetcher", false},
+		{"s3", "s3://bucket/prefix", "main.s3Fetcher", false},
+		{"oci", "oci://ghcr.io/org/tuf:latest", "*main.ociFetcher", false},
+		{"invalid oci", "oci://ghcr.io", "", true},
+		{"unsupported", "ftp://example.com/repo", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFetcher(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFetcher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprintf("%T", got) != tt.want {
+				t.Errorf("NewFetcher() = %T, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorFileURL(t *testing.T) {
+	tests := []struct {
+		mirror string
+		name   string
+		want   string
+	}{
+		{"https://tuf-repo-cdn.sigstore.dev", "timestamp.json", "https://tuf-repo-cdn.sigstore.dev/timestamp.json"},
+		{"https://artifacts.corp/tuf/sigstore/", "targets/rekor.pub", "https://artifacts.corp/tuf/sigstore/targets/rekor.pub"},
+		{"https://artifacts.corp/tuf/sigstore//", "1.root.json", "https://artifacts.corp/tuf/sigstore/1.root.json"},
+		{"HTTPS://Artifacts.Corp/tuf?token=abc#top", "2.snapshot.json", "https://artifacts.corp/tuf/2.snapshot.json?token=abc"},
+		{"https://artifacts.corp/tuf%20mirror", "targets/a b#c.pem", "https://artifacts.corp/tuf%20mirror/targets/a%20b%23c.pem"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mirror, func(t *testing.T) {
+			u, err := url.Parse(tt.mirror)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mirrorFileURL(mirrorBase(u), tt.name); got != tt.want {
+				t.Errorf("mirrorFileURL(%q) = %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeMirrorURL(t *testing.T) {
+	tests := []struct {
+		mirror string
+		want   string
+	}{
+		{"https://tuf-repo-cdn.sigstore.dev", "https://tuf-repo-cdn.sigstore.dev"},
+		{"HTTPS://Tuf-Repo-CDN.sigstore.dev/", "https://tuf-repo-cdn.sigstore.dev"},
+		{"https://artifacts.corp/tuf/sigstore///", "https://artifacts.corp/tuf/sigstore"},
+		{"./repo/", "./repo/"},
+		{"s3://bucket/prefix/", "s3://bucket/prefix/"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeMirrorURL(tt.mirror); got != tt.want {
+			t.Errorf("NormalizeMirrorURL(%q) = %s, want %s", tt.mirror, got, tt.want)
+		}
+	}
+}
+
+// TestPathPrefixMirror assembles a mirror served under a path prefix, behind
+// a redirect and requiring a query token, as artifact managers serve them.
+func TestPathPrefixMirror(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/artifacts/tuf/sigstore/", http.StripPrefix("/artifacts/tuf/sigstore", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != "abc" {
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+		mockmirror.Handler().ServeHTTP(w, r)
+	})))
+	mux.HandleFunc("/old/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/artifacts/tuf/sigstore/"+strings.TrimPrefix(r.URL.Path, "/old/")+"?"+r.URL.RawQuery, http.StatusMovedPermanently)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, mirror := range []string{server.URL + "/artifacts/tuf/sigstore?token=abc", server.URL + "/old/?token=abc"} {
+		t.Run(mirror, func(t *testing.T) {
+			rootJSONFile, err := AssembleRepository(mirror, t.TempDir())
+			if err != nil {
+				t.Fatalf("AssembleRepository() error = %v", err)
+			}
+			rootJSONFile.Close()
+		})
+	}
+}
+
+// newTestRegistry serves the files of dir as an OCI artifact
+// tuf/repository:latest, requiring an anonymous bearer token.
+func newTestRegistry(t *testing.T, dir string) *httptest.Server {
+	t.Helper()
+	type layer struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int               `json:"size"`
+		Annotations map[string]string `json:"annotations"`
+	}
+	blobs := map[string][]byte{}
+	var layers []layer
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+		blobs[digest] = content
+		layers = append(layers, layer{"application/octet-stream", digest, len(content), map[string]string{ociTitleAnnotation: filepath.ToSlash(rel)}})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read repository: %v", err)
+	}
+	manifest, _ := json.Marshal(map[string]any{"schemaVersion": 2, "layers": layers})
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:tuf/repository:pull" {
+				t.Errorf("unexpected token scope %q", r.URL.Query().Get("scope"))
+			}
+			fmt.Fprint(w, `{"token": "anonymous"}`)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer anonymous" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:tuf/repository:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v2/tuf/repository/manifests/latest":
+			w.Write(manifest)
+		case strings.HasPrefix(r.URL.Path, "/v2/tuf/repository/blobs/"):
+			blob, ok := blobs[strings.TrimPrefix(r.URL.Path, "/v2/tuf/repository/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(blob)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchers(t *testing.T) {
+	dir := newTestRepository(t)
+	mirror := httptest.NewServer(RepositoryHandler(dir))
+	defer mirror.Close()
+	bucket := httptest.NewServer(http.StripPrefix("/bucket/prefix", RepositoryHandler(dir)))
+	defer bucket.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", bucket.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	registry := newTestRegistry(t, dir)
+	oci, err := newOCIFetcher("http", strings.TrimPrefix(registry.URL, "http://")+"/tuf/repository:latest")
+	if err != nil {
+		t.Fatalf("newOCIFetcher() error = %v", err)
+	}
+	s3, err := NewFetcher("s3://bucket/prefix")
+	if err != nil {
+		t.Fatalf("NewFetcher() error = %v", err)
+	}
+
+	mirrorURL, _ := url.Parse(mirror.URL)
+
+	tests := []struct {
+		name    string
+		fetcher Fetcher
+	}{
+		{"http", httpFetcher{base: mirrorBase(mirrorURL), client: http.DefaultClient}},
+		{"directory", dirFetcher{dir: dir}},
+		{"s3", s3},
+		{"oci", oci},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootJSON, err := latestRoot(tt.fetcher)
+			if err != nil {
+				t.Fatalf("latestRoot() error = %v", err)
+			}
+			repository, err := openVerifiedRemote(tt.name, remoteStore{tt.fetcher}, rootJSON)
+			if err != nil {
+				t.Fatalf("openVerifiedRemote() error = %v", err)
+			}
+			workDir := t.TempDir()
+			for name := range repository.Targets {
+				if err := repository.DownloadTarget(name, workDir); err != nil {
+					t.Errorf("DownloadTarget(%s) error = %v", name, err)
+				}
+			}
+			if _, _, err := tt.fetcher.GetMetadata("2.root.json"); !errors.As(err, &client.ErrNotFound{}) {
+				t.Errorf("GetMetadata() of a missing file error = %v, want client.ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func FuzzParseOCIManifest(f *testing.F) {
+	f.Add([]byte(`{"layers":[{"digest":"sha256:abcd","annotations":{"org.opencontainers.image.title":"targets/rekor.pub"}}]}`))
+	f.Add([]byte(`{"layers":[{"digest":"sha256:abcd"}]}`))
+	f.Add([]byte(`{"layers":null}`))
+	f.Fuzz(func(t *testing.T, manifest []byte) {
+		layers, err := ParseOCIManifest(manifest)
+		if err == nil && layers == nil {
+			t.Errorf("ParseOCIManifest() = nil, nil")
+		}
+	})
+}
+
+func FuzzParseBearerChallenge(f *testing.F) {
+	f.Add(`Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:tuf:pull"`)
+	f.Add(`Basic realm="registry"`)
+	f.Add(`Bearer ,,=`)
+	f.Fuzz(func(t *testing.T, challenge string) {
+		values, err := ParseBearerChallenge(challenge)
+		if err == nil && !strings.HasPrefix(challenge, "Bearer ") {
+			t.Errorf("ParseBearerChallenge(%q) = %v, want an error", challenge, values)
+		}
+	})
+}
+
+// rootsFetcher is a Fetcher serving root.json versions 1 to latest.
+type rootsFetcher struct {
+	latest   int
+	requests *int
+}
+
+func (r rootsFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	*r.requests++
+	var version int
+	if _, err := fmt.Sscanf(name, "%d.root.json", &version); err != nil || version < 1 || version > r.latest {
+		return nil, 0, client.ErrNotFound{File: name}
+	}
+	root := fmt.Sprintf(`{"signed":{"version":%d}}`, version)
+	return io.NopCloser(strings.NewReader(root)), int64(len(root)), nil
+}
+
+func (r rootsFetcher) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return nil, 0, client.ErrNotFound{File: path}
+}
+
+func TestLatestRootMaxRootRotations(t *testing.T) {
+	requests := 0
+	_, err := latestRoot(rootsFetcher{latest: math.MaxInt, requests: &requests})
+	if !errors.Is(err, assembler.ErrVerification) {
+		t.Fatalf("latestRoot() error = %v, want %v", err, assembler.ErrVerification)
+	}
+	if requests > 12 {
+		t.Errorf("latestRoot() made %d requests, want at most 12", requests)
+	}
+}
+
+func TestLatestRoot(t *testing.T) {
+	tests := []struct {
+		name         string
+		latest       int
+		maxRequests  int
+		wantNotFound bool
+	}{
+		{"first", 1, 2, false},
+		{"power of two", 16, 10, false},
+		{"after a power of two", 17, 12, false},
+		{"sigstore", 13, 10, false},
+		{"large", 200, 22, false},
+		{"empty", 0, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			root, err := latestRoot(rootsFetcher{latest: tt.latest, requests: &requests})
+			if tt.wantNotFound {
+				if _, ok := err.(client.ErrNotFound); !ok {
+					t.Fatalf("latestRoot() error = %v, want client.ErrNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("latestRoot() error = %v", err)
+			}
+			if version, _ := assembler.MetadataVersion(root); version != int64(tt.latest) {
+				t.Errorf("latestRoot() = version %d, want %d", version, tt.latest)
+			}
+			if requests > tt.maxRequests {
+				t.Errorf("latestRoot() made %d requests, want at most %d", requests, tt.maxRequests)
+			}
+		})
+	}
+}
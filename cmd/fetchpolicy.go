@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// File classes of a FetchPolicy.
+const (
+	fileClassMetadata      = "metadata"
+	fileClassTarget        = "target"
+	fileClassExpiredTarget = "expired-target"
+)
+
+// maxPolicyFileSize bounds the files read in memory by fetches with retries
+// or a timeout, far beyond any metadata or target of a Sigstore repository.
+const maxPolicyFileSize = 32 << 20
+
+// FetchPolicy is the retry budget and failure policy of the files of a class:
+// the metadata, the targets, or the targets Sigstore marked as expired.
+type FetchPolicy struct {
+	// Retries is how many times a failed fetch is retried, with exponential
+	// backoff. Missing files and client errors are not retried.
+	Retries int
+	// Timeout bounds each attempt, 0 for --http-timeout only.
+	Timeout time.Duration
+	// Skip leaves the file out of the repository once its retries are
+	// exhausted instead of failing the run, only for expired targets.
+	Skip bool
+}
+
+// fetchPolicies are the FetchPolicy of each file class, set by --fetch-policy.
+// Files are fetched once, without a timeout of their own, by default.
+var fetchPolicies = map[string]FetchPolicy{}
+
+// retryBackoff is the delay before the first retry, doubled for each next one.
+var retryBackoff = 500 * time.Millisecond
+
+// ParseFetchPolicy parses a --fetch-policy value,
+// CLASS:retries=N,timeout=DURATION,skip with any of the options, e.g.
+// expired-target:retries=1,timeout=10s,skip.
+//
+// Parameters:
+//   - value: The flag value.
+//
+// Returns:
+//   - The class: metadata, target or expired-target.
+//   - The FetchPolicy of the class.
+//   - An error if the value is malformed, or sets skip on another class than expired-target.
+func ParseFetchPolicy(value string) (string, FetchPolicy, error) {
+	class, options, _ := strings.Cut(value, ":")
+	switch class {
+	case fileClassMetadata, fileClassTarget, fileClassExpiredTarget:
+	default:
+		return "", FetchPolicy{}, fmt.Errorf("unknown file class %q, expected %s, %s or %s", class, fileClassMetadata, fileClassTarget, fileClassExpiredTarget)
+	}
+	var policy FetchPolicy
+	for _, option := range strings.Split(options, ",") {
+		name, optionValue, _ := strings.Cut(option, "=")
+		var err error
+		switch name {
+		case "":
+		case "retries":
+			policy.Retries, err = strconv.Atoi(optionValue)
+			if err == nil && policy.Retries < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "timeout":
+			policy.Timeout, err = time.ParseDuration(optionValue)
+			if err == nil && policy.Timeout < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "skip":
+			if class != fileClassExpiredTarget {
+				return "", FetchPolicy{}, fmt.Errorf("%s files cannot be skipped, only %s files", class, fileClassExpiredTarget)
+			}
+			policy.Skip = true
+		default:
+			return "", FetchPolicy{}, fmt.Errorf("unknown option %q of %s, expected retries, timeout or skip", name, class)
+		}
+		if err != nil {
+			return "", FetchPolicy{}, fmt.Errorf("invalid %s of %s: %v", name, class, err)
+		}
+	}
+	return class, policy, nil
+}
+
+// policyFetcher is a Fetcher applying fetchPolicies to the files of next. It
+// classifies targets as expired once expired is set.
+type policyFetcher struct {
+	next Fetcher
+	// expired are the names of the targets Sigstore marked as expired.
+	expired map[string]bool
+}
+
+func (p *policyFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return p.fetch(fetchPolicies[fileClassMetadata], name, func() (io.ReadCloser, int64, error) {
+		return p.next.GetMetadata(name)
+	})
+}
+
+func (p *policyFetcher) GetTarget(target string) (io.ReadCloser, int64, error) {
+	return p.fetch(fetchPolicies[p.targetClass(target)], target, func() (io.ReadCloser, int64, error) {
+		return p.next.GetTarget(target)
+	})
+}
+
+// targetClass returns the file class of target, whose name may be prefixed
+// with its hash in consistent snapshot repositories.
+func (p *policyFetcher) targetClass(target string) string {
+	dir, base := path.Split(target)
+	if hash, name, ok := strings.Cut(base, "."); ok && isHexDigest(hash) {
+		target = dir + name
+	}
+	if p.expired[target] {
+		return fileClassExpiredTarget
+	}
+	return fileClassTarget
+}
+
+// fetch opens name with get, retrying as policy allows. Unless the policy is
+// the default, the file is read in memory within each attempt, so failures
+// while reading the body are retried too.
+func (p *policyFetcher) fetch(policy FetchPolicy, name string, get func() (io.ReadCloser, int64, error)) (io.ReadCloser, int64, error) {
+	if policy.Retries == 0 && policy.Timeout == 0 {
+		return get()
+	}
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		content, err := fetchAttempt(get, policy.Timeout)
+		if err == nil {
+			return io.NopCloser(bytes.NewReader(content)), int64(len(content)), nil
+		}
+		if attempt == policy.Retries || !retryable(err) {
+			return nil, 0, err
+		}
+		log.Printf("warning: could not fetch %s, retrying in %s (%d/%d): %v\n", name, backoff, attempt+1, policy.Retries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// errAttemptTimeout is the error of a fetch attempt exceeding the timeout of
+// its FetchPolicy.
+var errAttemptTimeout = errors.New("fetch attempt timed out")
+
+// fetchAttempt reads the file opened by get, failing after timeout if it is
+// not 0. The body of an attempt timing out is closed to abort its reads.
+func fetchAttempt(get func() (io.ReadCloser, int64, error), timeout time.Duration) ([]byte, error) {
+	type result struct {
+		content []byte
+		err     error
+	}
+	done := make(chan result, 1)
+	bodies := make(chan io.Closer, 1)
+	go func() {
+		body, _, err := get()
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		bodies <- body
+		defer body.Close()
+		content, err := io.ReadAll(io.LimitReader(body, maxPolicyFileSize+1))
+		if err == nil && len(content) > maxPolicyFileSize {
+			err = fmt.Errorf("file is larger than %d bytes", maxPolicyFileSize)
+		}
+		done <- result{content: content, err: err}
+	}()
+	if timeout == 0 {
+		r := <-done
+		return r.content, r.err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.content, r.err
+	case <-timer.C:
+		go func() {
+			select {
+			case body := <-bodies:
+				body.Close()
+			case <-done:
+			}
+		}()
+		return nil, fmt.Errorf("%w after %s", errAttemptTimeout, timeout)
+	}
+}
+
+// retryable reports whether a failed fetch may succeed when retried: not
+// for missing files and for HTTP client errors other than 408 and 429.
+func retryable(err error) bool {
+	var notFound client.ErrNotFound
+	if errors.As(err, &notFound) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) && status.StatusCode >= 400 && status.StatusCode < 500 {
+		return status.StatusCode == http.StatusRequestTimeout || status.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// expiredTargets returns the names of the targets Sigstore marked as expired
+// in their custom metadata.
+func expiredTargets(targets data.TargetFiles) map[string]bool {
+	expired := map[string]bool{}
+	for name, meta := range targets {
+		if custom := targetSigstoreMetadata(meta); custom != nil && strings.EqualFold(custom.Status, "Expired") {
+			expired[name] = true
+		}
+	}
+	return expired
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+func TestParseFetchPolicy(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantClass  string
+		wantPolicy FetchPolicy
+		wantErr    bool
+	}{
+		{value: "metadata:retries=5,timeout=30s", wantClass: fileClassMetadata, wantPolicy: FetchPolicy{Retries: 5, Timeout: 30 * time.Second}},
+		{value: "target:retries=2", wantClass: fileClassTarget, wantPolicy: FetchPolicy{Retries: 2}},
+		{value: "expired-target:retries=1,timeout=10s,skip", wantClass: fileClassExpiredTarget, wantPolicy: FetchPolicy{Retries: 1, Timeout: 10 * time.Second, Skip: true}},
+		{value: "expired-target:skip", wantClass: fileClassExpiredTarget, wantPolicy: FetchPolicy{Skip: true}},
+		{value: "metadata", wantClass: fileClassMetadata},
+		{value: "metadata:skip", wantErr: true},
+		{value: "target:retries=-1", wantErr: true},
+		{value: "target:timeout=soon", wantErr: true},
+		{value: "target:backoff=1s", wantErr: true},
+		{value: "delegations:retries=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			class, policy, err := ParseFetchPolicy(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFetchPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if class != tt.wantClass || policy != tt.wantPolicy {
+				t.Errorf("ParseFetchPolicy() = %s, %+v, want %s, %+v", class, policy, tt.wantClass, tt.wantPolicy)
+			}
+		})
+	}
+}
+
+// flakyFetcher fails the first failures fetches of every file with err.
+type flakyFetcher struct {
+	failures int
+	err      error
+	delay    time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (f *flakyFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return f.get(name)
+}
+
+func (f *flakyFetcher) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return f.get("targets/" + path)
+}
+
+func (f *flakyFetcher) get(name string) (io.ReadCloser, int64, error) {
+	f.mu.Lock()
+	f.attempts[name]++
+	attempt := f.attempts[name]
+	f.mu.Unlock()
+	if attempt <= f.failures {
+		time.Sleep(f.delay)
+		return nil, 0, f.err
+	}
+	return io.NopCloser(strings.NewReader(name)), int64(len(name)), nil
+}
+
+func TestPolicyFetcher(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = 0
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}
+
+	tests := []struct {
+		name         string
+		policies     map[string]FetchPolicy
+		fetcher      *flakyFetcher
+		target       string
+		wantAttempts int
+		wantErr      bool
+	}{
+		{name: "default policy fetches once", fetcher: &flakyFetcher{failures: 1, err: unavailable}, target: "rekor.pub", wantAttempts: 1, wantErr: true},
+		{name: "retried until it succeeds", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 2}}, fetcher: &flakyFetcher{failures: 2, err: unavailable}, target: "rekor.pub", wantAttempts: 3},
+		{name: "retries exhausted", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 1}}, fetcher: &flakyFetcher{failures: 2, err: unavailable}, target: "rekor.pub", wantAttempts: 2, wantErr: true},
+		{name: "missing files are not retried", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 3}}, fetcher: &flakyFetcher{failures: 1, err: client.ErrNotFound{File: "rekor.pub"}}, target: "rekor.pub", wantAttempts: 1, wantErr: true},
+		{name: "client errors are not retried", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 3}}, fetcher: &flakyFetcher{failures: 1, err: &StatusError{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"}}, target: "rekor.pub", wantAttempts: 1, wantErr: true},
+		{name: "expired targets have their own policy", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 3}, fileClassExpiredTarget: {Retries: 1}}, fetcher: &flakyFetcher{failures: 3, err: unavailable}, target: "ctfe.pub", wantAttempts: 2, wantErr: true},
+		{name: "hashed names of expired targets", policies: map[string]FetchPolicy{fileClassExpiredTarget: {Retries: 1}}, fetcher: &flakyFetcher{failures: 1, err: unavailable}, target: strings.Repeat("a", 64) + ".ctfe.pub", wantAttempts: 2},
+		{name: "timed out attempts are retried", policies: map[string]FetchPolicy{fileClassTarget: {Retries: 1, Timeout: 10 * time.Millisecond}}, fetcher: &flakyFetcher{failures: 1, err: unavailable, delay: time.Second}, target: "rekor.pub", wantAttempts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(policies map[string]FetchPolicy) { fetchPolicies = policies }(fetchPolicies)
+			fetchPolicies = tt.policies
+			tt.fetcher.attempts = map[string]int{}
+			fetcher := &policyFetcher{next: tt.fetcher, expired: map[string]bool{"ctfe.pub": true}}
+
+			content, err := fetchTargetFile(fetcher, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			tt.fetcher.mu.Lock()
+			defer tt.fetcher.mu.Unlock()
+			if got := tt.fetcher.attempts["targets/"+tt.target]; got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if err == nil && string(content) != "targets/"+tt.target {
+				t.Errorf("content = %q", content)
+			}
+		})
+	}
+}
+
+func TestExpiredTargets(t *testing.T) {
+	custom := func(status string) *json.RawMessage {
+		raw := json.RawMessage(`{"sigstore":{"usage":"CTFE","status":"` + status + `"}}`)
+		return &raw
+	}
+	targets := data.TargetFiles{
+		"ctfe.pub":      {Custom: custom("Expired")},
+		"ctfe_2022.pub": {Custom: custom("Active")},
+		"rekor.pub":     {},
+	}
+	expired := expiredTargets(targets)
+	if len(expired) != 1 || !expired["ctfe.pub"] {
+		t.Errorf("expiredTargets() = %v, want ctfe.pub", expired)
+	}
+}
+
+func TestAssembleSkipsExpiredTargets(t *testing.T) {
+	defer func(policies map[string]FetchPolicy) { fetchPolicies = policies }(fetchPolicies)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "ctfe.pub") {
+			http.Error(w, "flaky", http.StatusServiceUnavailable)
+			return
+		}
+		mockmirror.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		policies map[string]FetchPolicy
+		wantErr  bool
+	}{
+		{name: "strict by default", wantErr: true},
+		{name: "skipped", policies: map[string]FetchPolicy{fileClassExpiredTarget: {Skip: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetchPolicies = tt.policies
+			repository, err := openVerifiedRepository(server.URL, mockmirror.RootJSON())
+			if err != nil {
+				t.Fatal(err)
+			}
+			repository.expired = map[string]bool{"ctfe.pub": true}
+			workDir := t.TempDir()
+			rootJSONFile, err := repository.assemble(workDir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("assemble() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			rootJSONFile.Close()
+			if _, err := os.Stat(filepath.Join(workDir, "targets", "ctfe.pub")); !os.IsNotExist(err) {
+				t.Errorf("skipped target ctfe.pub exists: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(workDir, "targets", "rekor.pub")); err != nil {
+				t.Errorf("target rekor.pub is missing: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// fulcioTrustBundle is the response of the Fulcio /api/v2/trustBundle
+// endpoint: one chain of PEM certificates, leaf first, per active CA.
+type fulcioTrustBundle struct {
+	Chains []struct {
+		Certificates []string `json:"certificates"`
+	} `json:"chains"`
+}
+
+// FetchFulcioTrustBundle downloads the CA certificate chains of a running
+// Fulcio instance from its /api/v2/trustBundle endpoint.
+//
+// Parameters:
+//   - fulcioURL: The base URL of the Fulcio instance, also used as the URI of its certificate authorities.
+//
+// Returns:
+//   - A CertificateAuthority for every chain of the trust bundle.
+//   - An error if the trust bundle could not be fetched, is empty or holds an invalid chain.
+func FetchFulcioTrustBundle(fulcioURL string) ([]CertificateAuthority, error) {
+	fulcioURL = strings.TrimSuffix(fulcioURL, "/")
+	content, err := fetch(fulcioURL + "/api/v2/trustBundle")
+	if err != nil {
+		return nil, err
+	}
+	var bundle fulcioTrustBundle
+	if err := json.Unmarshal(content, &bundle); err != nil {
+		return nil, fmt.Errorf("could not decode trust bundle: %v", err)
+	}
+	if len(bundle.Chains) == 0 {
+		return nil, fmt.Errorf("trust bundle of %s has no certificate chains", fulcioURL)
+	}
+	cas := make([]CertificateAuthority, 0, len(bundle.Chains))
+	for i, chain := range bundle.Chains {
+		var pemChain strings.Builder
+		for _, certificate := range chain.Certificates {
+			pemChain.WriteString(strings.TrimSuffix(certificate, "\n") + "\n")
+		}
+		ca, err := NewCertificateAuthority(fulcioURL, []byte(pemChain.String()))
+		if err != nil {
+			return nil, fmt.Errorf("chain %d of the trust bundle: %v", i, err)
+		}
+		cas = append(cas, ca)
+	}
+	return cas, nil
+}
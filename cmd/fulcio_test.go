@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchFulcioTrustBundle(t *testing.T) {
+	chain := testCertificateChainPEM(t, "sigstore.dev", "sigstore-intermediate", "sigstore")
+	split := strings.Index(string(chain), "-----END CERTIFICATE-----\n") + len("-----END CERTIFICATE-----\n")
+	intermediate, root := string(chain[:split]), string(chain[split:])
+	other := string(testCertificatePEM(t, "example.com", "fulcio-2"))
+
+	tests := []struct {
+		name    string
+		bundle  interface{}
+		wantCNs []string
+		wantErr bool
+	}{
+		{"one chain", map[string]interface{}{"chains": []map[string][]string{{"certificates": {intermediate, root}}}}, []string{"sigstore"}, false},
+		{"two chains", map[string]interface{}{"chains": []map[string][]string{{"certificates": {intermediate, root}}, {"certificates": {other}}}}, []string{"sigstore", "fulcio-2"}, false},
+		{"no chains", map[string]interface{}{"chains": []interface{}{}}, nil, true},
+		{"invalid certificate", map[string]interface{}{"chains": []map[string][]string{{"certificates": {"not a pem"}}}}, nil, true},
+		{"not json", "not json", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v2/trustBundle" {
+					http.NotFound(w, r)
+					return
+				}
+				if s, ok := tt.bundle.(string); ok {
+					w.Write([]byte(s))
+					return
+				}
+				json.NewEncoder(w).Encode(tt.bundle)
+			}))
+			defer server.Close()
+
+			cas, err := FetchFulcioTrustBundle(server.URL + "/")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchFulcioTrustBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(cas) != len(tt.wantCNs) {
+				t.Fatalf("FetchFulcioTrustBundle() returned %d certificate authorities, want %d", len(cas), len(tt.wantCNs))
+			}
+			for i, ca := range cas {
+				if ca.CommonName != tt.wantCNs[i] || ca.URI != server.URL {
+					t.Errorf("certificate authority %d = %s at %s, want %s at %s", i, ca.CommonName, ca.URI, tt.wantCNs[i], server.URL)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// gcpSecretManager is a minimal Secret Manager API client adding secret
+// versions.
+type gcpSecretManager struct {
+	endpoint string
+	token    string
+}
+
+// gcpSecret is the subset of a Secret Manager Secret used by the tool.
+type gcpSecret struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// newGCPSecretManager returns a gcpSecretManager authenticated like the
+// gs:// publisher.
+func newGCPSecretManager() (*gcpSecretManager, error) {
+	token, err := gcpAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	return &gcpSecretManager{endpoint: "https://secretmanager.googleapis.com", token: token}, nil
+}
+
+// gcpSecretName returns the resource name of the --gcp-secret secret, the
+// secret ID in the GOOGLE_CLOUD_PROJECT project unless it is a resource name.
+func gcpSecretName(secret string) (string, error) {
+	if strings.HasPrefix(secret, "projects/") {
+		if parts := strings.Split(secret, "/"); len(parts) != 4 || parts[2] != "secrets" || parts[1] == "" || parts[3] == "" {
+			return "", fmt.Errorf("invalid secret %q, want projects/PROJECT/secrets/SECRET", secret)
+		}
+		return secret, nil
+	}
+	project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if project == "" {
+		return "", fmt.Errorf("secret %q is not a projects/PROJECT/secrets/SECRET resource name and GOOGLE_CLOUD_PROJECT is not set", secret)
+	}
+	return "projects/" + project + "/secrets/" + secret, nil
+}
+
+// tufVersionLabels returns the Secret Manager labels describing the metadata
+// versions of a repository, e.g. snapshot-version: "42".
+func tufVersionLabels(versions map[string]int64) map[string]string {
+	labels := map[string]string{}
+	for role, version := range versions {
+		labels[strings.TrimSuffix(role, ".json")+"-version"] = strconv.FormatInt(version, 10)
+	}
+	return labels
+}
+
+// AddVersion adds payload as the latest version of the secret name, creating
+// the secret with automatic replication if it does not exist, and sets labels
+// on the secret, keeping its other labels.
+//
+// Parameters:
+//   - name: The resource name of the secret, projects/PROJECT/secrets/SECRET.
+//   - payload: The data of the new version.
+//   - labels: The labels describing the new version.
+//
+// Returns:
+//   - The resource name of the new version.
+//   - An error if the secret could not be read, created, labeled or versioned.
+func (g *gcpSecretManager) AddVersion(name string, payload []byte, labels map[string]string) (string, error) {
+	secret := gcpSecret{}
+	err := g.do(http.MethodGet, "/v1/"+name, nil, &secret)
+	switch {
+	case errors.Is(err, errGCPNotFound):
+		parent, secretID, _ := strings.Cut(name, "/secrets/")
+		create := map[string]any{"replication": map[string]any{"automatic": map[string]any{}}, "labels": labels}
+		if err := g.do(http.MethodPost, "/v1/"+parent+"/secrets?secretId="+secretID, create, &secret); err != nil {
+			return "", fmt.Errorf("could not create secret: %w", err)
+		}
+	case err != nil:
+		return "", err
+	default:
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		for key, value := range labels {
+			secret.Labels[key] = value
+		}
+		if err := g.do(http.MethodPatch, "/v1/"+name+"?updateMask=labels", secret, &secret); err != nil {
+			return "", fmt.Errorf("could not label secret: %w", err)
+		}
+	}
+	checksum := crc32.Checksum(payload, crc32.MakeTable(crc32.Castagnoli))
+	version := struct {
+		Name string `json:"name"`
+	}{}
+	request := map[string]any{"payload": map[string]string{
+		"data":       base64.StdEncoding.EncodeToString(payload),
+		"dataCrc32c": strconv.FormatUint(uint64(checksum), 10),
+	}}
+	if err := g.do(http.MethodPost, "/v1/"+name+":addVersion", request, &version); err != nil {
+		return "", fmt.Errorf("could not add secret version: %w", err)
+	}
+	return version.Name, nil
+}
+
+// errGCPNotFound is returned by gcpSecretManager when the requested resource
+// does not exist.
+var errGCPNotFound = errors.New("not found")
+
+// do sends body, if not nil, as JSON to the API path, decoding the answer
+// into response.
+func (g *gcpSecretManager) do(method, path string, body, response any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, g.endpoint+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errGCPNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var answer struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(content, &answer) == nil && answer.Error.Message != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, answer.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// storeGCPSecret adds the trust material of the repository assembled in
+// workDir as a version of the --gcp-secret secret, labeled with its metadata
+// versions, exiting on errors.
+func storeGCPSecret(secret, manifest, workDir string, rootJSONFile *os.File) {
+	if secret == "" {
+		return
+	}
+	name, err := gcpSecretName(secret)
+	if err != nil {
+		log.Fatalf("Error: --gcp-secret: %v", err)
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	data, err := trustMaterialData(manifest, workDir, rootJSON)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	versions, err := metadataVersions(workDir)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	client, err := newGCPSecretManager()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	version, err := client.AddVersion(name, payload, tufVersionLabels(versions))
+	if err != nil {
+		fatalf(err, "Error: could not write Secret Manager secret %s: %v", name, err)
+	}
+	log.Printf("wrote trust material to Secret Manager version %s\n", version)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestGCPSecretName(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "fleet")
+	tests := []struct {
+		secret  string
+		want    string
+		wantErr bool
+	}{
+		{"trustroot", "projects/fleet/secrets/trustroot", false},
+		{"projects/other/secrets/trustroot", "projects/other/secrets/trustroot", false},
+		{"projects/other/trustroot", "", true},
+		{"projects/other/secrets/trustroot/versions/1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			got, err := gcpSecretName(tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("gcpSecretName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("gcpSecretName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTUFVersionLabels(t *testing.T) {
+	got := tufVersionLabels(map[string]int64{"root.json": 12, "snapshot.json": 340})
+	want := map[string]string{"root-version": "12", "snapshot-version": "340"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tufVersionLabels() = %v, want %v", got, want)
+	}
+}
+
+func TestGCPSecretManagerAddVersion(t *testing.T) {
+	const name = "projects/fleet/secrets/trustroot"
+	tests := []struct {
+		name       string
+		existing   string
+		wantCalls  []string
+		wantLabels map[string]string
+	}{
+		{"created", "", []string{
+			"GET /v1/" + name,
+			"POST /v1/projects/fleet/secrets?secretId=trustroot",
+			"POST /v1/" + name + ":addVersion",
+		}, map[string]string{"root-version": "12"}},
+		{"labels merged", `{"name":"` + name + `","labels":{"team":"platform","root-version":"11"}}`, []string{
+			"GET /v1/" + name,
+			"PATCH /v1/" + name + "?updateMask=labels",
+			"POST /v1/" + name + ":addVersion",
+		}, map[string]string{"team": "platform", "root-version": "12"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			var labels map[string]string
+			var payload struct {
+				Data       string `json:"data"`
+				DataCrc32c string `json:"dataCrc32c"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer gcp-token" {
+					t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+				}
+				calls = append(calls, r.Method+" "+r.URL.RequestURI())
+				switch {
+				case r.Method == http.MethodGet && tt.existing == "":
+					w.WriteHeader(http.StatusNotFound)
+					io.WriteString(w, `{"error":{"code":404,"message":"Secret not found"}}`)
+				case r.Method == http.MethodGet:
+					io.WriteString(w, tt.existing)
+				case r.URL.Path == "/v1/"+name+":addVersion":
+					var request struct {
+						Payload *struct {
+							Data       string `json:"data"`
+							DataCrc32c string `json:"dataCrc32c"`
+						} `json:"payload"`
+					}
+					json.NewDecoder(r.Body).Decode(&request)
+					payload = *request.Payload
+					io.WriteString(w, `{"name":"`+name+`/versions/3"}`)
+				default:
+					var secret gcpSecret
+					json.NewDecoder(r.Body).Decode(&secret)
+					labels = secret.Labels
+					io.WriteString(w, `{}`)
+				}
+			}))
+			defer server.Close()
+			client := &gcpSecretManager{endpoint: server.URL, token: "gcp-token"}
+
+			version, err := client.AddVersion(name, []byte(`{"manifest":""}`), map[string]string{"root-version": "12"})
+			if err != nil {
+				t.Fatalf("AddVersion() error = %v", err)
+			}
+			if version != name+"/versions/3" {
+				t.Errorf("AddVersion() = %q", version)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", labels, tt.wantLabels)
+			}
+			data, _ := base64.StdEncoding.DecodeString(payload.Data)
+			checksum := strconv.FormatUint(uint64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))), 10)
+			if string(data) != `{"manifest":""}` || payload.DataCrc32c != checksum {
+				t.Errorf("payload = %+v", payload)
+			}
+		})
+	}
+}
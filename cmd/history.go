@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// historyManifest and historyRecord are the files of a generation in the
+// history directory.
+const (
+	historyManifest = "trustroot.yaml"
+	historyRecord   = "generation.json"
+)
+
+// History keeps the last generated TrustRoots in a state directory, one
+// sub-directory per generation, so a previous one can be rolled back to.
+type History struct {
+	Dir string
+	// Keep is the number of generations kept, older ones are pruned.
+	Keep int
+}
+
+// HistoryEntry describes a generated TrustRoot.
+type HistoryEntry struct {
+	Generation int       `json:"generation"`
+	Name       string    `json:"name"`
+	Mirror     string    `json:"mirror"`
+	Created    time.Time `json:"created"`
+	// Versions are the versions of the metadata of the repository, by role.
+	Versions map[string]int64 `json:"versions"`
+}
+
+// Record stores a generated TrustRoot as the next generation and prunes the
+// generations beyond Keep.
+//
+// Parameters:
+//   - name: The name of the TrustRoot.
+//   - mirror: The mirror the TrustRoot was generated from.
+//   - versionsDir: The directory of the metadata of the repository, to record their versions.
+//   - manifest: The TrustRoot YAML.
+//
+// Returns:
+//   - The recorded entry.
+//   - An error if the history directory could not be written.
+func (h History) Record(name, mirror, versionsDir, manifest string) (*HistoryEntry, error) {
+	versions, err := repositoryVersions(versionsDir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := h.List()
+	if err != nil {
+		return nil, err
+	}
+	entry := &HistoryEntry{Generation: 1, Name: name, Mirror: mirror, Created: now().UTC(), Versions: versions}
+	if len(entries) > 0 {
+		entry.Generation = entries[len(entries)-1].Generation + 1
+	}
+	dir := h.generationDir(entry.Generation)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	record, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, historyManifest), []byte(manifest), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, historyRecord), record, 0o644); err != nil {
+		return nil, err
+	}
+
+	entries = append(entries, *entry)
+	for len(entries) > max(h.Keep, 1) {
+		if err := os.RemoveAll(h.generationDir(entries[0].Generation)); err != nil {
+			return nil, err
+		}
+		entries = entries[1:]
+	}
+	return entry, nil
+}
+
+// List returns the recorded generations, oldest first.
+//
+// Returns:
+//   - The entries of the history, empty if the directory does not exist.
+//   - An error if a generation could not be read.
+func (h History) List() ([]HistoryEntry, error) {
+	dirs, err := os.ReadDir(h.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil || !dir.IsDir() {
+			continue
+		}
+		record, err := os.ReadFile(filepath.Join(h.Dir, dir.Name(), historyRecord))
+		if err != nil {
+			return nil, err
+		}
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(record, &entry); err != nil {
+			return nil, fmt.Errorf("could not parse generation %s: %v", dir.Name(), err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Generation < entries[j].Generation })
+	return entries, nil
+}
+
+// Manifest returns the TrustRoot YAML of generation.
+func (h History) Manifest(generation int) ([]byte, error) {
+	return os.ReadFile(filepath.Join(h.generationDir(generation), historyManifest))
+}
+
+func (h History) generationDir(generation int) string {
+	return filepath.Join(h.Dir, fmt.Sprintf("%06d", generation))
+}
+
+// repositoryVersions returns the versions of the TUF metadata in dir, by role
+// file name without the version prefix (root.json, ..., timestamp.json).
+func repositoryVersions(dir string) (map[string]int64, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]int64{}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == "map.json" {
+			continue
+		}
+		if prefix, role, ok := strings.Cut(name, "."); ok {
+			if _, err := strconv.Atoi(prefix); err == nil {
+				name = role
+			}
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		version, err := assembler.MetadataVersion(content)
+		if err != nil {
+			return nil, fmt.Errorf("could not read version of %s: %v", file, err)
+		}
+		if version > versions[name] {
+			versions[name] = version
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRecord(t *testing.T) {
+	versionsDir := t.TempDir()
+	for name, content := range map[string]string{
+		"10.root.json":      `{"signed": {"version": 10}}`,
+		"156.snapshot.json": `{"signed": {"version": 156}}`,
+		"timestamp.json":    `{"signed": {"version": 251}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(versionsDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name            string
+		keep            int
+		records         int
+		wantGenerations []int
+	}{
+		{"single", 3, 1, []int{1}},
+		{"within keep", 3, 3, []int{1, 2, 3}},
+		{"pruned", 2, 4, []int{3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := History{Dir: filepath.Join(t.TempDir(), "history"), Keep: tt.keep}
+			for i := 1; i <= tt.records; i++ {
+				if _, err := history.Record("sigstore", "https://tuf.example.com", versionsDir, fmt.Sprintf("manifest %d", i)); err != nil {
+					t.Fatalf("Record() error = %v", err)
+				}
+			}
+			entries, err := history.List()
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			var generations []int
+			for _, entry := range entries {
+				generations = append(generations, entry.Generation)
+			}
+			if !reflect.DeepEqual(generations, tt.wantGenerations) {
+				t.Errorf("generations = %v, want %v", generations, tt.wantGenerations)
+			}
+			last := entries[len(entries)-1]
+			if want := map[string]int64{"root.json": 10, "snapshot.json": 156, "timestamp.json": 251}; !reflect.DeepEqual(last.Versions, want) {
+				t.Errorf("versions = %v, want %v", last.Versions, want)
+			}
+			manifest, err := history.Manifest(last.Generation)
+			if err != nil {
+				t.Fatalf("Manifest() error = %v", err)
+			}
+			if want := fmt.Sprintf("manifest %d", tt.records); string(manifest) != want {
+				t.Errorf("manifest = %q, want %q", manifest, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+// defaultHTTPTimeout bounds every HTTP request unless --http-timeout is set,
+// so a wedged mirror connection cannot hang a run forever.
+const defaultHTTPTimeout = time.Minute
+
+// httpTimeoutUsage is the usage of the --http-timeout flag of every command.
+const httpTimeoutUsage = "Timeout of every HTTP request to mirrors, registries, buckets and webhooks, 0 for none"
+
+// debugUsage is the usage of the --debug flag of every command.
+const debugUsage = "Log every HTTP request, with the headers and the beginning of the body of failed ones"
+
+// logHTTPUsage is the usage of the --log-http flag of every command.
+const logHTTPUsage = "Log every HTTP request with its method, URL, status, duration and response bytes: requests, or headers to also dump the request and response headers"
+
+// --log-http values.
+const (
+	logHTTPRequests = "requests"
+	logHTTPHeaders  = "headers"
+)
+
+// debugBodySize is how much of the body of a failed response --debug logs.
+const debugBodySize = 1024
+
+// maxIdleConnsPerHost is how many idle connections sharedTransport keeps to
+// each host, so the concurrent target downloads of a run reuse their
+// connections instead of handshaking again for each target.
+const maxIdleConnsPerHost = 32
+
+// sharedTransport is the connection pool of every client of newHTTPClient,
+// kept alive across the clients of subcommands and watch cycles, and
+// negotiating HTTP/2 with the hosts supporting it.
+var sharedTransport = newSharedTransport()
+
+// newSharedTransport returns http.DefaultTransport, with proxies from the
+// environment, tuned for many requests to the same few hosts.
+func newSharedTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// httpClient performs the HTTP requests to mirrors, registries, buckets and
+// webhooks. The Kubernetes API and cloud metadata servers have their own.
+var httpClient = newHTTPClient(defaultHTTPTimeout, false, "")
+
+// SetHTTPClient replaces the HTTP client of the package, e.g. to set
+// timeouts, proxies or instrumentation, or to serve tests from a fake
+// http.RoundTripper.
+//
+// Parameters:
+//   - client: The HTTP client performing every request.
+func SetHTTPClient(client *http.Client) {
+	httpClient = client
+}
+
+// newHTTPClient returns the HTTP client of the --http-timeout, --debug and
+// --log-http flags, accepting compressed responses over sharedTransport.
+// logHTTP is "", logHTTPRequests or logHTTPHeaders.
+func newHTTPClient(timeout time.Duration, debug bool, logHTTP string) *http.Client {
+	var next http.RoundTripper = sharedTransport
+	if logHTTP != "" {
+		next = httpLogTransport{next: next, headers: logHTTP == logHTTPHeaders}
+	}
+	if debug {
+		next = debugTransport{next: next}
+	}
+	return &http.Client{Timeout: timeout, Transport: compressionTransport{next: next}}
+}
+
+// validateLogHTTP returns an error if value is not a --log-http value.
+func validateLogHTTP(value string) error {
+	switch value {
+	case "", logHTTPRequests, logHTTPHeaders:
+		return nil
+	}
+	return fmt.Errorf("--log-http must be %s or %s", logHTTPRequests, logHTTPHeaders)
+}
+
+// parseSubcommandFlags parses the flags of a subcommand created with
+// newSubcommandFlagSet and applies its --http-timeout, --debug, --log-http,
+// --work-dir and --no-color.
+func parseSubcommandFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	workDirBase = fs.Lookup("work-dir").Value.String()
+	setupLogOutput(fs.Lookup("no-color").Value.(flag.Getter).Get().(bool))
+	timeout := fs.Lookup("http-timeout").Value.(flag.Getter).Get().(time.Duration)
+	debug := fs.Lookup("debug").Value.(flag.Getter).Get().(bool)
+	logHTTP := fs.Lookup("log-http").Value.String()
+	if err := validateLogHTTP(logHTTP); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	SetHTTPClient(newHTTPClient(timeout, debug, logHTTP))
+}
+
+// StatusError is the error of an HTTP request answered with an unexpected
+// status.
+type StatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay of the Retry-After header of the response, 0
+	// without one.
+	RetryAfter time.Duration
+}
+
+// newStatusError returns the StatusError of resp.
+func newStatusError(resp *http.Response) *StatusError {
+	err := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now())}
+	if resp.Request != nil {
+		err.Method, err.URL = resp.Request.Method, resp.Request.URL.Redacted()
+	}
+	return err
+}
+
+func (e *StatusError) Error() string {
+	if e.URL == "" {
+		return e.Status
+	}
+	return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+}
+
+// parseRetryAfter returns the delay of a Retry-After header value at now,
+// given in seconds or as an HTTP date, 0 if it is empty, invalid or past.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return 0
+}
+
+// retryAfter returns the RetryAfter of the StatusError err wraps, also when
+// go-tuf wrapped it in a client.ErrDownloadFailed, 0 if there is none.
+func retryAfter(err error) time.Duration {
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.RetryAfter
+	}
+	var downloadFailed client.ErrDownloadFailed
+	if errors.As(err, &downloadFailed) && downloadFailed.Err != nil {
+		return retryAfter(downloadFailed.Err)
+	}
+	return 0
+}
+
+// debugTransport is an http.RoundTripper logging the requests of next, and
+// the details of the failed ones.
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+func (d debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := d.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		log.Printf("debug: %s %s failed after %s: %v\n%s", req.Method, req.URL.Redacted(), elapsed, err, formatHeaders("> ", req.Header))
+		return nil, err
+	}
+	log.Printf("debug: %s %s: %s in %s\n", req.Method, req.URL.Redacted(), resp.Status, elapsed)
+	if resp.StatusCode >= 400 {
+		body := make([]byte, debugBodySize)
+		n, _ := io.ReadFull(resp.Body, body)
+		body = body[:n]
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		log.Printf("%s%s%s\n", formatHeaders("> ", req.Header), formatHeaders("< ", resp.Header), body)
+	}
+	return resp, nil
+}
+
+// httpLogTransport is an http.RoundTripper logging every request of next with
+// its status, duration and response bytes as transferred, and with headers the
+// request and response headers. A response is logged once its body is closed,
+// so the duration and bytes cover the transfer of the body.
+type httpLogTransport struct {
+	next    http.RoundTripper
+	headers bool
+}
+
+func (l httpLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("http: %s %s failed after %s: %v\n%s", req.Method, req.URL.Redacted(), time.Since(start).Round(time.Millisecond), err, l.formatHeaders("> ", req.Header))
+		return nil, err
+	}
+	resp.Body = &loggedBody{ReadCloser: resp.Body, closed: func(n int64) {
+		log.Printf("http: %s %s: %s, %d bytes in %s\n%s%s", req.Method, req.URL.Redacted(), resp.Status, n, time.Since(start).Round(time.Millisecond), l.formatHeaders("> ", req.Header), l.formatHeaders("< ", resp.Header))
+	}}
+	return resp, nil
+}
+
+// formatHeaders is formatHeaders if headers are logged, "" otherwise.
+func (l httpLogTransport) formatHeaders(prefix string, header http.Header) string {
+	if !l.headers {
+		return ""
+	}
+	return formatHeaders(prefix, header)
+}
+
+// loggedBody is a response body counting the bytes read from it, and calling
+// closed with their count when first closed.
+type loggedBody struct {
+	io.ReadCloser
+	n      int64
+	closed func(n int64)
+	once   sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.closed(b.n) })
+	return err
+}
+
+// readCloser reads from a Reader and closes a Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// formatHeaders formats header one line per value in lexical order, with
+// prefix and credentials redacted.
+func formatHeaders(prefix string, header http.Header) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines strings.Builder
+	for _, name := range names {
+		for _, value := range header[name] {
+			switch http.CanonicalHeaderKey(name) {
+			case "Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie":
+				value = "<redacted>"
+			}
+			fmt.Fprintf(&lines, "%s%s: %s\n", prefix, name, value)
+		}
+	}
+	return lines.String()
+}
+
+// acceptEncoding is the Accept-Encoding of the requests of compressionTransport.
+const acceptEncoding = "gzip, deflate, zstd"
+
+// compressionTransport is an http.RoundTripper asking next for compressed
+// responses, and transparently decoding the gzip, deflate and zstd encoded
+// responses of mirrors and CDNs. Requests setting their own Accept-Encoding
+// or a Range are left alone.
+type compressionTransport struct {
+	next http.RoundTripper
+}
+
+func (c compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return c.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return resp, nil
+	}
+	body, err := decodeBody(encoding, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: could not decode %s response: %v", req.Method, req.URL.Redacted(), encoding, err)
+	}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// decodeBody returns the decoded body of a response of Content-Encoding
+// encoding, closing body when closed.
+func decodeBody(encoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{reader, body}, nil
+	case "deflate":
+		// deflate is zlib wrapped per RFC 9110, but some servers send raw
+		// DEFLATE data: a zlib stream starts with a header whose first 16
+		// bits are a multiple of 31.
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err != nil && len(header) < 2 {
+			return nil, err
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, err
+			}
+			return readCloser{reader, body}, nil
+		}
+		return readCloser{flate.NewReader(buffered), body}, nil
+	case "zstd":
+		decoder, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return readCloser{decoder, closerFunc(func() error {
+			decoder.Close()
+			return body.Close()
+		})}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// closerFunc adapts a function to the io.Closer interface.
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDebugTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/1.root.json" {
+			w.Write([]byte(`{"signed":{}}`))
+			return
+		}
+		w.Header().Set("X-Request-Id", "abc123")
+		http.Error(w, "denied by policy", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	client := newHTTPClient(time.Second, true, "")
+
+	tests := []struct {
+		name     string
+		path     string
+		want     []string
+		dontWant []string
+	}{
+		{"ok", "/1.root.json", []string{"debug: GET " + server.URL + "/1.root.json: 200 OK"}, []string{"Authorization"}},
+		{"forbidden", "/timestamp.json", []string{"403 Forbidden", "> Authorization: <redacted>", "< X-Request-Id: abc123", "denied by policy"}, []string{"secret-token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			req, _ := http.NewRequest(http.MethodGet, server.URL+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer secret-token")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusForbidden && !strings.Contains(string(body), "denied by policy") {
+				t.Errorf("body = %q, want it to be readable after logging", body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(logs.String(), dontWant) {
+					t.Errorf("logs = %q, want them not to contain %q", logs.String(), dontWant)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPLogTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc123")
+		w.Write([]byte(`{"signed":{}}`))
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		logHTTP  string
+		want     []string
+		dontWant []string
+	}{
+		{logHTTP: logHTTPRequests, want: []string{"http: GET " + server.URL + "/1.root.json: 200 OK, 13 bytes in "}, dontWant: []string{"X-Request-Id"}},
+		{logHTTP: logHTTPHeaders, want: []string{"13 bytes", "> Authorization: <redacted>", "< X-Request-Id: abc123"}, dontWant: []string{"secret-token"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.logHTTP, func(t *testing.T) {
+			logs.Reset()
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/1.root.json", nil)
+			req.Header.Set("Authorization", "Bearer secret-token")
+			resp, err := newHTTPClient(time.Second, false, tt.logHTTP).Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			io.ReadAll(resp.Body)
+			resp.Body.Close()
+			for _, want := range tt.want {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("logs = %q, want them to contain %q", logs.String(), want)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(logs.String(), dontWant) {
+					t.Errorf("logs = %q, want them not to contain %q", logs.String(), dontWant)
+				}
+			}
+		})
+	}
+
+	if err := validateLogHTTP("bodies"); err == nil {
+		t.Error("validateLogHTTP(bodies) succeeded")
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	defaultClient := httpClient
+	defer SetHTTPClient(defaultClient)
+	SetHTTPClient(server.Client())
+
+	err := Download(io.Discard, server.URL+"/1.root.json")
+	var status *StatusError
+	if !errors.As(err, &status) {
+		t.Fatalf("Download() error = %v, want a StatusError", err)
+	}
+	if status.StatusCode != http.StatusNotFound || status.URL != server.URL+"/1.root.json" {
+		t.Errorf("StatusError = %+v, want 404 for %s/1.root.json", status, server.URL)
+	}
+}
+
+func TestCompressionTransport(t *testing.T) {
+	content := []byte(strings.Repeat(`{"signed":{"_type":"root"}}`, 64))
+	encode := func(t *testing.T, encoding string) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "zstd":
+			w, _ = zstd.NewWriter(&buf)
+		default:
+			return content
+		}
+		w.Write(content)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		header   string
+		wantErr  bool
+	}{
+		{name: "identity", encoding: "", header: ""},
+		{name: "gzip", encoding: "gzip", header: "gzip"},
+		{name: "deflate", encoding: "deflate", header: "deflate"},
+		{name: "raw deflate", encoding: "raw-deflate", header: "deflate"},
+		{name: "zstd", encoding: "zstd", header: "zstd"},
+		{name: "unsupported", encoding: "", header: "br", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := encode(t, tt.encoding)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != acceptEncoding {
+					t.Errorf("Accept-Encoding = %q, want %q", got, acceptEncoding)
+				}
+				if tt.header != "" {
+					w.Header().Set("Content-Encoding", tt.header)
+				}
+				w.Write(body)
+			}))
+			defer server.Close()
+
+			resp, err := newHTTPClient(time.Minute, false, "").Get(server.URL + "/1.root.json")
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			got, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Errorf("body = %q, want %q", got, content)
+			}
+			if tt.header != "" && (resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != -1) {
+				t.Errorf("Content-Encoding = %q, ContentLength = %d, want decoded response", resp.Header.Get("Content-Encoding"), resp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestCompressionTransportRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Accept-Encoding"); got == acceptEncoding {
+			t.Errorf("Accept-Encoding = %q on a Range request", got)
+		}
+		w.Write([]byte("partial"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Range", "bytes=0-6")
+	resp, err := newHTTPClient(time.Minute, false, "").Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestSharedTransport(t *testing.T) {
+	var mu sync.Mutex
+	remotes := map[string]bool{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		w.Write([]byte("target"))
+	}))
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		resp, err := newHTTPClient(time.Minute, i%2 == 0, "").Get(server.URL + "/targets/rekor.pub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	if len(remotes) != 1 {
+		t.Errorf("requests of new clients used %d connections, want 1", len(remotes))
+	}
+	if !sharedTransport.ForceAttemptHTTP2 || sharedTransport.MaxIdleConnsPerHost != maxIdleConnsPerHost {
+		t.Errorf("sharedTransport is not tuned: %+v", sharedTransport)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Tue, 31 Dec 2024 12:30:00 GMT", 30 * time.Minute},
+		{"Tue, 31 Dec 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
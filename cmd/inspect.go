@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// inspectRoles are the top-level metadata roles listed by WriteInspectReport,
+// in the order clients update them.
+var inspectRoles = []string{"root.json", "timestamp.json", "snapshot.json", "targets.json"}
+
+// TargetReport describes a target of a targets.json with the custom metadata
+// Sigstore attaches to it.
+type TargetReport struct {
+	Name   string
+	Length int64
+	// Usage, Status and URI are empty for targets without Sigstore custom
+	// metadata. Status is "Active" or "Expired" in the Sigstore repository.
+	Usage  string
+	Status string
+	URI    string
+}
+
+// SigstoreTargets lists the targets of a targets.json document with their
+// Sigstore custom metadata (custom.sigstore usage, status and uri).
+//
+// Parameters:
+//   - targetsJSON: The content of the targets.json file.
+//
+// Returns:
+//   - The targets, sorted by name.
+//   - An error if the document could not be decoded.
+func SigstoreTargets(targetsJSON []byte) ([]TargetReport, error) {
+	targets, err := parseTargetsMetadata(targetsJSON)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]TargetReport, 0, len(targets.Signed.Targets))
+	for name, meta := range targets.Signed.Targets {
+		report := TargetReport{Name: name, Length: meta.Length}
+		if custom := meta.sigstore(); custom != nil {
+			report.Usage, report.Status, report.URI = custom.Usage, custom.Status, custom.URI
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Name < reports[j].Name })
+	return reports, nil
+}
+
+// WriteInspectReport writes the version and expiry of the top-level metadata
+// and the targets with their Sigstore usage and status, so operators can tell
+// active trust material from material Sigstore marked as expired.
+//
+// Parameters:
+//   - w: Where the report is written.
+//   - metadata: The verified top-level metadata, by role file name (root.json, ..., timestamp.json).
+//   - now: The current time, to compute the remaining validity.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error naming the metadata that could not be read.
+func WriteInspectReport(w io.Writer, metadata map[string][]byte, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "METADATA\tVERSION\tEXPIRES\tREMAINING")
+	for _, role := range inspectRoles {
+		content, ok := metadata[role]
+		if !ok {
+			return fmt.Errorf("no %s to inspect", role)
+		}
+		var signed struct {
+			Signed struct {
+				Version int64     `json:"version"`
+				Expires time.Time `json:"expires"`
+			} `json:"signed"`
+		}
+		if err := json.Unmarshal(content, &signed); err != nil {
+			return fmt.Errorf("could not read %s: %v", role, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", role, signed.Signed.Version, signed.Signed.Expires.UTC().Format(time.RFC3339), remainingValidity(signed.Signed.Expires.Sub(now)))
+	}
+
+	targets, err := SigstoreTargets(metadata["targets.json"])
+	if err != nil {
+		return fmt.Errorf("could not read targets.json: %v", err)
+	}
+	fmt.Fprintln(tw, "\nTARGET\tLENGTH\tUSAGE\tSTATUS\tURI")
+	for _, target := range targets {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", target.Name, target.Length, orDash(target.Usage), orDash(target.Status), orDash(target.URI))
+	}
+	return tw.Flush()
+}
+
+// remainingValidity formats the validity left to a metadata document: whole
+// days beyond two days, minutes below, and "expired" once it has expired.
+func remainingValidity(remaining time.Duration) string {
+	switch {
+	case remaining <= 0:
+		return "expired"
+	case remaining >= 48*time.Hour:
+		return fmt.Sprintf("%dd", remaining/(24*time.Hour))
+	}
+	return remaining.Round(time.Minute).String()
+}
+
+// orDash returns s, or "-" for an empty report column.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// WriteArchiveListing writes the entries of a repository archive with their
+// size and SHA-256, to confirm what a mirrorFS embeds before applying it.
+//
+// Parameters:
+//   - w: Where the listing is written.
+//   - archive: The gzip compressed tar archive of the repository.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error if the archive could not be read.
+func WriteArchiveListing(w io.Writer, archive io.Reader) error {
+	gr, err := gzip.NewReader(archive)
+	if err != nil {
+		return fmt.Errorf("could not read the archive: %v", err)
+	}
+	defer gr.Close()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTRY\tSIZE\tSHA256")
+	var entries, total int64
+	tr := tar.NewReader(gr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("could not read the archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			fmt.Fprintf(tw, "%s\t-\t-\n", header.Name)
+			continue
+		}
+		digest := sha256.New()
+		size, err := io.Copy(digest, tr)
+		if err != nil {
+			return fmt.Errorf("could not read %s from the archive: %v", header.Name, err)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%x\n", header.Name, size, digest.Sum(nil))
+		entries++
+		total += size
+	}
+	fmt.Fprintf(tw, "TOTAL (%d files)\t%d\t\n", entries, total)
+	return tw.Flush()
+}
+
+// MirrorFSArchive returns the repository archive of content: content itself if
+// it is gzip compressed, otherwise the mirrorFS of the TrustRoot manifest it
+// holds.
+//
+// Parameters:
+//   - content: A repository.tar.gz, or a YAML or JSON TrustRoot manifest with a repository spec.
+//
+// Returns:
+//   - The gzip compressed tar archive of the repository.
+//   - An error if content is neither.
+func MirrorFSArchive(content []byte) ([]byte, error) {
+	if bytes.HasPrefix(content, []byte{0x1f, 0x8b}) {
+		return content, nil
+	}
+	trustRoot, err := ReadTrustRoot(content)
+	if err != nil {
+		return nil, err
+	}
+	if trustRoot.Spec.Repository == nil || len(trustRoot.Spec.Repository.MirrorFS) == 0 {
+		return nil, fmt.Errorf("TrustRoot %s has no repository mirrorFS", trustRoot.Metadata.Name)
+	}
+	return trustRoot.Spec.Repository.MirrorFS, nil
+}
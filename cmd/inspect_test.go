@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigstoreTargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetsJSON string
+		want        []TargetReport
+		wantErr     bool
+	}{
+		{
+			name: "custom metadata",
+			targetsJSON: `{"signed": {"targets": {
+				"rekor.pub": {"length": 178, "custom": {"sigstore": {"usage": "Rekor", "status": "Active", "uri": "https://rekor.sigstore.dev"}}},
+				"ctfe.pub": {"length": 177, "custom": {"sigstore": {"usage": "CTFE", "status": "Expired"}}}
+			}}}`,
+			want: []TargetReport{
+				{Name: "ctfe.pub", Length: 177, Usage: "CTFE", Status: "Expired"},
+				{Name: "rekor.pub", Length: 178, Usage: "Rekor", Status: "Active", URI: "https://rekor.sigstore.dev"},
+			},
+		},
+		{
+			name:        "without custom metadata",
+			targetsJSON: `{"signed": {"targets": {"trusted_root.json": {"length": 42}}}}`,
+			want:        []TargetReport{{Name: "trusted_root.json", Length: 42}},
+		},
+		{
+			name:        "invalid JSON",
+			targetsJSON: `{`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SigstoreTargets([]byte(tt.targetsJSON))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SigstoreTargets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SigstoreTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteInspectReport(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	metadata := map[string][]byte{
+		"root.json":      []byte(`{"signed": {"version": 13, "expires": "2025-07-01T00:00:00Z"}}`),
+		"timestamp.json": []byte(`{"signed": {"version": 900, "expires": "2025-01-01T12:00:00Z"}}`),
+		"snapshot.json":  []byte(`{"signed": {"version": 156, "expires": "2024-12-31T00:00:00Z"}}`),
+		"targets.json": []byte(`{"signed": {"version": 12, "expires": "2025-07-01T00:00:00Z", "targets": {
+			"ctfe.pub": {"length": 177, "custom": {"sigstore": {"usage": "CTFE", "status": "Expired"}}},
+			"trusted_root.json": {"length": 42}
+		}}}`),
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string][]byte
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "report",
+			metadata: metadata,
+			want: []string{
+				"root.json       13       2025-07-01T00:00:00Z  181d",
+				"timestamp.json  900      2025-01-01T12:00:00Z  12h0m0s",
+				"snapshot.json   156      2024-12-31T00:00:00Z  expired",
+				"ctfe.pub           177     CTFE   Expired  -",
+				"trusted_root.json  42      -      -        -",
+			},
+		},
+		{
+			name:     "missing role",
+			metadata: map[string][]byte{"root.json": metadata["root.json"]},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := WriteInspectReport(&out, tt.metadata, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteInspectReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, line := range tt.want {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("WriteInspectReport() = %q, want a line %q", out.String(), line)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteArchiveListing(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workDir, "targets"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "targets", "rekor.pub"), []byte("rekor"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := compressRepository(workDir, &archive); err != nil {
+		t.Fatal(err)
+	}
+
+	var listing strings.Builder
+	if err := WriteArchiveListing(&listing, bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("WriteArchiveListing() error = %v", err)
+	}
+	// sha256("rekor")
+	for _, want := range []string{"targets/rekor.pub", "5", "6c70a238c7396f83f6eb6b5d5347acb96d85eeebb4d9d780d695e2b3dfcdb8a9", "TOTAL (1 files)"} {
+		if !strings.Contains(listing.String(), want) {
+			t.Errorf("listing = %q, want %q", listing.String(), want)
+		}
+	}
+	if err := WriteArchiveListing(io.Discard, strings.NewReader("not an archive")); err == nil {
+		t.Error("WriteArchiveListing() of a malformed archive succeeded")
+	}
+}
+
+func TestMirrorFSArchive(t *testing.T) {
+	spec := testRepositorySpec(t)
+	archive := spec["repository"].(map[string]any)["mirrorFS"].([]byte)
+	manifest := testTrustRootObject(t, spec)
+
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "archive", content: archive},
+		{name: "TrustRoot manifest", content: manifest},
+		{name: "sigstoreKeys TrustRoot", content: testTrustRootObject(t, map[string]any{"sigstoreKeys": map[string]any{}}), wantErr: true},
+		{name: "malformed", content: []byte("spec: ["), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MirrorFSArchive(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MirrorFSArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, archive) {
+				t.Error("MirrorFSArchive() is not the mirrorFS of the TrustRoot")
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// minRSAKeyBits is the smallest RSA key accepted as trust material.
+const minRSAKeyBits = 2048
+
+// NormalizePublicKey validates the public key of a transparency log and
+// returns it as a single PEM "PUBLIC KEY" block, the encoding the
+// policy-controller expects. PEM and DER PKIX keys and PEM PKCS #1 RSA keys
+// are accepted.
+//
+// Parameters:
+//   - input: The PEM or DER encoded public key.
+//
+// Returns:
+//   - The PEM encoded PKIX public key.
+//   - An error naming what is wrong with the key, e.g. a private key, a certificate or a weak algorithm.
+func NormalizePublicKey(input []byte) ([]byte, error) {
+	var pub any
+	block, rest := pem.Decode(input)
+	switch {
+	case block == nil:
+		key, err := x509.ParsePKIXPublicKey(input)
+		if err != nil {
+			return nil, fmt.Errorf("not a PEM or DER encoded public key")
+		}
+		pub = key
+	case len(bytes.TrimSpace(rest)) != 0:
+		return nil, fmt.Errorf("unexpected data after the %s PEM block", block.Type)
+	case block.Type == "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key: %v", err)
+		}
+		pub = key
+	case block.Type == "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA public key: %v", err)
+		}
+		pub = key
+	case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+		return nil, fmt.Errorf("found a %s, expected a public key", block.Type)
+	case block.Type == "CERTIFICATE":
+		return nil, fmt.Errorf("found a certificate, expected a public key")
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q, expected PUBLIC KEY", block.Type)
+	}
+	if err := checkKeyAlgorithm(pub); err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// NormalizeCertificateChain validates the certificate chain of a Fulcio
+// instance or Timestamp Authority and returns it as PEM "CERTIFICATE" blocks,
+// leaf first. Every certificate must be valid at now and issued by the next
+// one; a chain given root first is reversed.
+//
+// Parameters:
+//   - input: The PEM encoded chain, or DER encoded certificates.
+//   - now: The time the certificates must be valid at.
+//
+// Returns:
+//   - The PEM encoded chain, leaf first.
+//   - An error naming the offending certificate.
+func NormalizeCertificateChain(input []byte, now time.Time) ([]byte, error) {
+	certs, err := parseCertificates(input)
+	if err != nil {
+		return nil, err
+	}
+	for i, cert := range certs {
+		switch {
+		case now.Before(cert.NotBefore):
+			return nil, fmt.Errorf("certificate %d (%s) is not valid before %s", i, cert.Subject.CommonName, cert.NotBefore.UTC().Format(time.RFC3339))
+		case now.After(cert.NotAfter):
+			return nil, fmt.Errorf("certificate %d (%s) expired on %s", i, cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+		if err := checkKeyAlgorithm(cert.PublicKey); err != nil {
+			return nil, fmt.Errorf("certificate %d (%s): %v", i, cert.Subject.CommonName, err)
+		}
+	}
+	if !chainLinked(certs) {
+		reversed := make([]*x509.Certificate, len(certs))
+		for i, cert := range certs {
+			reversed[len(certs)-1-i] = cert
+		}
+		if !chainLinked(reversed) {
+			return nil, fmt.Errorf("certificates are not a chain: each must be issued by the next, leaf first")
+		}
+		certs = reversed
+	}
+
+	var chain bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return chain.Bytes(), nil
+}
+
+// parseCertificates parses the PEM "CERTIFICATE" blocks of input, or input
+// as DER certificates when it holds no PEM block.
+func parseCertificates(input []byte) ([]*x509.Certificate, error) {
+	if block, _ := pem.Decode(input); block == nil {
+		certs, err := x509.ParseCertificates(input)
+		if err != nil || len(certs) == 0 {
+			return nil, fmt.Errorf("no PEM or DER encoded certificate found in chain")
+		}
+		return certs, nil
+	}
+	var certs []*x509.Certificate
+	for rest := bytes.TrimSpace(input); len(rest) != 0; rest = bytes.TrimSpace(rest) {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("unexpected data after certificate %d of the chain", len(certs))
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block %q in certificate chain", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate %d of the chain: %v", len(certs), err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// chainLinked reports whether every certificate of certs is signed by the next.
+func chainLinked(certs []*x509.Certificate) bool {
+	for i := 0; i+1 < len(certs); i++ {
+		if certs[i].CheckSignatureFrom(certs[i+1]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// checkKeyAlgorithm rejects keys Sigstore clients cannot verify with: ECDSA
+// keys on curves other than P-256, P-384 and P-521 and RSA keys below
+// minRSAKeyBits.
+func checkKeyAlgorithm(pub any) error {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+		return fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeyBits {
+			return fmt.Errorf("RSA key of %d bits, at least %d required", key.N.BitLen(), minRSAKeyBits)
+		}
+		return nil
+	case ed25519.PublicKey:
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", pub)
+}
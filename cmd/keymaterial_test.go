@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizePublicKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPEM, ecDER := testPublicKeyPEM(t, &ecKey.PublicKey)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPEM, _ := testPublicKeyPEM(t, &rsaKey.PublicKey)
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)})
+	weakKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	weakPEM, _ := testPublicKeyPEM(t, &weakKey.PublicKey)
+	p224Key, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	p224PEM, _ := testPublicKeyPEM(t, &p224Key.PublicKey)
+	privateDER, _ := x509.MarshalECPrivateKey(ecKey)
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateDER})
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		wantErr string
+	}{
+		{name: "pem", input: ecPEM, want: ecPEM},
+		{name: "der", input: ecDER, want: ecPEM},
+		{name: "surrounding whitespace", input: append(append([]byte("\n"), ecPEM...), "\n\n"...), want: ecPEM},
+		{name: "pkcs1 rsa key", input: pkcs1PEM, want: rsaPEM},
+		{name: "private key", input: privatePEM, wantErr: "EC PRIVATE KEY"},
+		{name: "certificate", input: testCertificatePEM(t, "org", "cn"), wantErr: "certificate"},
+		{name: "two keys", input: append(append([]byte{}, ecPEM...), rsaPEM...), wantErr: "unexpected data"},
+		{name: "weak rsa key", input: weakPEM, wantErr: "1024 bits"},
+		{name: "unsupported curve", input: p224PEM, wantErr: "P-224"},
+		{name: "garbage", input: []byte("not a key"), wantErr: "not a PEM or DER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizePublicKey(tt.input)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NormalizePublicKey() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizePublicKey() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("NormalizePublicKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeCertificateChain(t *testing.T) {
+	chain := testCertificateChainPEM(t, "org", "leaf", "intermediate", "root")
+	certs, err := parseCertificates(chain)
+	if err != nil {
+		t.Fatalf("parseCertificates() error = %v", err)
+	}
+	var der, rootFirst []byte
+	for i := range certs {
+		der = append(der, certs[i].Raw...)
+		rootFirst = append(rootFirst, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[len(certs)-1-i].Raw})...)
+	}
+	unlinked := append(append([]byte{}, chain...), testCertificatePEM(t, "other", "other")...)
+	withKey := append(append([]byte{}, chain...), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte{1}})...)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		now     time.Time
+		wantErr string
+	}{
+		{name: "leaf first", input: chain, now: time.Now()},
+		{name: "root first", input: rootFirst, now: time.Now()},
+		{name: "der", input: der, now: time.Now()},
+		{name: "expired", input: chain, now: time.Now().Add(2 * time.Hour), wantErr: "expired"},
+		{name: "not yet valid", input: chain, now: time.Now().Add(-2 * time.Hour), wantErr: "not valid before"},
+		{name: "not a chain", input: unlinked, now: time.Now(), wantErr: "not a chain"},
+		{name: "private key block", input: withKey, now: time.Now(), wantErr: "PRIVATE KEY"},
+		{name: "garbage", input: []byte("not a certificate"), now: time.Now(), wantErr: "no PEM or DER"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeCertificateChain(tt.input, tt.now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("NormalizeCertificateChain() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeCertificateChain() error = %v", err)
+			}
+			if !bytes.Equal(got, chain) {
+				t.Errorf("NormalizeCertificateChain() = %s, want the chain leaf first %s", got, chain)
+			}
+		})
+	}
+}
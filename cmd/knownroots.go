@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"cmd/assembler"
+)
+
+// KnownRoot pins a root.json version of a repository by the fingerprints of
+// its root role keys, as reported by RootKeys.
+type KnownRoot struct {
+	Version   int64
+	Threshold int
+	// Fingerprints are the RootKey fingerprints of the root role.
+	Fingerprints []string
+}
+
+// knownRoots are the pinned roots of the mirror presets. The public-good
+// root is version 7, the root embedded in Sigstore clients, whose keys are
+// those of the root-signing ceremony.
+var knownRoots = map[string]KnownRoot{
+	mirrorPresets["public-good"]: {
+		Version:   7,
+		Threshold: 3,
+		Fingerprints: []string{
+			"0673582046e91a71682393136788cb919fec710ff32f0405f8d6c1c4e353b32a",
+			"5330d64c5e6be93dd8d118433cffec9a079aa7260706502b352f3f88ce8d03ff",
+			"56cf17e59dacce4f7e8c9066fa9d0f6850c038b3778aa3dad8764d3e3a76356b",
+			"982b189ef57e402bd5a68367ccfdcdb0b771460ee3db5c16c78f097f1df4e5ac",
+			"e94c11193ffde323a900649a7e88e5ef153fbbe6a3bae6b6fa1dea55f4e2d681",
+		},
+	},
+}
+
+// CheckKnownRoot checks that the root chain of a repository goes through
+// known: that the mirror's root of the known version has the known root
+// keys and threshold, and that every root from that version to latestRoot
+// is signed by the keys of the previous one, so the latest root keys derive
+// from the known ones however the ceremony rotated them since.
+//
+// Parameters:
+//   - fetcher: The mirror of the repository.
+//   - known: The pinned root.
+//   - latestRoot: The latest verified root.json of the repository.
+//
+// Returns:
+//   - error: nil if the chain goes through known, otherwise an error wrapping assembler.ErrVerification.
+func CheckKnownRoot(fetcher Fetcher, known KnownRoot, latestRoot []byte) error {
+	latestVersion, err := assembler.MetadataVersion(latestRoot)
+	if err != nil {
+		return err
+	}
+	if latestVersion < known.Version {
+		return fmt.Errorf("%w: the latest root.json is version %d, older than the known root version %d", assembler.ErrVerification, latestVersion, known.Version)
+	}
+	roots := [][]byte{}
+	for version := known.Version; version < latestVersion; version++ {
+		root, err := fetchMetadata(fetcher, fmt.Sprintf("%d.root.json", version))
+		if err != nil {
+			return fmt.Errorf("could not get root version %d: %w: %v", version, assembler.ErrMirrorUnreachable, err)
+		}
+		roots = append(roots, root)
+	}
+	roots = append(roots, latestRoot)
+	roles, err := RootKeys(roots[0])
+	if err != nil {
+		return fmt.Errorf("%w: root version %d: %v", assembler.ErrVerification, known.Version, err)
+	}
+	var fingerprints []string
+	for _, key := range roles[0].Keys {
+		fingerprints = append(fingerprints, key.Fingerprint)
+	}
+	sort.Strings(fingerprints)
+	want := append([]string(nil), known.Fingerprints...)
+	sort.Strings(want)
+	if roles[0].Threshold != known.Threshold || strings.Join(fingerprints, ",") != strings.Join(want, ",") {
+		return fmt.Errorf("%w: the root keys of root version %d do not match the known root keys", assembler.ErrVerification, known.Version)
+	}
+	if err := VerifyRootChain(roots); err != nil {
+		return fmt.Errorf("%w: the root chain from the known root version %d: %v", assembler.ErrVerification, known.Version, err)
+	}
+	return nil
+}
+
+// checkKnownRoot runs CheckKnownRoot for the preset mirror assembled with the
+// root.json at rootPath, exiting on errors unless allowUnknown. Other mirrors
+// are not checked.
+func checkKnownRoot(mirror, rootPath string, allowUnknown bool) {
+	known, err := verifyKnownRoot(mirror, rootPath)
+	if err != nil {
+		if allowUnknown {
+			warnf(err, "%v, continuing with --allow-unknown-root", err)
+			return
+		}
+		fatalf(err, "Error: %v (--allow-unknown-root skips this check)", err)
+	}
+	if known != nil {
+		log.Printf("root.json chains to the known root version %d of %s\n", known.Version, mirror)
+	}
+}
+
+// verifyKnownRoot runs CheckKnownRoot for the preset mirror assembled with
+// the root.json at rootPath, and returns the known root it chains to, or nil
+// for other mirrors, which are not checked.
+func verifyKnownRoot(mirror, rootPath string) (*KnownRoot, error) {
+	known, ok := knownRoots[NormalizeMirrorURL(mirror)]
+	if !ok {
+		return nil, nil
+	}
+	latestRoot, err := os.ReadFile(rootPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read root.json: %v", err)
+	}
+	fetcher, err := NewFetcher(mirror)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckKnownRoot(fetcher, known, latestRoot); err != nil {
+		return nil, err
+	}
+	return &known, nil
+}
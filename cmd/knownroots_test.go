@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cmd/assembler"
+
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+func TestCheckKnownRoot(t *testing.T) {
+	oldKey, _ := keys.GenerateEd25519Key()
+	newKey, _ := keys.GenerateEd25519Key()
+	otherKey, _ := keys.GenerateEd25519Key()
+	roots := [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, oldKey, oldKey), testRoot(t, 3, newKey, oldKey, newKey), testRoot(t, 4, newKey, newKey)}
+	known := func(root []byte, version int64) KnownRoot {
+		roles, err := RootKeys(root)
+		if err != nil {
+			t.Fatalf("RootKeys() error = %v", err)
+		}
+		knownRoot := KnownRoot{Version: version, Threshold: roles[0].Threshold}
+		for _, key := range roles[0].Keys {
+			knownRoot.Fingerprints = append(knownRoot.Fingerprints, key.Fingerprint)
+		}
+		return knownRoot
+	}
+
+	tests := []struct {
+		name       string
+		roots      [][]byte
+		known      KnownRoot
+		latestRoot []byte
+		wantErr    error
+	}{
+		{"latest is the known root", roots, known(roots[1], 2), roots[1], nil},
+		{"rotated since the known root", roots, known(roots[1], 2), roots[3], nil},
+		{"other root keys", roots, known(testRoot(t, 2, otherKey, otherKey), 2), roots[3], assembler.ErrVerification},
+		{"other threshold", roots, KnownRoot{Version: 2, Threshold: 2, Fingerprints: known(roots[1], 2).Fingerprints}, roots[3], assembler.ErrVerification},
+		{"latest older than the known root", roots, known(roots[1], 2), roots[0], assembler.ErrVerification},
+		{"broken chain", [][]byte{roots[0], roots[1], testRoot(t, 3, otherKey, otherKey)}, known(roots[1], 2), testRoot(t, 3, otherKey, otherKey), assembler.ErrVerification},
+		{"missing version", [][]byte{roots[0], nil, roots[2]}, known(roots[1], 2), roots[3], assembler.ErrMirrorUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := t.TempDir()
+			for i, root := range tt.roots {
+				if root == nil {
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(upstream, fmt.Sprintf("%d.root.json", i+1)), root, 0o644); err != nil {
+					t.Fatalf("Failed to write root: %v", err)
+				}
+			}
+			err := CheckKnownRoot(dirFetcher{dir: upstream}, tt.known, tt.latestRoot)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Errorf("CheckKnownRoot() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKnownRoots(t *testing.T) {
+	for mirror, known := range knownRoots {
+		if len(known.Fingerprints) < known.Threshold || known.Threshold < 1 {
+			t.Errorf("known root of %s has threshold %d of %d keys", mirror, known.Threshold, len(known.Fingerprints))
+		}
+	}
+}
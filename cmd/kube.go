@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceAccountDir is where Kubernetes mounts the pod's service account credentials.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// errKubeNotFound is returned by kubeClient when the requested object does not exist.
+var errKubeNotFound = errors.New("not found")
+
+// kubeClient is a minimal Kubernetes API client authenticated with the
+// service account mounted into the pod the tool is running in, or with a
+// kubeconfig file.
+type kubeClient struct {
+	host  string
+	token string
+	// namespace is the namespace of namespaced objects applied by the tool.
+	namespace string
+	client    *http.Client
+}
+
+// kubeService is the subset of a core/v1 Service used by the tool.
+type kubeService struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Ports []struct {
+			Port int `json:"port"`
+		} `json:"ports"`
+	} `json:"spec"`
+}
+
+// kubeSecret is the subset of a core/v1 Secret used by the tool.
+type kubeSecret struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+// newInClusterKubeClient returns a kubeClient configured from the environment
+// and the service account of the pod.
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running inside a Kubernetes cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read service account token: %v", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read service account CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("could not parse service account CA")
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		namespace = []byte("default")
+	}
+	return &kubeClient{
+		host:      "https://" + strings.Trim(host, "[]") + ":" + port,
+		token:     strings.TrimSpace(string(token)),
+		namespace: strings.TrimSpace(string(namespace)),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// get fetches the API object at path and decodes it into v.
+func (k *kubeClient) get(path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, k.host+path, nil)
+	if err != nil {
+		return err
+	}
+	k.authorize(req)
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errKubeNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// fieldManager is the field manager of the objects applied by the tool.
+const fieldManager = "trustroot-assembler"
+
+// authorize sets the bearer token of the client on req, if it has one.
+func (k *kubeClient) authorize(req *http.Request) {
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+}
+
+// apply creates or updates the API object at path with a server-side apply
+// of manifest, which may be YAML. With dryRun, the request goes through
+// admission and validation without being persisted.
+func (k *kubeClient) apply(path string, manifest []byte, dryRun bool) error {
+	query := "?fieldManager=" + fieldManager + "&force=true"
+	if dryRun {
+		query += "&dryRun=All"
+	}
+	req, err := http.NewRequest(http.MethodPatch, k.host+path+query, bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+	k.authorize(req)
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PATCH %s: %s: %s", path, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// jsonPatch applies the RFC 6902 JSON Patch patch to the API object at path.
+func (k *kubeClient) jsonPatch(path string, patch []byte) error {
+	req, err := http.NewRequest(http.MethodPatch, k.host+path+"?fieldManager="+fieldManager, bytes.NewReader(patch))
+	if err != nil {
+		return err
+	}
+	k.authorize(req)
+	req.Header.Set("Content-Type", "application/json-patch+json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PATCH %s: %s: %s", path, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// delete deletes the API object at path. An object that does not exist is
+// not an error.
+func (k *kubeClient) delete(path string) error {
+	req, err := http.NewRequest(http.MethodDelete, k.host+path, nil)
+	if err != nil {
+		return err
+	}
+	k.authorize(req)
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("DELETE %s: %s: %s", path, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// applyTrustRoot server-side applies a TrustRoot manifest named name.
+func (k *kubeClient) applyTrustRoot(name string, manifest []byte) error {
+	return k.apply(trustRootPath(name), manifest, false)
+}
+
+// dryRunApply server-side applies a manifest rendered in the --output format
+// with dryRun=All, so admission webhooks and CRD validation run without the
+// object being persisted.
+func (k *kubeClient) dryRunApply(format, name string, manifest []byte) error {
+	return k.applyOutput(format, name, manifest, true)
+}
+
+// applyOutput server-side applies a manifest rendered in the --output format
+// named name, ConfigMaps and Secrets in the namespace of the client.
+func (k *kubeClient) applyOutput(format, name string, manifest []byte, dryRun bool) error {
+	var path string
+	switch format {
+	case outputTrustRoot:
+		path = trustRootPath(name)
+	case outputConfigMap:
+		path = fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", k.namespace, name)
+	case outputSecret:
+		path = fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", k.namespace, name)
+	default:
+		return fmt.Errorf("the %s output is not a Kubernetes object", format)
+	}
+	return k.apply(path, manifest, dryRun)
+}
+
+// trustRootPath returns the API path of the TrustRoot name.
+func trustRootPath(name string) string {
+	return "/apis/policy.sigstore.dev/v1alpha1/trustroots/" + name
+}
+
+// getService returns the Service name in namespace.
+func (k *kubeClient) getService(namespace, name string) (*kubeService, error) {
+	svc := &kubeService{}
+	if err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/services/%s", namespace, name), svc); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// getSecret returns the Secret name in namespace.
+func (k *kubeClient) getSecret(namespace, name string) (*kubeSecret, error) {
+	secret := &kubeSecret{}
+	if err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kubeConfig is the subset of a kubeconfig file used by the tool.
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+			Exec                  any    `yaml:"exec"`
+			AuthProvider          any    `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// newKubeClient returns a kubeClient for the cluster the tool is running in,
+// or else for the current context of the kubeconfig file of $KUBECONFIG or
+// ~/.kube/config.
+func newKubeClient() (*kubeClient, error) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return newInClusterKubeClient()
+	}
+	return newContextKubeClient("")
+}
+
+// newContextKubeClient returns a kubeClient for the context name of the
+// kubeconfig file of $KUBECONFIG or ~/.kube/config, or for its current
+// context if name is empty.
+func newContextKubeClient(name string) (*kubeClient, error) {
+	path := os.Getenv("KUBECONFIG")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("not running inside a Kubernetes cluster and no kubeconfig: %v", err)
+		}
+		path = filepath.Join(home, ".kube", "config")
+	}
+	// Only the first file of a KUBECONFIG list is read
+	path, _, _ = strings.Cut(path, string(os.PathListSeparator))
+	return newKubeconfigContextKubeClient(path, name)
+}
+
+// newKubeconfigKubeClient returns a kubeClient for the current context of
+// the kubeconfig file at path. Tokens and client certificates are supported,
+// exec and auth-provider plugins are not.
+func newKubeconfigKubeClient(path string) (*kubeClient, error) {
+	return newKubeconfigContextKubeClient(path, "")
+}
+
+// newKubeconfigContextKubeClient returns a kubeClient for the context name of
+// the kubeconfig file at path, or for its current context if name is empty.
+func newKubeconfigContextKubeClient(path, name string) (*kubeClient, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read kubeconfig: %v", err)
+	}
+	var config kubeConfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("could not parse kubeconfig %s: %v", path, err)
+	}
+	dir := filepath.Dir(path)
+	contextKind := "context"
+	if name == "" {
+		name, contextKind = config.CurrentContext, "current context"
+	}
+
+	k := &kubeClient{namespace: "default"}
+	found := false
+	var clusterName, userName string
+	for _, context := range config.Contexts {
+		if context.Name == name {
+			clusterName, userName, found = context.Context.Cluster, context.Context.User, true
+			if context.Context.Namespace != "" {
+				k.namespace = context.Context.Namespace
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s %q of kubeconfig %s not found", contextKind, name, path)
+	}
+
+	tlsConfig := &tls.Config{}
+	found = false
+	for _, cluster := range config.Clusters {
+		if cluster.Name != clusterName {
+			continue
+		}
+		found = true
+		k.host = strings.TrimSuffix(cluster.Cluster.Server, "/")
+		tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTLSVerify
+		ca, err := kubeconfigData(dir, cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate authority of cluster %s: %v", clusterName, err)
+		}
+		if ca != nil {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("could not parse certificate authority of cluster %s", clusterName)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	if !found || k.host == "" {
+		return nil, fmt.Errorf("cluster %q of kubeconfig %s not found", clusterName, path)
+	}
+
+	for _, user := range config.Users {
+		if user.Name != userName {
+			continue
+		}
+		if user.User.Exec != nil || user.User.AuthProvider != nil {
+			return nil, fmt.Errorf("user %s of kubeconfig %s authenticates with a plugin, which is not supported: use a token or client certificate", userName, path)
+		}
+		k.token = user.User.Token
+		if user.User.TokenFile != "" {
+			token, err := os.ReadFile(kubeconfigPath(dir, user.User.TokenFile))
+			if err != nil {
+				return nil, fmt.Errorf("could not read token of user %s: %v", userName, err)
+			}
+			k.token = strings.TrimSpace(string(token))
+		}
+		cert, err := kubeconfigData(dir, user.User.ClientCertificate, user.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client certificate of user %s: %v", userName, err)
+		}
+		key, err := kubeconfigData(dir, user.User.ClientKey, user.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client key of user %s: %v", userName, err)
+		}
+		if cert != nil || key != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("could not load client certificate of user %s: %v", userName, err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+		}
+	}
+	k.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}}
+	return k, nil
+}
+
+// kubeconfigData returns the content of a kubeconfig field set either as a
+// file, relative to the kubeconfig directory dir, or as base64 data. It
+// returns nil if neither is set.
+func kubeconfigData(dir, file, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return os.ReadFile(kubeconfigPath(dir, file))
+	}
+	return nil, nil
+}
+
+// kubeconfigPath resolves a path of a kubeconfig file relative to its
+// directory dir.
+func kubeconfigPath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
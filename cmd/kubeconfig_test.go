@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKubeconfigDryRunApply(t *testing.T) {
+	var gotPath, gotQuery, gotAuthorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotQuery, gotAuthorization = r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "invalid") {
+			http.Error(w, `admission webhook "policy.sigstore.dev" denied the request`, http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(kubeconfig, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+current-context: test
+contexts:
+- name: other
+  context:
+    cluster: other
+    user: other
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: sigstore
+clusters:
+- name: test
+  cluster:
+    server: %s
+    certificate-authority-data: %s
+users:
+- name: test
+  user:
+    token: test-token
+`, server.URL, base64.StdEncoding.EncodeToString(ca))), 0o600); err != nil {
+		t.Fatalf("Failed to write kubeconfig: %v", err)
+	}
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBECONFIG", kubeconfig)
+	kube, err := newKubeClient()
+	if err != nil {
+		t.Fatalf("newKubeClient() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		manifest string
+		wantPath string
+		wantErr  bool
+	}{
+		{"trustroot", outputTrustRoot, "kind: TrustRoot", "/apis/policy.sigstore.dev/v1alpha1/trustroots/tuf-example", false},
+		{"configmap", outputConfigMap, "kind: ConfigMap", "/api/v1/namespaces/sigstore/configmaps/tuf-example", false},
+		{"secret", outputSecret, "kind: Secret", "/api/v1/namespaces/sigstore/secrets/tuf-example", false},
+		{"rejected", outputTrustRoot, "kind: TrustRoot # invalid", "/apis/policy.sigstore.dev/v1alpha1/trustroots/tuf-example", true},
+		{"not an object", outputTrustedRoot, "{}", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotQuery, gotAuthorization = "", "", ""
+			err := kube.dryRunApply(tt.format, "tuf-example", []byte(tt.manifest))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dryRunApply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("dryRunApply() requested %q, want %q", gotPath, tt.wantPath)
+			}
+			if tt.wantPath == "" {
+				return
+			}
+			if !strings.Contains(gotQuery, "dryRun=All") {
+				t.Errorf("dryRunApply() query = %q, want dryRun=All", gotQuery)
+			}
+			if gotAuthorization != "Bearer test-token" {
+				t.Errorf("dryRunApply() Authorization = %q, want the kubeconfig token", gotAuthorization)
+			}
+		})
+	}
+}
+
+func TestNewKubeconfigKubeClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		kubeconfig string
+		wantErr    string
+	}{
+		{"missing context", "current-context: test\n", `current context "test"`},
+		{"missing cluster", "current-context: test\ncontexts:\n- name: test\n  context:\n    cluster: test\n", `cluster "test"`},
+		{"exec plugin", "current-context: test\ncontexts:\n- name: test\n  context:\n    cluster: test\n    user: test\nclusters:\n- name: test\n  cluster:\n    server: https://127.0.0.1:6443\nusers:\n- name: test\n  user:\n    exec:\n      command: aws\n", "not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeconfig := filepath.Join(t.TempDir(), "config")
+			if err := os.WriteFile(kubeconfig, []byte(tt.kubeconfig), 0o600); err != nil {
+				t.Fatalf("Failed to write kubeconfig: %v", err)
+			}
+			_, err := newKubeconfigKubeClient(kubeconfig)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newKubeconfigKubeClient() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -1,17 +1,14 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"context"
+	"bytes"
 	"encoding/base64"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -19,16 +16,93 @@ import (
 	"strings"
 	"time"
 
-	"github.com/sigstore/sigstore/pkg/tuf"
+	"cmd/assembler"
 )
 
 func main() {
+	log.SetFlags(0)
+	log.SetOutput(os.Stderr)
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+	// `assemble` names the main command
+	if len(os.Args) > 1 && os.Args[1] == "assemble" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// Define default mirror URL and parse command-line flags
 	defaultMirror := "https://tuf-repo-cdn.sigstore.dev"
 	mirror := flag.String("mirror", defaultMirror, "Sigstore TUF Repository Mirror")
+	discoverInCluster := flag.Bool("discover-in-cluster", false, "Discover a private Sigstore deployed by sigstore/scaffolding in the current cluster and emit a SigstoreKeys TrustRoot")
+	fulcioURL := flag.String("fulcio-url", "", "Base URL of a Fulcio instance whose /api/v2/trustBundle CA chains are put in a SigstoreKeys TrustRoot, replacing any discovered Fulcio")
+	rekorURL := flag.String("rekor-url", "", "Base URL of a Rekor instance whose /api/v1/log/publicKey, checked against its signed tree head, is put in a SigstoreKeys TrustRoot, replacing any discovered Rekor")
+	ctlogURL := flag.String("ctlog-url", "", "Base URL of a CT log (CTFE) whose signed tree head is checked with --ctlog-public-key before the log is put in a SigstoreKeys TrustRoot, replacing any discovered CT log")
+	ctlogPublicKey := flag.String("ctlog-public-key", "", "PEM public key of the CT log of --ctlog-url")
+	tsaURL := flag.String("tsa-url", "", "Base URL of a Timestamp Authority whose /api/v1/timestamp/certchain is put in a SigstoreKeys TrustRoot, replacing any discovered TSA")
+	tsaCertChain := flag.String("tsa-cert-chain", "", "PEM certificate chain of a Timestamp Authority to include in the SigstoreKeys TrustRoot")
+	tsaURI := flag.String("tsa-uri", "", "URI of the Timestamp Authority of --tsa-cert-chain (defaults to --tsa-url or the discovered TSA)")
+	rekorV2URL := flag.String("rekor-v2-url", "", "Base URL of a Rekor v2 (tiled) log to include in the SigstoreKeys TrustRoot")
+	rekorV2PublicKey := flag.String("rekor-v2-public-key", "", "PEM public key of the Rekor v2 log of --rekor-v2-url")
+	rootOut := flag.String("root-out", "", "Also write the verified root.json to this path, e.g. for cosign initialize --root")
+	clientTrustConfigOut := flag.String("client-trust-config", "", "Also write a ClientTrustConfig JSON (trusted root + signing config) to this path")
+	expiryGrace := flag.Duration("expiry-grace", 0, fmt.Sprintf("Fail with exit code %d if timestamp.json or snapshot.json expire within this duration, e.g. the refresh interval", exitCodeExpiring))
+	nameStrategy := flag.String("name-strategy", nameStrategyTimestamp, "How the TrustRoot is named: timestamp (<mirror>-<unix time>) or digest (<mirror>-<snapshot version>-<content digest>)")
+	historyDir := flag.String("history-dir", defaultHistoryDir(), "State directory keeping the last generated TrustRoots for rollback, by default history under $XDG_STATE_HOME/trustroot-assembler if set")
+	historyKeep := flag.Int("history-keep", 10, "Number of generations kept in --history-dir")
+	auditLog := flag.String("audit-log", "", auditLogUsage)
+	output := flag.String("output", outputTrustRoot, "Output format of repositories: trustroot, configmap, secret, trusted-root, digest or a custom registered Renderer")
+	templatePath := flag.String("template", "", "Go text/template file rendering the assembled repository instead of --output")
+	archivePrefix := flag.String("archive-prefix", "", "Directory of the repository inside the archive, e.g. repository, instead of the archive root")
+	archiveTargetsDir := flag.String("archive-targets-dir", "targets", "Directory of the targets inside the repository of the archive, also set as the targets field of TrustRoots")
+	wrapBase64 := flag.Int("wrap-base64", 0, "Wrap the base64 encoded root.json, archive, keys and certificate chains of manifests at this column inside their YAML block scalars, 0 for a single line")
+	targetDigests := flag.Bool("annotate-target-digests", false, "Annotate the manifest with the SHA-256 of every embedded target, to audit the trusted keys and certificates without unpacking the archive")
+	deterministicMode := flag.Bool("deterministic", false, "Fixed clock (SOURCE_DATE_EPOCH or the unix epoch), fixed temporary directory names and reproducible archives, for regression tests")
+	httpTimeout := flag.Duration("http-timeout", defaultHTTPTimeout, httpTimeoutUsage)
+	runTimeout := flag.Duration("run-timeout", 0, runTimeoutUsage)
+	skipCleanupFlag := flag.Bool("skip-cleanup", false, skipCleanupUsage)
+	debug := flag.Bool("debug", false, debugUsage)
+	logHTTP := flag.String("log-http", "", logHTTPUsage)
+	workDirFlag := flag.String("work-dir", "", workDirUsage)
+	noColor := flag.Bool("no-color", false, noColorUsage)
+	maxArchive := flag.String("max-archive-size", "", "Fail when the repository archive, before base64 encoding, exceeds this size, e.g. 512Ki, for downstream systems with their own payload limits")
+	memoryLimit := flag.String("memory-limit", "", "Soft memory ceiling, e.g. 48Mi for a 64Mi Job: tunes the garbage collector and spills outputs beyond a quarter of it to disk")
+	metadataOnly := flag.Bool("metadata-only", false, "Verify the metadata chain without downloading any target and emit a remote TrustRoot, whose targets the policy-controller fetches from the mirror")
+	rootHistory := flag.Bool("root-history", false, "Embed every root version, 1.root.json to the latest, in the repository so clients can verify the root chain themselves")
+	repositoryMap := flag.String("map", "", "TAP-4 map file describing a multi-repository setup to assemble instead of --mirror")
+	mapRoots := flag.String("map-roots", "", "Directory of the trusted initial root.json of every repository of --map, as <name>/root.json")
+	dryRun := flag.String("dry-run", "", "server: submit the output to the Kubernetes API of the cluster or kubeconfig with dryRun=All before printing it, so admission webhooks and CRD validation run without persisting it")
+	interactive := flag.Bool("interactive", false, "Walk through mirror selection, naming, output format and apply target, then print the equivalent command and run it")
+	storeSecretName := flag.String("store-secret", "", "Secret, [namespace/]name in the namespace of the cluster or kubeconfig, updated in place with root.json and repository.tar.gz, keeping the previous generation under .previous keys")
+	vaultPath := flag.String("vault-path", "", "Path of a Vault KV v2 secret written with the manifest, root.json and repository.tar.gz, at VAULT_ADDR")
+	vaultMount := flag.String("vault-mount", "secret", "Mount path of the KV v2 secrets engine of --vault-path")
+	vaultAuth := flag.String("vault-auth", vaultAuthToken, "Vault auth method: token (VAULT_TOKEN), kubernetes (service account of the pod) or approle (VAULT_ROLE_ID and VAULT_SECRET_ID)")
+	vaultAuthMount := flag.String("vault-auth-mount", "", "Mount path of the Vault auth method, defaults to its name")
+	vaultRole := flag.String("vault-role", "", "Role of the kubernetes Vault auth method")
+	awsSecretID := flag.String("aws-secret-id", "", "AWS Secrets Manager secret, created if missing, whose new version is a JSON object with the manifest, root.json and repository.tar.gz")
+	awsSSMPath := flag.String("aws-ssm-path", "", "SSM Parameter Store path, e.g. /sigstore/trustroot, of String parameters with the name, root.json and digests of the trust root")
+	gcpSecret := flag.String("gcp-secret", "", "GCP Secret Manager secret, projects/PROJECT/secrets/SECRET or a secret ID of GOOGLE_CLOUD_PROJECT, created if missing, given a version with the manifest, root.json and repository.tar.gz")
+	resultFile := flag.String("result-file", "", "Write a JSON result with the output files, their sizes and digests, and the metadata versions and expiries to this path")
+	allowUnknownRoot := flag.Bool("allow-unknown-root", false, "Assemble the public-good repository even if its root.json does not chain to the known Sigstore root keys")
+	flag.StringVar(&rootSource, "root-source", "", rootSourceUsage)
+	flag.StringVar(&rootChecksum, "root-checksum", "", rootChecksumUsage)
+	crossCheck := flag.String("cross-check", "", "Require this second source, root-signing for the sigstore/root-signing GitHub repository or a mirror URL, to serve the same root.json byte for byte")
+	showRootKeys := flag.Bool("show-root-keys", false, "Print the key IDs, fingerprints and thresholds of the verified root.json to stderr, to compare with the root-signing ceremony")
+	previous := flag.String("previous", "", "Previous TrustRoot manifest, e.g. the one being refreshed or a history generation, whose changes to the metadata versions, targets and root keys are printed to stderr and added to --result-file")
+	strict := flag.Bool("strict", false, "Fail if the assembled repository holds files or directories no verified metadata references, e.g. stray dotfiles or editor backups")
+	flag.BoolVar(&failOnWarning, "fail-on-warning", false, failOnWarningUsage)
+	flag.StringVar(&pushgateway, "pushgateway", "", pushgatewayUsage)
+	flag.StringVar(&pushgatewayJob, "pushgateway-job", pushgatewayJob, "Job label of the metrics pushed to --pushgateway, to tell the runs of several CronJobs apart")
+	listArchive := flag.Bool("list-archive", false, "Print the entries, sizes and SHA-256 of the repository archive to stderr, to confirm what the output embeds")
+	var plugins stringsFlag
+	flag.Var(&plugins, "plugin", "Executable receiving the assembled result as JSON on stdin once the output is printed, repeatable")
+	var cloudEventSinks stringsFlag
+	flag.Var(&cloudEventSinks, "cloudevents-sink", "URL receiving a CloudEvent with the digests and metadata versions of every generated trust root, repeatable")
+	flag.Var(&delegatedTargets, "delegated-target", "Target delegated to succinct hash bins to resolve, fetching only the bins the targets hash to instead of every bin, repeatable")
+	var fetchPolicyFlags stringsFlag
+	flag.Var(&fetchPolicyFlags, "fetch-policy", "Retries, per-attempt timeout and failure policy of a file class, CLASS:retries=N,timeout=DURATION[,skip] with CLASS metadata, target or expired-target, skip leaving out expired targets that still fail, repeatable")
 	help := flag.Bool("help", false, "Print this help message")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [assemble] [options]\n       %s mirror-sync|serve|api|admission-webhook|watch|rollback|mockmirror|compare|inspect|report|export|convert|rewire|tenants|e2e-verify|preflight|audit-verify [options]\n       %s bundle export|import [options]\n", os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
@@ -36,133 +110,654 @@ func main() {
 		flag.Usage()
 		os.Exit(0)
 	}
-	log.SetFlags(0)
-	log.SetOutput(os.Stderr)
+	if *interactive {
+		os.Exit(runInteractive())
+	}
+	skipCleanup = *skipCleanupFlag
+	workDirBase = *workDirFlag
+	defer startRunDeadline(*runTimeout, exitOnRunTimeout(*runTimeout))()
+	setupLogOutput(*noColor)
+	if err := validateLogHTTP(*logHTTP); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	SetHTTPClient(newHTTPClient(*httpTimeout, *debug, *logHTTP))
+	if *maxArchive != "" {
+		limit, err := ParseByteSize(*maxArchive)
+		if err != nil {
+			log.Fatalf("Error: --max-archive-size: %v", err)
+		}
+		maxArchiveSize = limit
+	}
+	if *memoryLimit != "" {
+		limit, err := ParseByteSize(*memoryLimit)
+		if err != nil {
+			log.Fatalf("Error: --memory-limit: %v", err)
+		}
+		SetMemoryLimit(limit)
+	}
+	for _, value := range fetchPolicyFlags {
+		class, policy, err := ParseFetchPolicy(value)
+		if err != nil {
+			log.Fatalf("Error: --fetch-policy: %v", err)
+		}
+		fetchPolicies[class] = policy
+	}
+	archiveLayout = assembler.ArchiveLayout{Prefix: *archivePrefix, TargetsDir: *archiveTargetsDir}
+	annotateTargetDigests = *targetDigests
+	if *wrapBase64 < 0 {
+		log.Fatalf("Error: --wrap-base64 must not be negative")
+	}
+	base64LineWidth = *wrapBase64
+	if err := archiveLayout.Validate(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	if *deterministicMode {
+		if err := enableDeterministicMode(); err != nil {
+			log.Fatalf("Error: --deterministic: %v", err)
+		}
+	}
+	sigstoreKeysOutput := *discoverInCluster || *fulcioURL != "" || *rekorURL != "" || *ctlogURL != "" || *tsaURL != ""
+	if !sigstoreKeysOutput && (*tsaCertChain != "" || *tsaURI != "" || *rekorV2URL != "" || *rekorV2PublicKey != "") {
+		// Targets of a serialized repository are only trusted when signed by its targets role
+		log.Fatalf("Error: --tsa-cert-chain, --tsa-uri, --rekor-v2-url and --rekor-v2-public-key require --discover-in-cluster, --fulcio-url, --rekor-url, --ctlog-url or --tsa-url, keys of a repository must be signed into its targets.json")
+	}
+	if *nameStrategy != nameStrategyTimestamp && *nameStrategy != nameStrategyDigest {
+		log.Fatalf("Error: --name-strategy must be %s or %s", nameStrategyTimestamp, nameStrategyDigest)
+	}
+	if *templatePath != "" {
+		if *output != outputTrustRoot {
+			log.Fatalf("Error: --template cannot be used with --output")
+		}
+		renderer, err := NewTemplateRenderer(*templatePath)
+		if err != nil {
+			log.Fatalf("Error: --template: %v", err)
+		}
+		RegisterRenderer(outputTemplate, renderer)
+		*output = outputTemplate
+	}
+	if _, err := LookupRenderer(*output); err != nil {
+		log.Fatalf("Error: --output: %v", err)
+	}
+	if *dryRun != "" && *dryRun != dryRunServer {
+		log.Fatalf("Error: --dry-run must be %s", dryRunServer)
+	}
+	if *dryRun == dryRunServer && (*output == outputTrustedRoot || *output == outputDigest) {
+		log.Fatalf("Error: --dry-run=%s requires a Kubernetes --output, not %s", dryRunServer, *output)
+	}
+	if (*repositoryMap == "") != (*mapRoots == "") {
+		log.Fatalf("Error: --map and --map-roots must be used together, repositories of a map are bootstrapped from trusted roots")
+	}
+	if *repositoryMap != "" && (*output == outputTrustedRoot || *output == outputDigest) {
+		log.Fatalf("Error: --map emits a TrustRoot per repository and requires a Kubernetes --output, not %s", *output)
+	}
+	if *repositoryMap != "" && (*storeSecretName != "" || *vaultPath != "" || *awsSecretID != "" || *awsSSMPath != "" || *gcpSecret != "") {
+		log.Fatalf("Error: --store-secret, --vault-path, --aws-secret-id, --aws-ssm-path and --gcp-secret store a single repository and cannot be used with --map")
+	}
+	if *rootHistory && *repositoryMap != "" {
+		log.Fatalf("Error: --root-history cannot be used with --map")
+	}
+	if *crossCheck != "" && *repositoryMap != "" {
+		log.Fatalf("Error: --cross-check cannot be used with --map")
+	}
+	if rootSource != "" && (sigstoreKeysOutput || *repositoryMap != "") {
+		log.Fatalf("Error: --root-source bootstraps the repository of --mirror and cannot be used with SigstoreKeys options or --map")
+	}
+	if rootChecksum != "" && (sigstoreKeysOutput || *repositoryMap != "") {
+		log.Fatalf("Error: --root-checksum pins the initial root of --mirror and cannot be used with SigstoreKeys options or --map")
+	}
+	if rootChecksum != "" && !validRootChecksum(rootChecksum) {
+		log.Fatalf("Error: --root-checksum must be a hex encoded SHA-256, got %q", rootChecksum)
+	}
+	if *previous != "" && (sigstoreKeysOutput || *repositoryMap != "" || *metadataOnly) {
+		log.Fatalf("Error: --previous compares a repository assembled from --mirror and cannot be used with SigstoreKeys options, --map or --metadata-only")
+	}
+	if *auditLog != "" && sigstoreKeysOutput {
+		log.Fatalf("Error: --audit-log records trust roots assembled from a TUF repository and cannot be used with SigstoreKeys options")
+	}
+	if *strict && (sigstoreKeysOutput || *repositoryMap != "" || *metadataOnly) {
+		log.Fatalf("Error: --strict checks a repository assembled from --mirror and cannot be used with SigstoreKeys options, --map or --metadata-only")
+	}
+	if *metadataOnly && (sigstoreKeysOutput || *repositoryMap != "" || *rootHistory || *clientTrustConfigOut != "" || *listArchive || *output != outputTrustRoot) {
+		log.Fatalf("Error: --metadata-only emits a remote TrustRoot and cannot be used with SigstoreKeys options, --map, --root-history, --client-trust-config, --list-archive, --template or --output")
+	}
+	if *metadataOnly && (*storeSecretName != "" || *vaultPath != "" || *awsSecretID != "" || *awsSSMPath != "" || *gcpSecret != "") {
+		log.Fatalf("Error: --store-secret, --vault-path, --aws-secret-id, --aws-ssm-path and --gcp-secret store a repository, --metadata-only assembles none")
+	}
+	if (*rekorV2URL == "") != (*rekorV2PublicKey == "") {
+		log.Fatalf("Error: --rekor-v2-url and --rekor-v2-public-key must be used together")
+	}
+	if *tsaURL != "" && *tsaCertChain != "" {
+		log.Fatalf("Error: --tsa-url and --tsa-cert-chain cannot be used together")
+	}
+	if (*ctlogURL == "") != (*ctlogPublicKey == "") {
+		log.Fatalf("Error: --ctlog-url and --ctlog-public-key must be used together")
+	}
+	if sigstoreKeysOutput && (*storeSecretName != "" || *vaultPath != "" || *awsSecretID != "" || *awsSSMPath != "" || *gcpSecret != "") {
+		log.Fatalf("Error: --store-secret, --vault-path, --aws-secret-id, --aws-ssm-path and --gcp-secret store a repository, a SigstoreKeys TrustRoot has none")
+	}
+	vaultCredentials := VaultAuth{
+		Method:   *vaultAuth,
+		Mount:    *vaultAuthMount,
+		Token:    os.Getenv("VAULT_TOKEN"),
+		Role:     *vaultRole,
+		RoleID:   os.Getenv("VAULT_ROLE_ID"),
+		SecretID: os.Getenv("VAULT_SECRET_ID"),
+	}
+
+	// Emit a SigstoreKeys TrustRoot for a private Sigstore running in this
+	// cluster or reachable at the given URLs
+	if sigstoreKeysOutput {
+		keys := SigstoreKeys{}
+		namePrefix := "sigstore-keys"
+		if *discoverInCluster {
+			kube, err := newInClusterKubeClient()
+			if err != nil {
+				log.Fatalf("Error: could not create Kubernetes client: %v", err)
+			}
+			keys, err = DiscoverInCluster(kube)
+			if err != nil {
+				log.Fatalf("Error: could not discover Sigstore in cluster: %v", err)
+			}
+			namePrefix = "sigstore-scaffold"
+		}
+		if *fulcioURL != "" {
+			cas, err := FetchFulcioTrustBundle(*fulcioURL)
+			if err != nil {
+				log.Fatalf("Error: could not get the Fulcio trust bundle of %s: %v", *fulcioURL, err)
+			}
+			keys.CertificateAuthorities = cas
+		}
+		if *rekorURL != "" {
+			tlog, err := FetchRekorLog(*rekorURL)
+			if err != nil {
+				log.Fatalf("Error: could not get the Rekor log of %s: %v", *rekorURL, err)
+			}
+			keys.TLogs = []TransparencyLogInstance{tlog}
+		}
+		if *ctlogURL != "" {
+			ctlog, err := FetchCTLog(*ctlogURL, *ctlogPublicKey)
+			if err != nil {
+				log.Fatalf("Error: could not configure the CT log of %s: %v", *ctlogURL, err)
+			}
+			keys.CTLogs = []TransparencyLogInstance{ctlog}
+		}
+		if *tsaURL != "" {
+			tsa, err := FetchTimestampAuthority(*tsaURL)
+			if err != nil {
+				log.Fatalf("Error: could not get the TSA certificate chain of %s: %v", *tsaURL, err)
+			}
+			if *tsaURI != "" {
+				tsa.URI = *tsaURI
+			}
+			keys.TimestampAuthorities = []CertificateAuthority{tsa}
+		}
+		if *tsaCertChain != "" {
+			uri := *tsaURI
+			if uri == "" && len(keys.TimestampAuthorities) > 0 {
+				uri = keys.TimestampAuthorities[0].URI
+			}
+			if uri == "" {
+				log.Fatalf("Error: --tsa-uri is required when no TSA is discovered in the cluster")
+			}
+			chain, err := os.ReadFile(*tsaCertChain)
+			if err != nil {
+				log.Fatalf("Error: could not read TSA certificate chain: %v", err)
+			}
+			tsa, err := NewCertificateAuthority(uri, chain)
+			if err != nil {
+				log.Fatalf("Error: could not parse TSA certificate chain %s: %v", *tsaCertChain, err)
+			}
+			keys.TimestampAuthorities = []CertificateAuthority{tsa}
+		}
+		if *rekorV2URL != "" {
+			tlog, err := NewRekorV2Log(*rekorV2URL, *rekorV2PublicKey)
+			if err != nil {
+				log.Fatalf("Error: could not configure Rekor v2 log: %v", err)
+			}
+			keys.TLogs = append(keys.TLogs, tlog)
+		}
+		name := fmt.Sprintf("%s-%d", namePrefix, now().Unix())
+		trustRootYAML := RenderSigstoreKeysTrustRoot(name, keys)
+		fmt.Println(trustRootYAML)
+		if *resultFile != "" {
+			writeResult(*resultFile, RunResult{TrustRoot: name, Output: outputTrustRoot}, strings.NewReader(trustRootYAML), nil)
+		}
+		pushRunMetrics("", "", strings.NewReader(trustRootYAML))
+		return
+	}
+
+	var history *History
+	if *historyDir != "" {
+		history = &History{Dir: *historyDir, Keep: *historyKeep}
+	}
 
 	// Create a temporary repository directory to store tuf resources
-	temporaryWorkingDirectory, err := os.MkdirTemp("", "tuf-repository-*")
+	temporaryWorkingDirectory, err := mkdirTemp("tuf-repository-*")
 	if err != nil {
 		log.Fatalf("Error: could not create temporary directory: %v", err)
 	}
 	defer os.RemoveAll(temporaryWorkingDirectory)
+	onRunTimeout(removeOnFailure(temporaryWorkingDirectory))
 
-	// Get the latest root.json file name from the mirror
-	latestRootName, _ := GetLatestMetadataName(*mirror, "root.json")
-	if latestRootName == "" {
-		log.Fatalf("Error: could not get the latest root.json file from the mirror")
-		os.Exit(1)
-	}
-
-	// Construct the URL for the root.json file
-	rootURL := fmt.Sprintf("%s/%s", *mirror, latestRootName)
-	log.Printf("mirror %s, root %s\n", *mirror, rootURL)
-	rootJSONFile := &os.File{}
-
-	// List of metadata files to download
-	madatadas := []string{"root.json", "snapshot.json", "targets.json", "timestamp.json"}
-	for _, metadata := range madatadas {
-		metadataName := ""
-		if metadata == "timestamp.json" {
-			metadataName = "timestamp.json"
-		} else {
-			metadataName, _ = GetLatestMetadataName(*mirror, metadata)
+	// Verify the metadata chain only, for a TrustRoot fetching targets live
+	if *metadataOnly {
+		rootJSONFile, err := AssembleMetadata(*mirror, temporaryWorkingDirectory)
+		if err != nil {
+			fatalf(err, "Error: could not verify the metadata of %s: %v", *mirror, err)
+		}
+		defer rootJSONFile.Close()
+		checkKnownRoot(*mirror, rootJSONFile.Name(), *allowUnknownRoot)
+		if *crossCheck != "" {
+			crossCheckRoot(*crossCheck, rootJSONFile.Name())
 		}
-		metadataURL := fmt.Sprintf("%s/%s", *mirror, metadataName)
-		metadataFilepath := filepath.Join(temporaryWorkingDirectory, metadataName)
-		metadataFile, err := os.Create(metadataFilepath)
+		if *showRootKeys {
+			printRootKeys(rootJSONFile.Name())
+		}
+		snapshotJSON := readLatestMetadata(temporaryWorkingDirectory, "snapshot.json")
+		checkExpiryGrace(temporaryWorkingDirectory, snapshotJSON, *expiryGrace)
+		rotations := checkRootRotation("", history, *mirror, rootJSONFile.Name())
+		rootJSON, err := io.ReadAll(rootJSONFile)
 		if err != nil {
-			log.Fatalf("Error: could not create file %s: %v", metadataFile.Name(), err)
+			log.Fatalf("Error: could not read root.json: %v", err)
 		}
-		err = DownloadFile(metadataFile, metadataURL)
+		name := trustRootName(*nameStrategy, sourceName(*mirror), temporaryWorkingDirectory, snapshotJSON)
+		trustRootYAML := RenderRemoteTrustRoot(name, NormalizeMirrorURL(*mirror), rootJSON)
+		if *dryRun == dryRunServer {
+			if err := serverDryRun(outputTrustRoot, name, []byte(trustRootYAML)); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			log.Printf("server-side dry-run accepted %s %s\n", outputTrustRoot, name)
+		}
+		fmt.Println(trustRootYAML)
+		writeRootOut(*rootOut, rootJSONFile.Name())
+		if *resultFile != "" {
+			writeResult(*resultFile, RunResult{TrustRoot: name, Output: outputTrustRoot}, strings.NewReader(trustRootYAML), map[string]string{resultFileRoot: *rootOut})
+		}
+		output := newSpillBuffer(0)
+		output.Write([]byte(trustRootYAML))
+		recordAudit(*auditLog, *mirror, outputTrustRoot, name, temporaryWorkingDirectory, rootJSONFile, output)
+		recordHistory(history, name, *mirror, temporaryWorkingDirectory, output)
+		pushRunMetrics(temporaryWorkingDirectory, "", strings.NewReader(trustRootYAML))
+		exitOnRootRotation(rotations, temporaryWorkingDirectory)
+		return
+	}
+
+	// Assemble a TAP-4 multi-repository setup described by a map file
+	if *repositoryMap != "" {
+		repositories, err := AssembleMultiRepository(*repositoryMap, *mapRoots, temporaryWorkingDirectory)
 		if err != nil {
-			log.Fatalf("Error: could not download %s from %s", metadataFile.Name(), metadataURL)
+			fatalf(err, "Error: could not assemble multi-repository %s: %v", *repositoryMap, err)
 		}
-		if metadata == "root.json" {
-			rootJSONFile = metadataFile
+		// Results are reported for the first repository of the first mapping
+		primary := repositories[0]
+		rootJSONFile, err := os.Open(primary.RootPath)
+		if err != nil {
+			log.Fatalf("Error: could not read root.json: %v", err)
+		}
+		defer rootJSONFile.Close()
+		var name string
+		trustRootYAML := newSpillBuffer(spillThreshold)
+		for i, repository := range repositories {
+			if *showRootKeys {
+				printRootKeys(repository.RootPath)
+			}
+			if *listArchive {
+				printArchiveListing(repository.Dir)
+			}
+			// Every repository is a TrustRoot of its own
+			checkArchive(repository.Dir, repository.RootPath)
+			snapshotJSON := readLatestMetadata(repository.Dir, "snapshot.json")
+			repositoryName := trustRootName(*nameStrategy, sourceName(repository.Name), repository.Dir, snapshotJSON)
+			if i == 0 {
+				name = repositoryName
+			} else {
+				fmt.Println("---")
+				trustRootYAML.Write([]byte("\n---\n"))
+			}
+			repositoryRoot, err := os.Open(repository.RootPath)
 			if err != nil {
-				log.Fatalf("Error: could not base64-encode root.json: %v", err)
+				log.Fatalf("Error: could not read root.json: %v", err)
 			}
+			document := emitRepositoryTrustRoot(*output, repositoryName, repository.Dir, repositoryRoot, *dryRun == dryRunServer)
+			repositoryRoot.Close()
+			if _, err := document.WriteTo(trustRootYAML); err != nil {
+				log.Fatalf("Error: could not buffer output: %v", err)
+			}
+			document.Close()
+		}
+		writeRootOut(*rootOut, primary.RootPath)
+		runPlugins(plugins, *repositoryMap, *output, name, primary.Dir, rootJSONFile, trustRootYAML.String())
+		sendGenerationEvents(cloudEventSinks, *repositoryMap, *output, name, primary.Dir, rootJSONFile)
+		writeResultFile(*resultFile, *repositoryMap, *output, name, primary.Dir, rootJSONFile, trustRootYAML, map[string]string{resultFileRoot: *rootOut}, nil)
+		recordAudit(*auditLog, *repositoryMap, *output, name, primary.Dir, rootJSONFile, trustRootYAML)
+		recordHistory(history, name, *repositoryMap, primary.Dir, trustRootYAML)
+		pushRunMetrics(primary.Dir, temporaryWorkingDirectory, trustRootYAML)
+		return
+	}
+
+	// Every file of the repository is downloaded and verified by a go-tuf client
+	rootJSONFile, err := AssembleRepository(*mirror, temporaryWorkingDirectory)
+	if err != nil {
+		fatalf(err, "Error: could not assemble %s: %v", *mirror, err)
+	}
+	defer rootJSONFile.Close()
+	checkKnownRoot(*mirror, rootJSONFile.Name(), *allowUnknownRoot)
+	if *crossCheck != "" {
+		crossCheckRoot(*crossCheck, rootJSONFile.Name())
+	}
+	if *rootHistory {
+		embedRootHistory(*mirror, temporaryWorkingDirectory, rootJSONFile.Name())
+	}
+	if *showRootKeys {
+		printRootKeys(rootJSONFile.Name())
+	}
+	if *strict {
+		if err := CheckStrictRepository(temporaryWorkingDirectory); err != nil {
+			fatalf(err, "Error: --strict: %v", err)
+		}
+	}
+	destinationTargetsDir := filepath.Join(temporaryWorkingDirectory, "targets")
+	targetsJSON := readLatestMetadata(temporaryWorkingDirectory, "targets.json")
+	snapshotJSON := readLatestMetadata(temporaryWorkingDirectory, "snapshot.json")
+
+	checkExpiryGrace(temporaryWorkingDirectory, snapshotJSON, *expiryGrace)
+	rotations := checkRootRotation(*previous, history, *mirror, rootJSONFile.Name())
+
+	// Make sure the TSA certificate chains of the repository can verify timestamps
+	if err := CheckTimestampAuthorities(destinationTargetsDir, targetsJSON, now()); err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+
+	// Write the ClientTrustConfig for signing-side tooling
+	if *clientTrustConfigOut != "" {
+		clientTrustConfigJSON, err := BuildClientTrustConfig(destinationTargetsDir)
+		if err != nil {
+			log.Fatalf("Error: could not build ClientTrustConfig: %v", err)
 		}
+		if err := os.WriteFile(*clientTrustConfigOut, clientTrustConfigJSON, 0o644); err != nil {
+			log.Fatalf("Error: could not write ClientTrustConfig to %s: %v", *clientTrustConfigOut, err)
+		}
+		log.Printf("ClientTrustConfig written to %s\n", *clientTrustConfigOut)
+	}
+
+	// Catch layout regressions before they reach an admission webhook
+	if *output != outputTrustedRoot && *output != outputDigest {
+		checkArchive(temporaryWorkingDirectory, rootJSONFile.Name())
 	}
 
-	// Delete the local TUF repository
-	if err := cleanupLocalTUFRepository(); err != nil {
-		log.Fatalf("Error: could not cleanup local TUF repository: %v", err)
+	var delta *RepositoryDelta
+	if *previous != "" {
+		delta = reportDelta(*previous, temporaryWorkingDirectory)
 	}
 
-	// Initialize the local TUF repository
-	ctx := context.Background()
-	rootJSON, _ := os.ReadFile(rootJSONFile.Name())
-	if err := tuf.Initialize(ctx, *mirror, rootJSON); err != nil {
-		log.Fatalf("Error: could not initialize TUF: %v", err)
+	if *listArchive {
+		printArchiveListing(temporaryWorkingDirectory)
 	}
 
-	// Get and print the root status
-	rootStatus, err := tuf.GetRootStatus(ctx)
+	name := trustRootName(*nameStrategy, sourceName(*mirror), temporaryWorkingDirectory, snapshotJSON)
+	trustRootYAML := emitRepositoryTrustRoot(*output, name, temporaryWorkingDirectory, rootJSONFile, *dryRun == dryRunServer)
+	writeRootOut(*rootOut, rootJSONFile.Name())
+	storeSecret(*storeSecretName, temporaryWorkingDirectory, rootJSONFile)
+	storeVault(*vaultPath, *vaultMount, vaultCredentials, trustRootYAML.String(), temporaryWorkingDirectory, rootJSONFile)
+	storeAWS(*awsSecretID, *awsSSMPath, *output, name, trustRootYAML.String(), temporaryWorkingDirectory, rootJSONFile)
+	storeGCPSecret(*gcpSecret, trustRootYAML.String(), temporaryWorkingDirectory, rootJSONFile)
+	runPlugins(plugins, *mirror, *output, name, temporaryWorkingDirectory, rootJSONFile, trustRootYAML.String())
+	sendGenerationEvents(cloudEventSinks, *mirror, *output, name, temporaryWorkingDirectory, rootJSONFile)
+	writeResultFile(*resultFile, *mirror, *output, name, temporaryWorkingDirectory, rootJSONFile, trustRootYAML, map[string]string{resultFileRoot: *rootOut, resultFileClientTrustConfig: *clientTrustConfigOut}, delta)
+	recordAudit(*auditLog, *mirror, *output, name, temporaryWorkingDirectory, rootJSONFile, trustRootYAML)
+	recordHistory(history, name, *mirror, temporaryWorkingDirectory, trustRootYAML)
+	pushRunMetrics(temporaryWorkingDirectory, archivedDir(*output, temporaryWorkingDirectory), trustRootYAML)
+	exitOnRootRotation(rotations, temporaryWorkingDirectory)
+}
+
+// archivedDir returns workDir if the output format embeds its repository
+// archive, "" otherwise.
+func archivedDir(output, workDir string) string {
+	if output == outputTrustedRoot || output == outputDigest {
+		return ""
+	}
+	return workDir
+}
+
+// expiryWarningPeriod is the remaining validity of timestamp.json and
+// snapshot.json below which a warning is logged.
+const expiryWarningPeriod = 24 * time.Hour
+
+// checkExpiryGrace refuses to ship a TrustRoot going stale before the next
+// refresh: it exits if the timestamp.json in workDir or snapshotJSON expire
+// within grace, unless grace is 0, and warns if they expire within
+// expiryWarningPeriod.
+func checkExpiryGrace(workDir string, snapshotJSON []byte, grace time.Duration) {
+	expiryMetadata := map[string][]byte{
+		"timestamp.json": readLatestMetadata(workDir, "timestamp.json"),
+		"snapshot.json":  snapshotJSON,
+	}
+	if grace > 0 {
+		if err := CheckExpiry(expiryMetadata, grace, time.Now()); err != nil {
+			fatalf(err, "Error: %v", err)
+		}
+	}
+	expiring, err := expiringMetadata(expiryMetadata, expiryWarningPeriod, time.Now())
 	if err != nil {
-		log.Fatalf("Error: could not get root status: %v", err)
+		log.Fatalf("Error: %v", err)
+	}
+	if len(expiring) > 0 {
+		warnf(assembler.ErrMetadataExpired, "metadata expires within %s: %s", expiryWarningPeriod, strings.Join(expiring, ", "))
+	}
+}
+
+// writeRootOut copies the verified root.json at rootPath to the --root-out
+// path, if set, exiting on errors.
+func writeRootOut(path, rootPath string) {
+	if path == "" {
+		return
+	}
+	if err := copyFile(rootPath, path); err != nil {
+		log.Fatalf("Error: could not write root.json to %s: %v", path, err)
 	}
-	rootStatusJSON, err := json.MarshalIndent(rootStatus, "", "  ")
+	log.Printf("root.json written to %s\n", path)
+}
+
+// printRootKeys writes the WriteRootKeyReport of the verified root.json at
+// rootPath to stderr, exiting on errors.
+func printRootKeys(rootPath string) {
+	rootJSON, err := os.ReadFile(rootPath)
 	if err != nil {
-		log.Fatalf("Error: could not marshal root status to JSON: %v", err)
+		log.Fatalf("Error: could not read root.json: %v", err)
 	}
-	log.Default().Printf("Root status: %s\n", rootStatusJSON)
+	if err := WriteRootKeyReport(os.Stderr, rootJSON); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
 
-	// Move the targets directory to the temporary working directory
-	originalTargetsDir := filepath.Join(os.Getenv("HOME"), ".sigstore", "root", "targets")
-	destinationTargetsDir := filepath.Join(temporaryWorkingDirectory, "targets")
-	err = os.Rename(originalTargetsDir, destinationTargetsDir)
+// printArchiveListing writes the WriteArchiveListing of the archive of the
+// repository assembled in workDir to stderr, exiting on errors.
+func printArchiveListing(workDir string) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compressRepository(workDir, pw))
+	}()
+	err := WriteArchiveListing(os.Stderr, pr)
+	// Drain the archive so the compressing goroutine ends
+	io.Copy(io.Discard, pr)
 	if err != nil {
-		log.Fatalf("Failed to move directory: %v", err)
+		log.Fatalf("Error: %v", err)
 	}
+}
 
-	// Compress the repository directory into a tar.gz file
-	repositoryArchive, err := os.CreateTemp("", "repository-*.tar.gz")
+// embedRootHistory writes every root version older than the root at
+// rootPath into the repository in workDir, with the chain verified, exiting
+// on errors.
+func embedRootHistory(mirror, workDir, rootPath string) {
+	latestRoot, err := os.ReadFile(rootPath)
 	if err != nil {
-		log.Fatalf("Error: could not create temporary file for repository archive: %v", err)
+		log.Fatalf("Error: could not read root.json: %v", err)
 	}
-	defer repositoryArchive.Close()
-	err = CompressDirectory(temporaryWorkingDirectory, repositoryArchive.Name())
+	fetcher, err := NewFetcher(mirror)
 	if err != nil {
-		log.Fatalf("Error: could not compress repository directory: %v", err)
+		log.Fatalf("Error: %v", err)
 	}
+	count, err := WriteRootHistory(fetcher, workDir, latestRoot)
+	if err != nil {
+		fatalf(err, "Error: could not embed the root history of %s: %v", mirror, err)
+	}
+	log.Printf("embedded %d root versions\n", count)
+}
 
-	// Base64 encode the repository archive file
-	b64RepositoryArchive, err := EncodeBase64(repositoryArchive)
+// readLatestMetadata reads the latest version of the metadata role in dir,
+// exiting on errors.
+func readLatestMetadata(dir, role string) []byte {
+	path, err := latestMetadataPath(dir, role)
 	if err != nil {
-		log.Fatalf("Error: could not base64-encode repository archive: %v", err)
+		log.Fatalf("Error: could not find %s: %v", role, err)
 	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Error: could not read %s: %v", role, err)
+	}
+	return content
+}
+
+// sourceName returns the repository source without its scheme, as a valid
+// Kubernetes name prefix for the TrustRoot.
+func sourceName(source string) string {
+	name := filepath.Base(source)
+	if _, rest, ok := strings.Cut(source, "://"); ok {
+		name = rest
+	}
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	return strings.Trim(name, "-.")
+}
 
-	// Base64 encode the root.json file
-	b64RootJSON, err := EncodeBase64(rootJSONFile)
+// trustRootName returns the TrustRoot name of the repository in workDir with
+// TrustRootName, exiting on errors.
+func trustRootName(strategy, prefix, workDir string, snapshotJSON []byte) string {
+	snapshotVersion, err := assembler.MetadataVersion(snapshotJSON)
+	if err != nil {
+		log.Fatalf("Error: could not read version of snapshot.json: %v", err)
+	}
+	name, err := TrustRootName(strategy, prefix, workDir, snapshotVersion)
 	if err != nil {
-		log.Fatalf("Error: could not base64-encode root.json: %v", err)
-	}
-
-	// Print the TrustRoot Custom Resource YAML to stdout
-	trustRootYAML := fmt.Sprintf(`apiVersion: policy.sigstore.dev/v1alpha1
-kind: TrustRoot
-metadata:
-  name: %s-%d
-spec:
-  repository:
-    root: |-
-      %s
-    mirrorFS: |-
-      %s
-`, strings.ReplaceAll(*mirror, "https://", ""), time.Now().Unix(), b64RootJSON, b64RepositoryArchive)
-	fmt.Println(trustRootYAML)
+		log.Fatalf("Error: could not name TrustRoot: %v", err)
+	}
+	return name
+}
+
+// emitRepositoryTrustRoot renders the repository assembled in workDir in the
+// --output format, by default the `repository` TrustRoot Custom Resource YAML
+// named name, prints it to stdout and returns it. The output is buffered until
+// complete, in a temporary file when it exceeds the --memory-limit share of
+// rendered outputs; the caller closes the buffer. With dryRun, it is only
+// printed once a server-side dry-run apply accepted it.
+func emitRepositoryTrustRoot(format, name, workDir string, rootJSONFile *os.File, dryRun bool) *spillBuffer {
+	output := newSpillBuffer(spillThreshold)
+	if err := renderRepositoryTo(output, format, name, workDir, rootJSONFile, nil); err != nil {
+		output.Close()
+		fatalf(err, "Error: %v", err)
+	}
+	if output.Spilled() {
+		log.Printf("spilled %d bytes of output to disk\n", output.Len())
+	}
+	if dryRun {
+		if err := serverDryRun(format, name, []byte(output.String())); err != nil {
+			output.Close()
+			log.Fatalf("Error: %v", err)
+		}
+		log.Printf("server-side dry-run accepted %s %s\n", format, name)
+	}
+	if _, err := output.WriteTo(os.Stdout); err != nil {
+		output.Close()
+		log.Fatalf("Error: could not write output: %v", err)
+	}
+	fmt.Println()
+	return output
 }
 
-// cleanupLocalTUFRepository removes the local TUF (The Update Framework) repository
-func cleanupLocalTUFRepository() error {
-	tufDir := filepath.Join(os.Getenv("HOME"), ".sigstore")
-	if err := os.RemoveAll(tufDir); err != nil {
-		return fmt.Errorf("error: could not remove TUF directory %s: %v", tufDir, err)
+// dryRunServer is the --dry-run mode submitting the output to the API server.
+const dryRunServer = "server"
+
+// serverDryRun submits manifest, rendered in the --output format and named
+// name, to the Kubernetes API with dryRun=All.
+func serverDryRun(format, name string, manifest []byte) error {
+	kube, err := newKubeClient()
+	if err != nil {
+		return fmt.Errorf("could not create Kubernetes client for --dry-run=%s: %v", dryRunServer, err)
+	}
+	if err := kube.dryRunApply(format, name, manifest); err != nil {
+		return fmt.Errorf("server-side dry-run rejected %s %s: %v", format, name, err)
 	}
 	return nil
 }
 
+// recordHistory records an emitted TrustRoot in the history directory, if one
+// is configured, exiting on errors.
+func recordHistory(history *History, name, mirror, versionsDir string, trustRootYAML *spillBuffer) {
+	defer trustRootYAML.Close()
+	if history == nil {
+		return
+	}
+	entry, err := history.Record(name, mirror, versionsDir, trustRootYAML.String())
+	if err != nil {
+		log.Fatalf("Error: could not record TrustRoot in history %s: %v", history.Dir, err)
+	}
+	log.Printf("recorded TrustRoot %s as generation %d in %s\n", name, entry.Generation, history.Dir)
+}
+
+// RenderRepositoryTrustRoot compresses the repository assembled in workDir and
+// renders the `repository` TrustRoot Custom Resource YAML embedding it.
+//
+// Parameters:
+//   - name: The name of the TrustRoot.
+//   - workDir: The directory of the assembled repository.
+//   - rootJSONFile: The root.json embedded as the trusted root of the repository.
+//
+// Returns:
+//   - The TrustRoot YAML.
+//   - An error if the repository could not be compressed or encoded.
+func RenderRepositoryTrustRoot(name, workDir string, rootJSONFile *os.File) (string, error) {
+	output, err := renderRepository(outputTrustRoot, name, workDir, rootJSONFile)
+	return string(output), err
+}
+
+// renderRepository renders the repository assembled in workDir with the
+// Renderer registered as format.
+func renderRepository(format, name, workDir string, rootJSONFile *os.File) ([]byte, error) {
+	var output bytes.Buffer
+	if err := renderRepositoryTo(&output, format, name, workDir, rootJSONFile, nil); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// renderRepositoryTo renders the repository assembled in workDir with the
+// Renderer registered as format to w, labelled with labels, streaming it if
+// the Renderer is a StreamRenderer.
+func renderRepositoryTo(w io.Writer, format, name, workDir string, rootJSONFile *os.File, labels map[string]string) error {
+	renderer, err := LookupRenderer(format)
+	if err != nil {
+		return err
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		return fmt.Errorf("could not read root.json: %v", err)
+	}
+	material := TrustMaterial{Name: name, Dir: workDir, RootJSON: rootJSON, Labels: labels}
+	if streamer, ok := renderer.(StreamRenderer); ok {
+		return streamer.RenderTo(w, material)
+	}
+	output, err := renderer.Render(material)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(output)
+	return err
+}
+
 // DownloadFile downloads file from the provided URL and saves it to the given file.
 // Parameters:
 //   - destinationFile: target file where downloaded content will be written
@@ -170,16 +765,31 @@ func cleanupLocalTUFRepository() error {
 //
 // Returns:
 //   - error: nil if successful, otherwise error describing what went wrong
+//
+// Deprecated: Use Download, which writes to any io.Writer.
 func DownloadFile(destinationFile *os.File, url string) error {
-	resp, err := http.Get(url)
+	return Download(destinationFile, url)
+}
+
+// Download downloads the content of the provided URL and writes it to w, which
+// can be a file, a buffer, a pipe or a network stream.
+//
+// Parameters:
+//   - w: The writer the downloaded content is written to.
+//   - url: The source URL to download from.
+//
+// Returns:
+//   - An error if the request failed, the response is not 200 OK or w could not be written.
+func Download(w io.Writer, url string) error {
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download file: %s", resp.Status)
+		return fmt.Errorf("failed to download file: %w", newStatusError(resp))
 	}
-	defer resp.Body.Close()
-	_, err = io.Copy(destinationFile, resp.Body)
+	_, err = io.Copy(w, resp.Body)
 	return err
 }
 
@@ -195,9 +805,11 @@ func DownloadFile(destinationFile *os.File, url string) error {
 //
 // The function performs the following steps:
 //  1. Creates the output file at the destination path.
-//  2. Creates a gzip writer and a tar writer.
-//  3. Walks through the source directory, adding files and directories to the tar archive.
-//  4. Writes file contents to the tar archive for regular files.
+//  2. Archives the source directory with assembler.CompressFS, which adds
+//     files and directories to a gzip-compressed tar archive. Symbolic links
+//     to files are archived as the files they point to, other links fail.
+//
+// Rendering does not go through an archive file, see writeEncodedRepositoryArchive.
 //
 // Example usage:
 //
@@ -212,50 +824,11 @@ func CompressDirectory(src, dst string) error {
 		return err
 	}
 	defer out.Close()
-	// Create gzip writer
-	gw := gzip.NewWriter(out)
-	defer gw.Close()
-	// Create tar writer
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-	// Walk through the source directory
-	err = filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		// Get the relative path
-		relPath, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		// Skip the root directory itself
-		if relPath == "." {
-			return nil
-		}
-		// Create tar header
-		header, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-		header.Name = relPath
-		// Write header
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-		// If it's a regular file, write its contents
-		if info.Mode().IsRegular() {
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			if _, err := io.Copy(tw, file); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	return err
+	// Archive the source directory, reproducibly with --deterministic
+	if err := compressRepository(src, out); err != nil {
+		return err
+	}
+	return out.Close()
 }
 
 // encodeBase64 reads the content of the provided file and encodes it in base64 format.
@@ -266,12 +839,37 @@ func CompressDirectory(src, dst string) error {
 // Returns:
 //   - A base64 encoded string representation of the file's content.
 //   - An error if there is an issue reading the file.
+//
+// Deprecated: Use EncodeBase64Reader, which reads from any io.Reader. Unlike
+// it, EncodeBase64 re-opens sourceFile by name and encodes the whole file.
 func EncodeBase64(sourceFile *os.File) (string, error) {
-	data, err := os.ReadFile(sourceFile.Name())
+	file, err := os.Open(sourceFile.Name())
 	if err != nil {
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(data), nil
+	defer file.Close()
+	return EncodeBase64Reader(file)
+}
+
+// EncodeBase64Reader reads r until EOF and returns its content encoded in
+// standard base64.
+//
+// Parameters:
+//   - r: The reader of the content to encode.
+//
+// Returns:
+//   - The base64 encoded content.
+//   - An error if r could not be read.
+func EncodeBase64Reader(r io.Reader) (string, error) {
+	var encoded strings.Builder
+	encoder := base64.NewEncoder(base64.StdEncoding, &encoded)
+	if _, err := io.Copy(encoder, r); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return encoded.String(), nil
 }
 
 // GetLatestMetadataName fetches the directory listing from the specified mirror URL,
@@ -285,26 +883,62 @@ func EncodeBase64(sourceFile *os.File) (string, error) {
 //   - The name of the latest metadata file matching the pattern.
 //   - An error if the directory listing could not be fetched or no matching files were found.
 func GetLatestMetadataName(mirror string, metadataPattern string) (string, error) {
-	resp, err := http.Get(mirror)
+	name, _, err := latestListedMetadata(mirror, metadataPattern)
+	return name, err
+}
+
+// latestListedMetadata is GetLatestMetadataName, also returning the URL of
+// the latest metadata file. The listing is requested at the mirrorBase of
+// mirror, so mirrors under a path prefix list their directory rather than
+// redirecting to it, and the file is resolved against the listing URL
+// reached after redirects.
+func latestListedMetadata(mirror, metadataPattern string) (string, string, error) {
+	u, err := url.Parse(mirror)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	resp, err := httpClient.Get(mirrorBase(u).String())
+	if err != nil {
+		return "", "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch mirror directory: %s", resp.Status)
+		return "", "", fmt.Errorf("failed to fetch mirror directory: %w", newStatusError(resp))
 	}
-	// Assuming the mirror returns a plain text listing of files
-	var files []string
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	name, err := ParseMetadataListing(body, metadataPattern)
+	if err != nil {
+		return "", "", err
 	}
+	return name, mirrorFileURL(mirrorBase(resp.Request.URL), name), nil
+}
+
+// ParseMetadataListing returns the name of the latest N.<metadataPattern>
+// file found in the directory listing of a mirror. The listing is untrusted
+// remote content, in any format naming one file per line.
+//
+// Parameters:
+//   - listing: The body of the directory listing.
+//   - metadataPattern: The metadata file name, e.g. root.json.
+//
+// Returns:
+//   - The name of the latest metadata file matching the pattern.
+//   - An error if no matching file was found.
+func ParseMetadataListing(listing []byte, metadataPattern string) (string, error) {
+	// Assuming the mirror returns a plain text listing of files
+	var files []string
 	// Parse the response body to extract file names
 	// This is a simplified example; adjust parsing as needed
-	re := regexp.MustCompile(fmt.Sprintf(`(\d+\.%s)`, regexp.QuoteMeta(metadataPattern)))
-	for _, line := range strings.Split(string(body), "\n") {
+	re, err := regexp.Compile(fmt.Sprintf(`(\d+\.%s)`, regexp.QuoteMeta(metadataPattern)))
+	if err != nil {
+		return "", fmt.Errorf("invalid metadata pattern %q: %v", metadataPattern, err)
+	}
+	for _, line := range strings.Split(string(listing), "\n") {
 		if matches := re.FindStringSubmatch(line); len(matches) > 0 {
 			files = append(files, matches[1])
 		}
@@ -313,9 +947,23 @@ func GetLatestMetadataName(mirror string, metadataPattern string) (string, error
 	if len(files) == 0 {
 		return "", fmt.Errorf("no metadata files matching pattern %s found in mirror directory", metadataPattern)
 	}
-	// Sort files to get the latest one
-	sort.Strings(files)
+	// Sort files by version to get the latest one, 13.root.json after 9.root.json
+	sort.SliceStable(files, func(i, j int) bool {
+		return metadataVersionLess(files[i], files[j])
+	})
 	latestMetadataName := files[len(files)-1]
 	// log.Default().Printf("Latest root.json file: %s\n", latestMetadataName)
 	return latestMetadataName, nil
 }
+
+// metadataVersionLess reports whether the version of the metadata name a,
+// e.g. 9 in 9.root.json, is lower than that of b. Versions are compared as
+// decimal numbers of any length, ignoring leading zeros.
+func metadataVersionLess(a, b string) bool {
+	versionA := strings.TrimLeft(a[:strings.IndexByte(a, '.')], "0")
+	versionB := strings.TrimLeft(b[:strings.IndexByte(b, '.')], "0")
+	if len(versionA) != len(versionB) {
+		return len(versionA) < len(versionB)
+	}
+	return versionA < versionB
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
 func TestDownloadFile(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(newTestRepository(t))))
+	defer server.Close()
+
 	tests := []struct {
 		name    string
 		url     string
@@ -13,12 +21,12 @@ func TestDownloadFile(t *testing.T) {
 	}{
 		{
 			name:    "valid URL",
-			url:     "https://tuf-repo-cdn.sigstore.dev/timestamp.json",
+			url:     server.URL + "/timestamp.json",
 			wantErr: false,
 		},
 		{
 			name:    "invalid URL",
-			url:     "https://invalid-domain-that-does-not-exist.example/file.json",
+			url:     "http://127.0.0.1:0/file.json",
 			wantErr: true,
 		},
 	}
@@ -55,6 +63,67 @@ func TestDownloadFile(t *testing.T) {
 	}
 }
 
+func TestDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/timestamp.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"signed":{}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{"found", server.URL + "/timestamp.json", `{"signed":{}}`, false},
+		{"not found", server.URL + "/missing.json", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Download(&buf, tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Download() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Download() wrote %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSetHTTPClient(t *testing.T) {
+	defaultClient := httpClient
+	defer SetHTTPClient(defaultClient)
+
+	var requested []string
+	SetHTTPClient(&http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requested = append(requested, r.URL.String())
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("1.root.json\n2.root.json\n")), Request: r}, nil
+	})})
+
+	got, err := GetLatestMetadataName("https://tuf.example.com", "root.json")
+	if err != nil {
+		t.Fatalf("GetLatestMetadataName() error = %v", err)
+	}
+	if got != "2.root.json" {
+		t.Errorf("GetLatestMetadataName() = %q, want %q", got, "2.root.json")
+	}
+	if len(requested) != 1 || requested[0] != "https://tuf.example.com/" {
+		t.Errorf("requests = %v, want the listing of https://tuf.example.com", requested)
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
 func TestCompressDirectory(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -83,6 +152,29 @@ func TestCompressDirectory(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "symlink to a file",
+			setup: func() (string, string) {
+				src, _ := os.MkdirTemp("", "src-*")
+				os.WriteFile(src+"/file1.txt", []byte("content1"), os.ModePerm)
+				os.Symlink("file1.txt", src+"/link.txt")
+				dst, _ := os.CreateTemp("", "output-*.tar.gz")
+				dst.Close()
+				return src, dst.Name()
+			},
+			wantErr: false,
+		},
+		{
+			name: "broken symlink",
+			setup: func() (string, string) {
+				src, _ := os.MkdirTemp("", "src-*")
+				os.Symlink("missing.txt", src+"/link.txt")
+				dst, _ := os.CreateTemp("", "output-*.tar.gz")
+				dst.Close()
+				return src, dst.Name()
+			},
+			wantErr: true,
+		},
 		{
 			name: "empty directory",
 			setup: func() (string, string) {
@@ -182,3 +274,69 @@ func TestEncodeBase64(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeBase64Reader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"content", "hello world", "aGVsbG8gd29ybGQ="},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeBase64Reader(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("EncodeBase64Reader() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EncodeBase64Reader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMetadataListing(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{"numeric order", "9.root.json\n13.root.json\n", "root.json", "13.root.json", false},
+		{"reverse listing", "13.root.json\n9.root.json\n", "root.json", "13.root.json", false},
+		{"html listing", "<a href=\"2.snapshot.json\">2.snapshot.json</a>\n<a href=\"10.snapshot.json\">10.snapshot.json</a>\n", "snapshot.json", "10.snapshot.json", false},
+		{"other metadata", "9.root.json\n13.targets.json\n", "root.json", "9.root.json", false},
+		{"no match", "timestamp.json\n", "root.json", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMetadataListing([]byte(tt.listing), tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMetadataListing() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseMetadataListing() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseMetadataListing(f *testing.F) {
+	f.Add([]byte("<a href=\"1.root.json\">1.root.json</a>\n<a href=\"2.root.json\">2.root.json</a>\n"), "root.json")
+	f.Add([]byte("10.snapshot.json\n9.snapshot.json\n"), "snapshot.json")
+	f.Add([]byte(""), "targets.json")
+	f.Fuzz(func(t *testing.T, listing []byte, pattern string) {
+		name, err := ParseMetadataListing(listing, pattern)
+		if err != nil {
+			return
+		}
+		if !strings.HasSuffix(name, "."+pattern) || !strings.Contains(string(listing), name) {
+			t.Errorf("ParseMetadataListing() = %q, not a %s named in the listing", name, pattern)
+		}
+	})
+}
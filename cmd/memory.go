@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// spillThreshold is the size beyond which rendered outputs are spilled to a
+// temporary file instead of being kept in memory, 0 for no limit.
+var spillThreshold int64
+
+// spillFraction is the share of --memory-limit rendered outputs may keep in
+// memory, the rest is left to the TUF clients and the garbage collector.
+const spillFraction = 4
+
+// SetMemoryLimit sets a soft memory ceiling for the process: the garbage
+// collector is tuned to stay below limit and rendered outputs beyond a quarter
+// of limit are spilled to a temporary file.
+//
+// Parameters:
+//   - limit: The memory ceiling in bytes, 0 to remove it.
+func SetMemoryLimit(limit int64) {
+	if limit <= 0 {
+		debug.SetMemoryLimit(-1)
+		spillThreshold = 0
+		return
+	}
+	debug.SetMemoryLimit(limit)
+	spillThreshold = limit / spillFraction
+}
+
+// ParseByteSize parses a size in bytes, with an optional Kubernetes binary
+// suffix: Ki, Mi or Gi.
+//
+// Parameters:
+//   - size: The size, e.g. 1048576 or 48Mi.
+//
+// Returns:
+//   - The size in bytes.
+//   - An error if size is not a non-negative size.
+func ParseByteSize(size string) (int64, error) {
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"Ki": 1 << 10, "Mi": 1 << 20, "Gi": 1 << 30} {
+		if strings.HasSuffix(size, suffix) {
+			size, multiplier = strings.TrimSuffix(size, suffix), m
+			break
+		}
+	}
+	value, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || value < 0 || value > (1<<62)/multiplier {
+		return 0, fmt.Errorf("invalid size %q, want bytes with an optional Ki, Mi or Gi suffix", size)
+	}
+	return value * multiplier, nil
+}
+
+// spillBuffer is an io.Writer keeping what it is written in memory up to a
+// threshold, and in a temporary file beyond it.
+type spillBuffer struct {
+	threshold int64
+	memory    bytes.Buffer
+	file      *os.File
+	size      int64
+}
+
+// newSpillBuffer returns a spillBuffer spilling beyond threshold bytes, never
+// if threshold is 0.
+func newSpillBuffer(threshold int64) *spillBuffer {
+	return &spillBuffer{threshold: threshold}
+}
+
+// Write writes p to memory, or to the temporary file once the buffer exceeds
+// its threshold.
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.threshold > 0 && b.size+int64(len(p)) > b.threshold {
+		file, err := os.CreateTemp(tempDir(), "trustroot-*.spill")
+		if err != nil {
+			return 0, fmt.Errorf("could not spill output to disk: %v", err)
+		}
+		b.file = file
+		keepOnFailure(file.Name())
+		if _, err := file.Write(b.memory.Bytes()); err != nil {
+			return 0, err
+		}
+		b.memory = bytes.Buffer{}
+	}
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.memory.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// Len returns the number of bytes written to the buffer.
+func (b *spillBuffer) Len() int64 {
+	return b.size
+}
+
+// Spilled reports whether the buffer spilled to disk.
+func (b *spillBuffer) Spilled() bool {
+	return b.file != nil
+}
+
+// WriteTo writes the content of the buffer to w.
+func (b *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.file == nil {
+		n, err := w.Write(b.memory.Bytes())
+		return int64(n), err
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, b.file)
+}
+
+// String returns the content of the buffer, reading it back from disk if it
+// spilled.
+func (b *spillBuffer) String() string {
+	var content strings.Builder
+	content.Grow(int(b.size))
+	b.WriteTo(&content)
+	return content.String()
+}
+
+// Close removes the temporary file of a spilled buffer.
+func (b *spillBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	b.file.Close()
+	return os.Remove(b.file.Name())
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"bytes", "1048576", 1 << 20, false},
+		{"kibibytes", "512Ki", 512 << 10, false},
+		{"mebibytes", "48Mi", 48 << 20, false},
+		{"gibibytes", "2Gi", 2 << 30, false},
+		{"zero", "0", 0, false},
+		{"decimal suffix", "48M", 0, true},
+		{"negative", "-1Mi", 0, true},
+		{"overflow", "9999999999Gi", 0, true},
+		{"empty", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseByteSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseByteSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseByteSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpillBuffer(t *testing.T) {
+	tests := []struct {
+		name        string
+		threshold   int64
+		writes      []string
+		wantSpilled bool
+	}{
+		{"no threshold", 0, []string{strings.Repeat("a", 1024), "b"}, false},
+		{"below threshold", 16, []string{"0123456789", "abcdef"}, false},
+		{"beyond threshold", 16, []string{"0123456789", "abcdefg", "hijk"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("TMPDIR", dir)
+			buffer := newSpillBuffer(tt.threshold)
+			for _, write := range tt.writes {
+				if _, err := buffer.Write([]byte(write)); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+			if buffer.Spilled() != tt.wantSpilled {
+				t.Errorf("Spilled() = %v, want %v", buffer.Spilled(), tt.wantSpilled)
+			}
+			want := strings.Join(tt.writes, "")
+			if buffer.Len() != int64(len(want)) {
+				t.Errorf("Len() = %d, want %d", buffer.Len(), len(want))
+			}
+			if got := buffer.String(); got != want {
+				t.Errorf("String() = %q, want %q", got, want)
+			}
+			if err := buffer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+				t.Errorf("Close() left %v", files)
+			}
+		})
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	defer SetMemoryLimit(0)
+	SetMemoryLimit(48 << 20)
+	if spillThreshold != 12<<20 {
+		t.Errorf("spillThreshold = %d, want %d", spillThreshold, 12<<20)
+	}
+	SetMemoryLimit(0)
+	if spillThreshold != 0 {
+		t.Errorf("spillThreshold = %d after removing the limit, want 0", spillThreshold)
+	}
+}
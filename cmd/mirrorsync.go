@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/verify"
+)
+
+// MirrorSync downloads a verified copy of the TUF repository served by mirror
+// into dir, laid out so that dir can be served as a mirror itself:
+//
+//   - every root version, 1.root.json to N.root.json, with the chain verified,
+//   - every N.snapshot.json and N.targets.json version and timestamp.json,
+//   - every target under targets/, named <hash>.<name> for each of its hashes.
+//
+// The repository is verified from the initialRoot, so --root-source and
+// --root-checksum pin the trust anchor instead of trusting the latest root of
+// mirror on first use. The current snapshot and targets versions are verified
+// from timestamp.json; older versions, kept so the mirror serves the files of
+// the upstream, by the keys of their role in one of the mirrored roots.
+//
+// Repositories without consistent snapshots are mirrored under the plain
+// names their clients fetch: snapshot.json, targets.json and targets/<name>.
+//
+// Parameters:
+//   - mirror: The URL of the upstream TUF repository mirror, or any source of NewFetcher.
+//   - dir: The destination directory, created if missing.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func MirrorSync(mirror, dir string) error {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if err := os.MkdirAll(filepath.Join(dir, "targets"), 0o755); err != nil {
+		return err
+	}
+	rootJSON, err := initialRoot(mirror)
+	if err != nil {
+		return err
+	}
+	fetcher, err := NewFetcher(mirror)
+	if err != nil {
+		return err
+	}
+	repository, err := openVerifiedRemote(mirror, remoteStore{fetcher}, rootJSON)
+	if err != nil {
+		return err
+	}
+	rootPath, err := repository.writeMetadata(dir)
+	if err != nil {
+		return err
+	}
+
+	// Mirror and verify the root history
+	latestRoot, err := os.ReadFile(rootPath)
+	if err != nil {
+		return err
+	}
+	count, err := WriteRootHistory(fetcher, dir, latestRoot)
+	if err != nil {
+		return err
+	}
+	log.Printf("mirrored %d root versions\n", count)
+	// The history must go through the pinned root, not another one of its version
+	if rootSource != "" || rootChecksum != "" {
+		version, err := assembler.MetadataVersion(rootJSON)
+		if err != nil {
+			return err
+		}
+		mirrored, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%d.root.json", version)))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(mirrored, rootJSON) {
+			return fmt.Errorf("%w: %d.root.json of %s is not the pinned initial root", assembler.ErrVerification, version, mirror)
+		}
+	}
+
+	consistent, err := assembler.ConsistentSnapshot(latestRoot)
+	if err != nil {
+		return err
+	}
+	if consistent {
+		meta, err := repository.Local.GetMeta()
+		if err != nil {
+			return err
+		}
+		for _, role := range []string{"snapshot", "targets"} {
+			version, err := assembler.MetadataVersion(meta[role+".json"])
+			if err != nil {
+				return err
+			}
+			count, err := WriteMetadataHistory(fetcher, dir, role, version)
+			if err != nil {
+				return err
+			}
+			log.Printf("mirrored %d %s versions\n", count, role)
+		}
+	}
+
+	// Without consistent snapshots, clients fetch targets by their plain names
+	if !consistent {
+		for name := range repository.Targets {
+			if err := repository.DownloadTarget(name, filepath.Join(dir, "targets")); err != nil {
+				return err
+			}
+		}
+		log.Printf("mirrored %d targets from %s to %s\n", len(repository.Targets), mirror, dir)
+		return nil
+	}
+	// Mirror the verified targets under their consistent snapshot names
+	stagingDir, err := os.MkdirTemp(tempDir(), "mirror-sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDir)
+	for name, meta := range repository.Targets {
+		if err := repository.DownloadTarget(name, stagingDir); err != nil {
+			return err
+		}
+		if err := assembler.HashedTargetCopies(filepath.Join(stagingDir, filepath.FromSlash(name)), filepath.Join(dir, "targets"), name, meta); err != nil {
+			return err
+		}
+	}
+	log.Printf("mirrored %d targets from %s to %s\n", len(repository.Targets), mirror, dir)
+	return nil
+}
+
+// WriteRootHistory downloads every root version older than latestRoot,
+// verifies the chain from 1.root.json to latestRoot and writes the older
+// versions into dir as N.root.json, so that clients bootstrapped with any of
+// them can walk the chain themselves.
+//
+// Parameters:
+//   - fetcher: The repository to download the roots from.
+//   - dir: The repository directory, where latestRoot is already written.
+//   - latestRoot: The verified latest root.json.
+//
+// Returns:
+//   - The number of root versions in the chain, latestRoot included.
+//   - An error if a version could not be downloaded or the chain does not verify.
+func WriteRootHistory(fetcher Fetcher, dir string, latestRoot []byte) (int, error) {
+	latestVersion, err := assembler.MetadataVersion(latestRoot)
+	if err != nil {
+		return 0, err
+	}
+	roots := make([][]byte, 0, latestVersion)
+	for version := int64(1); version < latestVersion; version++ {
+		root, err := fetchMetadata(fetcher, fmt.Sprintf("%d.root.json", version))
+		if err != nil {
+			return 0, fmt.Errorf("could not get root version %d: %w: %v", version, assembler.ErrMirrorUnreachable, err)
+		}
+		roots = append(roots, root)
+	}
+	roots = append(roots, latestRoot)
+	if err := VerifyRootChain(roots); err != nil {
+		return 0, fmt.Errorf("%w: %v", assembler.ErrVerification, err)
+	}
+	for i, root := range roots[:len(roots)-1] {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.root.json", i+1)), root, 0o644); err != nil {
+			return 0, err
+		}
+	}
+	return len(roots), nil
+}
+
+// WriteMetadataHistory downloads every version of the snapshot or targets
+// metadata older than latestVersion into dir as N.<role>.json, so the mirror
+// serves every file of the upstream. Older versions no longer match the
+// current timestamp, and have usually expired: each must be of its role and
+// version and signed by a threshold of the keys of its role in one of the
+// N.root.json versions already written into dir. Versions the upstream no
+// longer publishes are skipped.
+//
+// Parameters:
+//   - fetcher: The repository to download the versions from.
+//   - dir: The repository directory, where the verified latest version and the root history are already written.
+//   - role: The role of the metadata, snapshot or targets.
+//   - latestVersion: The verified latest version.
+//
+// Returns:
+//   - The number of versions mirrored, latestVersion included.
+//   - An error if a version could not be downloaded or verified.
+func WriteMetadataHistory(fetcher Fetcher, dir, role string, latestVersion int64) (int, error) {
+	roots, err := filepath.Glob(filepath.Join(dir, "*.root.json"))
+	if err != nil {
+		return 0, err
+	}
+	dbs := make([]*verify.DB, 0, len(roots))
+	for _, root := range roots {
+		rootJSON, err := os.ReadFile(root)
+		if err != nil {
+			return 0, err
+		}
+		db, err := roleKeysDB(rootJSON, role)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", filepath.Base(root), err)
+		}
+		dbs = append(dbs, db)
+	}
+	count := 1
+	for version := int64(1); version < latestVersion; version++ {
+		name := fmt.Sprintf("%d.%s.json", version, role)
+		content, err := fetchMetadata(fetcher, name)
+		if _, ok := err.(client.ErrNotFound); ok {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not get %s: %w: %v", name, assembler.ErrMirrorUnreachable, err)
+		}
+		if err := verifyMetadataVersion(content, role, version, dbs); err != nil {
+			return 0, fmt.Errorf("%w: %s: %v", assembler.ErrVerification, name, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// verifyMetadataVersion checks that metadata is version of role, signed by a
+// threshold of the keys of the role in one of dbs, ignoring its expiry.
+func verifyMetadataVersion(metadata []byte, role string, version int64, dbs []*verify.DB) error {
+	signed := &data.Signed{}
+	if err := json.Unmarshal(metadata, signed); err != nil {
+		return err
+	}
+	var common struct {
+		Type    string `json:"_type"`
+		Version int64  `json:"version"`
+	}
+	if err := json.Unmarshal(signed.Signed, &common); err != nil {
+		return err
+	}
+	if common.Type != role || common.Version != version {
+		return fmt.Errorf("found %s version %d", common.Type, common.Version)
+	}
+	for _, db := range dbs {
+		if db.VerifySignatures(signed, role) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("not signed by the %s keys of any root version", role)
+}
+
+// copyFile copies src to dst, creating the parent directories of dst.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// VerifyRootChain verifies a sequence of root.json versions, oldest first: each
+// root must have the version following its predecessor and be signed by a
+// threshold of the root keys of both its predecessor and itself.
+//
+// Parameters:
+//   - roots: The root.json documents, starting with version 1 or any trusted version.
+//
+// Returns:
+//   - error: nil if the chain is valid, otherwise the first broken link.
+func VerifyRootChain(roots [][]byte) error {
+	for i := 1; i < len(roots); i++ {
+		previous, err := rootKeysDB(roots[i-1])
+		if err != nil {
+			return fmt.Errorf("root %d: %v", i, err)
+		}
+		current, err := rootKeysDB(roots[i])
+		if err != nil {
+			return fmt.Errorf("root %d: %v", i+1, err)
+		}
+		signed := &data.Signed{}
+		if err := json.Unmarshal(roots[i], signed); err != nil {
+			return err
+		}
+		previousVersion, _ := assembler.MetadataVersion(roots[i-1])
+		version, err := assembler.MetadataVersion(roots[i])
+		if err != nil {
+			return err
+		}
+		if version != previousVersion+1 {
+			return fmt.Errorf("root version %d follows version %d", version, previousVersion)
+		}
+		if err := previous.VerifySignatures(signed, "root"); err != nil {
+			return fmt.Errorf("root version %d is not signed by the keys of version %d: %v", version, previousVersion, err)
+		}
+		if err := current.VerifySignatures(signed, "root"); err != nil {
+			return fmt.Errorf("root version %d is not signed by its own keys: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// rootKeysDB returns a verification DB holding the root role of a root.json.
+func rootKeysDB(rootJSON []byte) (*verify.DB, error) {
+	return roleKeysDB(rootJSON, "root")
+}
+
+// roleKeysDB returns a verification DB holding the top-level role of a
+// root.json.
+func roleKeysDB(rootJSON []byte, roleName string) (*verify.DB, error) {
+	signed := &data.Signed{}
+	if err := json.Unmarshal(rootJSON, signed); err != nil {
+		return nil, err
+	}
+	root := &data.Root{}
+	if err := json.Unmarshal(signed.Signed, root); err != nil {
+		return nil, err
+	}
+	role, ok := root.Roles[roleName]
+	if !ok {
+		return nil, fmt.Errorf("no %s role", roleName)
+	}
+	db := verify.NewDB()
+	for _, id := range role.KeyIDs {
+		if key, ok := root.Keys[id]; ok {
+			if err := db.AddKey(id, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := db.AddRole(roleName, role); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+	"github.com/theupdateframework/go-tuf/sign"
+)
+
+// testRoot returns a root.json of version trusting owner, signed by signers.
+func testRoot(t *testing.T, version int64, owner keys.Signer, signers ...keys.Signer) []byte {
+	t.Helper()
+	root := data.NewRoot()
+	root.Version = version
+	root.Expires = time.Now().Add(time.Hour)
+	root.AddKey(owner.PublicData())
+	for _, role := range rootKeyRoles {
+		root.Roles[role] = &data.Role{KeyIDs: owner.PublicData().IDs(), Threshold: 1}
+	}
+	signed, err := sign.Marshal(root, signers...)
+	if err != nil {
+		t.Fatalf("Failed to sign root: %v", err)
+	}
+	rootJSON, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatalf("Failed to marshal root: %v", err)
+	}
+	return rootJSON
+}
+
+func TestVerifyRootChain(t *testing.T) {
+	oldKey, _ := keys.GenerateEd25519Key()
+	newKey, _ := keys.GenerateEd25519Key()
+	tests := []struct {
+		name    string
+		roots   [][]byte
+		wantErr bool
+	}{
+		{
+			name:  "single root",
+			roots: [][]byte{testRoot(t, 1, oldKey, oldKey)},
+		},
+		{
+			name:  "key rotation signed by both keys",
+			roots: [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, newKey, oldKey, newKey), testRoot(t, 3, newKey, newKey)},
+		},
+		{
+			name:    "rotation not signed by the previous keys",
+			roots:   [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, newKey, newKey)},
+			wantErr: true,
+		},
+		{
+			name:    "rotation not signed by its own keys",
+			roots:   [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, newKey, oldKey)},
+			wantErr: true,
+		},
+		{
+			name:    "version gap",
+			roots:   [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 3, oldKey, oldKey)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyRootChain(tt.roots)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRootChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteRootHistory(t *testing.T) {
+	oldKey, _ := keys.GenerateEd25519Key()
+	newKey, _ := keys.GenerateEd25519Key()
+	tests := []struct {
+		name    string
+		roots   [][]byte
+		wantErr error
+	}{
+		{"single root", [][]byte{testRoot(t, 1, oldKey, oldKey)}, nil},
+		{"rotated roots", [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, newKey, oldKey, newKey), testRoot(t, 3, newKey, newKey)}, nil},
+		{"broken chain", [][]byte{testRoot(t, 1, oldKey, oldKey), testRoot(t, 2, newKey, newKey)}, assembler.ErrVerification},
+		{"missing version", [][]byte{nil, testRoot(t, 2, newKey, newKey)}, assembler.ErrMirrorUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := t.TempDir()
+			for i, root := range tt.roots {
+				if root == nil {
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(upstream, fmt.Sprintf("%d.root.json", i+1)), root, 0o644); err != nil {
+					t.Fatalf("Failed to write root: %v", err)
+				}
+			}
+			dir := t.TempDir()
+			count, err := WriteRootHistory(dirFetcher{dir: upstream}, dir, tt.roots[len(tt.roots)-1])
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil) != (err == nil) {
+				t.Fatalf("WriteRootHistory() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if count != len(tt.roots) {
+				t.Errorf("WriteRootHistory() = %d, want %d", count, len(tt.roots))
+			}
+			for version := 1; version < len(tt.roots); version++ {
+				if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%d.root.json", version))); err != nil {
+					t.Errorf("root version %d is missing: %v", version, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorSync(t *testing.T) {
+	defer func(source, checksum string) { rootSource, rootChecksum = source, checksum }(rootSource, rootChecksum)
+	tests := []struct {
+		name       string
+		repository func(t *testing.T) string
+		history    []string
+	}{
+		{"consistent snapshots", newTestRepository, []string{"1.snapshot.json", "1.targets.json", "2.snapshot.json", "2.targets.json"}},
+		{"without consistent snapshots", newNonConsistentTestRepository, []string{"snapshot.json", "targets.json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootSource, rootChecksum = "", ""
+			repositoryDir := tt.repository(t)
+			updateTestRepository(t, repositoryDir, "added.txt", "added")
+			server := httptest.NewServer(RepositoryHandler(repositoryDir))
+			defer server.Close()
+
+			dir := t.TempDir()
+			if err := MirrorSync(server.URL, dir); err != nil {
+				t.Fatalf("MirrorSync() error = %v", err)
+			}
+			repository, err := openVerifiedDirectory(dir)
+			if err != nil {
+				t.Fatalf("mirrored repository does not verify: %v", err)
+			}
+			if len(repository.Targets) != len(testTargets)+1 {
+				t.Errorf("got %d targets, want %d", len(repository.Targets), len(testTargets)+1)
+			}
+			for _, name := range tt.history {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("%s not mirrored: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMirrorSyncHistory(t *testing.T) {
+	defer func(source, checksum string) { rootSource, rootChecksum = source, checksum }(rootSource, rootChecksum)
+	repositoryDir := newTestRepository(t)
+	updateTestRepository(t, repositoryDir, "added.txt", "added")
+	rootJSON, err := os.ReadFile(filepath.Join(repositoryDir, "1.root.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned := filepath.Join(t.TempDir(), "root.json")
+	if err := os.WriteFile(pinned, rootJSON, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := keys.GenerateEd25519Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPinned := filepath.Join(t.TempDir(), "root.json")
+	if err := os.WriteFile(otherPinned, testRoot(t, 1, otherKey, otherKey), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		checksum string
+		tamper   string
+		replace  string
+		wantErr  error
+	}{
+		{name: "pinned root source", source: pinned},
+		{name: "pinned root checksum", checksum: fmt.Sprintf("%x", sha256.Sum256(rootJSON))},
+		{name: "other root checksum", checksum: strings.Repeat("ab", 32), wantErr: assembler.ErrVerification},
+		{name: "other root source", source: otherPinned, wantErr: assembler.ErrVerification},
+		{name: "unsigned older snapshot", tamper: "1.snapshot.json", wantErr: assembler.ErrVerification},
+		{name: "other older targets version", tamper: "1.targets.json", replace: "2.targets.json", wantErr: assembler.ErrVerification},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootSource, rootChecksum = tt.source, tt.checksum
+			served := repositoryDir
+			if tt.tamper != "" {
+				served = newTestRepository(t)
+				updateTestRepository(t, served, "added.txt", "added")
+				content, err := os.ReadFile(filepath.Join(served, tt.tamper))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.replace != "" {
+					content, err = os.ReadFile(filepath.Join(served, tt.replace))
+					if err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					signed := &data.Signed{}
+					if err := json.Unmarshal(content, signed); err != nil {
+						t.Fatal(err)
+					}
+					signed.Signatures = []data.Signature{}
+					if content, err = json.Marshal(signed); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.WriteFile(filepath.Join(served, tt.tamper), content, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := httptest.NewServer(RepositoryHandler(served))
+			defer server.Close()
+			err := MirrorSync(server.URL, t.TempDir())
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("MirrorSync() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MirrorSync() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
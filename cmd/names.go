@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// TrustRoot name strategies of --name-strategy.
+const (
+	// nameStrategyTimestamp names TrustRoots <prefix>-<unix time>.
+	nameStrategyTimestamp = "timestamp"
+	// nameStrategyDigest names TrustRoots <prefix>-<snapshot version>-<digest8>.
+	nameStrategyDigest = "digest"
+)
+
+// TrustRootName returns the metadata.name of the TrustRoot of the repository
+// assembled in workDir.
+//
+// With the digest strategy the name only depends on the repository contents,
+// so reruns against an unchanged repository produce the same name.
+//
+// Parameters:
+//   - strategy: nameStrategyTimestamp or nameStrategyDigest.
+//   - prefix: The prefix of the name, e.g. the mirror host.
+//   - workDir: The directory of the assembled repository.
+//   - snapshotVersion: The version of the snapshot.json of the repository.
+//
+// Returns:
+//   - The TrustRoot name.
+//   - An error if the strategy is unknown or the repository could not be read.
+func TrustRootName(strategy, prefix, workDir string, snapshotVersion int64) (string, error) {
+	switch strategy {
+	case nameStrategyTimestamp:
+		return fmt.Sprintf("%s-%d", prefix, now().Unix()), nil
+	case nameStrategyDigest:
+		digest, err := RepositoryDigest(workDir)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s-%d-%s", prefix, snapshotVersion, digest[:8]), nil
+	default:
+		return "", fmt.Errorf("unknown name strategy %q, expected %s or %s", strategy, nameStrategyTimestamp, nameStrategyDigest)
+	}
+}
+
+// RepositoryDigest returns the hex SHA-256 of the files of dir: their paths
+// relative to dir and the SHA-256 of their contents, in lexical order. Unlike
+// a digest of the archive it does not depend on file times or compression.
+//
+// Parameters:
+//   - dir: The directory to digest.
+//
+// Returns:
+//   - The hex digest.
+//   - An error if a file could not be read.
+func RepositoryDigest(dir string) (string, error) {
+	digest := sha256.New()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		content := sha256.New()
+		if _, err := io.Copy(content, file); err != nil {
+			return err
+		}
+		fmt.Fprintf(digest, "%s\x00%x\n", filepath.ToSlash(relPath), content.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// maxNameLength is the length limit of Kubernetes object names.
+const maxNameLength = 253
+
+// maxLabelLength is the length limit of label values and of the names of
+// label keys.
+const maxLabelLength = 63
+
+// labelNamePattern matches the name of a label key and non-empty label
+// values: alphanumerics, '-', '_' and '.', starting and ending with an
+// alphanumeric.
+var labelNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+
+// validLabelKey reports whether key is a valid Kubernetes label key: an
+// optional DNS subdomain prefix and '/', then a name of up to maxLabelLength
+// characters.
+func validLabelKey(key string) bool {
+	prefix, name, prefixed := strings.Cut(key, "/")
+	if !prefixed {
+		name = prefix
+	} else if !validObjectName(prefix) {
+		return false
+	}
+	return len(name) <= maxLabelLength && labelNamePattern.MatchString(name)
+}
+
+// validLabelValue reports whether value is a valid Kubernetes label value:
+// empty, or up to maxLabelLength characters like the name of a key.
+func validLabelValue(value string) bool {
+	return value == "" || len(value) <= maxLabelLength && labelNamePattern.MatchString(value)
+}
+
+// validObjectName reports whether name is a valid Kubernetes object name, a
+// DNS subdomain of lowercase alphanumerics, '-' and '.' starting and ending
+// with an alphanumeric.
+func validObjectName(name string) bool {
+	if name == "" || len(name) > maxNameLength {
+		return false
+	}
+	for i, r := range name {
+		alphanumeric := r >= 'a' && r <= 'z' || r >= '0' && r <= '9'
+		if !alphanumeric && (r != '-' && r != '.' || i == 0 || i == len(name)-1) {
+			return false
+		}
+	}
+	return true
+}
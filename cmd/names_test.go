@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTrustRootName(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "targets"), 0o755); err != nil {
+		t.Fatalf("Failed to create targets directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "targets", "rekor.pub"), []byte("rekor public key"), 0o644); err != nil {
+		t.Fatalf("Failed to write target: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		want     string
+		wantErr  bool
+	}{
+		{"timestamp", nameStrategyTimestamp, `^tuf.example.com-\d+$`, false},
+		{"digest", nameStrategyDigest, `^tuf.example.com-156-[0-9a-f]{8}$`, false},
+		{"unknown", "random", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TrustRootName(tt.strategy, "tuf.example.com", dir, 156)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TrustRootName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("TrustRootName() = %q, want match of %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryDigest(t *testing.T) {
+	write := func(t *testing.T, dir, name, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	digest := func(t *testing.T, files map[string]string) string {
+		t.Helper()
+		dir := t.TempDir()
+		for name, content := range files {
+			write(t, dir, name, content)
+		}
+		got, err := RepositoryDigest(dir)
+		if err != nil {
+			t.Fatalf("RepositoryDigest() error = %v", err)
+		}
+		return got
+	}
+	base := map[string]string{"1.root.json": "root", "targets/rekor.pub": "key"}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		same  bool
+	}{
+		{"identical contents", map[string]string{"targets/rekor.pub": "key", "1.root.json": "root"}, true},
+		{"changed content", map[string]string{"1.root.json": "root", "targets/rekor.pub": "rotated"}, false},
+		{"renamed file", map[string]string{"1.root.json": "root", "targets/ctfe.pub": "key"}, false},
+	}
+
+	want := digest(t, base)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := digest(t, tt.files); (got == want) != tt.same {
+				t.Errorf("RepositoryDigest() = %s, base %s, want same %v", got, want, tt.same)
+			}
+		})
+	}
+}
+
+func TestValidObjectName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"sigstore", true},
+		{"tuf-repo-cdn.sigstore.dev-1700000000", true},
+		{"a", true},
+		{strings.Repeat("a", maxNameLength), true},
+		{"", false},
+		{"Sigstore", false},
+		{"sigstore_keys", false},
+		{"team/sigstore", false},
+		{"-sigstore", false},
+		{"sigstore.", false},
+		{strings.Repeat("a", maxNameLength+1), false},
+	}
+	for _, tt := range tests {
+		if got := validObjectName(tt.name); got != tt.want {
+			t.Errorf("validObjectName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidLabel(t *testing.T) {
+	keys := []struct {
+		key  string
+		want bool
+	}{
+		{"team", true},
+		{"app.kubernetes.io/managed-by", true},
+		{"Team_A.b", true},
+		{strings.Repeat("a", maxLabelLength), true},
+		{"", false},
+		{"a b", false},
+		{"-team", false},
+		{"team:a", false},
+		{"app.kubernetes.io/", false},
+		{"/team", false},
+		{"App.io/team", false},
+		{"a/b/c", false},
+		{strings.Repeat("a", maxLabelLength+1), false},
+		{strings.Repeat("a", maxNameLength+1) + "/team", false},
+	}
+	for _, tt := range keys {
+		if got := validLabelKey(tt.key); got != tt.want {
+			t.Errorf("validLabelKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	values := []struct {
+		value string
+		want  bool
+	}{
+		{"", true},
+		{"a", true},
+		{"trustroot-assembler", true},
+		{"v1.2_3", true},
+		{"a b", false},
+		{"a\n  b: c", false},
+		{"\"quoted\"", false},
+		{"team-", false},
+		{strings.Repeat("a", maxLabelLength+1), false},
+	}
+	for _, tt := range values {
+		if got := validLabelValue(tt.value); got != tt.want {
+			t.Errorf("validLabelValue(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultNATSTimeout bounds a NATS publish when the HTTP client has no timeout.
+const defaultNATSTimeout = 10 * time.Second
+
+// NATSNotifier publishes WebhookEvents as JSON messages to a NATS subject,
+// speaking the NATS client protocol directly: every event is published on a
+// new connection, confirmed with a PING round trip.
+type NATSNotifier struct {
+	// URL is the server, nats://[user:password@|token@]host:port, or
+	// tls://host:port to require TLS.
+	URL     string
+	Subject string
+}
+
+// natsInfo is the part of the INFO message of a NATS server used by the tool.
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// Notify publishes event to the subject of the notifier.
+//
+// Parameters:
+//   - event: The event to publish.
+//
+// Returns:
+//   - error: nil once the server acknowledged the message.
+func (n NATSNotifier) Notify(event WebhookEvent) error {
+	u, err := url.Parse(n.URL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid NATS URL %q", n.URL)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	timeout := httpClient.Timeout
+	if timeout <= 0 {
+		timeout = defaultNATSTimeout
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no INFO from NATS server %s: %v", host, err)
+	}
+	info := natsInfo{}
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info) != nil {
+		return fmt.Errorf("unexpected greeting from NATS server %s: %q", host, strings.TrimSpace(line))
+	}
+	var rw net.Conn = conn
+	if info.TLSRequired || u.Scheme == "tls" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			return fmt.Errorf("TLS handshake with NATS server %s failed: %v", host, err)
+		}
+		rw, reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+
+	connect := map[string]any{"verbose": false, "pedantic": false, "name": fieldManager, "lang": "go", "protocol": 1}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			connect["user"], connect["pass"] = u.User.Username(), password
+		} else {
+			connect["auth_token"] = u.User.Username()
+		}
+	}
+	options, err := json.Marshal(connect)
+	if err != nil {
+		return err
+	}
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "CONNECT %s\r\nPUB %s %d\r\n", options, n.Subject, len(payload))
+	message.Write(payload)
+	message.WriteString("\r\nPING\r\n")
+	if _, err := rw.Write(message.Bytes()); err != nil {
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("NATS server %s did not acknowledge the message: %v", host, err)
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PONG":
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server %s rejected the message: %s", host, strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// KafkaNotifier produces WebhookEvents as JSON records, keyed by TrustRoot
+// name, to a Kafka topic through an HTTP proxy with the Confluent REST Proxy
+// v2 API, also served by the Strimzi Kafka Bridge and the Redpanda HTTP
+// proxy.
+type KafkaNotifier struct {
+	// URL is the base URL of the proxy.
+	URL   string
+	Topic string
+}
+
+// Notify produces event to the topic of the notifier.
+//
+// Parameters:
+//   - event: The event to produce.
+//
+// Returns:
+//   - error: nil once the proxy acknowledged the record.
+func (k KafkaNotifier) Notify(event WebhookEvent) error {
+	payload, err := json.Marshal(map[string]any{
+		"records": []map[string]any{{"key": event.TrustRoot, "value": event}},
+	})
+	if err != nil {
+		return err
+	}
+	endpoint := strings.TrimSuffix(k.URL, "/") + "/topics/" + url.PathEscape(k.Topic)
+	resp, err := httpClient.Post(endpoint, "application/vnd.kafka.json.v2+json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Kafka proxy %s answered %s", k.URL, resp.Status)
+	}
+	var result struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		// Proxies are not required to describe the offsets
+		return nil
+	}
+	for _, offset := range result.Offsets {
+		if offset.ErrorCode != nil && *offset.ErrorCode != 0 {
+			return fmt.Errorf("Kafka proxy %s could not produce to %s: %s", k.URL, k.Topic, offset.Error)
+		}
+	}
+	return nil
+}
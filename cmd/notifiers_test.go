@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveNATS accepts one connection on listener, answers the PING with reply
+// and sends the CONNECT options and the published message to received.
+func serveNATS(t *testing.T, listener net.Listener, reply string, received chan<- [2]string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+	reader := bufio.NewReader(conn)
+	connect, _ := reader.ReadString('\n')
+	pub, _ := reader.ReadString('\n')
+	var subject string
+	var size int
+	if _, err := fmt.Sscanf(pub, "PUB %s %d", &subject, &size); err != nil {
+		t.Errorf("unexpected PUB line %q", pub)
+		return
+	}
+	payload := make([]byte, size+2)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Errorf("Failed to read payload: %v", err)
+		return
+	}
+	if ping, _ := reader.ReadString('\n'); ping != "PING\r\n" {
+		t.Errorf("unexpected line %q, want PING", ping)
+	}
+	fmt.Fprint(conn, reply)
+	received <- [2]string{strings.TrimPrefix(connect, "CONNECT "), subject + " " + strings.TrimSpace(string(payload))}
+}
+
+func TestNATSNotifierNotify(t *testing.T) {
+	event := WebhookEvent{Type: EventGenerated, TrustRoot: "sigstore", Mirror: "https://tuf-repo-cdn.sigstore.dev", Message: "generated", Time: time.Unix(0, 0).UTC()}
+	tests := []struct {
+		name     string
+		userinfo string
+		reply    string
+		wantAuth map[string]string
+		wantErr  bool
+	}{
+		{"anonymous", "", "PONG\r\n", map[string]string{}, false},
+		{"token", "s3cr3t@", "PONG\r\n", map[string]string{"auth_token": "s3cr3t"}, false},
+		{"user and password", "alice:pw@", "PONG\r\n", map[string]string{"user": "alice", "pass": "pw"}, false},
+		{"rejected", "", "-ERR 'Authorization Violation'\r\n", map[string]string{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			defer listener.Close()
+			received := make(chan [2]string, 1)
+			go serveNATS(t, listener, tt.reply, received)
+
+			notifier := NATSNotifier{URL: "nats://" + tt.userinfo + listener.Addr().String(), Subject: "trustroot.events"}
+			err = notifier.Notify(event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := <-received
+			var options map[string]any
+			if err := json.Unmarshal([]byte(got[0]), &options); err != nil {
+				t.Fatalf("CONNECT options are not JSON: %v", err)
+			}
+			for _, key := range []string{"auth_token", "user", "pass"} {
+				if value, _ := options[key].(string); value != tt.wantAuth[key] {
+					t.Errorf("CONNECT %s = %q, want %q", key, value, tt.wantAuth[key])
+				}
+			}
+			payload, _ := json.Marshal(event)
+			if want := "trustroot.events " + string(payload); got[1] != want {
+				t.Errorf("published %q, want %q", got[1], want)
+			}
+		})
+	}
+}
+
+func TestNATSNotifierNotifyInvalidURL(t *testing.T) {
+	if err := (NATSNotifier{URL: "localhost", Subject: "trustroot.events"}).Notify(WebhookEvent{}); err == nil {
+		t.Error("Notify() error = nil, want an invalid URL error")
+	}
+}
+
+func TestKafkaNotifierNotify(t *testing.T) {
+	event := WebhookEvent{Type: EventKeysRotated, TrustRoot: "sigstore", Message: "changed rekor.pub"}
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantErr  bool
+	}{
+		{"produced", http.StatusOK, `{"offsets":[{"partition":0,"offset":4,"error_code":null,"error":null}]}`, false},
+		{"no offsets", http.StatusOK, ``, false},
+		{"record error", http.StatusOK, `{"offsets":[{"error_code":40403,"error":"unknown topic"}]}`, true},
+		{"proxy error", http.StatusNotFound, `{"error_code":40401,"message":"Topic not found."}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, contentType string
+			var got struct {
+				Records []struct {
+					Key   string       `json:"key"`
+					Value WebhookEvent `json:"value"`
+				} `json:"records"`
+			}
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("Failed to decode records: %v", err)
+				}
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.response)
+			}))
+			defer proxy.Close()
+
+			err := KafkaNotifier{URL: proxy.URL + "/", Topic: "trustroot-events"}.Notify(event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != "/topics/trustroot-events" || contentType != "application/vnd.kafka.json.v2+json" {
+				t.Errorf("POST %s with %s, want /topics/trustroot-events with application/vnd.kafka.json.v2+json", path, contentType)
+			}
+			if len(got.Records) != 1 || got.Records[0].Key != "sigstore" || got.Records[0].Value.Message != event.Message {
+				t.Errorf("records = %+v", got.Records)
+			}
+		})
+	}
+}
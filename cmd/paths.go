@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// MoveDirectory moves the directory src to dst. When they are on different
+// volumes, where os.Rename fails (e.g. a TEMP directory on another drive on
+// Windows), src is copied to dst and then removed.
+//
+// Parameters:
+//   - src: The directory to move.
+//   - dst: The destination path, which must not exist.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func MoveDirectory(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("could not move %s: %s already exists", src, dst)
+	}
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, relPath), 0o755)
+		}
+		return copyFile(path, filepath.Join(dst, relPath))
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return fmt.Errorf("could not copy %s to %s: %v", src, dst, err)
+	}
+	return os.RemoveAll(src)
+}
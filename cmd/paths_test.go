@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMoveDirectory(t *testing.T) {
+	tests := []struct {
+		name      string
+		dstExists bool
+		wantErr   bool
+	}{
+		{"move", false, false},
+		{"existing destination", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "targets")
+			if err := os.MkdirAll(filepath.Join(src, "nested"), 0o755); err != nil {
+				t.Fatalf("Failed to create source: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "nested", "rekor.pub"), []byte("key"), 0o644); err != nil {
+				t.Fatalf("Failed to write source file: %v", err)
+			}
+			dst := filepath.Join(t.TempDir(), "targets")
+			if tt.dstExists {
+				if err := os.WriteFile(dst, nil, 0o644); err != nil {
+					t.Fatalf("Failed to create destination: %v", err)
+				}
+			}
+
+			err := MoveDirectory(src, dst)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveDirectory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if content, err := os.ReadFile(filepath.Join(dst, "nested", "rekor.pub")); err != nil || string(content) != "key" {
+				t.Errorf("moved file = %q, %v", content, err)
+			}
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("source still exists: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// PluginInput is the JSON document a --plugin executable reads on stdin.
+type PluginInput struct {
+	TemplateData
+	// Mirror is the repository source, the mirror URL or the --map file.
+	Mirror string `json:"mirror"`
+	// Output is the --output format of Manifest, the printed output.
+	Output   string `json:"output"`
+	Manifest string `json:"manifest"`
+}
+
+// RunPlugin runs the executable at path, kubectl-plugin style, with input as
+// JSON on its stdin, for distribution steps the tool does not implement, such
+// as ticketing or CMDB updates. The plugin output goes to stderr, so it never
+// mixes with the printed manifest.
+//
+// Parameters:
+//   - path: The plugin executable, looked up in $PATH.
+//   - input: The assembled result.
+//
+// Returns:
+//   - error: nil if the plugin exited with code 0, otherwise an error describing what went wrong.
+func RunPlugin(path string, input PluginInput) error {
+	content, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path)
+	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("plugin %s failed: %v", path, err)
+	}
+	return nil
+}
+
+// runPlugins runs every --plugin with the repository assembled in workDir and
+// its printed manifest, in order, exiting on errors.
+func runPlugins(plugins []string, mirror, format, name, workDir string, rootJSONFile *os.File, manifest string) {
+	if len(plugins) == 0 {
+		return
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	data, err := newTemplateData(TrustMaterial{Name: name, Dir: workDir, RootJSON: rootJSON})
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	input := PluginInput{TemplateData: data, Mirror: mirror, Output: format, Manifest: manifest}
+	for _, plugin := range plugins {
+		if err := RunPlugin(plugin, input); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		log.Printf("plugin %s done\n", plugin)
+	}
+}
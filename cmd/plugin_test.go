@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRunPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	input := PluginInput{
+		TemplateData: TemplateData{Name: "sigstore-1", Root: "e30=", Versions: map[string]int64{"snapshot.json": 3}},
+		Mirror:       "https://tuf-repo-cdn.sigstore.dev",
+		Output:       outputTrustRoot,
+		Manifest:     "kind: TrustRoot\n",
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{"reads stdin", "#!/bin/sh\ncat > \"$PLUGIN_OUT\"\n", false},
+		{"fails", "#!/bin/sh\necho rejected >&2\nexit 3\n", true},
+		{"missing", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			plugin := filepath.Join(dir, "plugin")
+			if tt.script != "" {
+				if err := os.WriteFile(plugin, []byte(tt.script), 0o755); err != nil {
+					t.Fatalf("Failed to write plugin: %v", err)
+				}
+			}
+			t.Setenv("PLUGIN_OUT", filepath.Join(dir, "input.json"))
+			err := RunPlugin(plugin, input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunPlugin() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "input.json"))
+			if err != nil {
+				t.Fatalf("Plugin did not receive its input: %v", err)
+			}
+			var got map[string]any
+			if err := json.Unmarshal(content, &got); err != nil {
+				t.Fatalf("Plugin input is not JSON: %v", err)
+			}
+			if got["name"] != "sigstore-1" || got["mirror"] != input.Mirror || got["manifest"] != input.Manifest || got["versions"].(map[string]any)["snapshot.json"] != float64(3) {
+				t.Errorf("plugin input = %s", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"testing/fstest"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// Archives are unpacked in memory, so the uncompressed stream and each of
+// its files are bounded by a small multiple of the object limit: a mirrorFS
+// cannot exceed maxObjectSize, and anything well beyond it once uncompressed
+// is a compression bomb rather than a repository.
+const (
+	maxUncompressedArchiveSize = 16 * maxObjectSize
+	maxUncompressedEntrySize   = 4 * maxObjectSize
+)
+
+// UncompressMemFS unpacks a tar.gz repository archive into an in-memory
+// filesystem the way the policy-controller unpacks the mirrorFS of a
+// TrustRoot: only directories and regular files are kept, and stripPrefix is
+// trimmed from the entry names. Archives uncompressing to more than
+// maxUncompressedArchiveSize, or holding a file larger than
+// maxUncompressedEntrySize, are rejected.
+//
+// Parameters:
+//   - archive: The tar.gz archive.
+//   - stripPrefix: The prefix trimmed from the entry names, e.g. "repository/".
+//
+// Returns:
+//   - The filesystem of the archive.
+//   - An error if the archive is not a valid tar.gz archive, wrapping
+//     assembler.ErrVerification if it exceeds the size limits.
+func UncompressMemFS(archive io.Reader, stripPrefix string) (fs.FS, error) {
+	gr, err := gzip.NewReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	memFS := fstest.MapFS{}
+	stream := &io.LimitedReader{R: gr, N: maxUncompressedArchiveSize + 1}
+	tooLarge := fmt.Errorf("%w: archive uncompresses to more than %d bytes", assembler.ErrVerification, maxUncompressedArchiveSize)
+	tr := tar.NewReader(stream)
+	for {
+		header, err := tr.Next()
+		if stream.N <= 0 {
+			return nil, tooLarge
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(header.Name, stripPrefix)
+		if name == "" {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			memFS[name] = &fstest.MapFile{Mode: fs.ModeDir | 0o755}
+		case tar.TypeReg:
+			if header.Size > maxUncompressedEntrySize {
+				return nil, fmt.Errorf("%w: archive entry %s is larger than %d bytes", assembler.ErrVerification, header.Name, maxUncompressedEntrySize)
+			}
+			content, err := io.ReadAll(io.LimitReader(tr, maxUncompressedEntrySize))
+			if stream.N <= 0 {
+				return nil, tooLarge
+			}
+			if err != nil {
+				return nil, err
+			}
+			memFS[name] = &fstest.MapFile{Data: content, Mode: 0o644}
+		}
+	}
+	return memFS, nil
+}
+
+// CheckPolicyControllerArchive loads a repository archive the way the
+// policy-controller loads a TrustRoot: the archive is unpacked in memory, a
+// go-tuf FileRemoteStore reads the metadata from the repository directory and
+// the targets from the targets directory of layout, and a client initialized
+// with rootJSON updates from it. Every target is then downloaded, so a
+// repository that does not load is caught before it reaches an admission
+// webhook.
+//
+// Parameters:
+//   - archive: The tar.gz repository archive.
+//   - rootJSON: The root.json of the TrustRoot.
+//   - layout: The layout of the archive, giving the repository and targets directories.
+//
+// Returns:
+//   - error: nil if the repository loads, otherwise an error describing why the policy-controller would reject it.
+func CheckPolicyControllerArchive(archive io.Reader, rootJSON []byte, layout assembler.ArchiveLayout) error {
+	c, targets, err := loadArchivedRepository(archive, rootJSON, layout)
+	if err != nil {
+		return err
+	}
+	for name := range targets {
+		if err := c.Download(name, discardDestination{}); err != nil {
+			return fmt.Errorf("could not load target %s from the archive: %w", name, assembler.ClassifyTUFError(err))
+		}
+	}
+	return nil
+}
+
+// loadArchivedRepository unpacks a repository archive laid out by layout in
+// memory and returns a go-tuf client initialized with rootJSON and updated
+// from it, with the verified targets metadata, see
+// CheckPolicyControllerArchive.
+func loadArchivedRepository(archive io.Reader, rootJSON []byte, layout assembler.ArchiveLayout) (*client.Client, data.TargetFiles, error) {
+	stripPrefix := ""
+	if layout.Prefix != "" {
+		stripPrefix = layout.Prefix + "/"
+	}
+	memFS, err := UncompressMemFS(archive, stripPrefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not unpack the archive: %w", err)
+	}
+	remote, err := client.NewFileRemoteStore(memFS, layout.Targets())
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open the archived repository: %v", err)
+	}
+	c := client.NewClient(client.MemoryLocalStore(), remote)
+	if err := c.Init(rootJSON); err != nil {
+		return nil, nil, fmt.Errorf("could not initialize a TUF client from the archive: %w", assembler.ClassifyTUFError(err))
+	}
+	targets, err := c.Update()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not update TUF metadata from the archive: %w", assembler.ClassifyTUFError(err))
+	}
+	return c, targets, nil
+}
+
+// checkArchive runs the archive of the repository assembled in workDir
+// through CheckPolicyControllerArchive with the root at rootPath, exiting on
+// errors.
+func checkArchive(workDir, rootPath string) {
+	if err := verifyArchive(workDir, rootPath); err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	log.Printf("verified the archive loads like in the policy-controller\n")
+}
+
+// verifyArchive runs the archive of the repository assembled in workDir
+// through CheckPolicyControllerArchive with the root at rootPath. The archive
+// is streamed to the check without an archive file.
+func verifyArchive(workDir, rootPath string) error {
+	if err := checkArchiveSize(workDir); err != nil {
+		return err
+	}
+	rootJSON, err := os.ReadFile(rootPath)
+	if err != nil {
+		return fmt.Errorf("could not read root.json: %v", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(compressRepository(workDir, pw))
+	}()
+	err = CheckPolicyControllerArchive(pr, rootJSON, archiveLayout)
+	// Drain the archive so the compressing goroutine ends
+	io.Copy(io.Discard, pr)
+	if err != nil {
+		return fmt.Errorf("the archive would not load in the policy-controller: %w", err)
+	}
+	return nil
+}
+
+// checkArchiveSize compresses the repository in workDir without keeping the
+// archive and returns an error wrapping errArchiveLimit if it exceeds
+// maxArchiveSize, so the limit is reported as such rather than as an archive
+// the policy-controller could not load.
+func checkArchiveSize(workDir string) error {
+	if maxArchiveSize == 0 {
+		return nil
+	}
+	if err := compressRepository(workDir, io.Discard); err != nil {
+		return fmt.Errorf("could not compress the repository: %w", err)
+	}
+	return nil
+}
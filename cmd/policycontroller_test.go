@@ -0,0 +1,186 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+)
+
+func TestCheckPolicyControllerArchive(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read root.json: %v", err)
+	}
+	archive := func(t *testing.T, dir string, layout assembler.ArchiveLayout) []byte {
+		var buf bytes.Buffer
+		if err := assembler.CompressFSLayout(os.DirFS(dir), &buf, layout, nil); err != nil {
+			t.Fatalf("CompressFSLayout() error = %v", err)
+		}
+		return buf.Bytes()
+	}
+	tampered := t.TempDir()
+	if err := MoveDirectory(workDir, filepath.Join(tampered, "repository")); err != nil {
+		t.Fatalf("Failed to copy the repository: %v", err)
+	}
+	tampered = filepath.Join(tampered, "repository")
+	// Under its plain and consistent snapshot names
+	copies, _ := filepath.Glob(filepath.Join(tampered, "targets", "*rekor.pub"))
+	for _, copy := range copies {
+		if err := os.WriteFile(copy, []byte("tampered"), 0o644); err != nil {
+			t.Fatalf("Failed to tamper with the repository: %v", err)
+		}
+	}
+	repository := t.TempDir()
+	if _, err := AssembleRepository(server.URL, repository); err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+
+	prefixed := assembler.ArchiveLayout{Prefix: "repository", TargetsDir: "files"}
+	tests := []struct {
+		name    string
+		archive []byte
+		layout  assembler.ArchiveLayout
+		wantErr error
+	}{
+		{"default layout", archive(t, repository, assembler.DefaultArchiveLayout), assembler.DefaultArchiveLayout, nil},
+		{"custom layout", archive(t, repository, prefixed), prefixed, nil},
+		{"layout mismatch", archive(t, repository, prefixed), assembler.DefaultArchiveLayout, errors.New("")},
+		{"tampered target", archive(t, tampered, assembler.DefaultArchiveLayout), assembler.DefaultArchiveLayout, assembler.ErrVerification},
+		{"not an archive", []byte("mirrorFS"), assembler.DefaultArchiveLayout, errors.New("")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPolicyControllerArchive(bytes.NewReader(tt.archive), rootJSON, tt.layout)
+			if (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("CheckPolicyControllerArchive() error = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(tt.wantErr, assembler.ErrVerification) && !errors.Is(err, assembler.ErrVerification) {
+				t.Errorf("CheckPolicyControllerArchive() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyArchiveMaxArchiveSize(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+	defer func(limit int64) { maxArchiveSize = limit }(maxArchiveSize)
+
+	tests := []struct {
+		name     string
+		limit    int64
+		wantCode int
+	}{
+		{"no limit", 0, 0},
+		{"within the limit", 1 << 20, 0},
+		{"beyond the limit", 10, exitCodeOversized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxArchiveSize = tt.limit
+			err := verifyArchive(workDir, rootJSONFile.Name())
+			if (err != nil) != (tt.wantCode != 0) {
+				t.Fatalf("verifyArchive() error = %v, want exit code %d", err, tt.wantCode)
+			}
+			if err == nil {
+				return
+			}
+			if got := exitCode(err); got != tt.wantCode {
+				t.Errorf("exitCode(%v) = %d, want %d", err, got, tt.wantCode)
+			}
+			if !errors.Is(err, errArchiveLimit) || !strings.Contains(remediationHint(err), "--max-archive-size") {
+				t.Errorf("verifyArchive() error = %v, hint %q, want %v", err, remediationHint(err), errArchiveLimit)
+			}
+		})
+	}
+}
+
+func TestUncompressMemFSSizeLimits(t *testing.T) {
+	archive := func(sizes ...int64) []byte {
+		var buffer bytes.Buffer
+		gw := gzip.NewWriter(&buffer)
+		tw := tar.NewWriter(gw)
+		for i, size := range sizes {
+			if err := tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("repository/%d.json", i), Typeflag: tar.TypeReg, Mode: 0o644, Size: size}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.CopyN(tw, zeroReader{}, size); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.Bytes()
+	}
+	entries := make([]int64, maxUncompressedArchiveSize/maxUncompressedEntrySize+1)
+	for i := range entries {
+		entries[i] = maxUncompressedEntrySize
+	}
+
+	tests := []struct {
+		name    string
+		archive []byte
+		wantErr bool
+	}{
+		{"within the limits", archive(1024, maxUncompressedEntrySize), false},
+		{"oversized entry", archive(maxUncompressedEntrySize + 1), true},
+		{"oversized archive", archive(entries...), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memFS, err := UncompressMemFS(bytes.NewReader(tt.archive), "repository/")
+			if tt.wantErr {
+				if !errors.Is(err, assembler.ErrVerification) {
+					t.Errorf("UncompressMemFS() error = %v, want %v", err, assembler.ErrVerification)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UncompressMemFS() error = %v", err)
+			}
+			if info, err := fs.Stat(memFS, "1.json"); err != nil || info.Size() != maxUncompressedEntrySize {
+				t.Errorf("fs.Stat(1.json) = %v, %v, want %d bytes", info, err, maxUncompressedEntrySize)
+			}
+		})
+	}
+}
+
+// zeroReader reads an endless stream of zeros, which compresses to almost
+// nothing.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
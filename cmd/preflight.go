@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// Annotations cosign sets on the layers of signature and attestation
+// manifests.
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+	cosignBundleAnnotation      = "dev.sigstore.cosign/bundle"
+)
+
+// maxPreflightBlobSize bounds the signature payloads and attestation
+// envelopes read from the registry.
+const maxPreflightBlobSize = 4 << 20
+
+// OIDC issuer extensions of Fulcio certificates: the first holds the raw
+// issuer, the second, which replaces it, a DER encoded UTF8String.
+var (
+	fulcioIssuerOID   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	fulcioIssuerV2OID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// sctListOID is the extension of the SignedCertificateTimestampList CT logs
+// return for the precertificate of a Fulcio certificate, RFC 6962 section 3.3.
+var sctListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// SigstoreKeysAt returns the trust material in effect at a time: the
+// entries of a trusted_root.json whose validFor window holds it.
+type SigstoreKeysAt func(at time.Time) (SigstoreKeys, error)
+
+// PreflightOptions configures PreflightImage.
+type PreflightOptions struct {
+	// IssuerRegExp and SubjectRegExp match the OIDC identity of the Fulcio
+	// certificates of the signatures, both are required.
+	IssuerRegExp  string
+	SubjectRegExp string
+	// RequireAttestation also requires a verified attestation.
+	RequireAttestation bool
+}
+
+// VerifiedSignature is a signature or attestation of an image verified by
+// PreflightImage.
+type VerifiedSignature struct {
+	Subject        string
+	Issuer         string
+	LogIndex       int64
+	IntegratedTime time.Time
+	// PredicateType is the in-toto predicate type of attestations.
+	PredicateType string
+}
+
+// PreflightResult holds the signatures and attestations of an image that
+// verify with the trust material and the reasons the others were rejected.
+type PreflightResult struct {
+	Signatures   []VerifiedSignature
+	Attestations []VerifiedSignature
+	Rejected     []string
+}
+
+// cosignLayer is a layer of a cosign signature or attestation manifest.
+type cosignLayer struct {
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// rekorBundle is the dev.sigstore.cosign/bundle annotation: the Rekor entry
+// of a signature and the promise of the log to include it.
+type rekorBundle struct {
+	SignedEntryTimestamp []byte             `json:"SignedEntryTimestamp"`
+	Payload              rekorBundlePayload `json:"Payload"`
+}
+
+// rekorBundlePayload is the Rekor entry the SignedEntryTimestamp signs, its
+// fields in the order of its canonical JSON.
+type rekorBundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// dsseEnvelope is the DSSE envelope of an attestation.
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     []byte `json:"payload"`
+	Signatures  []struct {
+		Sig []byte `json:"sig"`
+	} `json:"signatures"`
+}
+
+// PreflightImage verifies the keyless cosign signatures and attestations of an
+// image with only the given trust material, before the TrustRoot holding it
+// is rolled out. The Fulcio certificate of each signature must chain to one
+// of the certificate authorities in effect when Rekor logged it and embed an
+// SCT signed by one of the CT logs in effect at its timestamp, a Rekor log in
+// effect then must have signed the promise to log it, the logged entry must
+// be that of the signature and the payload that of the image digest.
+// Detached SCTs, Rekor inclusion proofs and timestamp authorities are not
+// checked.
+//
+// Parameters:
+//   - registry: The fetcher of the image, whose reference must be a sha256 digest.
+//   - keys: The trust material to verify with, at the integrated time of each signature and the timestamp of each SCT.
+//   - options: The identity the signatures must be made by.
+//
+// Returns:
+//   - The verified signatures and attestations, with the reasons the others were rejected.
+//   - An error wrapping assembler.ErrVerification if no signature, or no attestation with options.RequireAttestation, verifies, or the error of the registry.
+func PreflightImage(registry *ociFetcher, keys SigstoreKeysAt, options PreflightOptions) (PreflightResult, error) {
+	var result PreflightResult
+	digest, ok := strings.CutPrefix(registry.reference, "sha256:")
+	if !ok {
+		return result, fmt.Errorf("image %s/%s must be referenced by its sha256 digest", registry.registry, registry.repository)
+	}
+	if options.IssuerRegExp == "" || options.SubjectRegExp == "" {
+		return result, fmt.Errorf("an issuer and a subject regular expression are required")
+	}
+	issuer, err := regexp.Compile(options.IssuerRegExp)
+	if err != nil {
+		return result, fmt.Errorf("invalid issuer regular expression: %v", err)
+	}
+	subject, err := regexp.Compile(options.SubjectRegExp)
+	if err != nil {
+		return result, fmt.Errorf("invalid subject regular expression: %v", err)
+	}
+	verifier := preflightVerifier{keys: keys, digest: digest, issuer: issuer, subject: subject}
+
+	for _, kind := range []string{"sig", "att"} {
+		layers, err := cosignLayers(registry, "sha256-"+digest+"."+kind)
+		if err != nil {
+			return result, err
+		}
+		for _, layer := range layers {
+			blob, err := cosignBlob(registry, layer.Digest)
+			if err != nil {
+				return result, err
+			}
+			if kind == "sig" {
+				verified, err := verifier.verifySignature(layer, blob)
+				if err != nil {
+					result.Rejected = append(result.Rejected, fmt.Sprintf("signature %s: %v", layer.Digest, err))
+					continue
+				}
+				result.Signatures = append(result.Signatures, verified)
+			} else {
+				verified, err := verifier.verifyAttestation(layer, blob)
+				if err != nil {
+					result.Rejected = append(result.Rejected, fmt.Sprintf("attestation %s: %v", layer.Digest, err))
+					continue
+				}
+				result.Attestations = append(result.Attestations, verified)
+			}
+		}
+	}
+
+	image := registry.registry + "/" + registry.repository + "@" + registry.reference
+	reasons := ""
+	if len(result.Rejected) > 0 {
+		reasons = ": " + strings.Join(result.Rejected, "; ")
+	}
+	if len(result.Signatures) == 0 {
+		return result, fmt.Errorf("%w: no signature of %s verifies with the trust root%s", assembler.ErrVerification, image, reasons)
+	}
+	if options.RequireAttestation && len(result.Attestations) == 0 {
+		return result, fmt.Errorf("%w: no attestation of %s verifies with the trust root%s", assembler.ErrVerification, image, reasons)
+	}
+	return result, nil
+}
+
+// cosignLayers returns the layers of the cosign manifest tag of registry, none
+// if the tag does not exist.
+func cosignLayers(registry *ociFetcher, tag string) ([]cosignLayer, error) {
+	resp, err := registry.do(fmt.Sprintf("/v2/%s/manifests/%s", registry.repository, tag), "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get manifest %s of %s/%s: %s", tag, registry.registry, registry.repository, resp.Status)
+	}
+	var manifest struct {
+		Layers []cosignLayer `json:"layers"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPreflightBlobSize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("could not parse manifest %s of %s/%s: %v", tag, registry.registry, registry.repository, err)
+	}
+	return manifest.Layers, nil
+}
+
+// cosignBlob returns the blob digest of registry, checking its content
+// matches the digest.
+func cosignBlob(registry *ociFetcher, digest string) ([]byte, error) {
+	resp, err := registry.do(fmt.Sprintf("/v2/%s/blobs/%s", registry.repository, digest), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not get blob %s of %s/%s: %s", digest, registry.registry, registry.repository, resp.Status)
+	}
+	blob, err := io.ReadAll(io.LimitReader(resp.Body, maxPreflightBlobSize))
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(blob); "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf("%w: blob %s of %s/%s does not match its digest", assembler.ErrVerification, digest, registry.registry, registry.repository)
+	}
+	return blob, nil
+}
+
+// preflightVerifier verifies the signatures of the image digest, the hex
+// encoded SHA-256 of its manifest.
+type preflightVerifier struct {
+	keys    SigstoreKeysAt
+	digest  string
+	issuer  *regexp.Regexp
+	subject *regexp.Regexp
+}
+
+// verifySignature verifies a signature layer and its simple signing payload.
+func (v preflightVerifier) verifySignature(layer cosignLayer, payload []byte) (VerifiedSignature, error) {
+	signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+	if err != nil || len(signature) == 0 {
+		return VerifiedSignature{}, fmt.Errorf("missing or malformed %s annotation", cosignSignatureAnnotation)
+	}
+	verified, cert, entry, err := v.verifyCertificate(layer)
+	if err != nil {
+		return verified, err
+	}
+	if err := verifyMessageSignature(cert.PublicKey, payload, signature); err != nil {
+		return verified, fmt.Errorf("invalid signature of the payload: %v", err)
+	}
+	var logged struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Data struct {
+				Hash struct {
+					Value string `json:"value"`
+				} `json:"hash"`
+			} `json:"data"`
+			Signature struct {
+				Content   []byte `json:"content"`
+				PublicKey struct {
+					Content []byte `json:"content"`
+				} `json:"publicKey"`
+			} `json:"signature"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(entry, &logged); err != nil || logged.Kind != "hashedrekord" {
+		return verified, fmt.Errorf("the Rekor entry is not a hashedrekord")
+	}
+	sum := sha256.Sum256(payload)
+	if logged.Spec.Data.Hash.Value != hex.EncodeToString(sum[:]) || !bytes.Equal(logged.Spec.Signature.Content, signature) {
+		return verified, fmt.Errorf("the Rekor entry is not that of the signature")
+	}
+	if err := checkLoggedKey(logged.Spec.Signature.PublicKey.Content, cert); err != nil {
+		return verified, err
+	}
+	var simpleSigning struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest string `json:"docker-manifest-digest"`
+			} `json:"image"`
+		} `json:"critical"`
+	}
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return verified, fmt.Errorf("malformed payload: %v", err)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != "sha256:"+v.digest {
+		return verified, fmt.Errorf("the payload signs %s, not the image", simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+	return verified, nil
+}
+
+// verifyAttestation verifies an attestation layer and its DSSE envelope.
+func (v preflightVerifier) verifyAttestation(layer cosignLayer, envelopeJSON []byte) (VerifiedSignature, error) {
+	var envelope dsseEnvelope
+	if err := json.Unmarshal(envelopeJSON, &envelope); err != nil {
+		return VerifiedSignature{}, fmt.Errorf("malformed DSSE envelope: %v", err)
+	}
+	verified, cert, entry, err := v.verifyCertificate(layer)
+	if err != nil {
+		return verified, err
+	}
+	pae := dssePAE(envelope.PayloadType, envelope.Payload)
+	signed := false
+	for _, signature := range envelope.Signatures {
+		if verifyMessageSignature(cert.PublicKey, pae, signature.Sig) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return verified, fmt.Errorf("no valid signature of the envelope")
+	}
+	var logged struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Content struct {
+				Envelope struct {
+					Signatures []struct {
+						PublicKey []byte `json:"publicKey"`
+					} `json:"signatures"`
+				} `json:"envelope"`
+				PayloadHash struct {
+					Value string `json:"value"`
+				} `json:"payloadHash"`
+			} `json:"content"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(entry, &logged); err != nil || logged.Kind != "intoto" {
+		return verified, fmt.Errorf("the Rekor entry is not an intoto entry")
+	}
+	sum := sha256.Sum256(envelope.Payload)
+	if logged.Spec.Content.PayloadHash.Value != hex.EncodeToString(sum[:]) {
+		return verified, fmt.Errorf("the Rekor entry is not that of the attestation")
+	}
+	keyErr := fmt.Errorf("the Rekor entry logs no signature key")
+	for _, signature := range logged.Spec.Content.Envelope.Signatures {
+		if keyErr = checkLoggedKey(signature.PublicKey, cert); keyErr == nil {
+			break
+		}
+	}
+	if keyErr != nil {
+		return verified, keyErr
+	}
+	var statement struct {
+		PredicateType string `json:"predicateType"`
+		Subject       []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+	}
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		return verified, fmt.Errorf("malformed in-toto statement: %v", err)
+	}
+	for _, subject := range statement.Subject {
+		if subject.Digest["sha256"] == v.digest {
+			verified.PredicateType = statement.PredicateType
+			return verified, nil
+		}
+	}
+	return verified, fmt.Errorf("the image is not a subject of the in-toto statement")
+}
+
+// verifyCertificate verifies the Rekor bundle and the Fulcio certificate of a
+// layer and the identity of the certificate, returning the certificate and
+// the body of the Rekor entry.
+func (v preflightVerifier) verifyCertificate(layer cosignLayer) (VerifiedSignature, *x509.Certificate, []byte, error) {
+	var verified VerifiedSignature
+	if layer.Annotations[cosignCertificateAnnotation] == "" {
+		return verified, nil, nil, fmt.Errorf("not a keyless signature, no Fulcio certificate")
+	}
+	certs, err := parseCertificates([]byte(layer.Annotations[cosignCertificateAnnotation]))
+	if err != nil {
+		return verified, nil, nil, fmt.Errorf("malformed certificate: %v", err)
+	}
+	cert := certs[0]
+	var bundle rekorBundle
+	if err := json.Unmarshal([]byte(layer.Annotations[cosignBundleAnnotation]), &bundle); err != nil {
+		return verified, nil, nil, fmt.Errorf("missing or malformed Rekor bundle")
+	}
+	verified.LogIndex = bundle.Payload.LogIndex
+	verified.IntegratedTime = time.Unix(bundle.Payload.IntegratedTime, 0).UTC()
+	keys, err := v.keys(verified.IntegratedTime)
+	if err != nil {
+		return verified, nil, nil, err
+	}
+	if err := verifyBundle(keys, bundle); err != nil {
+		return verified, nil, nil, err
+	}
+	entry, err := base64.StdEncoding.DecodeString(bundle.Payload.Body)
+	if err != nil {
+		return verified, nil, nil, fmt.Errorf("malformed Rekor entry: %v", err)
+	}
+
+	intermediates := x509.NewCertPool()
+	if chain := layer.Annotations[cosignChainAnnotation]; chain != "" {
+		chainCerts, err := parseCertificates([]byte(chain))
+		if err != nil {
+			return verified, nil, nil, fmt.Errorf("malformed certificate chain: %v", err)
+		}
+		for _, chainCert := range chainCerts {
+			intermediates.AddCert(chainCert)
+		}
+	}
+	roots := x509.NewCertPool()
+	for _, ca := range keys.CertificateAuthorities {
+		chain, err := parseCertificates(ca.CertChain)
+		if err != nil {
+			return verified, nil, nil, fmt.Errorf("certificate authority %s: %v", ca.URI, err)
+		}
+		for i, chainCert := range chain {
+			if i == len(chain)-1 {
+				roots.AddCert(chainCert)
+			} else {
+				intermediates.AddCert(chainCert)
+			}
+		}
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   verified.IntegratedTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return verified, nil, nil, fmt.Errorf("the certificate does not verify with the certificate authorities when it was logged: %v", err)
+	}
+	if len(chains[0]) < 2 {
+		return verified, nil, nil, fmt.Errorf("the certificate is a certificate authority of the trust root")
+	}
+	if err := v.verifyEmbeddedSCTs(cert, chains[0][1]); err != nil {
+		return verified, nil, nil, err
+	}
+
+	switch {
+	case len(cert.URIs) > 0:
+		verified.Subject = cert.URIs[0].String()
+	case len(cert.EmailAddresses) > 0:
+		verified.Subject = cert.EmailAddresses[0]
+	}
+	for _, extension := range cert.Extensions {
+		switch {
+		case extension.Id.Equal(fulcioIssuerV2OID):
+			var issuer string
+			if _, err := asn1.Unmarshal(extension.Value, &issuer); err == nil {
+				verified.Issuer = issuer
+			}
+		case extension.Id.Equal(fulcioIssuerOID) && verified.Issuer == "":
+			verified.Issuer = string(extension.Value)
+		}
+	}
+	if !v.issuer.MatchString(verified.Issuer) || !v.subject.MatchString(verified.Subject) {
+		return verified, nil, nil, fmt.Errorf("signed by %s from %s, which does not match the expected identity", verified.Subject, verified.Issuer)
+	}
+	return verified, cert, entry, nil
+}
+
+// checkLoggedKey returns an error unless the PEM certificate or public key
+// logged in a Rekor entry is the signing certificate cert or its public key,
+// so a log entry of the same payload signed by another key is not accepted
+// as the entry of the signature.
+func checkLoggedKey(logged []byte, cert *x509.Certificate) error {
+	block, _ := pem.Decode(logged)
+	if block == nil {
+		return fmt.Errorf("the Rekor entry logs no PEM encoded signing certificate or key")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		if bytes.Equal(block.Bytes, cert.Raw) {
+			return nil
+		}
+	case "PUBLIC KEY":
+		if der, err := x509.MarshalPKIXPublicKey(cert.PublicKey); err == nil && bytes.Equal(block.Bytes, der) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the Rekor entry logs another signing key than that of the certificate")
+}
+
+// verifyBundle checks the SignedEntryTimestamp of bundle is signed by the
+// transparency log of keys it names.
+func verifyBundle(keys SigstoreKeys, bundle rekorBundle) error {
+	canonical, err := json.Marshal(bundle.Payload)
+	if err != nil {
+		return err
+	}
+	for _, tlog := range keys.TLogs {
+		if !strings.EqualFold(tlog.LogID, bundle.Payload.LogID) {
+			continue
+		}
+		pub, err := logPublicKey(tlog)
+		if err != nil {
+			return err
+		}
+		if err := verifyMessageSignature(pub, canonical, bundle.SignedEntryTimestamp); err != nil {
+			return fmt.Errorf("invalid SignedEntryTimestamp of %s: %v", tlog.BaseURL, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("logged by %s, which is not a transparency log of the trust root", bundle.Payload.LogID)
+}
+
+// logPublicKey returns the parsed public key of a Rekor or CT log.
+func logPublicKey(tlog TransparencyLogInstance) (crypto.PublicKey, error) {
+	der, err := publicKeyDER(tlog.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("transparency log %s: %v", tlog.BaseURL, err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("transparency log %s: %v", tlog.BaseURL, err)
+	}
+	return pub, nil
+}
+
+// signedCertificateTimestamp is an SCT of RFC 6962 section 3.2.
+type signedCertificateTimestamp struct {
+	logID      []byte
+	timestamp  uint64
+	extensions []byte
+	hash       byte
+	signature  []byte
+}
+
+// verifyEmbeddedSCTs checks cert, issued by issuer, embeds an SCT signed by
+// one of the CT logs in effect at its timestamp, as the policy-controller
+// requires of Fulcio certificates.
+func (v preflightVerifier) verifyEmbeddedSCTs(cert, issuer *x509.Certificate) error {
+	scts, err := embeddedSCTs(cert)
+	if err != nil {
+		return err
+	}
+	if len(scts) == 0 {
+		return fmt.Errorf("the certificate embeds no SCT")
+	}
+	tbs, err := precertificateTBS(cert)
+	if err != nil {
+		return fmt.Errorf("malformed certificate: %v", err)
+	}
+	issuerKeyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	for _, sct := range scts {
+		keys, err := v.keys(time.UnixMilli(int64(sct.timestamp)).UTC())
+		if err != nil {
+			return err
+		}
+		for _, ctlog := range keys.CTLogs {
+			if !strings.EqualFold(ctlog.LogID, hex.EncodeToString(sct.logID)) {
+				continue
+			}
+			pub, err := logPublicKey(ctlog)
+			if err != nil {
+				return err
+			}
+			// The digitally-signed struct of a precert_entry, RFC 6962 section 3.2
+			signed := []byte{0, 0}
+			signed = binary.BigEndian.AppendUint64(signed, sct.timestamp)
+			signed = append(signed, 0, 1)
+			signed = append(signed, issuerKeyHash[:]...)
+			signed = append(signed, byte(len(tbs)>>16), byte(len(tbs)>>8), byte(len(tbs)))
+			signed = append(signed, tbs...)
+			signed = binary.BigEndian.AppendUint16(signed, uint16(len(sct.extensions)))
+			signed = append(signed, sct.extensions...)
+			if sct.hash == tlsHashSHA256 && verifyMessageSignature(pub, signed, sct.signature) == nil {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no SCT of the certificate is signed by a CT log of the trust root")
+}
+
+// embeddedSCTs returns the SCTs of the SignedCertificateTimestampList
+// extension of cert, none without one.
+func embeddedSCTs(cert *x509.Certificate) ([]signedCertificateTimestamp, error) {
+	malformed := errors.New("malformed SCT list of the certificate")
+	var list []byte
+	for _, extension := range cert.Extensions {
+		if extension.Id.Equal(sctListOID) {
+			if rest, err := asn1.Unmarshal(extension.Value, &list); err != nil || len(rest) > 0 {
+				return nil, malformed
+			}
+		}
+	}
+	if list == nil {
+		return nil, nil
+	}
+	list, rest, ok := tlsVector(list)
+	if !ok || len(rest) > 0 {
+		return nil, malformed
+	}
+	var scts []signedCertificateTimestamp
+	for len(list) > 0 {
+		var raw []byte
+		if raw, list, ok = tlsVector(list); !ok {
+			return nil, malformed
+		}
+		// Version v1, the 32 bytes log ID and the 8 bytes timestamp
+		if len(raw) < 41 || raw[0] != 0 {
+			return nil, malformed
+		}
+		sct := signedCertificateTimestamp{logID: raw[1:33], timestamp: binary.BigEndian.Uint64(raw[33:41])}
+		if sct.extensions, rest, ok = tlsVector(raw[41:]); !ok || len(rest) < 2 {
+			return nil, malformed
+		}
+		sct.hash = rest[0]
+		if sct.signature, rest, ok = tlsVector(rest[2:]); !ok || len(rest) > 0 {
+			return nil, malformed
+		}
+		scts = append(scts, sct)
+	}
+	return scts, nil
+}
+
+// tlsVector splits the opaque vector with a 2 bytes length at the start of b
+// from the rest of b.
+func tlsVector(b []byte) ([]byte, []byte, bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return b[2 : 2+n], b[2+n:], true
+}
+
+// precertificateTBS returns the TBSCertificate of cert without its SCT list
+// extension: that of the precertificate the CT logs signed.
+func precertificateTBS(cert *x509.Certificate) ([]byte, error) {
+	var tbs asn1.RawValue
+	if _, err := asn1.Unmarshal(cert.RawTBSCertificate, &tbs); err != nil {
+		return nil, err
+	}
+	var fields []byte
+	for rest := tbs.Bytes; len(rest) > 0; {
+		var field asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return nil, err
+		}
+		// The extensions are the explicitly tagged [3] field
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			fields = append(fields, field.FullBytes...)
+			continue
+		}
+		var extensions []asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &extensions); err != nil {
+			return nil, err
+		}
+		var kept []byte
+		for _, raw := range extensions {
+			var extension pkix.Extension
+			if _, err := asn1.Unmarshal(raw.FullBytes, &extension); err != nil {
+				return nil, err
+			}
+			if !extension.Id.Equal(sctListOID) {
+				kept = append(kept, raw.FullBytes...)
+			}
+		}
+		sequence, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: kept})
+		if err != nil {
+			return nil, err
+		}
+		explicit, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: sequence})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, explicit...)
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+// verifyMessageSignature verifies the signature of message by pub: of its
+// SHA-256 for ECDSA and RSA PKCS #1 v1.5 keys, of message itself for Ed25519
+// keys.
+func verifyMessageSignature(pub crypto.PublicKey, message, signature []byte) error {
+	digest := sha256.Sum256(message)
+	var valid bool
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, signature)
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	if !valid {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// dssePAE returns the DSSE pre-authentication encoding of payload, which its
+// signatures sign.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// trustRootSigstoreKeys returns the trust material of a TrustRoot: that of
+// its sigstoreKeys spec, whatever the time, or that of the repository of its
+// repository spec, archived under archivePrefix, in effect at the time.
+func trustRootSigstoreKeys(trustRoot trustRootObject, archivePrefix string) (SigstoreKeysAt, error) {
+	spec := trustRoot.Spec.Repository
+	if spec == nil {
+		keys := trustRoot.sigstoreKeys()
+		return func(time.Time) (SigstoreKeys, error) { return keys, nil }, nil
+	}
+	layout := assembler.ArchiveLayout{Prefix: archivePrefix, TargetsDir: spec.Targets}
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	return func(at time.Time) (SigstoreKeys, error) {
+		return RepositorySigstoreKeys(bytes.NewReader(spec.MirrorFS), spec.Root, layout, at)
+	}, nil
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+)
+
+// testSigner is a Fulcio certificate authority, CT log and Rekor log signing
+// image signatures the way cosign does.
+type testSigner struct {
+	caKey *ecdsa.PrivateKey
+	ca    *x509.Certificate
+	rekor *ecdsa.PrivateKey
+	// ct signs the SCT embedded in certificates, none if nil
+	ct      *ecdsa.PrivateKey
+	keys    SigstoreKeys
+	logged  time.Time
+	subject string
+}
+
+// newTestSigner returns a testSigner and the trust material of its CA and logs.
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	s := &testSigner{logged: time.Now().Add(-time.Hour).Truncate(time.Second), subject: "https://github.com/org/app/.github/workflows/release.yml@refs/heads/main"}
+	var err error
+	if s.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"example.com"}, CommonName: "fulcio"},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &s.caKey.PublicKey, s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := NewCertificateAuthority("https://fulcio.example.com", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rekorPEM []byte
+	s.rekor, rekorPEM = testRekorKey(t)
+	tlog, err := newTransparencyLog("https://rekor.example.com", rekorPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ctPEM []byte
+	s.ct, ctPEM = testRekorKey(t)
+	ctlog, err := newTransparencyLog("https://ctfe.example.com", ctPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.keys = SigstoreKeys{CertificateAuthorities: []CertificateAuthority{ca}, TLogs: []TransparencyLogInstance{tlog}, CTLogs: []TransparencyLogInstance{ctlog}}
+	return s
+}
+
+// keysAt returns the trust material of the signer, whatever the time.
+func (s *testSigner) keysAt(time.Time) (SigstoreKeys, error) {
+	return s.keys, nil
+}
+
+// certificate returns a short-lived code signing certificate of key for
+// subject, valid when the signature is logged, embedding the SCT of its
+// precertificate signed by the CT log.
+func (s *testSigner) certificate(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	t.Helper()
+	subject, _ := url.Parse(s.subject)
+	issuer, _ := asn1.MarshalWithParams("https://token.actions.githubusercontent.com", "utf8")
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       s.logged.Add(-time.Minute),
+		NotAfter:        s.logged.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{subject},
+		ExtraExtensions: []pkix.Extension{{Id: fulcioIssuerV2OID, Value: issuer}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.ca, &key.PublicKey, s.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ct != nil {
+		precert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logID, _ := LogID(s.keys.CTLogs[0].PublicKey)
+		id, _ := hex.DecodeString(logID)
+		timestamp := uint64(s.logged.UnixMilli())
+		issuerKeyHash := sha256.Sum256(s.ca.RawSubjectPublicKeyInfo)
+		tbs := precert.RawTBSCertificate
+		signed := binary.BigEndian.AppendUint64([]byte{0, 0}, timestamp)
+		signed = append(append(signed, 0, 1), issuerKeyHash[:]...)
+		signed = append(signed, byte(len(tbs)>>16), byte(len(tbs)>>8), byte(len(tbs)))
+		signed = append(append(signed, tbs...), 0, 0)
+		digest := sha256.Sum256(signed)
+		signature, err := ecdsa.SignASN1(rand.Reader, s.ct, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sct := append([]byte{0}, id...)
+		sct = binary.BigEndian.AppendUint64(sct, timestamp)
+		sct = append(sct, 0, 0, tlsHashSHA256, tlsSignatureECDSA)
+		sct = append(binary.BigEndian.AppendUint16(sct, uint16(len(signature))), signature...)
+		list := binary.BigEndian.AppendUint16(nil, uint16(len(sct)))
+		list = append(binary.BigEndian.AppendUint16(nil, uint16(len(list)+len(sct))), append(list, sct...)...)
+		value, _ := asn1.Marshal(list)
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: sctListOID, Value: value})
+		if der, err = x509.CreateCertificate(rand.Reader, template, s.ca, &key.PublicKey, s.caKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// bundle returns the Rekor bundle of the entry body, signed by the log.
+func (s *testSigner) bundle(t *testing.T, body any) string {
+	t.Helper()
+	entry, _ := json.Marshal(body)
+	logID, _ := LogID(s.keys.TLogs[0].PublicKey)
+	payload := rekorBundlePayload{Body: base64.StdEncoding.EncodeToString(entry), IntegratedTime: s.logged.Unix(), LogID: logID, LogIndex: 42}
+	canonical, _ := json.Marshal(payload)
+	digest := sha256.Sum256(canonical)
+	set, err := ecdsa.SignASN1(rand.Reader, s.rekor, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, _ := json.Marshal(rekorBundle{SignedEntryTimestamp: set, Payload: payload})
+	return string(bundle)
+}
+
+// testArtifact is a layer of a cosign manifest and its blob.
+type testArtifact struct {
+	layer cosignLayer
+	blob  []byte
+}
+
+// sign returns a signature layer of the simple signing payload of digest.
+func (s *testSigner) sign(t *testing.T, digest string) testArtifact {
+	t.Helper()
+	return s.signLogged(t, digest, func(cert []byte, key *ecdsa.PrivateKey) []byte { return cert })
+}
+
+// signLogged returns a signature layer like sign, whose Rekor entry logs the
+// key returned by logged for the certificate and key of the signature.
+func (s *testSigner) signLogged(t *testing.T, digest string, logged func(cert []byte, key *ecdsa.PrivateKey) []byte) testArtifact {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload := []byte(`{"critical":{"identity":{"docker-reference":"registry.example.com/org/app"},"image":{"docker-manifest-digest":"` + digest + `"},"type":"cosign container image signature"},"optional":null}`)
+	sum := sha256.Sum256(payload)
+	signature, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	cert := s.certificate(t, key)
+	entry := map[string]any{"apiVersion": "0.0.1", "kind": "hashedrekord", "spec": map[string]any{
+		"data":      map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])}},
+		"signature": map[string]any{"content": signature, "publicKey": map[string]any{"content": logged(cert, key)}},
+	}}
+	return testArtifact{cosignLayer{Annotations: map[string]string{
+		cosignSignatureAnnotation:   base64.StdEncoding.EncodeToString(signature),
+		cosignCertificateAnnotation: string(cert),
+		cosignBundleAnnotation:      s.bundle(t, entry),
+	}}, payload}
+}
+
+// attest returns an attestation layer of the DSSE envelope of an SLSA
+// provenance of digest.
+func (s *testSigner) attest(t *testing.T, digest string) testArtifact {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	statement := []byte(`{"_type":"https://in-toto.io/Statement/v0.1","predicateType":"https://slsa.dev/provenance/v0.2","subject":[{"name":"registry.example.com/org/app","digest":{"sha256":"` + strings.TrimPrefix(digest, "sha256:") + `"}}],"predicate":{}}`)
+	pae := dssePAE("application/vnd.in-toto+json", statement)
+	sum := sha256.Sum256(pae)
+	signature, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	envelope, _ := json.Marshal(map[string]any{"payloadType": "application/vnd.in-toto+json", "payload": statement, "signatures": []map[string]any{{"sig": signature}}})
+	payloadSum := sha256.Sum256(statement)
+	cert := s.certificate(t, key)
+	entry := map[string]any{"apiVersion": "0.0.2", "kind": "intoto", "spec": map[string]any{
+		"content": map[string]any{
+			"envelope":    map[string]any{"payloadType": "application/vnd.in-toto+json", "signatures": []map[string]any{{"sig": signature, "publicKey": cert}}},
+			"payloadHash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadSum[:])},
+		},
+	}}
+	return testArtifact{cosignLayer{Annotations: map[string]string{
+		cosignSignatureAnnotation:   "",
+		cosignCertificateAnnotation: string(cert),
+		cosignBundleAnnotation:      s.bundle(t, entry),
+	}}, envelope}
+}
+
+// testCosignRegistry serves the cosign manifests of org/app by tag and their
+// layer blobs.
+func testCosignRegistry(t *testing.T, manifests map[string][]testArtifact) *httptest.Server {
+	t.Helper()
+	content := map[string][]byte{}
+	for tag, artifacts := range manifests {
+		var layers []cosignLayer
+		for _, artifact := range artifacts {
+			sum := sha256.Sum256(artifact.blob)
+			artifact.layer.Digest = "sha256:" + hex.EncodeToString(sum[:])
+			content["/v2/org/app/blobs/"+artifact.layer.Digest] = artifact.blob
+			layers = append(layers, artifact.layer)
+		}
+		manifest, _ := json.Marshal(map[string]any{"schemaVersion": 2, "layers": layers})
+		content["/v2/org/app/manifests/"+tag] = manifest
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := content[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(body)
+	}))
+}
+
+func TestPreflightImage(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	tag := "sha256-" + strings.Repeat("ab", 32)
+	options := PreflightOptions{IssuerRegExp: "^https://token.actions.githubusercontent.com$", SubjectRegExp: "^https://github.com/org/"}
+	withBundleOf := func(artifact, of testArtifact) testArtifact {
+		artifact.layer.Annotations[cosignBundleAnnotation] = of.layer.Annotations[cosignBundleAnnotation]
+		return artifact
+	}
+	unlogged := *signer
+	unlogged.ct = nil
+	forged := *signer
+	forged.ct = other.ct
+	// effectiveAfter returns the trust material of the signer with the
+	// entries selected by clear only in effect after the signature is logged
+	effectiveAfter := func(clear func(keys *SigstoreKeys)) SigstoreKeysAt {
+		return func(at time.Time) (SigstoreKeys, error) {
+			keys := signer.keys
+			if !at.After(signer.logged) {
+				clear(&keys)
+			}
+			return keys, nil
+		}
+	}
+
+	tests := []struct {
+		name         string
+		signatures   []testArtifact
+		attestations []testArtifact
+		options      PreflightOptions
+		// keys is the trust material, that of the signer if nil
+		keys    SigstoreKeysAt
+		wantErr bool
+		// want is the number of verified signatures and attestations
+		want [2]int
+	}{
+		{
+			name:         "signed and attested",
+			signatures:   []testArtifact{signer.sign(t, digest)},
+			attestations: []testArtifact{signer.attest(t, digest)},
+			options:      PreflightOptions{IssuerRegExp: options.IssuerRegExp, SubjectRegExp: options.SubjectRegExp, RequireAttestation: true},
+			want:         [2]int{1, 1},
+		},
+		{
+			name:       "one signature of another trust root",
+			signatures: []testArtifact{other.sign(t, digest), signer.sign(t, digest)},
+			want:       [2]int{1, 0},
+		},
+		{
+			name:    "unsigned",
+			wantErr: true,
+		},
+		{
+			name:       "signed by another trust root",
+			signatures: []testArtifact{other.sign(t, digest)},
+			wantErr:    true,
+		},
+		{
+			name:       "logged by another log",
+			signatures: []testArtifact{withBundleOf(signer.sign(t, digest), other.sign(t, digest))},
+			wantErr:    true,
+		},
+		{
+			name:       "logged entry of another signature",
+			signatures: []testArtifact{withBundleOf(signer.sign(t, digest), signer.sign(t, digest))},
+			wantErr:    true,
+		},
+		{
+			name: "logged public key of the certificate",
+			signatures: []testArtifact{signer.signLogged(t, digest, func(cert []byte, key *ecdsa.PrivateKey) []byte {
+				der, _ := x509.MarshalPKIXPublicKey(key.Public())
+				return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+			})},
+			want: [2]int{1, 0},
+		},
+		{
+			name: "logged key of another certificate",
+			signatures: []testArtifact{signer.signLogged(t, digest, func(cert []byte, key *ecdsa.PrivateKey) []byte {
+				other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				return signer.certificate(t, other)
+			})},
+			wantErr: true,
+		},
+		{
+			name:       "logged without key",
+			signatures: []testArtifact{signer.signLogged(t, digest, func(cert []byte, key *ecdsa.PrivateKey) []byte { return nil })},
+			wantErr:    true,
+		},
+		{
+			name:       "certificate without SCT",
+			signatures: []testArtifact{unlogged.sign(t, digest)},
+			wantErr:    true,
+		},
+		{
+			name:       "SCT of another CT log",
+			signatures: []testArtifact{forged.sign(t, digest)},
+			wantErr:    true,
+		},
+		{
+			name:       "certificate authority not in effect when logged",
+			signatures: []testArtifact{signer.sign(t, digest)},
+			keys:       effectiveAfter(func(keys *SigstoreKeys) { keys.CertificateAuthorities = nil }),
+			wantErr:    true,
+		},
+		{
+			name:       "CT log not in effect at the SCT timestamp",
+			signatures: []testArtifact{signer.sign(t, digest)},
+			keys:       effectiveAfter(func(keys *SigstoreKeys) { keys.CTLogs = nil }),
+			wantErr:    true,
+		},
+		{
+			name:       "trust material in effect when logged",
+			signatures: []testArtifact{signer.sign(t, digest)},
+			keys: func(at time.Time) (SigstoreKeys, error) {
+				if !at.Equal(signer.logged) {
+					return SigstoreKeys{}, nil
+				}
+				return signer.keys, nil
+			},
+			want: [2]int{1, 0},
+		},
+		{
+			name:       "signature of another image",
+			signatures: []testArtifact{signer.sign(t, "sha256:"+strings.Repeat("cd", 32))},
+			wantErr:    true,
+		},
+		{
+			name:         "attestation of another image",
+			signatures:   []testArtifact{signer.sign(t, digest)},
+			attestations: []testArtifact{signer.attest(t, "sha256:"+strings.Repeat("cd", 32))},
+			options:      PreflightOptions{IssuerRegExp: ".*", SubjectRegExp: ".*", RequireAttestation: true},
+			wantErr:      true,
+		},
+		{
+			name:       "unexpected identity",
+			signatures: []testArtifact{signer.sign(t, digest)},
+			options:    PreflightOptions{IssuerRegExp: options.IssuerRegExp, SubjectRegExp: "^https://github.com/other/"},
+			wantErr:    true,
+		},
+		{
+			name:       "attestation required but missing",
+			signatures: []testArtifact{signer.sign(t, digest)},
+			options:    PreflightOptions{IssuerRegExp: ".*", SubjectRegExp: ".*", RequireAttestation: true},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests := map[string][]testArtifact{}
+			if len(tt.signatures) > 0 {
+				manifests[tag+".sig"] = tt.signatures
+			}
+			if len(tt.attestations) > 0 {
+				manifests[tag+".att"] = tt.attestations
+			}
+			registry := testCosignRegistry(t, manifests)
+			defer registry.Close()
+			fetcher, err := newOCIFetcher("http", strings.TrimPrefix(registry.URL, "http://")+"/org/app@"+digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := tt.options
+			if opts.IssuerRegExp == "" {
+				opts = options
+			}
+			keys := tt.keys
+			if keys == nil {
+				keys = signer.keysAt
+			}
+			result, err := PreflightImage(fetcher, keys, opts)
+			if tt.wantErr {
+				if !errors.Is(err, assembler.ErrVerification) {
+					t.Fatalf("PreflightImage() error = %v, want %v", err, assembler.ErrVerification)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PreflightImage() error = %v", err)
+			}
+			if got := [2]int{len(result.Signatures), len(result.Attestations)}; got != tt.want {
+				t.Fatalf("verified = %v, want %v, rejected %q", got, tt.want, result.Rejected)
+			}
+			verified := result.Signatures[0]
+			if verified.Subject != signer.subject || verified.Issuer != "https://token.actions.githubusercontent.com" || verified.LogIndex != 42 || !verified.IntegratedTime.Equal(signer.logged) {
+				t.Errorf("signature = %+v, want the identity and log entry of the signer", verified)
+			}
+			if len(result.Attestations) > 0 && result.Attestations[0].PredicateType != "https://slsa.dev/provenance/v0.2" {
+				t.Errorf("predicate type = %q, want https://slsa.dev/provenance/v0.2", result.Attestations[0].PredicateType)
+			}
+		})
+	}
+
+	t.Run("tag reference", func(t *testing.T) {
+		fetcher, err := newOCIFetcher("http", "registry.example.com/org/app:latest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PreflightImage(fetcher, signer.keysAt, options); err == nil {
+			t.Error("PreflightImage() error = nil, want an error for a tag reference")
+		}
+	})
+
+	t.Run("no identity", func(t *testing.T) {
+		fetcher, err := newOCIFetcher("http", "registry.example.com/org/app@"+digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := PreflightImage(fetcher, signer.keysAt, PreflightOptions{IssuerRegExp: options.IssuerRegExp}); err == nil {
+			t.Error("PreflightImage() error = nil, want an error without a subject regular expression")
+		}
+	})
+}
+
+func TestTrustRootSigstoreKeys(t *testing.T) {
+	keys := testSigstoreKeys(t)
+	tests := []struct {
+		name     string
+		manifest []byte
+		want     int
+	}{
+		{"sigstoreKeys", []byte(RenderSigstoreKeysTrustRoot("sigstore", keys)), 2},
+		// The targets of the mockmirror have no Sigstore custom metadata
+		{"repository", testTrustRootObject(t, testRepositorySpec(t)), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustRoot, err := ReadTrustRoot(tt.manifest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keysAt, err := trustRootSigstoreKeys(trustRoot, "")
+			if err != nil {
+				t.Fatalf("trustRootSigstoreKeys() error = %v", err)
+			}
+			got, err := keysAt(now())
+			if err != nil {
+				t.Fatalf("trustRootSigstoreKeys() error = %v", err)
+			}
+			if n := len(got.CertificateAuthorities) + len(got.TLogs); n != tt.want {
+				t.Errorf("trustRootSigstoreKeys() = %d authorities and logs, want %d", n, tt.want)
+			}
+		})
+	}
+}
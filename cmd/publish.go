@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache-Control headers of published repository files. Versioned metadata and
+// hash-prefixed targets never change, timestamp.json changes on every refresh.
+const (
+	cacheControlImmutable = "public, max-age=31536000, immutable"
+	cacheControlTimestamp = "public, max-age=60"
+)
+
+// publisher uploads files to remote storage.
+type publisher interface {
+	put(key string, body []byte, contentType, cacheControl string) error
+}
+
+// PublishDirectory uploads a repository laid out by MirrorSync to dest, which
+// is selected by URL scheme:
+//
+//   - s3://bucket/prefix: AWS S3 or compatible storage, with AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+//     AWS_SESSION_TOKEN, AWS_REGION and AWS_ENDPOINT_URL_S3 read from the environment,
+//   - gs://bucket/prefix: Google Cloud Storage, with GOOGLE_OAUTH_ACCESS_TOKEN or the metadata server token,
+//   - webdav://host/path or webdavs://host/path: WebDAV, with optional basic auth user info,
+//   - sftp://user@host/path: SFTP through the system sftp client and its SSH configuration.
+//
+// Targets are uploaded first, then versioned metadata, and timestamp.json last,
+// so clients never see a timestamp referencing files that are not there yet.
+//
+// Parameters:
+//   - dir: The repository directory.
+//   - dest: The destination URL.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func PublishDirectory(dir, dest string) error {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return fmt.Errorf("invalid publish destination %q: %v", dest, err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	var p publisher
+	switch u.Scheme {
+	case "s3":
+		p, err = newS3Publisher(u.Host, prefix)
+	case "gs":
+		p, err = newGCSPublisher(u.Host, prefix)
+	case "webdav", "webdavs":
+		p = newWebDAVPublisher(u)
+	case "sftp":
+		return publishSFTP(dir, u)
+	default:
+		return fmt.Errorf("unsupported publish destination scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return err
+	}
+	files, err := publishOrder(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		body, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		if err := p.put(file, body, contentType(file), cacheControl(file)); err != nil {
+			return fmt.Errorf("could not publish %s: %v", file, err)
+		}
+	}
+	log.Printf("published %d files to %s\n", len(files), dest)
+	return nil
+}
+
+// publishOrder returns the slash separated paths of the files of dir in the
+// order clients resolve them backwards: target files first, then root and
+// targets metadata, then snapshot metadata, which references the targets
+// metadata, then timestamp.json, which references the snapshot.
+func publishOrder(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	rank := func(file string) int {
+		switch {
+		case strings.HasPrefix(file, "targets/"):
+			return 0
+		case file == "timestamp.json":
+			return 3
+		case file == "snapshot.json" || strings.HasSuffix(file, ".snapshot.json"):
+			return 2
+		default:
+			return 1
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		if rank(files[i]) != rank(files[j]) {
+			return rank(files[i]) < rank(files[j])
+		}
+		return files[i] < files[j]
+	})
+	return files, nil
+}
+
+// contentType returns the Content-Type of a repository file.
+func contentType(file string) string {
+	switch path.Ext(file) {
+	case ".json":
+		return "application/json"
+	case ".pem", ".pub":
+		return "application/x-pem-file"
+	}
+	if t := mime.TypeByExtension(path.Ext(file)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// cacheControl returns the Cache-Control of a repository file.
+func cacheControl(file string) string {
+	if file == "timestamp.json" || file == "root.json" {
+		return cacheControlTimestamp
+	}
+	return cacheControlImmutable
+}
+
+// webDAVPublisher uploads files with WebDAV PUT requests.
+type webDAVPublisher struct {
+	base    *url.URL
+	created map[string]bool
+}
+
+func newWebDAVPublisher(u *url.URL) *webDAVPublisher {
+	base := *u
+	base.Scheme = "http"
+	if u.Scheme == "webdavs" {
+		base.Scheme = "https"
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	return &webDAVPublisher{base: &base, created: map[string]bool{}}
+}
+
+func (w *webDAVPublisher) do(method, key string, body []byte, headers map[string]string) (*http.Response, error) {
+	target := *w.base
+	target.User = nil
+	target.Path = w.base.Path + "/" + key
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if w.base.User != nil {
+		password, _ := w.base.User.Password()
+		req.SetBasicAuth(w.base.User.Username(), password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func (w *webDAVPublisher) put(key string, body []byte, contentType, cacheControl string) error {
+	// Create the parent collections, MKCOL answers 405 when one already exists
+	dir := ""
+	for _, part := range strings.Split(path.Dir(key), "/") {
+		if part == "." {
+			break
+		}
+		dir = path.Join(dir, part)
+		if w.created[dir] {
+			continue
+		}
+		resp, err := w.do("MKCOL", dir, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("MKCOL %s: %s", dir, resp.Status)
+		}
+		w.created[dir] = true
+	}
+	resp, err := w.do(http.MethodPut, key, body, map[string]string{"Content-Type": contentType, "Cache-Control": cacheControl})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+// s3Publisher uploads files with AWS Signature Version 4 signed PUT requests.
+type s3Publisher struct {
+	endpoint     *url.URL
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	now          func() time.Time
+}
+
+func newS3Publisher(bucket, prefix string) (*s3Publisher, error) {
+	s, err := newS3Storage(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to publish to S3")
+	}
+	return s, nil
+}
+
+// newS3Storage returns an s3Publisher configured from the environment, with
+// empty credentials if none are set, which is enough to read public buckets.
+func newS3Storage(bucket, prefix string) (*s3Publisher, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	// Custom endpoints use path-style addressing, AWS virtual-hosted-style
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	} else {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Publisher{
+		endpoint:     u,
+		bucket:       bucket,
+		prefix:       prefix,
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		now:          time.Now,
+	}, nil
+}
+
+func (s *s3Publisher) put(key string, body []byte, contentType, cacheControl string) error {
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + path.Join(s.prefix, key)
+	req, err := http.NewRequest(http.MethodPut, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", cacheControl)
+	s.sign(req, body)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %s: %s", target.Path, resp.Status)
+	}
+	return nil
+}
+
+// get downloads key, signing the request when credentials are configured.
+func (s *s3Publisher) get(key string) (*http.Response, error) {
+	target := *s.endpoint
+	target.Path = strings.TrimSuffix(s.endpoint.Path, "/") + "/" + path.Join(s.prefix, key)
+	req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.accessKey != "" {
+		s.sign(req, nil)
+	}
+	return httpClient.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (s *s3Publisher) sign(req *http.Request, body []byte) {
+	signAWSRequest(req, body, "s3", s.region, awsCredentials{accessKey: s.accessKey, secretKey: s.secretKey, sessionToken: s.sessionToken}, s.now())
+}
+
+// gcsPublisher uploads files with the Cloud Storage XML API.
+type gcsPublisher struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	token    string
+}
+
+func newGCSPublisher(bucket, prefix string) (*gcsPublisher, error) {
+	token, err := gcpAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	return &gcsPublisher{endpoint: "https://storage.googleapis.com", bucket: bucket, prefix: prefix, token: token}, nil
+}
+
+// gcpAccessToken returns GOOGLE_OAUTH_ACCESS_TOKEN or else a token of the
+// metadata server.
+func gcpAccessToken() (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	token, err := gcpMetadataToken()
+	if err != nil {
+		return "", fmt.Errorf("GOOGLE_OAUTH_ACCESS_TOKEN is not set and no metadata server token is available: %v", err)
+	}
+	return token, nil
+}
+
+// gcpMetadataToken returns an access token of the default service account from
+// the GCE/GKE metadata server.
+func gcpMetadataToken() (string, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (g *gcsPublisher) put(key string, body []byte, contentType, cacheControl string) error {
+	object := path.Join(g.prefix, key)
+	req, err := http.NewRequest(http.MethodPut, g.endpoint+"/"+g.bucket+"/"+object, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cache-Control", cacheControl)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT gs://%s/%s: %s", g.bucket, object, resp.Status)
+	}
+	return nil
+}
+
+// get downloads key with the Cloud Storage XML API.
+func (g *gcsPublisher) get(key string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, g.endpoint+"/"+g.bucket+"/"+path.Join(g.prefix, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	return httpClient.Do(req)
+}
+
+// publishSFTP uploads dir with the system sftp client in batch mode. SFTP has
+// no notion of content types or cache headers, the serving web server sets them.
+func publishSFTP(dir string, u *url.URL) error {
+	files, err := publishOrder(dir)
+	if err != nil {
+		return err
+	}
+	remote := strings.TrimSuffix(u.Path, "/")
+	created := map[string]bool{}
+	for _, file := range files {
+		for d := path.Dir(file); d != "." && !created[d]; d = path.Dir(d) {
+			created[d] = true
+		}
+	}
+	// A leading "-" tells sftp to ignore failures, mkdir fails for existing directories
+	var batch strings.Builder
+	var commands [][]string
+	if remote != "" {
+		commands = append(commands, []string{"-mkdir", remote})
+	}
+	for _, d := range sortedKeys(created) {
+		commands = append(commands, []string{"-mkdir", remote + "/" + d})
+	}
+	for _, file := range files {
+		commands = append(commands, []string{"put", filepath.Join(dir, filepath.FromSlash(file)), remote + "/" + file})
+	}
+	for _, command := range commands {
+		for _, p := range command[1:] {
+			if err := checkSFTPPath(p); err != nil {
+				return err
+			}
+		}
+		batch.WriteString(strings.Join(command, " ") + "\n")
+	}
+	batchFile, err := os.CreateTemp(tempDir(), "sftp-batch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(batchFile.Name())
+	if _, err := batchFile.WriteString(batch.String()); err != nil {
+		return err
+	}
+	batchFile.Close()
+
+	host := u.Host
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	args := []string{"-b", batchFile.Name()}
+	if u.Port() != "" {
+		args = append(args, "-P", u.Port())
+		host = strings.TrimSuffix(host, ":"+u.Port())
+	}
+	cmd := exec.Command("sftp", append(args, host)...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sftp: %v", err)
+	}
+	log.Printf("published %d files to %s\n", len(files), u.Redacted())
+	return nil
+}
+
+// sftpPathPattern matches the paths written to sftp batch files unquoted.
+var sftpPathPattern = regexp.MustCompile(`^[A-Za-z0-9._+,:=@%~/-]+$`)
+
+// checkSFTPPath returns an error if p cannot be written to an sftp batch file
+// as is. sftp splits batch lines on whitespace, has its own quoting and
+// escaping rules and expands glob characters in local paths, so paths needing
+// any of them, or starting with "-" like an option, are rejected instead of
+// being quoted.
+func checkSFTPPath(p string) error {
+	if p == "" || strings.HasPrefix(p, "-") || !sftpPathPattern.MatchString(p) {
+		return fmt.Errorf("sftp: cannot publish path %q: only letters, digits and ._+,:=@%%~/- are supported", p)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRepositoryDir writes a small mirrored repository layout in a temporary directory.
+func testRepositoryDir(t *testing.T) string {
+	t.Helper()
+	return testRepositoryFiles(t, "timestamp.json", "1.root.json", "2.snapshot.json", "targets/abc.rekor.pub")
+}
+
+// testRepositoryFiles writes the named files in a temporary directory.
+func testRepositoryFiles(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// recordedRequest is a request received by a test storage server.
+type recordedRequest struct {
+	method, path, contentType, cacheControl, authorization, body string
+}
+
+// newRecordingServer returns a server recording every request it receives.
+func newRecordingServer(t *testing.T) (*httptest.Server, *[]recordedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	requests := &[]recordedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		*requests = append(*requests, recordedRequest{r.Method, r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Cache-Control"), r.Header.Get("Authorization"), string(body)})
+		mu.Unlock()
+		if r.Method == "MKCOL" && strings.Count(r.URL.Path, "/") > 2 {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestPublishOrder(t *testing.T) {
+	dir := testRepositoryFiles(t, "timestamp.json", "snapshot.json", "2.snapshot.json", "2.targets.json", "1.registry.json", "1.root.json", "2.root.json", "targets/abc.rekor.pub")
+	got, err := publishOrder(dir)
+	if err != nil {
+		t.Fatalf("publishOrder() error = %v", err)
+	}
+	want := []string{"targets/abc.rekor.pub", "1.registry.json", "1.root.json", "2.root.json", "2.targets.json", "2.snapshot.json", "snapshot.json", "timestamp.json"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("publishOrder() = %v, want %v", got, want)
+	}
+}
+
+func TestCheckSFTPPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/srv/tuf/targets/0a1b.trusted_root.json", false},
+		{"repo/1.root.json", false},
+		{"/srv/tuf mirror/1.root.json", true},
+		{`/srv/tuf/"quoted".json`, true},
+		{"/srv/tuf/*.json", true},
+		{"/srv/tuf/\u00e9.json", true},
+		{"/srv/tuf/1.root.json\nrm 1.root.json", true},
+		{"-P", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if err := checkSFTPPath(tt.path); (err != nil) != tt.wantErr {
+				t.Errorf("checkSFTPPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishDirectory(t *testing.T) {
+	tests := []struct {
+		name     string
+		dest     func(server *httptest.Server) string
+		env      map[string]string
+		wantPuts []string
+		wantAuth string
+		wantErr  bool
+	}{
+		{
+			name: "webdav",
+			dest: func(server *httptest.Server) string {
+				return strings.Replace(server.URL, "http://", "webdav://user:secret@", 1) + "/repo/"
+			},
+			wantPuts: []string{"/repo/targets/abc.rekor.pub", "/repo/1.root.json", "/repo/2.snapshot.json", "/repo/timestamp.json"},
+			wantAuth: "Basic ",
+		},
+		{
+			name: "s3 compatible endpoint",
+			dest: func(server *httptest.Server) string { return "s3://bucket/prefix" },
+			env: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+				"AWS_REGION":            "eu-west-1",
+			},
+			wantPuts: []string{"/bucket/prefix/targets/abc.rekor.pub", "/bucket/prefix/1.root.json", "/bucket/prefix/2.snapshot.json", "/bucket/prefix/timestamp.json"},
+			wantAuth: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/",
+		},
+		{
+			name:    "s3 without credentials",
+			dest:    func(server *httptest.Server) string { return "s3://bucket/prefix" },
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_SECRET_ACCESS_KEY": ""},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported scheme",
+			dest:    func(server *httptest.Server) string { return "ftp://host/repo" },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newRecordingServer(t)
+			t.Setenv("AWS_ENDPOINT_URL_S3", server.URL)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			err := PublishDirectory(testRepositoryDir(t), tt.dest(server))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PublishDirectory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var puts []string
+			for _, r := range *requests {
+				if r.method != http.MethodPut {
+					continue
+				}
+				puts = append(puts, r.path)
+				if !strings.HasPrefix(r.authorization, tt.wantAuth) {
+					t.Errorf("PUT %s authorization = %q, want prefix %q", r.path, r.authorization, tt.wantAuth)
+				}
+				if strings.HasSuffix(r.path, ".json") && r.contentType != "application/json" {
+					t.Errorf("PUT %s content type = %q", r.path, r.contentType)
+				}
+				wantCache := cacheControlImmutable
+				if strings.HasSuffix(r.path, "/timestamp.json") {
+					wantCache = cacheControlTimestamp
+				}
+				if r.cacheControl != wantCache {
+					t.Errorf("PUT %s cache control = %q, want %q", r.path, r.cacheControl, wantCache)
+				}
+			}
+			if !reflect.DeepEqual(puts, tt.wantPuts) {
+				t.Errorf("PublishDirectory() uploaded %v, want %v", puts, tt.wantPuts)
+			}
+		})
+	}
+}
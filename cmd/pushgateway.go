@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushgatewayUsage is the usage of the --pushgateway flag.
+const pushgatewayUsage = "Prometheus Pushgateway URL receiving the success, duration, output and archive sizes and metadata expiries of the run when it ends, for CronJobs without a scrape endpoint"
+
+var (
+	// pushgateway is the --pushgateway URL and pushgatewayJob the job of its
+	// metrics, set by --pushgateway-job.
+	pushgateway    string
+	pushgatewayJob = "trustroot-assembler"
+	// runStarted is the start of the run, for its duration.
+	runStarted = time.Now()
+)
+
+// RunMetrics are the metrics of a run pushed to a Prometheus Pushgateway.
+type RunMetrics struct {
+	Success  bool
+	ExitCode int
+	Started  time.Time
+	Finished time.Time
+	// OutputSize and ArchiveSize are the sizes of the printed output and of
+	// the repository archive, 0 if unknown, e.g. for failed runs.
+	OutputSize  int64
+	ArchiveSize int64
+	// Expires are the expiries of the top-level metadata by role file name.
+	Expires map[string]time.Time
+}
+
+// Exposition returns the metrics in the Prometheus text exposition format.
+// Failed runs leave out the last success timestamp and the sizes, so pushing
+// them keeps the values of the last successful run.
+func (m RunMetrics) Exposition() []byte {
+	var b bytes.Buffer
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	success := 0.0
+	if m.Success {
+		success = 1
+	}
+	gauge("trustroot_assembler_success", "Whether the last run succeeded.", success)
+	gauge("trustroot_assembler_exit_code", "Exit code of the last run.", float64(m.ExitCode))
+	gauge("trustroot_assembler_duration_seconds", "Duration of the last run.", m.Finished.Sub(m.Started).Seconds())
+	gauge("trustroot_assembler_last_run_timestamp_seconds", "End of the last run, in Unix time.", float64(m.Finished.Unix()))
+	if m.Success {
+		gauge("trustroot_assembler_last_success_timestamp_seconds", "End of the last successful run, in Unix time.", float64(m.Finished.Unix()))
+	}
+	if m.OutputSize > 0 {
+		gauge("trustroot_assembler_output_bytes", "Size of the output of the last successful run.", float64(m.OutputSize))
+	}
+	if m.ArchiveSize > 0 {
+		gauge("trustroot_assembler_archive_bytes", "Size of the repository archive of the last successful run.", float64(m.ArchiveSize))
+	}
+	if len(m.Expires) > 0 {
+		const name = "trustroot_assembler_metadata_expiry_timestamp_seconds"
+		fmt.Fprintf(&b, "# HELP %s Expiry of the top-level metadata of the last successful run, in Unix time.\n# TYPE %s gauge\n", name, name)
+		roles := make([]string, 0, len(m.Expires))
+		for role := range m.Expires {
+			roles = append(roles, role)
+		}
+		sort.Strings(roles)
+		for _, role := range roles {
+			fmt.Fprintf(&b, "%s{role=%q} %d\n", name, strings.TrimSuffix(role, ".json"), m.Expires[role].Unix())
+		}
+	}
+	return b.Bytes()
+}
+
+// PushMetrics POSTs metrics to the group of job on a Prometheus Pushgateway,
+// replacing the metrics of the same name in the group.
+//
+// Parameters:
+//   - gateway: The URL of the Pushgateway, e.g. http://pushgateway.monitoring:9091.
+//   - job: The job label of the metrics.
+//   - metrics: The metrics of the run.
+//
+// Returns:
+//   - error: nil if the Pushgateway answered with a 2xx status.
+func PushMetrics(gateway, job string, metrics RunMetrics) error {
+	endpoint := strings.TrimSuffix(gateway, "/") + "/metrics/job/" + url.PathEscape(job)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(metrics.Exposition()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Pushgateway %s answered %s", gateway, resp.Status)
+	}
+	return nil
+}
+
+// pushRunMetrics pushes the metrics of a successful run to the --pushgateway,
+// if set, exiting on errors: the expiries of the metadata in metadataDir, the
+// size of the archive of the repository in archiveDir, "" for none, and the
+// size of output.
+func pushRunMetrics(metadataDir, archiveDir string, output io.WriterTo) {
+	if pushgateway == "" {
+		return
+	}
+	metrics := RunMetrics{Success: true, Started: runStarted}
+	described, err := describeOutput(output)
+	if err != nil {
+		log.Fatalf("Error: could not measure output: %v", err)
+	}
+	metrics.OutputSize = described.Size
+	if archiveDir != "" {
+		counter := &countingWriter{w: io.Discard}
+		if err := compressRepository(archiveDir, counter); err != nil {
+			log.Fatalf("Error: could not measure the repository archive: %v", err)
+		}
+		metrics.ArchiveSize = int64(counter.n)
+	}
+	if metadataDir != "" {
+		if metrics.Expires, err = metadataExpiries(metadataDir); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
+	metrics.Finished = time.Now()
+	if err := PushMetrics(pushgateway, pushgatewayJob, metrics); err != nil {
+		log.Fatalf("Error: could not push metrics: %v", err)
+	}
+	log.Printf("pushed metrics to %s\n", pushgateway)
+}
+
+// pushFailureMetrics pushes the failure of the run with exitCode to the
+// --pushgateway, if set. It is called by fatalf and only logs errors, the
+// run failing anyway.
+func pushFailureMetrics(exitCode int) {
+	if pushgateway == "" {
+		return
+	}
+	metrics := RunMetrics{ExitCode: exitCode, Started: runStarted, Finished: time.Now()}
+	if err := PushMetrics(pushgateway, pushgatewayJob, metrics); err != nil {
+		log.Printf("could not push metrics: %v\n", err)
+	}
+}
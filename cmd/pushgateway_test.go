@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetricsExposition(t *testing.T) {
+	started := time.Unix(1700000000, 0)
+	expires := map[string]time.Time{"timestamp.json": time.Unix(1700600000, 0), "root.json": time.Unix(1730000000, 0)}
+	tests := []struct {
+		name    string
+		metrics RunMetrics
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "success",
+			metrics: RunMetrics{Success: true, Started: started, Finished: started.Add(1500 * time.Millisecond), OutputSize: 2048, ArchiveSize: 1024, Expires: expires},
+			want: []string{
+				"# TYPE trustroot_assembler_success gauge\ntrustroot_assembler_success 1\n",
+				"trustroot_assembler_exit_code 0\n",
+				"trustroot_assembler_duration_seconds 1.5\n",
+				"trustroot_assembler_last_success_timestamp_seconds 1700000001\n",
+				"trustroot_assembler_output_bytes 2048\n",
+				"trustroot_assembler_archive_bytes 1024\n",
+				"trustroot_assembler_metadata_expiry_timestamp_seconds{role=\"root\"} 1730000000\ntrustroot_assembler_metadata_expiry_timestamp_seconds{role=\"timestamp\"} 1700600000\n",
+			},
+		},
+		{
+			name:    "failure",
+			metrics: RunMetrics{ExitCode: exitCodeUnreachable, Started: started, Finished: started.Add(time.Second)},
+			want:    []string{"trustroot_assembler_success 0\n", "trustroot_assembler_exit_code 4\n", "trustroot_assembler_last_run_timestamp_seconds 1700000001\n"},
+			notWant: []string{"last_success", "_bytes", "expiry"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(tt.metrics.Exposition())
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Exposition() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("Exposition() = %q, want no %q", got, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusOK, false},
+		{"rejected", http.StatusBadRequest, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path, contentType, body string
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path, contentType = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type")
+				content, _ := io.ReadAll(r.Body)
+				body = string(content)
+				w.WriteHeader(tt.status)
+			}))
+			defer gateway.Close()
+
+			metrics := RunMetrics{Success: true, Started: time.Now(), Finished: time.Now()}
+			err := PushMetrics(gateway.URL+"/", "trustroot assembler", metrics)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PushMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if method != http.MethodPost || path != "/metrics/job/trustroot%20assembler" {
+				t.Errorf("request = %s %s, want POST /metrics/job/trustroot%%20assembler", method, path)
+			}
+			if !strings.HasPrefix(contentType, "text/plain") || body != string(metrics.Exposition()) {
+				t.Errorf("body = %q (%s), want the exposition of the metrics", body, contentType)
+			}
+		})
+	}
+}
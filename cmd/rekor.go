@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// rekorLogInfo is the part of the Rekor /api/v1/log response needed to check
+// the public key against the running log.
+type rekorLogInfo struct {
+	TreeID         string `json:"treeID"`
+	SignedTreeHead string `json:"signedTreeHead"`
+}
+
+// FetchRekorLog retrieves the public key of a running Rekor v1 instance from
+// /api/v1/log/publicKey and validates it against the signed tree head of
+// /api/v1/log, so a key that does not sign the log is never trusted.
+//
+// Parameters:
+//   - rekorURL: The base URL of the Rekor instance.
+//
+// Returns:
+//   - The TransparencyLogInstance of the log, identified by its log ID.
+//   - An error if the key or the log info could not be fetched, or the key does not verify the signed tree head.
+func FetchRekorLog(rekorURL string) (TransparencyLogInstance, error) {
+	rekorURL = strings.TrimSuffix(rekorURL, "/")
+	publicKey, err := fetch(rekorURL + "/api/v1/log/publicKey")
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("could not get the public key: %v", err)
+	}
+	publicKey, err = NormalizePublicKey(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("invalid public key: %v", err)
+	}
+	logID, err := LogID(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	content, err := fetch(rekorURL + "/api/v1/log")
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("could not get the log info: %v", err)
+	}
+	var info rekorLogInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("could not decode the log info: %v", err)
+	}
+	if err := verifySignedNote(info.SignedTreeHead, publicKey); err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("the public key does not verify the signed tree head: %v", err)
+	}
+	log.Printf("rekor %s: tree ID %s, log ID %s\n", rekorURL, info.TreeID, logID)
+	return TransparencyLogInstance{BaseURL: rekorURL, HashAlgorithm: "sha-256", PublicKey: publicKey, LogID: logID}, nil
+}
+
+// verifySignedNote checks that one of the signatures of a signed note, see
+// https://github.com/C2SP/C2SP/blob/main/signed-note.md, is made by the PEM
+// encoded publicKeyPEM. Rekor v1 signs the SHA-256 of the note text with
+// ECDSA or RSA keys and the text itself with Ed25519 keys.
+func verifySignedNote(note string, publicKeyPEM []byte) error {
+	split := strings.LastIndex(note, "\n\n")
+	if split < 0 {
+		return fmt.Errorf("no signatures in the signed note")
+	}
+	text, signatures := []byte(note[:split+1]), note[split+2:]
+	der, err := publicKeyDER(publicKeyPEM)
+	if err != nil {
+		return err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(text)
+	for _, line := range strings.Split(signatures, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "— "))
+		if !strings.HasPrefix(line, "— ") || len(fields) != 2 {
+			continue
+		}
+		// The signature follows the 4-byte key hint
+		signature, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(signature) <= 4 {
+			continue
+		}
+		signature = signature[4:]
+		var valid bool
+		switch key := pub.(type) {
+		case *ecdsa.PublicKey:
+			valid = ecdsa.VerifyASN1(key, digest[:], signature)
+		case *rsa.PublicKey:
+			valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+		case ed25519.PublicKey:
+			valid = ed25519.Verify(key, text, signature)
+		default:
+			return fmt.Errorf("unsupported public key type %T", key)
+		}
+		if valid {
+			return nil
+		}
+	}
+	if !strings.Contains(signatures, "— ") {
+		return fmt.Errorf("no signatures in the signed note")
+	}
+	return fmt.Errorf("no valid signature in the signed note")
+}
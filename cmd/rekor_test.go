@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// testRekorKey returns an ECDSA key and its PEM encoded public key.
+func testRekorKey(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// testSignedTreeHead returns a Rekor v1 signed tree head signed by key.
+func testSignedTreeHead(t *testing.T, key *ecdsa.PrivateKey) string {
+	t.Helper()
+	text := "rekor.example.com - 1193050959916656506\n42\nAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=\n"
+	digest := sha256.Sum256([]byte(text))
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign tree head: %v", err)
+	}
+	return text + "\n— rekor.example.com " + base64.StdEncoding.EncodeToString(append([]byte{1, 2, 3, 4}, signature...)) + "\n"
+}
+
+func TestFetchRekorLog(t *testing.T) {
+	key, publicKey := testRekorKey(t)
+	otherKey, _ := testRekorKey(t)
+	logID, err := LogID(publicKey)
+	if err != nil {
+		t.Fatalf("LogID() error = %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		publicKey      []byte
+		signedTreeHead string
+		wantErr        bool
+	}{
+		{"valid", publicKey, testSignedTreeHead(t, key), false},
+		{"signed by another key", publicKey, testSignedTreeHead(t, otherKey), true},
+		{"unsigned tree head", publicKey, "rekor.example.com - 1\n42\nAAAA\n", true},
+		{"invalid public key", []byte("not a key"), testSignedTreeHead(t, key), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v1/log/publicKey":
+					w.Write(tt.publicKey)
+				case "/api/v1/log":
+					json.NewEncoder(w).Encode(rekorLogInfo{TreeID: "1193050959916656506", SignedTreeHead: tt.signedTreeHead})
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			tlog, err := FetchRekorLog(server.URL + "/")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchRekorLog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if tlog.BaseURL != server.URL || tlog.LogID != logID || string(tlog.PublicKey) != string(publicKey) {
+				t.Errorf("FetchRekorLog() = %+v, want the log at %s with log ID %s", tlog, server.URL, logID)
+			}
+		})
+	}
+}
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cmd/assembler"
+	"gopkg.in/yaml.v3"
+)
+
+// TrustMaterial is an assembled repository, the input of a Renderer.
+type TrustMaterial struct {
+	// Name of the rendered object.
+	Name string
+	// Dir is the directory of the assembled repository.
+	Dir string
+	// RootJSON is the trusted root.json of the repository.
+	RootJSON []byte
+	// Labels are added to the metadata of Kubernetes manifests, replacing
+	// the version labels of the same key.
+	Labels map[string]string
+}
+
+// Renderer produces an output format from assembled trust material.
+type Renderer interface {
+	Render(material TrustMaterial) ([]byte, error)
+}
+
+// RendererFunc adapts a function to the Renderer interface.
+type RendererFunc func(material TrustMaterial) ([]byte, error)
+
+// Render calls f(material).
+func (f RendererFunc) Render(material TrustMaterial) ([]byte, error) {
+	return f(material)
+}
+
+// The built-in output formats of --output.
+const (
+	outputTrustRoot   = "trustroot"
+	outputConfigMap   = "configmap"
+	outputSecret      = "secret"
+	outputTrustedRoot = "trusted-root"
+	outputDigest      = "digest"
+)
+
+// Size limits of the Kubernetes objects rendered: ConfigMaps and Secrets are
+// limited to 1 MiB, other objects by the 1.5 MiB request limit of etcd.
+const (
+	maxObjectSize    = 1536 * 1024
+	maxConfigMapSize = 1 << 20
+)
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = map[string]Renderer{
+		outputTrustRoot:   trustRootRenderer,
+		outputConfigMap:   configMapRenderer,
+		outputSecret:      secretRenderer,
+		outputTrustedRoot: RendererFunc(renderTrustedRoot),
+		outputDigest:      RendererFunc(renderDigest),
+	}
+)
+
+// RegisterRenderer makes a Renderer available as an --output format. Custom
+// builds register their formats from an init function in an additional file
+// of the package. It panics if name is already registered.
+//
+// Parameters:
+//   - name: The name of the format.
+//   - renderer: The Renderer of the format.
+func RegisterRenderer(name string, renderer Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	if _, ok := renderers[name]; ok {
+		panic(fmt.Sprintf("renderer %s is already registered", name))
+	}
+	renderers[name] = renderer
+}
+
+// LookupRenderer returns the Renderer registered as name.
+//
+// Parameters:
+//   - name: The name of the format.
+//
+// Returns:
+//   - The Renderer.
+//   - An error listing the registered formats if name is not one of them.
+func LookupRenderer(name string) (Renderer, error) {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	renderer, ok := renderers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q, expected one of %v", name, rendererNames())
+	}
+	return renderer, nil
+}
+
+// rendererNames returns the registered format names in lexical order. The
+// caller must hold renderersMu.
+func rendererNames() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StreamRenderer is a Renderer that can also write its output to an
+// io.Writer, without holding it in memory. Outputs are rendered with RenderTo
+// when their Renderer implements it.
+type StreamRenderer interface {
+	Renderer
+	// RenderTo writes the output to w, which may have received part of it
+	// when an error is returned.
+	RenderTo(w io.Writer, material TrustMaterial) error
+}
+
+// manifestRenderer renders a Kubernetes manifest embedding the base64
+// encoded root.json and repository archive. The manifest is marshaled with
+// yaml.v3 and ends with the archive, which is streamed into the output.
+type manifestRenderer struct {
+	kind string
+	// manifest returns the object given its metadata, the root.json and the
+	// block scalar of the repository archive, its last field.
+	manifest func(metadata *yaml.Node, rootJSON []byte, archive *yaml.Node) *yaml.Node
+	limit    int
+}
+
+var (
+	// trustRootRenderer renders the `repository` TrustRoot Custom Resource.
+	trustRootRenderer = manifestRenderer{kind: "TrustRoot", limit: maxObjectSize, manifest: func(metadata *yaml.Node, rootJSON []byte, archive *yaml.Node) *yaml.Node {
+		repository := []yamlField{{"root", yamlBase64(rootJSON)}}
+		if archiveLayout.Targets() != assembler.DefaultArchiveLayout.Targets() {
+			repository = append(repository, yamlField{"targets", yamlString(archiveLayout.Targets())})
+		}
+		repository = append(repository, yamlField{"mirrorFS", archive})
+		return yamlMapping(
+			yamlField{"apiVersion", yamlString("policy.sigstore.dev/v1alpha1")},
+			yamlField{"kind", yamlString("TrustRoot")},
+			yamlField{"metadata", metadata},
+			yamlField{"spec", yamlMapping(yamlField{"repository", yamlMapping(repository...)})},
+		)
+	}}
+	// configMapRenderer renders a ConfigMap with root.json and
+	// repository.tar.gz, for workloads mounting the trust material directly.
+	configMapRenderer = manifestRenderer{kind: "ConfigMap", limit: maxConfigMapSize, manifest: func(metadata *yaml.Node, rootJSON []byte, archive *yaml.Node) *yaml.Node {
+		return yamlMapping(
+			yamlField{"apiVersion", yamlString("v1")},
+			yamlField{"kind", yamlString("ConfigMap")},
+			yamlField{"metadata", metadata},
+			yamlField{"binaryData", yamlMapping(
+				yamlField{"root.json", yamlBase64(rootJSON)},
+				yamlField{"repository.tar.gz", archive},
+			)},
+		)
+	}}
+	// secretRenderer renders an Opaque Secret with root.json and
+	// repository.tar.gz.
+	secretRenderer = manifestRenderer{kind: "Secret", limit: maxConfigMapSize, manifest: func(metadata *yaml.Node, rootJSON []byte, archive *yaml.Node) *yaml.Node {
+		return yamlMapping(
+			yamlField{"apiVersion", yamlString("v1")},
+			yamlField{"kind", yamlString("Secret")},
+			yamlField{"metadata", metadata},
+			yamlField{"type", yamlString("Opaque")},
+			yamlField{"data", yamlMapping(
+				yamlField{"root.json", yamlBase64(rootJSON)},
+				yamlField{"repository.tar.gz", archive},
+			)},
+		)
+	}}
+)
+
+// Render returns the manifest in memory.
+func (r manifestRenderer) Render(material TrustMaterial) ([]byte, error) {
+	var output bytes.Buffer
+	if err := r.RenderTo(&output, material); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+// RenderTo streams the manifest to w, failing once it exceeds the size limit
+// of its kind. The manifest is marshaled up to the archive, which is then
+// compressed and encoded straight into w, so it is never held in memory.
+func (r manifestRenderer) RenderTo(w io.Writer, material TrustMaterial) error {
+	metadata, err := manifestMetadata(material.Name, material.Dir, material.Labels)
+	if err != nil {
+		return err
+	}
+	archive := yamlString(archivePlaceholder)
+	archive.Style = yaml.LiteralStyle
+	var manifest bytes.Buffer
+	if err := writeYAML(&manifest, r.manifest(metadata, material.RootJSON, archive)); err != nil {
+		return err
+	}
+	// The archive is the last field, anything before it may be chosen freely
+	header, trailer, indent := splitArchivePlaceholder(manifest.Bytes())
+	counter := &countingWriter{w: w}
+	if _, err := counter.Write(header); err != nil {
+		return err
+	}
+	if err := writeEncodedRepositoryArchive(&blockScalarWriter{w: counter, indent: indent, width: base64LineWidth}, material.Dir); err != nil {
+		return err
+	}
+	if _, err := counter.Write(trailer); err != nil {
+		return err
+	}
+	if err := sizeError(r.kind, counter.n, r.limit); err != nil {
+		return err
+	}
+	if counter.n > r.limit/10*9 {
+		warnf(assembler.ErrOversizedOutput, "%s of %d bytes is within 10%% of the limit of %d bytes", r.kind, counter.n, r.limit)
+	}
+	return nil
+}
+
+// archivePlaceholder stands for the repository archive in the marshaled
+// manifest, replaced by the streamed archive.
+const archivePlaceholder = "REPOSITORY-ARCHIVE"
+
+// splitArchivePlaceholder returns the manifest before and after the last
+// archivePlaceholder, and the indentation of its block scalar.
+func splitArchivePlaceholder(manifest []byte) (header, trailer []byte, indent string) {
+	i := bytes.LastIndex(manifest, []byte(archivePlaceholder))
+	line := bytes.LastIndexByte(manifest[:i], '\n') + 1
+	return manifest[:i], manifest[i+len(archivePlaceholder):], string(manifest[line:i])
+}
+
+// blockScalarWriter writes the content of a literal block scalar to w,
+// breaking it into lines of width bytes indented by indent, or a single
+// line if width is 0.
+type blockScalarWriter struct {
+	w      io.Writer
+	indent string
+	width  int
+	column int
+}
+
+func (b *blockScalarWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if b.width > 0 && b.column == b.width {
+			if _, err := io.WriteString(b.w, "\n"+b.indent); err != nil {
+				return written, err
+			}
+			b.column = 0
+		}
+		n := len(p)
+		if b.width > 0 {
+			n = min(n, b.width-b.column)
+		}
+		n, err := b.w.Write(p[:n])
+		written += n
+		b.column += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// RenderRemoteTrustRoot renders the `remote` TrustRoot Custom Resource YAML of
+// mirror: the policy-controller fetches the metadata and targets from the
+// mirror itself, starting from the embedded root.
+//
+// Parameters:
+//   - name: The name of the TrustRoot.
+//   - mirror: The URL of the TUF repository mirror.
+//   - rootJSON: The verified root.json embedded as the trusted root of the mirror.
+//
+// Returns:
+//   - The TrustRoot YAML.
+func RenderRemoteTrustRoot(name, mirror string, rootJSON []byte) string {
+	var b strings.Builder
+	// Marshaling a tree of strings to memory does not fail
+	writeYAML(&b, yamlMapping(
+		yamlField{"apiVersion", yamlString("policy.sigstore.dev/v1alpha1")},
+		yamlField{"kind", yamlString("TrustRoot")},
+		yamlField{"metadata", yamlMapping(yamlField{"name", yamlString(name)})},
+		yamlField{"spec", yamlMapping(yamlField{"remote", yamlMapping(
+			yamlField{"mirror", yamlString(mirror)},
+			yamlField{"root", yamlBase64(rootJSON)},
+		)})},
+	))
+	return b.String()
+}
+
+// targetDigestsAnnotation holds the TargetDigests of the repository as a JSON
+// object, on manifests rendered with --annotate-target-digests.
+const targetDigestsAnnotation = "trustroot-assembler.sigstore.dev/target-digests"
+
+// annotateTargetDigests adds the targetDigestsAnnotation to rendered
+// manifests, set by --annotate-target-digests.
+var annotateTargetDigests = false
+
+// versionLabelPrefix prefixes the labels of rendered manifests holding the
+// version of a top-level metadata role, e.g.
+// trustroot.sigstore.dev/snapshot-version, so trust roots can be selected and
+// compared with label selectors.
+const versionLabelPrefix = "trustroot.sigstore.dev/"
+
+// manifestMetadata returns the metadata of manifests named name rendered
+// from the repository in dir, labelled with the versions of its top-level
+// metadata and extra, in key order after them, and annotated with the
+// targetDigestsAnnotation if annotateTargetDigests. Labels of extra replace
+// the version labels of the same key.
+func manifestMetadata(name, dir string, extra map[string]string) (*yaml.Node, error) {
+	metadata := yamlMapping(yamlField{"name", yamlString(name)})
+	versions, err := metadataVersions(dir)
+	if err != nil {
+		return nil, err
+	}
+	var labels []yamlField
+	for _, role := range inspectRoles {
+		key := versionLabelPrefix + strings.TrimSuffix(role, ".json") + "-version"
+		if _, ok := extra[key]; ok {
+			continue
+		}
+		if version, ok := versions[role]; ok {
+			labels = append(labels, yamlField{key, yamlString(strconv.FormatInt(version, 10))})
+		}
+	}
+	for _, key := range sortedKeys(extra) {
+		labels = append(labels, yamlField{key, yamlString(extra[key])})
+	}
+	if len(labels) > 0 {
+		metadata.Content = append(metadata.Content, yamlString("labels"), yamlMapping(labels...))
+	}
+	if !annotateTargetDigests {
+		return metadata, nil
+	}
+	digests, err := TargetDigests(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not digest targets: %v", err)
+	}
+	content, err := json.Marshal(digests)
+	if err != nil {
+		return nil, err
+	}
+	annotation := yamlString(string(content))
+	annotation.Style = yaml.DoubleQuotedStyle
+	metadata.Content = append(metadata.Content, yamlString("annotations"), yamlMapping(yamlField{targetDigestsAnnotation, annotation}))
+	return metadata, nil
+}
+
+// TargetDigests returns the SHA-256 of every target embedded in the
+// repository assembled in dir, so reviewers can audit the trusted key and
+// certificate bytes without unpacking the archive. The <hash>.<name> copies
+// of consistent snapshot repositories are not listed again.
+//
+// Parameters:
+//   - dir: The directory of the assembled repository.
+//
+// Returns:
+//   - The "sha256:<hex>" digests by target path, relative to targets/.
+//   - An error if the targets could not be read.
+func TargetDigests(dir string) (map[string]string, error) {
+	targetsDir := filepath.Join(dir, "targets")
+	digests := map[string]string{}
+	err := filepath.WalkDir(targetsDir, func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == targetsDir {
+			return filepath.SkipDir
+		}
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if hash, plain, ok := strings.Cut(entry.Name(), "."); ok && isHexDigest(hash) {
+			if _, err := os.Stat(filepath.Join(filepath.Dir(path), plain)); err == nil {
+				return nil
+			}
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(targetsDir, path)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256(content)
+		digests[filepath.ToSlash(rel)] = "sha256:" + hex.EncodeToString(digest[:])
+		return nil
+	})
+	return digests, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// renderTrustedRoot outputs the verified trusted_root.json target, for
+// sigstore-go and cosign --trusted-root.
+func renderTrustedRoot(material TrustMaterial) ([]byte, error) {
+	trustedRoot, err := os.ReadFile(filepath.Join(material.Dir, "targets", "trusted_root.json"))
+	if err != nil {
+		return nil, fmt.Errorf("repository has no trusted_root.json target: %v", err)
+	}
+	return trustedRoot, nil
+}
+
+// renderDigest outputs only the SHA-256 of the root.json and the
+// RepositoryDigest of the repository, in the format of sha256sum, for
+// pipelines pinning trust material by digest and fetching it elsewhere.
+func renderDigest(material TrustMaterial) ([]byte, error) {
+	repositoryDigest, err := RepositoryDigest(material.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not digest repository: %v", err)
+	}
+	rootDigest := sha256.Sum256(material.RootJSON)
+	return []byte(fmt.Sprintf("%s  root.json\n%s  repository", hex.EncodeToString(rootDigest[:]), repositoryDigest)), nil
+}
+
+// writeEncodedRepositoryArchive writes the base64 encoded tar.gz archive of
+// the repository in dir to w. The archive is streamed from the files of dir
+// through gzip and the base64 encoder, without an intermediate archive file
+// or buffer.
+func writeEncodedRepositoryArchive(w io.Writer, dir string) error {
+	encoder := base64.NewEncoder(base64.StdEncoding, w)
+	if err := compressRepository(dir, encoder); err != nil {
+		return fmt.Errorf("could not compress repository directory: %w", err)
+	}
+	return encoder.Close()
+}
+
+// archiveLayout places the metadata and targets in repository archives, set
+// by --archive-prefix and --archive-targets-dir.
+var archiveLayout = assembler.DefaultArchiveLayout
+
+// maxArchiveSize is the --max-archive-size ceiling of repository archives in
+// bytes, 0 for none.
+var maxArchiveSize int64
+
+// errArchiveLimit is wrapped, with assembler.ErrOversizedOutput, by the error
+// of archives exceeding maxArchiveSize.
+var errArchiveLimit = errors.New("repository archive exceeds --max-archive-size")
+
+// archiveLimitWriter fails the write that takes the archive written to w
+// beyond limit bytes.
+type archiveLimitWriter struct {
+	w     io.Writer
+	n     int64
+	limit int64
+}
+
+func (a *archiveLimitWriter) Write(p []byte) (int, error) {
+	if a.n+int64(len(p)) > a.limit {
+		return 0, fmt.Errorf("%w: %w of %d bytes", assembler.ErrOversizedOutput, errArchiveLimit, a.limit)
+	}
+	n, err := a.w.Write(p)
+	a.n += int64(n)
+	return n, err
+}
+
+// compressRepository writes the tar.gz archive of the repository in dir to w,
+// laid out by archiveLayout and reproducibly with --deterministic, failing
+// once it exceeds maxArchiveSize.
+func compressRepository(dir string, w io.Writer) error {
+	if maxArchiveSize > 0 {
+		w = &archiveLimitWriter{w: w, limit: maxArchiveSize}
+	}
+	if deterministic {
+		modTime := now()
+		return assembler.CompressFSLayout(os.DirFS(dir), w, archiveLayout, &modTime)
+	}
+	return assembler.CompressFSLayout(os.DirFS(dir), w, archiveLayout, nil)
+}
+
+// base64LineWidth is the length of the lines of the base64 block scalars of
+// rendered manifests, set by --wrap-base64, 0 for a single line. Kubernetes
+// ignores the line breaks when decoding them.
+var base64LineWidth = 0
+
+// yamlField is a key and its value in a YAML mapping.
+type yamlField struct {
+	key   string
+	value *yaml.Node
+}
+
+// yamlMapping returns the YAML mapping of fields, in order.
+func yamlMapping(fields ...yamlField) *yaml.Node {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for _, field := range fields {
+		mapping.Content = append(mapping.Content, yamlString(field.key), field.value)
+	}
+	return mapping
+}
+
+// yamlString returns the YAML string scalar of value, quoted only where YAML
+// requires it.
+func yamlString(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// yamlBase64 returns content base64 encoded as a literal block scalar of
+// base64LineWidth lines.
+func yamlBase64(content []byte) *yaml.Node {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	var lines []string
+	for base64LineWidth > 0 && len(encoded) > base64LineWidth {
+		lines = append(lines, encoded[:base64LineWidth])
+		encoded = encoded[base64LineWidth:]
+	}
+	lines = append(lines, encoded)
+	node := yamlString(strings.Join(lines, "\n"))
+	node.Style = yaml.LiteralStyle
+	return node
+}
+
+// writeYAML marshals document to w with two space indentation.
+func writeYAML(w io.Writer, document *yaml.Node) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/assembler"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRenderers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "targets"), 0o755); err != nil {
+		t.Fatalf("Failed to create targets directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "targets", "trusted_root.json"), []byte(`{"mediaType":"trusted-root"}`), 0o644); err != nil {
+		t.Fatalf("Failed to write target: %v", err)
+	}
+	material := TrustMaterial{Name: "tuf-example", Dir: dir, RootJSON: []byte(`{"signed":{}}`)}
+
+	tests := []struct {
+		name    string
+		format  string
+		want    []string
+		wantErr bool
+	}{
+		{"trustroot", outputTrustRoot, []string{"kind: TrustRoot", "name: tuf-example", "root: |-\n      eyJzaWduZWQiOnt9fQ==", "mirrorFS: |-"}, false},
+		{"configmap", outputConfigMap, []string{"kind: ConfigMap", "name: tuf-example", "root.json: |-\n    eyJzaWduZWQiOnt9fQ==", "repository.tar.gz: |-"}, false},
+		{"secret", outputSecret, []string{"kind: Secret", "type: Opaque", "root.json: |-\n    eyJzaWduZWQiOnt9fQ==", "repository.tar.gz: |-"}, false},
+		{"trusted-root", outputTrustedRoot, []string{`{"mediaType":"trusted-root"}`}, false},
+		{"digest", outputDigest, []string{"8ffa8cd51e508792ee7e8cd1378dfe08566bbe1e0c7f9ec86b501767502f2f62  root.json\n", "  repository"}, false},
+		{"unknown", "helm", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer, err := LookupRenderer(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LookupRenderer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := renderer.Render(material)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(got), want) {
+					t.Errorf("Render() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterRenderer(t *testing.T) {
+	RegisterRenderer("test-name", RendererFunc(func(material TrustMaterial) ([]byte, error) {
+		return []byte(material.Name), nil
+	}))
+	renderer, err := LookupRenderer("test-name")
+	if err != nil {
+		t.Fatalf("LookupRenderer() error = %v", err)
+	}
+	if got, _ := renderer.Render(TrustMaterial{Name: "tuf-example"}); string(got) != "tuf-example" {
+		t.Errorf("Render() = %q, want %q", got, "tuf-example")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("RegisterRenderer() of a registered name did not panic")
+		}
+	}()
+	RegisterRenderer(outputTrustRoot, trustRootRenderer)
+}
+
+func TestRenderTrustRootWithoutTemporaryFiles(t *testing.T) {
+	repository := newTestRepository(t)
+	// Any temporary file would fail to be created
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	if _, err := trustRootRenderer.Render(TrustMaterial{Name: "test", Dir: repository, RootJSON: []byte(`{}`)}); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+}
+
+func TestManifestRendererRenderTo(t *testing.T) {
+	material := TrustMaterial{Name: "test", Dir: newTestRepository(t), RootJSON: []byte(`{}`)}
+	want, err := configMapRenderer.Render(material)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	var got strings.Builder
+	if err := configMapRenderer.RenderTo(&got, material); err != nil {
+		t.Fatalf("RenderTo() error = %v", err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("RenderTo() = %q, want the output of Render() %q", got.String(), want)
+	}
+
+	small := manifestRenderer{kind: "ConfigMap", manifest: configMapRenderer.manifest, limit: 10}
+	if err := small.RenderTo(io.Discard, material); !errors.Is(err, assembler.ErrOversizedOutput) {
+		t.Errorf("RenderTo() beyond the limit error = %v, want %v", err, assembler.ErrOversizedOutput)
+	}
+}
+
+func TestManifestRendererStreamsArchive(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	material := TrustMaterial{Name: "test", Dir: newTestRepository(t), RootJSON: []byte(`{}`)}
+	var archive bytes.Buffer
+	if err := compressRepository(material.Dir, &archive); err != nil {
+		t.Fatal(err)
+	}
+	defer func(width int) { base64LineWidth = width }(base64LineWidth)
+
+	for _, width := range []int{0, 76} {
+		base64LineWidth = width
+		// The output goes to the spill file once beyond the threshold
+		output := newSpillBuffer(64)
+		if err := trustRootRenderer.RenderTo(output, material); err != nil {
+			t.Fatalf("RenderTo() error = %v", err)
+		}
+		if !output.Spilled() {
+			t.Errorf("RenderTo() of %d bytes did not spill beyond the threshold of 64 bytes", output.Len())
+		}
+		var trustRoot struct {
+			Spec struct {
+				Repository struct {
+					MirrorFS string `yaml:"mirrorFS"`
+				} `yaml:"repository"`
+			} `yaml:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(output.String()), &trustRoot); err != nil {
+			t.Fatalf("output does not parse: %v\n%s", err, output.String())
+		}
+		for _, line := range strings.Split(trustRoot.Spec.Repository.MirrorFS, "\n") {
+			if width > 0 && len(line) > width {
+				t.Errorf("mirrorFS line of %d characters, want at most %d", len(line), width)
+			}
+		}
+		decoded, err := base64.StdEncoding.DecodeString(trustRoot.Spec.Repository.MirrorFS)
+		if err != nil || !bytes.Equal(decoded, archive.Bytes()) {
+			t.Errorf("mirrorFS with width %d does not decode to the repository archive: %v", width, err)
+		}
+		output.Close()
+	}
+}
+
+func TestRenderTrustRootArchiveLayout(t *testing.T) {
+	material := TrustMaterial{Name: "test", Dir: newTestRepository(t), RootJSON: []byte(`{}`)}
+	defer func(layout assembler.ArchiveLayout) { archiveLayout = layout }(archiveLayout)
+
+	tests := []struct {
+		name    string
+		layout  assembler.ArchiveLayout
+		want    string
+		wantNot string
+	}{
+		{"default", assembler.DefaultArchiveLayout, "    root: |-\n      e30=\n    mirrorFS: |-\n", "targets:"},
+		{"targets directory", assembler.ArchiveLayout{Prefix: "repository", TargetsDir: "files"}, "      e30=\n    targets: files\n    mirrorFS: |-\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archiveLayout = tt.layout
+			got, err := trustRootRenderer.Render(material)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if !strings.Contains(string(got), tt.want) {
+				t.Errorf("Render() = %q, want it to contain %q", got, tt.want)
+			}
+			if tt.wantNot != "" && strings.Contains(string(got), tt.wantNot) {
+				t.Errorf("Render() = %q, want it not to contain %q", got, tt.wantNot)
+			}
+		})
+	}
+}
+
+// sha256Hex returns the hex SHA-256 of content.
+func sha256Hex(content string) string {
+	digest := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestTargetDigests(t *testing.T) {
+	dir := t.TempDir()
+	hash := strings.Repeat("ab", 32)
+	for name, content := range map[string]string{
+		"ctfe.pub":                      "ctfe",
+		hash + ".ctfe.pub":              "ctfe",
+		"nested/fulcio.crt.pem":         "fulcio",
+		strings.Repeat("cd", 32) + ".x": "not a copy",
+	} {
+		path := filepath.Join(dir, "targets", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write target: %v", err)
+		}
+	}
+
+	digests, err := TargetDigests(dir)
+	if err != nil {
+		t.Fatalf("TargetDigests() error = %v", err)
+	}
+	want := map[string]string{
+		"ctfe.pub":                      "sha256:" + sha256Hex("ctfe"),
+		"nested/fulcio.crt.pem":         "sha256:" + sha256Hex("fulcio"),
+		strings.Repeat("cd", 32) + ".x": "sha256:" + sha256Hex("not a copy"),
+	}
+	if len(digests) != len(want) {
+		t.Fatalf("TargetDigests() = %v, want %v", digests, want)
+	}
+	for name, digest := range want {
+		if digests[name] != digest {
+			t.Errorf("digest of %s = %s, want %s", name, digests[name], digest)
+		}
+	}
+	if digests, err := TargetDigests(t.TempDir()); err != nil || len(digests) != 0 {
+		t.Errorf("TargetDigests() without targets = %v, %v", digests, err)
+	}
+}
+
+func TestRenderTargetDigestAnnotation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "targets"), 0o755); err != nil {
+		t.Fatalf("Failed to create targets directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "targets", "ctfe.pub"), []byte("ctfe"), 0o644); err != nil {
+		t.Fatalf("Failed to write target: %v", err)
+	}
+	material := TrustMaterial{Name: "test", Dir: dir, RootJSON: []byte(`{}`)}
+	defer func(annotate bool) { annotateTargetDigests = annotate }(annotateTargetDigests)
+
+	for _, renderer := range []manifestRenderer{trustRootRenderer, configMapRenderer, secretRenderer} {
+		t.Run(renderer.kind, func(t *testing.T) {
+			annotateTargetDigests = true
+			got, err := renderer.Render(material)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			want := "metadata:\n  name: test\n  annotations:\n    " + targetDigestsAnnotation + ": \"{\\\"ctfe.pub\\\":\\\"sha256:" + sha256Hex("ctfe") + "\\\"}\"\n"
+			if !strings.Contains(string(got), want) {
+				t.Errorf("Render() = %q, want it to contain %q", got, want)
+			}
+			annotateTargetDigests = false
+			if got, _ := renderer.Render(material); strings.Contains(string(got), "annotations:") {
+				t.Errorf("Render() without --annotate-target-digests = %q", got)
+			}
+		})
+	}
+}
+
+func TestRenderVersionLabels(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"1.root.json":    `{"signed":{"version":1}}`,
+		"2.root.json":    `{"signed":{"version":2}}`,
+		"timestamp.json": `{"signed":{"version":340}}`,
+		"snapshot.json":  `{"signed":{"version":340}}`,
+		"7.targets.json": `{"signed":{"version":7}}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write metadata: %v", err)
+		}
+	}
+	material := TrustMaterial{Name: "test", Dir: dir, RootJSON: []byte(`{}`)}
+
+	for _, renderer := range []manifestRenderer{trustRootRenderer, configMapRenderer, secretRenderer} {
+		t.Run(renderer.kind, func(t *testing.T) {
+			got, err := renderer.Render(material)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			want := "metadata:\n  name: test\n  labels:\n" +
+				"    " + versionLabelPrefix + "root-version: \"2\"\n" +
+				"    " + versionLabelPrefix + "timestamp-version: \"340\"\n" +
+				"    " + versionLabelPrefix + "snapshot-version: \"340\"\n" +
+				"    " + versionLabelPrefix + "targets-version: \"7\"\n"
+			if !strings.Contains(string(got), want) {
+				t.Errorf("Render() = %q, want it to contain %q", got, want)
+			}
+		})
+	}
+}
+
+func TestCompressRepositoryMaxArchiveSize(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "1.root.json"), []byte(strings.Repeat("root", 1024)), 0o644); err != nil {
+		t.Fatalf("Failed to write root: %v", err)
+	}
+	defer func(limit int64) { maxArchiveSize = limit }(maxArchiveSize)
+
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr bool
+	}{
+		{"no limit", 0, false},
+		{"within the limit", 1 << 20, false},
+		{"beyond the limit", 64, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxArchiveSize = tt.limit
+			var encoded strings.Builder
+			err := writeEncodedRepositoryArchive(&encoded, dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeEncodedRepositoryArchive() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && (!errors.Is(err, assembler.ErrOversizedOutput) || !errors.Is(err, errArchiveLimit)) {
+				t.Errorf("writeEncodedRepositoryArchive() error = %v, want %v", err, errArchiveLimit)
+			}
+		})
+	}
+}
+
+func TestYAMLBase64(t *testing.T) {
+	content := []byte(strings.Repeat("trust material ", 20))
+	defer func(width int) { base64LineWidth = width }(base64LineWidth)
+
+	tests := []struct {
+		name      string
+		width     int
+		wantLines int
+	}{
+		{"single line", 0, 1},
+		{"wrapped", 76, 6},
+		{"wider than the payload", 1024, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base64LineWidth = tt.width
+			var got strings.Builder
+			if err := writeYAML(&got, yamlMapping(yamlField{"name", yamlString("org: sigstore")}, yamlField{"data", yamlBase64(content)})); err != nil {
+				t.Fatalf("writeYAML() error = %v", err)
+			}
+			var decoded struct {
+				Name string `yaml:"name"`
+				Data string `yaml:"data"`
+			}
+			if err := yaml.Unmarshal([]byte(got.String()), &decoded); err != nil {
+				t.Fatalf("output does not parse: %v\n%s", err, got.String())
+			}
+			if decoded.Name != "org: sigstore" {
+				t.Errorf("name = %q, want %q", decoded.Name, "org: sigstore")
+			}
+			lines := strings.Split(decoded.Data, "\n")
+			if len(lines) != tt.wantLines {
+				t.Errorf("data has %d lines, want %d", len(lines), tt.wantLines)
+			}
+			for _, line := range lines {
+				if tt.width > 0 && len(line) > tt.width {
+					t.Errorf("line of %d characters, want at most %d", len(line), tt.width)
+				}
+			}
+			if raw, err := base64.StdEncoding.DecodeString(decoded.Data); err != nil || !bytes.Equal(raw, content) {
+				t.Errorf("data decodes to %q, %v, want %q", raw, err, content)
+			}
+		})
+	}
+}
+
+func TestManifestMetadataLabels(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "5.root.json"), []byte(`{"signed":{"version":5}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"version labels", nil, "name: sigstore\nlabels:\n  trustroot.sigstore.dev/root-version: \"5\"\n"},
+		{"sorted after the version labels", map[string]string{"zone": "eu", "app.kubernetes.io/managed-by": "trustroot-assembler"},
+			"name: sigstore\nlabels:\n  trustroot.sigstore.dev/root-version: \"5\"\n  app.kubernetes.io/managed-by: trustroot-assembler\n  zone: eu\n"},
+		{"replacing a version label", map[string]string{"trustroot.sigstore.dev/root-version": "pinned", "team": "true"},
+			"name: sigstore\nlabels:\n  team: \"true\"\n  trustroot.sigstore.dev/root-version: pinned\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metadata, err := manifestMetadata("sigstore", dir, tt.labels)
+			if err != nil {
+				t.Fatalf("manifestMetadata() error = %v", err)
+			}
+			var got strings.Builder
+			if err := writeYAML(&got, metadata); err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("manifestMetadata() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of `report`.
+const (
+	reportOutputTable = "table"
+	reportOutputJSON  = "json"
+)
+
+// Status of a TrustEntry at the time of the report.
+const (
+	trustStatusActive  = "active"
+	trustStatusExpired = "expired"
+	trustStatusPending = "pending"
+)
+
+// TrustEntry is a certificate authority, log key or timestamp authority of a
+// Sigstore trusted_root.json.
+type TrustEntry struct {
+	// Usage is the Sigstore service trusted: Fulcio, Rekor, CTFE or TSA.
+	Usage string `json:"usage"`
+	// Identity is the certificate subject of authorities and the base URL
+	// of logs.
+	Identity string `json:"identity"`
+	URI      string `json:"uri,omitempty"`
+	// LogID is the hex log ID of logs.
+	LogID string `json:"logId,omitempty"`
+	// Algorithm is the key details of logs and the public key algorithm of
+	// the first certificate of authorities.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Fingerprint is the hex SHA-256 of the public key DER of logs and of
+	// the first certificate DER of authorities.
+	Fingerprint string `json:"fingerprint"`
+	// ValidFrom and ValidUntil are the validFor window of the entry,
+	// ValidUntil nil while it is open.
+	ValidFrom  time.Time  `json:"validFrom"`
+	ValidUntil *time.Time `json:"validUntil,omitempty"`
+	// Certificates is the length of the certificate chain of authorities
+	// and CertificateNotAfter the expiry of its first certificate.
+	Certificates        int        `json:"certificates,omitempty"`
+	CertificateNotAfter *time.Time `json:"certificateNotAfter,omitempty"`
+	// Status is active, expired or pending, the validity window at the
+	// time of the report.
+	Status string `json:"status"`
+}
+
+// TrustReport is the machine-readable `report` document.
+type TrustReport struct {
+	Source    string       `json:"source"`
+	Generated time.Time    `json:"generated"`
+	Entries   []TrustEntry `json:"entries"`
+}
+
+// trustedRootDocument is the part of a Sigstore trusted_root.json listed by
+// TrustInventory and written by TrustedRootJSON.
+type trustedRootDocument struct {
+	MediaType              string                 `json:"mediaType,omitempty"`
+	TLogs                  []trustedRootLog       `json:"tlogs"`
+	CertificateAuthorities []trustedRootAuthority `json:"certificateAuthorities"`
+	CTLogs                 []trustedRootLog       `json:"ctlogs"`
+	TimestampAuthorities   []trustedRootAuthority `json:"timestampAuthorities"`
+}
+
+// trustedRootValidity is the validFor window of a trusted_root.json entry.
+type trustedRootValidity struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+type trustedRootLog struct {
+	BaseURL       string               `json:"baseUrl"`
+	HashAlgorithm string               `json:"hashAlgorithm,omitempty"`
+	PublicKey     trustedRootPublicKey `json:"publicKey"`
+	LogID         trustedRootLogID     `json:"logId"`
+	// CheckpointKeyID is set for logs signing checkpoints, like Rekor v2
+	CheckpointKeyID *trustedRootLogID `json:"checkpointKeyId,omitempty"`
+}
+
+type trustedRootLogID struct {
+	KeyID []byte `json:"keyId"`
+}
+
+type trustedRootPublicKey struct {
+	RawBytes   []byte              `json:"rawBytes"`
+	KeyDetails string              `json:"keyDetails"`
+	ValidFor   trustedRootValidity `json:"validFor"`
+}
+
+type trustedRootAuthority struct {
+	Subject struct {
+		Organization string `json:"organization"`
+		CommonName   string `json:"commonName"`
+	} `json:"subject"`
+	URI       string `json:"uri"`
+	CertChain struct {
+		Certificates []trustedRootCertificate `json:"certificates"`
+	} `json:"certChain"`
+	ValidFor trustedRootValidity `json:"validFor"`
+}
+
+type trustedRootCertificate struct {
+	RawBytes []byte `json:"rawBytes"`
+}
+
+// TrustInventory lists every certificate authority, transparency log key, CT
+// log key and timestamp authority of a Sigstore trusted_root.json with its
+// validity window, a bill of trust for compliance evidence.
+//
+// Parameters:
+//   - trustedRootJSON: The content of the trusted_root.json target.
+//   - now: The time of the report, to compute the status of the entries.
+//
+// Returns:
+//   - The Fulcio authorities, Rekor logs, CT logs and timestamp authorities, in this order.
+//   - An error if the document could not be decoded or holds an invalid certificate.
+func TrustInventory(trustedRootJSON []byte, now time.Time) ([]TrustEntry, error) {
+	var root trustedRootDocument
+	if err := json.Unmarshal(trustedRootJSON, &root); err != nil {
+		return nil, fmt.Errorf("could not read trusted_root.json: %v", err)
+	}
+	var entries []TrustEntry
+	for _, ca := range root.CertificateAuthorities {
+		entry, err := authorityEntry("Fulcio", ca, now)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	for _, tlog := range root.TLogs {
+		entries = append(entries, logEntry("Rekor", tlog, now))
+	}
+	for _, ctlog := range root.CTLogs {
+		entries = append(entries, logEntry("CTFE", ctlog, now))
+	}
+	for _, tsa := range root.TimestampAuthorities {
+		entry, err := authorityEntry("TSA", tsa, now)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// authorityEntry returns the TrustEntry of a certificate or timestamp
+// authority.
+func authorityEntry(usage string, authority trustedRootAuthority, now time.Time) (TrustEntry, error) {
+	identity := authority.Subject.CommonName
+	if authority.Subject.Organization != "" {
+		identity = fmt.Sprintf("%s (%s)", identity, authority.Subject.Organization)
+	}
+	entry := TrustEntry{
+		Usage:        usage,
+		Identity:     identity,
+		URI:          authority.URI,
+		ValidFrom:    authority.ValidFor.Start.UTC(),
+		ValidUntil:   utcTime(authority.ValidFor.End),
+		Certificates: len(authority.CertChain.Certificates),
+		Status:       trustStatus(authority.ValidFor, now),
+	}
+	if entry.Certificates == 0 {
+		return entry, nil
+	}
+	der := authority.CertChain.Certificates[0].RawBytes
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return TrustEntry{}, fmt.Errorf("invalid %s certificate of %s: %v", usage, identity, err)
+	}
+	fingerprint := sha256.Sum256(der)
+	notAfter := certificate.NotAfter.UTC()
+	entry.Fingerprint = hex.EncodeToString(fingerprint[:])
+	entry.Algorithm = certificate.PublicKeyAlgorithm.String()
+	entry.CertificateNotAfter = &notAfter
+	return entry, nil
+}
+
+// logEntry returns the TrustEntry of a transparency or CT log key.
+func logEntry(usage string, tlog trustedRootLog, now time.Time) TrustEntry {
+	fingerprint := sha256.Sum256(tlog.PublicKey.RawBytes)
+	return TrustEntry{
+		Usage:       usage,
+		Identity:    tlog.BaseURL,
+		LogID:       hex.EncodeToString(tlog.LogID.KeyID),
+		Algorithm:   tlog.PublicKey.KeyDetails,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		ValidFrom:   tlog.PublicKey.ValidFor.Start.UTC(),
+		ValidUntil:  utcTime(tlog.PublicKey.ValidFor.End),
+		Status:      trustStatus(tlog.PublicKey.ValidFor, now),
+	}
+}
+
+// utcTime returns t in UTC, nil if t is nil.
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+// trustStatus returns the status of the validity window at now.
+func trustStatus(validity trustedRootValidity, now time.Time) string {
+	switch {
+	case now.Before(validity.Start):
+		return trustStatusPending
+	case validity.End != nil && now.After(*validity.End):
+		return trustStatusExpired
+	}
+	return trustStatusActive
+}
+
+// WriteTrustReport writes report to w as a table, one line per entry, or as
+// indented JSON.
+//
+// Parameters:
+//   - w: Where the report is written.
+//   - report: The inventory of the trusted root.
+//   - output: reportOutputTable or reportOutputJSON.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func WriteTrustReport(w io.Writer, report TrustReport, output string) error {
+	switch output {
+	case reportOutputJSON:
+		if report.Entries == nil {
+			report.Entries = []TrustEntry{}
+		}
+		content, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", content)
+		return err
+	case reportOutputTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "USAGE\tIDENTITY\tALGORITHM\tVALID FROM\tVALID UNTIL\tSTATUS\tFINGERPRINT")
+		for _, entry := range report.Entries {
+			until := "-"
+			if entry.ValidUntil != nil {
+				until = entry.ValidUntil.Format(time.RFC3339)
+			}
+			fingerprint := "-"
+			if entry.Fingerprint != "" {
+				fingerprint = "SHA256:" + entry.Fingerprint
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Usage, orDash(entry.Identity), orDash(entry.Algorithm), entry.ValidFrom.Format(time.RFC3339), until, entry.Status, fingerprint)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown report output %q, expected %s or %s", output, reportOutputTable, reportOutputJSON)
+	}
+}
+
+// readTrustReport returns the TrustReport of the repository assembled from
+// source in workDir.
+func readTrustReport(source, workDir string, now time.Time) (TrustReport, error) {
+	trustedRoot, err := os.ReadFile(filepath.Join(workDir, "targets", "trusted_root.json"))
+	if err != nil {
+		return TrustReport{}, fmt.Errorf("repository has no trusted_root.json target: %v", err)
+	}
+	entries, err := TrustInventory(trustedRoot, now)
+	if err != nil {
+		return TrustReport{}, err
+	}
+	return TrustReport{Source: source, Generated: now.UTC().Truncate(time.Second), Entries: entries}, nil
+}
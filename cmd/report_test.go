@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTrustedRoot returns a trusted_root.json with an expired Fulcio CA, an
+// active Rekor log, a pending CT log and a TSA, and the DER of their
+// certificate and public key.
+func testTrustedRoot(t *testing.T, now time.Time) ([]byte, []byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sigstore", Organization: []string{"sigstore.dev"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	authority := func(uri string, end *time.Time) map[string]any {
+		validFor := map[string]any{"start": now.Add(-2 * time.Hour)}
+		if end != nil {
+			validFor["end"] = *end
+		}
+		return map[string]any{
+			"subject":   map[string]string{"organization": "sigstore.dev", "commonName": "sigstore"},
+			"uri":       uri,
+			"certChain": map[string]any{"certificates": []map[string][]byte{{"rawBytes": certificate}}},
+			"validFor":  validFor,
+		}
+	}
+	tlog := func(baseURL string, start time.Time) map[string]any {
+		return map[string]any{
+			"baseUrl":       baseURL,
+			"hashAlgorithm": "SHA2_256",
+			"publicKey":     map[string]any{"rawBytes": publicKey, "keyDetails": "PKIX_ECDSA_P256_SHA_256", "validFor": map[string]any{"start": start}},
+			"logId":         map[string][]byte{"keyId": {0xab, 0xcd}},
+		}
+	}
+	expired := now.Add(-time.Hour)
+	content, err := json.Marshal(map[string]any{
+		"mediaType":              "application/vnd.dev.sigstore.trustedroot+json;version=0.1",
+		"certificateAuthorities": []any{authority("https://fulcio.example", &expired)},
+		"tlogs":                  []any{tlog("https://rekor.example", now.Add(-time.Hour))},
+		"ctlogs":                 []any{tlog("https://ctfe.example", now.Add(time.Hour))},
+		"timestampAuthorities":   []any{authority("", nil)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal trusted root: %v", err)
+	}
+	return content, certificate, publicKey
+}
+
+func TestTrustInventory(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	trustedRoot, certificate, publicKey := testTrustedRoot(t, now)
+	certificateFingerprint := sha256.Sum256(certificate)
+	keyFingerprint := sha256.Sum256(publicKey)
+
+	entries, err := TrustInventory(trustedRoot, now)
+	if err != nil {
+		t.Fatalf("TrustInventory() error = %v", err)
+	}
+	tests := []struct {
+		usage       string
+		identity    string
+		status      string
+		fingerprint []byte
+	}{
+		{"Fulcio", "sigstore (sigstore.dev)", trustStatusExpired, certificateFingerprint[:]},
+		{"Rekor", "https://rekor.example", trustStatusActive, keyFingerprint[:]},
+		{"CTFE", "https://ctfe.example", trustStatusPending, keyFingerprint[:]},
+		{"TSA", "sigstore (sigstore.dev)", trustStatusActive, certificateFingerprint[:]},
+	}
+	if len(entries) != len(tests) {
+		t.Fatalf("TrustInventory() = %+v, want %d entries", entries, len(tests))
+	}
+	for i, tt := range tests {
+		t.Run(tt.usage, func(t *testing.T) {
+			entry := entries[i]
+			if entry.Usage != tt.usage || entry.Identity != tt.identity || entry.Status != tt.status || entry.Fingerprint != hex.EncodeToString(tt.fingerprint) {
+				t.Errorf("entry = %+v, want %s %s %s", entry, tt.usage, tt.identity, tt.status)
+			}
+		})
+	}
+	if entries[0].CertificateNotAfter == nil || !entries[0].CertificateNotAfter.Equal(now.Add(time.Hour)) || entries[0].Algorithm != "ECDSA" || entries[0].Certificates != 1 {
+		t.Errorf("Fulcio entry = %+v", entries[0])
+	}
+	if entries[1].LogID != "abcd" || entries[1].Algorithm != "PKIX_ECDSA_P256_SHA_256" || entries[1].ValidUntil != nil {
+		t.Errorf("Rekor entry = %+v", entries[1])
+	}
+
+	if _, err := TrustInventory([]byte(`{"certificateAuthorities":[{"certChain":{"certificates":[{"rawBytes":"AAAA"}]}}]}`), now); err == nil {
+		t.Error("TrustInventory() of an invalid certificate error = nil, want an error")
+	}
+	if _, err := TrustInventory([]byte("{"), now); err == nil {
+		t.Error("TrustInventory() of invalid JSON error = nil, want an error")
+	}
+}
+
+func TestWriteTrustReport(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	trustedRoot, _, _ := testTrustedRoot(t, now)
+	entries, err := TrustInventory(trustedRoot, now)
+	if err != nil {
+		t.Fatalf("TrustInventory() error = %v", err)
+	}
+	report := TrustReport{Source: "https://tuf.example", Generated: now, Entries: entries}
+
+	tests := []struct {
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{reportOutputTable, []string{"USAGE", "Fulcio  sigstore (sigstore.dev)", "2025-12-31T23:00:00Z", "expired", "CTFE", "pending", "SHA256:"}, false},
+		{reportOutputJSON, []string{`"source": "https://tuf.example"`, `"usage": "Rekor"`, `"status": "active"`, `"logId": "abcd"`}, false},
+		{"yaml", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			var out bytes.Buffer
+			err := WriteTrustReport(&out, report, tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteTrustReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("WriteTrustReport() = %s, want it to contain %q", out.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// RepositoryMap is a TAP-4 map file, describing how trust in targets is split
+// across several TUF repositories.
+// See https://github.com/theupdateframework/taps/blob/master/tap4.md
+type RepositoryMap struct {
+	// Repositories maps repository names to their mirrors; the first mirror is used.
+	Repositories map[string][]string `json:"repositories"`
+	// Mapping is evaluated in order for every target.
+	Mapping []RepositoryMapping `json:"mapping"`
+}
+
+// RepositoryMapping assigns the targets matching Paths to Repositories.
+type RepositoryMapping struct {
+	Paths        []string `json:"paths"`
+	Repositories []string `json:"repositories"`
+	Threshold    int      `json:"threshold"`
+	Terminating  bool     `json:"terminating"`
+}
+
+// LoadRepositoryMap reads and validates a TAP-4 map file.
+//
+// Parameters:
+//   - mapPath: The path of the map.json file.
+//
+// Returns:
+//   - The parsed RepositoryMap.
+//   - An error if the file could not be read or is inconsistent.
+func LoadRepositoryMap(mapPath string) (*RepositoryMap, error) {
+	content, err := os.ReadFile(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	m := &RepositoryMap{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("could not parse map file %s: %v", mapPath, err)
+	}
+	if len(m.Mapping) == 0 {
+		return nil, fmt.Errorf("map file %s has no mapping", mapPath)
+	}
+	for name, mirrors := range m.Repositories {
+		// Names are the directories of the trusted roots and of the repositories
+		if !fs.ValidPath(name) || strings.Contains(name, "/") || name == "." || sourceName(name) == "" {
+			return nil, fmt.Errorf("invalid repository name %q", name)
+		}
+		if len(mirrors) == 0 {
+			return nil, fmt.Errorf("repository %s has no mirror", name)
+		}
+	}
+	for i, mapping := range m.Mapping {
+		if mapping.Threshold < 1 || mapping.Threshold > len(mapping.Repositories) {
+			return nil, fmt.Errorf("mapping %d has threshold %d for %d repositories", i, mapping.Threshold, len(mapping.Repositories))
+		}
+		for _, name := range mapping.Repositories {
+			if _, ok := m.Repositories[name]; !ok {
+				return nil, fmt.Errorf("mapping %d references unknown repository %s", i, name)
+			}
+		}
+	}
+	return m, nil
+}
+
+// ResolveTarget applies the TAP-4 mapping to a target and returns the
+// repositories that agree on it.
+//
+// The first mapping whose paths match the target decides: the target is trusted
+// when at least threshold of its repositories list it with identical length and
+// hashes. Otherwise the search goes on with the next mapping, unless the
+// mapping is terminating.
+//
+// Parameters:
+//   - name: The target name.
+//   - targets: The verified targets of every repository, by repository name.
+//
+// Returns:
+//   - The names of the agreeing repositories, in mapping order.
+//   - An error if no mapping vouches for the target.
+func (m *RepositoryMap) ResolveTarget(name string, targets map[string]data.TargetFiles) ([]string, error) {
+	for i, mapping := range m.Mapping {
+		if !matchesAny(mapping.Paths, name) {
+			continue
+		}
+		if agreeing := agreeingRepositories(name, mapping.Repositories, targets); len(agreeing) >= mapping.Threshold {
+			return agreeing, nil
+		}
+		if mapping.Terminating {
+			return nil, fmt.Errorf("target %s is not agreed upon by %d repositories of terminating mapping %d", name, mapping.Threshold, i)
+		}
+	}
+	return nil, fmt.Errorf("no mapping vouches for target %s", name)
+}
+
+// agreeingRepositories returns the largest group of repositories listing name
+// with identical metadata.
+func agreeingRepositories(name string, repositories []string, targets map[string]data.TargetFiles) []string {
+	var best []string
+	for i, candidate := range repositories {
+		candidateMeta, ok := targets[candidate][name]
+		if !ok {
+			continue
+		}
+		group := []string{candidate}
+		for _, other := range repositories[i+1:] {
+			if otherMeta, ok := targets[other][name]; ok && sameTargetMeta(candidateMeta, otherMeta) {
+				group = append(group, other)
+			}
+		}
+		if len(group) > len(best) {
+			best = group
+		}
+	}
+	return best
+}
+
+// sameTargetMeta reports whether two target metadata describe the same bytes:
+// equal lengths and, for at least one shared algorithm, equal hashes.
+func sameTargetMeta(a, b data.TargetFileMeta) bool {
+	if a.Length != b.Length {
+		return false
+	}
+	shared := false
+	for alg, hash := range a.Hashes {
+		if other, ok := b.Hashes[alg]; ok {
+			if !bytes.Equal(hash, other) {
+				return false
+			}
+			shared = true
+		}
+	}
+	return shared
+}
+
+// matchesAny reports whether name matches any of the shell patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// MappedRepository is a repository of a TAP-4 setup assembled by
+// AssembleMultiRepository.
+type MappedRepository struct {
+	// Name is the name of the repository in the map file.
+	Name string
+	// Dir is the serialized repository, loadable as a TrustRoot of its own.
+	Dir string
+	// RootPath is the verified latest root.json in Dir.
+	RootPath string
+}
+
+// AssembleMultiRepository assembles each repository of a TAP-4 setup into its
+// own directory of workDir, named after the repository, so each is the
+// serialized repository of its own TrustRoot: the policy-controller loads a
+// single TUF repository per TrustRoot and trusts every target it finds in
+// its targets.json. The mapping is therefore enforced here: every target of
+// a repository must be vouched for by the mapping with that repository among
+// the agreeing ones, or no TrustRoot is assembled.
+//
+// Repositories are bootstrapped from rootsDir/<name>/root.json, the layout
+// TAP-4 clients keep their trusted roots in, never from a root of a mirror
+// trusted on first use, and then follow the root rotations of their first
+// mirror. Errors of the mapping wrap assembler.ErrVerification.
+//
+// Parameters:
+//   - mapPath: The path of the map.json file.
+//   - rootsDir: The directory of the trusted initial root of every repository.
+//   - workDir: The directory to assemble the repositories in.
+//
+// Returns:
+//   - The repositories, the first repository of the first mapping first, the others by name.
+//   - An error if a repository could not be verified or a target is not vouched for.
+func AssembleMultiRepository(mapPath, rootsDir, workDir string) ([]MappedRepository, error) {
+	m, err := LoadRepositoryMap(mapPath)
+	if err != nil {
+		return nil, err
+	}
+
+	primary := m.Mapping[0].Repositories[0]
+	names := []string{primary}
+	for name := range m.Repositories {
+		if name != primary {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	repositories := map[string]*verifiedRepository{}
+	targets := map[string]data.TargetFiles{}
+	for _, name := range names {
+		mirror := m.Repositories[name][0]
+		rootJSON, err := os.ReadFile(filepath.Join(rootsDir, name, "root.json"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read the trusted root.json of repository %s: %v", name, err)
+		}
+		log.Printf("bootstrapping repository %s from %s\n", name, filepath.Join(rootsDir, name, "root.json"))
+		repository, err := openVerifiedRepository(mirror, rootJSON)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("repository %s, mirror %s, %d targets\n", name, mirror, len(repository.Targets))
+		repositories[name] = repository
+		targets[name] = repository.Targets
+	}
+
+	// A TrustRoot trusts all the targets of its repository
+	for _, name := range names {
+		for _, target := range sortedTargetNames(targets[name]) {
+			agreeing, err := m.ResolveTarget(target, targets)
+			if err != nil {
+				return nil, fmt.Errorf("%w: target %s of repository %s: %v", assembler.ErrVerification, target, name, err)
+			}
+			if !slices.Contains(agreeing, name) {
+				return nil, fmt.Errorf("%w: target %s of repository %s is vouched for by %s only", assembler.ErrVerification, target, name, strings.Join(agreeing, ", "))
+			}
+		}
+	}
+
+	assembled := make([]MappedRepository, 0, len(names))
+	for _, name := range names {
+		dir := filepath.Join(workDir, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		rootJSONFile, err := repositories[name].assemble(dir)
+		if err != nil {
+			return nil, err
+		}
+		rootJSONFile.Close()
+		assembled = append(assembled, MappedRepository{Name: name, Dir: dir, RootPath: rootJSONFile.Name()})
+	}
+	return assembled, nil
+}
+
+// sortedTargetNames returns the names of targets in lexical order.
+func sortedTargetNames(targets data.TargetFiles) []string {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sortedKeys returns the keys of set in lexical order.
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
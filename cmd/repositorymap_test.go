@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"cmd/assembler"
+
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+func TestLoadRepositoryMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapJSON string
+		wantErr bool
+	}{
+		{
+			name: "valid map",
+			mapJSON: `{"repositories": {"internal": ["https://tuf.example"], "sigstore": ["https://tuf-repo-cdn.sigstore.dev"]},
+				"mapping": [{"paths": ["*"], "repositories": ["internal", "sigstore"], "threshold": 2, "terminating": true}]}`,
+		},
+		{
+			name: "unknown repository",
+			mapJSON: `{"repositories": {"sigstore": ["https://tuf-repo-cdn.sigstore.dev"]},
+				"mapping": [{"paths": ["*"], "repositories": ["internal"], "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name: "threshold too high",
+			mapJSON: `{"repositories": {"sigstore": ["https://tuf-repo-cdn.sigstore.dev"]},
+				"mapping": [{"paths": ["*"], "repositories": ["sigstore"], "threshold": 2}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no mapping",
+			mapJSON: `{"repositories": {"sigstore": ["https://tuf-repo-cdn.sigstore.dev"]}, "mapping": []}`,
+			wantErr: true,
+		},
+		{
+			name:    "repository name escaping the roots directory",
+			mapJSON: `{"repositories": {"../sigstore": ["https://tuf-repo-cdn.sigstore.dev"]}, "mapping": [{"paths": ["*"], "repositories": ["../sigstore"], "threshold": 1}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "repository without mirror",
+			mapJSON: `{"repositories": {"sigstore": []}, "mapping": [{"paths": ["*"], "repositories": ["sigstore"], "threshold": 1}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapPath := filepath.Join(t.TempDir(), "map.json")
+			if err := os.WriteFile(mapPath, []byte(tt.mapJSON), 0o644); err != nil {
+				t.Fatalf("Failed to write map file: %v", err)
+			}
+			_, err := LoadRepositoryMap(mapPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRepositoryMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	meta := func(length int64, sha256 string) data.TargetFileMeta {
+		return data.TargetFileMeta{FileMeta: data.FileMeta{Length: length, Hashes: data.Hashes{"sha256": data.HexBytes(sha256)}}}
+	}
+	targets := map[string]data.TargetFiles{
+		"internal": {"shared.pem": meta(1, "a"), "conflict.pem": meta(1, "a"), "internal.pem": meta(1, "i")},
+		"sigstore": {"shared.pem": meta(1, "a"), "conflict.pem": meta(1, "b"), "sigstore.pem": meta(1, "s")},
+	}
+	m := &RepositoryMap{
+		Repositories: map[string][]string{"internal": {"https://tuf.example"}, "sigstore": {"https://tuf-repo-cdn.sigstore.dev"}},
+		Mapping: []RepositoryMapping{
+			{Paths: []string{"internal.pem"}, Repositories: []string{"internal"}, Threshold: 1, Terminating: true},
+			{Paths: []string{"conflict.pem"}, Repositories: []string{"internal", "sigstore"}, Threshold: 2, Terminating: true},
+			{Paths: []string{"*"}, Repositories: []string{"internal", "sigstore"}, Threshold: 2},
+			{Paths: []string{"*"}, Repositories: []string{"sigstore"}, Threshold: 1},
+		},
+	}
+	tests := []struct {
+		name    string
+		target  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "single repository mapping", target: "internal.pem", want: []string{"internal"}},
+		{name: "agreeing repositories", target: "shared.pem", want: []string{"internal", "sigstore"}},
+		{name: "terminating disagreement", target: "conflict.pem", wantErr: true},
+		{name: "fall through to next mapping", target: "sigstore.pem", want: []string{"sigstore"}},
+		{name: "unknown target", target: "missing.pem", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.ResolveTarget(tt.target, targets)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolveTarget() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ResolveTarget() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssembleMultiRepository(t *testing.T) {
+	shared := map[string]string{"shared.pem": "shared"}
+	internalTargets := map[string]string{"shared.pem": "shared", "internal.pem": "internal"}
+	mapJSON := `{"repositories": {"sigstore": ["%s"], "internal": ["%s"]},
+		"mapping": [
+			{"paths": ["internal.pem"], "repositories": ["internal"], "threshold": 1, "terminating": true},
+			{"paths": ["*"], "repositories": ["sigstore", "internal"], "threshold": 2, "terminating": true}
+		]}`
+	tests := []struct {
+		name      string
+		internal  map[string]string
+		roots     map[string]string
+		wantDirs  []string
+		wantErr   bool
+		wantClass error
+	}{
+		{name: "vouched targets", internal: internalTargets, wantDirs: []string{"internal", "sigstore"}},
+		{name: "target not vouched for its repository", internal: map[string]string{"shared.pem": "other"}, wantErr: true, wantClass: assembler.ErrVerification},
+		{name: "missing trusted root", internal: internalTargets, roots: map[string]string{"internal": ""}, wantErr: true},
+		{name: "trusted root of another repository", internal: internalTargets, roots: map[string]string{"internal": "sigstore"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repositoryDirs := map[string]string{"sigstore": newTestRepositoryWithTargets(t, shared), "internal": newTestRepositoryWithTargets(t, tt.internal)}
+			servers := map[string]*httptest.Server{}
+			rootsDir := t.TempDir()
+			for name, dir := range repositoryDirs {
+				servers[name] = httptest.NewServer(RepositoryHandler(dir))
+				defer servers[name].Close()
+				source, ok := tt.roots[name]
+				if !ok {
+					source = name
+				}
+				if source == "" {
+					continue
+				}
+				rootJSON, err := os.ReadFile(filepath.Join(repositoryDirs[source], "1.root.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := os.MkdirAll(filepath.Join(rootsDir, name), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(rootsDir, name, "root.json"), rootJSON, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mapPath := filepath.Join(t.TempDir(), "map.json")
+			if err := os.WriteFile(mapPath, []byte(fmt.Sprintf(mapJSON, servers["sigstore"].URL, servers["internal"].URL)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			workDir := t.TempDir()
+			repositories, err := AssembleMultiRepository(mapPath, rootsDir, workDir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AssembleMultiRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantClass != nil && !errors.Is(err, tt.wantClass) {
+				t.Errorf("AssembleMultiRepository() error = %v, want %v", err, tt.wantClass)
+			}
+			var dirs []string
+			for _, repository := range repositories {
+				dirs = append(dirs, repository.Name)
+				if repository.Dir != filepath.Join(workDir, repository.Name) {
+					t.Errorf("repository %s assembled in %s", repository.Name, repository.Dir)
+				}
+				// Every repository loads as the repository of its own TrustRoot
+				if err := verifyArchive(repository.Dir, repository.RootPath); err != nil {
+					t.Errorf("repository %s does not load in the policy-controller: %v", repository.Name, err)
+				}
+			}
+			if !reflect.DeepEqual(dirs, tt.wantDirs) {
+				t.Errorf("AssembleMultiRepository() = %v, want %v", dirs, tt.wantDirs)
+			}
+		})
+	}
+}
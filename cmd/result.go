@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// RunResult is the --result-file document describing a run, for automation
+// consuming structured results instead of logs.
+type RunResult struct {
+	TrustRoot string `json:"trustRoot"`
+	// Source is the mirror or --map file of the repository, empty for
+	// SigstoreKeys TrustRoots.
+	Source string `json:"source,omitempty"`
+	Output string `json:"output"`
+	// RootDigest and RepositoryDigest are those of GenerationEvent.
+	RootDigest       string               `json:"rootDigest,omitempty"`
+	RepositoryDigest string               `json:"repositoryDigest,omitempty"`
+	Versions         map[string]int64     `json:"versions,omitempty"`
+	Expires          map[string]time.Time `json:"expires,omitempty"`
+	Files            []ResultFile         `json:"files"`
+	// Delta is the changelog since the --previous TrustRoot.
+	Delta *RepositoryDelta `json:"delta,omitempty"`
+}
+
+// ResultFile describes a file written by a run.
+type ResultFile struct {
+	// Kind is output for the printed output, root for --root-out and
+	// clientTrustConfig for --client-trust-config.
+	Kind string `json:"kind"`
+	// Path is "-" for stdout.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Kinds of ResultFile.
+const (
+	resultFileOutput            = "output"
+	resultFileRoot              = "root"
+	resultFileClientTrustConfig = "clientTrustConfig"
+)
+
+// DescribeFile returns the ResultFile of kind for the file at path.
+//
+// Parameters:
+//   - kind: The kind of the file.
+//   - path: The path of the file.
+//
+// Returns:
+//   - The ResultFile with the size and SHA-256 of the file.
+//   - An error if the file could not be read.
+func DescribeFile(kind, path string) (ResultFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ResultFile{}, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ResultFile{}, err
+	}
+	return ResultFile{Kind: kind, Path: path, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// describeOutput returns the ResultFile of the output printed to stdout.
+func describeOutput(output io.WriterTo) (ResultFile, error) {
+	hash := sha256.New()
+	size, err := output.WriteTo(hash)
+	if err != nil {
+		return ResultFile{}, err
+	}
+	return ResultFile{Kind: resultFileOutput, Path: "-", Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// metadataExpiries returns the expiry of the top-level metadata found in the
+// repository directory dir, by role file name, like metadataVersions.
+func metadataExpiries(dir string) (map[string]time.Time, error) {
+	expiries := map[string]time.Time{}
+	for _, role := range inspectRoles {
+		path, err := latestMetadataPath(dir, role)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var signed struct {
+			Signed struct {
+				Expires time.Time `json:"expires"`
+			} `json:"signed"`
+		}
+		if err := json.Unmarshal(content, &signed); err != nil {
+			return nil, fmt.Errorf("could not read expiry of %s: %v", role, err)
+		}
+		expiries[role] = signed.Signed.Expires.UTC()
+	}
+	return expiries, nil
+}
+
+// WriteRunResult writes result as indented JSON to path.
+//
+// Parameters:
+//   - path: The --result-file path.
+//   - result: The result of the run.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func WriteRunResult(path string, result RunResult) error {
+	content, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0o644)
+}
+
+// writeResultFile writes the RunResult of the repository assembled in
+// workDir, printed as output, and of the optional files written next to it,
+// kind to path, to the --result-file path, if set, exiting on errors.
+func writeResultFile(path, source, format, name, workDir string, rootJSONFile *os.File, output io.WriterTo, files map[string]string, delta *RepositoryDelta) {
+	if path == "" {
+		return
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	event, err := newGenerationEvent(name, format, workDir, rootJSON)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	expiries, err := metadataExpiries(workDir)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	result := RunResult{
+		TrustRoot:        name,
+		Source:           source,
+		Output:           format,
+		RootDigest:       event.RootDigest,
+		RepositoryDigest: event.RepositoryDigest,
+		Versions:         event.Versions,
+		Expires:          expiries,
+		Delta:            delta,
+	}
+	writeResult(path, result, output, files)
+}
+
+// writeResult adds the output and files to result and writes it to path,
+// exiting on errors.
+func writeResult(path string, result RunResult, output io.WriterTo, files map[string]string) {
+	described, err := describeOutput(output)
+	if err != nil {
+		log.Fatalf("Error: could not digest output: %v", err)
+	}
+	result.Files = append(result.Files, described)
+	for _, kind := range []string{resultFileRoot, resultFileClientTrustConfig} {
+		if files[kind] == "" {
+			continue
+		}
+		described, err := DescribeFile(kind, files[kind])
+		if err != nil {
+			log.Fatalf("Error: could not describe %s: %v", files[kind], err)
+		}
+		result.Files = append(result.Files, described)
+	}
+	if err := WriteRunResult(path, result); err != nil {
+		log.Fatalf("Error: could not write result file %s: %v", path, err)
+	}
+	log.Printf("result written to %s\n", path)
+}
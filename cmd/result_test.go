@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestDescribeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "root.json")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	got, err := DescribeFile(resultFileRoot, path)
+	if err != nil {
+		t.Fatalf("DescribeFile() error = %v", err)
+	}
+	want := ResultFile{Kind: resultFileRoot, Path: path, Size: 2, SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"}
+	if got != want {
+		t.Errorf("DescribeFile() = %+v, want %+v", got, want)
+	}
+	if _, err := DescribeFile(resultFileRoot, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("DescribeFile() of a missing file error = nil, want an error")
+	}
+}
+
+func TestDescribeOutput(t *testing.T) {
+	got, err := describeOutput(strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("describeOutput() error = %v", err)
+	}
+	if got.Path != "-" || got.Kind != resultFileOutput || got.Size != 2 || got.SHA256 != "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
+		t.Errorf("describeOutput() = %+v", got)
+	}
+}
+
+func TestMetadataExpiries(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+
+	expiries, err := metadataExpiries(workDir)
+	if err != nil {
+		t.Fatalf("metadataExpiries() error = %v", err)
+	}
+	for _, role := range inspectRoles {
+		if expiries[role].IsZero() || expiries[role].Location().String() != "UTC" {
+			t.Errorf("expiry of %s = %v", role, expiries[role])
+		}
+	}
+	if expiries, err := metadataExpiries(t.TempDir()); err != nil || len(expiries) != 0 {
+		t.Errorf("metadataExpiries() of an empty directory = %v, %v", expiries, err)
+	}
+}
+
+func TestWriteRunResult(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	result := RunResult{
+		TrustRoot: "sigstore-1",
+		Source:    "https://tuf-repo-cdn.sigstore.dev",
+		Output:    outputTrustRoot,
+		Versions:  map[string]int64{"snapshot.json": 3},
+		Files:     []ResultFile{{Kind: resultFileOutput, Path: "-", Size: 10, SHA256: "aa"}},
+	}
+	if err := WriteRunResult(path, result); err != nil {
+		t.Fatalf("WriteRunResult() error = %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read result: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("result is not JSON: %v", err)
+	}
+	if got["trustRoot"] != "sigstore-1" || got["versions"].(map[string]any)["snapshot.json"] != float64(3) || len(got["files"].([]any)) != 1 {
+		t.Errorf("result = %s", content)
+	}
+	if _, ok := got["expires"]; ok {
+		t.Errorf("empty expires should be omitted: %s", content)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// clusterImagePoliciesPath is the API path of the ClusterImagePolicies of the
+// policy-controller.
+const clusterImagePoliciesPath = "/apis/policy.sigstore.dev/v1beta1/clusterimagepolicies"
+
+// jsonPatchOperation is an RFC 6902 JSON Patch operation.
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// PolicyRewire is the rewiring of the trustRootRef fields of a
+// ClusterImagePolicy.
+type PolicyRewire struct {
+	// Name is the name of the ClusterImagePolicy.
+	Name string
+	// Patch is the JSON Patch testing every rewired trustRootRef for its
+	// previous TrustRoot and replacing it.
+	Patch []jsonPatchOperation
+}
+
+// RewireClusterImagePolicy points the trustRootRef fields of a
+// ClusterImagePolicy to the TrustRoot to, wherever the policy-controller
+// reads them: keyless authorities, CT logs, transparency logs and RFC 3161
+// timestamp authorities.
+//
+// Parameters:
+//   - policy: The YAML or JSON document of the policy, rewired in place.
+//   - from: A path.Match pattern of the TrustRoots to rewire, e.g. tuf-repo-cdn.sigstore.dev-*, "" for every TrustRoot.
+//   - to: The name of the TrustRoot to reference.
+//
+// Returns:
+//   - The rewiring of the policy, without operations if nothing references a TrustRoot matching from.
+//   - An error if from is malformed.
+func RewireClusterImagePolicy(policy *yaml.Node, from, to string) (PolicyRewire, error) {
+	if _, err := path.Match(from, ""); err != nil {
+		return PolicyRewire{}, fmt.Errorf("invalid TrustRoot pattern %q: %v", from, err)
+	}
+	root := policy
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	rewire := PolicyRewire{Name: mappingValue(mappingNode(root, "metadata"), "name")}
+	rewireNode(root, "", from, to, &rewire.Patch)
+	return rewire, nil
+}
+
+// rewireNode rewires the trustRootRef fields of node, at the JSON pointer
+// pointer, appending the operations to patch.
+func rewireNode(node *yaml.Node, pointer, from, to string, patch *[]jsonPatchOperation) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPointer := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key.Value)
+			if key.Value == "trustRootRef" && value.Kind == yaml.ScalarNode {
+				if matched, _ := path.Match(from, value.Value); (from == "" || matched) && value.Value != to {
+					*patch = append(*patch,
+						jsonPatchOperation{Op: "test", Path: fieldPointer, Value: value.Value},
+						jsonPatchOperation{Op: "replace", Path: fieldPointer, Value: to})
+					value.Value = to
+				}
+				continue
+			}
+			rewireNode(value, fieldPointer, from, to, patch)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			rewireNode(item, fmt.Sprintf("%s/%d", pointer, i), from, to, patch)
+		}
+	}
+}
+
+// mappingNode returns the value of key in the mapping node, nil if node is
+// not a mapping or has no key.
+func mappingNode(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the scalar value of key in the mapping node, "" if
+// there is none.
+func mappingValue(node *yaml.Node, key string) string {
+	value := mappingNode(node, key)
+	if value == nil || value.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return value.Value
+}
+
+// RewireManifests rewires the ClusterImagePolicies of a multi-document YAML
+// stream, see RewireClusterImagePolicy. Other documents are left alone.
+//
+// Parameters:
+//   - manifests: The YAML documents, e.g. the content of a file of a GitOps repository.
+//   - from: A path.Match pattern of the TrustRoots to rewire, "" for every TrustRoot.
+//   - to: The name of the TrustRoot to reference.
+//
+// Returns:
+//   - The rewiring of every policy referencing a TrustRoot matching from.
+//   - The rewired documents, indented by two spaces.
+//   - An error if the documents could not be parsed.
+func RewireManifests(manifests []byte, from, to string) ([]PolicyRewire, []byte, error) {
+	var rewires []PolicyRewire
+	var rewired bytes.Buffer
+	encoder := yaml.NewEncoder(&rewired)
+	encoder.SetIndent(2)
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		if len(document.Content) > 0 && mappingValue(document.Content[0], "kind") == "ClusterImagePolicy" {
+			rewire, err := RewireClusterImagePolicy(&document, from, to)
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(rewire.Patch) > 0 {
+				rewires = append(rewires, rewire)
+			}
+		}
+		if err := encoder.Encode(&document); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, err
+	}
+	return rewires, rewired.Bytes(), nil
+}
+
+// clusterImagePolicies returns the ClusterImagePolicies of the cluster.
+func (k *kubeClient) clusterImagePolicies() ([]json.RawMessage, error) {
+	var list struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := k.get(clusterImagePoliciesPath, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// RewireCluster rewires the ClusterImagePolicies of the cluster of kube, see
+// RewireClusterImagePolicy.
+//
+// Parameters:
+//   - kube: The client of the cluster.
+//   - from: A path.Match pattern of the TrustRoots to rewire, "" for every TrustRoot.
+//   - to: The name of the TrustRoot to reference.
+//   - apply: Whether to patch the policies, otherwise only their rewiring is returned.
+//
+// Returns:
+//   - The rewiring of every policy referencing a TrustRoot matching from.
+//   - An error if the policies could not be listed or patched.
+func RewireCluster(kube *kubeClient, from, to string, apply bool) ([]PolicyRewire, error) {
+	policies, err := kube.clusterImagePolicies()
+	if err != nil {
+		return nil, fmt.Errorf("could not list ClusterImagePolicies: %v", err)
+	}
+	var rewires []PolicyRewire
+	for _, policy := range policies {
+		var document yaml.Node
+		if err := yaml.Unmarshal(policy, &document); err != nil {
+			return nil, fmt.Errorf("could not read ClusterImagePolicy: %v", err)
+		}
+		rewire, err := RewireClusterImagePolicy(&document, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if len(rewire.Patch) == 0 {
+			continue
+		}
+		if apply {
+			patch, _ := json.Marshal(rewire.Patch)
+			if err := kube.jsonPatch(clusterImagePoliciesPath+"/"+rewire.Name, patch); err != nil {
+				return rewires, fmt.Errorf("could not rewire ClusterImagePolicy %s: %v", rewire.Name, err)
+			}
+		}
+		rewires = append(rewires, rewire)
+	}
+	return rewires, nil
+}
+
+// kubectlPatch returns the kubectl command applying the rewiring.
+func (r PolicyRewire) kubectlPatch() string {
+	patch, _ := json.Marshal(r.Patch)
+	return fmt.Sprintf("kubectl patch clusterimagepolicy %s --type=json -p '%s'", r.Name, patch)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testClusterImagePolicies are ClusterImagePolicies referencing TrustRoots
+// from keyless authorities, CT logs and timestamp authorities.
+const testClusterImagePolicies = `# Production policies
+apiVersion: policy.sigstore.dev/v1beta1
+kind: ClusterImagePolicy
+metadata:
+  name: keyless
+spec:
+  images:
+    - glob: "ghcr.io/example/**"
+  authorities:
+    - keyless:
+        trustRootRef: sigstore-1700000000 # rotated by the CronJob
+        url: https://fulcio.sigstore.dev
+      ctlog:
+        trustRootRef: sigstore-1700000000
+    - rfc3161timestamp:
+        trustRootRef: private-tsa
+---
+apiVersion: policy.sigstore.dev/v1beta1
+kind: ClusterImagePolicy
+metadata:
+  name: up-to-date
+spec:
+  authorities:
+    - keyless:
+        trustRootRef: sigstore-1800000000
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: trustRootRef
+data:
+  trustRootRef: sigstore-1700000000
+`
+
+func TestRewireManifests(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      string
+		wantPatch []jsonPatchOperation
+		wantRefs  int
+		wantErr   bool
+	}{
+		{
+			name: "matching pattern",
+			from: "sigstore-*",
+			wantPatch: []jsonPatchOperation{
+				{Op: "test", Path: "/spec/authorities/0/keyless/trustRootRef", Value: "sigstore-1700000000"},
+				{Op: "replace", Path: "/spec/authorities/0/keyless/trustRootRef", Value: "sigstore-1800000000"},
+				{Op: "test", Path: "/spec/authorities/0/ctlog/trustRootRef", Value: "sigstore-1700000000"},
+				{Op: "replace", Path: "/spec/authorities/0/ctlog/trustRootRef", Value: "sigstore-1800000000"},
+			},
+			wantRefs: 3,
+		},
+		{
+			name: "every TrustRoot",
+			wantPatch: []jsonPatchOperation{
+				{Op: "test", Path: "/spec/authorities/0/keyless/trustRootRef", Value: "sigstore-1700000000"},
+				{Op: "replace", Path: "/spec/authorities/0/keyless/trustRootRef", Value: "sigstore-1800000000"},
+				{Op: "test", Path: "/spec/authorities/0/ctlog/trustRootRef", Value: "sigstore-1700000000"},
+				{Op: "replace", Path: "/spec/authorities/0/ctlog/trustRootRef", Value: "sigstore-1800000000"},
+				{Op: "test", Path: "/spec/authorities/1/rfc3161timestamp/trustRootRef", Value: "private-tsa"},
+				{Op: "replace", Path: "/spec/authorities/1/rfc3161timestamp/trustRootRef", Value: "sigstore-1800000000"},
+			},
+			wantRefs: 4,
+		},
+		{name: "malformed pattern", from: "sigstore-[", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewires, rewired, err := RewireManifests([]byte(testClusterImagePolicies), tt.from, "sigstore-1800000000")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RewireManifests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(rewires) != 1 || rewires[0].Name != "keyless" {
+				t.Fatalf("RewireManifests() = %+v, want the keyless policy only", rewires)
+			}
+			if !reflect.DeepEqual(rewires[0].Patch, tt.wantPatch) {
+				t.Errorf("patch = %+v, want %+v", rewires[0].Patch, tt.wantPatch)
+			}
+			if got := strings.Count(string(rewired), "trustRootRef: sigstore-1800000000"); got != tt.wantRefs {
+				t.Errorf("rewired manifests have %d references to the new TrustRoot, want %d:\n%s", got, tt.wantRefs, rewired)
+			}
+			for _, kept := range []string{"# Production policies", "# rotated by the CronJob", "  trustRootRef: sigstore-1700000000\n"} {
+				if !strings.Contains(string(rewired), kept) {
+					t.Errorf("rewired manifests lost %q:\n%s", kept, rewired)
+				}
+			}
+		})
+	}
+}
+
+func TestRewireCluster(t *testing.T) {
+	var patches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == clusterImagePoliciesPath:
+			w.Write([]byte(`{"items": [
+				{"metadata": {"name": "keyless"}, "spec": {"authorities": [{"keyless": {"trustRootRef": "sigstore-1700000000"}}]}},
+				{"metadata": {"name": "keys"}, "spec": {"authorities": [{"key": {"data": "..."}}]}}
+			]}`))
+		case r.Method == http.MethodPatch && r.Header.Get("Content-Type") == "application/json-patch+json":
+			body, _ := io.ReadAll(r.Body)
+			patches = append(patches, r.URL.Path+" "+string(body))
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	kube := &kubeClient{host: server.URL, client: server.Client()}
+
+	for _, apply := range []bool{false, true} {
+		patches = nil
+		rewires, err := RewireCluster(kube, "", "sigstore-1800000000", apply)
+		if err != nil {
+			t.Fatalf("RewireCluster(apply=%v) error = %v", apply, err)
+		}
+		if len(rewires) != 1 || rewires[0].Name != "keyless" {
+			t.Fatalf("RewireCluster(apply=%v) = %+v, want the keyless policy only", apply, rewires)
+		}
+		patch, _ := json.Marshal(rewires[0].Patch)
+		wantPatches := []string(nil)
+		if apply {
+			wantPatches = []string{clusterImagePoliciesPath + "/keyless " + string(patch)}
+		}
+		if !reflect.DeepEqual(patches, wantPatches) {
+			t.Errorf("patches(apply=%v) = %q, want %q", apply, patches, wantPatches)
+		}
+	}
+	if got := (PolicyRewire{Name: "keyless", Patch: []jsonPatchOperation{{Op: "replace", Path: "/a", Value: "b"}}}).kubectlPatch(); got != `kubectl patch clusterimagepolicy keyless --type=json -p '[{"op":"replace","path":"/a","value":"b"}]'` {
+		t.Errorf("kubectlPatch() = %s", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// rootKeyRoles are the roles whose keys a root.json delegates, in the order
+// of WriteRootKeyReport.
+var rootKeyRoles = []string{"root", "targets", "snapshot", "timestamp"}
+
+// RoleKeys describes the keys a root.json trusts for a top-level role.
+type RoleKeys struct {
+	Role      string
+	Threshold int
+	Keys      []RootKey
+}
+
+// RootKey describes a key of a root.json.
+type RootKey struct {
+	// ID is the TUF key ID, published by the root-signing ceremony.
+	ID   string
+	Type string
+	// Owner is the x-tuf-on-ci-keyowner of the key, the keyholder of the
+	// Sigstore root, empty if the key has none.
+	Owner string
+	// Fingerprint is the hex SHA-256 of the public key, DER for PEM keys
+	// and raw for hex encoded ones.
+	Fingerprint string
+}
+
+// RootKeys lists the keys and thresholds of the top-level roles of a
+// root.json, so operators can compare them against the published
+// root-signing ceremony artifacts before trusting it.
+//
+// Parameters:
+//   - rootJSON: The content of the root.json file.
+//
+// Returns:
+//   - The keys of the root, targets, snapshot and timestamp roles in this order, with their key IDs in root.json order.
+//   - An error if the document could not be decoded or references an undefined key.
+func RootKeys(rootJSON []byte) ([]RoleKeys, error) {
+	var root struct {
+		Signed struct {
+			Keys map[string]struct {
+				KeyType string `json:"keytype"`
+				KeyVal  struct {
+					Public string `json:"public"`
+				} `json:"keyval"`
+				Owner string `json:"x-tuf-on-ci-keyowner"`
+			} `json:"keys"`
+			Roles map[string]struct {
+				KeyIDs    []string `json:"keyids"`
+				Threshold int      `json:"threshold"`
+			} `json:"roles"`
+		} `json:"signed"`
+	}
+	if err := json.Unmarshal(rootJSON, &root); err != nil {
+		return nil, err
+	}
+	var roles []RoleKeys
+	for _, name := range rootKeyRoles {
+		role, ok := root.Signed.Roles[name]
+		if !ok {
+			return nil, fmt.Errorf("root.json has no %s role", name)
+		}
+		roleKeys := RoleKeys{Role: name, Threshold: role.Threshold}
+		for _, id := range role.KeyIDs {
+			key, ok := root.Signed.Keys[id]
+			if !ok {
+				return nil, fmt.Errorf("the %s role references the undefined key %s", name, id)
+			}
+			roleKeys.Keys = append(roleKeys.Keys, RootKey{ID: id, Type: key.KeyType, Owner: key.Owner, Fingerprint: publicKeyFingerprint(key.KeyVal.Public)})
+		}
+		roles = append(roles, roleKeys)
+	}
+	return roles, nil
+}
+
+// publicKeyFingerprint returns the hex SHA-256 of the DER of a PEM public key
+// or of the bytes of a hex encoded one.
+func publicKeyFingerprint(public string) string {
+	content := []byte(public)
+	if block, _ := pem.Decode(content); block != nil {
+		content = block.Bytes
+	} else if raw, err := hex.DecodeString(strings.TrimSpace(public)); err == nil {
+		content = raw
+	}
+	fingerprint := sha256.Sum256(content)
+	return hex.EncodeToString(fingerprint[:])
+}
+
+// WriteRootKeyReport writes the RootKeys of rootJSON as a table, one line per
+// key with the threshold of its role.
+//
+// Parameters:
+//   - w: Where the report is written.
+//   - rootJSON: The content of the verified root.json file.
+//
+// Returns:
+//   - error: nil if successful, otherwise an error describing what went wrong.
+func WriteRootKeyReport(w io.Writer, rootJSON []byte) error {
+	roles, err := RootKeys(rootJSON)
+	if err != nil {
+		return fmt.Errorf("could not read root.json keys: %v", err)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tTHRESHOLD\tKEY ID\tTYPE\tOWNER\tFINGERPRINT")
+	for _, role := range roles {
+		threshold := fmt.Sprintf("%d/%d", role.Threshold, len(role.Keys))
+		for _, key := range role.Keys {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\tSHA256:%s\n", role.Role, threshold, key.ID, key.Type, orDash(key.Owner), key.Fingerprint)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestRootKeys(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	derFingerprint := sha256.Sum256(der)
+	rawFingerprint := sha256.Sum256([]byte{0xab, 0xcd})
+	keys := map[string]any{
+		"ceremony": map[string]any{"keytype": "ecdsa", "keyval": map[string]string{"public": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}, "x-tuf-on-ci-keyowner": "@keyholder"},
+		"online":   map[string]any{"keytype": "ed25519", "keyval": map[string]string{"public": "abcd"}},
+	}
+	rootJSON := func(roles map[string]any) []byte {
+		content, err := json.Marshal(map[string]any{"signed": map[string]any{"keys": keys, "roles": roles}})
+		if err != nil {
+			t.Fatalf("Failed to marshal root: %v", err)
+		}
+		return content
+	}
+	valid := map[string]any{
+		"root":      map[string]any{"keyids": []string{"ceremony"}, "threshold": 1},
+		"targets":   map[string]any{"keyids": []string{"ceremony"}, "threshold": 1},
+		"snapshot":  map[string]any{"keyids": []string{"online"}, "threshold": 1},
+		"timestamp": map[string]any{"keyids": []string{"online"}, "threshold": 1},
+	}
+
+	tests := []struct {
+		name     string
+		rootJSON []byte
+		wantErr  bool
+	}{
+		{"valid", rootJSON(valid), false},
+		{"missing role", rootJSON(map[string]any{"root": valid["root"]}), true},
+		{"undefined key", rootJSON(map[string]any{
+			"root": map[string]any{"keyids": []string{"unknown"}, "threshold": 1}, "targets": valid["targets"], "snapshot": valid["snapshot"], "timestamp": valid["timestamp"],
+		}), true},
+		{"not json", []byte("{"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roles, err := RootKeys(tt.rootJSON)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RootKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if len(roles) != 4 || roles[0].Role != "root" || roles[3].Role != "timestamp" {
+				t.Fatalf("RootKeys() = %+v", roles)
+			}
+			want := RootKey{ID: "ceremony", Type: "ecdsa", Owner: "@keyholder", Fingerprint: hex.EncodeToString(derFingerprint[:])}
+			if roles[0].Threshold != 1 || len(roles[0].Keys) != 1 || roles[0].Keys[0] != want {
+				t.Errorf("root keys = %+v, want %+v", roles[0], want)
+			}
+			if got := roles[2].Keys[0].Fingerprint; got != hex.EncodeToString(rawFingerprint[:]) {
+				t.Errorf("fingerprint of a hex key = %s", got)
+			}
+		})
+	}
+}
+
+func TestWriteRootKeyReport(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteRootKeyReport(&out, mockmirror.RootJSON()); err != nil {
+		t.Fatalf("WriteRootKeyReport() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "ROLE") {
+		t.Fatalf("WriteRootKeyReport() = %q", out.String())
+	}
+	for i, role := range rootKeyRoles {
+		if fields := strings.Fields(lines[i+1]); fields[0] != role || fields[1] != "1/1" || len(fields[2]) != 64 || !strings.HasPrefix(fields[5], "SHA256:") {
+			t.Errorf("line %q, want a %s key", lines[i+1], role)
+		}
+	}
+}
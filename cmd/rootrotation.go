@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// exitCodeRootRotated is the exit code of a run whose root keys or
+// thresholds changed since the previous TrustRoot, so a scheduled job pages
+// a human to review the rotation although the TrustRoot was generated.
+const exitCodeRootRotated = 8
+
+// errRootRotated is the failure class of a rotation of the root keys.
+var errRootRotated = errors.New("root keys rotated")
+
+// rootKeysRotatedReason is the reason of the Kubernetes Event a Watcher
+// records on its TrustRoot when the root keys rotate.
+const rootKeysRotatedReason = "RootKeysRotated"
+
+// previousRoot returns the root.json of the previous TrustRoot of mirror,
+// the --previous manifest if set, or else the latest generation of mirror in
+// the history. It returns no root if there is no previous TrustRoot, or if
+// it embeds no root.json, e.g. a SigstoreKeys TrustRoot or a ConfigMap.
+//
+// Parameters:
+//   - previous: The path of the --previous manifest, "" for the history.
+//   - history: The history, nil if disabled.
+//   - mirror: The mirror the TrustRoot is assembled from.
+//
+// Returns:
+//   - The previous root.json, nil if there is none.
+//   - A description of where it comes from, for messages.
+//   - An error if the previous TrustRoot could not be read.
+func previousRoot(previous string, history *History, mirror string) ([]byte, string, error) {
+	if previous != "" {
+		manifest, err := os.ReadFile(previous)
+		if err != nil {
+			return nil, "", err
+		}
+		rootJSON, err := trustRootRootJSON(manifest)
+		return rootJSON, previous, err
+	}
+	if history == nil {
+		return nil, "", nil
+	}
+	entries, err := history.List()
+	if err != nil {
+		return nil, "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Mirror != mirror {
+			continue
+		}
+		manifest, err := history.Manifest(entries[i].Generation)
+		if err != nil {
+			return nil, "", err
+		}
+		rootJSON, err := trustRootRootJSON(manifest)
+		if err != nil {
+			// Outputs other than TrustRoots, e.g. a digest, are not compared
+			return nil, "", nil
+		}
+		return rootJSON, fmt.Sprintf("generation %d of %s", entries[i].Generation, history.Dir), nil
+	}
+	return nil, "", nil
+}
+
+// trustRootRootJSON returns the root.json of a repository or remote
+// TrustRoot manifest, nil for a SigstoreKeys TrustRoot.
+func trustRootRootJSON(manifest []byte) ([]byte, error) {
+	trustRoot, err := ReadTrustRoot(manifest)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case trustRoot.Spec.Repository != nil:
+		return trustRoot.Spec.Repository.Root, nil
+	case trustRoot.Spec.Remote != nil:
+		return trustRoot.Spec.Remote.Root, nil
+	}
+	return nil, nil
+}
+
+// checkRootRotation compares the root.json at rootPath with that of the
+// previous TrustRoot of mirror and warns prominently about rotated root
+// keys and thresholds, which humans should review before the TrustRoot is
+// trusted. With --fail-on-warning the run fails with exitCodeRootRotated,
+// otherwise exitOnRootRotation exits with it once the TrustRoot is emitted.
+func checkRootRotation(previous string, history *History, mirror, rootPath string) []KeyRotation {
+	previousRootJSON, source, err := previousRoot(previous, history, mirror)
+	if err != nil {
+		log.Fatalf("Error: could not read the previous TrustRoot of %s: %v", mirror, err)
+	}
+	if len(previousRootJSON) == 0 {
+		return nil
+	}
+	rootJSON, err := os.ReadFile(rootPath)
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	rotations, err := CompareRootKeys(previousRootJSON, rootJSON)
+	if err != nil {
+		log.Fatalf("Error: could not compare the root keys with %s: %v", source, err)
+	}
+	if len(rotations) > 0 {
+		lines := make([]string, 0, len(rotations))
+		for _, rotation := range rotations {
+			lines = append(lines, rotation.String())
+		}
+		warnf(errRootRotated, "ROOT KEYS ROTATED since %s, review the new root before trusting it:\n  %s", source, strings.Join(lines, "\n  "))
+	}
+	return rotations
+}
+
+// exitOnRootRotation exits with exitCodeRootRotated if the root keys rotated,
+// removing the temporary working directory workDir the deferred cleanup of
+// main would. It is called once the TrustRoot has been emitted.
+func exitOnRootRotation(rotations []KeyRotation, workDir string) {
+	if len(rotations) > 0 {
+		os.RemoveAll(workDir)
+		os.Exit(exitCodeRootRotated)
+	}
+}
+
+// recordRootRotationEvent records a Warning Event about the rotation of the
+// root keys of the TrustRoot name, so it shows up in kubectl describe and
+// alerts watching cluster events.
+func (k *kubeClient) recordRootRotationEvent(name, message string) error {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	namespace := k.namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	eventName := name + "." + hex.EncodeToString(suffix)
+	now := time.Now().UTC().Format(time.RFC3339)
+	event, _ := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata":   map[string]any{"name": eventName, "namespace": namespace},
+		"involvedObject": map[string]string{
+			"apiVersion": "policy.sigstore.dev/v1alpha1",
+			"kind":       "TrustRoot",
+			"name":       name,
+		},
+		"type":           "Warning",
+		"reason":         rootKeysRotatedReason,
+		"message":        message,
+		"source":         map[string]string{"component": fieldManager},
+		"firstTimestamp": now,
+		"lastTimestamp":  now,
+		"count":          1,
+	})
+	return k.apply(fmt.Sprintf("/api/v1/namespaces/%s/events/%s", namespace, eventName), event, false)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+func TestCompareRootKeys(t *testing.T) {
+	oldKey, _ := keys.GenerateEd25519Key()
+	newKey, _ := keys.GenerateEd25519Key()
+	oldRoot := testRoot(t, 1, oldKey, oldKey)
+	oldID, newID := oldKey.PublicData().IDs()[0], newKey.PublicData().IDs()[0]
+	// The root role comes first in the sorted roles of the root
+	raisedThreshold := []byte(strings.Replace(string(oldRoot), `"threshold":1`, `"threshold":2`, 1))
+
+	tests := []struct {
+		name    string
+		current []byte
+		want    []KeyRotation
+	}{
+		{name: "unchanged", current: testRoot(t, 2, oldKey, oldKey)},
+		{
+			name:    "rotated",
+			current: testRoot(t, 2, newKey, oldKey, newKey),
+			want: []KeyRotation{
+				{Role: "root", Added: []string{newID}, Removed: []string{oldID}},
+				{Role: "targets", Added: []string{newID}, Removed: []string{oldID}},
+				{Role: "snapshot", Added: []string{newID}, Removed: []string{oldID}},
+				{Role: "timestamp", Added: []string{newID}, Removed: []string{oldID}},
+			},
+		},
+		{
+			name:    "threshold",
+			current: raisedThreshold,
+			want:    []KeyRotation{{Role: "root", PreviousThreshold: 1, Threshold: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CompareRootKeys(oldRoot, tt.current)
+			if err != nil {
+				t.Fatalf("CompareRootKeys() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompareRootKeys() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got := (KeyRotation{Role: "root", Added: []string{"a"}, PreviousThreshold: 1, Threshold: 2}).String(); got != "root keys rotated: added a; threshold 1 -> 2" {
+		t.Errorf("String() = %q", got)
+	}
+	if _, err := CompareRootKeys([]byte("{"), oldRoot); err == nil {
+		t.Error("CompareRootKeys() of a malformed root succeeded")
+	}
+}
+
+func TestPreviousRoot(t *testing.T) {
+	key, _ := keys.GenerateEd25519Key()
+	rootJSON := testRoot(t, 1, key, key)
+	dir := t.TempDir()
+	previous := filepath.Join(dir, "previous.yaml")
+	if err := os.WriteFile(previous, testTrustRootObject(t, map[string]any{"remote": map[string]any{"mirror": "https://tuf.example.com", "root": rootJSON}}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	versionsDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(versionsDir, "1.root.json"), rootJSON, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	history := &History{Dir: filepath.Join(dir, "history"), Keep: 10}
+	if _, err := history.Record("sigstore", "https://tuf.example.com", versionsDir, string(testTrustRootObject(t, map[string]any{"repository": map[string]any{"root": rootJSON}}))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := history.Record("other", "https://other.example.com", versionsDir, "sha256:0000\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		previous string
+		history  *History
+		mirror   string
+		want     []byte
+		source   string
+	}{
+		{name: "none", mirror: "https://tuf.example.com"},
+		{name: "previous", previous: previous, history: history, mirror: "https://tuf.example.com", want: rootJSON, source: previous},
+		{name: "history", history: history, mirror: "https://tuf.example.com", want: rootJSON, source: "generation 1 of " + history.Dir},
+		{name: "not a TrustRoot", history: history, mirror: "https://other.example.com"},
+		{name: "other mirror", history: history, mirror: "https://mirror.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, source, err := previousRoot(tt.previous, tt.history, tt.mirror)
+			if err != nil {
+				t.Fatalf("previousRoot() error = %v", err)
+			}
+			if string(got) != string(tt.want) || source != tt.source {
+				t.Errorf("previousRoot() = %q, %q, want %q, %q", got, source, tt.want, tt.source)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"cmd/assembler"
+)
+
+// rootSourceUsage is the usage of the --root-source flag.
+const rootSourceUsage = "Source of the initial root.json the mirror is verified from instead of the mirror itself: root-signing for the repository published by the sigstore/root-signing GitHub repository, another repository URL or directory whose latest N.root.json is used, or the URL or path of a root.json file, e.g. a root-signing release asset"
+
+// rootChecksumUsage is the usage of the --root-checksum flag.
+const rootChecksumUsage = "Hex encoded SHA-256 of the initial root.json, of the mirror or --root-source, failing closed if the root the repository is bootstrapped from is not the reviewed one"
+
+var (
+	// rootSource is the --root-source value, "" to bootstrap from the
+	// mirror.
+	rootSource string
+	// rootChecksum is the --root-checksum value, "" to trust the initial
+	// root.json on first use.
+	rootChecksum string
+)
+
+// initialRoot returns the root.json the client verifying mirror is
+// initialized with: the latest root.json of the mirror, or that of the
+// --root-source, so the trust anchor does not depend on the mirror being
+// assembled. The client then follows the root rotations of the mirror from
+// it. Errors wrap assembler.ErrMirrorUnreachable, or with --root-checksum
+// assembler.ErrVerification for a root.json of another digest.
+func initialRoot(mirror string) ([]byte, error) {
+	var rootJSON []byte
+	var err error
+	if rootSource == "" {
+		rootJSON, err = fetchLatestRoot(mirror)
+	} else {
+		rootJSON, err = fetchRootSourceRoot(rootSourceName(mirror))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get the latest root.json from %s: %w: %v", rootSourceName(mirror), assembler.ErrMirrorUnreachable, err)
+	}
+	if rootChecksum != "" {
+		if err := CheckRootChecksum(rootJSON, rootChecksum); err != nil {
+			return nil, err
+		}
+	}
+	return rootJSON, nil
+}
+
+// validRootChecksum reports whether checksum is a hex encoded SHA-256,
+// optionally prefixed with sha256:.
+func validRootChecksum(checksum string) bool {
+	digest, err := hex.DecodeString(strings.TrimPrefix(checksum, "sha256:"))
+	return err == nil && len(digest) == sha256.Size
+}
+
+// CheckRootChecksum pins the root.json a repository is bootstrapped from to
+// the digest of a reviewed one.
+//
+// Parameters:
+//   - rootJSON: The initial root.json.
+//   - checksum: The expected hex encoded SHA-256, optionally prefixed with sha256:.
+//
+// Returns:
+//   - error: nil if the digests match, otherwise an error wrapping assembler.ErrVerification.
+func CheckRootChecksum(rootJSON []byte, checksum string) error {
+	sum := sha256.Sum256(rootJSON)
+	got := hex.EncodeToString(sum[:])
+	if !strings.EqualFold(strings.TrimPrefix(checksum, "sha256:"), got) {
+		return fmt.Errorf("%w: the initial root.json has SHA-256 %s, not the pinned %s", assembler.ErrVerification, got, checksum)
+	}
+	return nil
+}
+
+// rootSourceName returns the source initialRoot gets the root.json of mirror
+// from, for messages.
+func rootSourceName(mirror string) string {
+	switch rootSource {
+	case "":
+		return mirror
+	case "root-signing":
+		return rootSigningRepository
+	default:
+		return rootSource
+	}
+}
+
+// fetchRootSourceRoot returns the root.json file at source, if it names a
+// .json file, or else the latest root.json of the repository at source.
+func fetchRootSourceRoot(source string) ([]byte, error) {
+	var rootJSON []byte
+	var err error
+	switch {
+	case !strings.HasSuffix(source, ".json"):
+		rootJSON, err = fetchLatestRoot(source)
+	case isHTTPSource(source):
+		rootJSON, err = fetch(source)
+	default:
+		rootJSON, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	version, err := assembler.MetadataVersion(rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("bootstrapping from %d.root.json of %s\n", version, source)
+	return rootJSON, nil
+}
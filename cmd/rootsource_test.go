@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+func TestAssembleRepositoryRootSource(t *testing.T) {
+	defer func(source, checksum string) { rootSource, rootChecksum = source, checksum }(rootSource, rootChecksum)
+	server := mockmirror.NewServer()
+	defer server.Close()
+	published := mockmirror.NewServer()
+	defer published.Close()
+	otherKey, _ := keys.GenerateEd25519Key()
+	dir := t.TempDir()
+	rootPath := filepath.Join(dir, "root.json")
+	otherRootPath := filepath.Join(dir, "other.root.json")
+	if err := os.WriteFile(rootPath, mockmirror.RootJSON(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(otherRootPath, testRoot(t, 1, otherKey, otherKey), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(mockmirror.RootJSON())
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		source   string
+		checksum string
+		wantErr  error
+	}{
+		{name: "mirror"},
+		{name: "pinned root of the mirror", checksum: checksum},
+		{name: "pinned root of the root source", source: rootPath, checksum: "sha256:" + checksum},
+		{name: "pinned root differs", source: otherRootPath, checksum: checksum, wantErr: assembler.ErrVerification},
+		{name: "repository", source: published.URL},
+		{name: "root.json URL", source: published.URL + "/1.root.json"},
+		{name: "root.json file", source: rootPath},
+		{name: "missing root.json", source: published.URL + "/2.root.json", wantErr: assembler.ErrMirrorUnreachable},
+		{name: "root of another repository", source: otherRootPath, wantErr: assembler.ErrVerification},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootSource, rootChecksum = tt.source, tt.checksum
+			rootJSONFile, err := AssembleRepository(server.URL, t.TempDir())
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("AssembleRepository() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AssembleRepository() error = %v", err)
+			}
+			defer rootJSONFile.Close()
+			var rootJSON bytes.Buffer
+			if _, err := rootJSON.ReadFrom(rootJSONFile); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rootJSON.Bytes(), mockmirror.RootJSON()) {
+				t.Error("AssembleRepository() root.json is not that of the mirror")
+			}
+		})
+	}
+}
+
+func TestCheckRootChecksum(t *testing.T) {
+	rootJSON := mockmirror.RootJSON()
+	sum := sha256.Sum256(rootJSON)
+	checksum := hex.EncodeToString(sum[:])
+	tests := []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{"match", checksum, false},
+		{"prefixed", "sha256:" + checksum, false},
+		{"upper case", strings.ToUpper(checksum), false},
+		{"mismatch", strings.Repeat("0", 64), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckRootChecksum(rootJSON, tt.checksum)
+			if (err != nil) != tt.wantErr || (err != nil && !errors.Is(err, assembler.ErrVerification)) {
+				t.Errorf("CheckRootChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidRootChecksum(t *testing.T) {
+	tests := []struct {
+		checksum string
+		want     bool
+	}{
+		{strings.Repeat("ab", 32), true},
+		{"sha256:" + strings.Repeat("ab", 32), true},
+		{strings.Repeat("ab", 20), false},
+		{strings.Repeat("zz", 32), false},
+	}
+	for _, tt := range tests {
+		if got := validRootChecksum(tt.checksum); got != tt.want {
+			t.Errorf("validRootChecksum(%q) = %v, want %v", tt.checksum, got, tt.want)
+		}
+	}
+}
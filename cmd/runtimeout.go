@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// exitCodeRunTimeout is the exit code of a run that exceeded --run-timeout.
+const exitCodeRunTimeout = 7
+
+// errRunTimeout is the failure class of a run that exceeded --run-timeout.
+var errRunTimeout = errors.New("run timeout exceeded")
+
+// runTimeoutUsage is the usage of the --run-timeout flag.
+const runTimeoutUsage = "Deadline of the whole run, after which it fails with exit code 7 even if requests are still in progress, 0 for none"
+
+var (
+	runCleanupsMu sync.Mutex
+	runCleanups   []func()
+)
+
+// onRunTimeout registers cleanup to run before exiting on the --run-timeout
+// deadline, e.g. to remove a temporary directory.
+func onRunTimeout(cleanup func()) {
+	runCleanupsMu.Lock()
+	defer runCleanupsMu.Unlock()
+	runCleanups = append(runCleanups, cleanup)
+}
+
+// startRunDeadline calls expired in its own goroutine when timeout elapses,
+// unless the returned stop function is called first. A timeout of 0 never
+// expires.
+func startRunDeadline(timeout time.Duration, expired func()) (stop func()) {
+	if timeout <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(timeout, expired)
+	return func() { timer.Stop() }
+}
+
+// exitOnRunTimeout runs the cleanups registered with onRunTimeout and exits
+// with exitCodeRunTimeout. It is the expired function of the main command.
+func exitOnRunTimeout(timeout time.Duration) func() {
+	return func() {
+		runCleanupsMu.Lock()
+		for _, cleanup := range runCleanups {
+			cleanup()
+		}
+		runCleanupsMu.Unlock()
+		fatalf(errRunTimeout, "Error: %v: the run did not complete within --run-timeout of %s", errRunTimeout, timeout)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStartRunDeadline(t *testing.T) {
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		stopAfter   time.Duration
+		wantExpired bool
+	}{
+		{"expires", 10 * time.Millisecond, 0, true},
+		{"stopped", time.Second, 10 * time.Millisecond, false},
+		{"no timeout", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expired := make(chan struct{}, 1)
+			stop := startRunDeadline(tt.timeout, func() { expired <- struct{}{} })
+			if tt.stopAfter > 0 {
+				time.Sleep(tt.stopAfter)
+				stop()
+			}
+			select {
+			case <-expired:
+				if !tt.wantExpired {
+					t.Errorf("startRunDeadline() expired, want it not to")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.wantExpired {
+					t.Errorf("startRunDeadline() did not expire after %s", tt.timeout)
+				}
+			}
+			stop()
+		})
+	}
+}
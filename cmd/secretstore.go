@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Keys of the Secret written by --store-secret.
+const (
+	secretRootKey    = "root.json"
+	secretArchiveKey = "repository.tar.gz"
+	// previousKeySuffix is appended to the keys of the generation replaced
+	// by an update of the Secret.
+	previousKeySuffix = ".previous"
+)
+
+// repositoryDigestAnnotation records the RepositoryDigest of the trust
+// material of a --store-secret Secret, so an unchanged repository does not
+// rotate it.
+const repositoryDigestAnnotation = "trustroot-assembler.sigstore.dev/repository-digest"
+
+// RotateSecretData returns the data of a Secret holding rootJSON and archive,
+// with the root.json and repository.tar.gz of current, the data of the
+// Secret being replaced, kept under keys suffixed with previousKeySuffix.
+//
+// Parameters:
+//   - current: The data of the existing Secret, nil if there is none.
+//   - rootJSON: The new root.json.
+//   - archive: The new repository.tar.gz.
+//
+// Returns:
+//   - The data of the updated Secret.
+func RotateSecretData(current map[string][]byte, rootJSON, archive []byte) map[string][]byte {
+	data := map[string][]byte{secretRootKey: rootJSON, secretArchiveKey: archive}
+	for _, key := range []string{secretRootKey, secretArchiveKey} {
+		if previous, ok := current[key]; ok {
+			data[key+previousKeySuffix] = previous
+		}
+	}
+	return data
+}
+
+// storeTrustMaterial updates the Secret name in namespace in place with
+// rootJSON and archive, rotating its previous content with RotateSecretData,
+// unless it already holds the repository of digest.
+//
+// Returns:
+//   - Whether the Secret was updated.
+//   - An error if the Secret could not be read, exceeds the Secret size limit or could not be applied.
+func (k *kubeClient) storeTrustMaterial(namespace, name string, rootJSON, archive []byte, digest string) (bool, error) {
+	current, err := k.getSecret(namespace, name)
+	if errors.Is(err, errKubeNotFound) {
+		current = &kubeSecret{}
+	} else if err != nil {
+		return false, err
+	}
+	if current.Metadata.Annotations[repositoryDigestAnnotation] == digest {
+		return false, nil
+	}
+	data := RotateSecretData(current.Data, rootJSON, archive)
+	manifest := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":        name,
+			"namespace":   namespace,
+			"annotations": map[string]string{repositoryDigestAnnotation: digest},
+		},
+		"type": "Opaque",
+		"data": data,
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return false, err
+	}
+	if err := sizeError("Secret", len(content), maxConfigMapSize); err != nil {
+		return false, err
+	}
+	if err := k.apply(fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", namespace, name), content, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeSecret writes the repository assembled in workDir and its root.json
+// into the --store-secret Secret, [namespace/]name in the namespace of the
+// cluster or kubeconfig by default, exiting on errors.
+func storeSecret(target, workDir string, rootJSONFile *os.File) {
+	if target == "" {
+		return
+	}
+	kube, err := newKubeClient()
+	if err != nil {
+		log.Fatalf("Error: could not create Kubernetes client for --store-secret: %v", err)
+	}
+	namespace, name, ok := strings.Cut(target, "/")
+	if !ok {
+		namespace, name = kube.namespace, target
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	digest, err := RepositoryDigest(workDir)
+	if err != nil {
+		log.Fatalf("Error: could not digest repository: %v", err)
+	}
+	var archive bytes.Buffer
+	if err := compressRepository(workDir, &archive); err != nil {
+		fatalf(err, "Error: could not compress repository directory: %v", err)
+	}
+	updated, err := kube.storeTrustMaterial(namespace, name, rootJSON, archive.Bytes(), digest)
+	if err != nil {
+		fatalf(err, "Error: could not store trust material in Secret %s/%s: %v", namespace, name, err)
+	}
+	if !updated {
+		log.Printf("Secret %s/%s already holds the repository\n", namespace, name)
+		return
+	}
+	log.Printf("stored trust material in Secret %s/%s\n", namespace, name)
+}
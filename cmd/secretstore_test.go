@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRotateSecretData(t *testing.T) {
+	tests := []struct {
+		name    string
+		current map[string][]byte
+		want    map[string][]byte
+	}{
+		{"new secret", nil, map[string][]byte{"root.json": []byte("root-2"), "repository.tar.gz": []byte("archive-2")}},
+		{"rotates", map[string][]byte{
+			"root.json": []byte("root-1"), "repository.tar.gz": []byte("archive-1"),
+			"root.json.previous": []byte("root-0"), "repository.tar.gz.previous": []byte("archive-0"),
+		}, map[string][]byte{
+			"root.json": []byte("root-2"), "repository.tar.gz": []byte("archive-2"),
+			"root.json.previous": []byte("root-1"), "repository.tar.gz.previous": []byte("archive-1"),
+		}},
+		{"drops other keys", map[string][]byte{"root.json": []byte("root-1"), "ca.crt": []byte("ca")}, map[string][]byte{
+			"root.json": []byte("root-2"), "repository.tar.gz": []byte("archive-2"), "root.json.previous": []byte("root-1"),
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RotateSecretData(tt.current, []byte("root-2"), []byte("archive-2")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RotateSecretData() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreTrustMaterial(t *testing.T) {
+	tests := []struct {
+		name        string
+		existing    string
+		wantUpdated bool
+		wantData    map[string]string
+	}{
+		{"creates", "", true, map[string]string{"root.json": "cm9vdC0y", "repository.tar.gz": "YXJjaGl2ZS0y"}},
+		{"rotates", `{"metadata":{"annotations":{"trustroot-assembler.sigstore.dev/repository-digest":"old"}},"data":{"root.json":"cm9vdC0x","repository.tar.gz":"YXJjaGl2ZS0x"}}`, true,
+			map[string]string{"root.json": "cm9vdC0y", "repository.tar.gz": "YXJjaGl2ZS0y", "root.json.previous": "cm9vdC0x", "repository.tar.gz.previous": "YXJjaGl2ZS0x"}},
+		{"unchanged", `{"metadata":{"annotations":{"trustroot-assembler.sigstore.dev/repository-digest":"new"}},"data":{"root.json":"cm9vdC0y"}}`, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var applied map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/namespaces/trust/secrets/sigstore" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				switch r.Method {
+				case http.MethodGet:
+					if tt.existing == "" {
+						http.NotFound(w, r)
+						return
+					}
+					io.WriteString(w, tt.existing)
+				case http.MethodPatch:
+					if !strings.Contains(r.URL.RawQuery, "fieldManager="+fieldManager) {
+						t.Errorf("apply without field manager: %s", r.URL.RawQuery)
+					}
+					if err := json.NewDecoder(r.Body).Decode(&applied); err != nil {
+						t.Errorf("Failed to decode applied Secret: %v", err)
+					}
+				}
+			}))
+			defer server.Close()
+			kube := &kubeClient{host: server.URL, namespace: "default", client: server.Client()}
+
+			updated, err := kube.storeTrustMaterial("trust", "sigstore", []byte("root-2"), []byte("archive-2"), "new")
+			if err != nil {
+				t.Fatalf("storeTrustMaterial() error = %v", err)
+			}
+			if updated != tt.wantUpdated {
+				t.Fatalf("storeTrustMaterial() updated = %v, want %v", updated, tt.wantUpdated)
+			}
+			if !updated {
+				if applied != nil {
+					t.Errorf("unchanged Secret was applied: %v", applied)
+				}
+				return
+			}
+			data := map[string]string{}
+			for key, value := range applied["data"].(map[string]any) {
+				data[key] = value.(string)
+			}
+			if !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("applied data = %v, want %v", data, tt.wantData)
+			}
+			annotations := applied["metadata"].(map[string]any)["annotations"].(map[string]any)
+			if annotations[repositoryDigestAnnotation] != "new" {
+				t.Errorf("applied annotations = %v", annotations)
+			}
+		})
+	}
+}
+
+func TestStoreTrustMaterialTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("oversized Secret was applied")
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	kube := &kubeClient{host: server.URL, client: server.Client()}
+
+	if _, err := kube.storeTrustMaterial("trust", "sigstore", []byte("{}"), make([]byte, maxConfigMapSize), "new"); err == nil {
+		t.Error("storeTrustMaterial() error = nil, want a size error")
+	}
+}
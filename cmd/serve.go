@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ServeRepository verifies the repository in dir and serves it over HTTP on
+// addr, as a drop-in mirror for clusters and developers inside an air gap.
+//
+// Parameters:
+//   - dir: The repository directory, as laid out by mirror-sync.
+//   - addr: The TCP address to listen on, e.g. ":8080".
+//
+// Returns:
+//   - error: The verification error, or the error that stopped the server.
+func ServeRepository(dir, addr string) error {
+	repository, err := openVerifiedDirectory(dir)
+	if err != nil {
+		return err
+	}
+	log.Printf("verified %s, %d targets, serving on %s\n", dir, len(repository.Targets), addr)
+	return http.ListenAndServe(addr, RepositoryHandler(dir))
+}
+
+// RepositoryHandler returns a read-only handler serving the repository in dir
+// with directory listings, so GetLatestMetadataName works against it, and the
+// Content-Type and Cache-Control headers used when publishing.
+//
+// Parameters:
+//   - dir: The repository directory.
+//
+// Returns:
+//   - The http.Handler serving dir.
+func RepositoryHandler(dir string) http.Handler {
+	files := http.FileServer(http.Dir(dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if name := strings.TrimPrefix(path.Clean(r.URL.Path), "/"); name != "" && !strings.HasSuffix(r.URL.Path, "/") {
+			w.Header().Set("Content-Type", contentType(name))
+			w.Header().Set("Cache-Control", cacheControl(name))
+		}
+		files.ServeHTTP(w, r)
+	})
+}
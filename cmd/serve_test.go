@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepositoryHandler(t *testing.T) {
+	server := httptest.NewServer(RepositoryHandler(newTestRepository(t)))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		status       int
+		cacheControl string
+	}{
+		{"timestamp", http.MethodGet, "/timestamp.json", http.StatusOK, cacheControlTimestamp},
+		{"versioned metadata", http.MethodGet, "/1.root.json", http.StatusOK, cacheControlImmutable},
+		{"head", http.MethodHead, "/1.targets.json", http.StatusOK, cacheControlImmutable},
+		{"listing", http.MethodGet, "/", http.StatusOK, ""},
+		{"missing", http.MethodGet, "/2.root.json", http.StatusNotFound, cacheControlImmutable},
+		{"put", http.MethodPut, "/timestamp.json", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, nil)
+			if err != nil {
+				t.Fatalf("Failed to create request: %v", err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+		})
+	}
+}
+
+func TestOpenVerifiedDirectory(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(dir string) error
+		wantErr bool
+	}{
+		{"valid repository", func(string) error { return nil }, false},
+		{"tampered target", func(dir string) error {
+			names, err := filepath.Glob(filepath.Join(dir, "targets", "*.rekor.pub"))
+			if err != nil || len(names) != 1 {
+				return err
+			}
+			return os.WriteFile(names[0], []byte("tampered"), 0o644)
+		}, true},
+		{"missing root", func(dir string) error {
+			return os.Remove(filepath.Join(dir, "1.root.json"))
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestRepository(t)
+			if err := tt.modify(dir); err != nil {
+				t.Fatalf("Failed to modify repository: %v", err)
+			}
+			repository, err := openVerifiedDirectory(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("openVerifiedDirectory() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(repository.Targets) != len(testTargets) {
+				t.Errorf("got %d targets, want %d", len(repository.Targets), len(testTargets))
+			}
+		})
+	}
+}
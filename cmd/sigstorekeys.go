@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SigstoreKeys holds the trust material of a TrustRoot that is specified with
+// `spec.sigstoreKeys` instead of a serialized TUF repository.
+type SigstoreKeys struct {
+	CertificateAuthorities []CertificateAuthority
+	TLogs                  []TransparencyLogInstance
+	CTLogs                 []TransparencyLogInstance
+	TimestampAuthorities   []CertificateAuthority
+}
+
+// CertificateAuthority describes a Fulcio instance or a Timestamp Authority.
+// CertChain is the PEM encoded certificate chain, leaf first.
+type CertificateAuthority struct {
+	Organization string
+	CommonName   string
+	URI          string
+	CertChain    []byte
+}
+
+// TransparencyLogInstance describes a Rekor or CT log instance.
+// PublicKey is the PEM encoded public key of the log, LogID is the hex encoded
+// identifier clients use to select it.
+type TransparencyLogInstance struct {
+	BaseURL       string
+	HashAlgorithm string
+	PublicKey     []byte
+	LogID         string
+}
+
+// RenderSigstoreKeysTrustRoot renders a TrustRoot Custom Resource YAML using the
+// `sigstoreKeys` specification.
+//
+// Parameters:
+//   - name: The metadata.name of the TrustRoot.
+//   - keys: The trust material to embed.
+//
+// Returns:
+//   - The TrustRoot YAML document. Certificate chains and public keys are base64 encoded.
+func RenderSigstoreKeysTrustRoot(name string, keys SigstoreKeys) string {
+	var fields []yamlField
+	if cas := certificateAuthoritiesYAML(keys.CertificateAuthorities); cas != nil {
+		fields = append(fields, yamlField{"certificateAuthorities", cas})
+	}
+	if tlogs := transparencyLogsYAML(keys.TLogs); tlogs != nil {
+		fields = append(fields, yamlField{"tLogs", tlogs})
+	}
+	if ctlogs := transparencyLogsYAML(keys.CTLogs); ctlogs != nil {
+		fields = append(fields, yamlField{"ctLogs", ctlogs})
+	}
+	if tsas := certificateAuthoritiesYAML(keys.TimestampAuthorities); tsas != nil {
+		fields = append(fields, yamlField{"timestampAuthorities", tsas})
+	}
+	var b strings.Builder
+	// Marshaling a tree of strings to memory does not fail
+	writeYAML(&b, yamlMapping(
+		yamlField{"apiVersion", yamlString("policy.sigstore.dev/v1alpha1")},
+		yamlField{"kind", yamlString("TrustRoot")},
+		yamlField{"metadata", yamlMapping(yamlField{"name", yamlString(name)})},
+		yamlField{"spec", yamlMapping(yamlField{"sigstoreKeys", yamlMapping(fields...)})},
+	))
+	return b.String()
+}
+
+// certificateAuthoritiesYAML returns the YAML sequence of cas, nil if empty.
+func certificateAuthoritiesYAML(cas []CertificateAuthority) *yaml.Node {
+	if len(cas) == 0 {
+		return nil
+	}
+	sequence := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, ca := range cas {
+		sequence.Content = append(sequence.Content, yamlMapping(
+			yamlField{"subject", yamlMapping(
+				yamlField{"organization", yamlString(ca.Organization)},
+				yamlField{"commonName", yamlString(ca.CommonName)},
+			)},
+			yamlField{"uri", yamlString(ca.URI)},
+			yamlField{"certChain", yamlBase64(ca.CertChain)},
+		))
+	}
+	return sequence
+}
+
+// transparencyLogsYAML returns the YAML sequence of logs, nil if empty.
+func transparencyLogsYAML(logs []TransparencyLogInstance) *yaml.Node {
+	if len(logs) == 0 {
+		return nil
+	}
+	sequence := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, tlog := range logs {
+		fields := []yamlField{
+			{"baseURL", yamlString(tlog.BaseURL)},
+			{"hashAlgorithm", yamlString(tlog.HashAlgorithm)},
+			{"publicKey", yamlBase64(tlog.PublicKey)},
+		}
+		if tlog.LogID != "" {
+			fields = append(fields, yamlField{"logID", yamlString(tlog.LogID)})
+		}
+		sequence.Content = append(sequence.Content, yamlMapping(fields...))
+	}
+	return sequence
+}
+
+// Signature type identifiers of the signed note format, see
+// https://github.com/C2SP/C2SP/blob/main/signed-note.md
+const (
+	noteAlgEd25519         = 0x01
+	noteAlgECDSAWithSHA256 = 0x02
+)
+
+// checkpointKeyIDSize is the size of the key hash naming a signed note key.
+const checkpointKeyIDSize = 4
+
+// LogID returns the identifier of a Rekor v1 or CT log: the hex encoded SHA-256
+// of its DER encoded public key.
+//
+// Parameters:
+//   - publicKeyPEM: The PEM encoded public key of the log.
+//
+// Returns:
+//   - The hex encoded log ID.
+//   - An error if the public key could not be decoded.
+func LogID(publicKeyPEM []byte) (string, error) {
+	der, err := publicKeyDER(publicKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CheckpointKeyID returns the identifier of a Rekor v2 (tiled) log: the hex
+// encoded key hash of the signed note format, the first 4 bytes of the
+// SHA-256 of the log origin, a newline, the signature type and the public
+// key, which is how checkpoint signatures name their key.
+//
+// Parameters:
+//   - origin: The checkpoint origin of the log, usually its hostname.
+//   - publicKeyPEM: The PEM encoded public key of the log.
+//
+// Returns:
+//   - The hex encoded checkpoint key ID.
+//   - An error if the public key is not a supported Ed25519 or ECDSA key.
+func CheckpointKeyID(origin string, publicKeyPEM []byte) (string, error) {
+	der, err := publicKeyDER(publicKeyPEM)
+	if err != nil {
+		return "", err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(origin + "\n"))
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		h.Write([]byte{noteAlgEd25519})
+		h.Write(key)
+	case *ecdsa.PublicKey:
+		h.Write([]byte{noteAlgECDSAWithSHA256})
+		h.Write(der)
+	default:
+		return "", fmt.Errorf("unsupported checkpoint key type %T", key)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:checkpointKeyIDSize]), nil
+}
+
+// publicKeyDER returns the DER bytes of the first PEM block of publicKeyPEM.
+func publicKeyDER(publicKeyPEM []byte) ([]byte, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("no PEM encoded public key found")
+	}
+	return block.Bytes, nil
+}
+
+// NewRekorV2Log returns the TransparencyLogInstance of a Rekor v2 (tiled) log.
+// The checkpoint origin of the log is the host of its base URL.
+//
+// Parameters:
+//   - baseURL: The base URL of the log.
+//   - publicKeyPath: The path of the PEM or DER encoded public key of the log.
+//
+// Returns:
+//   - The TransparencyLogInstance, identified by its checkpoint key ID.
+//   - An error if the URL or the public key are invalid.
+func NewRekorV2Log(baseURL, publicKeyPath string) (TransparencyLogInstance, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return TransparencyLogInstance{}, fmt.Errorf("invalid base URL %q", baseURL)
+	}
+	publicKey, err := os.ReadFile(publicKeyPath)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	publicKey, err = NormalizePublicKey(publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, fmt.Errorf("invalid public key %s: %v", publicKeyPath, err)
+	}
+	logID, err := CheckpointKeyID(u.Host, publicKey)
+	if err != nil {
+		return TransparencyLogInstance{}, err
+	}
+	return TransparencyLogInstance{BaseURL: baseURL, HashAlgorithm: "sha-256", PublicKey: publicKey, LogID: logID}, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"strings"
+	"testing"
+)
+
+func TestRenderSigstoreKeysTrustRoot(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    SigstoreKeys
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "fulcio and rekor",
+			keys: SigstoreKeys{
+				CertificateAuthorities: []CertificateAuthority{{Organization: "org", CommonName: "cn", URI: "http://fulcio", CertChain: []byte("chain")}},
+				TLogs:                  []TransparencyLogInstance{{BaseURL: "http://rekor", HashAlgorithm: "sha-256", PublicKey: []byte("key"), LogID: "abcd"}},
+			},
+			want: []string{
+				"  name: test\n",
+				"    certificateAuthorities:\n      - subject:\n          organization: org\n          commonName: cn\n        uri: http://fulcio\n        certChain: |-\n          Y2hhaW4=\n",
+				"    tLogs:\n      - baseURL: http://rekor\n        hashAlgorithm: sha-256\n        publicKey: |-\n          a2V5\n        logID: abcd\n",
+			},
+			notWant: []string{"ctLogs:", "timestampAuthorities:"},
+		},
+		{
+			name:    "empty",
+			keys:    SigstoreKeys{},
+			want:    []string{"  sigstoreKeys: {}\n"},
+			notWant: []string{"certificateAuthorities:", "tLogs:", "ctLogs:", "timestampAuthorities:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := RenderSigstoreKeysTrustRoot("test", tt.keys)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("RenderSigstoreKeysTrustRoot() = %s, want it to contain %q", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("RenderSigstoreKeysTrustRoot() = %s, want it not to contain %q", got, notWant)
+				}
+			}
+		})
+	}
+}
+
+// testPublicKeyPEM returns the PEM encoding of pub and its DER bytes.
+func testPublicKeyPEM(t *testing.T, pub any) ([]byte, []byte) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), der
+}
+
+func TestLogID(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPEM, ecDER := testPublicKeyPEM(t, &ecKey.PublicKey)
+	sum := sha256.Sum256(ecDER)
+	tests := []struct {
+		name    string
+		key     []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "ecdsa key", key: ecPEM, want: hex.EncodeToString(sum[:])},
+		{name: "not a PEM", key: []byte("garbage"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LogID(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LogID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("LogID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckpointKeyID(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	edPEM, _ := testPublicKeyPEM(t, edPub)
+	edSum := sha256.Sum256(append([]byte("log.example\n\x01"), edPub...))
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPEM, ecDER := testPublicKeyPEM(t, &ecKey.PublicKey)
+	ecSum := sha256.Sum256(append([]byte("log.example\n\x02"), ecDER...))
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaPEM, _ := testPublicKeyPEM(t, &rsaKey.PublicKey)
+	// The verifier key PeterNeumann+c74f20a3+ARpc2QcUPDhMQegwxbzhKqiBfsVkmqq/LDE4izWy10TW of the signed note specification
+	vectorKey, err := base64.StdEncoding.DecodeString("ARpc2QcUPDhMQegwxbzhKqiBfsVkmqq/LDE4izWy10TW")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vectorPEM, _ := testPublicKeyPEM(t, ed25519.PublicKey(vectorKey[1:]))
+	tests := []struct {
+		name    string
+		origin  string
+		key     []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "signed note test vector", origin: "PeterNeumann", key: vectorPEM, want: "c74f20a3"},
+		{name: "ed25519 key", origin: "log.example", key: edPEM, want: hex.EncodeToString(edSum[:4])},
+		{name: "ecdsa key", origin: "log.example", key: ecPEM, want: hex.EncodeToString(ecSum[:4])},
+		{name: "rsa key", origin: "log.example", key: rsaPEM, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CheckpointKeyID(tt.origin, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckpointKeyID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CheckpointKeyID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
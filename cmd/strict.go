@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cmd/assembler"
+)
+
+// CheckStrictRepository verifies the repository assembled in dir again and
+// fails if it holds anything its verified metadata does not reference: stray
+// files such as dotfiles or editor backups, directories without verified
+// content, links and other special files. Referenced are the metadata read by
+// the verifying client, from the oldest root to timestamp.json, the targets
+// under their plain and <hash>.<name> names, and the succinct hash bins with
+// their targets.
+//
+// Parameters:
+//   - dir: The directory of the assembled repository.
+//
+// Returns:
+//   - error: nil if the repository only holds verified content, otherwise an error listing the unexpected paths, wrapping assembler.ErrVerification.
+func CheckStrictRepository(dir string) error {
+	targetsPath, err := latestMetadataPath(dir, "targets.json")
+	if err != nil {
+		return err
+	}
+	targetsJSON, err := os.ReadFile(targetsPath)
+	if err != nil {
+		return err
+	}
+	listed, err := parseTargetsMetadata(targetsJSON)
+	if err != nil {
+		return fmt.Errorf("could not parse targets.json: %v", err)
+	}
+	// Expired targets skipped by their FetchPolicy are missing, not stray
+	skipped := map[string]bool{}
+	for name := range listed.Signed.Targets {
+		if _, err := os.Stat(filepath.Join(dir, "targets", filepath.FromSlash(name))); os.IsNotExist(err) {
+			skipped[name] = true
+		}
+	}
+	expected := map[string]bool{}
+	repository, err := verifyDirectory(dir, dirFetcher{dir: dir, plainTargets: true, opened: expected}, skipped)
+	if err != nil {
+		return err
+	}
+	for name, meta := range repository.Targets {
+		if skipped[name] {
+			continue
+		}
+		targetDir, base := path.Split(name)
+		for _, hash := range meta.Hashes {
+			expected[path.Join("targets", targetDir, hash.String()+"."+base)] = true
+		}
+	}
+	if err := expectDelegatedFiles(dir, repository, expected); err != nil {
+		return err
+	}
+
+	// Directories are expected if they lead to an expected file
+	for name := range expected {
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			expected[parent] = true
+		}
+	}
+	var unexpected []string
+	err = filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || filePath == dir {
+			return err
+		}
+		name, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if expected[name] && (entry.IsDir() || entry.Type().IsRegular()) {
+			return nil
+		}
+		unexpected = append(unexpected, name)
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(unexpected) > 0 {
+		return fmt.Errorf("%w: the repository holds content no verified metadata references: %s", assembler.ErrVerification, strings.Join(unexpected, ", "))
+	}
+	return nil
+}
+
+// expectDelegatedFiles adds to expected the succinct hash bins of the
+// repository in dir and the targets they list, resolved again from dir into
+// a scratch directory.
+func expectDelegatedFiles(dir string, repository *verifiedRepository, expected map[string]bool) error {
+	meta, err := repository.Local.GetMeta()
+	if err != nil {
+		return err
+	}
+	consistent, err := assembler.ConsistentSnapshot(meta["root.json"])
+	if err != nil {
+		return err
+	}
+	scratch, err := os.MkdirTemp(tempDir(), "tuf-delegations-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+	if err := os.Mkdir(filepath.Join(scratch, "targets"), 0o755); err != nil {
+		return err
+	}
+	if _, err := ResolveSuccinctDelegations(dir, scratch, meta["targets.json"], meta["snapshot.json"], consistent, delegatedTargets); err != nil {
+		return fmt.Errorf("succinct hash bins do not verify: %w", err)
+	}
+	return filepath.WalkDir(scratch, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(scratch, filePath)
+		if err != nil {
+			return err
+		}
+		expected[filepath.ToSlash(name)] = true
+		return nil
+	})
+}
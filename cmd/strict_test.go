@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+)
+
+func TestCheckStrictRepository(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		// stray adds content to the assembled repository and returns its path
+		stray func(t *testing.T, dir string) string
+	}{
+		{name: "verified content only"},
+		{
+			name: "dotfile",
+			stray: func(t *testing.T, dir string) string {
+				return testStrayFile(t, dir, ".DS_Store")
+			},
+		},
+		{
+			name: "editor backup",
+			stray: func(t *testing.T, dir string) string {
+				return testStrayFile(t, dir, "targets/rekor.pub~")
+			},
+		},
+		{
+			name: "empty directory",
+			stray: func(t *testing.T, dir string) string {
+				if err := os.Mkdir(filepath.Join(dir, "targets", "old"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				return "targets/old"
+			},
+		},
+		{
+			name: "link to a target",
+			stray: func(t *testing.T, dir string) string {
+				if err := os.Symlink("rekor.pub", filepath.Join(dir, "targets", "rekor.key")); err != nil {
+					t.Fatal(err)
+				}
+				return "targets/rekor.key"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			rootJSONFile, err := AssembleRepository(server.URL, workDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootJSONFile.Close()
+			if tt.stray == nil {
+				if err := CheckStrictRepository(workDir); err != nil {
+					t.Errorf("CheckStrictRepository() error = %v", err)
+				}
+				return
+			}
+			stray := tt.stray(t, workDir)
+			err = CheckStrictRepository(workDir)
+			if !errors.Is(err, assembler.ErrVerification) {
+				t.Fatalf("CheckStrictRepository() error = %v, want %v", err, assembler.ErrVerification)
+			}
+			if !strings.Contains(err.Error(), stray) {
+				t.Errorf("CheckStrictRepository() error = %v, want it to name %s", err, stray)
+			}
+		})
+	}
+}
+
+// testStrayFile writes a file at the slash path name of dir and returns name.
+func testStrayFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte("stray"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
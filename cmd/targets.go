@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cmd/assembler"
+)
+
+// targetsMetadata is the subset of a TUF targets.json used by the tool.
+type targetsMetadata struct {
+	Signed struct {
+		Version int                       `json:"version"`
+		Targets map[string]targetFileMeta `json:"targets"`
+	} `json:"signed"`
+}
+
+// targetFileMeta describes a single target of a targets.json.
+type targetFileMeta struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+	Custom *struct {
+		Sigstore *sigstoreCustomMetadata `json:"sigstore"`
+	} `json:"custom,omitempty"`
+}
+
+// sigstoreCustomMetadata is the custom metadata Sigstore attaches to its targets.
+type sigstoreCustomMetadata struct {
+	Usage  string `json:"usage"`
+	Status string `json:"status"`
+	URI    string `json:"uri,omitempty"`
+}
+
+// sigstore returns the Sigstore custom metadata of the target, or nil if it has none.
+func (t targetFileMeta) sigstore() *sigstoreCustomMetadata {
+	if t.Custom == nil {
+		return nil
+	}
+	return t.Custom.Sigstore
+}
+
+// parseTargetsMetadata decodes a targets.json document.
+func parseTargetsMetadata(targetsJSON []byte) (*targetsMetadata, error) {
+	targets := &targetsMetadata{}
+	if err := json.Unmarshal(targetsJSON, targets); err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// TSATargets returns the names of the Timestamp Authority certificate chain
+// targets listed in a targets.json document.
+//
+// A target is a TSA target when its Sigstore custom metadata declares the "TSA"
+// usage, or, lacking custom metadata, when its name follows the
+// `tsa*.crt.pem` convention.
+//
+// Parameters:
+//   - targetsJSON: The content of the targets.json file.
+//
+// Returns:
+//   - The sorted names of the TSA targets.
+//   - An error if the document could not be decoded.
+func TSATargets(targetsJSON []byte) ([]string, error) {
+	targets, err := parseTargetsMetadata(targetsJSON)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name, meta := range targets.Signed.Targets {
+		if isTSATarget(name, meta.sigstore()) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// isTSATarget reports whether the target name with the Sigstore custom
+// metadata custom, nil if it has none, holds TSA certificates.
+func isTSATarget(name string, custom *sigstoreCustomMetadata) bool {
+	if custom != nil {
+		return strings.EqualFold(custom.Usage, "TSA")
+	}
+	return strings.HasPrefix(name, "tsa") && strings.HasSuffix(name, ".crt.pem")
+}
+
+// TimestampAuthorities returns the validated certificate chains of the TSA
+// targets of a targets.json document.
+//
+// Each TSA target with Sigstore custom metadata is a chain of its own, with
+// the URI of its metadata; those not "Active" are skipped. TSA targets named
+// after the `tsa*.crt.pem` convention hold the certificates of a single
+// chain without URI, assembled leaf first: the `*leaf*` target, the others in
+// name order and the `*root*` target. Every chain is checked with
+// NormalizeCertificateChain, so a TrustRoot never carries a TSA chain that
+// cannot verify a timestamp.
+//
+// Parameters:
+//   - targetsJSON: The content of the targets.json file.
+//   - download: Returns the content of a verified target.
+//   - now: The time the certificates must be valid at.
+//
+// Returns:
+//   - The TSA CertificateAuthorities.
+//   - An error naming the target that could not be read or does not validate.
+func TimestampAuthorities(targetsJSON []byte, download func(name string) ([]byte, error), now time.Time) ([]CertificateAuthority, error) {
+	targets, err := parseTargetsMetadata(targetsJSON)
+	if err != nil {
+		return nil, err
+	}
+	custom := make(map[string]*sigstoreCustomMetadata, len(targets.Signed.Targets))
+	for name, meta := range targets.Signed.Targets {
+		custom[name] = meta.sigstore()
+	}
+	return timestampAuthorities(custom, download, now)
+}
+
+// CheckTimestampAuthorities validates the TSA certificate chains of a
+// verified repository: those of its TSA targets, and the timestampAuthorities
+// of its trusted_root.json target, if any.
+//
+// Parameters:
+//   - targetsDir: The directory of the verified targets.
+//   - targetsJSON: The content of the targets.json file.
+//   - now: The time the certificates must be valid at.
+//
+// Returns:
+//   - An error wrapping assembler.ErrVerification if a chain does not validate.
+func CheckTimestampAuthorities(targetsDir string, targetsJSON []byte, now time.Time) error {
+	download := func(name string) ([]byte, error) {
+		if !fs.ValidPath(name) {
+			return nil, fmt.Errorf("invalid target name")
+		}
+		return os.ReadFile(filepath.Join(targetsDir, filepath.FromSlash(name)))
+	}
+	authorities, err := TimestampAuthorities(targetsJSON, download, now)
+	if err != nil {
+		return fmt.Errorf("%w: %v", assembler.ErrVerification, err)
+	}
+	for _, ca := range authorities {
+		log.Printf("including TSA certificate chain of %s\n", describeAuthority(ca))
+	}
+	trustedRootJSON, err := os.ReadFile(filepath.Join(targetsDir, "trusted_root.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var root trustedRootDocument
+	if err := json.Unmarshal(trustedRootJSON, &root); err != nil {
+		return fmt.Errorf("%w: malformed trusted_root.json: %v", assembler.ErrVerification, err)
+	}
+	trustedRootTSAs, err := trustedRootAuthorities(root.TimestampAuthorities, now)
+	if err != nil {
+		return fmt.Errorf("%w: TSA of trusted_root.json: %v", assembler.ErrVerification, err)
+	}
+	for _, ca := range trustedRootTSAs {
+		log.Printf("including TSA certificate chain of %s from trusted_root.json\n", describeAuthority(ca))
+	}
+	return nil
+}
+
+// describeAuthority names a CertificateAuthority in messages.
+func describeAuthority(ca CertificateAuthority) string {
+	if ca.URI != "" {
+		return ca.URI
+	}
+	return ca.CommonName
+}
+
+// timestampAuthorities is TimestampAuthorities for the Sigstore custom
+// metadata of every target, nil for a target without.
+func timestampAuthorities(targets map[string]*sigstoreCustomMetadata, download func(name string) ([]byte, error), now time.Time) ([]CertificateAuthority, error) {
+	names := make([]string, 0, len(targets))
+	for name, custom := range targets {
+		if isTSATarget(name, custom) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	// Chain order of the targets named after the convention
+	rank := func(name string) int {
+		switch {
+		case strings.Contains(name, "leaf"):
+			return 0
+		case strings.Contains(name, "root"):
+			return 2
+		}
+		return 1
+	}
+	sort.SliceStable(names, func(i, j int) bool { return rank(names[i]) < rank(names[j]) })
+
+	var authorities []CertificateAuthority
+	var chain bytes.Buffer
+	var chainTargets []string
+	for _, name := range names {
+		custom := targets[name]
+		if custom != nil && !strings.EqualFold(custom.Status, "Active") {
+			continue
+		}
+		content, err := download(name)
+		if err != nil {
+			return nil, fmt.Errorf("TSA target %s: %v", name, err)
+		}
+		if custom == nil {
+			chain.Write(bytes.TrimSpace(content))
+			chain.WriteByte('\n')
+			chainTargets = append(chainTargets, name)
+			continue
+		}
+		ca, err := NewCertificateAuthority(custom.URI, content)
+		if err != nil {
+			return nil, fmt.Errorf("TSA target %s: %v", name, err)
+		}
+		authorities = append(authorities, ca)
+	}
+	if len(chainTargets) > 0 {
+		ca, err := NewCertificateAuthority("", chain.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("TSA targets %s: %v", strings.Join(chainTargets, ", "), err)
+		}
+		authorities = append(authorities, ca)
+	}
+	return authorities, nil
+}
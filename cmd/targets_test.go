@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+)
+
+func TestTSATargets(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetsJSON string
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name: "custom metadata usage",
+			targetsJSON: `{"signed": {"targets": {
+				"timestamp.crt.pem": {"custom": {"sigstore": {"usage": "TSA", "status": "Active"}}},
+				"fulcio.crt.pem": {"custom": {"sigstore": {"usage": "Fulcio", "status": "Active"}}},
+				"tsa_old.crt.pem": {"custom": {"sigstore": {"usage": "Unknown", "status": "Expired"}}}
+			}}}`,
+			want: []string{"timestamp.crt.pem"},
+		},
+		{
+			name: "name convention without custom metadata",
+			targetsJSON: `{"signed": {"targets": {
+				"tsa_root.crt.pem": {},
+				"tsa_intermediate_0.crt.pem": {},
+				"rekor.pub": {}
+			}}}`,
+			want: []string{"tsa_intermediate_0.crt.pem", "tsa_root.crt.pem"},
+		},
+		{
+			name:        "no TSA targets",
+			targetsJSON: `{"signed": {"targets": {"trusted_root.json": {}}}}`,
+			want:        nil,
+		},
+		{
+			name:        "invalid JSON",
+			targetsJSON: `{`,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TSATargets([]byte(tt.targetsJSON))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TSATargets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TSATargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// splitPEM returns each PEM block of chain on its own.
+func splitPEM(t *testing.T, chain []byte) [][]byte {
+	t.Helper()
+	var blocks [][]byte
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return blocks
+		}
+		blocks = append(blocks, pem.EncodeToMemory(block))
+	}
+}
+
+func TestTimestampAuthorities(t *testing.T) {
+	chain := testCertificateChainPEM(t, "tsa-org", "tsa leaf", "tsa intermediate", "tsa root")
+	certs := splitPEM(t, chain)
+	unlinked := append(testCertificatePEM(t, "tsa-org", "tsa leaf"), testCertificatePEM(t, "tsa-org", "tsa root")...)
+	tests := []struct {
+		name        string
+		targetsJSON string
+		files       map[string][]byte
+		now         time.Time
+		want        []string
+		wantErr     bool
+	}{
+		{
+			name: "custom metadata chains",
+			targetsJSON: `{"signed": {"targets": {
+				"tsa.crt.pem": {"custom": {"sigstore": {"usage": "TSA", "status": "Active", "uri": "https://tsa.example"}}},
+				"tsa_old.crt.pem": {"custom": {"sigstore": {"usage": "TSA", "status": "Expired", "uri": "https://old.example"}}},
+				"fulcio.crt.pem": {"custom": {"sigstore": {"usage": "Fulcio", "status": "Active"}}}
+			}}}`,
+			files: map[string][]byte{"tsa.crt.pem": chain},
+			want:  []string{"https://tsa.example"},
+		},
+		{
+			name: "name convention chain",
+			targetsJSON: `{"signed": {"targets": {
+				"tsa_root.crt.pem": {}, "tsa_intermediate_0.crt.pem": {}, "tsa_leaf.crt.pem": {}, "rekor.pub": {}
+			}}}`,
+			files: map[string][]byte{"tsa_root.crt.pem": certs[2], "tsa_intermediate_0.crt.pem": certs[1], "tsa_leaf.crt.pem": certs[0]},
+			want:  []string{"tsa root"},
+		},
+		{
+			name:        "unlinked chain",
+			targetsJSON: `{"signed": {"targets": {"tsa.crt.pem": {"custom": {"sigstore": {"usage": "TSA", "status": "Active"}}}}}}`,
+			files:       map[string][]byte{"tsa.crt.pem": unlinked},
+			wantErr:     true,
+		},
+		{
+			name:        "expired chain",
+			targetsJSON: `{"signed": {"targets": {"tsa_leaf.crt.pem": {}, "tsa_root.crt.pem": {}}}}`,
+			files:       map[string][]byte{"tsa_root.crt.pem": certs[2], "tsa_leaf.crt.pem": certs[0]},
+			now:         time.Now().Add(2 * time.Hour),
+			wantErr:     true,
+		},
+		{
+			name:        "missing target",
+			targetsJSON: `{"signed": {"targets": {"tsa.crt.pem": {}}}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "no TSA targets",
+			targetsJSON: `{"signed": {"targets": {"trusted_root.json": {}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := tt.now
+			if now.IsZero() {
+				now = time.Now()
+			}
+			download := func(name string) ([]byte, error) {
+				content, ok := tt.files[name]
+				if !ok {
+					return nil, fmt.Errorf("no target %s", name)
+				}
+				return content, nil
+			}
+			got, err := TimestampAuthorities([]byte(tt.targetsJSON), download, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TimestampAuthorities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, ca := range got {
+				names = append(names, describeAuthority(ca))
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("TimestampAuthorities() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+	got, err := TimestampAuthorities([]byte(tests[1].targetsJSON), func(name string) ([]byte, error) { return tests[1].files[name], nil }, time.Now())
+	if err != nil || len(got) != 1 || !bytes.Equal(got[0].CertChain, chain) {
+		t.Errorf("TimestampAuthorities() chain = %v, %v, want the chain leaf first", got, err)
+	}
+}
+
+func TestCheckTimestampAuthorities(t *testing.T) {
+	now := time.Now()
+	trustedRoot, _, _ := testTrustedRoot(t, now)
+	chain := testCertificateChainPEM(t, "tsa-org", "tsa leaf", "tsa root")
+	tests := []struct {
+		name        string
+		targetsJSON string
+		files       map[string][]byte
+		now         time.Time
+		wantErr     bool
+	}{
+		{name: "valid chains", files: map[string][]byte{"tsa.crt.pem": chain, "trusted_root.json": trustedRoot}, now: now},
+		{name: "without trusted_root.json", files: map[string][]byte{"tsa.crt.pem": chain}, now: now},
+		{name: "invalid chain", files: map[string][]byte{"tsa.crt.pem": []byte("not a chain"), "trusted_root.json": trustedRoot}, now: now, wantErr: true},
+		{name: "missing TSA target", files: map[string][]byte{"trusted_root.json": trustedRoot}, now: now, wantErr: true},
+		{name: "not yet valid trusted_root.json TSA", targetsJSON: `{"signed": {"targets": {"trusted_root.json": {}}}}`, files: map[string][]byte{"trusted_root.json": trustedRoot}, now: now.Add(-90 * time.Minute), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			targetsJSON := tt.targetsJSON
+			if targetsJSON == "" {
+				targetsJSON = `{"signed": {"targets": {"tsa.crt.pem": {}, "trusted_root.json": {}}}}`
+			}
+			err := CheckTimestampAuthorities(dir, []byte(targetsJSON), tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckTimestampAuthorities() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, assembler.ErrVerification) {
+				t.Errorf("CheckTimestampAuthorities() error = %v, want %v", err, assembler.ErrVerification)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"cmd/assembler"
+)
+
+// outputTemplate is the --output format of a --template.
+const outputTemplate = "template"
+
+// TemplateData is the assembled trust material exposed to --template files
+// and, as JSON, to --plugin executables.
+type TemplateData struct {
+	// Name is the name of the rendered object.
+	Name string `json:"name"`
+	// Root and Archive are the base64 encoded root.json and tar.gz
+	// repository archive, RootJSON is the root.json itself.
+	Root     string `json:"root"`
+	Archive  string `json:"archive"`
+	RootJSON string `json:"rootJSON"`
+	// Versions are the versions of the top-level metadata by role file
+	// name, e.g. Versions "snapshot.json".
+	Versions map[string]int64 `json:"versions"`
+	// RootDigest is the hex SHA-256 of root.json, RepositoryDigest the
+	// digest of the repository used by --name-strategy digest.
+	RootDigest       string `json:"rootDigest"`
+	RepositoryDigest string `json:"repositoryDigest"`
+	// TargetsDir is the targets directory of the archive layout.
+	TargetsDir string `json:"targetsDir"`
+}
+
+// templateFuncs are the functions available to --template files besides the
+// text/template built-ins.
+var templateFuncs = template.FuncMap{
+	// indent prefixes every line of s with n spaces, to embed values in YAML.
+	"indent": func(n int, s string) string {
+		pad := strings.Repeat(" ", n)
+		return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+	},
+}
+
+// NewTemplateRenderer returns a Renderer executing the Go text/template in
+// the file at path with the TemplateData of the assembled repository, for
+// manifest formats that are not built in.
+//
+// Parameters:
+//   - path: The path of the template file.
+//
+// Returns:
+//   - The Renderer.
+//   - An error if the template could not be read or parsed.
+func NewTemplateRenderer(path string) (Renderer, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template: %v", err)
+	}
+	return RendererFunc(func(material TrustMaterial) ([]byte, error) {
+		data, err := newTemplateData(material)
+		if err != nil {
+			return nil, err
+		}
+		var output bytes.Buffer
+		if err := tmpl.Execute(&output, data); err != nil {
+			return nil, fmt.Errorf("could not execute template: %v", err)
+		}
+		return output.Bytes(), nil
+	}), nil
+}
+
+// newTemplateData collects the TemplateData of material.
+func newTemplateData(material TrustMaterial) (TemplateData, error) {
+	var archive bytes.Buffer
+	if err := writeEncodedRepositoryArchive(&archive, material.Dir); err != nil {
+		return TemplateData{}, err
+	}
+	repositoryDigest, err := RepositoryDigest(material.Dir)
+	if err != nil {
+		return TemplateData{}, fmt.Errorf("could not digest repository: %v", err)
+	}
+	versions, err := metadataVersions(material.Dir)
+	if err != nil {
+		return TemplateData{}, err
+	}
+	rootDigest := sha256.Sum256(material.RootJSON)
+	data := TemplateData{
+		Name:             material.Name,
+		Root:             base64.StdEncoding.EncodeToString(material.RootJSON),
+		Archive:          archive.String(),
+		RootJSON:         string(material.RootJSON),
+		Versions:         versions,
+		RootDigest:       hex.EncodeToString(rootDigest[:]),
+		RepositoryDigest: repositoryDigest,
+		TargetsDir:       archiveLayout.Targets(),
+	}
+	return data, nil
+}
+
+// metadataVersions returns the versions of the top-level metadata found in
+// the repository directory dir, by role file name. A --map directory has none.
+func metadataVersions(dir string) (map[string]int64, error) {
+	versions := map[string]int64{}
+	for _, role := range inspectRoles {
+		path, err := latestMetadataPath(dir, role)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		version, err := assembler.MetadataVersion(content)
+		if err != nil {
+			return nil, fmt.Errorf("could not read version of %s: %v", role, err)
+		}
+		versions[role] = version
+	}
+	return versions, nil
+}
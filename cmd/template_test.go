@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestNewTemplateRenderer(t *testing.T) {
+	server := mockmirror.NewServer()
+	defer server.Close()
+	workDir := t.TempDir()
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	rootJSONFile.Close()
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to read root.json: %v", err)
+	}
+	material := TrustMaterial{Name: "sigstore", Dir: workDir, RootJSON: rootJSON}
+	repositoryDigest, err := RepositoryDigest(workDir)
+	if err != nil {
+		t.Fatalf("RepositoryDigest() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"fields", `{{ .Name }} {{ index .Versions "snapshot.json" }} {{ .TargetsDir }} {{ .RepositoryDigest }}`, "sigstore 1 targets " + repositoryDigest, false},
+		{"root", `{{ .Root }}`, base64.StdEncoding.EncodeToString(rootJSON), false},
+		{"indent", "root:\n{{ indent 2 \"a\\nb\" }}", "root:\n  a\n  b", false},
+		{"unknown field", `{{ .Mirror }}`, "", true},
+		{"missing version", `{{ .Versions.mirror }}`, "", true},
+		{"parse error", `{{ .Name `, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "output.tmpl")
+			if err := os.WriteFile(path, []byte(tt.template), 0o644); err != nil {
+				t.Fatalf("Failed to write template: %v", err)
+			}
+			renderer, err := NewTemplateRenderer(path)
+			if err == nil {
+				var output []byte
+				output, err = renderer.Render(material)
+				if err == nil && string(output) != tt.want {
+					t.Errorf("Render() = %q, want %q", output, tt.want)
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("template error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "output.tmpl")
+		if err := os.WriteFile(path, []byte(`{{ .Archive }}`), 0o644); err != nil {
+			t.Fatalf("Failed to write template: %v", err)
+		}
+		renderer, err := NewTemplateRenderer(path)
+		if err != nil {
+			t.Fatalf("NewTemplateRenderer() error = %v", err)
+		}
+		output, err := renderer.Render(material)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		archive, err := base64.StdEncoding.DecodeString(string(output))
+		if err != nil {
+			t.Fatalf("Archive is not base64: %v", err)
+		}
+		if err := CheckPolicyControllerArchive(strings.NewReader(string(archive)), rootJSON, archiveLayout); err != nil {
+			t.Errorf("Archive does not load: %v", err)
+		}
+	})
+}
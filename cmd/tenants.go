@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"cmd/assembler"
+	"gopkg.in/yaml.v3"
+)
+
+// TenantsConfig describes the tenants of a shared cluster, each with its own
+// trust sources, naming, labels and destinations, generated in one run by the
+// tenants command.
+type TenantsConfig struct {
+	Tenants []Tenant `yaml:"tenants"`
+}
+
+// Tenant is the trust configuration of one tenant.
+type Tenant struct {
+	Name string `yaml:"name"`
+	// Mirror or Map is the trust source: a TUF repository mirror, or a TAP-4
+	// map file relative to the tenants config. MapRoots is the directory of
+	// the trusted root of every repository of Map, as <name>/root.json.
+	Mirror   string `yaml:"mirror"`
+	Map      string `yaml:"map"`
+	MapRoots string `yaml:"mapRoots"`
+	// NameStrategy is timestamp (default) or digest, NamePrefix replaces the
+	// source name in the TrustRoot name.
+	NameStrategy string `yaml:"nameStrategy"`
+	NamePrefix   string `yaml:"namePrefix"`
+	// Output is trustroot (default), configmap or secret.
+	Output string            `yaml:"output"`
+	Labels map[string]string `yaml:"labels"`
+	// Destinations are where the output is applied, by default the cluster
+	// the tool runs in or the current kubeconfig context.
+	Destinations []TenantDestination `yaml:"destinations"`
+}
+
+// TenantDestination is a cluster, and for ConfigMap and Secret outputs a
+// namespace, a tenant output is applied to.
+type TenantDestination struct {
+	// Context is the kubeconfig context of the cluster, empty for the
+	// cluster the tool runs in or the current context.
+	Context   string `yaml:"context"`
+	Namespace string `yaml:"namespace"`
+}
+
+// LoadTenantsConfig reads and validates a tenants config file. Unknown fields
+// are rejected and defaults are filled in.
+//
+// Parameters:
+//   - path: The path of the YAML tenants config.
+//
+// Returns:
+//   - The tenants config, with map paths resolved relative to it.
+//   - An error naming the invalid tenant.
+func LoadTenantsConfig(path string) (*TenantsConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	var config TenantsConfig
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("could not parse tenants config %s: %v", path, err)
+	}
+	if len(config.Tenants) == 0 {
+		return nil, fmt.Errorf("tenants config %s defines no tenants", path)
+	}
+	seen := map[string]bool{}
+	for i := range config.Tenants {
+		tenant := &config.Tenants[i]
+		if tenant.Name == "" {
+			return nil, fmt.Errorf("tenant %d has no name", i)
+		}
+		if seen[tenant.Name] {
+			return nil, fmt.Errorf("tenant %s is defined twice", tenant.Name)
+		}
+		seen[tenant.Name] = true
+		if (tenant.Mirror == "") == (tenant.Map == "") {
+			return nil, fmt.Errorf("tenant %s must set exactly one of mirror and map", tenant.Name)
+		}
+		if (tenant.Map == "") != (tenant.MapRoots == "") {
+			return nil, fmt.Errorf("tenant %s must set mapRoots with map", tenant.Name)
+		}
+		if tenant.Map != "" && !filepath.IsAbs(tenant.Map) {
+			tenant.Map = filepath.Join(filepath.Dir(path), tenant.Map)
+		}
+		if tenant.MapRoots != "" && !filepath.IsAbs(tenant.MapRoots) {
+			tenant.MapRoots = filepath.Join(filepath.Dir(path), tenant.MapRoots)
+		}
+		if tenant.NameStrategy == "" {
+			tenant.NameStrategy = nameStrategyTimestamp
+		}
+		if tenant.NameStrategy != nameStrategyTimestamp && tenant.NameStrategy != nameStrategyDigest {
+			return nil, fmt.Errorf("tenant %s: nameStrategy must be %s or %s", tenant.Name, nameStrategyTimestamp, nameStrategyDigest)
+		}
+		if tenant.Output == "" {
+			tenant.Output = outputTrustRoot
+		}
+		if tenant.Output != outputTrustRoot && tenant.Output != outputConfigMap && tenant.Output != outputSecret {
+			return nil, fmt.Errorf("tenant %s: output must be %s, %s or %s", tenant.Name, outputTrustRoot, outputConfigMap, outputSecret)
+		}
+		for key, value := range tenant.Labels {
+			if !validLabelKey(key) {
+				return nil, fmt.Errorf("tenant %s: invalid label key %q", tenant.Name, key)
+			}
+			if !validLabelValue(value) {
+				return nil, fmt.Errorf("tenant %s: invalid value %q of label %s", tenant.Name, value, key)
+			}
+		}
+	}
+	return &config, nil
+}
+
+// TenantObject is an object rendered for a tenant.
+type TenantObject struct {
+	Name     string
+	Manifest []byte
+}
+
+// GenerateTenant assembles and renders the trust source of tenant in workDir
+// like the main command, and labels the output. A map renders an object per
+// repository, named after the repository, after the name prefix if set.
+//
+// Parameters:
+//   - tenant: The tenant, as validated by LoadTenantsConfig.
+//   - workDir: An empty working directory.
+//
+// Returns:
+//   - The rendered objects.
+//   - An error describing what went wrong.
+func GenerateTenant(tenant Tenant, workDir string) ([]TenantObject, error) {
+	if tenant.Map == "" {
+		rootJSONFile, err := AssembleRepository(tenant.Mirror, workDir)
+		if err != nil {
+			return nil, err
+		}
+		defer rootJSONFile.Close()
+		prefix := sourceName(tenant.Mirror)
+		if tenant.NamePrefix != "" {
+			prefix = tenant.NamePrefix
+		}
+		object, err := renderTenantObject(tenant, prefix, workDir, rootJSONFile.Name())
+		if err != nil {
+			return nil, err
+		}
+		return []TenantObject{object}, nil
+	}
+
+	repositories, err := AssembleMultiRepository(tenant.Map, tenant.MapRoots, workDir)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]TenantObject, 0, len(repositories))
+	for _, repository := range repositories {
+		prefix := sourceName(repository.Name)
+		if tenant.NamePrefix != "" {
+			prefix = tenant.NamePrefix + "-" + prefix
+		}
+		object, err := renderTenantObject(tenant, prefix, repository.Dir, repository.RootPath)
+		if err != nil {
+			return nil, fmt.Errorf("repository %s: %w", repository.Name, err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// renderTenantObject renders the repository assembled in dir for tenant,
+// once its archive loads like in the policy-controller.
+func renderTenantObject(tenant Tenant, prefix, dir, rootPath string) (TenantObject, error) {
+	if err := verifyArchive(dir, rootPath); err != nil {
+		return TenantObject{}, err
+	}
+	snapshotPath, err := latestMetadataPath(dir, "snapshot.json")
+	if err != nil {
+		return TenantObject{}, err
+	}
+	snapshotJSON, err := os.ReadFile(snapshotPath)
+	if err != nil {
+		return TenantObject{}, err
+	}
+	snapshotVersion, err := assembler.MetadataVersion(snapshotJSON)
+	if err != nil {
+		return TenantObject{}, fmt.Errorf("could not read version of snapshot.json: %v", err)
+	}
+	name, err := TrustRootName(tenant.NameStrategy, prefix, dir, snapshotVersion)
+	if err != nil {
+		return TenantObject{}, fmt.Errorf("could not name TrustRoot: %v", err)
+	}
+	rootJSONFile, err := os.Open(rootPath)
+	if err != nil {
+		return TenantObject{}, err
+	}
+	defer rootJSONFile.Close()
+	var output bytes.Buffer
+	if err := renderRepositoryTo(&output, tenant.Output, name, dir, rootJSONFile, tenant.Labels); err != nil {
+		return TenantObject{}, err
+	}
+	return TenantObject{Name: name, Manifest: output.Bytes()}, nil
+}
+
+// runTenants generates the output of every tenant of config, concurrency
+// tenants at a time, and applies it to the tenant destinations, or with apply
+// unset writes the manifests to w as a multi-document YAML stream in config
+// order. A failing tenant does not stop the others, and the errors are logged
+// in config order once every tenant is done.
+//
+// Returns:
+//   - The number of tenants that failed.
+func runTenants(config *TenantsConfig, apply bool, concurrency int, w io.Writer) int {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	manifests := make([][]byte, len(config.Tenants))
+	errs := make([]error, len(config.Tenants))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, tenant := range config.Tenants {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			manifests[i], errs[i] = runTenant(tenant, apply)
+		}()
+	}
+	wg.Wait()
+
+	failed, written := 0, 0
+	for i, tenant := range config.Tenants {
+		if errs[i] != nil {
+			log.Printf("Error: tenant %s: %v\n", tenant.Name, errs[i])
+			failed++
+			continue
+		}
+		if apply {
+			continue
+		}
+		if written > 0 {
+			io.WriteString(w, "---\n")
+		}
+		if _, err := w.Write(manifests[i]); err != nil {
+			log.Printf("Error: tenant %s: %v\n", tenant.Name, err)
+			failed++
+			continue
+		}
+		written++
+	}
+	return failed
+}
+
+// runTenant generates the output of one tenant and returns it, or with apply
+// set applies it to the tenant destinations.
+func runTenant(tenant Tenant, apply bool) ([]byte, error) {
+	workDir, err := mkdirTemp("tuf-repository-*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	objects, err := GenerateTenant(tenant, workDir)
+	if err != nil {
+		return nil, err
+	}
+	if !apply {
+		manifests := make([][]byte, 0, len(objects))
+		for _, object := range objects {
+			manifests = append(manifests, object.Manifest)
+		}
+		return bytes.Join(manifests, []byte("---\n")), nil
+	}
+	destinations := tenant.Destinations
+	if len(destinations) == 0 {
+		destinations = []TenantDestination{{}}
+	}
+	for _, destination := range destinations {
+		kube, err := newDestinationKubeClient(destination)
+		if err != nil {
+			return nil, fmt.Errorf("could not create Kubernetes client for context %q: %v", destination.Context, err)
+		}
+		for _, object := range objects {
+			if err := kube.applyOutput(tenant.Output, object.Name, object.Manifest, false); err != nil {
+				return nil, fmt.Errorf("could not apply %s %s: %v", tenant.Output, object.Name, err)
+			}
+			log.Printf("applied %s %s of tenant %s to %s\n", tenant.Output, object.Name, tenant.Name, kube.host)
+		}
+	}
+	return nil, nil
+}
+
+// newDestinationKubeClient returns a kubeClient for the cluster and namespace
+// of destination.
+func newDestinationKubeClient(destination TenantDestination) (*kubeClient, error) {
+	var kube *kubeClient
+	var err error
+	if destination.Context == "" {
+		kube, err = newKubeClient()
+	} else {
+		kube, err = newContextKubeClient(destination.Context)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if destination.Namespace != "" {
+		kube.namespace = destination.Namespace
+	}
+	return kube, nil
+}
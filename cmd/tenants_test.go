@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestLoadTenantsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "valid", config: `
+tenants:
+- name: team-a
+  mirror: https://tuf.team-a.example
+  labels:
+    team: a
+- name: team-b
+  map: map.json
+  mapRoots: roots
+  output: configmap
+  nameStrategy: digest
+  destinations:
+  - context: prod
+    namespace: team-b
+`},
+		{name: "no tenants", config: "tenants: []\n", wantErr: "no tenants"},
+		{name: "unnamed", config: "tenants:\n- mirror: https://tuf.example\n", wantErr: "has no name"},
+		{name: "duplicate", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n- name: a\n  mirror: https://tuf.example\n", wantErr: "defined twice"},
+		{name: "mirror and map", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n  map: map.json\n", wantErr: "exactly one of mirror and map"},
+		{name: "no source", config: "tenants:\n- name: a\n", wantErr: "exactly one of mirror and map"},
+		{name: "map without roots", config: "tenants:\n- name: a\n  map: map.json\n", wantErr: "must set mapRoots"},
+		{name: "unknown output", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n  output: digest\n", wantErr: "output must be"},
+		{name: "unknown name strategy", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n  nameStrategy: random\n", wantErr: "nameStrategy must be"},
+		{name: "invalid label", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n  labels:\n    \"a b\": c\n", wantErr: "invalid label key"},
+		{name: "invalid label value", config: "tenants:\n- name: a\n  mirror: https://tuf.example\n  labels:\n    team: \"a\\n  b: c\"\n", wantErr: "invalid value"},
+		{name: "unknown field", config: "tenants:\n- name: a\n  mirrors: https://tuf.example\n", wantErr: "mirrors"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "tenants.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o644); err != nil {
+				t.Fatalf("Failed to write tenants config: %v", err)
+			}
+			config, err := LoadTenantsConfig(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadTenantsConfig() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadTenantsConfig() error = %v", err)
+			}
+			a, b := config.Tenants[0], config.Tenants[1]
+			if a.Output != outputTrustRoot || a.NameStrategy != nameStrategyTimestamp {
+				t.Errorf("tenant %s defaults = %s, %s, want %s, %s", a.Name, a.Output, a.NameStrategy, outputTrustRoot, nameStrategyTimestamp)
+			}
+			if b.Map != filepath.Join(dir, "map.json") || b.MapRoots != filepath.Join(dir, "roots") {
+				t.Errorf("tenant %s map = %s, %s, want them relative to the config", b.Name, b.Map, b.MapRoots)
+			}
+			if len(b.Destinations) != 1 || b.Destinations[0].Context != "prod" || b.Destinations[0].Namespace != "team-b" {
+				t.Errorf("tenant %s destinations = %+v", b.Name, b.Destinations)
+			}
+		})
+	}
+}
+
+func TestRunTenants(t *testing.T) {
+	mirror := mockmirror.NewServer()
+	defer mirror.Close()
+	config := &TenantsConfig{Tenants: []Tenant{
+		{Name: "team-a", Mirror: mirror.URL, NamePrefix: "team-a", NameStrategy: nameStrategyTimestamp, Output: outputTrustRoot, Labels: map[string]string{"team": "a"}},
+		{Name: "broken", Mirror: mirror.URL + "/missing", NameStrategy: nameStrategyTimestamp, Output: outputTrustRoot},
+		{Name: "team-b", Mirror: mirror.URL, NamePrefix: "team-b", NameStrategy: nameStrategyTimestamp, Output: outputConfigMap,
+			Destinations: []TenantDestination{{Context: "east", Namespace: "team-b"}, {Context: "west", Namespace: "team-b"}}},
+	}}
+
+	t.Run("print", func(t *testing.T) {
+		var output bytes.Buffer
+		if failed := runTenants(config, false, 3, &output); failed != 1 {
+			t.Errorf("runTenants() failed = %d, want 1", failed)
+		}
+		documents := strings.Split(output.String(), "---\n")
+		if len(documents) != 2 {
+			t.Fatalf("runTenants() wrote %d documents, want 2", len(documents))
+		}
+		if !strings.Contains(documents[0], "kind: TrustRoot\nmetadata:\n  name: team-a-") || !strings.Contains(documents[0], "    team: a\n") {
+			t.Errorf("first document is not the labelled TrustRoot of team-a:\n%.300s", documents[0])
+		}
+		if !strings.Contains(documents[1], "kind: ConfigMap\nmetadata:\n  name: team-b-") {
+			t.Errorf("second document is not the ConfigMap of team-b:\n%.300s", documents[1])
+		}
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		var mu sync.Mutex
+		applied := map[string][]string{}
+		newCluster := func(name string) *httptest.Server {
+			return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.Copy(io.Discard, r.Body)
+				mu.Lock()
+				applied[name] = append(applied[name], r.Method+" "+r.URL.Path)
+				mu.Unlock()
+			}))
+		}
+		east, west := newCluster("east"), newCluster("west")
+		defer east.Close()
+		defer west.Close()
+		var kubeconfig strings.Builder
+		kubeconfig.WriteString("apiVersion: v1\nkind: Config\ncurrent-context: east\ncontexts:\n")
+		for _, cluster := range []string{"east", "west"} {
+			fmt.Fprintf(&kubeconfig, "- name: %s\n  context:\n    cluster: %s\n    user: admin\n", cluster, cluster)
+		}
+		kubeconfig.WriteString("clusters:\n")
+		for cluster, server := range map[string]*httptest.Server{"east": east, "west": west} {
+			ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+			fmt.Fprintf(&kubeconfig, "- name: %s\n  cluster:\n    server: %s\n    certificate-authority-data: %s\n", cluster, server.URL, base64.StdEncoding.EncodeToString(ca))
+		}
+		kubeconfig.WriteString("users:\n- name: admin\n  user:\n    token: test-token\n")
+		kubeconfigPath := filepath.Join(t.TempDir(), "config")
+		if err := os.WriteFile(kubeconfigPath, []byte(kubeconfig.String()), 0o600); err != nil {
+			t.Fatalf("Failed to write kubeconfig: %v", err)
+		}
+		t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		t.Setenv("KUBECONFIG", kubeconfigPath)
+
+		// One tenant at a time, so the applies reach east in config order
+		if failed := runTenants(config, true, 1, io.Discard); failed != 1 {
+			t.Errorf("runTenants() failed = %d, want 1", failed)
+		}
+		if len(applied["east"]) != 2 || !strings.HasPrefix(applied["east"][0], "PATCH /apis/policy.sigstore.dev/v1alpha1/trustroots/team-a-") ||
+			!strings.HasPrefix(applied["east"][1], "PATCH /api/v1/namespaces/team-b/configmaps/team-b-") {
+			t.Errorf("applied to east = %v, want the TrustRoot of team-a and the ConfigMap of team-b", applied["east"])
+		}
+		if len(applied["west"]) != 1 || !strings.HasPrefix(applied["west"][0], "PATCH /api/v1/namespaces/team-b/configmaps/team-b-") {
+			t.Errorf("applied to west = %v, want the ConfigMap of team-b", applied["west"])
+		}
+	})
+}
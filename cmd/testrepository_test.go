@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theupdateframework/go-tuf"
+)
+
+// testTargets are the targets of the repository created by newTestRepository.
+var testTargets = map[string]string{
+	"rekor.pub":         "rekor public key",
+	"trusted_root.json": `{"mediaType": "application/vnd.dev.sigstore.trustedroot+json;version=0.1"}`,
+}
+
+// newTestRepository creates a signed, consistent snapshot TUF repository with
+// testTargets and returns the directory laid out for serving.
+func newTestRepository(t *testing.T) string {
+	t.Helper()
+	return newTestRepositoryWithTargets(t, testTargets)
+}
+
+// newTestRepositoryWithTargets is newTestRepository with the given targets.
+func newTestRepositoryWithTargets(t *testing.T, targets map[string]string) string {
+	t.Helper()
+	return newTestRepositoryWith(t, targets, true)
+}
+
+// newNonConsistentTestRepository is newTestRepository without consistent
+// snapshots: metadata and targets are published under their plain names.
+func newNonConsistentTestRepository(t *testing.T) string {
+	t.Helper()
+	return newTestRepositoryWith(t, testTargets, false)
+}
+
+// newTestRepositoryWith creates a signed TUF repository with targets and
+// consistent snapshots enabled or not.
+func newTestRepositoryWith(t *testing.T, targets map[string]string, consistentSnapshot bool) string {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := tuf.NewRepo(tuf.FileSystemStore(dir, nil))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	if err := repo.Init(consistentSnapshot); err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	for _, role := range []string{"root", "targets", "snapshot", "timestamp"} {
+		if _, err := repo.GenKey(role); err != nil {
+			t.Fatalf("Failed to generate %s key: %v", role, err)
+		}
+	}
+	for name, content := range targets {
+		p := filepath.Join(dir, "staged", "targets", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("Failed to create targets directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write target %s: %v", name, err)
+		}
+		if err := repo.AddTarget(name, nil); err != nil {
+			t.Fatalf("Failed to add target %s: %v", name, err)
+		}
+	}
+	if err := repo.Snapshot(); err != nil {
+		t.Fatalf("Failed to snapshot repository: %v", err)
+	}
+	if err := repo.Timestamp(); err != nil {
+		t.Fatalf("Failed to timestamp repository: %v", err)
+	}
+	if err := repo.Commit(); err != nil {
+		t.Fatalf("Failed to commit repository: %v", err)
+	}
+	return filepath.Join(dir, "repository")
+}
+
+// updateTestRepository publishes new versions of the targets, snapshot and
+// timestamp metadata of the repository created by newTestRepository in
+// repositoryDir, signed by its keys, with the content of target name
+// replaced.
+func updateTestRepository(t *testing.T, repositoryDir, name, content string) {
+	t.Helper()
+	dir := filepath.Dir(repositoryDir)
+	repo, err := tuf.NewRepo(tuf.FileSystemStore(dir, nil))
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	p := filepath.Join(dir, "staged", "targets", name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("Failed to create targets directory: %v", err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write target %s: %v", name, err)
+	}
+	if err := repo.AddTarget(name, nil); err != nil {
+		t.Fatalf("Failed to add target %s: %v", name, err)
+	}
+	if err := repo.Snapshot(); err != nil {
+		t.Fatalf("Failed to snapshot repository: %v", err)
+	}
+	if err := repo.Timestamp(); err != nil {
+		t.Fatalf("Failed to timestamp repository: %v", err)
+	}
+	if err := repo.Commit(); err != nil {
+		t.Fatalf("Failed to commit repository: %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+// verifiedRepository is a TUF repository whose metadata has been updated and
+// verified by a go-tuf client. Any number of them can be opened in the same
+// process.
+type verifiedRepository struct {
+	*assembler.Repository
+	// expired are the targets Sigstore marked as expired, whose downloads
+	// follow the expired-target FetchPolicy.
+	expired map[string]bool
+}
+
+// openVerifiedRepository bootstraps a TUF client for mirror with rootJSON and
+// updates it to the latest verified metadata, fetching files with their
+// fetchPolicies.
+func openVerifiedRepository(mirror string, rootJSON []byte) (*verifiedRepository, error) {
+	next, err := NewFetcher(mirror)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := &policyFetcher{next: next}
+	repository, err := openVerifiedRemote(mirror, remoteStore{fetcher}, rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	fetcher.expired = expiredTargets(repository.Targets)
+	repository.expired = fetcher.expired
+	return repository, nil
+}
+
+// update updates the repository opened by openVerifiedRepository to the
+// latest verified metadata of its mirror. Only the timestamp.json and newer
+// root versions are fetched if nothing changed, and snapshot.json and
+// targets.json once their new versions are published.
+func (r *verifiedRepository) update() error {
+	if _, err := r.Client.Update(); err != nil {
+		return fmt.Errorf("could not update TUF metadata from %s: %w", r.Mirror, assembler.ClassifyTUFError(err))
+	}
+	// Update only returns the targets changed by a new targets.json
+	targets, err := r.Client.Targets()
+	if err != nil {
+		return err
+	}
+	r.Targets = targets
+	// The fetcher shares the map
+	clear(r.expired)
+	for name := range expiredTargets(targets) {
+		r.expired[name] = true
+	}
+	return nil
+}
+
+// openVerifiedDirectory verifies a repository laid out by MirrorSync in dir,
+// bootstrapping from its oldest N.root.json, and checks every target against
+// the verified metadata.
+func openVerifiedDirectory(dir string) (*verifiedRepository, error) {
+	return verifyDirectory(dir, dirFetcher{dir: dir}, nil)
+}
+
+// verifyAssembledDirectory verifies a repository assembled in dir, whose
+// targets are stored under their plain names, like openVerifiedDirectory.
+func verifyAssembledDirectory(dir string) error {
+	_, err := verifyDirectory(dir, dirFetcher{dir: dir, plainTargets: true}, nil)
+	return err
+}
+
+// verifyDirectory verifies the repository in dir read by fetcher, see
+// openVerifiedDirectory, except for the targets in skipped, left out of it.
+func verifyDirectory(dir string, fetcher dirFetcher, skipped map[string]bool) (*verifiedRepository, error) {
+	roots, err := filepath.Glob(filepath.Join(dir, "*.root.json"))
+	if err != nil {
+		return nil, err
+	}
+	oldest, oldestVersion := "", int64(0)
+	for _, root := range roots {
+		var version int64
+		if _, err := fmt.Sscanf(filepath.Base(root), "%d.root.json", &version); err != nil {
+			continue
+		}
+		if oldest == "" || version < oldestVersion {
+			oldest, oldestVersion = root, version
+		}
+	}
+	if oldest == "" {
+		return nil, fmt.Errorf("no N.root.json found in %s", dir)
+	}
+	rootJSON, err := os.ReadFile(oldest)
+	if err != nil {
+		return nil, err
+	}
+	if fetcher.opened != nil {
+		fetcher.opened[filepath.Base(oldest)] = true
+	}
+	repository, err := openVerifiedRemote(dir, remoteStore{fetcher}, rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	for name := range repository.Targets {
+		if skipped[name] {
+			continue
+		}
+		if err := repository.Client.Download(name, discardDestination{}); err != nil {
+			return nil, fmt.Errorf("target %s of %s does not verify: %w", name, dir, assembler.ClassifyTUFError(err))
+		}
+	}
+	return repository, nil
+}
+
+// openVerifiedRemote bootstraps a TUF client for remote with rootJSON and
+// updates it to the latest verified metadata, see assembler.Assembler.Open.
+func openVerifiedRemote(mirror string, remote client.RemoteStore, rootJSON []byte) (*verifiedRepository, error) {
+	a, err := assembler.New(assembler.WithMirror(mirror), assembler.WithRemoteStore(remote), assembler.WithRoot(rootJSON))
+	if err != nil {
+		return nil, err
+	}
+	repository, err := a.Open()
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedRepository{Repository: repository}, nil
+}
+
+// dirFetcher is a Fetcher reading a repository from a local directory laid
+// out like a consistent snapshot mirror.
+type dirFetcher struct {
+	dir string
+	// plainTargets reads the consistent snapshot target <hash>.<name> from
+	// targets/<name>, the layout of assembled repositories.
+	plainTargets bool
+	// opened records the slash separated paths of the files read, if set.
+	opened map[string]bool
+}
+
+func (d dirFetcher) GetMetadata(name string) (io.ReadCloser, int64, error) {
+	return d.open(name)
+}
+
+func (d dirFetcher) GetTarget(target string) (io.ReadCloser, int64, error) {
+	if d.plainTargets {
+		dir, base := path.Split(target)
+		if digest, name, ok := strings.Cut(base, "."); ok && isHexDigest(digest) {
+			target = dir + name
+		}
+	}
+	return d.open(filepath.Join("targets", filepath.FromSlash(target)))
+}
+
+// isHexDigest reports whether s is a hex encoded SHA-256 or SHA-512 digest,
+// the prefix of consistent snapshot target names.
+func isHexDigest(s string) bool {
+	if len(s) != 64 && len(s) != 128 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func (d dirFetcher) open(name string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(filepath.Join(d.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, client.ErrNotFound{File: name}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if d.opened != nil {
+		d.opened[filepath.ToSlash(name)] = true
+	}
+	return file, info.Size(), nil
+}
+
+// discardDestination is a client.Destination dropping what it is written,
+// downloading to it only verifies a target.
+type discardDestination struct{}
+
+func (discardDestination) Write(p []byte) (int, error) { return len(p), nil }
+func (discardDestination) Delete() error               { return nil }
+
+// writeMetadata writes the verified top-level metadata into dir, see
+// assembler.Repository.WriteMetadata, and logs their versions.
+func (r *verifiedRepository) writeMetadata(dir string) (string, error) {
+	rootPath, err := r.WriteMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+	r.logVersions()
+	return rootPath, nil
+}
+
+// logVersions logs the versions of the verified top-level metadata of r.
+func (r *verifiedRepository) logVersions() {
+	meta, err := r.Local.GetMeta()
+	if err != nil {
+		return
+	}
+	var versions []string
+	for _, role := range []string{"root.json", "snapshot.json", "targets.json", "timestamp.json"} {
+		if version, err := assembler.MetadataVersion(meta[role]); err == nil {
+			versions = append(versions, fmt.Sprintf("%s v%d", role, version))
+		}
+	}
+	log.Printf("verified %s: %s\n", r.Mirror, strings.Join(versions, ", "))
+}
+
+// fetchLatestRoot downloads the latest root.json published by mirror, found in
+// the directory listing of HTTP mirrors serving one and by probing versions
+// otherwise, e.g. for buckets in website mode or web servers without
+// autoindex answering listings with 403 or 404.
+func fetchLatestRoot(mirror string) ([]byte, error) {
+	if isHTTPSource(mirror) {
+		_, latestRootURL, err := latestListedMetadata(mirror, "root.json")
+		if err == nil {
+			return fetch(latestRootURL)
+		}
+		log.Printf("no directory listing at %s, probing root.json versions: %v\n", mirror, err)
+	}
+	fetcher, err := NewFetcher(mirror)
+	if err != nil {
+		return nil, err
+	}
+	return latestRoot(&policyFetcher{next: fetcher})
+}
+
+// latestMetadataPath returns the path of the latest version of the metadata
+// role in dir: timestamp.json, or the N.<role> with the highest N, or else
+// the plain <role> of a repository without consistent snapshots.
+func latestMetadataPath(dir, role string) (string, error) {
+	if role == "timestamp.json" {
+		return filepath.Join(dir, role), nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*."+role))
+	if err != nil {
+		return "", err
+	}
+	latest, latestVersion := "", int64(-1)
+	for _, path := range paths {
+		var version int64
+		if _, err := fmt.Sscanf(filepath.Base(path), "%d."+role, &version); err != nil {
+			continue
+		}
+		if version > latestVersion {
+			latest, latestVersion = path, version
+		}
+	}
+	if latest == "" {
+		if _, err := os.Stat(filepath.Join(dir, role)); err == nil {
+			return filepath.Join(dir, role), nil
+		}
+		return "", fmt.Errorf("no N.%s or %s found in %s", role, role, dir)
+	}
+	return latest, nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Vault auth methods of --vault-auth.
+const (
+	vaultAuthToken      = "token"
+	vaultAuthKubernetes = "kubernetes"
+	vaultAuthAppRole    = "approle"
+)
+
+// VaultAuth logs in to Vault with one of the vaultAuth* methods.
+type VaultAuth struct {
+	Method string
+	// Mount is the path the auth method is mounted at, the method name by
+	// default.
+	Mount string
+	// Token is the token of the token method.
+	Token string
+	// Role is the role of the kubernetes method, logging in with the
+	// service account token of the pod.
+	Role string
+	// RoleID and SecretID are the credentials of the approle method.
+	RoleID   string
+	SecretID string
+}
+
+// VaultClient is a minimal Vault API client writing KV version 2 secrets.
+type VaultClient struct {
+	Addr string
+	// Namespace is the Vault Enterprise namespace of the requests, if set.
+	Namespace string
+	Token     string
+}
+
+// NewVaultClient returns a VaultClient for the Vault at addr, logged in with
+// auth.
+//
+// Parameters:
+//   - addr: The address of Vault, e.g. https://vault.example.com:8200.
+//   - namespace: The Vault Enterprise namespace, "" for none.
+//   - auth: The auth method and its credentials.
+//
+// Returns:
+//   - The logged in client.
+//   - An error if the auth method is unknown or the login failed.
+func NewVaultClient(addr, namespace string, auth VaultAuth) (*VaultClient, error) {
+	client := &VaultClient{Addr: strings.TrimSuffix(addr, "/"), Namespace: namespace}
+	mount := auth.Mount
+	if mount == "" {
+		mount = auth.Method
+	}
+	var login map[string]string
+	switch auth.Method {
+	case vaultAuthToken:
+		if auth.Token == "" {
+			return nil, fmt.Errorf("the %s auth method requires a token, e.g. in VAULT_TOKEN", vaultAuthToken)
+		}
+		client.Token = auth.Token
+		return client, nil
+	case vaultAuthKubernetes:
+		jwt, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		if err != nil {
+			return nil, fmt.Errorf("could not read service account token: %v", err)
+		}
+		login = map[string]string{"role": auth.Role, "jwt": strings.TrimSpace(string(jwt))}
+	case vaultAuthAppRole:
+		login = map[string]string{"role_id": auth.RoleID, "secret_id": auth.SecretID}
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method %q, must be %s, %s or %s", auth.Method, vaultAuthToken, vaultAuthKubernetes, vaultAuthAppRole)
+	}
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := client.do(http.MethodPost, "auth/"+strings.Trim(mount, "/")+"/login", login, &response); err != nil {
+		return nil, fmt.Errorf("%s login failed: %v", auth.Method, err)
+	}
+	if response.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("%s login returned no token", auth.Method)
+	}
+	client.Token = response.Auth.ClientToken
+	return client, nil
+}
+
+// WriteKV writes data as a new version of the secret at path of the KV
+// version 2 secrets engine mounted at mount.
+//
+// Parameters:
+//   - mount: The mount path of the secrets engine, e.g. secret.
+//   - path: The path of the secret in the engine.
+//   - data: The key-value pairs of the secret.
+//
+// Returns:
+//   - The version of the written secret.
+//   - An error if Vault rejected the write.
+func (c *VaultClient) WriteKV(mount, path string, data map[string]string) (int, error) {
+	var response struct {
+		Data struct {
+			Version int `json:"version"`
+		} `json:"data"`
+	}
+	apiPath := strings.Trim(mount, "/") + "/data/" + strings.Trim(path, "/")
+	if err := c.do(http.MethodPost, apiPath, map[string]any{"data": data}, &response); err != nil {
+		return 0, err
+	}
+	return response.Data.Version, nil
+}
+
+// do sends body as JSON to the Vault API path, decoding the answer into
+// response.
+func (c *VaultClient) do(method, path string, body, response any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.Addr+"/v1/"+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Token != "" {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var vaultError struct {
+			Errors []string `json:"errors"`
+		}
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(content, &vaultError) == nil && len(vaultError.Errors) > 0 {
+			return fmt.Errorf("%s /v1/%s: %s: %s", method, path, resp.Status, strings.Join(vaultError.Errors, ", "))
+		}
+		return fmt.Errorf("%s /v1/%s: %s", method, path, resp.Status)
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// trustMaterialData returns the key-value pairs --vault-path, --aws-secret-id
+// and --gcp-secret store: the printed manifest, root.json and the base64
+// encoded repository.tar.gz of the repository in workDir.
+func trustMaterialData(manifest, workDir string, rootJSON []byte) (map[string]string, error) {
+	var archive bytes.Buffer
+	if err := compressRepository(workDir, &archive); err != nil {
+		return nil, fmt.Errorf("could not compress repository directory: %w", err)
+	}
+	return map[string]string{
+		"manifest":       manifest,
+		secretRootKey:    string(rootJSON),
+		secretArchiveKey: base64.StdEncoding.EncodeToString(archive.Bytes()),
+	}, nil
+}
+
+// storeVault writes the printed manifest, root.json and archive of the
+// repository assembled in workDir to the --vault-path secret, exiting on
+// errors.
+func storeVault(path, mount string, auth VaultAuth, manifest, workDir string, rootJSONFile *os.File) {
+	if path == "" {
+		return
+	}
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		log.Fatalf("Error: --vault-path requires VAULT_ADDR")
+	}
+	client, err := NewVaultClient(addr, os.Getenv("VAULT_NAMESPACE"), auth)
+	if err != nil {
+		log.Fatalf("Error: could not log in to Vault %s: %v", addr, err)
+	}
+	rootJSON, err := os.ReadFile(rootJSONFile.Name())
+	if err != nil {
+		log.Fatalf("Error: could not read root.json: %v", err)
+	}
+	data, err := trustMaterialData(manifest, workDir, rootJSON)
+	if err != nil {
+		fatalf(err, "Error: %v", err)
+	}
+	version, err := client.WriteKV(mount, path, data)
+	if err != nil {
+		fatalf(err, "Error: could not write %s/%s to Vault: %v", mount, path, err)
+	}
+	log.Printf("wrote trust material to Vault %s/%s version %d\n", mount, path, version)
+}
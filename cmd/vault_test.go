@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewVaultClient(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      VaultAuth
+		wantPath  string
+		wantLogin map[string]string
+		wantToken string
+		wantErr   bool
+	}{
+		{"token", VaultAuth{Method: vaultAuthToken, Token: "s.static"}, "", nil, "s.static", false},
+		{"token missing", VaultAuth{Method: vaultAuthToken}, "", nil, "", true},
+		{"approle", VaultAuth{Method: vaultAuthAppRole, RoleID: "role", SecretID: "secret"}, "/v1/auth/approle/login",
+			map[string]string{"role_id": "role", "secret_id": "secret"}, "s.login", false},
+		{"approle custom mount", VaultAuth{Method: vaultAuthAppRole, Mount: "ci/approle", RoleID: "role", SecretID: "secret"}, "/v1/auth/ci/approle/login",
+			map[string]string{"role_id": "role", "secret_id": "secret"}, "s.login", false},
+		{"approle rejected", VaultAuth{Method: vaultAuthAppRole, RoleID: "role", SecretID: "wrong"}, "/v1/auth/approle/login",
+			map[string]string{"role_id": "role", "secret_id": "wrong"}, "", true},
+		{"unknown method", VaultAuth{Method: "ldap"}, "", nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var login map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.wantPath {
+					t.Errorf("unexpected request to %s, want %s", r.URL.Path, tt.wantPath)
+				}
+				if r.Header.Get("X-Vault-Namespace") != "team" {
+					t.Errorf("X-Vault-Namespace = %q, want team", r.Header.Get("X-Vault-Namespace"))
+				}
+				if err := json.NewDecoder(r.Body).Decode(&login); err != nil {
+					t.Errorf("Failed to decode login: %v", err)
+				}
+				if login["secret_id"] == "wrong" {
+					w.WriteHeader(http.StatusBadRequest)
+					io.WriteString(w, `{"errors":["invalid role or secret ID"]}`)
+					return
+				}
+				io.WriteString(w, `{"auth":{"client_token":"s.login"}}`)
+			}))
+			defer server.Close()
+
+			client, err := NewVaultClient(server.URL+"/", "team", tt.auth)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewVaultClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantLogin != nil && (login["role_id"] != tt.wantLogin["role_id"] || login["secret_id"] != tt.wantLogin["secret_id"]) {
+				t.Errorf("login = %v, want %v", login, tt.wantLogin)
+			}
+			if err == nil && client.Token != tt.wantToken {
+				t.Errorf("token = %q, want %q", client.Token, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestVaultClientWriteKV(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"written", "s.valid", false},
+		{"permission denied", "s.expired", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			var body struct {
+				Data map[string]string `json:"data"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				if r.Header.Get("X-Vault-Token") != "s.valid" {
+					w.WriteHeader(http.StatusForbidden)
+					io.WriteString(w, `{"errors":["permission denied"]}`)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("Failed to decode secret: %v", err)
+				}
+				io.WriteString(w, `{"data":{"version":3}}`)
+			}))
+			defer server.Close()
+			client := &VaultClient{Addr: server.URL, Token: tt.token}
+
+			version, err := client.WriteKV("/kv/", "/clusters/east/trustroot", map[string]string{"manifest": "kind: TrustRoot\n"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteKV() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if path != "/v1/kv/data/clusters/east/trustroot" {
+				t.Errorf("path = %s, want /v1/kv/data/clusters/east/trustroot", path)
+			}
+			if err == nil && (version != 3 || body.Data["manifest"] != "kind: TrustRoot\n") {
+				t.Errorf("WriteKV() = %d with %v", version, body.Data)
+			}
+		})
+	}
+}
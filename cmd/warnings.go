@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// failOnWarningUsage is the usage of the --fail-on-warning flag.
+const failOnWarningUsage = "Fail the run on any warning about the trust root, e.g. expired targets skipped, metadata expiring within a day or an output close to its size limit, instead of shipping it"
+
+// failOnWarning promotes warnings to failures, set by --fail-on-warning.
+var failOnWarning bool
+
+// warnf logs a warning about the trust root being generated, or with
+// failOnWarning fails the run with it like fatalf, with the exit code of
+// err, nil for 1. The message is formatted from format and v.
+func warnf(err error, format string, v ...any) {
+	message := fmt.Sprintf(format, v...)
+	if failOnWarning {
+		fatalf(err, "Error: %s (--fail-on-warning)\n", message)
+	}
+	log.Printf("warning: %s\n", message)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarnf(t *testing.T) {
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	warnf(nil, "skipping expired target %s", "ctfe.pub")
+	if !strings.HasSuffix(logs.String(), "warning: skipping expired target ctfe.pub\n") {
+		t.Errorf("warnf() logged %q", logs.String())
+	}
+}
+
+func TestCheckExpiryGraceWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		expires     time.Duration
+		wantWarning bool
+	}{
+		{"valid for a week", 7 * 24 * time.Hour, false},
+		{"expiring within a day", time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workDir := t.TempDir()
+			metadata := []byte(fmt.Sprintf(`{"signed":{"expires":%q}}`, time.Now().Add(tt.expires).UTC().Format(time.RFC3339)))
+			if err := os.WriteFile(filepath.Join(workDir, "timestamp.json"), metadata, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			var logs strings.Builder
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			checkExpiryGrace(workDir, metadata, 0)
+			warned := strings.Contains(logs.String(), "warning: metadata expires within 24h0m0s: snapshot.json expires")
+			if warned != tt.wantWarning {
+				t.Errorf("checkExpiryGrace() logged %q, want warning %v", logs.String(), tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// Watcher periodically checks a TUF repository and regenerates its TrustRoot
+// when the upstream root or targets change.
+type Watcher struct {
+	Mirror string
+	// Name is the stable name of the TrustRoot.
+	Name string
+	// Out is the file the TrustRoot YAML is written to, if set.
+	Out string
+	// Kube applies the TrustRoot to the cluster, if set.
+	Kube *kubeClient
+	// Notifiers are notified of every WebhookEvent.
+	Notifiers []Notifier
+	// CloudEventSinks receive a GenerationEvent for every generated TrustRoot.
+	CloudEventSinks []CloudEventSink
+	// History records every generated TrustRoot, if set.
+	History *History
+	// AuditLog receives an AuditEntry for every generated TrustRoot, if set.
+	AuditLog string
+	// Jitter spreads the delays of Run by up to this fraction of them, so a
+	// fleet of watchers started together does not poll in lockstep.
+	Jitter float64
+	// MaxBackoff caps the delay of Run after consecutive failed refreshes,
+	// doubled from the interval after each failure; the interval at least.
+	MaxBackoff time.Duration
+
+	// root and targets describe the upstream state of the last generation,
+	// whose root.json is rootJSON, and keyTargets its targets holding keys
+	// or certificates.
+	root       string
+	rootJSON   []byte
+	targets    map[string]string
+	keyTargets map[string]string
+	// repository holds the verified metadata of the last refresh, updated
+	// in place by the next one.
+	repository *verifiedRepository
+}
+
+// Run refreshes the TrustRoot every interval, spread by Jitter, until stop
+// is closed. Errors of a refresh are logged and retried with exponential
+// backoff, no sooner than the Retry-After of the mirror.
+func (w *Watcher) Run(interval time.Duration, stop <-chan struct{}) {
+	failures := 0
+	for {
+		delay := jittered(interval, w.Jitter)
+		if err := w.Refresh(); err != nil {
+			failures++
+			delay = w.backoff(interval, failures, err)
+			log.Printf("refresh of %s failed, retrying in %s: %v\n", w.Mirror, delay.Round(time.Second), err)
+		} else {
+			failures = 0
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns the delay of Run after failures consecutive failed
+// refreshes, the last one failing with err: the interval doubled for each
+// failure after the first and spread by Jitter, up to MaxBackoff, and no
+// sooner than the Retry-After of err within that limit.
+func (w *Watcher) backoff(interval time.Duration, failures int, err error) time.Duration {
+	limit := max(w.MaxBackoff, interval)
+	delay := interval
+	for i := 1; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	// Jitter spreads the delay, but never beyond the limit
+	delay = min(jittered(delay, w.Jitter), limit)
+	if after := retryAfter(err); after > delay {
+		delay = min(after, limit)
+	}
+	return delay
+}
+
+// jittered returns delay spread uniformly by up to jitter times it.
+func jittered(delay time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return delay
+	}
+	return delay + time.Duration(jitter*(2*rand.Float64()-1)*float64(delay))
+}
+
+// Refresh verifies the upstream repository and, if its root or targets
+// changed since the last generation, generates, writes and applies the
+// TrustRoot again.
+//
+// Returns:
+//   - error: nil if the TrustRoot is up to date.
+func (w *Watcher) Refresh() error {
+	repository, err := w.verifiedRepository()
+	if err != nil {
+		return err
+	}
+	meta, err := repository.Local.GetMeta()
+	if err != nil {
+		return err
+	}
+	rootJSON := meta["root.json"]
+	rootSum := sha256.Sum256(rootJSON)
+	root := hex.EncodeToString(rootSum[:])
+	targets, keyTargets := map[string]string{}, map[string]string{}
+	for name, meta := range repository.Targets {
+		targets[name] = targetDigest(meta)
+		if isKeyTarget(name, meta) {
+			keyTargets[name] = targets[name]
+		}
+	}
+	var keyChanges []string
+	if w.targets != nil {
+		if len(stateChanges(w.root, root, w.targets, targets)) == 0 {
+			return nil
+		}
+		keyChanges = stateChanges(w.root, root, w.keyTargets, keyTargets)
+	}
+	w.checkRootRotation(rootJSON)
+
+	workDir, err := os.MkdirTemp(tempDir(), "tuf-repository-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+	rootJSONFile, err := repository.assemble(workDir)
+	if err != nil {
+		return err
+	}
+	defer rootJSONFile.Close()
+	trustRootYAML, err := RenderRepositoryTrustRoot(w.Name, workDir, rootJSONFile)
+	if err != nil {
+		return err
+	}
+	if w.Out != "" {
+		if err := writeFileAtomic(w.Out, []byte(trustRootYAML)); err != nil {
+			return err
+		}
+	}
+	if w.Kube != nil {
+		if err := w.Kube.applyTrustRoot(w.Name, []byte(trustRootYAML)); err != nil {
+			w.notify(EventApplyFailed, err.Error())
+			return fmt.Errorf("could not apply TrustRoot %s: %v", w.Name, err)
+		}
+	}
+	if w.AuditLog != "" {
+		entry, err := newAuditEntry(w.Mirror, outputTrustRoot, w.Name, workDir, rootJSON, strings.NewReader(trustRootYAML))
+		if err != nil {
+			return err
+		}
+		if _, err := AppendAuditEntry(w.AuditLog, entry); err != nil {
+			return fmt.Errorf("could not record TrustRoot %s in the audit log: %w", w.Name, err)
+		}
+	}
+	if w.History != nil {
+		if _, err := w.History.Record(w.Name, w.Mirror, workDir, trustRootYAML); err != nil {
+			log.Printf("could not record TrustRoot in history %s: %v\n", w.History.Dir, err)
+		}
+	}
+	w.root, w.rootJSON, w.targets, w.keyTargets = root, rootJSON, targets, keyTargets
+	// Only once the rotated material is rolled out, and once per generation
+	if len(keyChanges) > 0 {
+		w.notify(EventKeysRotated, strings.Join(keyChanges, ", "))
+	}
+	w.notify(EventGenerated, fmt.Sprintf("generated from %d targets", len(targets)))
+	w.sendGenerationEvent(workDir, rootJSON)
+	return nil
+}
+
+// checkRootRotation compares rootJSON with the root.json of the last
+// generation, or on the first refresh with that of the latest generation of
+// the mirror in the History, and on rotated root keys or thresholds sends an
+// EventRootKeysRotated and records a Warning Event on the TrustRoot.
+func (w *Watcher) checkRootRotation(rootJSON []byte) {
+	previousRootJSON := w.rootJSON
+	if previousRootJSON == nil && w.History != nil {
+		var err error
+		if previousRootJSON, _, err = previousRoot("", w.History, w.Mirror); err != nil {
+			log.Printf("could not read the previous TrustRoot in history %s: %v\n", w.History.Dir, err)
+		}
+	}
+	if len(previousRootJSON) == 0 || bytes.Equal(previousRootJSON, rootJSON) {
+		return
+	}
+	rotations, err := CompareRootKeys(previousRootJSON, rootJSON)
+	if err != nil {
+		log.Printf("could not compare the root keys of %s: %v\n", w.Mirror, err)
+		return
+	}
+	if len(rotations) == 0 {
+		return
+	}
+	changes := make([]string, 0, len(rotations))
+	for _, rotation := range rotations {
+		changes = append(changes, rotation.String())
+	}
+	message := strings.Join(changes, "; ")
+	w.notify(EventRootKeysRotated, message)
+	if w.Kube != nil {
+		if err := w.Kube.recordRootRotationEvent(w.Name, message); err != nil {
+			log.Printf("could not record the %s Event of TrustRoot %s: %v\n", rootKeysRotatedReason, w.Name, err)
+		}
+	}
+}
+
+// verifiedRepository returns the repository of the last refresh updated to
+// the latest metadata of the mirror, which only fetches and verifies the
+// metadata published since, or on the first refresh a repository
+// bootstrapped from the initialRoot. A repository that no longer updates,
+// e.g. after the mirror was re-keyed out of band, keeps its last trusted
+// state and the refresh fails: it is only bootstrapped again from a root
+// pinned by --root-source or --root-checksum, as the latest root.json of the
+// mirror is exactly what can no longer be trusted. Without them, an operator
+// restarting the watch bootstraps it again.
+func (w *Watcher) verifiedRepository() (*verifiedRepository, error) {
+	if w.repository != nil {
+		err := w.repository.update()
+		if err == nil {
+			return w.repository, nil
+		}
+		w.notify(EventUpdateFailed, err.Error())
+		if rootSource == "" && rootChecksum == "" {
+			return nil, fmt.Errorf("could not update the verified metadata of %s, keeping the last trusted state until the watch is restarted: %w", w.Mirror, err)
+		}
+		log.Printf("could not update the verified metadata of %s, bootstrapping again from the pinned root: %v\n", w.Mirror, err)
+	}
+	rootJSON, err := initialRoot(w.Mirror)
+	if err != nil {
+		return nil, err
+	}
+	repository, err := openVerifiedRepository(w.Mirror, rootJSON)
+	if err != nil {
+		return nil, err
+	}
+	w.repository = repository
+	return repository, nil
+}
+
+// sendGenerationEvent sends the GenerationEvent of the TrustRoot assembled in
+// workDir to every CloudEvent sink, logging failures.
+func (w *Watcher) sendGenerationEvent(workDir string, rootJSON []byte) {
+	if len(w.CloudEventSinks) == 0 {
+		return
+	}
+	event, err := newGenerationEvent(w.Name, outputTrustRoot, workDir, rootJSON)
+	if err != nil {
+		log.Printf("could not describe TrustRoot %s for CloudEvents: %v\n", w.Name, err)
+		return
+	}
+	for _, sink := range w.CloudEventSinks {
+		if err := sink.Send(w.Mirror, event); err != nil {
+			log.Printf("could not send CloudEvent: %v\n", err)
+		}
+	}
+}
+
+// notify sends an event to every notifier, logging failures.
+func (w *Watcher) notify(eventType, message string) {
+	log.Printf("%s: %s\n", eventType, message)
+	event := WebhookEvent{Type: eventType, TrustRoot: w.Name, Mirror: w.Mirror, Message: message, Time: time.Now().UTC()}
+	for _, notifier := range w.Notifiers {
+		if err := notifier.Notify(event); err != nil {
+			log.Printf("could not send %s event: %v\n", eventType, err)
+		}
+	}
+}
+
+// targetDigest returns the hashes of a target as listed in its metadata, so
+// targets listed with any hash algorithm, e.g. only SHA-512, are compared.
+func targetDigest(meta data.TargetFileMeta) string {
+	algorithms := make([]string, 0, len(meta.Hashes))
+	for algorithm := range meta.Hashes {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	digests := make([]string, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		digests = append(digests, algorithm+":"+meta.Hashes[algorithm].String())
+	}
+	return strings.Join(digests, ",")
+}
+
+// isKeyTarget reports whether the target name holds trust material: its
+// Sigstore custom metadata declares a usage, or, lacking one, it is the
+// trusted_root.json or a public key or certificate by its extension.
+func isKeyTarget(name string, meta data.TargetFileMeta) bool {
+	if custom := targetSigstoreMetadata(meta); custom != nil && custom.Usage != "" {
+		return true
+	}
+	base := path.Base(name)
+	return base == "trusted_root.json" || strings.HasSuffix(base, ".pub") || strings.HasSuffix(base, ".pem")
+}
+
+// stateChanges describes the differences between two upstream states, given
+// as root.json digest and target name to targetDigest.
+func stateChanges(oldRoot, newRoot string, oldTargets, newTargets map[string]string) []string {
+	var changes []string
+	if oldRoot != newRoot {
+		changes = append(changes, "root.json changed")
+	}
+	names := map[string]bool{}
+	for name := range oldTargets {
+		names[name] = true
+	}
+	for name := range newTargets {
+		names[name] = true
+	}
+	for _, name := range sortedKeys(names) {
+		oldSum, wasListed := oldTargets[name]
+		newSum, isListed := newTargets[name]
+		switch {
+		case !wasListed:
+			changes = append(changes, "added "+name)
+		case !isListed:
+			changes = append(changes, "removed "+name)
+		case oldSum != newSum:
+			changes = append(changes, "changed "+name)
+		}
+	}
+	return changes
+}
+
+// writeFileAtomic replaces path with content, so readers never see a
+// partially written file.
+func writeFileAtomic(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+	"github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+)
+
+// eventRecorder is a webhook server recording the types of received events.
+type eventRecorder struct {
+	*httptest.Server
+	mu     sync.Mutex
+	events []string
+}
+
+func newEventRecorder(t *testing.T) *eventRecorder {
+	t.Helper()
+	recorder := &eventRecorder{}
+	recorder.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := WebhookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode event: %v", err)
+		}
+		recorder.mu.Lock()
+		recorder.events = append(recorder.events, event.Type)
+		recorder.mu.Unlock()
+	}))
+	t.Cleanup(recorder.Close)
+	return recorder
+}
+
+// take returns and forgets the recorded event types.
+func (r *eventRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestWatcherRefresh(t *testing.T) {
+	var mu sync.Mutex
+	current := newTestRepository(t)
+	var requests []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dir := current
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		RepositoryHandler(dir).ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+	webhook := newEventRecorder(t)
+	out := filepath.Join(t.TempDir(), "trustroot.yaml")
+	auditLog := filepath.Join(t.TempDir(), "audit.jsonl")
+	watcher := &Watcher{Mirror: mirror.URL, Name: "sigstore", Out: out, AuditLog: auditLog, Notifiers: []Notifier{Webhook{URL: webhook.URL}}}
+
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("first Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first refresh events = %v, want %v", got, want)
+	}
+	trustRootYAML, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("TrustRoot was not written: %v", err)
+	}
+	if !strings.Contains(string(trustRootYAML), "name: sigstore\n") {
+		t.Errorf("unexpected TrustRoot:\n%s", trustRootYAML)
+	}
+
+	mu.Lock()
+	requests = nil
+	mu.Unlock()
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("unchanged Refresh() error = %v", err)
+	}
+	if got := webhook.take(); len(got) != 0 {
+		t.Errorf("unchanged refresh events = %v, want none", got)
+	}
+	if entries, err := CheckAuditLog(auditLog, nil); err != nil || len(entries) != 1 {
+		t.Errorf("audit log = %d entries, %v, want the first generation", len(entries), err)
+	}
+	// The verified metadata of the first refresh is updated, not fetched again
+	if want := []string{"/2.root.json", "/timestamp.json"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("unchanged refresh requests = %v, want %v", requests, want)
+	}
+
+	mu.Lock()
+	updateTestRepository(t, current, "rekor.pub", "rotated rekor public key")
+	mu.Unlock()
+	watcher.Kube = &kubeClient{host: "http://127.0.0.1:0", client: http.DefaultClient}
+	if err := watcher.Refresh(); err == nil {
+		t.Fatalf("Refresh() applying to an unreachable cluster succeeded")
+	}
+	if got, want := webhook.take(), []string{EventApplyFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rotated refresh events = %v, want %v", got, want)
+	}
+	// Keys rotate once the generation rolling them out is applied, whatever
+	// the retries before it
+	if err := watcher.Refresh(); err == nil {
+		t.Fatalf("Refresh() applying to an unreachable cluster succeeded")
+	}
+	watcher.Kube = nil
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("rotated Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventApplyFailed, EventKeysRotated, EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("retried rotated refresh events = %v, want %v", got, want)
+	}
+
+	// Targets without keys or certificates rotate nothing
+	mu.Lock()
+	updateTestRepository(t, current, "artifact.txt", "new artifact")
+	mu.Unlock()
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("artifact Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("artifact refresh events = %v, want %v", got, want)
+	}
+}
+
+func TestIsKeyTarget(t *testing.T) {
+	usage := json.RawMessage(`{"sigstore":{"usage":"Fulcio","status":"Active"}}`)
+	tests := []struct {
+		name string
+		meta data.TargetFileMeta
+		want bool
+	}{
+		{"rekor.pub", data.TargetFileMeta{}, true},
+		{"tsa/tsa_root.crt.pem", data.TargetFileMeta{}, true},
+		{"trusted_root.json", data.TargetFileMeta{}, true},
+		{"fulcio_v1.crt", data.TargetFileMeta{Custom: &usage}, true},
+		{"artifact.txt", data.TargetFileMeta{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isKeyTarget(tt.name, tt.meta); got != tt.want {
+				t.Errorf("isKeyTarget(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherUpdateFailed(t *testing.T) {
+	defer func(source, checksum string) { rootSource, rootChecksum = source, checksum }(rootSource, rootChecksum)
+	rootSource, rootChecksum = "", ""
+	var mu sync.Mutex
+	original := newTestRepository(t)
+	current := original
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dir := current
+		mu.Unlock()
+		RepositoryHandler(dir).ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+	serve := func(dir string) {
+		mu.Lock()
+		current = dir
+		mu.Unlock()
+	}
+	webhook := newEventRecorder(t)
+	out := filepath.Join(t.TempDir(), "trustroot.yaml")
+	watcher := &Watcher{Mirror: mirror.URL, Name: "sigstore", Out: out, Notifiers: []Notifier{Webhook{URL: webhook.URL}}}
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("first Refresh() error = %v", err)
+	}
+	webhook.take()
+	trusted, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A mirror re-keyed out of band is not trusted on first use again
+	rekeyed := newTestRepository(t)
+	serve(rekeyed)
+	if err := watcher.Refresh(); err == nil {
+		t.Fatal("Refresh() of a re-keyed mirror succeeded")
+	}
+	if got, want := webhook.take(), []string{EventUpdateFailed}; !reflect.DeepEqual(got, want) {
+		t.Errorf("re-keyed refresh events = %v, want %v", got, want)
+	}
+	if got, _ := os.ReadFile(out); string(got) != string(trusted) {
+		t.Error("the TrustRoot of a re-keyed mirror was written")
+	}
+
+	// The last trusted state still updates once the mirror is restored
+	serve(original)
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("restored Refresh() error = %v", err)
+	}
+	if got := webhook.take(); len(got) != 0 {
+		t.Errorf("restored refresh events = %v, want none", got)
+	}
+
+	// A pinned root bootstraps the re-keyed mirror again
+	serve(rekeyed)
+	rootSource = rekeyed
+	if err := watcher.Refresh(); err != nil {
+		t.Fatalf("pinned Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventUpdateFailed, EventRootKeysRotated, EventKeysRotated, EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pinned refresh events = %v, want %v", got, want)
+	}
+}
+
+func TestWatcherRootKeysRotated(t *testing.T) {
+	var mu sync.Mutex
+	current := newTestRepository(t)
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		dir := current
+		mu.Unlock()
+		RepositoryHandler(dir).ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+	var applied []string
+	var event map[string]any
+	cluster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, r.URL.Path)
+		if strings.Contains(r.URL.Path, "/events/") {
+			if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+				t.Errorf("Failed to decode Event: %v", err)
+			}
+		}
+	}))
+	defer cluster.Close()
+	history := &History{Dir: t.TempDir(), Keep: 10}
+	webhook := newEventRecorder(t)
+	newWatcher := func() *Watcher {
+		return &Watcher{Mirror: mirror.URL, Name: "sigstore", Kube: &kubeClient{host: cluster.URL, namespace: "trustroot", client: cluster.Client()}, History: history, Notifiers: []Notifier{Webhook{URL: webhook.URL}}}
+	}
+
+	if err := newWatcher().Refresh(); err != nil {
+		t.Fatalf("first Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first refresh events = %v, want %v", got, want)
+	}
+
+	// A restarted watcher compares the root with the last generation in the history
+	mu.Lock()
+	current = newTestRepository(t)
+	applied = nil
+	mu.Unlock()
+	if err := newWatcher().Refresh(); err != nil {
+		t.Fatalf("rotated Refresh() error = %v", err)
+	}
+	if got, want := webhook.take(), []string{EventRootKeysRotated, EventGenerated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rotated refresh events = %v, want %v", got, want)
+	}
+	if len(applied) != 2 || !strings.HasPrefix(applied[0], "/api/v1/namespaces/trustroot/events/sigstore.") || applied[1] != trustRootPath("sigstore") {
+		t.Fatalf("applied = %v, want the Event and the TrustRoot", applied)
+	}
+	if event["type"] != "Warning" || event["reason"] != rootKeysRotatedReason || !strings.HasPrefix(event["message"].(string), "root keys rotated: added ") {
+		t.Errorf("unexpected Event %v", event)
+	}
+	if involved := event["involvedObject"].(map[string]any); involved["kind"] != "TrustRoot" || involved["name"] != "sigstore" {
+		t.Errorf("Event involvedObject = %v, want TrustRoot sigstore", involved)
+	}
+}
+
+func TestStateChanges(t *testing.T) {
+	tests := []struct {
+		name       string
+		oldRoot    string
+		newRoot    string
+		oldTargets map[string]string
+		newTargets map[string]string
+		want       []string
+	}{
+		{"unchanged", "r1", "r1", map[string]string{"a": "1"}, map[string]string{"a": "1"}, nil},
+		{"root rotated", "r1", "r2", map[string]string{"a": "1"}, map[string]string{"a": "1"}, []string{"root.json changed"}},
+		{"targets", "r1", "r1", map[string]string{"a": "1", "b": "2"}, map[string]string{"b": "3", "c": "4"}, []string{"removed a", "changed b", "added c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stateChanges(tt.oldRoot, tt.newRoot, tt.oldTargets, tt.newTargets); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stateChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherBackoff(t *testing.T) {
+	unavailable := fmt.Errorf("%w: %w", assembler.ErrMirrorUnreachable, &StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"})
+	throttled := fmt.Errorf("%w: %w", assembler.ErrMirrorUnreachable, &StatusError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests", RetryAfter: 45 * time.Minute})
+	tests := []struct {
+		name       string
+		maxBackoff time.Duration
+		failures   int
+		err        error
+		want       time.Duration
+	}{
+		{"first failure", 6 * time.Hour, 1, unavailable, time.Hour},
+		{"doubled", 6 * time.Hour, 3, unavailable, 4 * time.Hour},
+		{"capped", 6 * time.Hour, 10, unavailable, 6 * time.Hour},
+		{"cap below the interval", time.Minute, 3, unavailable, time.Hour},
+		{"retry-after shorter than the backoff", 6 * time.Hour, 1, throttled, time.Hour},
+		{"retry-after of a go-tuf download", 6 * time.Hour, 1, client.ErrDownloadFailed{File: "timestamp.json", Err: &StatusError{RetryAfter: 3 * time.Hour}}, 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			watcher := &Watcher{MaxBackoff: tt.maxBackoff}
+			if got := watcher.backoff(time.Hour, tt.failures, tt.err); got != tt.want {
+				t.Errorf("backoff() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherBackoffJitterCapped(t *testing.T) {
+	unavailable := &StatusError{StatusCode: http.StatusServiceUnavailable}
+	watcher := &Watcher{MaxBackoff: 6 * time.Hour, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		for failures := 1; failures <= 5; failures++ {
+			if got := watcher.backoff(time.Hour, failures, unavailable); got > watcher.MaxBackoff {
+				t.Fatalf("backoff() after %d failures = %s, want at most MaxBackoff %s", failures, got, watcher.MaxBackoff)
+			}
+		}
+	}
+}
+
+func TestWatcherBackoffRetryAfter(t *testing.T) {
+	// The backoff is shorter than the Retry-After when the interval is short
+	watcher := &Watcher{MaxBackoff: time.Hour}
+	throttled := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 10 * time.Minute}
+	if got := watcher.backoff(time.Minute, 1, throttled); got != 10*time.Minute {
+		t.Errorf("backoff() = %s, want the Retry-After of 10m", got)
+	}
+	throttled.RetryAfter = 24 * time.Hour
+	if got := watcher.backoff(time.Minute, 1, throttled); got != time.Hour {
+		t.Errorf("backoff() = %s, want the Retry-After capped at 1h", got)
+	}
+}
+
+func TestJittered(t *testing.T) {
+	if got := jittered(time.Hour, 0); got != time.Hour {
+		t.Errorf("jittered() without jitter = %s, want 1h", got)
+	}
+	spread := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		got := jittered(time.Hour, 0.1)
+		if got < 54*time.Minute || got > 66*time.Minute {
+			t.Fatalf("jittered() = %s, want within 10%% of 1h", got)
+		}
+		spread[got] = true
+	}
+	if len(spread) < 2 {
+		t.Errorf("jittered() returned the same delay 100 times")
+	}
+}
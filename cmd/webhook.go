@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Webhook event types.
+const (
+	// EventGenerated is sent when a new TrustRoot has been generated.
+	EventGenerated = "trustroot.generated"
+	// EventApplyFailed is sent when a generated TrustRoot could not be applied.
+	EventApplyFailed = "trustroot.apply-failed"
+	// EventKeysRotated is sent once a TrustRoot rolling out a changed root, or changed key or certificate targets, of the upstream repository is generated and applied.
+	EventKeysRotated = "upstream.keys-rotated"
+	// EventRootKeysRotated is sent when the keys or thresholds of the roles of the upstream root changed.
+	EventRootKeysRotated = "upstream.root-keys-rotated"
+	// EventUpdateFailed is sent when the verified metadata of the upstream repository could not be updated.
+	EventUpdateFailed = "upstream.update-failed"
+)
+
+// WebhookEvent is a notification about a refresh of a watched TrustRoot. It
+// is the body of generic JSON webhooks.
+type WebhookEvent struct {
+	Type      string    `json:"type"`
+	TrustRoot string    `json:"trustRoot"`
+	Mirror    string    `json:"mirror"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// Notifier is notified of the WebhookEvents of a Watcher: a Webhook, a
+// NATSNotifier or a KafkaNotifier.
+type Notifier interface {
+	Notify(event WebhookEvent) error
+}
+
+// Webhook is an HTTP endpoint notified of WebhookEvents.
+type Webhook struct {
+	URL string
+	// Slack sends the event as a Slack compatible {"text": ...} message
+	// instead of the WebhookEvent JSON.
+	Slack bool
+}
+
+// Notify POSTs event to the webhook.
+//
+// Parameters:
+//   - event: The event to send.
+//
+// Returns:
+//   - error: nil if the webhook answered with a 2xx status.
+func (w Webhook) Notify(event WebhookEvent) error {
+	var body any = event
+	if w.Slack {
+		body = struct {
+			Text string `json:"text"`
+		}{fmt.Sprintf("[%s] TrustRoot %s (%s): %s", event.Type, event.TrustRoot, event.Mirror, event.Message)}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(w.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", w.URL, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	event := WebhookEvent{Type: EventGenerated, TrustRoot: "sigstore", Mirror: "https://tuf.example.com", Message: "generated", Time: time.Unix(0, 0).UTC()}
+
+	tests := []struct {
+		name    string
+		slack   bool
+		status  int
+		want    string
+		wantErr bool
+	}{
+		{"generic", false, http.StatusOK, `{"type":"trustroot.generated","trustRoot":"sigstore","mirror":"https://tuf.example.com","message":"generated","time":"1970-01-01T00:00:00Z"}`, false},
+		{"slack", true, http.StatusOK, `{"text":"[trustroot.generated] TrustRoot sigstore (https://tuf.example.com): generated"}`, false},
+		{"failing webhook", false, http.StatusInternalServerError, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+				if got := r.Header.Get("Content-Type"); got != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", got)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := Webhook{URL: server.URL, Slack: tt.slack}.Notify(event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want != "" && strings.TrimSpace(string(body)) != tt.want {
+				t.Errorf("body = %s, want %s", body, tt.want)
+			}
+			if !json.Valid(body) {
+				t.Errorf("body is not JSON: %s", body)
+			}
+		})
+	}
+}
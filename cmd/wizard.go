@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Apply targets of the interactive wizard.
+const (
+	applyStdout  = "stdout"
+	applyFile    = "file"
+	applyCluster = "cluster"
+)
+
+// WizardAnswers are the choices made in the interactive wizard.
+type WizardAnswers struct {
+	Mirror       string
+	NameStrategy string
+	Output       string
+	// Apply is where the output goes: applyStdout, applyFile or applyCluster.
+	Apply string
+	// File is the output file of applyFile.
+	File string
+}
+
+// Args returns the flags of the non-interactive command equivalent to the
+// answers, defaults omitted.
+func (a WizardAnswers) Args() []string {
+	var args []string
+	if a.Mirror != mirrorPresets["public-good"] {
+		args = append(args, "--mirror", a.Mirror)
+	}
+	if a.NameStrategy != nameStrategyTimestamp {
+		args = append(args, "--name-strategy", a.NameStrategy)
+	}
+	if a.Output != outputTrustRoot {
+		args = append(args, "--output", a.Output)
+	}
+	return args
+}
+
+// Command returns the shell command equivalent to the answers, for reuse in
+// automation.
+func (a WizardAnswers) Command(program string) string {
+	words := []string{shellQuote(program)}
+	for _, arg := range a.Args() {
+		words = append(words, shellQuote(arg))
+	}
+	command := strings.Join(words, " ")
+	switch a.Apply {
+	case applyFile:
+		return command + " > " + shellQuote(a.File)
+	case applyCluster:
+		return command + " | kubectl apply -f -"
+	}
+	return command
+}
+
+// shellQuote quotes word for a POSIX shell if it needs to be.
+func shellQuote(word string) string {
+	if word != "" && strings.Trim(word, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@") == "" {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// RunWizard walks a first-time user through mirror selection, naming, output
+// format and apply target.
+//
+// Parameters:
+//   - in: The answers of the user, one per line.
+//   - out: Where the questions are written, typically stderr.
+//
+// Returns:
+//   - The answers.
+//   - An error if in ended before every question was answered.
+func RunWizard(in io.Reader, out io.Writer) (WizardAnswers, error) {
+	scanner := bufio.NewScanner(in)
+	var answers WizardAnswers
+
+	presets := make([]string, 0, len(mirrorPresets))
+	for preset := range mirrorPresets {
+		presets = append(presets, preset)
+	}
+	sort.Strings(presets)
+	mirrors := make([]string, 0, len(presets)+1)
+	for _, preset := range presets {
+		mirrors = append(mirrors, fmt.Sprintf("%s (%s)", preset, mirrorPresets[preset]))
+	}
+	mirror, err := choose(scanner, out, "TUF repository mirror", append(mirrors, "another mirror"), 0)
+	if err != nil {
+		return answers, err
+	}
+	if mirror < len(presets) {
+		answers.Mirror = mirrorPresets[presets[mirror]]
+	} else if answers.Mirror, err = ask(scanner, out, "Mirror URL, or s3://, gs://, oci:// or file:// source", ""); err != nil {
+		return answers, err
+	}
+
+	strategies := []string{nameStrategyTimestamp, nameStrategyDigest}
+	strategy, err := choose(scanner, out, "TrustRoot naming", []string{
+		"timestamp (<mirror>-<unix time>, a new name every run)",
+		"digest (<mirror>-<snapshot version>-<content digest>, stable while the repository is unchanged)",
+	}, 0)
+	if err != nil {
+		return answers, err
+	}
+	answers.NameStrategy = strategies[strategy]
+
+	renderersMu.RLock()
+	formats := rendererNames()
+	renderersMu.RUnlock()
+	defaultFormat := sort.SearchStrings(formats, outputTrustRoot)
+	format, err := choose(scanner, out, "Output format", formats, defaultFormat)
+	if err != nil {
+		return answers, err
+	}
+	answers.Output = formats[format]
+
+	targets := []string{applyStdout, applyFile, applyCluster}
+	target, err := choose(scanner, out, "Apply target", []string{
+		"stdout",
+		"file",
+		"cluster (kubectl apply to the current context)",
+	}, 0)
+	if err != nil {
+		return answers, err
+	}
+	answers.Apply = targets[target]
+	if answers.Apply == applyFile {
+		if answers.File, err = ask(scanner, out, "Output file", answers.Output+".yaml"); err != nil {
+			return answers, err
+		}
+	}
+	return answers, nil
+}
+
+// ask asks question until it gets a non-empty answer, def if the answer is
+// empty and def is set.
+func ask(scanner *bufio.Scanner, out io.Writer, question, def string) (string, error) {
+	for {
+		if def != "" {
+			fmt.Fprintf(out, "%s [%s]: ", question, def)
+		} else {
+			fmt.Fprintf(out, "%s: ", question)
+		}
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("no answer to %q", question)
+		}
+		answer := strings.TrimSpace(scanner.Text())
+		if answer == "" {
+			answer = def
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// choose asks question until one of the numbered choices is picked, and
+// returns its index, def for an empty answer.
+func choose(scanner *bufio.Scanner, out io.Writer, question string, choices []string, def int) (int, error) {
+	fmt.Fprintf(out, "%s:\n", question)
+	for i, choice := range choices {
+		fmt.Fprintf(out, "  %d) %s\n", i+1, choice)
+	}
+	for {
+		answer, err := ask(scanner, out, "Choice", strconv.Itoa(def+1))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(choices) {
+			return n - 1, nil
+		}
+		fmt.Fprintf(out, "Please answer a number between 1 and %d\n", len(choices))
+	}
+}
+
+// runInteractive runs the wizard on the terminal, prints the equivalent
+// command and runs it, returning its exit code.
+func runInteractive() int {
+	answers, err := RunWizard(os.Stdin, os.Stderr)
+	if err != nil {
+		log.Printf("Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "\nEquivalent command, for automation:\n\n  %s\n\n", answers.Command(os.Args[0]))
+
+	self, err := os.Executable()
+	if err != nil {
+		log.Printf("Error: could not find the executable: %v\n", err)
+		return 1
+	}
+	assemble := exec.Command(self, answers.Args()...)
+	assemble.Stderr = os.Stderr
+	var apply *exec.Cmd
+	switch answers.Apply {
+	case applyFile:
+		file, err := os.Create(answers.File)
+		if err != nil {
+			log.Printf("Error: could not create %s: %v\n", answers.File, err)
+			return 1
+		}
+		defer file.Close()
+		assemble.Stdout = file
+	case applyCluster:
+		apply = exec.Command("kubectl", "apply", "-f", "-")
+		apply.Stdout, apply.Stderr = os.Stdout, os.Stderr
+		if apply.Stdin, err = assemble.StdoutPipe(); err != nil {
+			log.Printf("Error: %v\n", err)
+			return 1
+		}
+	default:
+		assemble.Stdout = os.Stdout
+	}
+	if err := assemble.Start(); err != nil {
+		log.Printf("Error: could not run %s: %v\n", self, err)
+		return 1
+	}
+	if apply != nil {
+		if err := apply.Run(); err != nil {
+			log.Printf("Error: kubectl apply failed: %v\n", err)
+			assemble.Process.Kill()
+			assemble.Wait()
+			return 1
+		}
+	}
+	if err := assemble.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRunWizard(t *testing.T) {
+	// Other tests may register renderers
+	renderersMu.RLock()
+	formats := rendererNames()
+	renderersMu.RUnlock()
+	formatChoice := func(format string) string {
+		for i, name := range formats {
+			if name == format {
+				return strconv.Itoa(i + 1)
+			}
+		}
+		t.Fatalf("format %s is not registered", format)
+		return ""
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		want        WizardAnswers
+		wantCommand string
+		wantErr     bool
+	}{
+		{
+			name:        "defaults",
+			input:       "\n\n\n\n",
+			want:        WizardAnswers{Mirror: "https://tuf-repo-cdn.sigstore.dev", NameStrategy: nameStrategyTimestamp, Output: outputTrustRoot, Apply: applyStdout},
+			wantCommand: "trustrootassembler",
+		},
+		{
+			name:        "staging to a file",
+			input:       "2\n2\n" + formatChoice(outputSecret) + "\n2\n\n",
+			want:        WizardAnswers{Mirror: "https://tuf-repo-cdn.sigstage.dev", NameStrategy: nameStrategyDigest, Output: outputSecret, Apply: applyFile, File: "secret.yaml"},
+			wantCommand: "trustrootassembler --mirror https://tuf-repo-cdn.sigstage.dev --name-strategy digest --output secret > secret.yaml",
+		},
+		{
+			name:        "custom mirror to the cluster",
+			input:       "3\ns3://my bucket/tuf\n\n7\n" + formatChoice(outputConfigMap) + "\n3\n",
+			want:        WizardAnswers{Mirror: "s3://my bucket/tuf", NameStrategy: nameStrategyTimestamp, Output: outputConfigMap, Apply: applyCluster},
+			wantCommand: "trustrootassembler --mirror 's3://my bucket/tuf' --output configmap | kubectl apply -f -",
+		},
+		{
+			name:    "interrupted",
+			input:   "1\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RunWizard(strings.NewReader(tt.input), io.Discard)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RunWizard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RunWizard() = %+v, want %+v", got, tt.want)
+			}
+			if command := got.Command("trustrootassembler"); command != tt.wantCommand {
+				t.Errorf("Command() = %q, want %q", command, tt.wantCommand)
+			}
+		})
+	}
+}
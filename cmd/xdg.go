@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// appDirName is the directory of the tool under the XDG base directories.
+const appDirName = "trustroot-assembler"
+
+// CacheDir returns the cache directory of the tool, where temporary working
+// directories, spilled outputs and staging directories are created:
+// $TRUSTROOT_ASSEMBLER_CACHE_DIR, or else trustroot-assembler under
+// $XDG_CACHE_HOME. Relative paths are ignored, as the XDG Base Directory
+// Specification requires.
+//
+// Returns:
+//   - The absolute cache directory, "" if neither variable is set.
+func CacheDir() string {
+	return baseDir("TRUSTROOT_ASSEMBLER_CACHE_DIR", "XDG_CACHE_HOME")
+}
+
+// StateDir returns the state directory of the tool, where the history of
+// generated TrustRoots is kept: $TRUSTROOT_ASSEMBLER_STATE_DIR, or else
+// trustroot-assembler under $XDG_STATE_HOME. Relative paths are ignored.
+//
+// Returns:
+//   - The absolute state directory, "" if neither variable is set.
+func StateDir() string {
+	return baseDir("TRUSTROOT_ASSEMBLER_STATE_DIR", "XDG_STATE_HOME")
+}
+
+// baseDir returns the absolute directory of the override variable, or else
+// appDirName under the XDG variable, "" if neither is set to an absolute path.
+func baseDir(override, xdg string) string {
+	if dir := os.Getenv(override); filepath.IsAbs(dir) {
+		return dir
+	}
+	if dir := os.Getenv(xdg); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appDirName)
+	}
+	return ""
+}
+
+// workDirUsage is the usage of the --work-dir flag of every command.
+const workDirUsage = "Directory temporary working directories and files are created in, e.g. the writable emptyDir of a read-only container, instead of the XDG cache directory or TMPDIR"
+
+// workDirBase is the directory of --work-dir, "" for the default of tempDir.
+var workDirBase string
+
+// tempDir returns the directory temporary files are created in: --work-dir,
+// or else CacheDir, created if missing, or else os.TempDir. HOME is never
+// used, so runs of different users of a shared build agent can be kept
+// apart with XDG_CACHE_HOME alone, and read-only containers only need
+// --work-dir writable.
+func tempDir() string {
+	dir := workDirBase
+	if dir == "" {
+		dir = CacheDir()
+	}
+	if dir == "" {
+		return os.TempDir()
+	}
+	// A failure surfaces when the temporary file is created
+	os.MkdirAll(dir, 0o700)
+	return dir
+}
+
+// defaultHistoryDir returns the default of --history-dir: history under
+// StateDir, "" to keep no history without a state directory.
+func defaultHistoryDir() string {
+	if dir := StateDir(); dir != "" {
+		return filepath.Join(dir, "history")
+	}
+	return ""
+}
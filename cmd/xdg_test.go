@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cmd/mockmirror"
+)
+
+func TestBaseDirs(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantCache string
+		wantState string
+	}{
+		{name: "unset"},
+		{name: "xdg", env: map[string]string{"XDG_CACHE_HOME": "/var/cache/ci", "XDG_STATE_HOME": "/var/lib/ci"}, wantCache: "/var/cache/ci/trustroot-assembler", wantState: "/var/lib/ci/trustroot-assembler"},
+		{name: "overrides", env: map[string]string{"XDG_CACHE_HOME": "/var/cache/ci", "TRUSTROOT_ASSEMBLER_CACHE_DIR": "/cache", "TRUSTROOT_ASSEMBLER_STATE_DIR": "/state"}, wantCache: "/cache", wantState: "/state"},
+		{name: "relative paths are ignored", env: map[string]string{"XDG_CACHE_HOME": "cache", "TRUSTROOT_ASSEMBLER_STATE_DIR": "state"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"XDG_CACHE_HOME", "XDG_STATE_HOME", "TRUSTROOT_ASSEMBLER_CACHE_DIR", "TRUSTROOT_ASSEMBLER_STATE_DIR"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := CacheDir(); got != tt.wantCache {
+				t.Errorf("CacheDir() = %q, want %q", got, tt.wantCache)
+			}
+			if got := StateDir(); got != tt.wantState {
+				t.Errorf("StateDir() = %q, want %q", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestTempDir(t *testing.T) {
+	t.Setenv("TRUSTROOT_ASSEMBLER_CACHE_DIR", "")
+	t.Setenv("XDG_CACHE_HOME", "")
+	if got := tempDir(); got != os.TempDir() {
+		t.Errorf("tempDir() = %q, want %q without a cache directory", got, os.TempDir())
+	}
+
+	cacheHome := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", cacheHome)
+	dir, err := mkdirTemp("tuf-repository-*")
+	if err != nil {
+		t.Fatalf("mkdirTemp() error = %v", err)
+	}
+	if filepath.Dir(dir) != filepath.Join(cacheHome, appDirName) {
+		t.Errorf("mkdirTemp() = %q, want it in %s", dir, filepath.Join(cacheHome, appDirName))
+	}
+}
+
+// TestHardenedContainer assembles a repository the way a hardened Kubernetes
+// Job runs the tool: no HOME, no writable temporary directory and a single
+// writable emptyDir given as --work-dir.
+func TestHardenedContainer(t *testing.T) {
+	defer func(dir string) { workDirBase = dir }(workDirBase)
+	for _, name := range []string{"HOME", "XDG_CACHE_HOME", "XDG_STATE_HOME", "TRUSTROOT_ASSEMBLER_CACHE_DIR", "TRUSTROOT_ASSEMBLER_STATE_DIR"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("TMPDIR", filepath.Join(t.TempDir(), "missing"))
+	workDirBase = t.TempDir()
+	server := mockmirror.NewServer()
+	defer server.Close()
+
+	workDir, err := mkdirTemp("tuf-repository-*")
+	if err != nil {
+		t.Fatalf("mkdirTemp() error = %v", err)
+	}
+	defer os.RemoveAll(workDir)
+	rootJSONFile, err := AssembleRepository(server.URL, workDir)
+	if err != nil {
+		t.Fatalf("AssembleRepository() error = %v", err)
+	}
+	defer rootJSONFile.Close()
+	if err := verifyArchive(workDir, rootJSONFile.Name()); err != nil {
+		t.Errorf("verifyArchive() error = %v", err)
+	}
+	output := newSpillBuffer(1)
+	defer output.Close()
+	if _, err := output.Write([]byte("spilled")); err != nil || !output.Spilled() {
+		t.Errorf("spillBuffer.Write() error = %v, spilled %v", err, output.Spilled())
+	}
+	if defaultHistoryDir() != "" {
+		t.Errorf("defaultHistoryDir() = %q, want no history without a state directory", defaultHistoryDir())
+	}
+}
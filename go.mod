@@ -2,20 +2,17 @@ module cmd
 
 go 1.22.5
 
+require (
+	github.com/klauspost/compress v1.16.5
+	github.com/klauspost/pgzip v1.2.6
+	github.com/theupdateframework/go-tuf v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/golang/snappy v0.0.4 // indirect
-	github.com/google/go-containerregistry v0.19.0 // indirect
-	github.com/letsencrypt/boulder v0.0.0-20230907030200-6d76a0f91e1e // indirect
-	github.com/opencontainers/go-digest v1.0.0 // indirect
 	github.com/secure-systems-lab/go-securesystemslib v0.8.0 // indirect
-	github.com/sigstore/cosign v1.13.6 // indirect
-	github.com/sigstore/sigstore v1.8.0 // indirect
 	github.com/syndtr/goleveldb v1.0.1-0.20220721030215-126854af5e6d // indirect
-	github.com/theupdateframework/go-tuf v0.7.0 // indirect
-	github.com/titanous/rocacheck v0.0.0-20171023193734-afe73141d399 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
-	golang.org/x/term v0.18.0 // indirect
-	gopkg.in/go-jose/go-jose.v2 v2.6.1 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,82 @@
+// Command gen regenerates the fixture repository of package mockmirror:
+//
+//	go generate ./mockmirror
+//
+// Every run creates new keys, so the fixture only changes when regenerated.
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/theupdateframework/go-tuf"
+)
+
+// expires is far enough in the future for the fixture to never expire.
+var expires = time.Date(2100, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// targets are the targets of the fixture repository, with the names used by
+// the Sigstore public good instance.
+var targets = map[string]string{
+	"rekor.pub":         "-----BEGIN PUBLIC KEY-----\nmockmirror rekor public key\n-----END PUBLIC KEY-----\n",
+	"ctfe.pub":          "-----BEGIN PUBLIC KEY-----\nmockmirror ctfe public key\n-----END PUBLIC KEY-----\n",
+	"fulcio.crt.pem":    "-----BEGIN CERTIFICATE-----\nmockmirror fulcio certificate\n-----END CERTIFICATE-----\n",
+	"trusted_root.json": `{"mediaType": "application/vnd.dev.sigstore.trustedroot+json;version=0.1"}` + "\n",
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("Usage: %s <output directory>", os.Args[0])
+	}
+	out := os.Args[1]
+	dir, err := os.MkdirTemp("", "mockmirror-*")
+	if err != nil {
+		log.Fatalf("Error: could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := tuf.NewRepo(tuf.FileSystemStore(dir, nil))
+	if err != nil {
+		log.Fatalf("Error: could not create repository: %v", err)
+	}
+	if err := repo.Init(true); err != nil {
+		log.Fatalf("Error: could not init repository: %v", err)
+	}
+	for _, role := range []string{"root", "targets", "snapshot", "timestamp"} {
+		if _, err := repo.GenKeyWithExpires(role, expires); err != nil {
+			log.Fatalf("Error: could not generate %s key: %v", role, err)
+		}
+	}
+	names := make([]string, 0, len(targets))
+	for name, content := range targets {
+		p := filepath.Join(dir, "staged", "targets", name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			log.Fatalf("Error: could not create targets directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			log.Fatalf("Error: could not write target %s: %v", name, err)
+		}
+		names = append(names, name)
+	}
+	if err := repo.AddTargetsWithExpires(names, nil, expires); err != nil {
+		log.Fatalf("Error: could not add targets: %v", err)
+	}
+	if err := repo.SnapshotWithExpires(expires); err != nil {
+		log.Fatalf("Error: could not snapshot repository: %v", err)
+	}
+	if err := repo.TimestampWithExpires(expires); err != nil {
+		log.Fatalf("Error: could not timestamp repository: %v", err)
+	}
+	if err := repo.Commit(); err != nil {
+		log.Fatalf("Error: could not commit repository: %v", err)
+	}
+
+	if err := os.RemoveAll(out); err != nil {
+		log.Fatalf("Error: could not remove %s: %v", out, err)
+	}
+	if err := os.Rename(filepath.Join(dir, "repository"), out); err != nil {
+		log.Fatalf("Error: could not move the repository to %s: %v", out, err)
+	}
+}
@@ -0,0 +1,61 @@
+// Package mockmirror serves a small, fully signed TUF repository laid out like
+// the Sigstore public good mirror, so tests assembling TrustRoots do not
+// depend on the live Sigstore CDN.
+//
+// Example usage:
+//
+//	server := mockmirror.NewServer()
+//	defer server.Close()
+//	a, err := assembler.New(assembler.WithMirror(server.URL))
+package mockmirror
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+)
+
+//go:generate go run ./internal/gen repository
+
+// repository is the fixture, a consistent snapshot repository whose metadata
+// expires in 2100.
+//
+//go:embed repository
+var repository embed.FS
+
+// Targets are the names of the targets of the fixture repository.
+var Targets = []string{"ctfe.pub", "fulcio.crt.pem", "rekor.pub", "trusted_root.json"}
+
+// FS returns the fixture repository: N.root.json, N.snapshot.json,
+// N.targets.json, timestamp.json and the targets in targets/ under their
+// consistent snapshot names.
+func FS() fs.FS {
+	fsys, err := fs.Sub(repository, "repository")
+	if err != nil {
+		panic(err)
+	}
+	return fsys
+}
+
+// RootJSON returns the initial root.json of the fixture repository, to
+// bootstrap TUF clients with.
+func RootJSON() []byte {
+	rootJSON, err := fs.ReadFile(FS(), "1.root.json")
+	if err != nil {
+		panic(err)
+	}
+	return rootJSON
+}
+
+// Handler returns a handler serving the fixture repository with directory
+// listings, like a mirror behind a plain web server.
+func Handler() http.Handler {
+	return http.FileServer(http.FS(FS()))
+}
+
+// NewServer starts an httptest.Server serving the fixture repository. The
+// caller must Close it.
+func NewServer() *httptest.Server {
+	return httptest.NewServer(Handler())
+}
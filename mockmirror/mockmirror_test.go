@@ -0,0 +1,58 @@
+package mockmirror
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/theupdateframework/go-tuf/client"
+)
+
+// discardDestination is a client.Destination dropping what it is written.
+type discardDestination struct{}
+
+func (discardDestination) Write(p []byte) (int, error) { return len(p), nil }
+func (discardDestination) Delete() error               { return nil }
+
+func TestNewServer(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	remote, err := client.HTTPRemoteStore(server.URL, nil, server.Client())
+	if err != nil {
+		t.Fatalf("HTTPRemoteStore() error = %v", err)
+	}
+	c := client.NewClient(client.MemoryLocalStore(), remote)
+	if err := c.Init(RootJSON()); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	targets, err := c.Update()
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	var names []string
+	for name := range targets {
+		if err := c.Download(name, discardDestination{}); err != nil {
+			t.Errorf("Download(%s) error = %v", name, err)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) != len(Targets) {
+		t.Fatalf("targets = %v, want %v", names, Targets)
+	}
+	for i := range names {
+		if names[i] != Targets[i] {
+			t.Errorf("targets = %v, want %v", names, Targets)
+		}
+	}
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET / error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET / = %s, want a directory listing", resp.Status)
+	}
+}
@@ -0,0 +1,60 @@
+// Package sigstorego loads the trust material of the TrustRoot Assembler into
+// a sigstore-go root.TrustedMaterial, for verification services written in Go
+// consuming the assembled repository without round-tripping through files.
+// It is a module of its own, so programs embedding package assembler without
+// verifying signatures do not depend on sigstore-go.
+//
+// Example usage:
+//
+//	a, err := assembler.New(assembler.WithMirror("https://tuf-repo-cdn.sigstore.dev"))
+//	if err != nil {
+//	    log.Fatalf("Error: %v", err)
+//	}
+//	trustedMaterial, err := sigstorego.TrustedMaterial(a)
+//	if err != nil {
+//	    log.Fatalf("Error: %v", err)
+//	}
+//	verifier, err := verify.NewSignedEntityVerifier(trustedMaterial, verify.WithTransparencyLog(1))
+package sigstorego
+
+import (
+	"fmt"
+
+	"cmd/assembler"
+
+	"github.com/sigstore/sigstore-go/pkg/root"
+)
+
+// TrustedMaterial assembles and verifies the repository of the mirror of a
+// and loads its verified trusted_root.json target.
+//
+// Parameters:
+//   - a: The Assembler of the mirror.
+//
+// Returns:
+//   - The trust material of the repository.
+//   - An error wrapping ErrVerification if the repository has no loadable trusted_root.json target, or the error of Assembler.TrustedRoot.
+func TrustedMaterial(a *assembler.Assembler) (root.TrustedMaterial, error) {
+	trustedRootJSON, err := a.TrustedRoot()
+	if err != nil {
+		return nil, err
+	}
+	return TrustedMaterialFromJSON(trustedRootJSON)
+}
+
+// TrustedMaterialFromJSON loads a trusted_root.json document, e.g. returned
+// by Assembler.TrustedRoot or printed by `--output trusted-root`.
+//
+// Parameters:
+//   - trustedRootJSON: The trusted_root.json document.
+//
+// Returns:
+//   - The trust material of the document.
+//   - An error wrapping ErrVerification if sigstore-go cannot load it.
+func TrustedMaterialFromJSON(trustedRootJSON []byte) (root.TrustedMaterial, error) {
+	trustedRoot, err := root.NewTrustedRootFromJSON(trustedRootJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not load trusted_root.json: %v", assembler.ErrVerification, err)
+	}
+	return trustedRoot, nil
+}
@@ -0,0 +1,154 @@
+package sigstorego
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cmd/assembler"
+	"cmd/mockmirror"
+
+	"github.com/sigstore/sigstore-go/pkg/root"
+	"github.com/theupdateframework/go-tuf"
+)
+
+// testTrustedRootJSON returns a trusted_root.json document with a Rekor log
+// of a new key, and the hex log ID of the log.
+func testTrustedRootJSON(t *testing.T) ([]byte, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logID := sha256.Sum256(der)
+	return []byte(fmt.Sprintf(`{
+  "mediaType": "application/vnd.dev.sigstore.trustedroot+json;version=0.1",
+  "tlogs": [{
+    "baseUrl": "https://rekor.example.com",
+    "hashAlgorithm": "SHA2_256",
+    "publicKey": {"rawBytes": %q, "keyDetails": "PKIX_ECDSA_P256_SHA_256", "validFor": {"start": "2024-01-01T00:00:00Z"}},
+    "logId": {"keyId": %q}
+  }]
+}`, base64.StdEncoding.EncodeToString(der), base64.StdEncoding.EncodeToString(logID[:]))), hex.EncodeToString(logID[:])
+}
+
+// newTestMirror serves a signed TUF repository of targets, by name.
+func newTestMirror(t *testing.T, targets map[string][]byte) *httptest.Server {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := tuf.NewRepo(tuf.FileSystemStore(dir, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Init(true); err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().AddDate(1, 0, 0)
+	for _, role := range []string{"root", "targets", "snapshot", "timestamp"} {
+		if _, err := repo.GenKeyWithExpires(role, expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := make([]string, 0, len(targets))
+	for name, content := range targets {
+		if err := os.MkdirAll(filepath.Join(dir, "staged", "targets"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "staged", "targets", name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := repo.AddTargetsWithExpires(names, nil, expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SnapshotWithExpires(expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.TimestampWithExpires(expires); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(dir, "repository"))))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTrustedMaterial(t *testing.T) {
+	trustedRootJSON, logID := testTrustedRootJSON(t)
+	fixture := mockmirror.NewServer()
+	defer fixture.Close()
+
+	tests := []struct {
+		name     string
+		server   *httptest.Server
+		wantLogs []string
+		wantErr  error
+	}{
+		{name: "trusted root with a Rekor log", server: newTestMirror(t, map[string][]byte{"trusted_root.json": trustedRootJSON}), wantLogs: []string{logID}},
+		{name: "empty trusted root", server: fixture},
+		{name: "repository without trusted root", server: newTestMirror(t, map[string][]byte{"rekor.pub": []byte("rekor")}), wantErr: assembler.ErrVerification},
+		{name: "malformed trusted root", server: newTestMirror(t, map[string][]byte{"trusted_root.json": []byte("{")}), wantErr: assembler.ErrVerification},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := assembler.New(assembler.WithMirror(tt.server.URL), assembler.WithHTTPClient(tt.server.Client()))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			trustedMaterial, err := TrustedMaterial(a)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("TrustedMaterial() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			logs := trustedMaterial.RekorLogs()
+			if len(logs) != len(tt.wantLogs) {
+				t.Fatalf("TrustedMaterial() has %d Rekor logs, want %d", len(logs), len(tt.wantLogs))
+			}
+			for _, id := range tt.wantLogs {
+				if logs[id] == nil || logs[id].BaseURL != "https://rekor.example.com" {
+					t.Errorf("TrustedMaterial() Rekor log %s = %+v", id, logs[id])
+				}
+			}
+		})
+	}
+}
+
+func TestTrustedMaterialFromJSON(t *testing.T) {
+	trustedRootJSON, _ := testTrustedRootJSON(t)
+	trustedMaterial, err := TrustedMaterialFromJSON(trustedRootJSON)
+	if err != nil {
+		t.Fatalf("TrustedMaterialFromJSON() error = %v", err)
+	}
+	// The material is the root.TrustedRoot sigstore-go parses itself
+	want, err := root.NewTrustedRootFromJSON(trustedRootJSON)
+	if err != nil {
+		t.Fatalf("NewTrustedRootFromJSON() error = %v", err)
+	}
+	if len(trustedMaterial.RekorLogs()) != len(want.RekorLogs()) {
+		t.Errorf("TrustedMaterialFromJSON() Rekor logs = %v, want %v", trustedMaterial.RekorLogs(), want.RekorLogs())
+	}
+	if _, err := TrustedMaterialFromJSON([]byte(`{"mediaType": "application/json"}`)); !errors.Is(err, assembler.ErrVerification) {
+		t.Errorf("TrustedMaterialFromJSON() of another media type error = %v, want %v", err, assembler.ErrVerification)
+	}
+}